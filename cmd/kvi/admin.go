@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/client"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// jobPollInterval is how often compact --url checks on the server's job.
+const jobPollInterval = 500 * time.Millisecond
+
+func runStats(args []string) error {
+	fs := newFlagSet("stats", "[flags]", "Print engine and runtime statistics as JSON: the server's GET /api/v1/stats\n"+
+		"report with --url, else the same report for the data directory.\n"+remoteNote)
+	ef := addEngineFlags(fs)
+	rf := addRemoteFlags(fs)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return &usageError{fs: fs, msg: "unexpected arguments " + strings.Join(fs.Args(), " ")}
+	}
+	if err := rf.check(fs); err != nil {
+		return err
+	}
+	if rf.remote() {
+		c, err := rf.client()
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		report, err := c.Stats(context.Background())
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, report, "", "  "); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		_, err = buf.WriteTo(os.Stdout)
+		return err
+	}
+
+	started := time.Now()
+	eng, err := ef.open()
+	if err != nil {
+		return err
+	}
+	defer eng.Close()
+	return writeJSON(os.Stdout, stats.Collect(eng, nil, started))
+}
+
+func runCompact(args []string) error {
+	fs := newFlagSet("compact", "[flags]", "Compact the engine's storage, reclaiming space held by overwritten and\n"+
+		"deleted records. With --url the server runs it as an admin job, which this\n"+
+		"command follows to the end.\n"+remoteNote)
+	ef := addEngineFlags(fs)
+	rf := addRemoteFlags(fs)
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return &usageError{fs: fs, msg: "unexpected arguments " + strings.Join(fs.Args(), " ")}
+	}
+	if err := rf.check(fs); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if rf.remote() {
+		c, err := rf.client()
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		job, err := c.StartMaintenance(ctx, types.MaintenanceCompact)
+		if err != nil {
+			return err
+		}
+		log.Printf("Started compact job %s on %s", job.ID, rf.url)
+		var last string
+		job, err = c.WaitJob(ctx, job.ID, jobPollInterval, func(j *client.Job) {
+			if p := progressText(j.Done, j.Total); p != "" && p != last {
+				log.Printf("Compacting: %s", p)
+				last = p
+			}
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("Compacted in %s", job.FinishedAt.Sub(job.StartedAt).Round(time.Millisecond))
+		return nil
+	}
+
+	cfg, err := ef.load()
+	if err != nil {
+		return err
+	}
+	eng, err := kvi.Open(cfg)
+	if err != nil {
+		return err
+	}
+	task := maintenanceTask(eng, types.MaintenanceCompact)
+	if task == nil {
+		eng.Close()
+		return fmt.Errorf("%s mode has no storage to compact", cfg.Mode)
+	}
+	started := time.Now()
+	var last string
+	err = task(ctx, func(done, total int) {
+		if p := progressText(done, total); p != "" && p != last {
+			log.Printf("Compacting: %s", p)
+			last = p
+		}
+	})
+	if closeErr := eng.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Compacted in %s", time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// maintenanceTask returns the engine's task op, or nil if it has none.
+func maintenanceTask(eng types.Engine, op string) types.MaintenanceFunc {
+	m, ok := eng.(types.Maintainer)
+	if !ok {
+		return nil
+	}
+	return m.Maintenance()[op]
+}
+
+func progressText(done, total int) string {
+	if total <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d (%d%%)", done, total, done*100/total)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/thirawat27/kvi/internal/bench"
+	"github.com/thirawat27/kvi/pkg/client"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func runBench(args []string) error {
+	fs := newFlagSet("bench", "[flags]", "Run a synthetic workload and report throughput, latency percentiles and errors.\n"+
+		"Without --url or --grpc it drives an embedded engine; in disk and hybrid modes\n"+
+		"that uses a temporary directory unless --dir is given.")
+	ef := addEngineFlags(fs)
+	o := bench.DefaultOptions()
+	workload := fs.String("workload", string(o.Workload), "Preset: write | read | mixed (95% reads) | scan | vector")
+	fs.IntVar(&o.Keys, "keys", o.Keys, "Keyspace size, loaded before the timed run except for write")
+	fs.IntVar(&o.ValueSize, "value-size", o.ValueSize, "Payload bytes per record")
+	fs.IntVar(&o.Concurrency, "concurrency", o.Concurrency, "Concurrent workers")
+	fs.DurationVar(&o.Duration, "duration", o.Duration, "Length of the timed run")
+	fs.Uint64Var(&o.Seed, "seed", o.Seed, "Seed of the key choice and the synthetic embeddings")
+	fs.Float64Var(&o.Zipf, "zipf", o.Zipf, "Zipf exponent (> 1) of the key choice, so a few keys are hot; 0 is uniform")
+	fs.IntVar(&o.Dim, "dim", o.Dim, "Vector dimension (vector)")
+	fs.IntVar(&o.Clusters, "clusters", o.Clusters, "Clusters the embeddings are grouped in (vector)")
+	fs.Float64Var(&o.Spread, "spread", o.Spread, "Per-coordinate noise around each cluster centre; larger is harder (vector)")
+	fs.IntVar(&o.K, "k", o.K, "Neighbours per search (vector)")
+	fs.IntVar(&o.RecallQueries, "recall-queries", o.RecallQueries, "Searches checked against an exact scan for recall, 0 to skip (vector)")
+	fs.Float64Var(&o.Selectivity, "selectivity", o.Selectivity, "Filter each search to this percentage of the records by a tenant field, 0 for none (vector)")
+	fs.BoolVar(&o.FilterPushdown, "filter-pushdown", o.FilterPushdown, "Keep posting lists for the tenant field; false checks the records each search finds instead (vector)")
+	url := fs.String("url", "", "Benchmark the server with this REST API root, e.g. http://localhost:8080")
+	grpcTarget := fs.String("grpc", "", "Benchmark the server with this gRPC address, e.g. localhost:50051")
+	apiKey := fs.String("api-key", "", "API key for a server with authentication")
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	o.Workload = bench.Workload(*workload)
+	if fs.NArg() > 0 {
+		return &usageError{fs: fs, msg: "unexpected arguments " + strings.Join(fs.Args(), " ")}
+	}
+	if err := o.Validate(); err != nil {
+		return &usageError{fs: fs, msg: err.Error()}
+	}
+	remote := *url != "" || *grpcTarget != ""
+	if remote && o.Workload == bench.Vector {
+		// The APIs decode vectors as JSON numbers, which the engines do not accept as embeddings
+		return &usageError{fs: fs, msg: "the vector workload needs an embedded engine; the server API cannot store vectors yet"}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var target bench.Target
+	var name string
+	if remote {
+		opts := []func(*client.Client){}
+		if *url != "" {
+			opts = append(opts, client.WithHTTP(*url))
+			name = *url
+		}
+		if *grpcTarget != "" {
+			opts = append(opts, client.WithGRPC(*grpcTarget), client.WithGRPCConns(min(o.Concurrency, 8)))
+			name = "grpc://" + *grpcTarget
+		}
+		if *apiKey != "" {
+			opts = append(opts, client.WithAPIKey(*apiKey))
+		}
+		opts = append(opts, client.WithMaxIdleConns(o.Concurrency))
+		c, err := client.New(opts...)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		target = c
+	} else {
+		eng, mode, cleanup, err := benchEngine(fs, ef, o)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		target, name = eng, "embedded "+string(mode)
+	}
+
+	report, err := bench.Run(ctx, target, o)
+	if err != nil {
+		return err
+	}
+	report.Target = name
+	if *asJSON {
+		return writeJSON(os.Stdout, report)
+	}
+	report.WriteText(os.Stdout)
+	return nil
+}
+
+// benchEngine opens the embedded engine for a run. Unless --dir was given
+// it works in a temporary directory, removed by cleanup.
+func benchEngine(fs *flag.FlagSet, ef *engineFlags, o bench.Options) (types.Engine, types.Mode, func(), error) {
+	cfg, err := ef.load()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	cfg.Mode = benchMode(fs, cfg.Mode, o)
+	cfg.VectorDim = o.Dim
+	dirSet := false
+	fs.Visit(func(fl *flag.Flag) { dirSet = dirSet || fl.Name == "dir" })
+	removeDir := func() {}
+	if !dirSet && (cfg.Mode == types.ModeDisk || cfg.Mode == types.ModeHybrid) {
+		dir, err := os.MkdirTemp("", "kvi-bench-")
+		if err != nil {
+			return nil, "", nil, err
+		}
+		cfg.DataDir = dir
+		removeDir = func() { os.RemoveAll(dir) }
+	}
+	eng, err := kvi.Open(cfg)
+	if err != nil {
+		removeDir()
+		return nil, "", nil, err
+	}
+	return eng, cfg.Mode, func() {
+		if err := eng.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "close: %v\n", err)
+		}
+		removeDir()
+	}, nil
+}
+
+// benchMode is the engine mode of an embedded run: --mode if given, else
+// vector for the vector workload and the configured mode for the others.
+func benchMode(fs *flag.FlagSet, mode types.Mode, o bench.Options) types.Mode {
+	modeSet := false
+	fs.Visit(func(fl *flag.Flag) { modeSet = modeSet || fl.Name == "mode" })
+	if !modeSet && o.Workload == bench.Vector {
+		return types.ModeVector
+	}
+	return mode
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/internal/sql"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// The data commands open the data directory themselves, so no server may
+// be running on it meanwhile.
+const offlineNote = "Opens the data directory directly; stop any server using it first."
+
+// remoteNote is the help of commands that can also work through a server.
+const remoteNote = "Opens the data directory directly, so stop any server using it first,\n" +
+	"or give --url to work through that server instead."
+
+func runBackup(args []string) error {
+	fs := newFlagSet("backup", "--out FILE | --dest DIR [flags]", "Write a backup of every record to FILE (- for stdout), or under DIR, a directory\n"+
+		"or an s3://bucket/prefix URL, named as snapshots are. Backups taken locally and\n"+
+		"through --url have the same format; either restores either way.\n"+remoteNote)
+	ef := addEngineFlags(fs)
+	rf := addRemoteFlags(fs)
+	out := fs.String("out", "", "Backup file to write (- for stdout)")
+	dest := fs.String("dest", "", "Directory or s3://bucket/prefix to write the backup under instead; S3 settings come from the config's backup.s3")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if (*out == "") == (*dest == "") {
+		return &usageError{fs: fs, msg: "give one of --out or --dest"}
+	}
+	if err := rf.check(fs); err != nil {
+		return err
+	}
+	cfg, err := ef.load()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var dump func(io.Writer) (backup.Summary, error)
+	from := ""
+	if rf.remote() {
+		c, err := rf.client()
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		dump = func(w io.Writer) (backup.Summary, error) { return c.Backup(ctx, w) }
+		from = " from " + rf.url
+	} else {
+		eng, err := kvi.Open(cfg)
+		if err != nil {
+			return err
+		}
+		defer eng.Close()
+		dump = func(w io.Writer) (backup.Summary, error) { return backup.Dump(ctx, eng, w) }
+	}
+
+	if *dest != "" {
+		store, err := backup.NewStore(*dest, cfg.Backup.S3)
+		if err != nil {
+			return err
+		}
+		name := backup.NewName(time.Now())
+		sum, err := backup.Save(ctx, store, name, dump)
+		if err != nil {
+			return err
+		}
+		log.Printf("Backed up %d records%s to %s (%d bytes, sha256 %s)", sum.Records, from, store.Location(name), sum.Bytes, sum.Checksum)
+		return nil
+	}
+	return writeOutput(*out, func(w io.Writer) error {
+		sum, err := dump(w)
+		if err != nil {
+			return err
+		}
+		log.Printf("Backed up %d records%s (%d bytes, sha256 %s)", sum.Records, from, sum.Bytes, sum.Checksum)
+		return nil
+	})
+}
+
+func runQuery(args []string) error {
+	fs := newFlagSet("query", "[flags] STATEMENT", "Run one SQL statement and print its result rows.\n"+remoteNote)
+	ef := addEngineFlags(fs)
+	rf := addRemoteFlags(fs)
+	output := fs.String("output", outputJSON, "Output format: table | json | ndjson | csv")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return &usageError{fs: fs, msg: "expected one SQL statement (quote it)"}
+	}
+	if err := checkOutput(*output); err != nil {
+		return &usageError{fs: fs, msg: err.Error()}
+	}
+	if err := rf.check(fs); err != nil {
+		return err
+	}
+	var rows []interface{}
+	var columns []string
+	if rf.remote() {
+		c, err := rf.client()
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		res, err := c.Query(context.Background(), fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if rows, err = remoteRows(res.Items); err != nil {
+			return err
+		}
+		columns = res.Columns
+	} else {
+		eng, err := ef.open()
+		if err != nil {
+			return err
+		}
+		defer eng.Close()
+		res, err := sql.NewExecutor(eng).Execute(context.Background(), fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		rows, columns = rowsOf(res.Value), res.Columns
+	}
+
+	// Results are small; knowing every row gives CSV its full header
+	flat := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		flat[i] = flatten(row)
+	}
+	rw := newRowWriter(os.Stdout, *output, orderColumns(columns, flat...))
+	for _, row := range rows {
+		if err := rw.write(row); err != nil {
+			return err
+		}
+	}
+	return rw.close()
+}
+
+func runImport(args []string) error {
+	fs := newFlagSet("import", "--in FILE [flags]", "Put the records of a JSON lines file, one record object per line,\nas written by kvi export (- for stdin).\n"+offlineNote)
+	ef := addEngineFlags(fs)
+	in := fs.String("in", "", "JSON lines file to read (required; - for stdin)")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return &usageError{fs: fs, msg: "--in is required"}
+	}
+	r := io.Reader(os.Stdin)
+	if *in != "-" {
+		f, err := os.Open(*in)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	eng, err := ef.open()
+	if err != nil {
+		return err
+	}
+
+	n, err := importRecords(context.Background(), eng, r)
+	if closeErr := eng.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Imported %d records", n)
+	return nil
+}
+
+// importRecords puts each record of the JSON lines in r under its ID.
+func importRecords(ctx context.Context, eng types.Engine, r io.Reader) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 64<<20)
+	n, line := 0, 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var rec types.Record
+		if err := json.Unmarshal([]byte(text), &rec); err != nil {
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+		if rec.ID == "" {
+			return n, fmt.Errorf("line %d: record has no id", line)
+		}
+		if err := eng.Put(ctx, rec.ID, &rec); err != nil {
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+		n++
+	}
+	return n, scanner.Err()
+}
+
+func runExport(args []string) error {
+	fs := newFlagSet("export", "[flags]", "Write the records under a prefix, by default as JSON lines that kvi import reads.\n"+offlineNote)
+	ef := addEngineFlags(fs)
+	out := fs.String("out", "-", "File to write (- for stdout)")
+	prefix := fs.String("prefix", "", "Only export keys starting with this prefix")
+	output := fs.String("output", outputNDJSON, "Output format: table | json | ndjson | csv")
+	columns := fs.String("columns", "", "Comma-separated columns to put first (table, csv); csv otherwise takes its header from the first record")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return &usageError{fs: fs, msg: "unexpected arguments " + strings.Join(fs.Args(), " ")}
+	}
+	if err := checkOutput(*output); err != nil {
+		return &usageError{fs: fs, msg: err.Error()}
+	}
+	var first []string
+	if *columns != "" {
+		first = strings.Split(*columns, ",")
+	}
+	eng, err := ef.open()
+	if err != nil {
+		return err
+	}
+	defer eng.Close()
+
+	return writeOutput(*out, func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		rw := newRowWriter(bw, *output, first)
+		n := 0
+		var writeErr error
+		err := eng.Scan(context.Background(), *prefix, func(rec *types.Record) bool {
+			writeErr = rw.write(rec)
+			n++
+			return writeErr == nil
+		})
+		if err = errors.Join(err, writeErr, rw.close(), bw.Flush()); err != nil {
+			return err
+		}
+		log.Printf("Exported %d records", n)
+		return nil
+	})
+}
+
+// writeOutput runs write against stdout for "-", else against a new file
+// at path that is removed again if write fails.
+func writeOutput(path string, write func(io.Writer) error) error {
+	if path == "-" {
+		return write(os.Stdout)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = errors.Join(write(f), f.Close()); err != nil {
+		os.Remove(path)
+	}
+	return err
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/internal/format"
+	"github.com/thirawat27/kvi/internal/wal"
+)
+
+func runFormats(args []string) error {
+	global, rest := splitGlobal(args)
+	if len(rest) > 0 && rest[0] == "upgrade" {
+		return runFormatsUpgrade(append(global, rest[1:]...))
+	}
+	fs := newFlagSet("formats", "[flags] [FILE...]\n       kvi formats upgrade [flags] FILE...",
+		"Without files, list the versions of the formats kvi writes: the write-ahead\n"+
+			"log and backups, which snapshots are too. With files, print what each is and\n"+
+			"which releases can read it.\n\n"+
+			"  upgrade   Rewrite files of an older version in place in the current one")
+	fs.String("config", "", "Ignored; accepted like every other command")
+	asJSON := fs.Bool("json", false, "Print JSON")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		if *asJSON {
+			return writeJSON(os.Stdout, format.Specs)
+		}
+		return writeFormatSpecs(os.Stdout)
+	}
+
+	var files []*fileFormat
+	var errs []error
+	for _, path := range fs.Args() {
+		ff, err := identify(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		files = append(files, ff)
+		if !*asJSON {
+			ff.writeText(os.Stdout)
+		}
+	}
+	if *asJSON {
+		errs = append(errs, writeJSON(os.Stdout, files))
+	}
+	return errors.Join(errs...)
+}
+
+func writeFormatSpecs(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "FORMAT\tVERSION\tREAD BY\tCHANGES")
+	for _, spec := range format.Specs {
+		for _, v := range spec.Versions {
+			version := fmt.Sprint(v.Version)
+			if v == spec.Latest() {
+				version += " (written)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\tkvi %s or later\t%s\n", spec.Name, version, v.Release, v.Note)
+		}
+	}
+	return tw.Flush()
+}
+
+// fileFormat is what kvi formats found a file to be.
+type fileFormat struct {
+	Path string `json:"path"`
+	format.Header
+	Codec string `json:"codec,omitempty"` // of a backup; "" for JSON
+	// Readable is whether this binary reads the file, and Current whether
+	// it is in the version this binary writes, which upgrade rewrites it in.
+	Readable bool `json:"readable"`
+	Current  bool `json:"current"`
+}
+
+// identify tells a backup from a write-ahead log by its first bytes. A
+// log from before headers has none, and is taken for one if its first
+// entry is intact.
+func identify(path string) (*fileFormat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ff := &fileFormat{Path: path}
+
+	hdr, err := backup.ReadHeader(f)
+	if !errors.Is(err, backup.ErrBadFormat) {
+		ff.Header, ff.Codec = hdr.Header, hdr.Codec
+		return ff.check(format.Backup, err, hdr.MinReader > 0)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	walHdr, size, err := wal.ReadHeader(f)
+	if err == nil && size == 0 {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		intact := false
+		if err := wal.Scan(f, func(frame wal.Frame) bool {
+			intact = frame.Valid()
+			return false
+		}); err != nil {
+			return nil, err
+		}
+		if !intact {
+			return nil, fmt.Errorf("%s: not a kvi backup or write-ahead log", path)
+		}
+	}
+	ff.Header = walHdr
+	return ff.check(format.WAL, err, walHdr.Version >= format.WAL.Latest().Version)
+}
+
+// check fills in what this binary makes of the file, given the error
+// reading its header and whether upgrade would leave it as it is.
+func (ff *fileFormat) check(spec format.Spec, err error, current bool) (*fileFormat, error) {
+	if err != nil && !errors.Is(err, format.ErrTooNew) {
+		return nil, fmt.Errorf("%s: %w", ff.Path, err)
+	}
+	if ff.MinRelease == "" {
+		ff.MinRelease = spec.Release(ff.Reader())
+	}
+	ff.Readable = err == nil
+	ff.Current = ff.Readable && current
+	return ff, nil
+}
+
+func (ff *fileFormat) writeText(w io.Writer) {
+	fmt.Fprintf(w, "%s:\n", ff.Path)
+	kind := ff.Format + " version " + fmt.Sprint(ff.Version)
+	if ff.Codec != "" {
+		kind += ", " + ff.Codec
+	}
+	fmt.Fprintf(w, "  format:   %s\n", kind)
+	if !ff.CreatedAt.IsZero() && ff.CreatedAt.Unix() > 0 {
+		fmt.Fprintf(w, "  written:  %s\n", ff.CreatedAt.Format(time.RFC3339))
+	}
+	readBy := "a newer kvi"
+	if ff.MinRelease != "" {
+		readBy = "kvi " + ff.MinRelease + " or later"
+	}
+	fmt.Fprintf(w, "  read by:  %s (format version %d readers)\n", readBy, ff.Reader())
+	switch {
+	case !ff.Readable:
+		fmt.Fprintf(w, "  this kvi: cannot read it (this is kvi v%s)\n", version)
+	case !ff.Current:
+		fmt.Fprintln(w, "  this kvi: reads it; kvi formats upgrade rewrites it in the current version")
+	default:
+		fmt.Fprintln(w, "  this kvi: reads it")
+	}
+}
+
+func runFormatsUpgrade(args []string) error {
+	fs := newFlagSet("formats upgrade", "[flags] FILE...", "Rewrite write-ahead logs and backups of an older format version in place in\n"+
+		"the current one, keeping their entries and records as they are. A log gets a\n"+
+		"header; a backup gets the minimum reader version in its header, and its\n"+
+		"NAME.sha256 file is rewritten to match. Files already current are left alone.\n"+
+		"Releases before kvi 1.1.0 cannot read an upgraded log; stop any server using\n"+
+		"one first.")
+	fs.String("config", "", "Ignored; accepted like every other command")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return &usageError{fs: fs, msg: "expected files to upgrade"}
+	}
+	var errs []error
+	for _, path := range fs.Args() {
+		ff, err := identify(path)
+		if err == nil && !ff.Readable {
+			err = fmt.Errorf("%s: %s version %d is newer than this binary reads", path, ff.Format, ff.Version)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		var upgraded bool
+		if ff.Format == format.WAL.Name {
+			upgraded, err = wal.Upgrade(path)
+		} else {
+			upgraded, err = backup.UpgradeFile(path)
+		}
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		case upgraded:
+			log.Printf("Upgraded %s from %s version %d", path, ff.Format, ff.Version)
+		default:
+			log.Printf("%s is current; nothing to upgrade", path)
+		}
+	}
+	return errors.Join(errs...)
+}
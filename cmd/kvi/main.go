@@ -1,123 +1,222 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
-	"net"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
+	"runtime"
+	"strings"
 
-	"github.com/thirawat27/kvi/internal/pubsub"
-	"github.com/thirawat27/kvi/pkg/api"
 	"github.com/thirawat27/kvi/pkg/config"
-	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
 	"github.com/thirawat27/kvi/pkg/kvi"
 	"github.com/thirawat27/kvi/pkg/types"
-	"google.golang.org/grpc"
 )
 
+// version is the release, set at build time with -ldflags "-X main.version=…".
+var version = "1.1.0"
+
+// command is one kvi subcommand. run returns a *usageError for bad
+// arguments, which prints the subcommand's usage.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
+
+var commands = []command{
+	{"serve", "Run the REST and gRPC servers", runServe},
+	{"backup", "Write a backup of the data directory or a server to a file", runBackup},
+	{"restore", "Replace or merge the contents of the data directory or a server from a backup", runRestore},
+	{"query", "Run one SQL statement against the data directory or a server", runQuery},
+	{"stats", "Print engine and runtime statistics", runStats},
+	{"compact", "Compact the engine's storage", runCompact},
+	{"import", "Load records from a JSON lines file", runImport},
+	{"export", "Write records as JSON lines", runExport},
+	{"bench", "Benchmark an embedded engine or a running server", runBench},
+	{"wal", "Inspect or repair the write-ahead log", runWal},
+	{"verify", "Check the integrity of the data directory", runVerify},
+	{"formats", "Identify write-ahead logs and backups, or upgrade them in place", runFormats},
+	{"version", "Print the kvi version", runVersion},
+}
+
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lmsgprefix)
 	log.SetPrefix("[kvi] ")
 
-	modeStr := flag.String("mode", string(types.ModeHybrid), "Engine mode: memory | disk | columnar | vector | hybrid")
-	dataDir := flag.String("dir", "./data", "Data directory (for Disk / Hybrid modes)")
-	port := flag.Int("port", 8080, "REST API port")
-	grpcPort := flag.Int("grpc-port", 50051, "gRPC port")
-	authOn := flag.Bool("auth", false, "Enable JWT authentication on all routes")
-	cfgFile := flag.String("config", "", "Path to JSON config file (overrides flags)")
-	flag.Parse()
-
-	// ── Load config ──────────────────────────────────────────────────────────
-	var cfg *config.Config
-
-	if *cfgFile != "" {
-		data, err := os.ReadFile(*cfgFile)
-		if err != nil {
-			log.Fatalf("Cannot read config file: %v", err)
+	// A --config before the subcommand applies to it, as if given after
+	global, args := splitGlobal(os.Args[1:])
+	cmd, ok := lookup(args)
+	switch {
+	case ok:
+		args = args[1:]
+	case len(args) == 0 || strings.HasPrefix(args[0], "-"):
+		// Releases before subcommands took the serve flags directly
+		log.Println("DEPRECATED: running kvi without a subcommand; use `kvi serve` with the same flags")
+		cmd = commands[0]
+	default:
+		fmt.Fprintf(os.Stderr, "kvi: unknown command %q\n\n", args[0])
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	err := cmd.run(append(global, args...))
+	var uerr *usageError
+	switch {
+	case err == nil:
+	case errors.Is(err, flag.ErrHelp):
+	case errors.As(err, &uerr):
+		if uerr.msg != "" {
+			fmt.Fprintf(os.Stderr, "kvi %s: %s\n", cmd.name, uerr.msg)
 		}
-		cfg = config.DefaultConfig()
-		if err := json.Unmarshal(data, cfg); err != nil {
-			log.Fatalf("Invalid config JSON: %v", err)
+		uerr.fs.Usage()
+		os.Exit(2)
+	default:
+		log.Fatalf("%s: %v", cmd.name, err)
+	}
+}
+
+// splitGlobal separates the leading --config flag, the one global option,
+// from the subcommand and its arguments.
+func splitGlobal(args []string) (global, rest []string) {
+	for len(args) > 0 {
+		name := strings.TrimLeft(args[0], "-")
+		switch {
+		case !strings.HasPrefix(args[0], "-"):
+			return global, args
+		case strings.HasPrefix(name, "config="):
+			global, args = append(global, args[0]), args[1:]
+		case name == "config" && len(args) > 1:
+			global, args = append(global, args[:2]...), args[2:]
+		default:
+			return global, args
 		}
-	} else {
-		cfg = config.DefaultConfig()
-		cfg.Mode = types.Mode(*modeStr)
-		cfg.DataDir = *dataDir
-		cfg.Port = *port
-		cfg.GrpcPort = *grpcPort
 	}
+	return global, args
+}
 
-	// ── Open engine ──────────────────────────────────────────────────────────
-	eng, err := kvi.Open(cfg)
-	if err != nil {
-		log.Fatalf("Failed to open engine: %v", err)
+func lookup(args []string) (command, bool) {
+	if len(args) == 0 {
+		return command{}, false
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd, true
+		}
 	}
+	return command{}, false
+}
 
-	banner(cfg)
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: kvi [--config FILE] <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-8s  %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run `kvi <command> -h` for the flags of a command.")
+}
 
-	// Shared pub/sub hub (REST + gRPC share it)
-	hub := pubsub.NewHub()
+// usageError is a bad command line; main prints msg and the usage of fs.
+type usageError struct {
+	fs  *flag.FlagSet
+	msg string
+}
 
-	// ── REST API server ───────────────────────────────────────────────────────
-	opts := []func(*api.Server){}
-	if *authOn {
-		log.Println("JWT authentication ENABLED")
-		opts = append(opts, api.WithAuth())
+func (e *usageError) Error() string { return e.msg }
+
+// newFlagSet returns the flag set of a subcommand. Its usage shows synopsis
+// and about above the flags.
+func newFlagSet(name, synopsis, about string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: kvi %s %s\n\n%s\n", name, synopsis, about)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(out, "\nFlags:")
+			fs.PrintDefaults()
+		}
 	}
-	restSrv := api.NewServer(eng, opts...)
+	return fs
+}
 
-	go func() {
-		addr := fmt.Sprintf(":%d", cfg.Port)
-		log.Printf("REST API  → http://0.0.0.0%s", addr)
-		if err := restSrv.Start(addr); err != nil {
-			log.Fatalf("REST server error: %v", err)
+// parse parses args into fs. On a bad flag the flag package has already
+// printed the problem and the usage, so parse exits with status 2.
+func parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
 		}
-	}()
-
-	// ── gRPC server ───────────────────────────────────────────────────────────
-	go func() {
-		addr := fmt.Sprintf(":%d", cfg.GrpcPort)
-		lis, err := net.Listen("tcp", addr)
-		if err != nil {
-			log.Fatalf("gRPC listen error: %v", err)
+		os.Exit(2)
+	}
+	return nil
+}
+
+// engineFlags are the options of every subcommand that opens the engine.
+type engineFlags struct {
+	fs     *flag.FlagSet
+	config string
+	mode   string
+	dir    string
+}
+
+func addEngineFlags(fs *flag.FlagSet) *engineFlags {
+	defaults := config.DefaultConfig()
+	f := &engineFlags{fs: fs}
+	fs.StringVar(&f.config, "config", "", "Path to a YAML (.yaml, .yml) or JSON config file; flags override its values")
+	fs.StringVar(&f.mode, "mode", string(defaults.Mode), "Engine mode: memory | disk | columnar | vector | hybrid")
+	fs.StringVar(&f.dir, "dir", defaults.DataDir, "Data directory (for Disk / Hybrid modes)")
+	return f
+}
+
+// load builds the configuration: defaults, then the config file if one was
+// given, then KVI_* environment variables, then the flags set on the
+// command line.
+func (f *engineFlags) load() (*config.Config, error) {
+	cfg := config.DefaultConfig()
+	if f.config != "" {
+		var err error
+		if cfg, err = config.Load(f.config); err != nil {
+			return nil, err
 		}
-		gs := grpc.NewServer()
-		kvi_grpc.RegisterKviServiceServer(gs, kvi_grpc.NewGrpcServer(eng, hub))
-		log.Printf("gRPC API  → grpc://0.0.0.0%s", addr)
-		if err := gs.Serve(lis); err != nil {
-			log.Fatalf("gRPC server error: %v", err)
+	}
+	if err := config.FromEnv(cfg); err != nil {
+		return nil, fmt.Errorf("environment: %w", err)
+	}
+	f.fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "mode":
+			cfg.Mode = types.Mode(f.mode)
+		case "dir":
+			cfg.DataDir = f.dir
 		}
-	}()
-
-	// ── Graceful shutdown ─────────────────────────────────────────────────────
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
-	<-quit
+	})
+	return cfg, nil
+}
 
-	log.Println("Shutting down Kvi engine…")
-	if err := eng.Close(); err != nil {
-		log.Printf("Close error: %v", err)
+// open loads the configuration and opens its engine for a one-off command.
+func (f *engineFlags) open() (types.Engine, error) {
+	cfg, err := f.load()
+	if err != nil {
+		return nil, err
 	}
-	log.Println("Goodbye 👋")
+	return kvi.Open(cfg)
 }
 
-func banner(cfg *config.Config) {
-	fmt.Println()
-	fmt.Println("  ██╗  ██╗██╗   ██╗██╗")
-	fmt.Println("  ██║ ██╔╝██║   ██║██║")
-	fmt.Println("  █████╔╝ ██║   ██║██║")
-	fmt.Println("  ██╔═██╗ ╚██╗ ██╔╝██║")
-	fmt.Println("  ██║  ██╗ ╚████╔╝ ██║")
-	fmt.Println("  ╚═╝  ╚═╝  ╚═══╝  ╚═╝")
-	fmt.Printf("  Kinetic Virtual Index  v1.0.0\n\n")
-	fmt.Printf("  Mode     : %s\n", cfg.Mode)
-	fmt.Printf("  DataDir  : %s\n", cfg.DataDir)
-	fmt.Printf("  REST     : http://0.0.0.0:%d\n", cfg.Port)
-	fmt.Printf("  gRPC     : grpc://0.0.0.0:%d\n", cfg.GrpcPort)
-	fmt.Printf("  Started  : %s\n\n", time.Now().Format(time.RFC3339))
+func runVersion(args []string) error {
+	fs := newFlagSet("version", "", "Print the kvi version.")
+	fs.String("config", "", "Ignored; accepted like every other command")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return &usageError{fs: fs, msg: "unexpected arguments"}
+	}
+	fmt.Printf("kvi v%s (%s, %s/%s)\n", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return nil
 }
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// Output formats of the query and export commands.
+const (
+	outputTable  = "table"  // aligned columns, NULL for missing values
+	outputJSON   = "json"   // indented JSON
+	outputNDJSON = "ndjson" // one JSON object per line, null for missing values
+	outputCSV    = "csv"    // header row, then one row per record; empty for missing values
+)
+
+var outputFormats = []string{outputTable, outputJSON, outputNDJSON, outputCSV}
+
+func checkOutput(format string) error {
+	if !slices.Contains(outputFormats, format) {
+		return fmt.Errorf("--output must be one of %s", strings.Join(outputFormats, ", "))
+	}
+	return nil
+}
+
+// rowWriter renders values one at a time. JSON formats write each value
+// as it is; table and CSV flatten it into a row of columns (see flatten).
+type rowWriter interface {
+	write(v interface{}) error
+	close() error
+}
+
+// newRowWriter returns a writer of format to w. columns come first, in
+// order; a table adds every other field it meets, sorted, while CSV, which
+// streams, takes the rest of its header from the first row.
+func newRowWriter(w io.Writer, format string, columns []string) rowWriter {
+	switch format {
+	case outputTable:
+		return &tableWriter{w: w, columns: columns}
+	case outputCSV:
+		return &csvWriter{w: csv.NewWriter(w), columns: columns}
+	case outputNDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w)}
+	default:
+		return &jsonWriter{w: w}
+	}
+}
+
+// flatten turns a value into a row: a record becomes its id, version and
+// data fields; a status object its fields; anything else one "value".
+func flatten(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case *types.Record:
+		row := make(map[string]interface{}, len(v.Data)+2)
+		for k, val := range v.Data {
+			row[k] = val
+		}
+		row["id"], row["version"] = v.ID, v.Version
+		return row
+	case map[string]string:
+		row := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			row[k] = val
+		}
+		return row
+	case map[string]interface{}:
+		return v
+	default:
+		return map[string]interface{}{"value": v}
+	}
+}
+
+// rowsOf splits a statement's result into the values to render.
+func rowsOf(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case []map[string]string:
+		rows := make([]interface{}, len(v))
+		for i, row := range v {
+			rows[i] = row
+		}
+		return rows
+	default:
+		return []interface{}{v}
+	}
+}
+
+// remoteRows turns the items of a server's query result back into the
+// values rowsOf yields locally: records, and status objects.
+func remoteRows(items []json.RawMessage) ([]interface{}, error) {
+	rows := make([]interface{}, len(items))
+	for i, item := range items {
+		var row map[string]interface{}
+		if err := json.Unmarshal(item, &row); err != nil {
+			return nil, err
+		}
+		if _, ok := row["data"].(map[string]interface{}); !ok {
+			rows[i] = row
+			continue
+		}
+		var rec types.Record
+		if err := json.Unmarshal(item, &rec); err != nil {
+			return nil, err
+		}
+		rows[i] = &rec
+	}
+	return rows, nil
+}
+
+// orderColumns returns first, then the other keys of rows sorted, with
+// "id" leading them.
+func orderColumns(first []string, rows ...map[string]interface{}) []string {
+	cols := slices.Clone(first)
+	var rest []string
+	for _, row := range rows {
+		for k := range row {
+			if !slices.Contains(cols, k) && !slices.Contains(rest, k) {
+				rest = append(rest, k)
+			}
+		}
+	}
+	slices.SortFunc(rest, func(a, b string) int {
+		switch {
+		case a == b:
+			return 0
+		case a == "id":
+			return -1
+		case b == "id":
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+	return append(cols, rest...)
+}
+
+// cell renders a field for table and CSV output; ok is false for NULL.
+func cell(v interface{}, present bool) (string, bool) {
+	if !present || v == nil {
+		return "", false
+	}
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), true
+	case bool, int, int64, uint64, json.Number:
+		return fmt.Sprint(v), true
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v), true
+		}
+		return string(b), true
+	}
+}
+
+type jsonWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (j *jsonWriter) write(v interface{}) error {
+	b, err := json.MarshalIndent(v, "  ", "  ")
+	if err != nil {
+		return err
+	}
+	sep := ",\n  "
+	if j.count == 0 {
+		sep = "[\n  "
+	}
+	j.count++
+	_, err = fmt.Fprintf(j.w, "%s%s", sep, b)
+	return err
+}
+
+func (j *jsonWriter) close() error {
+	if j.count == 0 {
+		_, err := io.WriteString(j.w, "[]\n")
+		return err
+	}
+	_, err := io.WriteString(j.w, "\n]\n")
+	return err
+}
+
+type ndjsonWriter struct{ enc *json.Encoder }
+
+func (n *ndjsonWriter) write(v interface{}) error { return n.enc.Encode(v) }
+
+func (n *ndjsonWriter) close() error { return nil }
+
+// tableWriter holds every row until close, to size the columns.
+type tableWriter struct {
+	w       io.Writer
+	columns []string
+	rows    []map[string]interface{}
+}
+
+func (t *tableWriter) write(v interface{}) error {
+	t.rows = append(t.rows, flatten(v))
+	return nil
+}
+
+func (t *tableWriter) close() error {
+	cols := orderColumns(t.columns, t.rows...)
+	tw := tabwriter.NewWriter(t.w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(cols, "\t"))
+	cells := make([]string, len(cols))
+	for _, row := range t.rows {
+		for i, col := range cols {
+			val, present := row[col]
+			s, ok := cell(val, present)
+			if !ok {
+				s = "NULL"
+			}
+			// A tab or newline would break the alignment
+			cells[i] = strings.NewReplacer("\t", `\t`, "\n", `\n`).Replace(s)
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	fmt.Fprintf(tw, "(%d rows)\n", len(t.rows))
+	return tw.Flush()
+}
+
+// csvWriter streams rows under a header fixed by the first of them.
+// Fields outside the header are left out and counted.
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+	started bool
+	omitted int
+}
+
+func (c *csvWriter) write(v interface{}) error {
+	row := flatten(v)
+	if !c.started {
+		c.columns = orderColumns(c.columns, row)
+		c.started = true
+		if err := c.w.Write(c.columns); err != nil {
+			return err
+		}
+	}
+	record := make([]string, len(c.columns))
+	for i, col := range c.columns {
+		val, present := row[col]
+		record[i], _ = cell(val, present)
+	}
+	for k := range row {
+		if !slices.Contains(c.columns, k) {
+			c.omitted++
+			break
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) close() error {
+	c.w.Flush()
+	if c.omitted > 0 {
+		log.Printf("WARNING: %d rows had fields outside the CSV header; list them with --columns", c.omitted)
+	}
+	return c.w.Error()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/thirawat27/kvi/pkg/client"
+)
+
+// remoteFlags are the options of subcommands that can drive a running
+// server over its REST API instead of opening the data directory.
+type remoteFlags struct {
+	url    string
+	token  string
+	apiKey string
+}
+
+func addRemoteFlags(fs *flag.FlagSet) *remoteFlags {
+	f := &remoteFlags{}
+	fs.StringVar(&f.url, "url", "", "Work through the server at this REST API root, e.g. http://localhost:8080, instead of the data directory")
+	fs.StringVar(&f.token, "token", "", "Bearer token for --url")
+	fs.StringVar(&f.apiKey, "api-key", "", "API key for --url, exchanged for a token")
+	return f
+}
+
+// remote reports whether --url was given.
+func (f *remoteFlags) remote() bool { return f.url != "" }
+
+// check rejects combinations that cannot work: credentials without --url,
+// or engine flags with it.
+func (f *remoteFlags) check(fs *flag.FlagSet) error {
+	var err error
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "token", "api-key":
+			if !f.remote() {
+				err = &usageError{fs: fs, msg: "--" + fl.Name + " needs --url"}
+			}
+		case "mode", "dir":
+			if f.remote() {
+				err = &usageError{fs: fs, msg: "--" + fl.Name + " has no effect with --url; the server uses its own"}
+			}
+		}
+	})
+	if err == nil && f.token != "" && f.apiKey != "" {
+		err = &usageError{fs: fs, msg: "give --token or --api-key, not both"}
+	}
+	return err
+}
+
+// client returns a client for the server. Its calls are unbounded, since
+// backups and restores take as long as the data set requires.
+func (f *remoteFlags) client() (*client.Client, error) {
+	opts := []func(*client.Client){client.WithHTTP(f.url), client.WithTimeout(0)}
+	switch {
+	case f.token != "":
+		opts = append(opts, client.WithToken(f.token))
+	case f.apiKey != "":
+		opts = append(opts, client.WithAPIKey(f.apiKey))
+	}
+	return client.New(opts...)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/internal/engine"
+	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/client"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func runRestore(args []string) error {
+	fs := newFlagSet("restore", "--in FILE [flags]", "Replace the records in the data directory with those of a backup, or with\n"+
+		"--merge apply it on top of them. --verify checks the backup instead: its format,\n"+
+		"checksum, record count and vector dimensions, without changing anything.\n"+
+		"Verifying a local target reads only the file; with --url the server also\n"+
+		"reports what the restore would change. FILE may be an s3://bucket/key URL,\n"+
+		"reached with the config's backup.s3; the object is checked against the\n"+
+		"checksum stored with it before anything else.\n\n"+
+		"With --wal-dir it restores to a point in time: the backup, then the entries of\n"+
+		"the write-ahead log in that directory logged after the backup was taken, up to\n"+
+		"--until or --until-lsn. Copy the log out of the data directory first; the\n"+
+		"restore rewrites it.\n"+remoteNote)
+	ef := addEngineFlags(fs)
+	rf := addRemoteFlags(fs)
+	in := fs.String("in", "", "Backup file or s3:// URL to read (required)")
+	fs.StringVar(in, "base", "", "Same as --in, the backup a point-in-time restore starts from")
+	merge := fs.Bool("merge", false, "Apply the backup on top of the stored records; a record stored at the same or a newer version is kept")
+	verify := fs.Bool("verify", false, "Check the backup and print a report instead of restoring it")
+	checksum := fs.String("checksum", "", "Expected SHA-256 of the file, as kvi backup logs it; checked before restoring")
+	records := fs.Int("records", 0, "Expected number of records; checked before restoring")
+	asJSON := fs.Bool("json", false, "With --verify, print the report as JSON")
+	walDir := fs.String("wal-dir", "", "Replay the write-ahead log in this directory on top of the backup")
+	until := fs.String("until", "", "With --wal-dir, stop before the first entry written at or after this RFC 3339 time")
+	untilLSN := fs.Uint64("until-lsn", 0, "With --wal-dir, stop after the entry with this LSN")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if *in == "" {
+		return &usageError{fs: fs, msg: "--in is required"}
+	}
+	if *asJSON && !*verify {
+		return &usageError{fs: fs, msg: "--json needs --verify"}
+	}
+	if err := rf.check(fs); err != nil {
+		return err
+	}
+	var cut wal.Cut
+	switch {
+	case *walDir == "" && (*until != "" || *untilLSN > 0):
+		return &usageError{fs: fs, msg: "--until and --until-lsn need --wal-dir"}
+	case *walDir != "" && (*merge || *verify || rf.remote()):
+		return &usageError{fs: fs, msg: "--wal-dir cannot be combined with --merge, --verify, --url or --grpc"}
+	case *until != "":
+		t, err := time.Parse(time.RFC3339Nano, *until)
+		if err != nil {
+			return &usageError{fs: fs, msg: fmt.Sprintf("--until: %v", err)}
+		}
+		cut.Time = t
+	}
+	cut.LSN = *untilLSN
+	cfg, err := ef.load()
+	if err != nil {
+		return err
+	}
+	if *walDir != "" && sameDir(*walDir, cfg.DataDir) {
+		return &usageError{fs: fs, msg: "--wal-dir is the data directory being restored; copy the log elsewhere first"}
+	}
+	ctx := context.Background()
+	f, err := openBackup(ctx, *in, cfg.Backup.S3)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	exp := backup.Expect{Checksum: *checksum, Records: *records}
+	if !rf.remote() {
+		if cfg.Mode == types.ModeVector || cfg.Mode == types.ModeHybrid {
+			exp.VectorDim = cfg.VectorDim
+		}
+	}
+	// The server checks vector dimensions itself, but only the file can
+	// be held to a checksum or count
+	if *verify && !rf.remote() || exp.Checksum != "" || exp.Records > 0 {
+		report, err := backup.Verify(f, exp)
+		if err != nil {
+			return fmt.Errorf("%s: %w", *in, err)
+		}
+		if *verify && (!rf.remote() || !report.OK()) {
+			return printVerify(*in, report, nil, *asJSON)
+		}
+		if !report.OK() {
+			return fmt.Errorf("%s failed verification: %s", *in, strings.Join(report.Problems, "; "))
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+
+	if rf.remote() {
+		c, err := rf.client()
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		res, err := c.Restore(ctx, f, client.RestoreOptions{Merge: *merge, DryRun: *verify})
+		if err != nil {
+			return err
+		}
+		if *verify {
+			var plan *backup.Result
+			if res.Status == "ok" {
+				plan = &backup.Result{Restored: res.Restored, Skipped: res.Skipped, Expired: res.Expired, Removed: res.Removed}
+			}
+			return printVerify(*in, res.Backup, plan, *asJSON)
+		}
+		log.Printf("Restored %d records to %s (%d skipped as not newer, %d expired), removed %d",
+			res.Restored, rf.url, res.Skipped, res.Expired, res.Removed)
+		return nil
+	}
+
+	if *walDir != "" {
+		return restoreUntil(ctx, cfg, f, filepath.Join(*walDir, wal.FileName), cut)
+	}
+
+	eng, err := kvi.Open(cfg)
+	if err != nil {
+		return err
+	}
+	strategy := backup.Replace
+	if *merge {
+		strategy = backup.Merge
+	}
+	res, err := backup.Restore(ctx, eng, f, strategy)
+	if closeErr := eng.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("Restored %d records (%d skipped as not newer, %d expired), removed %d", res.Restored, res.Skipped, res.Expired, res.Removed)
+	return nil
+}
+
+// restoreUntil restores the engine cfg opens to the backup in base and the
+// log at walPath, up to cut.
+func restoreUntil(ctx context.Context, cfg *config.Config, base io.ReadSeeker, walPath string, cut wal.Cut) error {
+	walFile, err := os.Open(walPath)
+	if err != nil {
+		return err
+	}
+	defer walFile.Close()
+
+	eng, err := kvi.Open(cfg)
+	if err != nil {
+		return err
+	}
+	res, err := engine.RecoverUntil(ctx, eng, base, walFile, cut)
+	if closeErr := eng.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	logRestore(res)
+	return nil
+}
+
+// logRestore reports a point-in-time restore: the backup, then the cut
+// point and what the log held past it.
+func logRestore(res engine.PointInTime) {
+	log.Printf("Restored %d records from the backup at LSN %d (%d expired), removed %d",
+		res.Backup.Restored, res.BaseLSN, res.Backup.Expired, res.Backup.Removed)
+	if res.Replayed == 0 {
+		log.Printf("Replayed no WAL entries (%d at or before the backup's LSN)", res.Skipped)
+	} else {
+		log.Printf("Replayed %d WAL entries through LSN %d, written %s", res.Replayed, res.LastLSN, res.LastTime.UTC().Format(time.RFC3339Nano))
+	}
+	if res.Discarded > 0 {
+		log.Printf("Discarded %d later entries, from LSN %d written %s", res.Discarded, res.NextLSN, res.NextTime.UTC().Format(time.RFC3339Nano))
+	}
+}
+
+// sameDir reports whether a and b name the same directory.
+func sameDir(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	return err == nil && os.SameFile(ai, bi)
+}
+
+// openBackup opens the backup at loc. One in object storage is downloaded
+// to a temporary file, removed on close, which a restore can read twice.
+func openBackup(ctx context.Context, loc string, s3 config.S3Config) (io.ReadSeekCloser, error) {
+	if !strings.HasPrefix(loc, "s3://") {
+		return os.Open(loc)
+	}
+	dest, name := backup.SplitLocation(loc)
+	store, err := backup.NewStore(dest, s3)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp("", "kvi-restore-*")
+	if err != nil {
+		return nil, err
+	}
+	spool := &spoolFile{f}
+	meta, err := backup.Fetch(ctx, store, name, spool)
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spool.Close()
+		return nil, err
+	}
+	log.Printf("Downloaded %s (sha256 %s)", loc, meta.Checksum)
+	return spool, nil
+}
+
+// spoolFile is a temporary file deleted on Close.
+type spoolFile struct{ *os.File }
+
+func (f *spoolFile) Close() error {
+	return errors.Join(f.File.Close(), os.Remove(f.Name()))
+}
+
+// errVerifyFailed ends a --verify whose report lists problems; the report
+// has said why.
+var errVerifyFailed = errors.New("backup failed verification")
+
+// printVerify prints a --verify report: the backup, and with plan what
+// restoring it would do.
+func printVerify(path string, report *backup.Report, plan *backup.Result, asJSON bool) error {
+	if asJSON {
+		out := struct {
+			File    string         `json:"file"`
+			Backup  *backup.Report `json:"backup"`
+			Restore *backup.Result `json:"restore,omitempty"`
+		}{path, report, plan}
+		if err := writeJSON(os.Stdout, out); err != nil {
+			return err
+		}
+	} else {
+		w := os.Stdout
+		fmt.Fprintf(w, "backup:   %s (%d bytes, sha256 %s)\n", path, report.Bytes, report.Checksum)
+		fmt.Fprintf(w, "format:   %s v%d, written %s\n", report.Format, report.Version, report.CreatedAt.Format(time.RFC3339))
+		if report.LSN > 0 {
+			fmt.Fprintf(w, "wal:      taken at LSN %d\n", report.LSN)
+		}
+		fmt.Fprintf(w, "records:  %d (%d expired, %d with vectors)\n", report.Records, report.Expired, report.Vectors)
+		if plan != nil {
+			fmt.Fprintf(w, "restore:  would write %d, skip %d as not newer, remove %d\n", plan.Restored, plan.Skipped, plan.Removed)
+		}
+		if report.OK() {
+			fmt.Fprintln(w, "result:   OK")
+		} else {
+			fmt.Fprintln(w, "result:   FAILED")
+			for _, p := range report.Problems {
+				fmt.Fprintf(w, "          - %s\n", p)
+			}
+		}
+	}
+	if !report.OK() {
+		return errVerifyFailed
+	}
+	return nil
+}
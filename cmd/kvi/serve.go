@@ -0,0 +1,635 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/internal/replication"
+	"github.com/thirawat27/kvi/internal/sql"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/auth"
+	"github.com/thirawat27/kvi/pkg/cdc"
+	"github.com/thirawat27/kvi/pkg/client"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/keygen"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/resp"
+	"github.com/thirawat27/kvi/pkg/retention"
+	"github.com/thirawat27/kvi/pkg/shadow"
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func runServe(args []string) error {
+	fs := newFlagSet("serve", "[flags]", "Run the REST and gRPC servers until SIGINT or SIGTERM.")
+	ef := addEngineFlags(fs)
+	port := fs.Int("port", 8080, "REST API port")
+	grpcPort := fs.Int("grpc-port", 50051, "gRPC port (0 disables the gRPC API)")
+	grpcOnly := fs.Bool("grpc-only", false, "Serve only the gRPC API")
+	authOn := fs.Bool("auth", false, "Enable JWT authentication on all routes")
+	printConfig := fs.Bool("print-config", false, "Print the effective configuration, secrets redacted, and exit")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return &usageError{fs: fs, msg: "unexpected arguments " + strings.Join(fs.Args(), " ")}
+	}
+
+	// ── Load config ──────────────────────────────────────────────────────────
+	// load is also how a reload re-reads it, so flags keep overriding the
+	// file and the environment
+	load := func() (*config.Config, error) {
+		cfg, err := ef.load()
+		if err != nil {
+			return nil, err
+		}
+		fs.Visit(func(fl *flag.Flag) {
+			switch fl.Name {
+			case "port":
+				cfg.Port = *port
+			case "grpc-port":
+				cfg.GrpcPort = *grpcPort
+			}
+		})
+		// Checked before binding any port; kvi.Open would only catch it after
+		if err := cfg.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid config: %w", err)
+		}
+		return cfg, nil
+	}
+	cfg, err := load()
+	if err != nil {
+		return err
+	}
+	if *grpcOnly && cfg.GrpcPort == 0 {
+		return &usageError{fs: fs, msg: "--grpc-only needs a gRPC port"}
+	}
+	if *printConfig {
+		return cfg.Dump(os.Stdout)
+	}
+	// Everything below logs here, the engine included
+	logLevel := new(slog.LevelVar)
+	logger, err := cfg.NewLogger(os.Stdout, logLevel)
+	if err != nil {
+		return fmt.Errorf("invalid log configuration: %w", err)
+	}
+	cfg.Logger = logger
+	logger.Info("configuration loaded", "precedence", "flags > environment > file > defaults", "config", cfg)
+	for _, w := range cfg.Warnings() {
+		logger.Warn(w)
+	}
+
+	// Shared pub/sub hub (REST + gRPC share it); nil leaves pub/sub out
+	var hub *pubsub.Hub
+	if cfg.EnablePubSub {
+		hub = pubsub.NewHub(pubsub.WithLogger(logger))
+	}
+
+	// ── Middleware ────────────────────────────────────────────────────────────
+	opts := []func(*api.Server){}
+	grpcCalls := stats.NewCalls()
+	// Both APIs count into the same pools, so the limits are server-wide
+	conns, streams := stats.NewPool(cfg.MaxConnections), stats.NewPool(cfg.MaxStreams)
+	middleware := kvi_grpc.Middleware{Calls: grpcCalls, Conns: conns, Streams: streams}
+	var authenticator *auth.Authenticator
+	var randomSecret []byte // signs tokens while no jwt_secret is set
+	if *authOn {
+		secret, ttl, keys, err := authSettings(cfg, &randomSecret)
+		if err == nil {
+			authenticator, err = auth.New(secret, ttl, keys)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot enable authentication: %w", err)
+		}
+		logger.Info("JWT authentication enabled", "api_keys", len(cfg.APIKeys))
+		opts = append(opts, api.WithAuth(authenticator))
+		middleware.Auth = authenticator
+	}
+	opts = append(opts, api.WithLogger(logger), api.WithGrpcCalls(grpcCalls), api.WithConnLimits(conns, streams))
+	middleware.Logger = logger
+	middleware.SlowThreshold = time.Duration(cfg.SlowRequestMs) * time.Millisecond
+	snapshotDest := cfg.Backup.SnapshotDest
+	if snapshotDest == "" {
+		snapshotDest = filepath.Join(cfg.DataDir, "snapshots")
+	}
+	snapshots, err := backup.NewStore(snapshotDest, cfg.Backup.S3)
+	if err != nil {
+		return fmt.Errorf("backup.snapshot_dest: %w", err)
+	}
+	opts = append(opts, api.WithSnapshotStore(snapshots), api.WithBackupS3(cfg.Backup.S3),
+		api.WithTimeouts(api.Timeouts{
+			Read:  time.Duration(cfg.ReadTimeoutMs) * time.Millisecond,
+			Write: time.Duration(cfg.WriteTimeoutMs) * time.Millisecond,
+			Query: time.Duration(cfg.QueryTimeoutMs) * time.Millisecond,
+		}), api.WithHub(hub))
+	opts = append(opts, rateLimits(cfg), api.WithMirror(cfg.Mirror))
+	opts = append(opts, liveSettings(cfg)...)
+	if cfg.Mirror.Enabled() {
+		logger.Info("mirroring requests", "target", cfg.Mirror.Target, "sample_percent", cfg.Mirror.SamplePercent, "methods", cfg.Mirror.Methods)
+	}
+
+	// ── Listen ────────────────────────────────────────────────────────────────
+	// Every port is bound before any API starts, so a port in use stops
+	// startup rather than leaving some APIs running without the others.
+	var restLis, grpcLis, respLis net.Listener
+	closeListeners := func() {
+		for _, l := range []net.Listener{restLis, grpcLis, respLis} {
+			if l != nil {
+				l.Close()
+			}
+		}
+	}
+	askedPort, askedGrpcPort := cfg.Port, cfg.GrpcPort
+	if !*grpcOnly {
+		if restLis, err = net.Listen("tcp", fmt.Sprintf(":%d", cfg.Port)); err != nil {
+			return fmt.Errorf("REST listen: %w", err)
+		}
+		cfg.Port = restLis.Addr().(*net.TCPAddr).Port
+	}
+	if cfg.GrpcPort != 0 {
+		if grpcLis, err = net.Listen("tcp", fmt.Sprintf(":%d", cfg.GrpcPort)); err != nil {
+			closeListeners()
+			return fmt.Errorf("gRPC listen: %w", err)
+		}
+		cfg.GrpcPort = grpcLis.Addr().(*net.TCPAddr).Port
+	}
+	if cfg.RESPPort != 0 {
+		if respLis, err = net.Listen("tcp", fmt.Sprintf(":%d", cfg.RESPPort)); err != nil {
+			closeListeners()
+			return fmt.Errorf("RESP listen: %w", err)
+		}
+	}
+
+	// ── Open engine ──────────────────────────────────────────────────────────
+	eng, err := kvi.Open(cfg)
+	if err != nil {
+		closeListeners()
+		return fmt.Errorf("failed to open engine: %w", err)
+	}
+	if cfg.PublishExpired && hub != nil {
+		if err := kvi.PublishExpired(eng, hub); err != nil {
+			logger.Warn("cannot publish expired records", "error", err)
+		}
+	}
+
+	banner(cfg, restLis != nil, grpcLis != nil, respLis != nil)
+
+	// One key generator for both APIs, so the keys they make up sort together
+	keys, _ := keygen.New(cfg.KeyGenerator, eng) // cfg is validated
+	opts = append(opts, api.WithKeyGenerator(keys))
+	grpcOpts := []func(*kvi_grpc.GrpcServer){kvi_grpc.WithKeyGenerator(keys)}
+
+	// ── Replication ──────────────────────────────────────────────────────────
+	var follower *replication.Follower
+	if cfg.ReplicaOf != "" {
+		if follower, err = startFollower(cfg, eng); err != nil {
+			eng.Close()
+			closeListeners()
+			return err
+		}
+		opts = append(opts, api.WithReplica(api.Replica{Stats: follower.Stats, Promote: follower.Promote}))
+		grpcOpts = append(grpcOpts, kvi_grpc.WithReplicaStats(follower.Stats))
+		logger.Info("following primary; writes are refused until promoted", "primary", cfg.ReplicaOf)
+	}
+
+	// ── Change data capture ──────────────────────────────────────────────────
+	var pipeline *cdc.CDC
+	if len(cfg.CDC.Webhooks) > 0 {
+		if pipeline, err = startCDC(cfg, eng); err != nil {
+			if follower != nil {
+				follower.Stop()
+			}
+			eng.Close()
+			closeListeners()
+			return err
+		}
+		opts = append(opts, api.WithCDCStats(pipeline.Stats))
+		grpcOpts = append(grpcOpts, kvi_grpc.WithCDCStats(pipeline.Stats))
+		logger.Info("change data capture started", "webhooks", len(cfg.CDC.Webhooks))
+	}
+
+	// ── Query cache ──────────────────────────────────────────────────────────
+	var queryCache *sql.Cache
+	if cfg.QueryCacheTTLMs > 0 {
+		if queryCache, err = sql.NewCache(eng, time.Duration(cfg.QueryCacheTTLMs)*time.Millisecond, cfg.QueryCacheEntries); err != nil {
+			if pipeline != nil {
+				pipeline.Stop()
+			}
+			if follower != nil {
+				follower.Stop()
+			}
+			eng.Close()
+			closeListeners()
+			return fmt.Errorf("query_cache_ttl_ms: %w", err)
+		}
+		opts = append(opts, api.WithQueryCache(queryCache))
+	}
+
+	// ── Live queries ─────────────────────────────────────────────────────────
+	// One set for both servers, so a client's limit counts both
+	if live, err := sql.NewLiveQueries(eng, time.Duration(cfg.LiveQueryDebounceMs)*time.Millisecond, cfg.LiveQueryMaxPerClient); err == nil {
+		opts = append(opts, api.WithLiveQueries(live))
+		grpcOpts = append(grpcOpts, kvi_grpc.WithLiveQueries(live))
+	}
+
+	// ── Retention ────────────────────────────────────────────────────────────
+	rules := retention.New(eng, retention.Options{
+		Interval:  time.Duration(cfg.RetentionIntervalMs) * time.Millisecond,
+		BatchSize: cfg.RetentionBatchSize,
+		Logger:    logger,
+	})
+	opts = append(opts, api.WithRetention(rules))
+
+	// ── Shadow reads ─────────────────────────────────────────────────────────
+	var comparison *shadow.Shadow
+	var shadowSrc io.Closer
+	if cfg.Shadow.Enabled() {
+		if comparison, shadowSrc, err = startShadow(cfg); err != nil {
+			if pipeline != nil {
+				pipeline.Stop()
+			}
+			if follower != nil {
+				follower.Stop()
+			}
+			rules.Close()
+			eng.Close()
+			closeListeners()
+			return err
+		}
+		opts = append(opts, api.WithShadow(comparison))
+		grpcOpts = append(grpcOpts, kvi_grpc.WithShadow(comparison))
+		logger.Info("comparing reads against a shadow", "source", comparison.Stats().Source, "rate_per_sec", cfg.Shadow.RatePerSec)
+	}
+
+	// A server that fails brings the other down, as a signal would
+	failed := make(chan error, 3)
+
+	// ── Reload ───────────────────────────────────────────────────────────────
+	// On SIGHUP or POST /api/v1/admin/reload the config is loaded again and
+	// what can change live is applied; the rest waits for a restart
+	var restSrv *api.Server
+	var current atomic.Pointer[config.Config]
+	current.Store(cfg)
+	var reloading sync.Mutex
+	reload := func() (*config.ReloadReport, error) {
+		reloading.Lock()
+		defer reloading.Unlock()
+
+		next, err := load()
+		if err != nil {
+			return nil, err
+		}
+		running := current.Load()
+		// Ports the system picked for a 0 are not changes
+		if next.Port == askedPort {
+			next.Port = running.Port
+		}
+		if next.GrpcPort == askedGrpcPort {
+			next.GrpcPort = running.GrpcPort
+		}
+		merged, report := config.Reload(running, next)
+		if authenticator != nil {
+			secret, ttl, keys, err := authSettings(merged, &randomSecret)
+			if err == nil {
+				err = authenticator.Update(secret, ttl, keys)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := logLevel.UnmarshalText([]byte(merged.LogLevel)); err != nil {
+			return nil, err
+		}
+		conns.SetLimit(merged.MaxConnections)
+		if fencer, ok := eng.(types.DiskFencer); ok {
+			fencer.SetDiskFence(uint64(merged.DiskFenceMB) << 20)
+		}
+		streams.SetLimit(merged.MaxStreams)
+		if restSrv != nil {
+			// Limits and mirroring are only set when they change, keeping
+			// what was set through the admin API otherwise
+			live := liveSettings(merged)
+			var limits, mirroring bool
+			for _, c := range report.Applied {
+				limits = limits || strings.HasSuffix(c.Key, "_rate_limit") || strings.HasSuffix(c.Key, "_burst")
+				mirroring = mirroring || strings.HasPrefix(c.Key, "mirror.")
+			}
+			if limits {
+				live = append(live, rateLimits(merged))
+			}
+			if mirroring {
+				live = append(live, api.WithMirror(merged.Mirror))
+			}
+			restSrv.Reconfigure(live...)
+		}
+		current.Store(merged)
+		logger.Info("configuration reloaded", "applied", changedKeys(report.Applied), "restart_required", changedKeys(report.RestartRequired))
+		return report, nil
+	}
+	opts = append(opts, api.WithConfig(api.ConfigSource{Current: current.Load, Reload: reload}))
+
+	// ── REST API server ───────────────────────────────────────────────────────
+	if restLis != nil {
+		restSrv = api.NewServer(eng, opts...)
+		logger.Info("REST API listening", "url", fmt.Sprintf("http://0.0.0.0:%d", cfg.Port))
+		go func() {
+			if err := restSrv.Serve(restLis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				failed <- fmt.Errorf("REST server error: %w", err)
+			}
+		}()
+	}
+
+	// ── gRPC server ───────────────────────────────────────────────────────────
+	grpcCtx, stopGrpc := context.WithCancel(context.Background())
+	grpcDone := make(chan struct{})
+	if grpcLis != nil {
+		logger.Info("gRPC API listening", "url", fmt.Sprintf("grpc://0.0.0.0:%d", cfg.GrpcPort))
+		go func() {
+			defer close(grpcDone)
+			grpcOpts = append(grpcOpts, kvi_grpc.WithCalls(grpcCalls), kvi_grpc.WithConnLimits(conns, streams),
+				kvi_grpc.WithMaxBatch(cfg.GrpcMaxBatch), kvi_grpc.WithMaxScanRows(cfg.GrpcMaxScanRows), kvi_grpc.WithLogger(logger))
+			if err := kvi_grpc.StartGRPCServer(grpcCtx, grpcLis, kvi_grpc.NewGrpcServer(eng, hub, grpcOpts...),
+				kvi_grpc.Interceptors(middleware)...); err != nil {
+				failed <- fmt.Errorf("gRPC server error: %w", err)
+			}
+		}()
+	} else {
+		close(grpcDone)
+	}
+
+	// ── RESP server ───────────────────────────────────────────────────────────
+	respCtx, stopRESP := context.WithCancel(context.Background())
+	respDone := make(chan struct{})
+	if respLis != nil {
+		logger.Info("RESP listening", "url", fmt.Sprintf("redis://0.0.0.0:%d", cfg.RESPPort))
+		respOpts := []func(*resp.Server){resp.WithConnLimits(conns, streams), resp.WithMaxBulk(cfg.MaxRequestBytes), resp.WithLogger(logger)}
+		if authenticator != nil {
+			respOpts = append(respOpts, resp.WithAuth(authenticator))
+		}
+		go func() {
+			defer close(respDone)
+			if err := resp.NewServer(eng, hub, respOpts...).Serve(respCtx, respLis); err != nil {
+				failed <- fmt.Errorf("RESP server error: %w", err)
+			}
+		}()
+	} else {
+		close(respDone)
+	}
+
+	// ── Graceful shutdown ─────────────────────────────────────────────────────
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var runErr error
+wait:
+	for {
+		select {
+		case <-hup:
+			if _, err := reload(); err != nil {
+				logger.Error("configuration reload failed; nothing changed", "err", err)
+			}
+		case <-quit:
+			break wait
+		case runErr = <-failed:
+			logger.Error("server failed", "err", runErr)
+			break wait
+		}
+	}
+
+	logger.Info("shutting down REST, gRPC and RESP APIs")
+	stopGrpc() // gRPC health turns NOT_SERVING while both drain
+	stopRESP()
+	if restSrv != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := restSrv.Shutdown(ctx); err != nil {
+			logger.Error("REST shutdown failed", "err", err)
+		}
+		cancel()
+	}
+	<-grpcDone
+	<-respDone
+
+	if follower != nil { // stops applying changes before the engine closes
+		follower.Stop()
+	}
+	if queryCache != nil {
+		queryCache.Close()
+	}
+	rules.Close() // before the engine, as a run may be deleting
+	logger.Info("closing engine")
+	if err := eng.Close(); err != nil {
+		logger.Error("engine close failed", "err", err)
+	}
+	if pipeline != nil { // after the engine, so every change it logged is captured
+		pipeline.Stop()
+	}
+	if comparison != nil {
+		comparison.Close()
+		if err := shadowSrc.Close(); err != nil {
+			logger.Error("shadow close failed", "err", err)
+		}
+	}
+	if runErr != nil {
+		return runErr
+	}
+	logger.Info("stopped")
+	return nil
+}
+
+// startFollower makes eng a read-only follower of cfg.ReplicaOf and starts
+// it syncing.
+func startFollower(cfg *config.Config, eng types.Engine) (*replication.Follower, error) {
+	copts := []func(*client.Client){client.WithGRPC(cfg.ReplicaOf)}
+	if cfg.ReplicaHTTP != "" {
+		copts = append(copts, client.WithHTTP(cfg.ReplicaHTTP))
+	}
+	if cfg.ReplicaAPIKey != "" {
+		copts = append(copts, client.WithAPIKey(cfg.ReplicaAPIKey))
+	}
+	c, err := client.New(copts...)
+	if err != nil {
+		return nil, fmt.Errorf("replica_of: %w", err)
+	}
+	f, err := replication.New(eng, c, cfg.ReplicaOf, replication.WithLogger(cfg.Log()))
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("replica_of: %w", err)
+	}
+	f.Start()
+	return f, nil
+}
+
+// startCDC starts pushing eng's changes to the webhooks of cfg, keeping
+// the outbox and cursors under data_dir.
+func startCDC(cfg *config.Config, eng types.Engine) (*cdc.CDC, error) {
+	var sinks []cdc.Sink
+	for _, u := range cfg.CDC.Webhooks {
+		sinks = append(sinks, cdc.NewWebhook(u, cfg.CDC.WebhookSecret))
+	}
+	pipeline, err := cdc.New(eng, filepath.Join(cfg.DataDir, "cdc"), sinks,
+		cdc.WithLogger(cfg.Log()),
+		cdc.WithBatch(cfg.CDC.BatchSize, time.Duration(cfg.CDC.BatchWaitMs)*time.Millisecond),
+		cdc.WithMaxOutbox(int64(cfg.CDC.MaxOutboxMB)<<20))
+	if err == nil {
+		err = pipeline.Start()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cdc: %w", err)
+	}
+	return pipeline, nil
+}
+
+// startShadow opens the shadow source of cfg, read-only, and starts
+// comparing reads against it. The source is the caller's to close after
+// the comparison.
+func startShadow(cfg *config.Config) (*shadow.Shadow, io.Closer, error) {
+	sc := cfg.Shadow
+	var src interface {
+		shadow.Reader
+		io.Closer
+	}
+	name := sc.URL
+	if sc.URL != "" {
+		copts := []func(*client.Client){client.WithHTTP(sc.URL)}
+		if sc.APIKey != "" {
+			copts = append(copts, client.WithAPIKey(sc.APIKey))
+		}
+		c, err := client.New(copts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("shadow.url: %w", err)
+		}
+		src = c
+	} else {
+		// The shadow's own copy of the settings, opened as it is: a
+		// mistyped path must fail rather than compare against nothing
+		shadowCfg := *cfg
+		shadowCfg.DataDir, shadowCfg.VerifyOnStart = sc.DataDir, false
+		if sc.Mode != "" {
+			shadowCfg.Mode = sc.Mode
+		}
+		eng, err := kvi.OpenReadOnly(&shadowCfg)
+		if err != nil {
+			return nil, nil, fmt.Errorf("shadow.data_dir: %w", err)
+		}
+		src, name = eng, sc.DataDir
+	}
+	return shadow.New(src, name, shadow.Options{
+		RatePerSec: sc.RatePerSec,
+		Queue:      sc.Queue,
+		Samples:    sc.Samples,
+		Timeout:    time.Duration(sc.TimeoutMs) * time.Millisecond,
+	}), src, nil
+}
+
+// authSettings reads the authenticator's settings from cfg. Without a
+// configured secret it signs with *random, generated on first use, so
+// tokens die with the process.
+func authSettings(cfg *config.Config, random *[]byte) ([]byte, time.Duration, auth.CredentialValidator, error) {
+	secret := []byte(cfg.JWTSecret)
+	if len(secret) == 0 {
+		cfg.Log().Warn("no jwt_secret (or KVI_JWT_SECRET) set; using a random secret")
+		if *random == nil {
+			*random = make([]byte, 32)
+			if _, err := rand.Read(*random); err != nil {
+				return nil, 0, nil, err
+			}
+		}
+		secret = *random
+	}
+
+	keys := make(map[string]auth.Role, len(cfg.APIKeys))
+	for key, name := range cfg.APIKeys {
+		role, err := auth.ParseRole(name)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		keys[key] = role
+	}
+	if len(keys) == 0 {
+		cfg.Log().Warn("no api_keys configured; no client can obtain a token")
+	}
+	return secret, time.Duration(cfg.JWTExpiryMinutes) * time.Minute, auth.StaticKeys(keys), nil
+}
+
+// liveSettings are the REST server's options that a reload can change; see
+// api.Server.Reconfigure.
+func liveSettings(cfg *config.Config) []func(*api.Server) {
+	opts := []func(*api.Server){
+		api.WithAccessLog(api.AccessLog{
+			Logger:             cfg.Logger,
+			SlowThreshold:      time.Duration(cfg.SlowRequestMs) * time.Millisecond,
+			SampleFailedBodies: cfg.LogFailedBodies,
+		}),
+		api.WithCORS(cfg.CORS),
+		api.WithBodyLimits(cfg.MaxRequestBytes, cfg.MaxImportBytes),
+		api.WithCompression(cfg.CompressionLevel, cfg.CompressMinBytes),
+	}
+	if cfg.Mode == types.ModeDisk || cfg.Mode == types.ModeHybrid {
+		opts = append(opts, api.WithDiskCheck(cfg.DataDir, uint64(cfg.MinFreeDiskMB)<<20))
+	}
+	return opts
+}
+
+// rateLimits is the REST server's rate limits option. A reload applies it
+// only when the limits changed, keeping any set since through
+// /api/v1/admin/rate-limits.
+func rateLimits(cfg *config.Config) func(*api.Server) {
+	return api.WithRateLimits(
+		api.RateLimit{RPS: cfg.ReadRateLimit, Burst: cfg.ReadBurst},
+		api.RateLimit{RPS: cfg.WriteRateLimit, Burst: cfg.WriteBurst},
+	)
+}
+
+func changedKeys(changes []config.Change) []string {
+	keys := make([]string, len(changes))
+	for i, c := range changes {
+		keys[i] = c.Key
+	}
+	return keys
+}
+
+func banner(cfg *config.Config, rest, grpc, redis bool) {
+	fmt.Println()
+	fmt.Println("  ██╗  ██╗██╗   ██╗██╗")
+	fmt.Println("  ██║ ██╔╝██║   ██║██║")
+	fmt.Println("  █████╔╝ ██║   ██║██║")
+	fmt.Println("  ██╔═██╗ ╚██╗ ██╔╝██║")
+	fmt.Println("  ██║  ██╗ ╚████╔╝ ██║")
+	fmt.Println("  ╚═╝  ╚═╝  ╚═══╝  ╚═╝")
+	fmt.Printf("  Kinetic Virtual Index  v%s\n\n", version)
+	fmt.Printf("  Mode     : %s\n", cfg.Mode)
+	fmt.Printf("  DataDir  : %s\n", cfg.DataDir)
+	if rest {
+		fmt.Printf("  REST     : http://0.0.0.0:%d\n", cfg.Port)
+	} else {
+		fmt.Println("  REST     : disabled")
+	}
+	if grpc {
+		fmt.Printf("  gRPC     : grpc://0.0.0.0:%d\n", cfg.GrpcPort)
+	} else {
+		fmt.Println("  gRPC     : disabled")
+	}
+	if redis {
+		fmt.Printf("  RESP     : redis://0.0.0.0:%d\n", cfg.RESPPort)
+	}
+	fmt.Printf("  Started  : %s\n\n", time.Now().Format(time.RFC3339))
+}
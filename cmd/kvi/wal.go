@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func runWal(args []string) error {
+	global, rest := splitGlobal(args)
+	fs := newFlagSet("wal", "inspect|repair [flags]", "Examine or repair the write-ahead log.\n\n"+
+		"  inspect   List entries with their checksum status, or summarize them with --stats\n"+
+		"  repair    Cut the log before its first damaged entry, keeping a backup")
+	if len(rest) == 0 {
+		return &usageError{fs: fs, msg: "expected inspect or repair"}
+	}
+	switch rest[0] {
+	case "inspect":
+		return runWalInspect(append(global, rest[1:]...))
+	case "repair":
+		return runWalRepair(append(global, rest[1:]...))
+	case "-h", "-help", "--help":
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	return &usageError{fs: fs, msg: fmt.Sprintf("unknown action %q", rest[0])}
+}
+
+// walPath resolves the log to work on: --path, else the log in the
+// configured data directory.
+func walPath(ef *engineFlags, path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	cfg, err := ef.load()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cfg.DataDir, wal.FileName), nil
+}
+
+func runWalInspect(args []string) error {
+	fs := newFlagSet("wal inspect", "[flags]", "List the entries of the write-ahead log. Damaged entries are always listed;\n"+
+		"the filters apply to intact ones. Read-only: safe while a server is running.")
+	ef := addEngineFlags(fs)
+	path := fs.String("path", "", "Log file (default: kvi.wal in the data directory)")
+	key := fs.String("key", "", "Only entries for this key")
+	prefix := fs.String("prefix", "", "Only entries for keys with this prefix")
+	op := fs.String("op", "", "Only entries of this operation: PUT | DELETE | BATCH | EXPIRE | RENAME")
+	since := fs.String("since", "", "Only entries written at or after this RFC 3339 time")
+	until := fs.String("until", "", "Only entries written before this RFC 3339 time")
+	stats := fs.Bool("stats", false, "Print a summary instead of the entries")
+	asJSON := fs.Bool("json", false, "Print JSON: one object per entry, or the summary")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return &usageError{fs: fs, msg: "unexpected arguments " + strings.Join(fs.Args(), " ")}
+	}
+	filter := walFilter{key: *key, prefix: *prefix, op: types.Operation(strings.ToUpper(*op))}
+	for _, t := range []struct {
+		flag, value string
+		into        *time.Time
+	}{{"since", *since, &filter.since}, {"until", *until, &filter.until}} {
+		if t.value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339Nano, t.value)
+		if err != nil {
+			return &usageError{fs: fs, msg: fmt.Sprintf("--%s: %v", t.flag, err)}
+		}
+		*t.into = parsed
+	}
+
+	p, err := walPath(ef, *path)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if *stats {
+		sum := newWalSummary(p)
+		if err := wal.Scan(f, func(frame wal.Frame) bool {
+			if !frame.Valid() || filter.match(frame.Entry) {
+				sum.add(frame)
+			}
+			return true
+		}); err != nil {
+			return err
+		}
+		if *asJSON {
+			return writeJSON(os.Stdout, sum)
+		}
+		sum.writeText(os.Stdout)
+		return nil
+	}
+
+	out := io.Writer(os.Stdout)
+	var tw *tabwriter.Writer
+	if !*asJSON {
+		tw = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		out = tw
+		fmt.Fprintln(tw, "OFFSET\tLSN\tTIME\tOP\tKEY\tBYTES\tCHECKSUM")
+	}
+	enc := json.NewEncoder(out)
+	var writeErr error
+	err = wal.Scan(f, func(frame wal.Frame) bool {
+		if frame.Valid() && !filter.match(frame.Entry) {
+			return true
+		}
+		line := walLine(frame)
+		if *asJSON {
+			writeErr = enc.Encode(line)
+		} else {
+			key := orDash(line.Key)
+			if line.From != "" {
+				key = line.From + " -> " + key
+			}
+			_, writeErr = fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%d\t%s\n",
+				line.Offset, orDash(line.LSN), orDash(line.Time), orDash(line.Op), key, line.Bytes, line.Checksum)
+		}
+		return writeErr == nil
+	})
+	if tw != nil {
+		writeErr = errors.Join(writeErr, tw.Flush())
+	}
+	return errors.Join(err, writeErr)
+}
+
+type walFilter struct {
+	key, prefix  string
+	op           types.Operation
+	since, until time.Time
+}
+
+func (f walFilter) match(e *wal.LogEntry) bool {
+	at := time.Unix(0, e.Timestamp)
+	return (f.key == "" || e.Key == f.key || e.From == f.key) &&
+		strings.HasPrefix(e.Key, f.prefix) &&
+		(f.op == "" || e.Op == f.op) &&
+		(f.since.IsZero() || !at.Before(f.since)) &&
+		(f.until.IsZero() || at.Before(f.until))
+}
+
+// walEntryLine is one listed frame. Fields the frame does not carry are
+// empty.
+type walEntryLine struct {
+	Offset   int64  `json:"offset"`
+	LSN      string `json:"lsn,omitempty"`
+	Time     string `json:"time,omitempty"`
+	Op       string `json:"op,omitempty"`
+	Key      string `json:"key,omitempty"`
+	From     string `json:"from,omitempty"` // the key a RENAME moved from
+	Bytes    int64  `json:"bytes"`
+	Checksum string `json:"checksum"` // "ok" or the problem
+}
+
+func walLine(frame wal.Frame) walEntryLine {
+	line := walEntryLine{Offset: frame.Offset, Bytes: frame.Size, Checksum: "ok"}
+	if frame.Err != nil {
+		line.Checksum = "BAD: " + frame.Err.Error()
+	}
+	if e := frame.Entry; e != nil {
+		line.LSN = fmt.Sprint(e.LSN)
+		line.Time = time.Unix(0, e.Timestamp).UTC().Format(time.RFC3339Nano)
+		line.Op, line.Key, line.From = string(e.Op), e.Key, e.From
+	}
+	return line
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// walSummary is the --stats report.
+type walSummary struct {
+	Path      string         `json:"path"`
+	Entries   int            `json:"entries"` // intact entries
+	Invalid   int            `json:"invalid"`
+	Problems  map[string]int `json:"problems,omitempty"` // invalid frames by error
+	Ops       map[string]int `json:"ops"`
+	Keys      int            `json:"keys"`
+	FirstLSN  uint64         `json:"first_lsn,omitempty"`
+	LastLSN   uint64         `json:"last_lsn,omitempty"`
+	FirstTime *time.Time     `json:"first_time,omitempty"`
+	LastTime  *time.Time     `json:"last_time,omitempty"`
+	Bytes     int64          `json:"bytes"`
+
+	keys map[string]struct{}
+}
+
+func newWalSummary(path string) *walSummary {
+	return &walSummary{Path: path, Problems: map[string]int{}, Ops: map[string]int{}, keys: map[string]struct{}{}}
+}
+
+func (s *walSummary) add(frame wal.Frame) {
+	s.Bytes += frame.Size
+	if !frame.Valid() {
+		s.Invalid++
+		s.Problems[rootCause(frame.Err)]++
+		return
+	}
+	e := frame.Entry
+	s.Entries++
+	s.Ops[string(e.Op)]++
+	s.keys[e.Key] = struct{}{}
+	s.Keys = len(s.keys)
+	if s.Entries == 1 {
+		s.FirstLSN = e.LSN
+	}
+	s.LastLSN = e.LSN
+	at := time.Unix(0, e.Timestamp).UTC()
+	if s.FirstTime == nil || at.Before(*s.FirstTime) {
+		s.FirstTime = &at
+	}
+	if s.LastTime == nil || at.After(*s.LastTime) {
+		s.LastTime = &at
+	}
+}
+
+// rootCause names an invalid frame by its sentinel, dropping the detail.
+func rootCause(err error) string {
+	for _, sentinel := range []error{wal.ErrChecksum, wal.ErrMalformed, wal.ErrTruncated, wal.ErrBadLength} {
+		if errors.Is(err, sentinel) {
+			return sentinel.Error()
+		}
+	}
+	return err.Error()
+}
+
+func (s *walSummary) writeText(w io.Writer) {
+	fmt.Fprintf(w, "log:      %s (%d bytes)\n", s.Path, s.Bytes)
+	fmt.Fprintf(w, "entries:  %d intact, %d invalid\n", s.Entries, s.Invalid)
+	for _, problem := range sortedKeys(s.Problems) {
+		fmt.Fprintf(w, "          %d × %s\n", s.Problems[problem], problem)
+	}
+	ops := sortedKeys(s.Ops)
+	counts := make([]string, len(ops))
+	for i, op := range ops {
+		counts[i] = fmt.Sprintf("%s %d", op, s.Ops[op])
+	}
+	fmt.Fprintf(w, "ops:      %s\n", strings.Join(counts, ", "))
+	fmt.Fprintf(w, "keys:     %d distinct\n", s.Keys)
+	if s.Entries > 0 {
+		fmt.Fprintf(w, "lsn:      %d … %d\n", s.FirstLSN, s.LastLSN)
+		fmt.Fprintf(w, "written:  %s … %s\n", s.FirstTime.Format(time.RFC3339), s.LastTime.Format(time.RFC3339))
+	}
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func runWalRepair(args []string) error {
+	fs := newFlagSet("wal repair", "[flags]", "Cut the write-ahead log before its first damaged entry. The original is\n"+
+		"copied to --backup first; an intact log is left alone.\n"+offlineNote)
+	ef := addEngineFlags(fs)
+	path := fs.String("path", "", "Log file (default: kvi.wal in the data directory)")
+	backup := fs.String("backup", "", "Where to copy the original (default: the log's path plus .bak-<time>)")
+	asJSON := fs.Bool("json", false, "Print the result as JSON")
+	if err := parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return &usageError{fs: fs, msg: "unexpected arguments " + strings.Join(fs.Args(), " ")}
+	}
+	p, err := walPath(ef, *path)
+	if err != nil {
+		return err
+	}
+	if *backup == "" {
+		*backup = p + ".bak-" + time.Now().UTC().Format("20060102T150405Z")
+	}
+
+	res, err := wal.Repair(p, *backup)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		out := struct {
+			wal.RepairResult
+			Backup string `json:"backup,omitempty"` // unset when nothing was repaired
+		}{RepairResult: res}
+		if res.Dropped > 0 {
+			out.Backup = *backup
+		}
+		return writeJSON(os.Stdout, out)
+	}
+	if res.Dropped == 0 {
+		log.Printf("%s is intact (%d entries); nothing to repair", p, res.Kept)
+		return nil
+	}
+	log.Printf("Backed up %s to %s", p, *backup)
+	log.Printf("Kept %d entries (%d bytes); dropped %d frames (%d bytes), %d of them intact entries after the damage",
+		res.Kept, res.KeptBytes, res.Dropped, res.DroppedBytes, res.DroppedValid)
+	return nil
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,373 @@
+// Package backup reads and writes the portable Kvi backup format: a gzip
+// stream holding a JSON header line followed by the records, one JSON
+// record per line or, when the header names another codec, one frame per
+// record: its length as 4 bytes, little-endian, then the record.
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/format"
+	"github.com/thirawat27/kvi/pkg/codec"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+const (
+	// Format identifies the stream in its header line.
+	Format = "kvi-backup"
+	// Version is the current format version. Version 2 added the codec;
+	// JSON backups are still written as version 1, which any reader of
+	// the format reads.
+	Version = 2
+)
+
+// spec is the format's history, shared with kvi formats.
+var spec = format.Backup
+
+// ErrBadFormat is returned when a stream is not a Kvi backup.
+var ErrBadFormat = errors.New("not a kvi backup stream")
+
+// ErrInvalid is returned by Restore when the stream fails validation, in
+// which case nothing was changed.
+var ErrInvalid = errors.New("invalid backup")
+
+// Header is the first line of every backup. Backups from before the
+// minimum reader version was recorded leave it 0, for Version.
+type Header struct {
+	format.Header
+	Codec string `json:"codec,omitempty"` // "" for JSON
+	// LSN is the engine's last logged change when the dump began: every
+	// change up to it is in the backup, so a point-in-time restore replays
+	// the WAL from the entry after it. 0 for an engine without a log.
+	LSN uint64 `json:"lsn,omitempty"`
+}
+
+// Summary describes a finished dump. Checksum is the hex SHA-256 of the
+// bytes written, i.e. of the compressed stream exactly as stored or sent.
+type Summary struct {
+	Records  int    `json:"records"`
+	Bytes    int64  `json:"bytes"`
+	Checksum string `json:"checksum"`
+}
+
+// Dump streams every record of eng to w, encoded with the engine's codec
+// if it has one (see types.RecordCoder). Records are written as the scan
+// yields them, so memory use does not grow with the data set; the dump is
+// not a point-in-time snapshot if writes continue meanwhile, though the
+// header records where the engine's WAL stood when it began.
+func Dump(ctx context.Context, eng types.Engine, w io.Writer) (Summary, error) {
+	h := sha256.New()
+	cw := &countingWriter{w: io.MultiWriter(w, h)}
+	zw := gzip.NewWriter(cw)
+	enc := json.NewEncoder(zw)
+
+	var sum Summary
+	c, err := codecOf(eng)
+	if err != nil {
+		return sum, err
+	}
+	hdr := Header{Header: spec.Stamp(1)}
+	if c != codec.JSON {
+		hdr.Header, hdr.Codec = spec.Stamp(Version), c.Name()
+	}
+	if ls, ok := eng.(types.LogShipper); ok {
+		hdr.LSN = ls.LSN()
+	}
+	if err := enc.Encode(hdr); err != nil {
+		return sum, err
+	}
+	var writeErr error
+	err = eng.Scan(ctx, "", func(rec *types.Record) bool {
+		if c == codec.JSON {
+			writeErr = enc.Encode(rec)
+		} else {
+			writeErr = writeFrame(zw, c, rec)
+		}
+		if writeErr != nil {
+			return false
+		}
+		sum.Records++
+		return true
+	})
+	if writeErr != nil {
+		return sum, writeErr
+	}
+	if err != nil {
+		return sum, err
+	}
+	if err := zw.Close(); err != nil {
+		return sum, err
+	}
+	sum.Bytes = cw.n
+	sum.Checksum = hex.EncodeToString(h.Sum(nil))
+	return sum, nil
+}
+
+// codecOf returns the codec eng is configured with, JSON if none.
+func codecOf(eng types.Engine) (codec.Codec, error) {
+	if rc, ok := eng.(types.RecordCoder); ok {
+		return codec.Lookup(rc.RecordCodec())
+	}
+	return codec.JSON, nil
+}
+
+func writeFrame(w io.Writer, c codec.Codec, rec *types.Record) error {
+	data, err := c.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(data)))
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Read decodes a backup stream, in whichever codec it was written with,
+// calling fn for each record in order. A stream of a format version this
+// binary cannot read fails with a *format.TooNewError.
+func Read(r io.Reader, fn func(*types.Record) error) (Header, error) {
+	var hdr Header
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return hdr, fmt.Errorf("%w: %v", ErrBadFormat, err)
+	}
+	defer zr.Close()
+
+	br := bufio.NewReader(zr)
+	dec := json.NewDecoder(br)
+	if err := dec.Decode(&hdr); err != nil || hdr.Format != Format {
+		return hdr, ErrBadFormat
+	}
+	if err := spec.Check(hdr.Header); err != nil {
+		return hdr, err
+	}
+	c, err := codec.Lookup(hdr.Codec)
+	if err != nil {
+		return hdr, fmt.Errorf("%w: %v", ErrBadFormat, err)
+	}
+	if c != codec.JSON {
+		return hdr, readFrames(bufio.NewReader(io.MultiReader(dec.Buffered(), br)), c, fn)
+	}
+	for {
+		var rec types.Record
+		if err := dec.Decode(&rec); err == io.EOF {
+			return hdr, nil
+		} else if err != nil {
+			return hdr, fmt.Errorf("corrupt backup record: %w", err)
+		}
+		if err := fn(&rec); err != nil {
+			return hdr, err
+		}
+	}
+}
+
+// readFrames reads the records after the header line of a backup written
+// with c, which is not JSON.
+func readFrames(r *bufio.Reader, c codec.Codec, fn func(*types.Record) error) error {
+	if b, err := r.ReadByte(); err != nil || b != '\n' {
+		return fmt.Errorf("corrupt backup record: no newline after the header")
+	}
+	var length [4]byte
+	for {
+		if _, err := io.ReadFull(r, length[:]); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("corrupt backup record: %w", err)
+		}
+		data := make([]byte, binary.LittleEndian.Uint32(length[:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			return fmt.Errorf("corrupt backup record: %w", err)
+		}
+		var rec types.Record
+		if err := c.Unmarshal(data, &rec); err != nil {
+			return fmt.Errorf("corrupt backup record: %w", err)
+		}
+		if err := fn(&rec); err != nil {
+			return err
+		}
+	}
+}
+
+// Load writes every record of a backup stream into eng and returns how many
+// were restored. Records keep their TTL; ones already expired are skipped.
+func Load(ctx context.Context, eng types.Engine, r io.Reader) (int, error) {
+	var n int
+	now := time.Now()
+	_, err := Read(r, func(rec *types.Record) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if rec.Expired(now) {
+			return nil
+		}
+		if err := eng.Put(ctx, rec.ID, rec); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// Strategy is how Restore combines a backup with the records stored.
+type Strategy string
+
+const (
+	// Replace deletes every stored record first, leaving exactly the
+	// backup's.
+	Replace Strategy = "replace"
+	// Merge applies the backup on top of the stored records: each of its
+	// records is written unless the store holds that key at the same or a
+	// newer Version. Keys the backup lacks are kept.
+	Merge Strategy = "merge"
+)
+
+// ParseStrategy reads a strategy name; "" is Replace.
+func ParseStrategy(s string) (Strategy, error) {
+	switch Strategy(s) {
+	case "", Replace:
+		return Replace, nil
+	case Merge:
+		return Merge, nil
+	}
+	return "", fmt.Errorf("restore mode must be %s or %s", Replace, Merge)
+}
+
+// Result counts what a restore did, or for DryRun would do.
+type Result struct {
+	Restored int `json:"restored"`
+	Skipped  int `json:"skipped"` // merge: stored at the same or a newer version
+	Expired  int `json:"expired"` // past their TTL, so not restored
+	Removed  int `json:"removed"` // replace: stored records deleted first
+}
+
+// Restore loads the backup in spool into eng by strategy. The whole stream
+// is validated first, with Verify against the engine's vector dimension,
+// and nothing is touched if it fails (ErrInvalid). Afterwards the engine's
+// gc task, if it has one, removes records that expired meanwhile. On any
+// later error the result counts what was done before it.
+func Restore(ctx context.Context, eng types.Engine, spool io.ReadSeeker, strategy Strategy) (Result, error) {
+	return restore(ctx, eng, spool, strategy, false)
+}
+
+// DryRun validates spool as Restore does and reports what restoring it
+// would do, without writing anything.
+func DryRun(ctx context.Context, eng types.Engine, spool io.ReadSeeker, strategy Strategy) (Result, error) {
+	return restore(ctx, eng, spool, strategy, true)
+}
+
+func restore(ctx context.Context, eng types.Engine, spool io.ReadSeeker, strategy Strategy, dry bool) (Result, error) {
+	var res Result
+	report, err := Verify(spool, Expect{VectorDim: VectorDim(eng)})
+	if err == nil && !report.OK() {
+		err = errors.New(strings.Join(report.Problems, "; "))
+	}
+	if err != nil {
+		return res, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return res, err
+	}
+	if strategy == Replace {
+		if res.Removed, err = clearAll(ctx, eng, dry); err != nil {
+			return res, err
+		}
+	}
+
+	now := time.Now()
+	_, err = Read(spool, func(rec *types.Record) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if rec.Expired(now) {
+			res.Expired++
+			return nil
+		}
+		if strategy == Merge {
+			stored, err := eng.Get(ctx, rec.ID)
+			switch {
+			case err == nil && stored.Version >= rec.Version:
+				res.Skipped++
+				return nil
+			case err != nil && !errors.Is(err, types.ErrKeyNotFound):
+				return err
+			}
+		}
+		if !dry {
+			if err := eng.Put(ctx, rec.ID, rec); err != nil {
+				return err
+			}
+		}
+		res.Restored++
+		return nil
+	})
+	if err != nil || dry {
+		return res, err
+	}
+	return res, collectGarbage(ctx, eng)
+}
+
+// collectGarbage runs eng's gc maintenance task, if it has one.
+func collectGarbage(ctx context.Context, eng types.Engine) error {
+	m, ok := eng.(types.Maintainer)
+	if !ok {
+		return nil
+	}
+	if gc := m.Maintenance()[types.MaintenanceGC]; gc != nil {
+		return gc(ctx, func(done, total int) {})
+	}
+	return nil
+}
+
+// clearAll deletes every record, collecting keys first so the scan is not
+// disturbed by its own deletes. With dry it only counts them.
+func clearAll(ctx context.Context, eng types.Engine, dry bool) (int, error) {
+	var keys []string
+	if err := eng.Scan(ctx, "", func(rec *types.Record) bool {
+		keys = append(keys, rec.ID)
+		return true
+	}); err != nil {
+		return 0, err
+	}
+	if dry {
+		return len(keys), nil
+	}
+	for _, k := range keys {
+		if err := eng.Delete(ctx, k); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// Checksum returns the hex SHA-256 of everything read from r.
+func Checksum(r io.Reader) (string, int64, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	return hex.EncodeToString(h.Sum(nil)), n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
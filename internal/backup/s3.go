@@ -0,0 +1,475 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/config"
+)
+
+const (
+	// minPartSize is the smallest part S3 accepts, but for the last.
+	minPartSize = 5 << 20
+	// maxCopySize is the most a single CopyObject can copy; larger
+	// backups are copied in parts.
+	maxCopySize  = 5 << 30
+	copyPartSize = 1 << 30
+
+	metaChecksum = "X-Amz-Meta-Kvi-Checksum"
+	metaRecords  = "X-Amz-Meta-Kvi-Records"
+
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// S3Store keeps backups in a bucket of an S3-compatible object store,
+// under a key prefix. Backups are uploaded in parts, each retried on its
+// own, and their checksum and record count are kept as object metadata.
+type S3Store struct {
+	cfg      config.S3Config
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	partSize int
+	client   *http.Client
+}
+
+// NewS3Store returns the store for bucket and key prefix, reached with
+// cfg. Requests are signed (AWS Signature Version 4) when cfg has an
+// access key and anonymous otherwise.
+func NewS3Store(bucket, prefix string, cfg config.S3Config) (*S3Store, error) {
+	if bucket == "" {
+		return nil, errors.New("s3: no bucket given")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	raw := cfg.Endpoint
+	if raw == "" {
+		raw = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(raw)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("s3: endpoint %q is not an http or https URL", cfg.Endpoint)
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3Store{
+		cfg:      cfg,
+		endpoint: endpoint,
+		bucket:   bucket,
+		prefix:   prefix,
+		partSize: max(cfg.PartSizeMB<<20, minPartSize),
+		client:   &http.Client{},
+	}, nil
+}
+
+func (s *S3Store) Location(name string) string {
+	return "s3://" + s.bucket + "/" + s.prefix + name
+}
+
+func (s *S3Store) Create(ctx context.Context, name string) (Upload, error) {
+	return &s3Upload{s: s, ctx: ctx, key: s.prefix + name}, nil
+}
+
+func (s *S3Store) Open(ctx context.Context, name string) (io.ReadCloser, Meta, error) {
+	var meta Meta
+	resp, err := s.do(ctx, http.MethodGet, s.prefix+name, nil, nil, nil)
+	if err != nil {
+		return nil, meta, err
+	}
+	meta.Checksum = resp.Header.Get(metaChecksum)
+	meta.Records, _ = strconv.Atoi(resp.Header.Get(metaRecords))
+	return resp.Body, meta, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, name string) error {
+	return s.call(ctx, http.MethodDelete, s.prefix+name, nil, nil, nil, nil)
+}
+
+// s3Upload holds one part in memory at a time. A backup that fits in one
+// part is sent with a single PUT; a larger one is uploaded in parts to
+// KEY.partial, then copied to KEY with its metadata, so the backup never
+// appears without it.
+type s3Upload struct {
+	s        *S3Store
+	ctx      context.Context
+	key      string
+	buf      []byte
+	uploadID string
+	parts    []completedPart
+	size     int64
+	done     bool
+}
+
+type completedPart struct {
+	PartNumber int
+	ETag       string
+}
+
+func (u *s3Upload) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if u.buf == nil {
+			u.buf = make([]byte, 0, u.s.partSize)
+		}
+		room := u.s.partSize - len(u.buf)
+		take := min(room, len(p))
+		u.buf = append(u.buf, p[:take]...)
+		p = p[take:]
+		if len(u.buf) == u.s.partSize {
+			if err := u.flush(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush uploads the buffered part, starting the multipart upload with the
+// first.
+func (u *s3Upload) flush() error {
+	if u.uploadID == "" {
+		id, err := u.s.createMultipart(u.ctx, u.key+".partial", nil)
+		if err != nil {
+			return err
+		}
+		u.uploadID = id
+	}
+	number := len(u.parts) + 1
+	var etag string
+	err := u.s.call(u.ctx, http.MethodPut, u.key+".partial", partQuery(u.uploadID, number), nil, u.buf, func(resp *http.Response) error {
+		etag = resp.Header.Get("ETag")
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("uploading part %d: %w", number, err)
+	}
+	u.parts = append(u.parts, completedPart{PartNumber: number, ETag: etag})
+	u.size += int64(len(u.buf))
+	u.buf = u.buf[:0]
+	return nil
+}
+
+func (u *s3Upload) Commit(meta Meta) error {
+	if u.done {
+		return errors.New("s3: upload already finished")
+	}
+	header := metaHeader(meta)
+	if u.uploadID == "" {
+		if err := u.s.call(u.ctx, http.MethodPut, u.key, nil, header, u.buf, nil); err != nil {
+			return err
+		}
+		u.done = true
+		return nil
+	}
+	if len(u.buf) > 0 {
+		if err := u.flush(); err != nil {
+			return err
+		}
+	}
+	partial := u.key + ".partial"
+	if err := u.s.completeMultipart(u.ctx, partial, u.uploadID, u.parts); err != nil {
+		return err
+	}
+	u.done = true // the parts are gone; what is left is the partial object
+	err := u.s.copyObject(u.ctx, partial, u.key, u.size, header)
+	if derr := u.s.call(u.ctx, http.MethodDelete, partial, nil, nil, nil, nil); err == nil {
+		err = derr
+	}
+	return err
+}
+
+func (u *s3Upload) Abort() error {
+	if u.done {
+		return nil
+	}
+	u.done = true
+	if u.uploadID == "" {
+		return nil
+	}
+	// The caller's context may be why the upload failed
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(u.ctx), time.Minute)
+	defer cancel()
+	return u.s.call(ctx, http.MethodDelete, u.key+".partial", url.Values{"uploadId": {u.uploadID}}, nil, nil, nil)
+}
+
+func metaHeader(meta Meta) http.Header {
+	h := http.Header{}
+	h.Set("Content-Type", "application/gzip")
+	h.Set(metaChecksum, meta.Checksum)
+	h.Set(metaRecords, strconv.Itoa(meta.Records))
+	return h
+}
+
+func partQuery(uploadID string, number int) url.Values {
+	return url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+}
+
+func (s *S3Store) createMultipart(ctx context.Context, key string, header http.Header) (string, error) {
+	var out struct {
+		UploadID string `xml:"UploadId"`
+	}
+	err := s.call(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, header, nil, decodeXML(&out))
+	if err == nil && out.UploadID == "" {
+		err = errors.New("s3: no upload ID in the reply")
+	}
+	return out.UploadID, err
+}
+
+func (s *S3Store) completeMultipart(ctx context.Context, key, uploadID string, parts []completedPart) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	return s.call(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body, decodeXML(nil))
+}
+
+// copyObject copies from to to in the bucket, replacing its metadata with
+// header's.
+func (s *S3Store) copyObject(ctx context.Context, from, to string, size int64, header http.Header) error {
+	source := "/" + s.bucket + "/" + uriEncode(from, false)
+	if size <= maxCopySize {
+		header.Set("X-Amz-Copy-Source", source)
+		header.Set("X-Amz-Metadata-Directive", "REPLACE")
+		return s.call(ctx, http.MethodPut, to, nil, header, nil, decodeXML(nil))
+	}
+
+	id, err := s.createMultipart(ctx, to, header)
+	if err != nil {
+		return err
+	}
+	var parts []completedPart
+	for start := int64(0); start < size; start += copyPartSize {
+		number := len(parts) + 1
+		var out struct {
+			ETag string `xml:"ETag"`
+		}
+		h := http.Header{}
+		h.Set("X-Amz-Copy-Source", source)
+		h.Set("X-Amz-Copy-Source-Range", fmt.Sprintf("bytes=%d-%d", start, min(start+copyPartSize, size)-1))
+		if err = s.call(ctx, http.MethodPut, to, partQuery(id, number), h, nil, decodeXML(&out)); err != nil {
+			break
+		}
+		parts = append(parts, completedPart{PartNumber: number, ETag: out.ETag})
+	}
+	if err == nil {
+		err = s.completeMultipart(ctx, to, id, parts)
+	}
+	if err != nil {
+		s.call(ctx, http.MethodDelete, to, url.Values{"uploadId": {id}}, nil, nil, nil)
+	}
+	return err
+}
+
+// S3Error is an error reply from the store.
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("s3: HTTP %d", e.StatusCode)
+	}
+	return fmt.Sprintf("s3: %s: %s (HTTP %d)", e.Code, e.Message, e.StatusCode)
+}
+
+// Is makes a missing key or bucket match fs.ErrNotExist.
+func (e *S3Error) Is(target error) bool {
+	return target == fs.ErrNotExist && e.StatusCode == http.StatusNotFound
+}
+
+// decodeXML reads a successful reply into out (nil to only check it).
+// Some calls report failure in the body of a 200 reply.
+func decodeXML(out any) func(*http.Response) error {
+	return func(resp *http.Response) error {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		if bytes.Contains(body, []byte("<Error>")) {
+			s3err := &S3Error{StatusCode: resp.StatusCode}
+			xml.Unmarshal(body, s3err)
+			return s3err
+		}
+		if out == nil {
+			return nil
+		}
+		return xml.Unmarshal(body, out)
+	}
+}
+
+// call makes a request whose reply is read by handle, if given, and
+// otherwise discarded.
+func (s *S3Store) call(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte, handle func(*http.Response) error) error {
+	resp, err := s.do(ctx, method, key, query, header, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if handle != nil {
+		return handle(resp)
+	}
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// do sends a request, retrying it up to cfg.Retries times with backoff
+// while the store cannot be reached or answers 5xx or 429. It returns
+// replies with a 2xx status and an *S3Error for the rest.
+func (s *S3Store) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	backoff := 200 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		resp, err := s.send(ctx, method, key, query, header, body)
+		if err == nil && resp.StatusCode/100 == 2 {
+			return resp, nil
+		}
+		retry := err != nil
+		if err == nil {
+			s3err := &S3Error{StatusCode: resp.StatusCode}
+			data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+			xml.Unmarshal(data, s3err)
+			err = s3err
+			retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		}
+		if !retry || attempt >= s.cfg.Retries || ctx.Err() != nil {
+			return nil, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, 10*time.Second)
+	}
+}
+
+func (s *S3Store) send(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	p := "/" + key
+	if s.cfg.PathStyle {
+		p = "/" + s.bucket + p
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + p
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.cfg.AccessKeyID != "" {
+		s.sign(req, body, time.Now())
+	}
+	return s.client.Do(req)
+}
+
+// sign adds AWS Signature Version 4 headers to req, signing the host and
+// every x-amz-* header.
+func (s *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	stamp := now.UTC().Format("20060102T150405Z")
+	day := stamp[:8]
+	payload := emptySHA256
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payload = hex.EncodeToString(sum[:])
+	}
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+
+	names := []string{"host"}
+	values := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-amz-") {
+			names = append(names, lk)
+			values[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + values[name] + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, headers.String(), signed, payload}, "\n")
+	scope := day + "/" + s.cfg.Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + s.cfg.SecretAccessKey)
+	for _, part := range []string{day, s.cfg.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKeyID, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query as SigV4 wants it: sorted, with every
+// reserved character escaped.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode escapes every byte but the unreserved characters, and '/'
+// unless slash is set.
+func uriEncode(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,208 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/config"
+)
+
+// ErrChecksumMismatch is returned by Fetch when a backup does not match
+// the checksum stored with it.
+var ErrChecksumMismatch = errors.New("backup checksum mismatch")
+
+// Meta is what a store keeps with a backup, from its Summary. Records is 0
+// where the store does not keep it.
+type Meta struct {
+	Checksum string
+	Records  int
+}
+
+// Store is where backups are kept: a directory (FileStore) or an
+// S3-compatible bucket (S3Store). Backups are named like files, without
+// directories.
+type Store interface {
+	// Create starts writing the backup name. It appears under that name
+	// only once the upload is committed.
+	Create(ctx context.Context, name string) (Upload, error)
+	// Open reads the backup name and what was stored with it. A backup
+	// that does not exist is an error matching fs.ErrNotExist.
+	Open(ctx context.Context, name string) (io.ReadCloser, Meta, error)
+	Delete(ctx context.Context, name string) error
+	// Location is where name is, as a path or URL, for messages.
+	Location(name string) string
+}
+
+// Upload is a backup being written to a Store.
+type Upload interface {
+	io.Writer
+	// Commit finishes the backup, storing meta with it.
+	Commit(meta Meta) error
+	// Abort discards what was written. It is a no-op after Commit.
+	Abort() error
+}
+
+// NewStore returns the store at dest: an s3://bucket/prefix URL, reached
+// with s3, or otherwise a directory.
+func NewStore(dest string, s3 config.S3Config) (Store, error) {
+	if rest, ok := strings.CutPrefix(dest, "s3://"); ok {
+		bucket, prefix, _ := strings.Cut(rest, "/")
+		store, err := NewS3Store(bucket, prefix, s3)
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	}
+	if dest == "" {
+		return nil, errors.New("no backup destination")
+	}
+	return NewFileStore(dest), nil
+}
+
+// SplitLocation splits the location of one backup, a file path or an
+// s3://bucket/key URL, into the store holding it and its name there.
+func SplitLocation(loc string) (dest, name string) {
+	if strings.HasPrefix(loc, "s3://") {
+		return path.Split(loc)
+	}
+	return filepath.Split(loc)
+}
+
+// NewName names a backup taken at t, as snapshots are named.
+func NewName(t time.Time) string {
+	return fmt.Sprintf("kvi-%s.kvibak", t.UTC().Format("20060102-150405.000"))
+}
+
+// Save writes a backup to store as name: dump writes the stream, and the
+// upload is committed with its summary if it succeeds and aborted if not.
+func Save(ctx context.Context, store Store, name string, dump func(io.Writer) (Summary, error)) (Summary, error) {
+	up, err := store.Create(ctx, name)
+	if err != nil {
+		return Summary{}, err
+	}
+	sum, err := dump(up)
+	if err == nil {
+		err = up.Commit(Meta{Checksum: sum.Checksum, Records: sum.Records})
+	}
+	if err != nil {
+		up.Abort()
+		return sum, err
+	}
+	return sum, nil
+}
+
+// Fetch copies the backup name from store to w and checks it against the
+// checksum stored with it, if there is one. What was written to w must be
+// discarded when it fails. It returns the stored Meta, with Checksum set
+// to that of the bytes copied.
+func Fetch(ctx context.Context, store Store, name string, w io.Writer) (Meta, error) {
+	rc, meta, err := store.Open(ctx, name)
+	if err != nil {
+		return meta, err
+	}
+	defer rc.Close()
+	checksum, _, err := Checksum(io.TeeReader(rc, w))
+	if err != nil {
+		return meta, fmt.Errorf("reading %s: %w", store.Location(name), err)
+	}
+	if meta.Checksum != "" && !strings.EqualFold(meta.Checksum, checksum) {
+		return meta, fmt.Errorf("%w: %s was stored with sha256 %s, read %s", ErrChecksumMismatch, store.Location(name), meta.Checksum, checksum)
+	}
+	meta.Checksum = checksum
+	return meta, nil
+}
+
+// FileStore keeps backups in a directory, each with its checksum alongside
+// in a NAME.sha256 file that sha256sum -c can check.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns the store in dir, which is created on first write.
+func NewFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+func (s *FileStore) Location(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+// Create writes to NAME.partial, renamed on commit.
+func (s *FileStore) Create(_ context.Context, name string) (Upload, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	path := s.Location(name)
+	f, err := os.Create(path + ".partial")
+	if err != nil {
+		return nil, err
+	}
+	return &fileUpload{f: f, path: path}, nil
+}
+
+func (s *FileStore) Open(_ context.Context, name string) (io.ReadCloser, Meta, error) {
+	var meta Meta
+	path := s.Location(name)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, meta, err
+	}
+	if sum, err := os.ReadFile(path + ".sha256"); err == nil {
+		meta.Checksum, _, _ = strings.Cut(strings.TrimSpace(string(sum)), " ")
+	}
+	return f, meta, nil
+}
+
+func (s *FileStore) Delete(_ context.Context, name string) error {
+	path := s.Location(name)
+	if err := os.Remove(path + ".sha256"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.Remove(path)
+}
+
+type fileUpload struct {
+	f    *os.File
+	path string
+	done bool
+}
+
+func (u *fileUpload) Write(p []byte) (int, error) { return u.f.Write(p) }
+
+// Commit syncs the backup and writes its checksum file before renaming it
+// into place, so a backup that exists is complete.
+func (u *fileUpload) Commit(meta Meta) error {
+	err := u.f.Sync()
+	if cerr := u.f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && meta.Checksum != "" {
+		line := fmt.Sprintf("%s  %s\n", meta.Checksum, filepath.Base(u.path))
+		err = os.WriteFile(u.path+".sha256", []byte(line), 0644)
+	}
+	if err == nil {
+		err = os.Rename(u.f.Name(), u.path)
+	}
+	if err != nil {
+		os.Remove(u.f.Name())
+		return err
+	}
+	u.done = true
+	return nil
+}
+
+func (u *fileUpload) Abort() error {
+	if u.done {
+		return nil
+	}
+	u.done = true
+	u.f.Close()
+	return os.Remove(u.f.Name())
+}
@@ -0,0 +1,88 @@
+package backup
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ReadHeader reads the header line of a backup stream, failing like Read
+// for one that is not a backup, or too new for this binary to read.
+func ReadHeader(r io.Reader) (Header, error) {
+	hdr, _, err := readHeaderLine(r)
+	return hdr, err
+}
+
+func readHeaderLine(r io.Reader) (Header, *bufio.Reader, error) {
+	var hdr Header
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return hdr, nil, fmt.Errorf("%w: %v", ErrBadFormat, err)
+	}
+	br := bufio.NewReader(zr)
+	line, err := br.ReadBytes('\n')
+	if err != nil || json.Unmarshal(line, &hdr) != nil || hdr.Format != Format {
+		return hdr, nil, ErrBadFormat
+	}
+	return hdr, br, spec.Check(hdr.Header)
+}
+
+// UpgradeFile rewrites the backup at path in place with a header that
+// records its minimum reader version, which backups written before it was
+// recorded lack; the records are kept as they are. A NAME.sha256 file
+// beside it is rewritten to match. It reports whether there was anything
+// to do.
+func UpgradeFile(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	hdr, br, err := readHeaderLine(f)
+	if err != nil || hdr.MinReader > 0 {
+		return false, err
+	}
+	hdr.MinReader, hdr.MinRelease = hdr.Version, spec.Release(hdr.Version)
+
+	tmp, err := os.OpenFile(path+".upgrade", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	h := sha256.New()
+	zw := gzip.NewWriter(io.MultiWriter(tmp, h))
+	err = json.NewEncoder(zw).Encode(hdr)
+	if err == nil {
+		_, err = io.Copy(zw, br)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+
+	f.Close() // Windows cannot rename over an open file
+	if _, err := os.Stat(path + ".sha256"); err == nil {
+		line := fmt.Sprintf("%s  %s\n", hex.EncodeToString(h.Sum(nil)), filepath.Base(path))
+		if err := os.WriteFile(path+".sha256", []byte(line), 0644); err != nil {
+			return false, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	return true, os.Rename(tmp.Name(), path)
+}
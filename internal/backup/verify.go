@@ -0,0 +1,109 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// Expect is what Verify checks a backup against. Zero fields are not
+// checked.
+type Expect struct {
+	Checksum  string // hex SHA-256 of the stream, as Dump reports it
+	Records   int
+	VectorDim int // length of every "vector" field
+}
+
+// Report describes a backup stream.
+type Report struct {
+	Header
+	Records  int    `json:"records"`
+	Expired  int    `json:"expired"` // already past their TTL; restoring skips them
+	Vectors  int    `json:"vectors"` // records with a "vector" field
+	Bytes    int64  `json:"bytes"`
+	Checksum string `json:"checksum"`
+	// Problems lists every way the stream failed Expect. A backup with
+	// problems is readable but should not be restored as it is.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// OK reports whether the backup met every expectation.
+func (r *Report) OK() bool { return len(r.Problems) == 0 }
+
+// Verify reads a whole backup stream and checks it against exp. It fails
+// only if the stream is unreadable: not a backup, of a newer format
+// version, or cut short or corrupt. Mismatches with exp are listed in the
+// report instead.
+func Verify(r io.Reader, exp Expect) (*Report, error) {
+	h := sha256.New()
+	cr := &countingReader{r: io.TeeReader(r, h)}
+	report := &Report{}
+	wrongDim, example := 0, ""
+	now := time.Now()
+
+	hdr, err := Read(cr, func(rec *types.Record) error {
+		report.Records++
+		if rec.Expired(now) {
+			report.Expired++
+		}
+		if len(rec.Vector) == 0 {
+			return nil
+		}
+		report.Vectors++
+		if dim := len(rec.Vector); exp.VectorDim > 0 && dim != exp.VectorDim {
+			if wrongDim++; example == "" {
+				example = fmt.Sprintf("%s has %d", rec.ID, dim)
+			}
+		}
+		return nil
+	})
+	report.Header = hdr
+	if err != nil {
+		return report, err
+	}
+	// The gzip reader may stop short of trailing bytes; they are part of
+	// the stream all the same
+	if _, err := io.Copy(io.Discard, cr); err != nil {
+		return report, err
+	}
+	report.Bytes = cr.n
+	report.Checksum = hex.EncodeToString(h.Sum(nil))
+
+	if exp.Checksum != "" && !strings.EqualFold(exp.Checksum, report.Checksum) {
+		report.Problems = append(report.Problems, fmt.Sprintf("checksum is %s, expected %s", report.Checksum, exp.Checksum))
+	}
+	if exp.Records > 0 && report.Records != exp.Records {
+		report.Problems = append(report.Problems, fmt.Sprintf("holds %d records, expected %d", report.Records, exp.Records))
+	}
+	if wrongDim > 0 {
+		report.Problems = append(report.Problems, fmt.Sprintf("%d vectors are not of dimension %d (%s)", wrongDim, exp.VectorDim, example))
+	}
+	return report, nil
+}
+
+// VectorDim returns the dimension of eng's vector index, or 0 if it has
+// none or does not say.
+func VectorDim(eng types.Engine) int {
+	if sr, ok := eng.(types.StatsReporter); ok {
+		if v := sr.Stats().Vector; v != nil {
+			return v.Dim
+		}
+	}
+	return 0
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,382 @@
+// Package bench drives synthetic workloads against an engine or a remote
+// server and measures throughput, latency and, for vector search, recall.
+package bench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// Workload is a benchmark preset.
+type Workload string
+
+const (
+	WriteOnly Workload = "write"  // puts over the keyspace
+	ReadOnly  Workload = "read"   // gets of loaded keys
+	Mixed     Workload = "mixed"  // 95% gets, 5% puts
+	ScanHeavy Workload = "scan"   // scans of 100-key ranges
+	Vector    Workload = "vector" // k-nearest-neighbour searches
+)
+
+// Workloads lists the presets.
+var Workloads = []Workload{WriteOnly, ReadOnly, Mixed, ScanHeavy, Vector}
+
+// Target is what a benchmark drives. Both types.Engine and the client
+// library's *client.Client satisfy it; the vector preset also needs
+// types.Searcher.
+type Target interface {
+	Put(ctx context.Context, key string, rec *types.Record) error
+	Get(ctx context.Context, key string) (*types.Record, error)
+	Scan(ctx context.Context, prefix string, fn func(*types.Record) bool) error
+}
+
+// Options configure a run. Keys is the size of the keyspace; every preset
+// but WriteOnly loads it before the timed phase.
+type Options struct {
+	Workload    Workload
+	Keys        int
+	ValueSize   int // bytes of payload per record
+	Concurrency int
+	Duration    time.Duration
+	Seed        uint64
+	// Zipf skews the key choice: 0 picks keys uniformly, and an exponent
+	// above 1 draws them from a Zipf distribution, lowest keys hottest.
+	Zipf float64
+
+	// Vector preset: Dim-dimensional embeddings around Clusters centres
+	// (see Embeddings), searched for K neighbours. Recall is measured on
+	// RecallQueries of the searches against an exact scan.
+	Dim           int
+	Clusters      int
+	Spread        float64
+	K             int
+	RecallQueries int
+	// Selectivity, a percentage above 0, gives each record a tenant field
+	// shared by that share of the records, and filters each search on one
+	// tenant (types.FilteredSearcher). FilterPushdown keeps posting lists
+	// for the field, narrowing the searches up front; without it they
+	// check the records they find.
+	Selectivity    float64
+	FilterPushdown bool
+}
+
+// TenantField is the field the vector preset filters searches on.
+const TenantField = "tenant"
+
+// DefaultOptions returns the options used unless flags change them.
+func DefaultOptions() Options {
+	return Options{
+		Workload:       Mixed,
+		Keys:           100_000,
+		ValueSize:      256,
+		Concurrency:    8,
+		Duration:       10 * time.Second,
+		Seed:           1,
+		Dim:            128,
+		Clusters:       32,
+		Spread:         0.1,
+		K:              10,
+		RecallQueries:  100,
+		FilterPushdown: true,
+	}
+}
+
+// Validate reports the first option that cannot work.
+func (o Options) Validate() error {
+	switch {
+	case !isWorkload(o.Workload):
+		return fmt.Errorf("unknown workload %q", o.Workload)
+	case o.Keys <= 0:
+		return errors.New("keys must be > 0")
+	case o.ValueSize < 0:
+		return errors.New("value size must be >= 0")
+	case o.Concurrency <= 0:
+		return errors.New("concurrency must be > 0")
+	case o.Duration <= 0:
+		return errors.New("duration must be > 0")
+	case o.Zipf != 0 && o.Zipf <= 1:
+		return errors.New("zipf exponent must be 0 (uniform) or > 1")
+	}
+	if o.Workload == Vector {
+		switch {
+		case o.Dim <= 0:
+			return errors.New("vector dimension must be > 0")
+		case o.Clusters <= 0:
+			return errors.New("clusters must be > 0")
+		case o.Spread < 0:
+			return errors.New("spread must be >= 0")
+		case o.K <= 0:
+			return errors.New("k must be > 0")
+		case o.Selectivity < 0 || o.Selectivity > 100:
+			return errors.New("selectivity must be between 0 and 100")
+		}
+	}
+	return nil
+}
+
+func isWorkload(w Workload) bool {
+	for _, known := range Workloads {
+		if w == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Key names record i of the keyspace. Keys sort in index order, so the
+// hundred sharing all but their last two digits form one scan range.
+func Key(i int) string { return fmt.Sprintf("bench:%08d", i) }
+
+// Run loads the keyspace if the workload reads it, then runs the workload
+// for o.Duration or until ctx ends.
+func Run(ctx context.Context, target Target, o Options) (*Report, error) {
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	b := &runner{target: target, o: o, payload: strings.Repeat("x", o.ValueSize)}
+	if o.Workload == Vector {
+		s, ok := target.(types.Searcher)
+		if !ok {
+			return nil, errors.New("the vector workload needs a target that can search vectors")
+		}
+		b.searcher = s
+		b.embeddings = NewEmbeddings(o.Dim, o.Clusters, o.Spread, o.Seed)
+		if o.Selectivity > 0 {
+			fs, ok := target.(types.FilteredSearcher)
+			if !ok {
+				return nil, errors.New("a selective vector workload needs a target that can filter vector searches")
+			}
+			var fields []string
+			if o.FilterPushdown {
+				fields = []string{TenantField}
+			}
+			if err := fs.SetFilterFields(fields); err != nil {
+				return nil, err
+			}
+			b.filtered = fs
+			b.tenants = max(int(math.Round(100/o.Selectivity)), 1)
+		}
+	}
+
+	report := &Report{Workload: o.Workload, Concurrency: o.Concurrency, Keys: o.Keys}
+	if b.filtered != nil {
+		report.Selectivity, report.FilterPushdown = 100/float64(b.tenants), o.FilterPushdown
+	}
+	if o.Workload != WriteOnly {
+		start := time.Now()
+		if err := b.load(ctx); err != nil {
+			return nil, fmt.Errorf("loading %d keys: %w", o.Keys, err)
+		}
+		report.LoadSeconds = time.Since(start).Seconds()
+	}
+	// An embedded engine shares the heap, so its memory use shows there
+	eng, embedded := target.(types.StatsReporter)
+	if embedded {
+		report.HeapLoadedMB = heapMB()
+	}
+
+	ops, elapsed := b.run(ctx)
+	report.finish(ops, elapsed)
+	if embedded {
+		report.HeapEndMB = heapMB()
+		report.Cache = eng.Stats().Cache
+	}
+	if o.Workload == Vector && o.RecallQueries > 0 {
+		recall, err := b.recall(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("measuring recall: %w", err)
+		}
+		report.Recall = &recall
+	}
+	return report, nil
+}
+
+type runner struct {
+	target     Target
+	searcher   types.Searcher
+	filtered   types.FilteredSearcher // with Selectivity
+	tenants    int                    // tenants the records are spread over
+	embeddings *Embeddings
+	o          Options
+	payload    string
+}
+
+func (b *runner) record(i int) *types.Record {
+	rec := &types.Record{ID: Key(i), Data: map[string]interface{}{"value": b.payload}}
+	if b.embeddings != nil {
+		rec.Vector = b.embeddings.Vector(i)
+	}
+	if b.filtered != nil {
+		rec.Data[TenantField] = b.tenant(i)
+	}
+	return rec
+}
+
+// tenant is the tenant of record i, and the one search i is filtered on.
+func (b *runner) tenant(i int) int { return i % b.tenants }
+
+// search runs vector search query, filtered if the workload is.
+func (b *runner) search(ctx context.Context, query int) ([]*types.Record, error) {
+	vec := b.embeddings.Query(query)
+	if b.filtered == nil {
+		return b.searcher.Search(ctx, vec, b.o.K)
+	}
+	return b.filtered.SearchFiltered(ctx, vec, b.o.K, map[string]interface{}{TenantField: b.tenant(query)})
+}
+
+// load puts every key of the keyspace, split across the workers.
+func (b *runner) load(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errs := make([]error, b.o.Concurrency)
+	for w := range b.o.Concurrency {
+		wg.Go(func() {
+			for i := w; i < b.o.Keys && errs[w] == nil; i += b.o.Concurrency {
+				if err := ctx.Err(); err != nil {
+					errs[w] = err
+					return
+				}
+				rec := b.record(i)
+				errs[w] = b.target.Put(ctx, rec.ID, rec)
+			}
+		})
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// run drives the workload from every worker until the duration is up, and
+// returns the merged per-operation stats and the time taken.
+func (b *runner) run(ctx context.Context) (map[string]*opStats, time.Duration) {
+	ctx, cancel := context.WithTimeout(ctx, b.o.Duration)
+	defer cancel()
+
+	perWorker := make([]map[string]*opStats, b.o.Concurrency)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for w := range b.o.Concurrency {
+		stats := map[string]*opStats{}
+		perWorker[w] = stats
+		rng := rand.New(rand.NewPCG(b.o.Seed, uint64(w)+1))
+		pick := func() int { return rng.IntN(b.o.Keys) }
+		if b.o.Zipf > 0 {
+			zipf := rand.NewZipf(rng, b.o.Zipf, 1, uint64(b.o.Keys-1))
+			pick = func() int { return int(zipf.Uint64()) }
+		}
+		wg.Go(func() {
+			for query := 0; ctx.Err() == nil; query++ {
+				name, took, err := b.op(ctx, rng, pick(), w+query*b.o.Concurrency)
+				if ctx.Err() != nil && err != nil {
+					return // cut short by the deadline, not a failure
+				}
+				s := stats[name]
+				if s == nil {
+					s = &opStats{}
+					stats[name] = s
+				}
+				s.add(took, err)
+			}
+		})
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	merged := map[string]*opStats{}
+	for _, stats := range perWorker {
+		for name, s := range stats {
+			if merged[name] == nil {
+				merged[name] = &opStats{}
+			}
+			merged[name].merge(s)
+		}
+	}
+	return merged, elapsed
+}
+
+// op runs one operation of the workload on key i; query numbers vector
+// searches so that no two workers repeat a query.
+func (b *runner) op(ctx context.Context, rng *rand.Rand, i, query int) (string, time.Duration, error) {
+	start := time.Now()
+	var name string
+	var err error
+	switch b.o.Workload {
+	case WriteOnly:
+		name, err = "put", b.put(ctx, i)
+	case ReadOnly:
+		name, err = "get", b.get(ctx, i)
+	case Mixed:
+		if rng.IntN(100) < 95 {
+			name, err = "get", b.get(ctx, i)
+		} else {
+			name, err = "put", b.put(ctx, i)
+		}
+	case ScanHeavy:
+		prefix := Key(i)
+		name, err = "scan", b.target.Scan(ctx, prefix[:len(prefix)-2], func(*types.Record) bool { return true })
+	case Vector:
+		name = "search"
+		_, err = b.search(ctx, query)
+	}
+	return name, time.Since(start), err
+}
+
+func (b *runner) put(ctx context.Context, i int) error {
+	rec := b.record(i)
+	return b.target.Put(ctx, rec.ID, rec)
+}
+
+func (b *runner) get(ctx context.Context, i int) error {
+	_, err := b.target.Get(ctx, Key(i))
+	return err
+}
+
+// heapMB is the live heap after a collection, in MiB.
+func heapMB() float64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return float64(m.HeapAlloc) / (1 << 20)
+}
+
+// recall is the mean fraction of the exact K nearest keys, among those of
+// the tenant filtered on if any, that searches for the first RecallQueries
+// queries return.
+func (b *runner) recall(ctx context.Context) (float64, error) {
+	queries := make([][]float32, b.o.RecallQueries)
+	for q := range queries {
+		queries[q] = b.embeddings.Query(q)
+	}
+	var keep func(q, i int) bool
+	if b.filtered != nil {
+		keep = func(q, i int) bool { return b.tenant(q) == b.tenant(i) }
+	}
+	exact := b.embeddings.NearestWhere(queries, b.o.Keys, b.o.K, keep)
+
+	var total float64
+	for q := range queries {
+		got, err := b.search(ctx, q)
+		if err != nil {
+			return 0, err
+		}
+		want := make(map[string]bool, len(exact[q]))
+		for _, i := range exact[q] {
+			want[Key(i)] = true
+		}
+		hits := 0
+		for _, rec := range got {
+			if want[rec.ID] {
+				hits++
+			}
+		}
+		total += float64(hits) / float64(len(want))
+	}
+	return total / float64(len(queries)), nil
+}
@@ -0,0 +1,125 @@
+package bench
+
+import (
+	"cmp"
+	"math"
+	"math/rand/v2"
+	"slices"
+)
+
+// Embeddings generates synthetic unit vectors grouped around random
+// cluster centres. Each coordinate of a point is its centre's plus normal
+// noise with standard deviation Spread, so a small spread gives tight,
+// well-separated clusters and a large one approaches uniform noise, the
+// hard case for approximate search. Vector(i) and Query(i) depend only on
+// the seed and i, so runs are repeatable and the dataset need not be kept.
+type Embeddings struct {
+	dim     int
+	spread  float64
+	seed    uint64
+	centres [][]float32
+}
+
+// NewEmbeddings returns a generator of dim-dimensional vectors around
+// clusters centres.
+func NewEmbeddings(dim, clusters int, spread float64, seed uint64) *Embeddings {
+	e := &Embeddings{dim: dim, spread: spread, seed: seed, centres: make([][]float32, clusters)}
+	rng := rand.New(rand.NewPCG(seed, 0))
+	for c := range e.centres {
+		centre := make([]float32, dim)
+		for d := range centre {
+			centre[d] = float32(rng.NormFloat64())
+		}
+		e.centres[c] = normalize(centre)
+	}
+	return e
+}
+
+// Vector returns the embedding stored under Key(i).
+func (e *Embeddings) Vector(i int) []float32 {
+	return e.point(rand.New(rand.NewPCG(e.seed, uint64(i)<<1|1)))
+}
+
+// Query returns search vector i, drawn from the same clusters as the
+// stored vectors but distinct from all of them.
+func (e *Embeddings) Query(i int) []float32 {
+	return e.point(rand.New(rand.NewPCG(e.seed+1, uint64(i)<<1|1)))
+}
+
+func (e *Embeddings) point(rng *rand.Rand) []float32 {
+	centre := e.centres[rng.IntN(len(e.centres))]
+	v := make([]float32, e.dim)
+	for d := range v {
+		v[d] = centre[d] + float32(rng.NormFloat64()*e.spread)
+	}
+	return normalize(v)
+}
+
+// Nearest returns, for each query, the indexes of the k vectors among
+// Vector(0) to Vector(n-1) with the highest cosine similarity, best first.
+// It is an exact scan, the ground truth recall is measured against.
+func (e *Embeddings) Nearest(queries [][]float32, n, k int) [][]int {
+	return e.NearestWhere(queries, n, k, nil)
+}
+
+// NearestWhere is Nearest over the vectors i that keep(q, i) keeps for
+// query q, or all of them if keep is nil.
+func (e *Embeddings) NearestWhere(queries [][]float32, n, k int, keep func(q, i int) bool) [][]int {
+	type hit struct {
+		i     int
+		score float32
+	}
+	best := make([][]hit, len(queries))
+	for i := range n {
+		v := e.Vector(i)
+		for q, query := range queries {
+			if keep != nil && !keep(q, i) {
+				continue
+			}
+			h := hit{i, dot(query, v)}
+			top := best[q]
+			if len(top) == k && h.score <= top[k-1].score {
+				continue
+			}
+			// Insert in order; k is small, so a shifted slice beats a heap
+			at, _ := slices.BinarySearchFunc(top, h, func(a, b hit) int { return cmp.Compare(b.score, a.score) })
+			top = slices.Insert(top, at, h)
+			if len(top) > k {
+				top = top[:k]
+			}
+			best[q] = top
+		}
+	}
+	out := make([][]int, len(queries))
+	for q, top := range best {
+		for _, h := range top {
+			out[q] = append(out[q], h.i)
+		}
+	}
+	return out
+}
+
+// normalize scales v to unit length in place, so that a dot product is
+// the cosine similarity.
+func normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return v
+	}
+	scale := float32(1 / math.Sqrt(sum))
+	for d := range v {
+		v[d] *= scale
+	}
+	return v
+}
+
+func dot(a, b []float32) float32 {
+	var sum float32
+	for d := range a {
+		sum += a[d] * b[d]
+	}
+	return sum
+}
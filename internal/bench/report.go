@@ -0,0 +1,227 @@
+package bench
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"slices"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// Report is the outcome of a run. Latencies are in milliseconds.
+type Report struct {
+	Workload    Workload `json:"workload"`
+	Target      string   `json:"target"`
+	Concurrency int      `json:"concurrency"`
+	Keys        int      `json:"keys"`
+	LoadSeconds float64  `json:"load_seconds,omitempty"`
+
+	Seconds    float64            `json:"seconds"`
+	Ops        int64              `json:"ops"`
+	Errors     int64              `json:"errors"`
+	OpsPerSec  float64            `json:"ops_per_sec"`
+	Operations map[string]OpStats `json:"operations"`
+	// Recall is the mean fraction of the exact nearest neighbours that
+	// vector searches returned.
+	Recall *float64 `json:"recall,omitempty"`
+	// Selectivity is the percentage of the records each filtered vector
+	// search could return, and FilterPushdown whether posting lists
+	// narrowed the searches to them.
+	Selectivity    float64 `json:"selectivity,omitempty"`
+	FilterPushdown bool    `json:"filter_pushdown,omitempty"`
+
+	// Embedded runs only: the live heap after loading and after the timed
+	// run, and the engine's cache if it has one, to size the cache by.
+	HeapLoadedMB float64           `json:"heap_loaded_mb,omitempty"`
+	HeapEndMB    float64           `json:"heap_end_mb,omitempty"`
+	Cache        *types.CacheStats `json:"cache,omitempty"`
+}
+
+// OpStats summarizes one kind of operation. Errors are counted in Count
+// and their latencies included.
+type OpStats struct {
+	Count     int64   `json:"count"`
+	Errors    int64   `json:"errors"`
+	OpsPerSec float64 `json:"ops_per_sec"`
+	MeanMs    float64 `json:"mean_ms"`
+	P50Ms     float64 `json:"p50_ms"`
+	P90Ms     float64 `json:"p90_ms"`
+	P99Ms     float64 `json:"p99_ms"`
+	P999Ms    float64 `json:"p999_ms"`
+	MaxMs     float64 `json:"max_ms"`
+	// FirstError is an example failure, to tell error counts apart.
+	FirstError string `json:"first_error,omitempty"`
+}
+
+func (r *Report) finish(ops map[string]*opStats, elapsed time.Duration) {
+	r.Seconds = elapsed.Seconds()
+	r.Operations = make(map[string]OpStats, len(ops))
+	for name, s := range ops {
+		stats := s.summary(elapsed)
+		r.Operations[name] = stats
+		r.Ops += stats.Count
+		r.Errors += stats.Errors
+	}
+	r.OpsPerSec = float64(r.Ops) / r.Seconds
+}
+
+// WriteText writes the report as aligned text for a terminal.
+func (r *Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "workload %s against %s, %d workers, %d keys\n", r.Workload, r.Target, r.Concurrency, r.Keys)
+	if r.LoadSeconds > 0 {
+		fmt.Fprintf(w, "loaded in %.1fs (%.0f puts/s)\n", r.LoadSeconds, float64(r.Keys)/r.LoadSeconds)
+	}
+	fmt.Fprintf(w, "%d ops in %.1fs: %.0f ops/s, %d errors\n\n", r.Ops, r.Seconds, r.OpsPerSec, r.Errors)
+	fmt.Fprintf(w, "%-7s %10s %10s %8s %9s %9s %9s %9s %9s %9s\n", "op", "count", "ops/s", "errors", "mean ms", "p50", "p90", "p99", "p99.9", "max")
+	names := make([]string, 0, len(r.Operations))
+	for name := range r.Operations {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		s := r.Operations[name]
+		fmt.Fprintf(w, "%-7s %10d %10.0f %8d %9.3f %9.3f %9.3f %9.3f %9.3f %9.3f\n",
+			name, s.Count, s.OpsPerSec, s.Errors, s.MeanMs, s.P50Ms, s.P90Ms, s.P99Ms, s.P999Ms, s.MaxMs)
+	}
+	for _, name := range names {
+		if e := r.Operations[name].FirstError; e != "" {
+			fmt.Fprintf(w, "\nfirst %s error: %s\n", name, e)
+		}
+	}
+	if r.Selectivity > 0 {
+		how := "checking the records found"
+		if r.FilterPushdown {
+			how = "through posting lists"
+		}
+		fmt.Fprintf(w, "\nsearches filtered to %.3g%% of the records, %s\n", r.Selectivity, how)
+	}
+	if r.Recall != nil {
+		fmt.Fprintf(w, "\nrecall: %.3f\n", *r.Recall)
+	}
+	if r.HeapEndMB > 0 {
+		fmt.Fprintf(w, "\nheap: %.1f MiB after load, %.1f MiB after run\n", r.HeapLoadedMB, r.HeapEndMB)
+	}
+	if c := r.Cache; c != nil {
+		fmt.Fprintf(w, "cache: %.1f of %.1f MiB, %d entries, %d evictions; memory hit ratio %.3f, disk hit ratio %.3f\n",
+			float64(c.SizeBytes)/(1<<20), float64(c.CapacityBytes)/(1<<20), c.Entries, c.Evictions, c.MemoryHitRatio, c.DiskHitRatio)
+	}
+}
+
+// opStats accumulates the latencies of one kind of operation in one
+// worker.
+type opStats struct {
+	hist     histogram
+	errors   int64
+	firstErr error
+}
+
+func (s *opStats) add(d time.Duration, err error) {
+	s.hist.add(d)
+	if err != nil {
+		s.errors++
+		if s.firstErr == nil {
+			s.firstErr = err
+		}
+	}
+}
+
+func (s *opStats) merge(o *opStats) {
+	s.hist.merge(&o.hist)
+	s.errors += o.errors
+	if s.firstErr == nil {
+		s.firstErr = o.firstErr
+	}
+}
+
+func (s *opStats) summary(elapsed time.Duration) OpStats {
+	h := &s.hist
+	out := OpStats{
+		Count:     h.count,
+		Errors:    s.errors,
+		OpsPerSec: float64(h.count) / elapsed.Seconds(),
+		P50Ms:     ms(h.quantile(0.5)),
+		P90Ms:     ms(h.quantile(0.9)),
+		P99Ms:     ms(h.quantile(0.99)),
+		P999Ms:    ms(h.quantile(0.999)),
+		MaxMs:     ms(h.max),
+	}
+	if h.count > 0 {
+		out.MeanMs = ms(time.Duration(h.sum / h.count))
+	}
+	if s.firstErr != nil {
+		out.FirstError = s.firstErr.Error()
+	}
+	return out
+}
+
+func ms(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+
+// subBuckets is how many buckets each power of two of nanoseconds is split
+// into, which bounds a quantile's error to about 1/subBuckets.
+const subBuckets = 32
+
+// histogram counts latencies in logarithmic buckets, so a run of any
+// length takes constant memory.
+type histogram struct {
+	buckets [64 * subBuckets]int64
+	count   int64
+	sum     int64
+	max     time.Duration
+}
+
+func (h *histogram) add(d time.Duration) {
+	h.buckets[bucketOf(d)]++
+	h.count++
+	h.sum += int64(d)
+	h.max = max(h.max, d)
+}
+
+func (h *histogram) merge(o *histogram) {
+	for i, n := range o.buckets {
+		h.buckets[i] += n
+	}
+	h.count += o.count
+	h.sum += o.sum
+	h.max = max(h.max, o.max)
+}
+
+// quantile returns the upper bound of the bucket holding the q-th
+// latency, capped at the largest seen.
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.count)))
+	var seen int64
+	for i, n := range h.buckets {
+		if seen += n; seen >= max(rank, 1) {
+			return min(bucketLimit(i), h.max)
+		}
+	}
+	return h.max
+}
+
+// bucketOf maps d to its bucket: the position of its top bit, then the
+// next log2(subBuckets) bits below it.
+func bucketOf(d time.Duration) int {
+	n := uint64(max(d, 0))
+	if n < subBuckets {
+		return int(n)
+	}
+	top := bits.Len64(n) - 1
+	shift := top - bits.Len64(subBuckets-1)
+	return (shift+1)*subBuckets + int(n>>shift) - subBuckets
+}
+
+// bucketLimit is the largest duration in bucket i.
+func bucketLimit(i int) time.Duration {
+	if i < subBuckets {
+		return time.Duration(i)
+	}
+	shift := i/subBuckets - 1
+	mantissa := uint64(i%subBuckets + subBuckets)
+	return time.Duration((mantissa+1)<<shift - 1)
+}
@@ -38,6 +38,9 @@ type ColumnarStore struct {
 	compression bool
 	encoder     *zstd.Encoder
 	decoder     *zstd.Decoder
+
+	rawBytes        int64 // serialized size of compressed columns
+	compressedBytes int64
 }
 
 func NewColumnarStore(blockSize int, compress bool) (*ColumnarStore, error) {
@@ -118,6 +121,49 @@ func (s *ColumnarStore) Insert(records []*types.Record) error {
 	return nil
 }
 
+// Seal compresses the open block and starts a new one, so the rows inserted
+// so far are stored compressed without waiting for the block to fill. It
+// returns the number of rows sealed.
+func (s *ColumnarStore) Seal() int {
+	if len(s.blocks) == 0 {
+		return 0
+	}
+	last := s.blocks[len(s.blocks)-1]
+	if last.Rows == 0 {
+		return 0
+	}
+	if s.compression {
+		s.compressBlock(last)
+	}
+	s.blocks = append(s.blocks, &Block{
+		ID:      len(s.blocks),
+		Columns: make(map[string]*Column),
+	})
+	return last.Rows
+}
+
+// Stats counts rows and non-empty blocks.
+func (s *ColumnarStore) Stats() types.ColumnarStats {
+	var stats types.ColumnarStats
+	for _, block := range s.blocks {
+		if block.Rows == 0 {
+			continue
+		}
+		stats.Rows += block.Rows
+		stats.Blocks++
+		for _, col := range block.Columns {
+			if col.Compressed != nil {
+				stats.CompressedBlocks++
+				break
+			}
+		}
+	}
+	if s.compressedBytes > 0 {
+		stats.CompressionRatio = float64(s.rawBytes) / float64(s.compressedBytes)
+	}
+	return stats
+}
+
 func (s *ColumnarStore) compressBlock(block *Block) {
 	for _, col := range block.Columns {
 		if len(col.Data) == 0 {
@@ -131,6 +177,8 @@ func (s *ColumnarStore) compressBlock(block *Block) {
 		}
 		compressed := s.encoder.EncodeAll(buf.Bytes(), make([]byte, 0, len(buf.Bytes())))
 		col.Compressed = compressed
+		s.rawBytes += int64(buf.Len())
+		s.compressedBytes += int64(len(compressed))
 
 		// Unset uncompressed data to save memory
 		// col.Data = nil
@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"container/list"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// hotCache keeps the books for the hybrid engine's memory tier: which keys
+// it holds, most recently used first, what they cost, and which are
+// pinned. The records themselves stay in the memory engine.
+type hotCache struct {
+	mu        sync.Mutex
+	capacity  int64 // bytes; 0 is unbounded
+	size      int64
+	order     *list.List // of *cacheEntry, most recently used first
+	entries   map[string]*list.Element
+	pinned    map[string]bool
+	evictions uint64
+
+	memoryHits, diskHits, misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	key  string
+	size int64
+}
+
+func newHotCache(capacity int64) *hotCache {
+	return &hotCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		pinned:   make(map[string]bool),
+	}
+}
+
+// add records that key now holds a record of the given size, and marks it
+// most recently used.
+func (c *hotCache) add(key string, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*cacheEntry)
+		c.size += size - entry.size
+		entry.size = size
+		c.order.MoveToFront(el)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, size: size})
+	c.size += size
+}
+
+// touch marks key most recently used.
+func (c *hotCache) touch(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.MoveToFront(el)
+	}
+}
+
+func (c *hotCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.size -= el.Value.(*cacheEntry).size
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// evict drops least recently used keys until the cache holds no more than
+// limit bytes (0 for no limit), and returns them. Pinned keys and those
+// keep reports are passed over.
+func (c *hotCache) evict(limit int64, keep func(key string) bool) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var evicted []string
+	for el := c.order.Back(); el != nil && limit > 0 && c.size > limit; {
+		entry, prev := el.Value.(*cacheEntry), el.Prev()
+		if !c.pinned[entry.key] && !keep(entry.key) {
+			c.size -= entry.size
+			c.order.Remove(el)
+			delete(c.entries, entry.key)
+			evicted = append(evicted, entry.key)
+		}
+		el = prev
+	}
+	c.evictions += uint64(len(evicted))
+	return evicted
+}
+
+func (c *hotCache) pin(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pinned[key] = true
+}
+
+func (c *hotCache) unpin(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pinned, key)
+}
+
+func (c *hotCache) pinnedKeys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := make([]string, 0, len(c.pinned))
+	for key := range c.pinned {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+func (c *hotCache) stats() *types.CacheStats {
+	c.mu.Lock()
+	stats := &types.CacheStats{
+		CapacityBytes: c.capacity,
+		SizeBytes:     c.size,
+		Entries:       len(c.entries),
+		Pinned:        len(c.pinned),
+		Evictions:     c.evictions,
+	}
+	c.mu.Unlock()
+
+	stats.MemoryHits, stats.DiskHits, stats.Misses = c.memoryHits.Load(), c.diskHits.Load(), c.misses.Load()
+	if reads := stats.MemoryHits + stats.DiskHits + stats.Misses; reads > 0 {
+		stats.MemoryHitRatio = float64(stats.MemoryHits) / float64(reads)
+	}
+	if fromDisk := stats.DiskHits + stats.Misses; fromDisk > 0 {
+		stats.DiskHitRatio = float64(stats.DiskHits) / float64(fromDisk)
+	}
+	return stats
+}
+
+// Rough per-value costs in bytes of the Go structures holding a record.
+const (
+	recordOverhead = 256 // Record, its map entry, list element and cache entry
+	valueOverhead  = 16  // an interface value
+	mapOverhead    = 256 // its header and first group of slots
+	sliceOverhead  = 24
+)
+
+// recordSize estimates the memory key's record takes in the memory tier,
+// packed or not.
+func recordSize(key string, rec *types.Record) int64 {
+	n := int64(recordOverhead + 2*len(key) + len(rec.ID) + 4*len(rec.Vector) + len(rec.Blob) + len(rec.ContentType) + len(rec.Packed))
+	for _, tag := range rec.Tags {
+		n += valueOverhead + int64(len(tag))
+	}
+	return n + valueSize(rec.Data)
+}
+
+// valueSize estimates the memory a decoded JSON value takes.
+func valueSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case string:
+		return valueOverhead + int64(len(v))
+	case map[string]interface{}:
+		n := int64(valueOverhead + mapOverhead)
+		for k, child := range v {
+			n += valueOverhead + int64(len(k)) + valueSize(child)
+		}
+		return n
+	case []interface{}:
+		n := int64(valueOverhead + sliceOverhead)
+		for _, child := range v {
+			n += valueSize(child)
+		}
+		return n
+	}
+	return valueOverhead
+}
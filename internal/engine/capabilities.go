@@ -0,0 +1,33 @@
+package engine
+
+import "github.com/thirawat27/kvi/pkg/types"
+
+func (e *MemoryEngine) Capabilities() types.Capabilities {
+	return types.Capabilities{Batch: true, Watch: true, ConsistentScan: true, TagScan: true, ChangeScan: true, TextSearch: true}
+}
+
+func (e *DiskEngine) Capabilities() types.Capabilities {
+	return types.Capabilities{Batch: true, Watch: true, ConsistentScan: true, TagScan: true, ChangeScan: true, Sync: true, TextSearch: true}
+}
+
+func (e *ColumnarEngine) Capabilities() types.Capabilities {
+	return types.Capabilities{Batch: true, Watch: true, ConsistentScan: true, TagScan: true, ChangeScan: true, TextSearch: true, Aggregate: true}
+}
+
+func (e *VectorEngine) Capabilities() types.Capabilities {
+	return types.Capabilities{Batch: true, Watch: true, ConsistentScan: true, TagScan: true, ChangeScan: true, Search: true, FilteredSearch: true, TextSearch: true, VectorRequired: true}
+}
+
+// Capabilities of the hybrid engine are its tiers' together, except that
+// records without a vector are kept out of the vector tier, not rejected.
+func (h *HybridEngine) Capabilities() types.Capabilities {
+	return types.Capabilities{Batch: true, Watch: true, ConsistentScan: true, TagScan: true, ChangeScan: true, Sync: true, Search: true, FilteredSearch: true, Pin: true, TextSearch: true, Aggregate: true}
+}
+
+var (
+	_ types.CapabilityReporter = (*MemoryEngine)(nil)
+	_ types.CapabilityReporter = (*DiskEngine)(nil)
+	_ types.CapabilityReporter = (*ColumnarEngine)(nil)
+	_ types.CapabilityReporter = (*VectorEngine)(nil)
+	_ types.CapabilityReporter = (*HybridEngine)(nil)
+)
@@ -0,0 +1,197 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/btree"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// changeItem is a key's last change in a changeIndex, ordered by when it
+// happened and then by key.
+type changeItem struct {
+	at      uint64
+	key     string
+	deleted bool
+}
+
+func (i changeItem) Less(than btree.Item) bool {
+	o := than.(changeItem)
+	if i.at != o.at {
+		return i.at < o.at
+	}
+	return i.key < o.key
+}
+
+// changeIndex orders keys by their last change for ScanSince: a put at the
+// record's UpdatedAt, a delete or expiry as a tombstone at the time it was
+// emitted. Like the tag index it lives in the feed and is not logged, so
+// the tombstones of deletes before the engine opened are gone.
+type changeIndex struct {
+	mu         sync.RWMutex
+	items      *btree.BTree // every key's last change
+	tombstones *btree.BTree // the deleted ones again, to drop the oldest
+	last       map[string]changeItem
+	max        int    // tombstones kept; 0 for all
+	dropped    uint64 // at of the newest tombstone dropped
+}
+
+func newChangeIndex(maxTombstones int) *changeIndex {
+	return &changeIndex{items: btree.New(32), tombstones: btree.New(32), last: make(map[string]changeItem), max: maxTombstones}
+}
+
+// changeTime is when rec was written, in Unix nanoseconds.
+func changeTime(rec *types.Record) uint64 {
+	if rec.UpdatedAt.IsZero() {
+		return 1 // written before records were timestamped: the oldest
+	}
+	return uint64(rec.UpdatedAt.UnixNano())
+}
+
+// apply records a change emitted by the feed; with tombstone false, as in
+// recovery, a delete is forgotten instead.
+func (x *changeIndex) apply(op types.Operation, key string, rec *types.Record, tombstone bool) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if prev, ok := x.last[key]; ok {
+		x.items.Delete(prev)
+		if prev.deleted {
+			x.tombstones.Delete(prev)
+		}
+		delete(x.last, key)
+	}
+	item := changeItem{key: key}
+	switch {
+	case op == types.OpPut && rec != nil:
+		item.at = changeTime(rec)
+	case tombstone:
+		item.at, item.deleted = uint64(time.Now().UnixNano()), true
+		x.tombstones.ReplaceOrInsert(item)
+	default:
+		return
+	}
+	x.items.ReplaceOrInsert(item)
+	x.last[key] = item
+
+	if x.max > 0 && x.tombstones.Len() > x.max {
+		oldest := x.tombstones.DeleteMin().(changeItem)
+		x.items.Delete(oldest)
+		delete(x.last, oldest.key)
+		x.dropped = max(x.dropped, oldest.at)
+	}
+}
+
+// page returns the changes after since, up to limit of them and any more
+// at the same time as the last.
+func (x *changeIndex) page(since uint64, limit int) ([]changeItem, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	if since > 0 && since < x.dropped {
+		return nil, fmt.Errorf("%w: tombstones up to %d have been dropped, asked for changes after %d", types.ErrHistoryUnavailable, x.dropped, since)
+	}
+	var items []changeItem
+	x.items.AscendGreaterOrEqual(changeItem{at: since + 1}, func(i btree.Item) bool {
+		item := i.(changeItem)
+		if limit > 0 && len(items) >= limit && item.at != items[len(items)-1].at {
+			return false
+		}
+		items = append(items, item)
+		return true
+	})
+	return items, nil
+}
+
+// scanSince implements types.ChangeScanner for an engine whose feed holds
+// x, looking up the records of the changed keys with get. A key changed
+// again since the page was taken is left for the page that reaches its
+// new change.
+func scanSince(ctx context.Context, x *changeIndex, get func(context.Context, []string) (map[string]*types.Record, error), since uint64, limit int) ([]types.Change, uint64, error) {
+	items, err := x.page(since, limit)
+	if err != nil || len(items) == 0 {
+		return nil, since, err
+	}
+	var keys []string
+	for _, item := range items {
+		if !item.deleted {
+			keys = append(keys, item.key)
+		}
+	}
+	found := map[string]*types.Record{}
+	for start := 0; start < len(keys); start += scanChunk {
+		chunk, err := get(ctx, keys[start:min(start+scanChunk, len(keys))])
+		if err != nil {
+			return nil, since, err
+		}
+		for key, rec := range chunk {
+			found[key] = rec
+		}
+	}
+
+	changes := make([]types.Change, 0, len(items))
+	for _, item := range items {
+		change := types.Change{Key: item.key, At: item.at, Deleted: item.deleted}
+		if !item.deleted {
+			if change.Record = found[item.key]; change.Record == nil || changeTime(change.Record) != item.at {
+				continue
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, items[len(items)-1].at, nil
+}
+
+// ScanSince implements types.ChangeScanner.
+func (e *MemoryEngine) ScanSince(ctx context.Context, since uint64, limit int) ([]types.Change, uint64, error) {
+	if err := e.open(); err != nil {
+		return nil, since, err
+	}
+	return scanSince(ctx, e.feed.changes, e.BatchGet, since, limit)
+}
+
+// ScanSince implements types.ChangeScanner.
+func (e *DiskEngine) ScanSince(ctx context.Context, since uint64, limit int) ([]types.Change, uint64, error) {
+	if err := e.readable(); err != nil {
+		return nil, since, err
+	}
+	return scanSince(ctx, e.feed.changes, e.BatchGet, since, limit)
+}
+
+// ScanSince implements types.ChangeScanner.
+func (e *ColumnarEngine) ScanSince(ctx context.Context, since uint64, limit int) ([]types.Change, uint64, error) {
+	if err := e.open(); err != nil {
+		return nil, since, err
+	}
+	return scanSince(ctx, e.feed.changes, e.BatchGet, since, limit)
+}
+
+// ScanSince implements types.ChangeScanner.
+func (e *VectorEngine) ScanSince(ctx context.Context, since uint64, limit int) ([]types.Change, uint64, error) {
+	if err := e.open(); err != nil {
+		return nil, since, err
+	}
+	return scanSince(ctx, e.feed.changes, e.BatchGet, since, limit)
+}
+
+// ScanSince implements types.ChangeScanner.
+func (h *HybridEngine) ScanSince(ctx context.Context, since uint64, limit int) ([]types.Change, uint64, error) {
+	if err := h.open(); err != nil {
+		return nil, since, err
+	}
+	return scanSince(ctx, h.feed.changes, h.BatchGet, since, limit)
+}
+
+var (
+	_ types.ChangeScanner = (*MemoryEngine)(nil)
+	_ types.ChangeScanner = (*DiskEngine)(nil)
+	_ types.ChangeScanner = (*ColumnarEngine)(nil)
+	_ types.ChangeScanner = (*VectorEngine)(nil)
+	_ types.ChangeScanner = (*HybridEngine)(nil)
+)
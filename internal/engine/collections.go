@@ -0,0 +1,332 @@
+package engine
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// collectionSep ends the prefix that names a key's collection.
+const collectionSep = ':'
+
+// collectionCache computes collection statistics from a walk over an
+// engine's keys and keeps them for the configured TTL. mu guards only the
+// cache: walks run without it, and callers asking for the same statistics
+// while one is running wait for it rather than each making their own.
+type collectionCache struct {
+	mu       sync.Mutex
+	byPrefix map[string]types.CollectionStats
+	all      []types.CollectionStats // every collection, largest first
+	allAt    time.Time
+	walks    map[walkKey]*collectionWalk // in progress
+}
+
+// walkKey names the statistics a walk computes: a prefix's, or with top
+// those of every collection.
+type walkKey struct {
+	prefix string
+	top    bool
+}
+
+// collectionWalk is a walk in progress. Its result is set before done is
+// closed.
+type collectionWalk struct {
+	done  chan struct{}
+	stats types.CollectionStats
+	all   []types.CollectionStats
+	err   error
+}
+
+// joinLocked returns the walk in progress for key and true, or else starts
+// one for the caller to run and false.
+func (c *collectionCache) joinLocked(key walkKey) (*collectionWalk, bool) {
+	if w, ok := c.walks[key]; ok {
+		return w, true
+	}
+	if c.walks == nil {
+		c.walks = make(map[walkKey]*collectionWalk)
+	}
+	w := &collectionWalk{done: make(chan struct{})}
+	c.walks[key] = w
+	return w, false
+}
+
+// finishLocked hands w's result to those waiting for it.
+func (c *collectionCache) finishLocked(key walkKey, w *collectionWalk) {
+	delete(c.walks, key)
+	close(w.done)
+}
+
+// wait waits for w, which another caller runs. retry is set if that
+// caller's context ended the walk while ctx goes on, so the caller should
+// ask again rather than take the other's cancellation as its own.
+func (w *collectionWalk) wait(ctx context.Context) (retry bool, err error) {
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+	if w.err != nil && ctx.Err() == nil && (errors.Is(w.err, context.Canceled) || errors.Is(w.err, context.DeadlineExceeded)) {
+		return true, nil
+	}
+	return false, w.err
+}
+
+// walkFunc walks an engine's live records under prefix in key order, as
+// walkTree does.
+type walkFunc func(ctx context.Context, prefix string, fn func(btreeItem) bool) error
+
+func (c *collectionCache) stats(ctx context.Context, cfg *config.Config, walk walkFunc, prefix string) (types.CollectionStats, error) {
+	key := walkKey{prefix: prefix}
+	for {
+		c.mu.Lock()
+		now := time.Now()
+		if s, ok := c.byPrefix[prefix]; ok && fresh(cfg, s.ComputedAt, now) {
+			c.mu.Unlock()
+			return s, nil
+		}
+		w, running := c.joinLocked(key)
+		c.mu.Unlock()
+
+		if running {
+			retry, err := w.wait(ctx)
+			if retry {
+				continue
+			}
+			if err != nil {
+				return types.CollectionStats{}, err
+			}
+			return w.stats, nil
+		}
+		w.stats = types.CollectionStats{Prefix: prefix, ComputedAt: now}
+		w.err = walk(ctx, prefix, func(item btreeItem) bool {
+			addToCollection(&w.stats, item)
+			return true
+		})
+
+		c.mu.Lock()
+		if w.err == nil {
+			if c.byPrefix == nil {
+				c.byPrefix = make(map[string]types.CollectionStats)
+			}
+			// Drop what has gone stale, so prefixes asked for once do not
+			// pile up
+			for p, old := range c.byPrefix {
+				if !fresh(cfg, old.ComputedAt, now) {
+					delete(c.byPrefix, p)
+				}
+			}
+			c.byPrefix[prefix] = w.stats
+		}
+		c.finishLocked(key, w)
+		c.mu.Unlock()
+		if w.err != nil {
+			return types.CollectionStats{}, w.err
+		}
+		return w.stats, nil
+	}
+}
+
+func (c *collectionCache) top(ctx context.Context, cfg *config.Config, walk walkFunc, n int) ([]types.CollectionStats, error) {
+	key := walkKey{top: true}
+	for {
+		c.mu.Lock()
+		now := time.Now()
+		if c.all != nil && fresh(cfg, c.allAt, now) {
+			all := c.all
+			c.mu.Unlock()
+			return slices.Clone(all[:min(n, len(all))]), nil
+		}
+		w, running := c.joinLocked(key)
+		c.mu.Unlock()
+
+		if running {
+			retry, err := w.wait(ctx)
+			if retry {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			return slices.Clone(w.all[:min(n, len(w.all))]), nil
+		}
+		w.all, w.err = walkCollections(ctx, walk, now)
+
+		c.mu.Lock()
+		if w.err == nil {
+			c.all, c.allAt = w.all, now
+		}
+		c.finishLocked(key, w)
+		c.mu.Unlock()
+		if w.err != nil {
+			return nil, w.err
+		}
+		return slices.Clone(w.all[:min(n, len(w.all))]), nil
+	}
+}
+
+// walkCollections computes the statistics of every collection, largest
+// first.
+func walkCollections(ctx context.Context, walk walkFunc, now time.Time) ([]types.CollectionStats, error) {
+	byName := make(map[string]*types.CollectionStats)
+	err := walk(ctx, "", func(item btreeItem) bool {
+		i := strings.IndexByte(item.key, collectionSep)
+		if i < 0 {
+			return true
+		}
+		name := item.key[:i+1]
+		s := byName[name]
+		if s == nil {
+			s = &types.CollectionStats{Prefix: name, ComputedAt: now}
+			byName[name] = s
+		}
+		addToCollection(s, item)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	all := make([]types.CollectionStats, 0, len(byName))
+	for _, s := range byName {
+		all = append(all, *s)
+	}
+	slices.SortFunc(all, func(a, b types.CollectionStats) int {
+		return cmp.Or(cmp.Compare(b.ApproxBytes, a.ApproxBytes), strings.Compare(a.Prefix, b.Prefix))
+	})
+	return all, nil
+}
+
+// addToCollection counts item in s. Walks go in key order, so the first
+// key is the least and the last the greatest.
+func addToCollection(s *types.CollectionStats, item btreeItem) {
+	if s.Keys == 0 {
+		s.MinKey = item.key
+	}
+	s.MaxKey = item.key
+	s.Keys++
+	s.ApproxBytes += recordSize(item.key, item.rec)
+	if len(item.rec.Vector) > 0 {
+		s.WithVector++
+	}
+	if item.rec.TTL != nil {
+		s.WithTTL++
+	}
+}
+
+// fresh reports whether stats computed at are still within the TTL.
+func fresh(cfg *config.Config, at, now time.Time) bool {
+	return now.Sub(at) < time.Duration(cfg.CollectionStatsTTLMs)*time.Millisecond
+}
+
+// CollectionStats implements types.CollectionStatser.
+func (e *MemoryEngine) CollectionStats(ctx context.Context, prefix string) (types.CollectionStats, error) {
+	if err := e.open(); err != nil {
+		return types.CollectionStats{}, err
+	}
+	return e.collections.stats(ctx, e.config, e.walk, prefix)
+}
+
+// TopCollections implements types.CollectionStatser.
+func (e *MemoryEngine) TopCollections(ctx context.Context, n int) ([]types.CollectionStats, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	return e.collections.top(ctx, e.config, e.walk, n)
+}
+
+func (e *MemoryEngine) walk(ctx context.Context, prefix string, fn func(btreeItem) bool) error {
+	return walkMap(ctx, &e.mu, e.records, prefix, nil, fn)
+}
+
+// CollectionStats implements types.CollectionStatser. The tree is walked
+// in key order, so the bounds come for free.
+func (e *DiskEngine) CollectionStats(ctx context.Context, prefix string) (types.CollectionStats, error) {
+	if err := e.readable(); err != nil {
+		return types.CollectionStats{}, err
+	}
+	return e.collections.stats(ctx, e.config, e.walk, prefix)
+}
+
+// TopCollections implements types.CollectionStatser.
+func (e *DiskEngine) TopCollections(ctx context.Context, n int) ([]types.CollectionStats, error) {
+	if err := e.readable(); err != nil {
+		return nil, err
+	}
+	return e.collections.top(ctx, e.config, e.walk, n)
+}
+
+func (e *DiskEngine) walk(ctx context.Context, prefix string, fn func(btreeItem) bool) error {
+	return walkTree(ctx, &e.mu, e.tree, prefix, nil, fn)
+}
+
+// CollectionStats implements types.CollectionStatser.
+func (e *ColumnarEngine) CollectionStats(ctx context.Context, prefix string) (types.CollectionStats, error) {
+	if err := e.open(); err != nil {
+		return types.CollectionStats{}, err
+	}
+	return e.collections.stats(ctx, e.config, e.walk, prefix)
+}
+
+// TopCollections implements types.CollectionStatser.
+func (e *ColumnarEngine) TopCollections(ctx context.Context, n int) ([]types.CollectionStats, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	return e.collections.top(ctx, e.config, e.walk, n)
+}
+
+func (e *ColumnarEngine) walk(ctx context.Context, prefix string, fn func(btreeItem) bool) error {
+	return walkMap(ctx, &e.mu, e.records, prefix, nil, fn)
+}
+
+// CollectionStats implements types.CollectionStatser.
+func (e *VectorEngine) CollectionStats(ctx context.Context, prefix string) (types.CollectionStats, error) {
+	if err := e.open(); err != nil {
+		return types.CollectionStats{}, err
+	}
+	return e.collections.stats(ctx, e.config, e.walk, prefix)
+}
+
+// TopCollections implements types.CollectionStatser.
+func (e *VectorEngine) TopCollections(ctx context.Context, n int) ([]types.CollectionStats, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	return e.collections.top(ctx, e.config, e.walk, n)
+}
+
+func (e *VectorEngine) walk(ctx context.Context, prefix string, fn func(btreeItem) bool) error {
+	return walkMap(ctx, &e.mu, e.records, prefix, nil, fn)
+}
+
+// CollectionStats implements types.CollectionStatser, over the records a
+// scan sees: memory's where it has them, disk's elsewhere.
+func (h *HybridEngine) CollectionStats(ctx context.Context, prefix string) (types.CollectionStats, error) {
+	if err := h.open(); err != nil {
+		return types.CollectionStats{}, err
+	}
+	return h.collections.stats(ctx, h.config, h.walk, prefix)
+}
+
+// TopCollections implements types.CollectionStatser.
+func (h *HybridEngine) TopCollections(ctx context.Context, n int) ([]types.CollectionStats, error) {
+	if err := h.open(); err != nil {
+		return nil, err
+	}
+	return h.collections.top(ctx, h.config, h.walk, n)
+}
+
+var (
+	_ types.CollectionStatser = (*MemoryEngine)(nil)
+	_ types.CollectionStatser = (*DiskEngine)(nil)
+	_ types.CollectionStatser = (*ColumnarEngine)(nil)
+	_ types.CollectionStatser = (*VectorEngine)(nil)
+	_ types.CollectionStatser = (*HybridEngine)(nil)
+)
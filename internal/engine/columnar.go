@@ -7,15 +7,25 @@ import (
 	"time"
 
 	"github.com/thirawat27/kvi/internal/columnar"
+	"github.com/thirawat27/kvi/internal/tracing"
 	"github.com/thirawat27/kvi/pkg/config"
 	"github.com/thirawat27/kvi/pkg/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type ColumnarEngine struct {
+	engineState
+
 	config  *config.Config
 	records map[string]*types.Record
 	store   *columnar.ColumnarStore
 	mu      sync.RWMutex
+	feed    *feed
+	tracer  *tracing.Tracer
+	gcs     gcCounts
+
+	collections collectionCache
 }
 
 func NewColumnarEngine(cfg *config.Config) (*ColumnarEngine, error) {
@@ -24,59 +34,246 @@ func NewColumnarEngine(cfg *config.Config) (*ColumnarEngine, error) {
 		return nil, err
 	}
 
-	return &ColumnarEngine{
+	e := &ColumnarEngine{
 		config:  cfg,
 		records: make(map[string]*types.Record),
 		store:   store,
-	}, nil
+		feed:    newFeed(cfg),
+		tracer:  tracing.New(cfg.TracerProvider),
+	}
+	e.indexing.add(e.feed.queue)
+	return e, nil
 }
 
 func (e *ColumnarEngine) Put(ctx context.Context, key string, record *types.Record) error {
+	_, span := e.tracer.Start(ctx, "columnar.Put")
+	defer span.End()
+
+	if err := e.writable(); err != nil {
+		return err
+	}
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	return e.putLocked(key, record)
+}
+
+func (e *ColumnarEngine) putLocked(key string, record *types.Record) error {
+	stamp(e.records[key], record)
+	return e.storeLocked(key, record)
+}
+
+// storeLocked stores record as it is, already stamped.
+func (e *ColumnarEngine) storeLocked(key string, record *types.Record) error {
 	e.records[key] = record
 	err := e.store.Insert([]*types.Record{record})
 	if err != nil {
 		return fmt.Errorf("columnar insert failed: %v", err)
 	}
+	e.feed.put(key, record)
+	return nil
+}
 
+// deleteLocked drops key's record. Its row stays in the append-only
+// column store.
+func (e *ColumnarEngine) deleteLocked(key string) {
+	if rec, ok := e.records[key]; ok {
+		delete(e.records, key)
+		e.feed.deleted(key, rec)
+	}
+}
+
+// BatchPut writes every record under one write lock.
+func (e *ColumnarEngine) BatchPut(ctx context.Context, records []*types.Record) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, rec := range records {
+		if err := e.putLocked(rec.ID, rec); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (e *ColumnarEngine) Get(ctx context.Context, key string) (*types.Record, error) {
+	_, span := e.tracer.Start(ctx, "columnar.Get")
+	defer span.End()
+
+	if err := e.open(); err != nil {
+		return nil, err
+	}
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
-	record, ok := e.records[key]
-	if !ok {
-		return nil, fmt.Errorf("record not found for key: %s", key)
+	stored := e.records[key]
+	record := live(stored)
+	if record == nil {
+		if stored != nil {
+			e.feed.reapLater(key, e.reap)
+		}
+		return nil, fmt.Errorf("%w for key: %s", types.ErrKeyNotFound, key)
 	}
 	return record, nil
 }
 
 func (e *ColumnarEngine) Delete(ctx context.Context, key string) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
 	// Columnar stores are append-only. Deletes are usually handled via tombstone bitmaps
 	// Since this is simplified, we'll just delete the map reference
-	delete(e.records, key)
+	e.deleteLocked(key)
 	return nil
 }
 
+// BatchGet reads every key under one read lock.
+func (e *ColumnarEngine) BatchGet(ctx context.Context, keys []string) (map[string]*types.Record, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return batchGetMap(e.records, keys), nil
+}
+
+// BatchDelete deletes every key under one write lock.
+func (e *ColumnarEngine) BatchDelete(ctx context.Context, keys []string) (map[string]bool, error) {
+	if err := e.writable(); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	deleted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if live(e.records[key]) != nil {
+			deleted[key] = true
+		}
+		e.deleteLocked(key)
+	}
+	return deleted, nil
+}
+
+func (e *ColumnarEngine) CompareAndSwap(ctx context.Context, key string, version uint64, record *types.Record) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := checkVersion(key, live(e.records[key]), version); err != nil {
+		return err
+	}
+	if record == nil {
+		e.deleteLocked(key)
+		return nil
+	}
+	return e.putLocked(key, record)
+}
+
+func (e *ColumnarEngine) Update(ctx context.Context, key string, fn func(*types.Record) error) (*types.Record, error) {
+	if err := e.writable(); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	cur := live(e.records[key])
+	if cur == nil {
+		return nil, fmt.Errorf("%w for key: %s", types.ErrKeyNotFound, key)
+	}
+	next := cur.Clone()
+	if err := fn(next); err != nil {
+		return nil, err
+	}
+	if err := e.putLocked(key, next); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+func (e *ColumnarEngine) Scan(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+	ctx, span := e.tracer.Start(ctx, "columnar.Scan")
+	defer span.End()
+
+	if err := e.open(); err != nil {
+		return err
+	}
+	return scanMap(ctx, &e.mu, e.records, prefix, fn)
+}
+
+// ScanConsistent implements types.ConsistentScanner.
+func (e *ColumnarEngine) ScanConsistent(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+	ctx, span := e.tracer.Start(ctx, "columnar.ScanConsistent")
+	defer span.End()
+
+	if err := e.open(); err != nil {
+		return err
+	}
+	return snapshotMap(ctx, &e.mu, e.records, prefix, fn)
+}
+
+// Apply implements types.Replica.
+func (e *ColumnarEngine) Apply(ctx context.Context, ev types.ChangeEvent) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	if err := checkApply(ev); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if ev.Op == types.OpDelete {
+		e.deleteLocked(ev.Key)
+		return nil
+	}
+	return e.storeLocked(ev.Key, ev.Record)
+}
+
+func (e *ColumnarEngine) Watch(ctx context.Context, prefix string, fromSeq uint64) (<-chan types.ChangeEvent, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	return e.feed.watch(ctx, prefix, fromSeq)
+}
+
 func (e *ColumnarEngine) Close() error {
+	if err := e.close(); err != nil {
+		return err
+	}
+	e.feed.close()
 	return nil
 }
 
 func (e *ColumnarEngine) Sum(columnName string) (float64, error) {
+	if err := e.open(); err != nil {
+		return 0, err
+	}
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
+	_, span := e.tracer.Start(context.Background(), "columnar.Sum", trace.WithAttributes(attribute.String("kvi.column", columnName)))
+	defer span.End()
+
 	// Mock analytics delay
 	time.Sleep(5 * time.Millisecond)
 
 	return e.store.Sum(columnName)
 }
 
-var _ types.Engine = (*ColumnarEngine)(nil)
+var (
+	_ types.Engine            = (*ColumnarEngine)(nil)
+	_ types.Batcher           = (*ColumnarEngine)(nil)
+	_ types.Watcher           = (*ColumnarEngine)(nil)
+	_ types.ConsistentScanner = (*ColumnarEngine)(nil)
+	_ types.Replica           = (*ColumnarEngine)(nil)
+)
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/thirawat27/kvi/internal/pack"
+	"github.com/thirawat27/kvi/pkg/codec"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// packer compresses the Data of the records a memory engine keeps once it
+// serializes to at least min bytes. A nil packer keeps records as they are.
+type packer struct {
+	min    int
+	codec  codec.Codec
+	counts pack.Counter
+}
+
+func newPacker(cfg *config.Config) (*packer, error) {
+	if cfg.RecordCompressMinBytes <= 0 {
+		return nil, nil
+	}
+	c, err := codec.Lookup(cfg.Codec)
+	if err != nil {
+		return nil, err
+	}
+	return &packer{min: cfg.RecordCompressMinBytes, codec: c}, nil
+}
+
+// pack returns the record to keep for rec: rec itself, or a copy with its
+// Data compressed into Packed.
+func (p *packer) pack(rec *types.Record) *types.Record {
+	if p == nil || rec == nil || len(rec.Data) == 0 {
+		return rec
+	}
+	raw, err := p.codec.Marshal(rec.Data)
+	if err != nil || len(raw) < p.min {
+		return rec
+	}
+	packed := pack.Encode(raw)
+	if packed == nil {
+		return rec
+	}
+	p.counts.Add(len(raw), len(packed))
+	kept := *rec
+	kept.Data, kept.Packed = nil, packed
+	return &kept
+}
+
+func (p *packer) stats() *types.CompressionStats {
+	if p == nil {
+		return nil
+	}
+	return p.counts.Stats()
+}
+
+// unpack returns rec, or a copy with its Data decompressed if it was
+// packed. Data comes back as the codec decodes it, as it would after a
+// restart.
+func unpack(rec *types.Record) *types.Record {
+	if rec == nil || rec.Packed == nil {
+		return rec
+	}
+	out := *rec
+	out.Packed = nil
+	raw, err := pack.Decode(rec.Packed)
+	if err == nil {
+		c, ok := codec.Detect(raw)
+		if !ok {
+			c = codec.JSON // for the error
+		}
+		err = c.Unmarshal(raw, &out.Data)
+	}
+	if err != nil { // pack wrote it, so the memory holding it is damaged
+		panic(fmt.Sprintf("engine: packed record: %v", err))
+	}
+	return &out
+}
+
+// unpackProjected is p.Apply(unpack(rec)) without decoding what p leaves
+// out: a packed record whose Data p drops whole is never decompressed, and
+// one whose fields p picks is trimmed in place of a second copy.
+func unpackProjected(rec *types.Record, p *types.Projection) *types.Record {
+	switch {
+	case p == nil || rec == nil:
+		return unpack(rec)
+	case rec.Packed == nil:
+		return p.Apply(rec)
+	case p.Fields != nil && len(p.Fields) == 0:
+		out := p.Apply(rec)
+		out.Packed = nil
+		return out
+	}
+	out := unpack(rec) // a copy of its own, Data included
+	if !p.Vector {
+		out.Vector = nil
+	}
+	if p.Fields != nil {
+		for k := range out.Data {
+			if !slices.Contains(p.Fields, k) {
+				delete(out.Data, k)
+			}
+		}
+	}
+	return out
+}
+
+// RecordCodec implements types.RecordCoder.
+func (e *MemoryEngine) RecordCodec() string { return e.config.Codec }
+
+// RecordCodec implements types.RecordCoder.
+func (e *DiskEngine) RecordCodec() string { return e.config.Codec }
+
+// RecordCodec implements types.RecordCoder.
+func (e *ColumnarEngine) RecordCodec() string { return e.config.Codec }
+
+// RecordCodec implements types.RecordCoder.
+func (e *VectorEngine) RecordCodec() string { return e.config.Codec }
+
+// RecordCodec implements types.RecordCoder.
+func (h *HybridEngine) RecordCodec() string { return h.config.Codec }
+
+var (
+	_ types.RecordCoder = (*MemoryEngine)(nil)
+	_ types.RecordCoder = (*DiskEngine)(nil)
+	_ types.RecordCoder = (*ColumnarEngine)(nil)
+	_ types.RecordCoder = (*VectorEngine)(nil)
+	_ types.RecordCoder = (*HybridEngine)(nil)
+)
@@ -2,11 +2,16 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/google/btree"
+	"github.com/thirawat27/kvi/internal/tracing"
 	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/codec"
 	"github.com/thirawat27/kvi/pkg/config"
 	"github.com/thirawat27/kvi/pkg/types"
 )
@@ -20,33 +25,163 @@ func (i btreeItem) Less(than btree.Item) bool {
 	return i.key < than.(btreeItem).key
 }
 
+// errNoWAL is returned by Ship when there is no log to ship.
+var errNoWAL = fmt.Errorf("%w: shipping changes needs enable_wal", errors.ErrUnsupported)
+
 type DiskEngine struct {
-	config *config.Config
-	tree   *btree.BTree
-	wal    *wal.WAL
-	mu     sync.RWMutex
+	engineState
+
+	config   *config.Config
+	tree     *btree.BTree
+	wal      *wal.WAL
+	mu       sync.RWMutex
+	feed     *feed
+	tracer   *tracing.Tracer
+	gcs      gcCounts
+	recovery *recovery
+	grouped  bool // writes wait for the WAL's group commit
+
+	collections collectionCache
 }
 
 func NewDiskEngine(cfg *config.Config) (*DiskEngine, error) {
-	walDB, err := wal.NewWAL(cfg.DataDir)
+	c, err := codec.Lookup(cfg.Codec)
 	if err != nil {
 		return nil, err
 	}
+	open := wal.NewWAL
+	if cfg.ReadOnly {
+		open = wal.OpenReadOnly
+	}
+	walDB, err := open(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &DiskEngine{
+		config:   cfg,
+		tree:     btree.New(32), // degree 32
+		wal:      walDB,
+		feed:     newFeed(cfg),
+		tracer:   tracing.New(cfg.TracerProvider),
+		recovery: newRecovery(),
+	}
+	e.space.dir = cfg.DataDir
+	e.space.minFree.Store(uint64(cfg.DiskFenceMB) << 20)
+	e.indexing.add(e.feed.queue)
+	walDB.SetTracer(e.tracer)
+	walDB.Compress(cfg.RecordCompressMinBytes)
+	walDB.SetCodec(c)
+	if cfg.EnableWAL && cfg.GroupCommit && cfg.Mode == types.ModeDisk {
+		walDB.GroupCommit(time.Duration(cfg.SyncIntervalMs) * time.Millisecond)
+		e.grouped = true
+	}
+	switch {
+	case !cfg.EnableWAL:
+		e.recovery.end(nil)
+	case cfg.Mode == types.ModeDisk && (cfg.RecoveryStartup == config.RecoveryBackground || cfg.RecoveryStartup == config.RecoveryServeReads):
+		e.recovering.Store(true)
+		e.partial.Store(cfg.RecoveryStartup == config.RecoveryServeReads)
+		go e.recoverInBackground()
+	default:
+		if err := e.recover(); err != nil {
+			walDB.Close()
+			return nil, e.recoverError(err)
+		}
+	}
+	return e, nil
+}
+
+// recover rebuilds the tree from the WAL. Records come back as logged,
+// versions and timestamps included, so versions carry on after a restart.
+// Those that expired meanwhile are left out, as a gc would remove them;
+// the WAL keeps them until it is compacted. Each entry is applied under
+// the write lock, so readers may look on, and closing the engine stops it.
+func (e *DiskEngine) recover() (err error) {
+	defer func() { e.recovery.end(err) }()
 
-	// In real DB, we would recover from WAL here.
-	// We'll skip WAL recovery implementation for simplicity of stub.
+	start := time.Now()
+	opts := wal.ReplayOptions{Workers: e.config.RecoveryParallelism, Progress: e.recovery.tracker(e.config.Log())}
+	n, err := e.wal.Replay(opts, func(entry *wal.LogEntry) error {
+		if err := e.open(); err != nil {
+			return err
+		}
+		e.mu.Lock()
+		defer e.mu.Unlock()
+
+		switch entry.Op {
+		case types.OpPut:
+			if entry.Record == nil {
+				return fmt.Errorf("put of %s at LSN %d has no record", entry.Key, entry.LSN)
+			}
+			e.tree.ReplaceOrInsert(btreeItem{key: entry.Key, rec: entry.Record})
+		case types.OpDelete:
+			e.tree.Delete(btreeItem{key: entry.Key})
+		case types.OpRename:
+			if entry.Record == nil {
+				return fmt.Errorf("rename of %s to %s at LSN %d has no record", entry.From, entry.Key, entry.LSN)
+			}
+			e.tree.ReplaceOrInsert(btreeItem{key: entry.Key, rec: entry.Record})
+			e.tree.Delete(btreeItem{key: entry.From})
+			e.feed.index(types.OpPut, entry.Key, entry.Record)
+			e.feed.index(types.OpDelete, entry.From, nil)
+			return nil
+		}
+		e.feed.index(entry.Op, entry.Key, entry.Record)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	expired := expiredKeys(e.tree, time.Now())
+	for _, key := range expired {
+		e.tree.Delete(btreeItem{key: key})
+		e.feed.index(types.OpDelete, key, nil)
+	}
+	records := e.tree.Len()
+	e.mu.Unlock()
+	e.gcs.count(len(expired), nil)
+	e.config.Log().Info("recovered from WAL", "engine", "disk", "entries", n, "records", records,
+		"expired", len(expired), "duration_ms", float64(time.Since(start).Microseconds())/1000)
+	return nil
+}
 
-	return &DiskEngine{
-		config: cfg,
-		tree:   btree.New(32), // degree 32
-		wal:    walDB,
-	}, nil
+// recoverError explains a failed recovery.
+func (e *DiskEngine) recoverError(err error) error {
+	return fmt.Errorf("recover %s: %w (kvi wal repair can cut the log before the damage)", filepath.Join(e.config.DataDir, wal.FileName), err)
 }
 
 func (e *DiskEngine) Put(ctx context.Context, key string, record *types.Record) error {
+	_, span := e.tracer.Start(ctx, "disk.Put")
+	defer span.End()
+
+	if err := e.writable(); err != nil {
+		return err
+	}
+	return e.write(func() error { return e.putLocked(key, record) })
+}
+
+// write runs fn under the write lock. With group commit it then waits for
+// the WAL to commit what fn logged, having let go of the lock so other
+// writers can join the same group.
+func (e *DiskEngine) write(fn func() error) error {
 	e.mu.Lock()
-	defer e.mu.Unlock()
+	err := fn()
+	e.mu.Unlock()
+	if err != nil || !e.grouped {
+		return err
+	}
+	return e.wal.Commit()
+}
 
+func (e *DiskEngine) putLocked(key string, record *types.Record) error {
+	stamp(e.getLocked(key), record)
+	return e.storeLocked(key, record)
+}
+
+// storeLocked logs and stores record as it is, already stamped.
+func (e *DiskEngine) storeLocked(key string, record *types.Record) error {
 	if e.config.EnableWAL {
 		if err := e.wal.WriteEntry(types.OpPut, key, record); err != nil {
 			return err
@@ -54,35 +189,268 @@ func (e *DiskEngine) Put(ctx context.Context, key string, record *types.Record)
 	}
 
 	e.tree.ReplaceOrInsert(btreeItem{key: key, rec: record})
+	e.feed.put(key, record)
+	return nil
+}
+
+func (e *DiskEngine) getLocked(key string) *types.Record {
+	if item := e.tree.Get(btreeItem{key: key}); item != nil {
+		return item.(btreeItem).rec
+	}
 	return nil
 }
 
+// putTree and deleteTree change the tree alone, for the hybrid engine,
+// which writes the WAL itself before its writes are queued.
+func (e *DiskEngine) putTree(key string, record *types.Record) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tree.ReplaceOrInsert(btreeItem{key: key, rec: record})
+}
+
+func (e *DiskEngine) deleteTree(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tree.Delete(btreeItem{key: key})
+}
+
+// stored returns key's record, expired or not, or nil.
+func (e *DiskEngine) stored(key string) *types.Record {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.getLocked(key)
+}
+
 func (e *DiskEngine) Get(ctx context.Context, key string) (*types.Record, error) {
+	_, span := e.tracer.Start(ctx, "disk.Get")
+	defer span.End()
+
+	if err := e.readable(); err != nil {
+		return nil, err
+	}
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
-	item := e.tree.Get(btreeItem{key: key})
-	if item == nil {
-		return nil, fmt.Errorf("record not found for key: %s", key)
+	stored := e.getLocked(key)
+	rec := live(stored)
+	if rec == nil {
+		if stored != nil {
+			e.feed.reapLater(key, e.reap)
+		}
+		return nil, fmt.Errorf("%w for key: %s", types.ErrKeyNotFound, key)
 	}
-	return item.(btreeItem).rec, nil
+	return rec, nil
 }
 
 func (e *DiskEngine) Delete(ctx context.Context, key string) error {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+	if err := e.writable(); err != nil {
+		return err
+	}
+	return e.write(func() error { return e.deleteLocked(key) })
+}
 
+func (e *DiskEngine) deleteLocked(key string) error {
+	rec, err := e.removeLocked(key)
+	if rec != nil {
+		e.feed.deleted(key, rec)
+	}
+	return err
+}
+
+// removeLocked deletes key and returns the record it held, if any.
+func (e *DiskEngine) removeLocked(key string) (*types.Record, error) {
 	if e.config.EnableWAL {
 		if err := e.wal.WriteEntry(types.OpDelete, key, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if item := e.tree.Delete(btreeItem{key: key}); item != nil {
+		return item.(btreeItem).rec, nil
+	}
+	return nil, nil
+}
+
+// BatchPut writes every record under one write lock, stopping at the first
+// WAL failure.
+func (e *DiskEngine) BatchPut(ctx context.Context, records []*types.Record) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
+	return e.write(func() error {
+		for _, rec := range records {
+			if err := e.putLocked(rec.ID, rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// BatchGet reads every key under one read lock.
+func (e *DiskEngine) BatchGet(ctx context.Context, keys []string) (map[string]*types.Record, error) {
+	if err := e.readable(); err != nil {
+		return nil, err
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	found := make(map[string]*types.Record, len(keys))
+	for _, key := range keys {
+		if rec := live(e.getLocked(key)); rec != nil {
+			found[key] = rec
+		}
+	}
+	return found, nil
+}
+
+// BatchDelete deletes every key under one write lock, stopping at the
+// first WAL failure.
+func (e *DiskEngine) BatchDelete(ctx context.Context, keys []string) (map[string]bool, error) {
+	if err := e.writable(); err != nil {
+		return nil, err
+	}
+	deleted := make(map[string]bool, len(keys))
+	err := e.write(func() error {
+		for _, key := range keys {
+			existed := live(e.getLocked(key)) != nil
+			if err := e.deleteLocked(key); err != nil {
+				return err
+			}
+			if existed {
+				deleted[key] = true
+			}
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// Update writes a single WAL entry holding the updated record.
+func (e *DiskEngine) Update(ctx context.Context, key string, fn func(*types.Record) error) (*types.Record, error) {
+	if err := e.writable(); err != nil {
+		return nil, err
+	}
+	var next *types.Record
+	err := e.write(func() error {
+		cur := live(e.getLocked(key))
+		if cur == nil {
+			return fmt.Errorf("%w for key: %s", types.ErrKeyNotFound, key)
+		}
+		next = cur.Clone()
+		if err := fn(next); err != nil {
+			return err
+		}
+		return e.putLocked(key, next)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+func (e *DiskEngine) CompareAndSwap(ctx context.Context, key string, version uint64, record *types.Record) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
+	return e.write(func() error {
+		if err := checkVersion(key, live(e.getLocked(key)), version); err != nil {
 			return err
 		}
+		if record == nil {
+			return e.deleteLocked(key)
+		}
+		return e.putLocked(key, record)
+	})
+}
+
+func (e *DiskEngine) Scan(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+	ctx, span := e.tracer.Start(ctx, "disk.Scan")
+	defer span.End()
+
+	if err := e.readable(); err != nil {
+		return err
 	}
+	return scanTree(ctx, &e.mu, e.tree, prefix, fn)
+}
 
-	e.tree.Delete(btreeItem{key: key})
-	return nil
+// ScanConsistent implements types.ConsistentScanner. It scans a clone of
+// the tree, which costs nothing up front: writes made meanwhile copy the
+// nodes they change rather than change the clone's.
+func (e *DiskEngine) ScanConsistent(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+	ctx, span := e.tracer.Start(ctx, "disk.ScanConsistent")
+	defer span.End()
+
+	if err := e.readable(); err != nil {
+		return err
+	}
+	return scanSnapshot(ctx, e.snapshot(), prefix, fn)
+}
+
+// snapshot clones the tree, under the write lock as cloning marks its
+// nodes shared.
+func (e *DiskEngine) snapshot() *btree.BTree {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.tree.Clone()
+}
+
+// Apply implements types.Replica. The change is logged to this engine's
+// own WAL under a LSN of its own.
+func (e *DiskEngine) Apply(ctx context.Context, ev types.ChangeEvent) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	if err := e.recovered(); err != nil {
+		return err
+	}
+	if err := checkApply(ev); err != nil {
+		return err
+	}
+	return e.write(func() error {
+		if ev.Op == types.OpDelete {
+			return e.deleteLocked(ev.Key)
+		}
+		return e.storeLocked(ev.Key, ev.Record)
+	})
+}
+
+// LSN implements types.LogShipper. It waits for recovery, which finds
+// the last LSN.
+func (e *DiskEngine) LSN() uint64 {
+	<-e.recovery.finished
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.wal.ShippedLSN()
+}
+
+// Ship implements types.LogShipper.
+func (e *DiskEngine) Ship(ctx context.Context, afterLSN uint64) (<-chan types.ChangeEvent, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	if !e.config.EnableWAL {
+		return nil, errNoWAL
+	}
+	if err := e.recovered(); err != nil {
+		return nil, err
+	}
+	return e.wal.Follow(ctx, afterLSN)
+}
+
+func (e *DiskEngine) Watch(ctx context.Context, prefix string, fromSeq uint64) (<-chan types.ChangeEvent, error) {
+	if err := e.readable(); err != nil {
+		return nil, err
+	}
+	return e.feed.watch(ctx, prefix, fromSeq)
 }
 
 func (e *DiskEngine) Close() error {
+	if err := e.close(); err != nil {
+		return err
+	}
+	<-e.recovery.finished // stops at the next entry
+	e.feed.close()
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
@@ -92,5 +460,44 @@ func (e *DiskEngine) Close() error {
 	return nil
 }
 
+// Sync implements types.Syncer. It waits for recovery, as writes do.
+func (e *DiskEngine) Sync(ctx context.Context) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	if err := e.recovered(); err != nil {
+		return err
+	}
+	if !e.config.EnableWAL {
+		return nil
+	}
+	_, span := e.tracer.Start(ctx, "disk.Sync")
+	defer span.End()
+	return e.wal.Flush()
+}
+
+func (e *DiskEngine) HealthChecks() map[string]func(context.Context) error {
+	return map[string]func(context.Context) error{"wal": e.checkWAL, "recovery": e.checkRecovery, "workers": e.workers.check, "disk_space": e.space.check}
+}
+
+// checkWAL fails while the WAL is being replayed, as checkRecovery reports.
+func (e *DiskEngine) checkWAL(ctx context.Context) error {
+	if !e.config.EnableWAL {
+		return nil
+	}
+	if err := e.recovered(); err != nil {
+		return err
+	}
+	return e.wal.Check()
+}
+
 // Compile time check
-var _ types.Engine = (*DiskEngine)(nil)
+var (
+	_ types.Engine            = (*DiskEngine)(nil)
+	_ types.Watcher           = (*DiskEngine)(nil)
+	_ types.Batcher           = (*DiskEngine)(nil)
+	_ types.ConsistentScanner = (*DiskEngine)(nil)
+	_ types.Replica           = (*DiskEngine)(nil)
+	_ types.LogShipper        = (*DiskEngine)(nil)
+	_ types.Syncer            = (*DiskEngine)(nil)
+)
@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/fsutil"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// diskSpace fences an engine's writes while the file system holding its
+// data dir has less than minFree bytes free, so a full volume fails them
+// with ErrDiskFull before the WAL fails mid-write. The disk monitor keeps
+// it current; dir is "" for engines that keep nothing on disk.
+type diskSpace struct {
+	dir     string
+	minFree atomic.Uint64
+	free    atomic.Uint64
+	fenced  atomic.Bool
+	checked atomic.Int64 // UnixNano of the last check
+	lastErr atomic.Pointer[string]
+}
+
+// fence returns ErrDiskFull while writes are fenced.
+func (s *diskSpace) fence() error {
+	if !s.fenced.Load() {
+		return nil
+	}
+	return fmt.Errorf("%w: %d MiB free in %s, need %d MiB", types.ErrDiskFull, s.free.Load()>>20, s.dir, s.minFree.Load()>>20)
+}
+
+// update checks the free space, fencing or unfencing writes, and reports
+// whether that changed.
+func (s *diskSpace) update() (changed bool, err error) {
+	free, err := fsutil.FreeBytes(s.dir)
+	s.checked.Store(time.Now().UnixNano())
+	if err != nil {
+		msg := err.Error()
+		s.lastErr.Store(&msg)
+		return false, err
+	}
+	s.lastErr.Store(nil)
+	s.free.Store(free)
+	minFree := s.minFree.Load()
+	fenced := minFree > 0 && free < minFree
+	return s.fenced.Swap(fenced) != fenced, nil
+}
+
+// stats is nil for an engine that keeps nothing on disk.
+func (s *diskSpace) stats() *types.DiskStats {
+	if s.dir == "" {
+		return nil
+	}
+	stats := &types.DiskStats{Dir: s.dir, FreeBytes: s.free.Load(), FenceBytes: s.minFree.Load(), Fenced: s.fenced.Load()}
+	if at := s.checked.Load(); at != 0 {
+		stats.CheckedAt = time.Unix(0, at).UTC()
+	}
+	if msg := s.lastErr.Load(); msg != nil {
+		stats.LastError = *msg
+	}
+	return stats
+}
+
+// check is the disk_space health check: it fails while writes are fenced.
+func (s *diskSpace) check(ctx context.Context) error { return s.fence() }
+
+// SetDiskFence implements types.DiskFencer.
+func (e *DiskEngine) SetDiskFence(minFree uint64) { e.space.minFree.Store(minFree) }
+
+// SetDiskFence implements types.DiskFencer.
+func (h *HybridEngine) SetDiskFence(minFree uint64) { h.space.minFree.Store(minFree) }
+
+func (c *engineState) diskSpace() *diskSpace { return &c.space }
+
+// watchDisk checks the free space every w.interval until stop is closed.
+// When writes are fenced it runs the emergency tasks, a checkpoint and a
+// compaction of the WAL, to give back what space they can; writes are
+// unfenced by the first check that finds enough free.
+func watchDisk(log *slog.Logger, space *diskSpace, tasks map[string]types.MaintenanceFunc, w *worker, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		changed, err := space.update()
+		select {
+		case <-stop:
+			return // failed, if it did, because the engine closed
+		default:
+		}
+		switch {
+		case errors.Is(err, errors.ErrUnsupported):
+			err = nil // no free space to go by on this platform: never fenced
+		case err != nil:
+			log.Warn("disk space check failed", "dir", space.dir, "error", err)
+		case changed && space.fenced.Load():
+			log.Error("writes fenced: data dir is low on space", "dir", space.dir,
+				"free_bytes", space.free.Load(), "fence_bytes", space.minFree.Load())
+			emergencyCompact(ctx, log, space, tasks)
+		case changed:
+			log.Info("writes unfenced: data dir has space again", "dir", space.dir, "free_bytes", space.free.Load())
+		}
+		w.done(0, err)
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// emergencyCompact checkpoints and compacts the WAL of an engine whose
+// writes were just fenced, then checks the space again.
+func emergencyCompact(ctx context.Context, log *slog.Logger, space *diskSpace, tasks map[string]types.MaintenanceFunc) {
+	before := space.free.Load()
+	for _, op := range []string{types.MaintenanceCheckpoint, types.MaintenanceCompact} {
+		task := tasks[op]
+		if task == nil {
+			continue
+		}
+		if err := task(ctx, func(done, total int) {}); err != nil {
+			log.Error("emergency "+op+" failed", "dir", space.dir, "error", err)
+			return
+		}
+	}
+	if _, err := space.update(); err == nil {
+		log.Warn("emergency compaction done", "dir", space.dir, "freed_bytes", int64(space.free.Load())-int64(before),
+			"fenced", space.fenced.Load())
+	}
+}
+
+var (
+	_ types.DiskFencer = (*DiskEngine)(nil)
+	_ types.DiskFencer = (*HybridEngine)(nil)
+)
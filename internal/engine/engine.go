@@ -2,15 +2,65 @@ package engine
 
 import (
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/thirawat27/kvi/pkg/config"
 	"github.com/thirawat27/kvi/pkg/types"
 )
 
+// NewEngine opens the engine for cfg.Mode, with the text indexes cfg
+// names built over the records it recovered.
 func NewEngine(cfg *config.Config) (types.Engine, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	eng, err := newEngine(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := createTextIndexes(eng.(types.TextSearcher), cfg.TextIndex); err != nil {
+		eng.Close()
+		return nil, err
+	}
+	if cfg.VerifyOnStart {
+		if err := verifyOnStart(cfg.Log(), eng); err != nil {
+			eng.Close()
+			return nil, err
+		}
+	}
+	if cfg.ReadOnly {
+		// Nothing runs on its own that could write
+		eng.(types.Replica).SetReadOnly(true)
+		return eng, nil
+	}
+	bg := eng.(interface {
+		stopped() <-chan struct{}
+		background() *workerSet
+		collected() *gcCounts
+		diskSpace() *diskSpace
+	})
+	if cfg.GCIntervalMs > 0 {
+		gc := eng.(types.Maintainer).Maintenance()[types.MaintenanceGC]
+		w := bg.background().add("gc", time.Duration(cfg.GCIntervalMs)*time.Millisecond, cfg.WorkerStallIntervals, nil)
+		go collectGarbage(cfg.Log(), gc, bg.collected(), w, bg.stopped())
+	}
+	if space := bg.diskSpace(); space.dir != "" && cfg.DiskCheckIntervalMs > 0 {
+		tasks := eng.(types.Maintainer).Maintenance()
+		w := bg.background().add("disk_monitor", time.Duration(cfg.DiskCheckIntervalMs)*time.Millisecond, cfg.WorkerStallIntervals, nil)
+		go watchDisk(cfg.Log(), space, tasks, w, bg.stopped())
+	}
+	if cfg.WorkerStallIntervals > 0 {
+		go bg.background().watch(cfg.Log(), bg.stopped())
+	}
+	return eng, nil
+}
+
+func newEngine(cfg *config.Config) (types.Engine, error) {
 	switch cfg.Mode {
 	case types.ModeMemory:
-		return NewMemoryEngine(cfg), nil
+		return NewMemoryEngine(cfg)
 	case types.ModeDisk:
 		return NewDiskEngine(cfg)
 	case types.ModeColumnar:
@@ -23,3 +73,106 @@ func NewEngine(cfg *config.Config) (types.Engine, error) {
 		return nil, fmt.Errorf("unknown mode: %s", cfg.Mode)
 	}
 }
+
+// engineState makes an engine refuse work once closed, and writes while
+// it follows a primary. Engines check it at the top of every operation and
+// close it first thing in Close.
+type engineState struct {
+	closed     atomic.Bool
+	readOnly   atomic.Bool
+	recovering atomic.Bool   // replaying a log in the background
+	partial    atomic.Bool   // reads are served while recovering
+	stop       chan struct{} // see stopped
+	stopOnce   sync.Once
+	workers    workerSet
+	space      diskSpace   // fences writes while the data dir is low on space
+	indexing   indexQueues // its index updates queued behind writes, if async_indexing
+}
+
+// open returns ErrClosed once the engine has been closed.
+func (c *engineState) open() error {
+	if c.closed.Load() {
+		return types.ErrClosed
+	}
+	return nil
+}
+
+// readable is open for reads: it also returns ErrRecovering while the
+// engine replays its log in the background, unless reads are served
+// meanwhile.
+func (c *engineState) readable() error {
+	if err := c.open(); err != nil {
+		return err
+	}
+	if !c.partial.Load() {
+		return c.recovered()
+	}
+	return nil
+}
+
+// writable is open for writes: it also returns ErrReadOnly while the
+// engine is a replica, ErrDiskFull while its writes are fenced and
+// ErrRecovering while it replays its log.
+// Replicated changes come in through Apply, which checks open and
+// recovered alone.
+func (c *engineState) writable() error {
+	if err := c.open(); err != nil {
+		return err
+	}
+	if c.readOnly.Load() {
+		return types.ErrReadOnly
+	}
+	if err := c.space.fence(); err != nil {
+		return err
+	}
+	return c.recovered()
+}
+
+// recovered returns ErrRecovering until a background replay of the
+// engine's log is done.
+func (c *engineState) recovered() error {
+	if c.recovering.Load() {
+		return types.ErrRecovering
+	}
+	return nil
+}
+
+// close marks the engine closed. It reports ErrClosed if it already was,
+// so only the first Close releases resources.
+func (c *engineState) close() error {
+	if c.closed.Swap(true) {
+		return types.ErrClosed
+	}
+	c.stopped() // make the channel if nothing has asked for it yet
+	close(c.stop)
+	return nil
+}
+
+// stopped returns a channel closed when the engine is, for its background
+// work to stop on.
+func (c *engineState) stopped() <-chan struct{} {
+	c.stopOnce.Do(func() { c.stop = make(chan struct{}) })
+	return c.stop
+}
+
+// background returns the engine's background workers.
+func (c *engineState) background() *workerSet { return &c.workers }
+
+// checkApply rejects a change Apply cannot store.
+func checkApply(ev types.ChangeEvent) error {
+	switch {
+	case ev.Op == types.OpDelete:
+		return nil
+	case ev.Op != types.OpPut:
+		return fmt.Errorf("cannot apply %s of %s", ev.Op, ev.Key)
+	case ev.Record == nil:
+		return fmt.Errorf("put of %s has no record", ev.Key)
+	}
+	return nil
+}
+
+// SetReadOnly implements types.Replica.
+func (c *engineState) SetReadOnly(readOnly bool) { c.readOnly.Store(readOnly) }
+
+// ReadOnly implements types.Replica.
+func (c *engineState) ReadOnly() bool { return c.readOnly.Load() }
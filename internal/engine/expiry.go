@@ -0,0 +1,230 @@
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// expiryWorkers is how many OnExpire callbacks may run at once.
+const expiryWorkers = 4
+
+// expiryHooks runs the OnExpire callbacks of an engine. It lives in the
+// feed, which hands it every expiry under the engine's write lock, so
+// notify only queues a copy of the record; the workers, started with the
+// first callback, drain the queue.
+type expiryHooks struct {
+	mu      sync.Mutex
+	wake    *sync.Cond
+	fns     []func(key string, rec *types.Record)
+	queue   []expiration
+	closed  bool
+	workers sync.WaitGroup
+}
+
+type expiration struct {
+	key string
+	rec *types.Record
+}
+
+func newExpiryHooks() *expiryHooks {
+	x := &expiryHooks{}
+	x.wake = sync.NewCond(&x.mu)
+	return x
+}
+
+// add registers fn, starting the workers if it is the first.
+func (x *expiryHooks) add(fn func(key string, rec *types.Record)) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.closed {
+		return
+	}
+	x.fns = append(x.fns, fn)
+	if len(x.fns) > 1 {
+		return
+	}
+	x.workers.Add(expiryWorkers)
+	for range expiryWorkers {
+		go x.work()
+	}
+}
+
+// listening reports whether any callback is registered, so reads only
+// remove the expired records they find when someone is told.
+func (x *expiryHooks) listening() bool {
+	if x == nil {
+		return false
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.fns) > 0 && !x.closed
+}
+
+// notify queues rec's expiry for every callback. It never blocks.
+func (x *expiryHooks) notify(key string, rec *types.Record) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if len(x.fns) == 0 || x.closed {
+		return
+	}
+	x.queue = append(x.queue, expiration{key: key, rec: rec.Clone()})
+	x.wake.Signal()
+}
+
+func (x *expiryHooks) work() {
+	defer x.workers.Done()
+	for {
+		x.mu.Lock()
+		for len(x.queue) == 0 && !x.closed {
+			x.wake.Wait()
+		}
+		if x.closed {
+			x.mu.Unlock()
+			return
+		}
+		next, fns := x.queue[0], x.fns
+		x.queue[0] = expiration{}
+		x.queue = x.queue[1:]
+		x.mu.Unlock()
+
+		for _, fn := range fns {
+			fn(next.key, next.rec.Clone()) // each callback gets its own copy
+		}
+	}
+}
+
+// close drops the expiries still queued and waits for the callbacks
+// running to return.
+func (x *expiryHooks) close() {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	x.closed = true
+	x.queue = nil
+	x.wake.Broadcast()
+	x.mu.Unlock()
+	x.workers.Wait()
+}
+
+// reapLater removes key's record in the background once a read has found
+// it expired, if any callback listens, so it fires without waiting for the
+// next garbage collection. reap checks again under the write lock that the
+// record is still there and expired, so of many reads finding it one
+// removes it and it is reported once.
+func (f *feed) reapLater(key string, reap func(key string)) {
+	if f != nil && f.expiry.listening() {
+		go reap(key)
+	}
+}
+
+// OnExpire implements types.ExpiryNotifier.
+func (e *MemoryEngine) OnExpire(fn func(key string, rec *types.Record)) { e.feed.expiry.add(fn) }
+
+func (e *MemoryEngine) reap(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if rec := e.records[key]; e.writable() == nil && rec != nil && rec.Expired(time.Now()) {
+		delete(e.records, key)
+		e.feed.expired(key, rec)
+	}
+}
+
+// OnExpire implements types.ExpiryNotifier.
+func (e *DiskEngine) OnExpire(fn func(key string, rec *types.Record)) { e.feed.expiry.add(fn) }
+
+// reap logs the delete to the WAL like gc; if that fails the record stays
+// for gc to retry.
+func (e *DiskEngine) reap(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.writable() != nil {
+		return
+	}
+	if rec := e.getLocked(key); rec != nil && rec.Expired(time.Now()) {
+		if _, err := e.removeLocked(key); err == nil {
+			e.feed.expired(key, rec)
+		}
+	}
+}
+
+// OnExpire implements types.ExpiryNotifier.
+func (e *ColumnarEngine) OnExpire(fn func(key string, rec *types.Record)) { e.feed.expiry.add(fn) }
+
+func (e *ColumnarEngine) reap(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if rec := e.records[key]; e.writable() == nil && rec != nil && rec.Expired(time.Now()) {
+		delete(e.records, key)
+		e.feed.expired(key, rec)
+	}
+}
+
+// OnExpire implements types.ExpiryNotifier.
+func (e *VectorEngine) OnExpire(fn func(key string, rec *types.Record)) { e.feed.expiry.add(fn) }
+
+func (e *VectorEngine) reap(key string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if rec := e.records[key]; e.writable() == nil && rec != nil && rec.Expired(time.Now()) {
+		delete(e.records, key)
+		e.unindexLocked(key)
+		e.feed.expired(key, rec)
+	}
+}
+
+// OnExpire implements types.ExpiryNotifier.
+func (h *HybridEngine) OnExpire(fn func(key string, rec *types.Record)) { h.feed.expiry.add(fn) }
+
+// holds reports whether memory or disk has a record for key, expired or
+// not.
+func (h *HybridEngine) holds(key string) bool {
+	_, held := h.memory.held(key, time.Now())
+	return held || h.disk.stored(key) != nil
+}
+
+// reap removes key from every tier, as gc does, once its latest record,
+// which loading it puts in memory, has expired.
+func (h *HybridEngine) reap(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	defer h.evictLocked()
+
+	if h.writable() != nil {
+		return
+	}
+	h.loadLocked(key)
+	h.memory.mu.RLock()
+	rec := h.memory.records[key]
+	h.memory.mu.RUnlock()
+	if rec == nil || !rec.Expired(time.Now()) {
+		return
+	}
+	if err := h.deleteTiersLocked(h.ctx, key); err != nil {
+		return
+	}
+	h.memory.mu.Lock()
+	defer h.memory.mu.Unlock()
+	delete(h.memory.records, key)
+	h.cache.remove(key)
+	h.feed.expired(key, rec)
+}
+
+var (
+	_ types.ExpiryNotifier = (*MemoryEngine)(nil)
+	_ types.ExpiryNotifier = (*DiskEngine)(nil)
+	_ types.ExpiryNotifier = (*ColumnarEngine)(nil)
+	_ types.ExpiryNotifier = (*VectorEngine)(nil)
+	_ types.ExpiryNotifier = (*HybridEngine)(nil)
+)
@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+const (
+	feedHistory = 1024 // changes retained for watchers resuming with fromSeq
+	feedBuffer  = 256  // live changes a watcher may fall behind by
+)
+
+// feed is an engine's change feed. Engines emit while holding their write
+// lock, so events are in commit order. A nil feed ignores everything.
+type feed struct {
+	mu       sync.Mutex
+	seq      uint64
+	history  []types.ChangeEvent // ring of the last feedHistory events
+	watchers map[*watcher]struct{}
+	closed   bool
+	text     *textIndex // kept current with every change emitted
+	tags     *tagIndex  // likewise
+	changes  *changeIndex
+	usage    *memoryUsage
+	expiry   *expiryHooks
+	queue    *indexQueue // text and tag updates behind the writes; nil to apply them as emitted
+}
+
+type watcher struct {
+	prefix string
+	ch     chan types.ChangeEvent
+}
+
+func newFeed(cfg *config.Config) *feed {
+	f := &feed{watchers: make(map[*watcher]struct{}), text: newTextIndex(cfg.TextIndex), tags: newTagIndex(),
+		changes: newChangeIndex(cfg.ChangesMaxTombstones), usage: newMemoryUsage(), expiry: newExpiryHooks()}
+	f.queue = newIndexQueue(cfg, nil, func(job indexJob) error {
+		f.text.apply(job.op, job.key, job.rec)
+		f.tags.apply(job.op, job.key, job.rec)
+		return nil
+	})
+	return f
+}
+
+func (f *feed) put(key string, rec *types.Record)     { f.emit(types.OpPut, key, rec) }
+func (f *feed) deleted(key string, rec *types.Record) { f.emit(types.OpDelete, key, rec) }
+func (f *feed) expired(key string, rec *types.Record) { f.emit(types.OpExpire, key, rec) }
+
+// index keeps the indexes current with a change that is not emitted, as
+// recovery replays them. Such a delete leaves no tombstone.
+func (f *feed) index(op types.Operation, key string, rec *types.Record) {
+	if f != nil {
+		f.search(op, key, rec)
+		f.changes.apply(op, key, rec, false)
+		f.usage.apply(op, key, rec)
+	}
+}
+
+// search updates the text and tag indexes for a change, or queues the
+// update.
+func (f *feed) search(op types.Operation, key string, rec *types.Record) {
+	if f.queue != nil {
+		f.queue.add(op, key, rec)
+		return
+	}
+	f.text.apply(op, key, rec)
+	f.tags.apply(op, key, rec)
+}
+
+// emit never blocks: a watcher whose buffer is full is dropped, and
+// resumes from the last Seq it saw.
+func (f *feed) emit(op types.Operation, key string, rec *types.Record) {
+	if f == nil {
+		return
+	}
+	rec = unpack(rec) // a delete or expiry hands over the record kept
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.search(op, key, rec)
+	f.changes.apply(op, key, rec, true)
+	f.usage.apply(op, key, rec)
+	if op == types.OpExpire {
+		f.expiry.notify(key, rec)
+	}
+	f.seq++
+	ev := types.ChangeEvent{Seq: f.seq, Op: op, Key: key, Record: rec}
+	if len(f.history) < feedHistory {
+		f.history = append(f.history, ev)
+	} else {
+		f.history[(f.seq-1)%feedHistory] = ev
+	}
+	for w := range f.watchers {
+		if !strings.HasPrefix(key, w.prefix) {
+			continue
+		}
+		select {
+		case w.ch <- ev:
+		default:
+			f.removeLocked(w)
+		}
+	}
+}
+
+// watch implements types.Watcher.
+func (f *feed) watch(ctx context.Context, prefix string, fromSeq uint64) (<-chan types.ChangeEvent, error) {
+	if f == nil {
+		return nil, fmt.Errorf("engine has no change feed")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return nil, fmt.Errorf("engine is closed")
+	}
+	oldest := f.seq - uint64(len(f.history)) // history holds (oldest, seq]
+	if fromSeq > f.seq || (fromSeq > 0 && fromSeq < oldest) {
+		return nil, fmt.Errorf("%w: asked to resume after %d, have %d to %d", types.ErrHistoryUnavailable, fromSeq, oldest+1, f.seq)
+	}
+
+	w := &watcher{prefix: prefix, ch: make(chan types.ChangeEvent, feedHistory+feedBuffer)}
+	if fromSeq > 0 {
+		for seq := fromSeq + 1; seq <= f.seq; seq++ {
+			if ev := f.history[(seq-1)%feedHistory]; strings.HasPrefix(ev.Key, prefix) {
+				w.ch <- ev
+			}
+		}
+	}
+	f.watchers[w] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.removeLocked(w)
+	}()
+	return w.ch, nil
+}
+
+func (f *feed) removeLocked(w *watcher) {
+	if _, ok := f.watchers[w]; ok {
+		delete(f.watchers, w)
+		close(w.ch)
+	}
+}
+
+// close applies the queued index updates, ends every watch and stops the
+// OnExpire callbacks, waiting for those running. Engines call it without
+// holding their lock, which a running callback may be waiting for.
+func (f *feed) close() {
+	if f == nil {
+		return
+	}
+	if f.queue != nil {
+		f.queue.close()
+	}
+	f.mu.Lock()
+	f.closed = true
+	for w := range f.watchers {
+		f.removeLocked(w)
+	}
+	f.mu.Unlock()
+	f.expiry.close()
+}
@@ -2,156 +2,884 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/btree"
+	"github.com/thirawat27/kvi/internal/tracing"
 	"github.com/thirawat27/kvi/pkg/config"
 	"github.com/thirawat27/kvi/pkg/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// HybridEngine serves reads from a memory tier holding the most recently
+// used records, up to CacheSizeMB, and keeps every record in the disk,
+// columnar and vector tiers. Writes go to memory first and reach disk and
+// columnar through an async queue; a record leaves memory only once disk
+// has it.
 type HybridEngine struct {
+	engineState
+
 	config      *config.Config
 	memory      *MemoryEngine
+	cache       *hotCache
 	disk        *DiskEngine
 	vectorStore *VectorEngine
 	columnStore *ColumnarEngine
+	vload       *vectorLoad // nil once opening indexed the recovered vectors
+
+	mu         sync.RWMutex
+	writeChan  chan queuedWrite
+	spillMu    sync.Mutex
+	spilled    []queuedWrite // writes past a full writeChan, in order
+	spillReady chan struct{} // signalled when spilled stops being empty
+	queued     atomic.Int64  // writes queued or spilled and not yet applied
+	written    atomic.Uint64 // seq of the last write queued
+	applied    atomic.Uint64 // seq of the last write applied
+	pendingMu  sync.Mutex
+	pending    map[string]int // queued writes per key
+	workerDone chan struct{}  // closed when asyncWorker exits
+	writer     *worker        // asyncWorker's numbers
+	closing    time.Time      // when Close began
+	drained    drainReport    // set by asyncWorker as it exits
+	wg         sync.WaitGroup
+	ctx        context.Context
+	cancel     context.CancelFunc
+	feed       *feed
+	tracer     *tracing.Tracer
+
+	collections collectionCache
+}
 
-	mu        sync.RWMutex
-	writeChan chan *types.Record
-	wg        sync.WaitGroup
-	ctx       context.Context
-	cancel    context.CancelFunc
+// tierConfig is the config of an in-memory tier of the hybrid engine: the
+// hybrid config, logger and all, in the tier's mode and without a WAL.
+func tierConfig(cfg *config.Config, mode types.Mode) *config.Config {
+	tier := *cfg
+	tier.Mode = mode
+	tier.EnableWAL = false
+	tier.AsyncIndexing = false // its writes are behind the async writer already
+	return &tier
 }
 
 func NewHybridEngine(cfg *config.Config) (*HybridEngine, error) {
-	mem := NewMemoryEngine(cfg)
+	mem, err := NewMemoryEngine(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init memory engine: %w", err)
+	}
+	mem.feed = nil   // the hybrid engine feeds its own changes, not its tiers'
+	mem.tracer = nil // and traces its own calls
 
 	disk, err := NewDiskEngine(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to init disk engine: %w", err)
 	}
+	disk.feed, disk.tracer = nil, nil // its WAL still traces flushes
 
-	vecConfig := config.VectorConfig(cfg.VectorDim)
-	vec, err := NewVectorEngine(vecConfig)
+	vec, err := NewVectorEngine(tierConfig(cfg, types.ModeVector))
 	if err != nil {
 		return nil, fmt.Errorf("failed to init vector engine: %w", err)
 	}
+	vec.feed, vec.tracer = nil, nil
 
-	col, err := NewColumnarEngine(config.ColumnarConfig())
+	col, err := NewColumnarEngine(tierConfig(cfg, types.ModeColumnar))
 	if err != nil {
 		return nil, fmt.Errorf("failed to init columnar engine: %w", err)
 	}
+	col.feed, col.tracer = nil, nil
 
 	ctx, cancel := context.WithCancel(context.Background())
 
 	h := &HybridEngine{
 		config:      cfg,
 		memory:      mem,
+		cache:       newHotCache(int64(cfg.CacheSizeMB) << 20),
 		disk:        disk,
 		vectorStore: vec,
 		columnStore: col,
-		writeChan:   make(chan *types.Record, 1000),
+		vload:       newVectorLoad(cfg.VectorIndexLoad),
+		writeChan:   make(chan queuedWrite, max(cfg.AsyncQueueSize, 1)),
+		spillReady:  make(chan struct{}, 1),
+		pending:     make(map[string]int),
+		workerDone:  make(chan struct{}),
 		ctx:         ctx,
 		cancel:      cancel,
+		feed:        newFeed(cfg),
+		tracer:      tracing.New(cfg.TracerProvider),
+	}
+	h.space.dir = cfg.DataDir
+	h.space.minFree.Store(uint64(cfg.DiskFenceMB) << 20)
+	h.indexing.add(h.feed.queue)
+	if err := h.loadTiers(); err != nil {
+		disk.Close()
+		return nil, fmt.Errorf("failed to load recovered records: %w", err)
 	}
 
+	h.writer = h.workers.add("async_writer", writerHeartbeat, cfg.WorkerStallIntervals, func() int { return int(h.queued.Load()) })
 	h.wg.Add(1)
 	go h.asyncWorker()
+	if cfg.VectorIndexLoad == config.VectorLoadBackground {
+		h.startVectorLoad()
+	}
 
 	return h, nil
 }
 
-func (h *HybridEngine) asyncWorker() {
-	defer h.wg.Done()
+func (h *HybridEngine) Put(ctx context.Context, key string, record *types.Record) error {
+	ctx, span := h.tracer.Start(ctx, "hybrid.Put")
+	defer span.End()
 
-	for {
-		select {
-		case <-h.ctx.Done():
-			// Flush remaining
-			for len(h.writeChan) > 0 {
-				rec := <-h.writeChan
-				_ = h.disk.Put(context.Background(), rec.ID, rec)
-				_ = h.columnStore.Put(context.Background(), rec.ID, rec)
-			}
-			return
-		case rec := <-h.writeChan:
-			// Write to disk
-			if err := h.disk.Put(context.Background(), rec.ID, rec); err != nil {
-				fmt.Printf("Disk async write error: %v\n", err)
-			}
-			// Write to columnar
-			if err := h.columnStore.Put(context.Background(), rec.ID, rec); err != nil {
-				fmt.Printf("Columnar async write error: %v\n", err)
-			}
+	if err := h.writable(); err != nil {
+		return err
+	}
+	if err := h.checkVector(record); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	defer h.evictLocked()
+
+	h.loadLocked(key)
+	return h.writeLocked(ctx, key, record)
+}
+
+// BatchPut writes the records in order under one lock, each as Put does,
+// stopping at the first failure.
+func (h *HybridEngine) BatchPut(ctx context.Context, records []*types.Record) error {
+	if err := h.writable(); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := h.checkVector(rec); err != nil {
+			return err
 		}
 	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	defer h.evictLocked()
+
+	for _, rec := range records {
+		h.loadLocked(rec.ID)
+		if err := h.writeLocked(ctx, rec.ID, rec); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (h *HybridEngine) Put(ctx context.Context, key string, record *types.Record) error {
+// writeLocked stores a record whose key memory holds if anything does. It
+// checks the vector index has room, makes room in the async queue, stamps
+// the version, logs the record and only then changes any tier, so a write
+// that fails changes nothing.
+func (h *HybridEngine) writeLocked(ctx context.Context, key string, record *types.Record) error {
+	return h.writeFromLocked(ctx, "", key, record)
+}
+
+// writeFromLocked is writeLocked for a record moving to key from the key
+// from, unless that is "": the write is logged as an OpRename, and the
+// caller removes from once it succeeds.
+func (h *HybridEngine) writeFromLocked(ctx context.Context, from, key string, record *types.Record) error {
+	if len(record.Vector) > 0 {
+		if err := h.vectorStore.fits(key, record.Vector); err != nil {
+			return err
+		}
+	}
+	if err := h.admitLocked(ctx); err != nil {
+		return err
+	}
+	h.memory.mu.RLock()
+	stamp(h.memory.records[key], record)
+	h.memory.mu.RUnlock()
+	op := types.OpPut
+	if from != "" {
+		op = types.OpRename
+	}
+	if err := h.logLocked(op, from, key, record); err != nil {
+		return err
+	}
+
 	// 1. Sync write to Memory for fast access
 	if err := h.memory.Put(ctx, key, record); err != nil {
 		return err
 	}
+	h.feed.put(key, record)
+	return h.forwardLocked(ctx, key, record)
+}
+
+// logLocked writes a change through to the WAL before it is acknowledged,
+// so a crash cannot lose it while it waits in the async queue. Without a
+// WAL the queue is all there is. from is the key an OpRename moves from.
+func (h *HybridEngine) logLocked(op types.Operation, from, key string, record *types.Record) error {
+	if !h.config.EnableWAL {
+		return nil
+	}
+	if op == types.OpRename {
+		return h.disk.wal.WriteRename(from, key, record, true)
+	}
+	return h.disk.wal.WriteThrough(op, key, record)
+}
+
+// checkVector rejects a vector the vector tier would, before any tier is
+// written. Records without one are fine.
+func (h *HybridEngine) checkVector(rec *types.Record) error {
+	if len(rec.Vector) == 0 {
+		return nil
+	}
+	return checkVector(rec.Vector, h.config.VectorDim)
+}
+
+// loadTiers indexes the records the disk tier recovered from the WAL in
+// the vector and columnar tiers, which keep nothing across restarts, and
+// by tag. The memory tier fills as keys are read, and the vector tier
+// later unless vector_index_load is eager.
+func (h *HybridEngine) loadTiers() error {
+	ctx := context.Background()
+	var err error
+	h.disk.tree.Ascend(func(i btree.Item) bool {
+		item := i.(btreeItem)
+		if live(item.rec) == nil {
+			return true
+		}
+		h.feed.index(types.OpPut, item.key, item.rec)
+		tier := *item.rec
+		if len(tier.Vector) > 0 && h.vload == nil {
+			if err = h.vectorStore.Put(ctx, item.key, &tier); err != nil {
+				return false
+			}
+		}
+		err = h.columnStore.Put(ctx, item.key, &tier)
+		return err == nil
+	})
+	return err
+}
 
-	// 2. Check if vector data exists
-	if _, ok := record.Data["vector"]; ok {
-		if err := h.vectorStore.Put(ctx, key, record); err != nil {
+// forwardLocked copies a record already written to memory into the other
+// tiers. They get their own copy so they never touch the one being served.
+// The caller has made room in the queue with admitLocked.
+func (h *HybridEngine) forwardLocked(ctx context.Context, key string, record *types.Record) error {
+	h.cache.add(key, recordSize(key, h.memory.kept(key)))
+	tier := *record
+
+	// 2. Index the vector, or drop the one an earlier version had
+	if len(record.Vector) > 0 {
+		if err := h.vectorStore.Put(ctx, key, &tier); err != nil {
 			return err
 		}
+	} else {
+		_ = h.vectorStore.Delete(ctx, key)
 	}
 
 	// 3. Async write to disk & columnar
-	select {
-	case h.writeChan <- record:
-	case <-time.After(100 * time.Millisecond):
-		return fmt.Errorf("async write queue full")
-	}
-
+	h.queueLocked(queuedWrite{key: key, rec: &tier})
 	return nil
 }
 
 func (h *HybridEngine) Get(ctx context.Context, key string) (*types.Record, error) {
-	// First check memory
-	if rec, err := h.memory.Get(ctx, key); err == nil {
+	ctx, span := h.tracer.Start(ctx, "hybrid.Get")
+	defer span.End()
+
+	if err := h.open(); err != nil {
+		return nil, err
+	}
+	if rec := h.memory.lookup(key); rec != nil {
+		h.cache.memoryHits.Add(1)
+		h.cache.touch(key)
 		return rec, nil
 	}
 
-	// Fallback to disk
-	rec, err := h.disk.Get(ctx, key)
-	if err == nil {
-		// Populate memory
-		_ = h.memory.Put(ctx, key, rec)
+	// A key memory lacks has no writes queued, so disk has its latest
+	// record, and the read lock keeps writers out until it is cached
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	defer h.evictLocked()
+
+	if rec := h.memory.lookup(key); rec != nil { // cached meanwhile
+		h.cache.memoryHits.Add(1)
 		return rec, nil
 	}
+	rec, err := h.disk.Get(ctx, key)
+	if err != nil {
+		h.cache.misses.Add(1)
+		if h.feed.expiry.listening() && h.holds(key) {
+			h.feed.reapLater(key, h.reap)
+		}
+		return nil, err
+	}
+	h.cache.diskHits.Add(1)
+	h.promoteLocked(key, rec)
+	return rec, nil
+}
+
+// BatchGet reads the memory tier under one lock, then the disk tier for the
+// keys it missed, caching what disk had as Get does.
+func (h *HybridEngine) BatchGet(ctx context.Context, keys []string) (map[string]*types.Record, error) {
+	if err := h.open(); err != nil {
+		return nil, err
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	defer h.evictLocked()
+
+	found, fromDisk := h.batchLookup(ctx, keys)
+	for _, key := range keys {
+		switch {
+		case found[key] == nil:
+			h.cache.misses.Add(1)
+		case fromDisk[key] == nil:
+			h.cache.memoryHits.Add(1)
+			h.cache.touch(key)
+		default:
+			h.cache.diskHits.Add(1)
+		}
+	}
+	for key, rec := range fromDisk {
+		h.promoteLocked(key, rec)
+	}
+	return found, nil
+}
+
+// promoteLocked caches a record read from disk, unless memory has the key
+// already. The caller holds h.mu, so no write of key is queued.
+func (h *HybridEngine) promoteLocked(key string, rec *types.Record) {
+	if h.memory.fill(key, rec) {
+		h.cache.add(key, recordSize(key, h.memory.kept(key)))
+	}
+}
+
+// loadLocked caches key's disk record, expired or not, before a write to
+// the memory tier, so the write continues its versions.
+func (h *HybridEngine) loadLocked(key string) {
+	if _, ok := h.memory.held(key, time.Now()); ok {
+		return
+	}
+	if rec := h.disk.stored(key); rec != nil {
+		h.promoteLocked(key, rec)
+	}
+}
+
+// evictLocked drops least recently used records from memory until the
+// cache fits its capacity, and the engine max_memory_mb. Keys with writes
+// still queued stay, as disk does not have them yet, so the cache can run
+// over by that much.
+func (h *HybridEngine) evictLocked() {
+	keys := h.cache.evict(h.cacheLimit(), h.isPending)
+	if len(keys) == 0 {
+		return
+	}
+	h.memory.mu.Lock()
+	defer h.memory.mu.Unlock()
+	for _, key := range keys {
+		delete(h.memory.records, key)
+	}
+}
+
+// cacheLimit is how many bytes the memory tier may hold: cache_size_mb,
+// or less if that would take the engine past max_memory_mb with the
+// records the disk tier holds and the vector index. The memory tier is
+// all that can give memory back, so once those alone are past the bound
+// it holds no more than its pinned keys and queued writes.
+func (h *HybridEngine) cacheLimit() int64 {
+	limit := h.cache.capacity
+	bound := int64(h.config.MaxMemoryMB) << 20
+	if bound <= 0 {
+		return limit
+	}
+	h.vectorStore.mu.RLock()
+	index := h.vectorStore.index.Stats().MemoryBytes
+	h.vectorStore.mu.RUnlock()
+	room := max(bound-h.feed.recordBytes()-index, 1)
+	if limit == 0 || room < limit {
+		limit = room
+	}
+	return limit
+}
+
+func (h *HybridEngine) isPending(key string) bool {
+	h.pendingMu.Lock()
+	defer h.pendingMu.Unlock()
+	return h.pending[key] > 0
+}
+
+// Pin pins key in the memory tier, loading its record from disk.
+func (h *HybridEngine) Pin(ctx context.Context, key string) error {
+	if err := h.open(); err != nil {
+		return err
+	}
+	h.cache.pin(key)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.loadLocked(key)
+	return nil
+}
+
+func (h *HybridEngine) Unpin(ctx context.Context, key string) error {
+	if err := h.open(); err != nil {
+		return err
+	}
+	h.cache.unpin(key)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.evictLocked()
+	return nil
+}
+
+func (h *HybridEngine) Pinned() []string {
+	return h.cache.pinnedKeys()
+}
+
+// batchLookup finds keys in the memory tier and then the disk tier;
+// fromDisk holds the records only disk had.
+func (h *HybridEngine) batchLookup(ctx context.Context, keys []string) (found, fromDisk map[string]*types.Record) {
+	found, _ = h.memory.BatchGet(ctx, keys)
+	var missed []string
+	for _, key := range keys {
+		if found[key] == nil {
+			missed = append(missed, key)
+		}
+	}
+	if len(missed) == 0 {
+		return found, nil
+	}
+	fromDisk, _ = h.disk.BatchGet(ctx, missed)
+	for key, rec := range fromDisk {
+		found[key] = rec
+	}
+	return found, fromDisk
+}
+
+// BatchDelete deletes every key under one hybrid write lock.
+func (h *HybridEngine) BatchDelete(ctx context.Context, keys []string) (map[string]bool, error) {
+	if err := h.writable(); err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-	return nil, err
+	existing, _ := h.batchLookup(ctx, keys)
+	deleted := make(map[string]bool, len(existing))
+	for _, key := range keys {
+		if err := h.deleteTiersLocked(ctx, key); err != nil {
+			return deleted, err
+		}
+		h.deleteMemoryLocked(key)
+		if existing[key] != nil {
+			deleted[key] = true
+		}
+	}
+	return deleted, nil
 }
 
 func (h *HybridEngine) Delete(ctx context.Context, key string) error {
-	// Delete from memory and disk synchronously to ensure data integrity
-	_ = h.memory.Delete(ctx, key)
+	if err := h.writable(); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err := h.deleteTiersLocked(ctx, key); err != nil {
+		return err
+	}
+	h.deleteMemoryLocked(key)
+	return nil
+}
+
+// deleteMemoryLocked removes key from the memory tier, feeding the delete
+// if there was a record.
+func (h *HybridEngine) deleteMemoryLocked(key string) {
+	h.memory.mu.Lock()
+	defer h.memory.mu.Unlock()
+
+	if rec, ok := h.memory.records[key]; ok {
+		delete(h.memory.records, key)
+		h.feed.deleted(key, rec)
+	}
+	h.cache.remove(key)
+}
+
+// deleteTiersLocked logs the delete of key and removes it from every tier
+// but memory, which the caller updates once this succeeds.
+func (h *HybridEngine) deleteTiersLocked(ctx context.Context, key string) error {
+	if err := h.awaitKeyLocked(ctx, key); err != nil {
+		return err
+	}
+	if err := h.logLocked(types.OpDelete, "", key, nil); err != nil {
+		return err
+	}
+	h.dropTiersLocked(ctx, key)
+	return nil
+}
+
+// dropTiersLocked removes key from every tier but memory once its queued
+// writes are awaited, its removal logged.
+func (h *HybridEngine) dropTiersLocked(ctx context.Context, key string) {
 	_ = h.vectorStore.Delete(ctx, key)
 	_ = h.columnStore.Delete(ctx, key)
-	return h.disk.Delete(ctx, key)
+	h.disk.deleteTree(key)
 }
 
+// Update applies fn to a copy of the memory tier's record and writes the
+// result like Put.
+func (h *HybridEngine) Update(ctx context.Context, key string, fn func(*types.Record) error) (*types.Record, error) {
+	if err := h.writable(); err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	defer h.evictLocked()
+
+	h.loadLocked(key)
+	cur := h.memory.lookup(key)
+	if cur == nil {
+		return nil, fmt.Errorf("%w for key: %s", types.ErrKeyNotFound, key)
+	}
+	next := cur.Clone()
+	if err := fn(next); err != nil {
+		return nil, err
+	}
+	if err := h.checkVector(next); err != nil {
+		return nil, err
+	}
+	if err := h.writeLocked(ctx, key, next); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// CompareAndSwap checks the version against the memory tier, which sees
+// every write first, once the key is loaded into it, then propagates the
+// change like Put or Delete.
+func (h *HybridEngine) CompareAndSwap(ctx context.Context, key string, version uint64, record *types.Record) error {
+	if err := h.writable(); err != nil {
+		return err
+	}
+	if record != nil {
+		if err := h.checkVector(record); err != nil {
+			return err
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	defer h.evictLocked()
+
+	h.loadLocked(key)
+	if err := checkVersion(key, h.memory.lookup(key), version); err != nil {
+		return err
+	}
+	if record != nil {
+		return h.writeLocked(ctx, key, record)
+	}
+	if err := h.deleteTiersLocked(ctx, key); err != nil {
+		return err
+	}
+	if version == 0 { // no record was there, so no delete to feed
+		_ = h.memory.Delete(ctx, key)
+		h.cache.remove(key)
+		return nil
+	}
+	h.deleteMemoryLocked(key)
+	return nil
+}
+
+// Scan merges the memory and disk tiers in key order. Memory has the newer
+// copy of a key in both, since disk copies may still be waiting in the
+// async queue; disk has the keys memory does not hold.
+func (h *HybridEngine) Scan(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+	ctx, span := h.tracer.Start(ctx, "hybrid.Scan")
+	defer span.End()
+
+	if err := h.open(); err != nil {
+		return err
+	}
+	return h.walk(ctx, prefix, func(item btreeItem) bool { return fn(item.rec) })
+}
+
+// walk is Scan handing fn the key alongside each record.
+func (h *HybridEngine) walk(ctx context.Context, prefix string, fn func(btreeItem) bool) error {
+	return h.walkProjected(ctx, prefix, nil, fn)
+}
+
+// walkProjected is walk handing fn only what p picks of each record.
+func (h *HybridEngine) walkProjected(ctx context.Context, prefix string, p *types.Projection, fn func(btreeItem) bool) error {
+	now := time.Now()
+	keys := prefixKeys(&h.memory.mu, h.memory.records, prefix)
+	held := func(key string) (*types.Record, bool) { return h.memory.heldProjected(key, now, p) }
+	return mergeScan(ctx, keys, held, &h.disk.mu, h.disk.tree, prefix, p, fn)
+}
+
+// ScanConsistent implements types.ConsistentScanner. Writers are held off
+// while the memory tier is copied and the disk tier cloned, in that order:
+// the async writer only brings disk up to what memory has, and keys memory
+// lacks have no writes queued.
+func (h *HybridEngine) ScanConsistent(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+	ctx, span := h.tracer.Start(ctx, "hybrid.ScanConsistent")
+	defer span.End()
+
+	if err := h.open(); err != nil {
+		return err
+	}
+	h.mu.RLock()
+	h.memory.mu.RLock()
+	memory := make(map[string]*types.Record)
+	for k, rec := range h.memory.records {
+		if strings.HasPrefix(k, prefix) {
+			memory[k] = rec
+		}
+	}
+	h.memory.mu.RUnlock()
+	tree := h.disk.snapshot()
+	h.mu.RUnlock()
+
+	now := time.Now()
+	keys := slices.Sorted(maps.Keys(memory))
+	held := func(key string) (*types.Record, bool) {
+		rec, ok := memory[key]
+		return unpack(liveAt(rec, now)), ok
+	}
+	return mergeScan(ctx, keys, held, new(sync.RWMutex), tree, prefix, nil, func(item btreeItem) bool { return fn(item.rec) })
+}
+
+// mergeScan scans the disk tier's tree in key order with the memory tier's
+// records for keys, sorted, taking the place of disk's. held reports
+// memory's live record for a key, and whether it still has the key at all.
+// fn gets each key alongside its record, of which a disk record is cut
+// down to what p picks; held is expected to have done the same.
+func mergeScan(ctx context.Context, keys []string, held func(string) (*types.Record, bool), mu *sync.RWMutex, tree *btree.BTree, prefix string, p *types.Projection, fn func(btreeItem) bool) error {
+	next, stopped := 0, false
+	// fromMemory hands fn the memory records for the keys before key, or
+	// for all keys left when last is set; false means the scan is over
+	fromMemory := func(key string, last bool) bool {
+		for ; next < len(keys) && (last || keys[next] < key); next++ {
+			if ctx.Err() != nil {
+				return false
+			}
+			if rec, _ := held(keys[next]); rec != nil && !fn(btreeItem{key: keys[next], rec: rec}) {
+				stopped = true
+				return false
+			}
+		}
+		return true
+	}
+	err := walkTree(ctx, mu, tree, prefix, nil, func(item btreeItem) bool {
+		if !fromMemory(item.key, false) {
+			return false
+		}
+		var rec *types.Record
+		kept := false
+		if next < len(keys) && keys[next] == item.key {
+			next++
+			rec, kept = held(item.key) // else evicted since
+		}
+		switch {
+		case !kept:
+			rec = p.Apply(item.rec)
+		case rec == nil:
+			return true
+		}
+		if !fn(btreeItem{key: item.key, rec: rec}) {
+			stopped = true
+			return false
+		}
+		return true
+	})
+	if err != nil || stopped {
+		return err
+	}
+	fromMemory("", true)
+	if stopped {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// Apply implements types.Replica. The record replaces whatever the memory
+// and vector tiers held for the key, so it keeps the primary's version
+// rather than being stamped after theirs, and then goes through the tiers
+// like a Put.
+func (h *HybridEngine) Apply(ctx context.Context, ev types.ChangeEvent) error {
+	if err := h.open(); err != nil {
+		return err
+	}
+	if err := checkApply(ev); err != nil {
+		return err
+	}
+	if ev.Op == types.OpPut {
+		if err := h.checkVector(ev.Record); err != nil {
+			return err
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	defer h.evictLocked()
+
+	if ev.Op == types.OpDelete {
+		if err := h.deleteTiersLocked(ctx, ev.Key); err != nil {
+			return err
+		}
+		h.deleteMemoryLocked(ev.Key)
+		return nil
+	}
+	if err := h.admitLocked(ctx); err != nil {
+		return err
+	}
+	h.memory.mu.Lock()
+	delete(h.memory.records, ev.Key)
+	h.memory.mu.Unlock()
+	_ = h.vectorStore.Delete(ctx, ev.Key)
+	return h.writeLocked(ctx, ev.Key, ev.Record)
+}
+
+// LSN implements types.LogShipper.
+func (h *HybridEngine) LSN() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.disk.wal.ShippedLSN()
+}
+
+// Ship implements types.LogShipper, from the WAL the hybrid engine writes
+// through before queueing.
+func (h *HybridEngine) Ship(ctx context.Context, afterLSN uint64) (<-chan types.ChangeEvent, error) {
+	if err := h.open(); err != nil {
+		return nil, err
+	}
+	return h.disk.Ship(ctx, afterLSN)
+}
+
+func (h *HybridEngine) Watch(ctx context.Context, prefix string, fromSeq uint64) (<-chan types.ChangeEvent, error) {
+	if err := h.open(); err != nil {
+		return nil, err
+	}
+	return h.feed.watch(ctx, prefix, fromSeq)
+}
+
+// Close waits up to AsyncDrainTimeoutMs for the async queue to reach disk.
+// Writes it leaves behind are still in the WAL.
 func (h *HybridEngine) Close() error {
+	if err := h.close(); err != nil {
+		return err
+	}
+	h.feed.close()
+	h.closing = time.Now()
 	h.cancel()
 	h.wg.Wait()
 
+	log := h.config.Log().With("engine", "hybrid", "flushed", h.drained.flushed, "left", h.drained.left)
+	var drainErr error
+	if h.drained.left > 0 {
+		drainErr = fmt.Errorf("async queue: %d writes not applied to disk within %dms", h.drained.left, h.config.AsyncDrainTimeoutMs)
+		log.Warn("async queue drain timed out")
+	} else {
+		log.Info("async queue drained")
+	}
+
 	h.memory.Close()
 	h.vectorStore.Close()
 	h.columnStore.Close()
-	return h.disk.Close()
+	return errors.Join(drainErr, h.disk.Close())
+}
+
+// Sync implements types.Syncer. Writes reach the WAL before they are
+// acknowledged, queued for the disk tier or not, so syncing it is enough.
+func (h *HybridEngine) Sync(ctx context.Context) error {
+	if err := h.open(); err != nil {
+		return err
+	}
+	return h.disk.Sync(ctx)
 }
 
 func (h *HybridEngine) Search(ctx context.Context, query []float32, k int) ([]*types.Record, error) {
+	ctx, span := h.tracer.Start(ctx, "hybrid.Search")
+	defer span.End()
+
+	if err := h.open(); err != nil {
+		return nil, err
+	}
+	if err := h.vectorsReady(); err != nil {
+		return nil, err
+	}
 	return h.vectorStore.Search(ctx, query, k)
 }
 
+// SearchFiltered implements types.FilteredSearcher over the vector tier,
+// whose copies of the records carry their data.
+func (h *HybridEngine) SearchFiltered(ctx context.Context, query []float32, k int, filter map[string]interface{}) ([]*types.Record, error) {
+	ctx, span := h.tracer.Start(ctx, "hybrid.SearchFiltered")
+	defer span.End()
+
+	if err := h.open(); err != nil {
+		return nil, err
+	}
+	if err := h.vectorsReady(); err != nil {
+		return nil, err
+	}
+	return h.vectorStore.SearchFiltered(ctx, query, k, filter)
+}
+
+// FilterFields implements types.FilteredSearcher.
+func (h *HybridEngine) FilterFields() []string { return h.vectorStore.FilterFields() }
+
+// SetFilterFields implements types.FilteredSearcher. Records the vector
+// tier is yet to load are posted as it loads them.
+func (h *HybridEngine) SetFilterFields(fields []string) error {
+	if err := h.open(); err != nil {
+		return err
+	}
+	return h.vectorStore.SetFilterFields(fields)
+}
+
 func (h *HybridEngine) Sum(columnName string) (float64, error) {
+	if err := h.open(); err != nil {
+		return 0, err
+	}
+	_, span := h.tracer.Start(context.Background(), "hybrid.Sum", trace.WithAttributes(attribute.String("kvi.column", columnName)))
+	defer span.End()
+
 	return h.columnStore.Sum(columnName)
 }
 
-var _ types.Engine = (*HybridEngine)(nil)
+func (h *HybridEngine) HealthChecks() map[string]func(context.Context) error {
+	checks := map[string]func(context.Context) error{
+		"wal":          h.disk.checkWAL,
+		"async_writer": h.checkWorker,
+		"workers":      h.workers.check,
+		"disk_space":   h.space.check,
+	}
+	if h.vload != nil && h.config.VectorIndexReadiness != config.ReadyForKV {
+		checks["vector_index"] = h.checkVectorLoad
+	}
+	return checks
+}
+
+// checkWorker fails once the async writer has stopped or fallen so far
+// behind that writes must wait or fail, as they do when the queue is full
+// unless it may spill.
+func (h *HybridEngine) checkWorker(ctx context.Context) error {
+	select {
+	case <-h.workerDone:
+		return errWorkerExited
+	default:
+	}
+	if h.config.AsyncQueueFull != config.QueueSpill && len(h.writeChan) == cap(h.writeChan) {
+		return fmt.Errorf("%w (%d records)", types.ErrQueueFull, cap(h.writeChan))
+	}
+	return nil
+}
+
+var (
+	_ types.Engine            = (*HybridEngine)(nil)
+	_ types.Watcher           = (*HybridEngine)(nil)
+	_ types.Searcher          = (*HybridEngine)(nil)
+	_ types.FilteredSearcher  = (*HybridEngine)(nil)
+	_ types.Batcher           = (*HybridEngine)(nil)
+	_ types.ConsistentScanner = (*HybridEngine)(nil)
+	_ types.Pinner            = (*HybridEngine)(nil)
+	_ types.Replica           = (*HybridEngine)(nil)
+	_ types.LogShipper        = (*HybridEngine)(nil)
+	_ types.Syncer            = (*HybridEngine)(nil)
+	_ types.Watermarker       = (*HybridEngine)(nil)
+)
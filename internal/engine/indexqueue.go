@@ -0,0 +1,291 @@
+package engine
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// indexJob is an index update a write queued.
+type indexJob struct {
+	op  types.Operation
+	key string
+	rec *types.Record
+	at  time.Time // when it was queued
+}
+
+// indexQueue applies index updates behind the writes that make them
+// (async_indexing). Updates are sharded by key over its workers, so those
+// to a key apply in the order they were queued. A writer that finds its
+// shard full applies the shard's backlog itself: the workers may need the
+// lock the writer holds, so it cannot wait for them.
+type indexQueue struct {
+	apply  func(indexJob) error
+	lock   sync.Locker // taken by workers before a shard, as writers hold it when they queue; nil if none
+	size   int         // updates a shard holds before its writer applies them
+	shards []*indexShard
+	start  sync.Once // the workers start with the first update
+	stop   chan struct{}
+	wg     sync.WaitGroup
+	closed atomic.Bool
+
+	mu      sync.Mutex
+	changed chan struct{} // closed, and replaced, whenever updates are applied
+
+	applied atomic.Uint64
+	errors  atomic.Uint64
+	lastErr atomic.Pointer[string]
+}
+
+type indexShard struct {
+	busy   sync.Mutex // held while updates are applied, so they apply in order
+	mu     sync.Mutex // guards what follows
+	jobs   []indexJob
+	since  time.Time // when the oldest update not yet applied was queued
+	queued uint64    // updates ever queued
+	done   uint64    // of those, applied
+	ready  chan struct{}
+}
+
+// newIndexQueue returns nil unless cfg asks for async indexing. apply is
+// called holding lock, if any.
+func newIndexQueue(cfg *config.Config, lock sync.Locker, apply func(indexJob) error) *indexQueue {
+	if !cfg.AsyncIndexing {
+		return nil
+	}
+	workers := max(1, cfg.AsyncIndexWorkers)
+	q := &indexQueue{
+		apply:   apply,
+		lock:    lock,
+		size:    max(1, cfg.AsyncIndexQueue/workers),
+		shards:  make([]*indexShard, workers),
+		stop:    make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+	for i := range q.shards {
+		q.shards[i] = &indexShard{ready: make(chan struct{}, 1)}
+	}
+	return q
+}
+
+// add queues an update to key. Once the queue is closed, or the shard is
+// full, it is applied before add returns, after those queued before it.
+func (q *indexQueue) add(op types.Operation, key string, rec *types.Record) {
+	q.start.Do(q.run)
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	s := q.shards[h.Sum32()%uint32(len(q.shards))]
+
+	now := time.Now()
+	s.mu.Lock()
+	s.jobs = append(s.jobs, indexJob{op: op, key: key, rec: rec, at: now})
+	s.queued++
+	if s.since.IsZero() {
+		s.since = now
+	}
+	full := len(s.jobs) > q.size
+	s.mu.Unlock()
+
+	if full || q.closed.Load() {
+		q.applyShard(s)
+		return
+	}
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *indexQueue) run() {
+	for _, s := range q.shards {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for {
+				select {
+				case <-s.ready:
+					q.drain(s)
+				case <-q.stop:
+					q.drain(s)
+					return
+				}
+			}
+		}()
+	}
+}
+
+// drain applies what s holds, taking the queue's lock.
+func (q *indexQueue) drain(s *indexShard) int {
+	if q.lock != nil {
+		q.lock.Lock()
+		defer q.lock.Unlock()
+	}
+	return q.applyShard(s)
+}
+
+// applyShard applies what s holds; the caller holds the queue's lock.
+func (q *indexQueue) applyShard(s *indexShard) int {
+	s.busy.Lock()
+	defer s.busy.Unlock()
+	s.mu.Lock()
+	jobs := s.jobs
+	s.jobs = nil
+	s.mu.Unlock()
+	if len(jobs) == 0 {
+		return 0
+	}
+
+	for _, job := range jobs {
+		if err := q.apply(job); err != nil {
+			q.errors.Add(1)
+			msg := err.Error()
+			q.lastErr.Store(&msg)
+		}
+	}
+	q.applied.Add(uint64(len(jobs)))
+
+	s.mu.Lock()
+	s.done += uint64(len(jobs))
+	s.since = time.Time{}
+	if len(s.jobs) > 0 {
+		s.since = s.jobs[0].at
+	}
+	s.mu.Unlock()
+
+	q.mu.Lock()
+	close(q.changed)
+	q.changed = make(chan struct{})
+	q.mu.Unlock()
+	return len(jobs)
+}
+
+// barrier returns once every update queued before it has been applied.
+func (q *indexQueue) barrier(ctx context.Context) error {
+	targets := make([]uint64, len(q.shards))
+	for i, s := range q.shards {
+		s.mu.Lock()
+		targets[i] = s.queued
+		s.mu.Unlock()
+	}
+	for {
+		q.mu.Lock()
+		changed := q.changed
+		q.mu.Unlock()
+		caughtUp := true
+		for i, s := range q.shards {
+			s.mu.Lock()
+			caughtUp = caughtUp && s.done >= targets[i]
+			s.mu.Unlock()
+		}
+		if caughtUp {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// flush applies every update queued on the caller's goroutine, returning
+// how many there were.
+func (q *indexQueue) flush() int {
+	n := 0
+	for _, s := range q.shards {
+		n += q.drain(s)
+	}
+	return n
+}
+
+// close stops the workers once they have applied everything queued. Later
+// updates are applied as they are added.
+func (q *indexQueue) close() {
+	q.closed.Store(true)
+	q.start.Do(func() {}) // no workers start from now on
+	close(q.stop)
+	q.wg.Wait()
+	q.flush()
+}
+
+// indexQueues are the queues of an engine's index updates, empty unless
+// it indexes asynchronously.
+type indexQueues []*indexQueue
+
+// add registers q if there is one.
+func (qs *indexQueues) add(q *indexQueue) {
+	if q != nil {
+		*qs = append(*qs, q)
+	}
+}
+
+func (qs indexQueues) barrier(ctx context.Context) error {
+	for _, q := range qs {
+		if err := q.barrier(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (qs indexQueues) flush() int {
+	n := 0
+	for _, q := range qs {
+		n += q.flush()
+	}
+	return n
+}
+
+// stats describes the queues together; nil if there are none.
+func (qs indexQueues) stats() *types.IndexingStats {
+	if len(qs) == 0 {
+		return nil
+	}
+	st := &types.IndexingStats{}
+	var oldest time.Time
+	for _, q := range qs {
+		st.Workers += len(q.shards)
+		st.Capacity += q.size * len(q.shards)
+		st.Applied += q.applied.Load()
+		st.Errors += q.errors.Load()
+		if msg := q.lastErr.Load(); msg != nil {
+			st.LastError = *msg
+		}
+		for _, s := range q.shards {
+			s.mu.Lock()
+			st.Queued += int(s.queued - s.done)
+			if !s.since.IsZero() && (oldest.IsZero() || s.since.Before(oldest)) {
+				oldest = s.since
+			}
+			s.mu.Unlock()
+		}
+	}
+	if !oldest.IsZero() {
+		st.LagMs = time.Since(oldest).Milliseconds()
+	}
+	return st
+}
+
+// IndexBarrier implements types.IndexQueuer.
+func (c *engineState) IndexBarrier(ctx context.Context) error {
+	if err := c.open(); err != nil {
+		return err
+	}
+	return c.indexing.barrier(ctx)
+}
+
+// FlushIndexes implements types.IndexQueuer.
+func (c *engineState) FlushIndexes() int { return c.indexing.flush() }
+
+var (
+	_ types.IndexQueuer = (*MemoryEngine)(nil)
+	_ types.IndexQueuer = (*DiskEngine)(nil)
+	_ types.IndexQueuer = (*ColumnarEngine)(nil)
+	_ types.IndexQueuer = (*VectorEngine)(nil)
+	_ types.IndexQueuer = (*HybridEngine)(nil)
+)
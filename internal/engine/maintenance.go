@@ -0,0 +1,349 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/btree"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// gcCounts counts an engine's garbage collection passes and the records
+// they removed, for Stats.
+type gcCounts struct {
+	runs      atomic.Uint64
+	reclaimed atomic.Uint64
+}
+
+// count records a pass that removed n records, passing its error on.
+func (c *gcCounts) count(n int, err error) error {
+	c.runs.Add(1)
+	c.reclaimed.Add(uint64(n))
+	return err
+}
+
+func (c *gcCounts) stats() *types.GCStats {
+	return &types.GCStats{Runs: c.runs.Load(), Reclaimed: c.reclaimed.Load()}
+}
+
+func (e *MemoryEngine) collected() *gcCounts   { return &e.gcs }
+func (e *DiskEngine) collected() *gcCounts     { return &e.gcs }
+func (e *ColumnarEngine) collected() *gcCounts { return &e.gcs }
+func (e *VectorEngine) collected() *gcCounts   { return &e.gcs }
+func (h *HybridEngine) collected() *gcCounts   { return &h.disk.gcs }
+
+// collectGarbage runs an engine's gc task every w.interval until stop is
+// closed, when the engine closes. Each pass is a cycle of w, processing
+// the records it reclaimed, as gcs counts them.
+func collectGarbage(log *slog.Logger, gc types.MaintenanceFunc, gcs *gcCounts, w *worker, stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		before := gcs.reclaimed.Load()
+		err := gc(ctx, func(done, total int) {})
+		select {
+		case <-stop:
+			return // failed, if it did, because the engine closed
+		default:
+		}
+		if errors.Is(err, types.ErrRecovering) {
+			err = nil // it runs once the log is replayed
+		}
+		if w.done(int(gcs.reclaimed.Load()-before), err) != nil {
+			log.Warn("garbage collection failed", "error", err)
+		}
+	}
+}
+
+// gcMap removes expired records from a map-backed engine and returns how
+// many. drop, if set, is called for each removed record so the engine can
+// update its indexes. Like Delete, removing a record lets the key's
+// versions start over.
+func gcMap(ctx context.Context, mu *sync.RWMutex, records map[string]*types.Record, progress func(done, total int), drop func(key string, rec *types.Record)) (int, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	now := time.Now()
+	total, done, removed := len(records), 0, 0
+	for key, rec := range records {
+		if rec.Expired(now) {
+			delete(records, key)
+			removed++
+			if drop != nil {
+				drop(key, rec)
+			}
+		}
+		if done++; done%scanChunk == 0 {
+			progress(done, total)
+			if err := ctx.Err(); err != nil {
+				return removed, err
+			}
+		}
+	}
+	progress(total, total)
+	return removed, nil
+}
+
+// ── Memory ───────────────────────────────────────────────────────────────────
+
+func (e *MemoryEngine) Maintenance() map[string]types.MaintenanceFunc {
+	return map[string]types.MaintenanceFunc{
+		types.MaintenanceRebuildIndexes: rebuildAll(e),
+		types.MaintenanceGC: func(ctx context.Context, progress func(done, total int)) error {
+			return e.gcs.count(gcMap(ctx, &e.mu, e.records, progress, e.feed.expired))
+		},
+	}
+}
+
+// rebuildAll runs RebuildIndexes for every kind of index eng has.
+func rebuildAll(eng types.IndexRebuilder) types.MaintenanceFunc {
+	return func(ctx context.Context, progress func(done, total int)) error {
+		return eng.RebuildIndexes(ctx, progress)
+	}
+}
+
+// ── Disk ─────────────────────────────────────────────────────────────────────
+
+// Maintenance tasks wait for recovery, failing with ErrRecovering until the
+// WAL has been replayed.
+func (e *DiskEngine) Maintenance() map[string]types.MaintenanceFunc {
+	tasks := map[string]types.MaintenanceFunc{types.MaintenanceGC: e.gc, types.MaintenanceRebuildIndexes: rebuildAll(e)}
+	if e.config.EnableWAL {
+		tasks[types.MaintenanceCheckpoint] = e.checkpoint
+		tasks[types.MaintenanceCompact] = e.compact
+	}
+	for op, task := range tasks {
+		tasks[op] = func(ctx context.Context, progress func(done, total int)) error {
+			if err := e.recovered(); err != nil {
+				return err
+			}
+			return task(ctx, progress)
+		}
+	}
+	return tasks
+}
+
+func (e *DiskEngine) checkpoint(ctx context.Context, progress func(done, total int)) error {
+	progress(0, 1)
+	if err := e.wal.Check(); err != nil {
+		return err
+	}
+	progress(1, 1)
+	return nil
+}
+
+// compact rewrites the WAL as one put per live record. Writers wait on the
+// read lock meanwhile; readers carry on.
+func (e *DiskEngine) compact(ctx context.Context, progress func(done, total int)) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	now := time.Now()
+	total, done := e.tree.Len(), 0
+	return e.wal.Rewrite(func(put func(key string, rec *types.Record) error) error {
+		var err error
+		e.tree.Ascend(func(i btree.Item) bool {
+			item := i.(btreeItem)
+			if !item.rec.Expired(now) {
+				err = put(item.key, item.rec)
+			}
+			if done++; done%scanChunk == 0 {
+				progress(done, total)
+				if err == nil {
+					err = ctx.Err()
+				}
+			}
+			return err == nil
+		})
+		if err == nil {
+			progress(total, total)
+		}
+		return err
+	})
+}
+
+// gc deletes expired records, logging each delete to the WAL.
+func (e *DiskEngine) gc(ctx context.Context, progress func(done, total int)) error {
+	return e.gcs.count(e.gcTree(ctx, progress, e.feed.expired))
+}
+
+// gcTree is gc calling drop for each removed record, returning how many
+// it removed. It refuses to run once the engine is closed, as the WAL is.
+func (e *DiskEngine) gcTree(ctx context.Context, progress func(done, total int), drop func(key string, rec *types.Record)) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.open(); err != nil {
+		return 0, err
+	}
+	expired := expiredKeys(e.tree, time.Now())
+	for i, key := range expired {
+		rec, err := e.removeLocked(key)
+		if err != nil {
+			return i, err
+		}
+		drop(key, rec)
+		if (i+1)%scanChunk == 0 {
+			progress(i+1, len(expired))
+			if err := ctx.Err(); err != nil {
+				return i + 1, err
+			}
+		}
+	}
+	progress(len(expired), len(expired))
+	return len(expired), nil
+}
+
+// expiredKeys returns the keys of the records in tree expired at now.
+func expiredKeys(tree *btree.BTree, now time.Time) []string {
+	var expired []string
+	tree.Ascend(func(i btree.Item) bool {
+		if item := i.(btreeItem); item.rec.Expired(now) {
+			expired = append(expired, item.key)
+		}
+		return true
+	})
+	return expired
+}
+
+// ── Columnar ─────────────────────────────────────────────────────────────────
+
+func (e *ColumnarEngine) Maintenance() map[string]types.MaintenanceFunc {
+	return map[string]types.MaintenanceFunc{
+		types.MaintenanceFlush:          e.flush,
+		types.MaintenanceRebuildIndexes: rebuildAll(e),
+		types.MaintenanceGC: func(ctx context.Context, progress func(done, total int)) error {
+			return e.gcs.count(gcMap(ctx, &e.mu, e.records, progress, e.feed.expired))
+		},
+	}
+}
+
+// flush seals the open block so its rows are stored compressed.
+func (e *ColumnarEngine) flush(ctx context.Context, progress func(done, total int)) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rows := e.store.Seal()
+	progress(rows, rows)
+	return nil
+}
+
+// ── Vector ───────────────────────────────────────────────────────────────────
+
+func (e *VectorEngine) Maintenance() map[string]types.MaintenanceFunc {
+	return map[string]types.MaintenanceFunc{
+		types.MaintenanceReindexVectors: reindexVectors(e),
+		types.MaintenanceRebuildIndexes: rebuildAll(e),
+		types.MaintenanceGC: func(ctx context.Context, progress func(done, total int)) error {
+			return e.gcs.count(gcMap(ctx, &e.mu, e.records, progress, func(key string, rec *types.Record) {
+				e.unindexLocked(key)
+				e.feed.expired(key, rec)
+			}))
+		},
+	}
+}
+
+// reindexVectors runs RebuildIndexes for the vector index alone.
+func reindexVectors(eng types.IndexRebuilder) types.MaintenanceFunc {
+	return func(ctx context.Context, progress func(done, total int)) error {
+		return eng.RebuildIndexes(ctx, progress, types.IndexVector)
+	}
+}
+
+// ── Hybrid ───────────────────────────────────────────────────────────────────
+
+// Maintenance tasks that touch the disk or columnar tiers pause writes and
+// wait for the async queue to drain first, so they see every acknowledged
+// write.
+func (h *HybridEngine) Maintenance() map[string]types.MaintenanceFunc {
+	afterDrain := func(task types.MaintenanceFunc) types.MaintenanceFunc {
+		return func(ctx context.Context, progress func(done, total int)) error {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+
+			if err := h.drainLocked(ctx); err != nil {
+				return err
+			}
+			return task(ctx, progress)
+		}
+	}
+	return map[string]types.MaintenanceFunc{
+		types.MaintenanceFlush:          afterDrain(h.columnStore.flush),
+		types.MaintenanceCheckpoint:     afterDrain(h.disk.checkpoint),
+		types.MaintenanceCompact:        afterDrain(h.disk.compact),
+		types.MaintenanceReindexVectors: reindexVectors(h),
+		types.MaintenanceRebuildIndexes: rebuildAll(h),
+		types.MaintenanceGC:             afterDrain(h.gc),
+	}
+}
+
+// drainLocked waits until the async writer has applied every queued record.
+func (h *HybridEngine) drainLocked(ctx context.Context) error {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+	for h.queued.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-h.workerDone:
+			return errWorkerExited
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// gc removes expired records from every tier: first those memory holds,
+// then those only disk has. The disk tier counts them for Stats.
+func (h *HybridEngine) gc(ctx context.Context, progress func(done, total int)) error {
+	var expired []string
+	_, err := gcMap(ctx, &h.memory.mu, h.memory.records, func(int, int) {}, func(key string, rec *types.Record) {
+		expired = append(expired, key)
+		h.cache.remove(key)
+		h.feed.expired(key, rec)
+	})
+	for i, key := range expired {
+		if err := h.deleteTiersLocked(ctx, key); err != nil {
+			return h.disk.gcs.count(i, err)
+		}
+		if (i+1)%scanChunk == 0 {
+			progress(i+1, len(expired))
+		}
+	}
+	if err != nil {
+		return h.disk.gcs.count(len(expired), err)
+	}
+	n, err := h.disk.gcTree(ctx, progress, func(key string, rec *types.Record) {
+		_ = h.vectorStore.Delete(ctx, key)
+		_ = h.columnStore.Delete(ctx, key)
+		h.feed.expired(key, rec)
+	})
+	return h.disk.gcs.count(len(expired)+n, err)
+}
+
+var (
+	_ types.Maintainer = (*MemoryEngine)(nil)
+	_ types.Maintainer = (*DiskEngine)(nil)
+	_ types.Maintainer = (*ColumnarEngine)(nil)
+	_ types.Maintainer = (*VectorEngine)(nil)
+	_ types.Maintainer = (*HybridEngine)(nil)
+)
@@ -4,53 +4,287 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/thirawat27/kvi/internal/tracing"
 	"github.com/thirawat27/kvi/pkg/config"
 	"github.com/thirawat27/kvi/pkg/types"
 )
 
 type MemoryEngine struct {
+	engineState
+
 	config  *config.Config
 	records map[string]*types.Record
 	mu      sync.RWMutex
+	feed    *feed
+	tracer  *tracing.Tracer
+	gcs     gcCounts
+	packer  *packer
+
+	collections collectionCache
 }
 
-func NewMemoryEngine(cfg *config.Config) *MemoryEngine {
-	return &MemoryEngine{
+func NewMemoryEngine(cfg *config.Config) (*MemoryEngine, error) {
+	packer, err := newPacker(cfg)
+	if err != nil {
+		return nil, err
+	}
+	e := &MemoryEngine{
 		config:  cfg,
 		records: make(map[string]*types.Record),
+		feed:    newFeed(cfg),
+		tracer:  tracing.New(cfg.TracerProvider),
+		packer:  packer,
 	}
+	e.indexing.add(e.feed.queue)
+	return e, nil
 }
 
 func (e *MemoryEngine) Put(ctx context.Context, key string, record *types.Record) error {
+	_, span := e.tracer.Start(ctx, "memory.Put")
+	defer span.End()
+
+	if err := e.writable(); err != nil {
+		return err
+	}
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	e.records[key] = record
+	e.putLocked(key, record)
+	return nil
+}
+
+func (e *MemoryEngine) putLocked(key string, record *types.Record) {
+	stamp(e.records[key], record)
+	e.records[key] = e.packer.pack(record)
+	e.feed.put(key, record)
+}
+
+// BatchPut writes every record under one write lock.
+func (e *MemoryEngine) BatchPut(ctx context.Context, records []*types.Record) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, rec := range records {
+		e.putLocked(rec.ID, rec)
+	}
 	return nil
 }
 
 func (e *MemoryEngine) Get(ctx context.Context, key string) (*types.Record, error) {
+	_, span := e.tracer.Start(ctx, "memory.Get")
+	defer span.End()
+
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	stored := e.records[key]
+	if record := live(stored); record != nil {
+		return unpack(record), nil
+	}
+	if stored != nil {
+		e.feed.reapLater(key, e.reap)
+	}
+	return nil, fmt.Errorf("%w for key: %s", types.ErrKeyNotFound, key)
+}
+
+// lookup returns key's live record, or nil.
+func (e *MemoryEngine) lookup(key string) *types.Record {
+	rec, _ := e.held(key, time.Now())
+	return rec
+}
+
+// held is lookup with expiry judged at now that also reports whether key
+// has a record at all, expired or not.
+func (e *MemoryEngine) held(key string, now time.Time) (*types.Record, bool) {
+	return e.heldProjected(key, now, nil)
+}
+
+// heldProjected is held returning only what p picks of the record.
+func (e *MemoryEngine) heldProjected(key string, now time.Time, p *types.Projection) (*types.Record, bool) {
 	e.mu.RLock()
 	defer e.mu.RUnlock()
+	rec, ok := e.records[key]
+	return unpackProjected(liveAt(rec, now), p), ok
+}
 
-	if record, exists := e.records[key]; exists {
-		return record, nil
+// fill stores record under key as it is, unless key already has one, and
+// reports whether it did. The hybrid engine fills its memory tier from disk.
+func (e *MemoryEngine) fill(key string, record *types.Record) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, ok := e.records[key]; ok {
+		return false
 	}
-	return nil, fmt.Errorf("record not found for key: %s", key)
+	e.records[key] = e.packer.pack(record)
+	return true
+}
+
+// kept returns the record memory keeps for key, packed or not, or nil.
+func (e *MemoryEngine) kept(key string) *types.Record {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.records[key]
 }
 
 func (e *MemoryEngine) Delete(ctx context.Context, key string) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.deleteLocked(key)
+	return nil
+}
+
+func (e *MemoryEngine) deleteLocked(key string) {
+	if rec, ok := e.records[key]; ok {
+		delete(e.records, key)
+		e.feed.deleted(key, rec)
+	}
+}
+
+// BatchGet reads every key under one read lock.
+func (e *MemoryEngine) BatchGet(ctx context.Context, keys []string) (map[string]*types.Record, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return batchGetMap(e.records, keys), nil
+}
+
+// BatchDelete deletes every key under one write lock.
+func (e *MemoryEngine) BatchDelete(ctx context.Context, keys []string) (map[string]bool, error) {
+	if err := e.writable(); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	deleted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if live(e.records[key]) != nil {
+			deleted[key] = true
+		}
+		e.deleteLocked(key)
+	}
+	return deleted, nil
+}
+
+func (e *MemoryEngine) CompareAndSwap(ctx context.Context, key string, version uint64, record *types.Record) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	cur := e.records[key]
+	if err := checkVersion(key, live(cur), version); err != nil {
+		return err
+	}
+	if record == nil {
+		e.deleteLocked(key)
+		return nil
+	}
+	stamp(cur, record)
+	e.records[key] = e.packer.pack(record)
+	e.feed.put(key, record)
+	return nil
+}
+
+func (e *MemoryEngine) Update(ctx context.Context, key string, fn func(*types.Record) error) (*types.Record, error) {
+	if err := e.writable(); err != nil {
+		return nil, err
+	}
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	delete(e.records, key)
+	cur := unpack(live(e.records[key]))
+	if cur == nil {
+		return nil, fmt.Errorf("%w for key: %s", types.ErrKeyNotFound, key)
+	}
+	next := cur.Clone()
+	if err := fn(next); err != nil {
+		return nil, err
+	}
+	stamp(cur, next)
+	e.records[key] = e.packer.pack(next)
+	e.feed.put(key, next)
+	return next, nil
+}
+
+func (e *MemoryEngine) Scan(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+	ctx, span := e.tracer.Start(ctx, "memory.Scan")
+	defer span.End()
+
+	if err := e.open(); err != nil {
+		return err
+	}
+	return scanMap(ctx, &e.mu, e.records, prefix, fn)
+}
+
+// ScanConsistent implements types.ConsistentScanner.
+func (e *MemoryEngine) ScanConsistent(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+	ctx, span := e.tracer.Start(ctx, "memory.ScanConsistent")
+	defer span.End()
+
+	if err := e.open(); err != nil {
+		return err
+	}
+	return snapshotMap(ctx, &e.mu, e.records, prefix, fn)
+}
+
+// Apply implements types.Replica.
+func (e *MemoryEngine) Apply(ctx context.Context, ev types.ChangeEvent) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	if err := checkApply(ev); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if ev.Op == types.OpDelete {
+		e.deleteLocked(ev.Key)
+		return nil
+	}
+	e.records[ev.Key] = e.packer.pack(ev.Record)
+	e.feed.put(ev.Key, ev.Record)
 	return nil
 }
 
+func (e *MemoryEngine) Watch(ctx context.Context, prefix string, fromSeq uint64) (<-chan types.ChangeEvent, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	return e.feed.watch(ctx, prefix, fromSeq)
+}
+
 func (e *MemoryEngine) Close() error {
+	if err := e.close(); err != nil {
+		return err
+	}
+	e.feed.close()
 	return nil
 }
 
 // Compile time check
-var _ types.Engine = (*MemoryEngine)(nil)
+var (
+	_ types.Engine            = (*MemoryEngine)(nil)
+	_ types.Watcher           = (*MemoryEngine)(nil)
+	_ types.Batcher           = (*MemoryEngine)(nil)
+	_ types.ConsistentScanner = (*MemoryEngine)(nil)
+	_ types.Replica           = (*MemoryEngine)(nil)
+)
@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// mergeInto turns rec into what BatchMerge stores for it over cur, the
+// live record under its key, if there is one: cur's Data with rec's
+// deep-merged into it, and the vector, blob, tags and TTL kept from cur
+// unless rec has its own.
+func mergeInto(rec, cur *types.Record) {
+	if cur == nil {
+		return
+	}
+	base := cur.Clone()
+	rec.Data = mergeData(base.Data, rec.Data)
+	if rec.Vector == nil {
+		rec.Vector = base.Vector
+	}
+	if rec.Blob == nil {
+		rec.Blob, rec.ContentType = base.Blob, base.ContentType
+	}
+	if rec.Tags == nil {
+		rec.Tags = base.Tags
+	}
+	if rec.TTL == nil {
+		rec.TTL = base.TTL
+	}
+}
+
+// mergeData merges src into dst and returns it. Where both hold an object
+// under the same key the two are merged the same way; anything else in src,
+// null and arrays included, replaces what dst has, so a scalar replaces an
+// object and an object a scalar. Nothing is ever removed.
+func mergeData(dst, src map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = make(map[string]interface{}, len(src))
+	}
+	for k, v := range src {
+		if sub, ok := v.(map[string]interface{}); ok {
+			if have, ok := dst[k].(map[string]interface{}); ok {
+				dst[k] = mergeData(have, sub)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+	return dst
+}
+
+// BatchMerge implements types.BatchMerger under one write lock.
+func (e *MemoryEngine) BatchMerge(ctx context.Context, records []*types.Record) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, rec := range records {
+		mergeInto(rec, unpack(live(e.records[rec.ID])))
+		e.putLocked(rec.ID, rec)
+	}
+	return nil
+}
+
+// BatchMerge implements types.BatchMerger under one write lock, logging
+// each merged record to the WAL and stopping at the first failure.
+func (e *DiskEngine) BatchMerge(ctx context.Context, records []*types.Record) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
+	return e.write(func() error {
+		for _, rec := range records {
+			mergeInto(rec, live(e.getLocked(rec.ID)))
+			if err := e.putLocked(rec.ID, rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// BatchMerge implements types.BatchMerger under one write lock.
+func (e *ColumnarEngine) BatchMerge(ctx context.Context, records []*types.Record) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, rec := range records {
+		mergeInto(rec, live(e.records[rec.ID]))
+		if err := e.putLocked(rec.ID, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchMerge implements types.BatchMerger under one write lock. A record
+// without a vector keeps the one stored, so it may leave Vector out only
+// for a key that has one.
+func (e *VectorEngine) BatchMerge(ctx context.Context, records []*types.Record) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, rec := range records {
+		mergeInto(rec, live(e.records[rec.ID]))
+		if err := e.putLocked(rec.ID, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchMerge implements types.BatchMerger: each record is merged into the
+// one memory holds once the key is loaded into it, then written as Put
+// does.
+func (h *HybridEngine) BatchMerge(ctx context.Context, records []*types.Record) error {
+	if err := h.writable(); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := h.checkVector(rec); err != nil {
+			return err
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	defer h.evictLocked()
+
+	for _, rec := range records {
+		h.loadLocked(rec.ID)
+		mergeInto(rec, h.memory.lookup(rec.ID))
+		if err := h.writeLocked(ctx, rec.ID, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	_ types.BatchMerger = (*MemoryEngine)(nil)
+	_ types.BatchMerger = (*DiskEngine)(nil)
+	_ types.BatchMerger = (*ColumnarEngine)(nil)
+	_ types.BatchMerger = (*VectorEngine)(nil)
+	_ types.BatchMerger = (*HybridEngine)(nil)
+)
@@ -0,0 +1,216 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// moved returns what Copy and Rename store under dst for cur, src's live
+// record, over prev, whatever dst holds, expired or not: a copy of cur
+// stamped at dst's next version.
+func moved(src, dst string, cur, prev *types.Record, opts types.MoveOptions) (*types.Record, error) {
+	switch {
+	case src == dst:
+		return nil, fmt.Errorf("cannot move %s onto itself", src)
+	case cur == nil:
+		return nil, fmt.Errorf("%w for key: %s", types.ErrKeyNotFound, src)
+	case live(prev) != nil && !opts.Overwrite:
+		return nil, fmt.Errorf("%w: %s", types.ErrKeyExists, dst)
+	}
+	rec := cur.Clone()
+	rec.ID, rec.Version = dst, 0
+	stamp(prev, rec)
+	rec.CreatedAt = rec.UpdatedAt
+	if opts.KeepCreatedAt && !cur.CreatedAt.IsZero() {
+		rec.CreatedAt = cur.CreatedAt
+	}
+	return rec, nil
+}
+
+// Copy implements types.Mover under one write lock.
+func (e *MemoryEngine) Copy(ctx context.Context, src, dst string, opts types.MoveOptions) (*types.Record, error) {
+	return e.move(src, dst, opts, false)
+}
+
+// Rename implements types.Mover under one write lock.
+func (e *MemoryEngine) Rename(ctx context.Context, src, dst string, opts types.MoveOptions) (*types.Record, error) {
+	return e.move(src, dst, opts, true)
+}
+
+func (e *MemoryEngine) move(src, dst string, opts types.MoveOptions, rename bool) (*types.Record, error) {
+	if err := e.writable(); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rec, err := moved(src, dst, unpack(live(e.records[src])), e.records[dst], opts)
+	if err != nil {
+		return nil, err
+	}
+	e.records[dst] = e.packer.pack(rec)
+	e.feed.put(dst, rec)
+	if rename {
+		e.deleteLocked(src)
+	}
+	return rec, nil
+}
+
+// Copy implements types.Mover, logging the copy as a put of dst.
+func (e *DiskEngine) Copy(ctx context.Context, src, dst string, opts types.MoveOptions) (*types.Record, error) {
+	return e.move(src, dst, opts, false)
+}
+
+// Rename implements types.Mover, logging the put of dst and the delete of
+// src as one OpRename entry.
+func (e *DiskEngine) Rename(ctx context.Context, src, dst string, opts types.MoveOptions) (*types.Record, error) {
+	return e.move(src, dst, opts, true)
+}
+
+func (e *DiskEngine) move(src, dst string, opts types.MoveOptions, rename bool) (*types.Record, error) {
+	if err := e.writable(); err != nil {
+		return nil, err
+	}
+	var rec *types.Record
+	err := e.write(func() error {
+		var err error
+		if rec, err = moved(src, dst, live(e.getLocked(src)), e.getLocked(dst), opts); err != nil {
+			return err
+		}
+		if !rename {
+			return e.storeLocked(dst, rec)
+		}
+		if e.config.EnableWAL {
+			if err := e.wal.WriteRename(src, dst, rec, false); err != nil {
+				return err
+			}
+		}
+		e.tree.ReplaceOrInsert(btreeItem{key: dst, rec: rec})
+		e.feed.put(dst, rec)
+		if item := e.tree.Delete(btreeItem{key: src}); item != nil {
+			e.feed.deleted(src, item.(btreeItem).rec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Copy implements types.Mover under one write lock.
+func (e *ColumnarEngine) Copy(ctx context.Context, src, dst string, opts types.MoveOptions) (*types.Record, error) {
+	return e.move(src, dst, opts, false)
+}
+
+// Rename implements types.Mover under one write lock. src's row stays in
+// the append-only column store, as a delete leaves it.
+func (e *ColumnarEngine) Rename(ctx context.Context, src, dst string, opts types.MoveOptions) (*types.Record, error) {
+	return e.move(src, dst, opts, true)
+}
+
+func (e *ColumnarEngine) move(src, dst string, opts types.MoveOptions, rename bool) (*types.Record, error) {
+	if err := e.writable(); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rec, err := moved(src, dst, live(e.records[src]), e.records[dst], opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.storeLocked(dst, rec); err != nil {
+		return nil, err
+	}
+	if rename {
+		e.deleteLocked(src)
+	}
+	return rec, nil
+}
+
+// Copy implements types.Mover under one write lock, indexing the vector
+// again under dst.
+func (e *VectorEngine) Copy(ctx context.Context, src, dst string, opts types.MoveOptions) (*types.Record, error) {
+	return e.move(src, dst, opts, false)
+}
+
+// Rename implements types.Mover under one write lock, moving the vector
+// from src to dst in the index.
+func (e *VectorEngine) Rename(ctx context.Context, src, dst string, opts types.MoveOptions) (*types.Record, error) {
+	return e.move(src, dst, opts, true)
+}
+
+func (e *VectorEngine) move(src, dst string, opts types.MoveOptions, rename bool) (*types.Record, error) {
+	if err := e.writable(); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	rec, err := moved(src, dst, live(e.records[src]), e.records[dst], opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.indexLocked(dst, rec.Vector); err != nil {
+		return nil, err
+	}
+	e.storeLocked(dst, rec)
+	if rename {
+		e.deleteLocked(src)
+	}
+	return rec, nil
+}
+
+// Copy implements types.Mover: the copy is written to dst as Put does,
+// once both keys are loaded into memory.
+func (h *HybridEngine) Copy(ctx context.Context, src, dst string, opts types.MoveOptions) (*types.Record, error) {
+	return h.move(ctx, src, dst, opts, false)
+}
+
+// Rename implements types.Mover: Copy, logged as one OpRename, that then
+// removes src from every tier once its queued writes have reached them.
+func (h *HybridEngine) Rename(ctx context.Context, src, dst string, opts types.MoveOptions) (*types.Record, error) {
+	return h.move(ctx, src, dst, opts, true)
+}
+
+func (h *HybridEngine) move(ctx context.Context, src, dst string, opts types.MoveOptions, rename bool) (*types.Record, error) {
+	if err := h.writable(); err != nil {
+		return nil, err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	defer h.evictLocked()
+
+	h.loadLocked(src)
+	h.loadLocked(dst)
+	rec, err := moved(src, dst, h.memory.lookup(src), h.memory.kept(dst), opts)
+	if err != nil {
+		return nil, err
+	}
+	if !rename {
+		if err := h.writeLocked(ctx, dst, rec); err != nil {
+			return nil, err
+		}
+		return rec, nil
+	}
+	if err := h.awaitKeyLocked(ctx, src); err != nil {
+		return nil, err
+	}
+	if err := h.writeFromLocked(ctx, src, dst, rec); err != nil {
+		return nil, err
+	}
+	h.dropTiersLocked(ctx, src)
+	h.deleteMemoryLocked(src)
+	return rec, nil
+}
+
+var (
+	_ types.Mover = (*MemoryEngine)(nil)
+	_ types.Mover = (*DiskEngine)(nil)
+	_ types.Mover = (*ColumnarEngine)(nil)
+	_ types.Mover = (*VectorEngine)(nil)
+	_ types.Mover = (*HybridEngine)(nil)
+)
@@ -50,11 +50,17 @@ func (m *MVCCManager) Get(key string) (*types.Record, uint64) {
 	}
 
 	last := vrs[len(vrs)-1]
+	if live(last.Record) == nil {
+		return nil, 0
+	}
 	return last.Record, last.TxID
 }
 
-// GetAsOf supports time-travel queries
-func (m *MVCCManager) GetAsOf(key string, txID uint64) *types.Record {
+// GetAsOf supports time-travel queries: it returns key's record as of
+// txID. Expiry is judged at now; the zero time judges it when that version
+// was written instead, so the read sees the record as it was live then
+// even if its TTL has passed since.
+func (m *MVCCManager) GetAsOf(key string, txID uint64, now time.Time) *types.Record {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -77,10 +83,13 @@ func (m *MVCCManager) GetAsOf(key string, txID uint64) *types.Record {
 		}
 	}
 
-	if result != nil {
-		return result.Record
+	if result == nil {
+		return nil
+	}
+	if now.IsZero() {
+		now = time.Unix(0, result.Timestamp)
 	}
-	return nil
+	return liveAt(result.Record, now)
 }
 
 func (m *MVCCManager) GC(olderThanTxID uint64) {
@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// ErrBeforeBase is a point-in-time cut earlier than the backup a recovery
+// starts from.
+var ErrBeforeBase = errors.New("cut point is before the backup")
+
+// PointInTime is what RecoverUntil did: the backup it restored, and what it
+// replayed of the WAL on top, up to the cut point.
+type PointInTime struct {
+	Backup  backup.Result
+	BaseLSN uint64 // the backup's, after which the WAL was replayed
+	wal.UntilResult
+}
+
+// RecoverUntil restores eng to a point in time: it replaces every record
+// with those of the backup in base, then replays the entries of the WAL in
+// log logged after the backup's LSN and before cut, as Apply would store
+// them. Both are read in full first, the backup as Restore verifies it,
+// and eng is left untouched if either fails: a damaged backup
+// (backup.ErrInvalid), damage in the log before the cut, a log no longer
+// reaching back to the backup (wal.ErrHistoryGap) or a cut before the
+// backup was taken (ErrBeforeBase).
+//
+// A backup is not a snapshot of one instant: it holds every change up to
+// its LSN, and may hold later ones written while it was being taken. A cut
+// inside that window can leave some of those later changes in place.
+func RecoverUntil(ctx context.Context, eng types.Engine, base, log io.ReadSeeker, cut wal.Cut) (PointInTime, error) {
+	var res PointInTime
+	r, ok := eng.(types.Replica)
+	if !ok {
+		return res, fmt.Errorf("%w: engine cannot apply logged changes", errors.ErrUnsupported)
+	}
+	hdr, err := backup.ReadHeader(base)
+	if err != nil {
+		return res, fmt.Errorf("%w: %w", backup.ErrInvalid, err)
+	}
+	res.BaseLSN = hdr.LSN
+	switch {
+	case cut.LSN > 0 && cut.LSN < hdr.LSN:
+		return res, fmt.Errorf("%w: LSN %d, and the backup is at %d", ErrBeforeBase, cut.LSN, hdr.LSN)
+	case !cut.Time.IsZero() && cut.Time.Before(hdr.CreatedAt):
+		return res, fmt.Errorf("%w: %s, and the backup was taken at %s", ErrBeforeBase,
+			cut.Time.Format(time.RFC3339Nano), hdr.CreatedAt.Format(time.RFC3339Nano))
+	}
+	if _, err := wal.ReplayUntil(log, res.BaseLSN, cut, nil); err != nil {
+		return res, err
+	}
+
+	for _, s := range []io.Seeker{base, log} {
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return res, err
+		}
+	}
+	if res.Backup, err = backup.Restore(ctx, eng, base, backup.Replace); err != nil {
+		return res, err
+	}
+	res.UntilResult, err = wal.ReplayUntil(log, res.BaseLSN, cut, func(entry *wal.LogEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return applyEntry(ctx, r, entry)
+	})
+	return res, err
+}
+
+// applyEntry stores the change entry logged, as the engine that logged it
+// stored it.
+func applyEntry(ctx context.Context, r types.Replica, entry *wal.LogEntry) error {
+	var err error
+	switch entry.Op {
+	case types.OpPut:
+		err = r.Apply(ctx, types.ChangeEvent{Seq: entry.LSN, Op: types.OpPut, Key: entry.Key, Record: entry.Record})
+	case types.OpDelete, types.OpExpire:
+		err = r.Apply(ctx, types.ChangeEvent{Seq: entry.LSN, Op: types.OpDelete, Key: entry.Key})
+	case types.OpRename:
+		err = r.Apply(ctx, types.ChangeEvent{Seq: entry.LSN - 1, Op: types.OpPut, Key: entry.Key, Record: entry.Record})
+		if err == nil {
+			err = r.Apply(ctx, types.ChangeEvent{Seq: entry.LSN, Op: types.OpDelete, Key: entry.From})
+		}
+	default:
+		err = fmt.Errorf("cannot replay %s", entry.Op)
+	}
+	if err != nil {
+		return fmt.Errorf("replay LSN %d: %w", entry.LSN, err)
+	}
+	return nil
+}
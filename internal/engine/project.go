@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"context"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// ScanProjected implements types.ProjectingScanner. Records are projected
+// as the walk reads them, so a packed record's Data is only decoded as far
+// as p needs, and not at all if p leaves it out.
+func (e *MemoryEngine) ScanProjected(ctx context.Context, prefix string, p *types.Projection, fn func(*types.Record) bool) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	return walkMap(ctx, &e.mu, e.records, prefix, p, func(item btreeItem) bool { return fn(item.rec) })
+}
+
+// ScanProjected implements types.ProjectingScanner.
+func (e *DiskEngine) ScanProjected(ctx context.Context, prefix string, p *types.Projection, fn func(*types.Record) bool) error {
+	if err := e.readable(); err != nil {
+		return err
+	}
+	return walkTree(ctx, &e.mu, e.tree, prefix, p, func(item btreeItem) bool { return fn(item.rec) })
+}
+
+// ScanProjected implements types.ProjectingScanner.
+func (e *ColumnarEngine) ScanProjected(ctx context.Context, prefix string, p *types.Projection, fn func(*types.Record) bool) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	return walkMap(ctx, &e.mu, e.records, prefix, p, func(item btreeItem) bool { return fn(item.rec) })
+}
+
+// ScanProjected implements types.ProjectingScanner.
+func (e *VectorEngine) ScanProjected(ctx context.Context, prefix string, p *types.Projection, fn func(*types.Record) bool) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	return walkMap(ctx, &e.mu, e.records, prefix, p, func(item btreeItem) bool { return fn(item.rec) })
+}
+
+// ScanProjected implements types.ProjectingScanner. Memory's records are
+// projected as its tier is read, like MemoryEngine's.
+func (h *HybridEngine) ScanProjected(ctx context.Context, prefix string, p *types.Projection, fn func(*types.Record) bool) error {
+	if err := h.open(); err != nil {
+		return err
+	}
+	return h.walkProjected(ctx, prefix, p, func(item btreeItem) bool { return fn(item.rec) })
+}
+
+var (
+	_ types.ProjectingScanner = (*MemoryEngine)(nil)
+	_ types.ProjectingScanner = (*DiskEngine)(nil)
+	_ types.ProjectingScanner = (*ColumnarEngine)(nil)
+	_ types.ProjectingScanner = (*VectorEngine)(nil)
+	_ types.ProjectingScanner = (*HybridEngine)(nil)
+)
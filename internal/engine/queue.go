@@ -0,0 +1,234 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// queuedWrite is a record on its way to the disk and columnar tiers.
+type queuedWrite struct {
+	key string
+	rec *types.Record
+	seq uint64 // its place in the write watermark
+}
+
+// drainReport is what the async writer applied while Close waited for it,
+// and what it left when the drain timeout ran out.
+type drainReport struct {
+	flushed, left int
+}
+
+var errWorkerExited = errors.New("async writer has exited")
+
+// writerHeartbeat is how often the async writer finishes a cycle while it
+// has nothing to write, so an idle writer is not taken for a stalled one.
+const writerHeartbeat = time.Second
+
+func (h *HybridEngine) asyncWorker() {
+	defer h.wg.Done()
+	defer close(h.workerDone)
+
+	heartbeat := time.NewTicker(writerHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-heartbeat.C:
+			h.writer.done(0, nil)
+		case <-h.ctx.Done():
+			h.drained = h.drainQueue(time.Duration(h.config.AsyncDrainTimeoutMs) * time.Millisecond)
+			return
+		case w := <-h.writeChan:
+			h.writeTiers(w)
+		case <-h.spillReady:
+			// Everything in writeChan was queued before the first spill
+			for len(h.writeChan) > 0 {
+				h.writeTiers(<-h.writeChan)
+			}
+			for _, w := range h.takeSpilled() {
+				h.writeTiers(w)
+			}
+		}
+	}
+}
+
+// drainQueue applies what is left in the queue, in order, until it is
+// empty or timeout (0 is none) runs out.
+func (h *HybridEngine) drainQueue(timeout time.Duration) drainReport {
+	var report drainReport
+	expired := func() bool { return timeout > 0 && time.Since(h.closing) > timeout }
+	for {
+		batch := make([]queuedWrite, 0, len(h.writeChan))
+		for len(h.writeChan) > 0 {
+			batch = append(batch, <-h.writeChan)
+		}
+		batch = append(batch, h.takeSpilled()...)
+		if len(batch) == 0 {
+			return report
+		}
+		for _, w := range batch {
+			if expired() {
+				report.left = int(h.queued.Load())
+				return report
+			}
+			h.writeTiers(w)
+			report.flushed++
+		}
+	}
+}
+
+func (h *HybridEngine) takeSpilled() []queuedWrite {
+	h.spillMu.Lock()
+	defer h.spillMu.Unlock()
+	batch := h.spilled
+	h.spilled = nil
+	return batch
+}
+
+// writeTiers applies a queued write to the disk and columnar tiers. The
+// caller has long since returned, so failures can only be logged. The key
+// can then leave memory; a writer holding h.mu evicts on its way out.
+func (h *HybridEngine) writeTiers(w queuedWrite) {
+	defer func() {
+		h.dequeued(w.key)
+		if h.mu.TryRLock() {
+			h.evictLocked()
+			h.mu.RUnlock()
+		}
+	}()
+	h.disk.putTree(w.key, w.rec)
+	if err := h.writer.done(1, h.columnStore.Put(context.Background(), w.key, w.rec)); err != nil {
+		h.config.Log().Error("async write failed", "engine", "hybrid", "tier", "columnar", "key", w.key, "err", err)
+	}
+	h.applied.Store(w.seq)
+}
+
+// admitLocked makes sure the queue can take one more write before any
+// tier is written, so a write the queue cannot take changes nothing. When
+// it is full, AsyncQueueFull decides: wait until ctx ends, fail, or let
+// the write spill past the limit. Only writers holding h.mu add to the
+// queue, so the room found here is still there for queueLocked.
+func (h *HybridEngine) admitLocked(ctx context.Context) error {
+	if len(h.writeChan) < cap(h.writeChan) || h.config.AsyncQueueFull == config.QueueSpill {
+		return nil
+	}
+	if h.config.AsyncQueueFull != config.QueueBlock {
+		return types.ErrQueueFull
+	}
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for len(h.writeChan) == cap(h.writeChan) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-h.workerDone:
+			return errWorkerExited
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// queueLocked hands w to the async writer. Once a write has spilled,
+// later ones spill too until the writer has caught up, so every write
+// reaches disk in the order it was made.
+func (h *HybridEngine) queueLocked(w queuedWrite) {
+	w.seq = h.written.Add(1)
+	h.enqueued(w.key)
+	h.spillMu.Lock()
+	defer h.spillMu.Unlock()
+	if len(h.spilled) == 0 {
+		select {
+		case h.writeChan <- w:
+			return
+		default:
+		}
+		select {
+		case h.spillReady <- struct{}{}:
+		default:
+		}
+	}
+	h.spilled = append(h.spilled, w)
+}
+
+func (h *HybridEngine) enqueued(key string) {
+	h.queued.Add(1)
+	h.pendingMu.Lock()
+	h.pending[key]++
+	h.pendingMu.Unlock()
+}
+
+func (h *HybridEngine) dequeued(key string) {
+	h.queued.Add(-1)
+	h.pendingMu.Lock()
+	if h.pending[key]--; h.pending[key] <= 0 {
+		delete(h.pending, key)
+	}
+	h.pendingMu.Unlock()
+}
+
+// awaitKeyLocked waits until no write of key is queued, so a delete is not
+// overtaken by an earlier put reaching disk after it.
+func (h *HybridEngine) awaitKeyLocked(ctx context.Context, key string) error {
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for {
+		h.pendingMu.Lock()
+		n := h.pending[key]
+		h.pendingMu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-h.workerDone:
+			return errWorkerExited
+		case <-ticker.C:
+		}
+	}
+}
+
+// Watermark implements types.Watermarker. Writes are applied in the order
+// they are queued, so every write up to Applied is in every tier.
+func (h *HybridEngine) Watermark() types.Watermark {
+	mark := types.Watermark{Written: h.written.Load(), Applied: h.applied.Load()}
+	if wal := h.disk.walStats(); wal != nil {
+		mark.SyncedLSN = wal.SyncedLSN
+	}
+	return mark
+}
+
+// Await implements types.Watermarker. Memory and the vector tier have a
+// write once it is acknowledged, so reading your writes waits only for the
+// disk and columnar tiers to catch up.
+func (h *HybridEngine) Await(ctx context.Context, level types.Consistency, after uint64) error {
+	if err := h.open(); err != nil {
+		return err
+	}
+	if level == types.ConsistencyEventual {
+		return nil
+	}
+	// A watermark from before the engine last opened counts as now
+	if written := h.written.Load(); after == 0 || after > written {
+		after = written
+	}
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	for h.applied.Load() < after {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-h.workerDone:
+			return errWorkerExited
+		case <-ticker.C:
+		}
+	}
+	if level == types.ConsistencyStrong {
+		return h.Sync(ctx)
+	}
+	return nil
+}
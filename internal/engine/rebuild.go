@@ -0,0 +1,311 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/google/btree"
+	"github.com/thirawat27/kvi/internal/vector"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// rebuild is the copy of an index RebuildIndexes is building beside it.
+// Every change to the index goes to the copy too and marks its key
+// touched, so records read for the copy before the change cannot undo it.
+type rebuild[T any] struct {
+	index   T
+	touched map[string]struct{}
+}
+
+// touch marks key changed and returns the copy to apply the change to.
+func (r *rebuild[T]) touch(key string) T {
+	r.touched[key] = struct{}{}
+	return r.index
+}
+
+// rebuildable is an index RebuildIndexes can rebuild.
+type rebuildable interface {
+	begin() error                                // start the copy
+	fill(records map[string]*types.Record) error // add records read since begin
+	swap()                                       // put the copy in the index's place
+	abandon()                                    // drop the copy
+}
+
+// indexRebuild implements rebuildable for an index guarded by mu, which
+// keeps the copy being built in *next.
+type indexRebuild[T any] struct {
+	mu      sync.Locker
+	next    **rebuild[T]
+	fresh   func() T                                           // an empty copy; called holding mu
+	add     func(index T, key string, rec *types.Record) error // index a live record
+	install func(index T)                                      // replace the index with the copy
+}
+
+func (x *indexRebuild[T]) begin() error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if *x.next != nil {
+		return fmt.Errorf("index is already being rebuilt")
+	}
+	*x.next = &rebuild[T]{index: x.fresh(), touched: make(map[string]struct{})}
+	return nil
+}
+
+func (x *indexRebuild[T]) fill(records map[string]*types.Record) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	next := *x.next
+	for key, rec := range records {
+		if _, ok := next.touched[key]; !ok {
+			if err := x.add(next.index, key, unpack(rec)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (x *indexRebuild[T]) swap() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.install((*x.next).index)
+	*x.next = nil
+}
+
+func (x *indexRebuild[T]) abandon() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	*x.next = nil
+}
+
+// indexSource is where RebuildIndexes reads the records of some of an
+// engine's indexes from, by kind.
+type indexSource struct {
+	keys    func() []string // every key that may hold a record
+	get     func(ctx context.Context, keys []string) (map[string]*types.Record, error)
+	indexes map[string]rebuildable
+}
+
+// rebuildIndexes rebuilds the indexes of kinds, or every index if kinds is
+// empty. It starts each copy before listing the keys, so a record written
+// meanwhile is either listed or reaches the copy as a change; reads the
+// listed records a chunk at a time, without holding the engine's lock; and
+// swaps the copies in once all are built.
+func rebuildIndexes(ctx context.Context, progress func(done, total int), kinds []string, sources ...indexSource) error {
+	for i := range sources {
+		sources[i].indexes = pickIndexes(sources[i].indexes, kinds)
+	}
+	for _, kind := range kinds {
+		if !slices.ContainsFunc(sources, func(s indexSource) bool { return s.indexes[kind] != nil }) {
+			if !slices.Contains(types.IndexKinds, kind) {
+				return fmt.Errorf("unknown index kind %q", kind)
+			}
+			return fmt.Errorf("this engine has no %s index", kind)
+		}
+	}
+
+	var begun []rebuildable
+	defer func() {
+		for _, x := range begun {
+			x.abandon()
+		}
+	}()
+	for _, s := range sources {
+		for _, x := range s.indexes {
+			if err := x.begin(); err != nil {
+				return err
+			}
+			begun = append(begun, x)
+		}
+	}
+
+	keys := make([][]string, len(sources))
+	total, done := 0, 0
+	for i, s := range sources {
+		if len(s.indexes) > 0 {
+			keys[i] = s.keys()
+			total += len(keys[i])
+		}
+	}
+	progress(0, total)
+	for i, s := range sources {
+		for start := 0; start < len(keys[i]); start += scanChunk {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			chunk := keys[i][start:min(start+scanChunk, len(keys[i]))]
+			found, err := s.get(ctx, chunk)
+			if err != nil {
+				return err
+			}
+			for _, x := range s.indexes {
+				if err := x.fill(found); err != nil {
+					return err
+				}
+			}
+			done += len(chunk)
+			progress(done, total)
+		}
+	}
+
+	for _, x := range begun {
+		x.swap()
+	}
+	begun = nil
+	return nil
+}
+
+// pickIndexes returns those of have whose kind is in kinds, or all of them
+// if kinds is empty.
+func pickIndexes(have map[string]rebuildable, kinds []string) map[string]rebuildable {
+	if len(kinds) == 0 {
+		return have
+	}
+	picked := make(map[string]rebuildable)
+	for _, kind := range kinds {
+		if x := have[kind]; x != nil {
+			picked[kind] = x
+		}
+	}
+	return picked
+}
+
+// rebuilders returns the indexes the feed keeps, by kind.
+func (f *feed) rebuilders() map[string]rebuildable {
+	if f == nil {
+		return nil
+	}
+	return map[string]rebuildable{types.IndexTags: f.tags.rebuilder(), types.IndexText: f.text.rebuilder()}
+}
+
+// treeKeys returns the keys in tree, expired or not.
+func treeKeys(mu *sync.RWMutex, tree *btree.BTree) []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	keys := make([]string, 0, tree.Len())
+	tree.Ascend(func(i btree.Item) bool {
+		keys = append(keys, i.(btreeItem).key)
+		return true
+	})
+	return keys
+}
+
+// RebuildIndexes implements types.IndexRebuilder.
+func (e *MemoryEngine) RebuildIndexes(ctx context.Context, progress func(done, total int), kinds ...string) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	return rebuildIndexes(ctx, progress, kinds, indexSource{
+		keys:    func() []string { return prefixKeys(&e.mu, e.records, "") },
+		get:     e.BatchGet,
+		indexes: e.feed.rebuilders(),
+	})
+}
+
+// RebuildIndexes implements types.IndexRebuilder. The B-tree holds the
+// records themselves rather than an index of them; it is rebuilt from the
+// WAL when the engine opens.
+func (e *DiskEngine) RebuildIndexes(ctx context.Context, progress func(done, total int), kinds ...string) error {
+	if err := e.readable(); err != nil {
+		return err
+	}
+	return rebuildIndexes(ctx, progress, kinds, indexSource{
+		keys:    func() []string { return treeKeys(&e.mu, e.tree) },
+		get:     e.BatchGet,
+		indexes: e.feed.rebuilders(),
+	})
+}
+
+// RebuildIndexes implements types.IndexRebuilder.
+func (e *ColumnarEngine) RebuildIndexes(ctx context.Context, progress func(done, total int), kinds ...string) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	return rebuildIndexes(ctx, progress, kinds, indexSource{
+		keys:    func() []string { return prefixKeys(&e.mu, e.records, "") },
+		get:     e.BatchGet,
+		indexes: e.feed.rebuilders(),
+	})
+}
+
+// RebuildIndexes implements types.IndexRebuilder. The HNSW copy counts
+// against vector_index_max_memory_mb on its own, so while it is built the
+// index may take up to twice that.
+func (e *VectorEngine) RebuildIndexes(ctx context.Context, progress func(done, total int), kinds ...string) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	indexes := e.feed.rebuilders()
+	indexes[types.IndexVector] = e.rebuilder()
+	return rebuildIndexes(ctx, progress, kinds, indexSource{
+		keys:    func() []string { return prefixKeys(&e.mu, e.records, "") },
+		get:     e.BatchGet,
+		indexes: indexes,
+	})
+}
+
+// rebuilder rebuilds the HNSW graph for RebuildIndexes.
+func (e *VectorEngine) rebuilder() rebuildable {
+	return &indexRebuild[*vector.HNSWIndex]{
+		mu:    &e.mu,
+		next:  &e.next,
+		fresh: func() *vector.HNSWIndex { return newVectorIndex(e.config) },
+		add: func(index *vector.HNSWIndex, key string, rec *types.Record) error {
+			if len(rec.Vector) == 0 {
+				return nil
+			}
+			return index.Add(key, rec.Vector)
+		},
+		install: func(index *vector.HNSWIndex) { e.index = index },
+	}
+}
+
+// RebuildIndexes implements types.IndexRebuilder. The tag and text
+// indexes are rebuilt from memory, which has every write still queued,
+// and disk; the HNSW graph from the vector tier.
+func (h *HybridEngine) RebuildIndexes(ctx context.Context, progress func(done, total int), kinds ...string) error {
+	if err := h.open(); err != nil {
+		return err
+	}
+	records := indexSource{
+		keys: func() []string {
+			h.mu.RLock()
+			defer h.mu.RUnlock()
+			keys := treeKeys(&h.disk.mu, h.disk.tree)
+			onDisk := len(keys)
+			for _, key := range prefixKeys(&h.memory.mu, h.memory.records, "") {
+				if _, found := slices.BinarySearch(keys[:onDisk], key); !found {
+					keys = append(keys, key)
+				}
+			}
+			return keys
+		},
+		get: func(ctx context.Context, keys []string) (map[string]*types.Record, error) {
+			h.mu.RLock()
+			defer h.mu.RUnlock()
+			found, _ := h.batchLookup(ctx, keys)
+			return found, nil
+		},
+		indexes: h.feed.rebuilders(),
+	}
+	vectors := indexSource{
+		keys:    func() []string { return prefixKeys(&h.vectorStore.mu, h.vectorStore.records, "") },
+		get:     h.vectorStore.BatchGet,
+		indexes: map[string]rebuildable{types.IndexVector: h.vectorStore.rebuilder()},
+	}
+	return rebuildIndexes(ctx, progress, kinds, records, vectors)
+}
+
+var (
+	_ types.IndexRebuilder = (*MemoryEngine)(nil)
+	_ types.IndexRebuilder = (*DiskEngine)(nil)
+	_ types.IndexRebuilder = (*ColumnarEngine)(nil)
+	_ types.IndexRebuilder = (*VectorEngine)(nil)
+	_ types.IndexRebuilder = (*HybridEngine)(nil)
+)
@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// recoveryLogEvery is how often a replay in progress is logged.
+const recoveryLogEvery = 5 * time.Second
+
+// recovery tracks a disk engine's replay of its WAL, for RecoveryStatus.
+type recovery struct {
+	started  time.Time
+	entries  atomic.Int64
+	done     atomic.Int64 // bytes replayed
+	total    atomic.Int64 // bytes to replay
+	finished chan struct{}
+	err      error // set before finished is closed
+}
+
+func newRecovery() *recovery {
+	return &recovery{started: time.Now(), finished: make(chan struct{})}
+}
+
+// tracker returns the Progress callback for the replay, which also logs
+// how far it has got every recoveryLogEvery.
+func (r *recovery) tracker(log *slog.Logger) func(done, total int64) {
+	logged := r.started
+	return func(done, total int64) {
+		r.entries.Add(1)
+		r.done.Store(done)
+		r.total.Store(total)
+		if now := time.Now(); now.Sub(logged) >= recoveryLogEvery {
+			logged = now
+			s := r.status()
+			log.Info("recovering from WAL", "engine", "disk", "entries", s.Entries, "bytes", s.BytesDone,
+				"total_bytes", s.BytesTotal, "percent", s.Percent, "remaining_ms", s.RemainingMs)
+		}
+	}
+}
+
+// end marks the replay over, failed if err is set.
+func (r *recovery) end(err error) {
+	r.err = err
+	close(r.finished)
+}
+
+func (r *recovery) status() types.RecoveryStatus {
+	s := types.RecoveryStatus{Entries: r.entries.Load(), BytesDone: r.done.Load(), BytesTotal: r.total.Load()}
+	select {
+	case <-r.finished:
+		if r.err != nil {
+			s.Error = r.err.Error()
+		} else {
+			s.Percent = 100
+		}
+		return s
+	default:
+		s.Recovering = true
+	}
+	if s.BytesTotal > 0 {
+		s.Percent = float64(s.BytesDone) * 100 / float64(s.BytesTotal)
+	}
+	if s.BytesDone > 0 {
+		elapsed := time.Since(r.started)
+		s.RemainingMs = (elapsed * time.Duration(s.BytesTotal-s.BytesDone) / time.Duration(s.BytesDone)).Milliseconds()
+	}
+	return s
+}
+
+// RecoveryStatus implements types.Recoverer.
+func (e *DiskEngine) RecoveryStatus() types.RecoveryStatus {
+	return e.recovery.status()
+}
+
+// recoverInBackground is recover for an engine already open: until it is
+// done, requests fail with ErrRecovering or reads see the records replayed
+// so far. If it fails the engine stays recovering, reads included, and
+// RecoveryStatus and readiness report why.
+func (e *DiskEngine) recoverInBackground() {
+	err := e.recover()
+	switch {
+	case err == nil:
+		e.recovering.Store(false)
+	case !errors.Is(err, types.ErrClosed):
+		e.partial.Store(false)
+		e.config.Log().Error("recovery failed", "engine", "disk", "error", e.recoverError(err))
+	}
+}
+
+// checkRecovery fails readiness until the engine has recovered.
+func (e *DiskEngine) checkRecovery(ctx context.Context) error {
+	s := e.RecoveryStatus()
+	switch {
+	case s.Error != "":
+		return fmt.Errorf("recovery failed: %s", s.Error)
+	case s.Recovering:
+		return fmt.Errorf("recovering %.0f%%, about %s left", s.Percent, time.Duration(s.RemainingMs)*time.Millisecond)
+	}
+	return nil
+}
+
+// RecoveryStatus implements types.Recoverer. The hybrid engine recovers
+// before it opens, so it is always done.
+func (h *HybridEngine) RecoveryStatus() types.RecoveryStatus {
+	return h.disk.RecoveryStatus()
+}
+
+var (
+	_ types.Recoverer = (*DiskEngine)(nil)
+	_ types.Recoverer = (*HybridEngine)(nil)
+)
@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/btree"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// scanChunk is how many records a scan reads per lock acquisition.
+const scanChunk = 256
+
+// batchGetMap returns the live record for each of keys in a map-backed
+// engine. The caller holds its read lock.
+func batchGetMap(records map[string]*types.Record, keys []string) map[string]*types.Record {
+	now := time.Now()
+	found := make(map[string]*types.Record, len(keys))
+	for _, key := range keys {
+		if rec := liveAt(records[key], now); rec != nil {
+			found[key] = unpack(rec)
+		}
+	}
+	return found
+}
+
+// scanMap scans a map-backed engine. Matching keys are snapshotted and
+// sorted up front; records are then looked up a chunk at a time, skipping
+// any deleted since the snapshot.
+func scanMap(ctx context.Context, mu *sync.RWMutex, records map[string]*types.Record, prefix string, fn func(*types.Record) bool) error {
+	return walkMap(ctx, mu, records, prefix, nil, func(item btreeItem) bool { return fn(item.rec) })
+}
+
+// walkMap is scanMap handing fn the key alongside what p picks of each
+// live record, projected as it is read so that a packed record is only
+// decoded as far as p needs.
+func walkMap(ctx context.Context, mu *sync.RWMutex, records map[string]*types.Record, prefix string, p *types.Projection, fn func(btreeItem) bool) error {
+	now := time.Now()
+	keys := prefixKeys(mu, records, prefix)
+	batch := make([]btreeItem, 0, scanChunk)
+	for start := 0; start < len(keys); start += scanChunk {
+		end := min(start+scanChunk, len(keys))
+
+		batch = batch[:0]
+		mu.RLock()
+		for _, k := range keys[start:end] {
+			if rec := liveAt(records[k], now); rec != nil {
+				batch = append(batch, btreeItem{key: k, rec: unpackProjected(rec, p)})
+			}
+		}
+		mu.RUnlock()
+
+		for _, item := range batch {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !fn(item) {
+				return nil
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// snapshotMap is scanMap as of one moment: the live records under prefix
+// are collected under a single read lock, then handed to fn in key order.
+// It holds a pointer per record rather than a copy, as writes replace
+// records instead of changing them.
+func snapshotMap(ctx context.Context, mu *sync.RWMutex, records map[string]*types.Record, prefix string, fn func(*types.Record) bool) error {
+	now := time.Now()
+	var items []btreeItem
+	mu.RLock()
+	for k, rec := range records {
+		if rec = liveAt(rec, now); rec != nil && strings.HasPrefix(k, prefix) {
+			items = append(items, btreeItem{key: k, rec: unpack(rec)})
+		}
+	}
+	mu.RUnlock()
+	slices.SortFunc(items, func(a, b btreeItem) int { return strings.Compare(a.key, b.key) })
+
+	for _, item := range items {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !fn(item.rec) {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// prefixKeys returns the keys of records starting with prefix, sorted.
+func prefixKeys(mu *sync.RWMutex, records map[string]*types.Record, prefix string) []string {
+	mu.RLock()
+	keys := make([]string, 0, len(records))
+	for k := range records {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	mu.RUnlock()
+	sort.Strings(keys)
+	return keys
+}
+
+// scanTree walks a btree in key order, scanChunk items per read lock, and
+// resumes after the last key seen so writers can interleave between chunks.
+func scanTree(ctx context.Context, mu *sync.RWMutex, tree *btree.BTree, prefix string, fn func(*types.Record) bool) error {
+	return walkTree(ctx, mu, tree, prefix, nil, func(item btreeItem) bool { return fn(item.rec) })
+}
+
+// scanSnapshot scans a tree no one else writes to, such as a clone.
+func scanSnapshot(ctx context.Context, tree *btree.BTree, prefix string, fn func(*types.Record) bool) error {
+	return scanTree(ctx, new(sync.RWMutex), tree, prefix, fn)
+}
+
+// walkTree is scanTree handing fn the key alongside what p picks of each
+// live record.
+func walkTree(ctx context.Context, mu *sync.RWMutex, tree *btree.BTree, prefix string, p *types.Projection, fn func(btreeItem) bool) error {
+	now := time.Now()
+	batch := make([]btreeItem, 0, scanChunk)
+	from, skip := prefix, false
+	for {
+		batch = batch[:0]
+		mu.RLock()
+		tree.AscendGreaterOrEqual(btreeItem{key: from}, func(i btree.Item) bool {
+			item := i.(btreeItem)
+			if skip && item.key == from {
+				return true
+			}
+			if !strings.HasPrefix(item.key, prefix) {
+				return false
+			}
+			if liveAt(item.rec, now) == nil {
+				return true
+			}
+			batch = append(batch, btreeItem{key: item.key, rec: p.Apply(item.rec)})
+			return len(batch) < scanChunk
+		})
+		mu.RUnlock()
+
+		for _, item := range batch {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if !fn(item) {
+				return nil
+			}
+		}
+		if len(batch) < scanChunk {
+			return ctx.Err()
+		}
+		from, skip = batch[len(batch)-1].key, true
+	}
+}
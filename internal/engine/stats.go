@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func (e *MemoryEngine) Stats() types.EngineStats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	used := e.feed.recordBytes()
+	return types.EngineStats{Mode: types.ModeMemory, Records: len(e.records), RecordBytes: used, MemoryUsed: used,
+		GC: e.gcs.stats(), Compression: e.packer.stats(), Workers: e.workers.stats(), Indexing: e.indexing.stats()}
+}
+
+func (e *DiskEngine) Stats() types.EngineStats {
+	e.mu.RLock()
+	used := e.feed.recordBytes()
+	stats := types.EngineStats{Mode: types.ModeDisk, Records: e.tree.Len(), RecordBytes: used, MemoryUsed: used,
+		GC: e.gcs.stats(), Workers: e.workers.stats(), Disk: e.space.stats(), Indexing: e.indexing.stats()}
+	e.mu.RUnlock()
+	stats.WAL = e.walStats()
+	return stats
+}
+
+// walStats is nil while the WAL is being replayed.
+func (e *DiskEngine) walStats() *types.WALStats {
+	if !e.config.EnableWAL || e.recovered() != nil {
+		return nil
+	}
+	wal := e.wal.Stats()
+	return &wal
+}
+
+func (e *ColumnarEngine) Stats() types.EngineStats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	columnar := e.store.Stats()
+	used := e.feed.recordBytes()
+	return types.EngineStats{Mode: types.ModeColumnar, Records: len(e.records), RecordBytes: used, MemoryUsed: used,
+		Columnar: &columnar, GC: e.gcs.stats(), Workers: e.workers.stats(), Indexing: e.indexing.stats()}
+}
+
+func (e *VectorEngine) Stats() types.EngineStats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	idx, used := e.index.Stats(), e.feed.recordBytes()
+	fields, postingBytes := e.postings.Stats()
+	vec := &types.VectorStats{Nodes: idx.Nodes, Levels: idx.Levels, Dim: idx.Dim, MemoryBytes: idx.MemoryBytes + postingBytes}
+	for _, f := range fields {
+		vec.Filters = append(vec.Filters, types.FilterFieldStats{Field: f.Field, Values: f.Values, Records: f.Records})
+	}
+	return types.EngineStats{
+		Mode:        types.ModeVector,
+		Records:     len(e.records),
+		Vector:      vec,
+		GC:          e.gcs.stats(),
+		RecordBytes: used,
+		MemoryUsed:  used + vec.MemoryBytes,
+		Workers:     e.workers.stats(),
+		Indexing:    e.indexing.stats(),
+	}
+}
+
+// Stats counts the records on disk and those still on their way there, and
+// reports each tier's internals.
+func (h *HybridEngine) Stats() types.EngineStats {
+	queued := int(h.queued.Load())
+	mark := h.Watermark()
+	cache, vec, used := h.cache.stats(), h.vectorStore.Stats().Vector, h.feed.recordBytes()
+	vec.Load = h.vload.status()
+	return types.EngineStats{
+		Mode:        types.ModeHybrid,
+		Records:     h.countRecords(),
+		AsyncQueue:  &queued,
+		Watermark:   &mark,
+		Cache:       cache,
+		WAL:         h.disk.walStats(),
+		Columnar:    h.columnStore.Stats().Columnar,
+		Vector:      vec,
+		GC:          h.disk.gcs.stats(),
+		Compression: h.memory.packer.stats(),
+		RecordBytes: used,
+		MemoryUsed:  used + cache.SizeBytes + vec.MemoryBytes,
+		Workers:     h.workers.stats(),
+		Disk:        h.space.stats(),
+		Indexing:    h.indexing.stats(),
+	}
+}
+
+// countRecords counts the disk tier's records and the memory tier's keys
+// disk does not have yet. Locks are taken in Scan's order, disk first.
+func (h *HybridEngine) countRecords() int {
+	h.disk.mu.RLock()
+	defer h.disk.mu.RUnlock()
+	h.memory.mu.RLock()
+	defer h.memory.mu.RUnlock()
+
+	n := h.disk.tree.Len()
+	for key := range h.memory.records {
+		if h.disk.getLocked(key) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+var (
+	_ types.StatsReporter = (*MemoryEngine)(nil)
+	_ types.StatsReporter = (*DiskEngine)(nil)
+	_ types.StatsReporter = (*ColumnarEngine)(nil)
+	_ types.StatsReporter = (*VectorEngine)(nil)
+	_ types.StatsReporter = (*HybridEngine)(nil)
+)
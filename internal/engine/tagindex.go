@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// tagIndex maps each tag to the keys of the records that carry it. Like
+// the text indexes it lives in the engine's feed, which hands it every
+// change under the engine's write lock, and it is rebuilt rather than
+// logged or backed up.
+type tagIndex struct {
+	mu   sync.RWMutex
+	keys map[string]map[string]struct{} // tag → keys
+	tags map[string][]string            // key → its tags
+	next *rebuild[*tagIndex]            // the copy RebuildIndexes is building
+}
+
+func newTagIndex() *tagIndex {
+	return &tagIndex{keys: make(map[string]map[string]struct{}), tags: make(map[string][]string)}
+}
+
+// apply updates the index for a change emitted by the feed.
+func (x *tagIndex) apply(op types.Operation, key string, rec *types.Record) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.applyLocked(op, key, rec)
+	if x.next != nil {
+		x.next.touch(key).applyLocked(op, key, rec)
+	}
+}
+
+func (x *tagIndex) applyLocked(op types.Operation, key string, rec *types.Record) {
+	for _, tag := range x.tags[key] {
+		delete(x.keys[tag], key)
+		if len(x.keys[tag]) == 0 {
+			delete(x.keys, tag)
+		}
+	}
+	delete(x.tags, key)
+	if op != types.OpPut || rec == nil || len(rec.Tags) == 0 {
+		return
+	}
+	for _, tag := range rec.Tags {
+		if x.keys[tag] == nil {
+			x.keys[tag] = make(map[string]struct{})
+		}
+		x.keys[tag][key] = struct{}{}
+	}
+	x.tags[key] = slices.Clone(rec.Tags)
+}
+
+// rebuilder rebuilds x for RebuildIndexes.
+func (x *tagIndex) rebuilder() rebuildable {
+	return &indexRebuild[*tagIndex]{
+		mu:    &x.mu,
+		next:  &x.next,
+		fresh: newTagIndex,
+		add: func(y *tagIndex, key string, rec *types.Record) error {
+			y.applyLocked(types.OpPut, key, rec)
+			return nil
+		},
+		install: func(y *tagIndex) { x.keys, x.tags = y.keys, y.tags },
+	}
+}
+
+// tagged returns the keys starting with prefix that carry tag, sorted.
+func (x *tagIndex) tagged(tag, prefix string) []string {
+	x.mu.RLock()
+	keys := make([]string, 0, len(x.keys[tag]))
+	for key := range x.keys[tag] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	x.mu.RUnlock()
+	sort.Strings(keys)
+	return keys
+}
+
+// tagScan implements types.TagScanner for an engine whose feed holds x,
+// looking up the tagged keys with get a chunk at a time and skipping any
+// deleted, expired or retagged since they were indexed.
+func tagScan(ctx context.Context, x *tagIndex, get func(context.Context, []string) (map[string]*types.Record, error), tag, prefix string, fn func(*types.Record) bool) error {
+	keys := x.tagged(tag, prefix)
+	for start := 0; start < len(keys); start += scanChunk {
+		chunk := keys[start:min(start+scanChunk, len(keys))]
+		found, err := get(ctx, chunk)
+		if err != nil {
+			return err
+		}
+		for _, key := range chunk {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if rec := found[key]; rec != nil && slices.Contains(rec.Tags, tag) && !fn(rec) {
+				return nil
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// ScanTag implements types.TagScanner.
+func (e *MemoryEngine) ScanTag(ctx context.Context, tag, prefix string, fn func(*types.Record) bool) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	return tagScan(ctx, e.feed.tags, e.BatchGet, tag, prefix, fn)
+}
+
+// ScanTag implements types.TagScanner.
+func (e *DiskEngine) ScanTag(ctx context.Context, tag, prefix string, fn func(*types.Record) bool) error {
+	if err := e.readable(); err != nil {
+		return err
+	}
+	return tagScan(ctx, e.feed.tags, e.BatchGet, tag, prefix, fn)
+}
+
+// ScanTag implements types.TagScanner.
+func (e *ColumnarEngine) ScanTag(ctx context.Context, tag, prefix string, fn func(*types.Record) bool) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	return tagScan(ctx, e.feed.tags, e.BatchGet, tag, prefix, fn)
+}
+
+// ScanTag implements types.TagScanner.
+func (e *VectorEngine) ScanTag(ctx context.Context, tag, prefix string, fn func(*types.Record) bool) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	return tagScan(ctx, e.feed.tags, e.BatchGet, tag, prefix, fn)
+}
+
+// ScanTag implements types.TagScanner.
+func (h *HybridEngine) ScanTag(ctx context.Context, tag, prefix string, fn func(*types.Record) bool) error {
+	if err := h.open(); err != nil {
+		return err
+	}
+	return tagScan(ctx, h.feed.tags, h.BatchGet, tag, prefix, fn)
+}
+
+var (
+	_ types.TagScanner = (*MemoryEngine)(nil)
+	_ types.TagScanner = (*DiskEngine)(nil)
+	_ types.TagScanner = (*ColumnarEngine)(nil)
+	_ types.TagScanner = (*VectorEngine)(nil)
+	_ types.TagScanner = (*HybridEngine)(nil)
+)
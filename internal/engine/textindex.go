@@ -0,0 +1,412 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/google/btree"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// defaultStopwords are left out of text indexes unless text_index.stopwords
+// says otherwise.
+var defaultStopwords = []string{
+	"a", "an", "and", "are", "as", "at", "be", "but", "by", "for", "if", "in",
+	"into", "is", "it", "no", "not", "of", "on", "or", "such", "that", "the",
+	"their", "then", "there", "these", "they", "this", "to", "was", "will", "with",
+}
+
+// textIndex holds the full-text indexes of an engine: for each indexed
+// field of Data, the records each term appears in. It lives in the
+// engine's feed, which hands it every change under the engine's write
+// lock, so it is current whatever path a write takes. Being derived from
+// the records, it is never logged or backed up; it is rebuilt when the
+// engine opens and when a backup is restored.
+type textIndex struct {
+	mu        sync.RWMutex
+	stopwords map[string]bool
+	fields    map[string]*textField
+	next      *rebuild[*textIndex] // the copy RebuildIndexes is building
+}
+
+type textField struct {
+	postings map[string]map[string]int // term → key → occurrences
+	terms    map[string][]string       // key → its distinct terms
+}
+
+func newTextIndex(cfg config.TextIndexConfig) *textIndex {
+	words := cfg.Stopwords
+	if words == nil {
+		words = defaultStopwords
+	}
+	stop := make(map[string]bool, len(words))
+	for _, w := range words {
+		stop[strings.ToLower(w)] = true
+	}
+	return &textIndex{stopwords: stop, fields: make(map[string]*textField)}
+}
+
+func newTextField() *textField {
+	return &textField{postings: make(map[string]map[string]int), terms: make(map[string][]string)}
+}
+
+// tokenize splits s into lowercase words of letters and digits, leaving
+// out stopwords.
+func (x *textIndex) tokenize(s string) []string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := words[:0]
+	for _, w := range words {
+		if !x.stopwords[w] {
+			terms = append(terms, w)
+		}
+	}
+	return terms
+}
+
+// create starts indexing field, filling it from each, which hands over
+// every stored record. The caller holds the engine's write lock, so no
+// change can slip in between.
+func (x *textIndex) create(field string, each func(add func(key string, rec *types.Record))) error {
+	if field == "" {
+		return fmt.Errorf("text index needs a field name")
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.fields[field] != nil {
+		return nil
+	}
+	f := newTextField()
+	each(func(key string, rec *types.Record) {
+		if live(rec) != nil {
+			x.addLocked(f, field, key, rec)
+		}
+	})
+	x.fields[field] = f
+	return nil
+}
+
+// apply updates every index for a change emitted by the feed.
+func (x *textIndex) apply(op types.Operation, key string, rec *types.Record) {
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.applyLocked(op, key, rec)
+	if x.next != nil {
+		x.next.touch(key).applyLocked(op, key, rec)
+	}
+}
+
+func (x *textIndex) applyLocked(op types.Operation, key string, rec *types.Record) {
+	for name, f := range x.fields {
+		f.remove(key)
+		if op == types.OpPut {
+			x.addLocked(f, name, key, rec)
+		}
+	}
+}
+
+// rebuilder rebuilds every field of x for RebuildIndexes. A field created
+// meanwhile is filled as it is created, so it is kept as it is.
+func (x *textIndex) rebuilder() rebuildable {
+	return &indexRebuild[*textIndex]{
+		mu:   &x.mu,
+		next: &x.next,
+		fresh: func() *textIndex {
+			y := &textIndex{stopwords: x.stopwords, fields: make(map[string]*textField, len(x.fields))}
+			for name := range x.fields {
+				y.fields[name] = newTextField()
+			}
+			return y
+		},
+		add: func(y *textIndex, key string, rec *types.Record) error {
+			y.applyLocked(types.OpPut, key, rec)
+			return nil
+		},
+		install: func(y *textIndex) {
+			for name, f := range x.fields {
+				if y.fields[name] == nil {
+					y.fields[name] = f
+				}
+			}
+			x.fields = y.fields
+		},
+	}
+}
+
+func (x *textIndex) addLocked(f *textField, field, key string, rec *types.Record) {
+	s, ok := rec.Data[field].(string)
+	if !ok {
+		return
+	}
+	counts := make(map[string]int)
+	for _, term := range x.tokenize(s) {
+		counts[term]++
+	}
+	if len(counts) == 0 {
+		return
+	}
+	terms := make([]string, 0, len(counts))
+	for term, n := range counts {
+		if f.postings[term] == nil {
+			f.postings[term] = make(map[string]int)
+		}
+		f.postings[term][key] = n
+		terms = append(terms, term)
+	}
+	f.terms[key] = terms
+}
+
+func (f *textField) remove(key string) {
+	for _, term := range f.terms[key] {
+		delete(f.postings[term], key)
+		if len(f.postings[term]) == 0 {
+			delete(f.postings, term)
+		}
+	}
+	delete(f.terms, key)
+}
+
+// textMatch is a key matching a query, with its score.
+type textMatch struct {
+	key   string
+	score float64
+}
+
+// search ranks the keys whose field matches query, best first. Words in
+// query must all appear; OR between words separates alternatives, any of
+// which may match. A key scores the occurrences in it of every query term
+// it has.
+func (x *textIndex) search(field, query string) ([]textMatch, error) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	f := x.fields[field]
+	if f == nil {
+		return nil, fmt.Errorf("%w on field %q", types.ErrNoTextIndex, field)
+	}
+	matched := make(map[string]bool)
+	queried := make(map[string]bool)
+	for _, alt := range strings.Split(" "+query+" ", " OR ") {
+		terms := x.tokenize(alt)
+		if len(terms) == 0 {
+			continue
+		}
+		for _, term := range terms {
+			queried[term] = true
+		}
+		for key := range f.postings[terms[0]] {
+			all := true
+			for _, term := range terms[1:] {
+				if _, ok := f.postings[term][key]; !ok {
+					all = false
+					break
+				}
+			}
+			if all {
+				matched[key] = true
+			}
+		}
+	}
+
+	matches := make([]textMatch, 0, len(matched))
+	for key := range matched {
+		var score float64
+		for term := range queried {
+			score += float64(f.postings[term][key])
+		}
+		matches = append(matches, textMatch{key: key, score: score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return matches[i].key < matches[j].key
+	})
+	return matches, nil
+}
+
+// textSearch implements types.TextSearcher for an engine whose feed holds
+// x, looking up the ranked keys with get a chunk at a time and skipping
+// any deleted or expired since they were indexed. A limit of 0 or less
+// returns every match.
+func textSearch(ctx context.Context, x *textIndex, get func(context.Context, []string) (map[string]*types.Record, error), field, query string, limit int) ([]types.TextHit, error) {
+	matches, err := x.search(field, query)
+	if err != nil {
+		return nil, err
+	}
+	hits := []types.TextHit{}
+	for start := 0; start < len(matches); start += scanChunk {
+		chunk := matches[start:min(start+scanChunk, len(matches))]
+		keys := make([]string, len(chunk))
+		for i, m := range chunk {
+			keys[i] = m.key
+		}
+		found, err := get(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range chunk {
+			if rec := found[m.key]; rec != nil {
+				hits = append(hits, types.TextHit{Record: rec, Score: m.score})
+				if len(hits) == limit {
+					return hits, nil
+				}
+			}
+		}
+	}
+	return hits, nil
+}
+
+// createTextIndexes indexes the fields cfg names, once the engine has
+// recovered its records.
+func createTextIndexes(eng types.TextSearcher, cfg config.TextIndexConfig) error {
+	for _, field := range cfg.Fields {
+		if err := eng.CreateTextIndex(field); err != nil {
+			return fmt.Errorf("text index on %s: %w", field, err)
+		}
+	}
+	return nil
+}
+
+// CreateTextIndex implements types.TextSearcher.
+func (e *MemoryEngine) CreateTextIndex(field string) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.feed.text.create(field, eachInMap(e.records))
+}
+
+// TextSearch implements types.TextSearcher.
+func (e *MemoryEngine) TextSearch(ctx context.Context, field, query string, limit int) ([]types.TextHit, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	return textSearch(ctx, e.feed.text, e.BatchGet, field, query, limit)
+}
+
+// CreateTextIndex implements types.TextSearcher.
+func (e *DiskEngine) CreateTextIndex(field string) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.feed.text.create(field, func(add func(string, *types.Record)) {
+		e.tree.Ascend(func(i btree.Item) bool {
+			item := i.(btreeItem)
+			add(item.key, item.rec)
+			return true
+		})
+	})
+}
+
+// TextSearch implements types.TextSearcher.
+func (e *DiskEngine) TextSearch(ctx context.Context, field, query string, limit int) ([]types.TextHit, error) {
+	if err := e.readable(); err != nil {
+		return nil, err
+	}
+	return textSearch(ctx, e.feed.text, e.BatchGet, field, query, limit)
+}
+
+// CreateTextIndex implements types.TextSearcher.
+func (e *ColumnarEngine) CreateTextIndex(field string) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.feed.text.create(field, eachInMap(e.records))
+}
+
+// TextSearch implements types.TextSearcher.
+func (e *ColumnarEngine) TextSearch(ctx context.Context, field, query string, limit int) ([]types.TextHit, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	return textSearch(ctx, e.feed.text, e.BatchGet, field, query, limit)
+}
+
+// CreateTextIndex implements types.TextSearcher.
+func (e *VectorEngine) CreateTextIndex(field string) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.feed.text.create(field, eachInMap(e.records))
+}
+
+// TextSearch implements types.TextSearcher.
+func (e *VectorEngine) TextSearch(ctx context.Context, field, query string, limit int) ([]types.TextHit, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	return textSearch(ctx, e.feed.text, e.BatchGet, field, query, limit)
+}
+
+// CreateTextIndex implements types.TextSearcher. It holds the hybrid lock,
+// which every write takes, while it reads the records from memory, which
+// has every write still queued, and from disk.
+func (h *HybridEngine) CreateTextIndex(field string) error {
+	if err := h.open(); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.disk.mu.RLock()
+	defer h.disk.mu.RUnlock()
+	h.memory.mu.RLock()
+	defer h.memory.mu.RUnlock()
+
+	return h.feed.text.create(field, func(add func(string, *types.Record)) {
+		h.disk.tree.Ascend(func(i btree.Item) bool {
+			if item := i.(btreeItem); h.memory.records[item.key] == nil {
+				add(item.key, item.rec)
+			}
+			return true
+		})
+		eachInMap(h.memory.records)(add)
+	})
+}
+
+// TextSearch implements types.TextSearcher.
+func (h *HybridEngine) TextSearch(ctx context.Context, field, query string, limit int) ([]types.TextHit, error) {
+	if err := h.open(); err != nil {
+		return nil, err
+	}
+	return textSearch(ctx, h.feed.text, h.BatchGet, field, query, limit)
+}
+
+// eachInMap hands create the records of a map-backed engine.
+func eachInMap(records map[string]*types.Record) func(func(string, *types.Record)) {
+	return func(add func(string, *types.Record)) {
+		for key, rec := range records {
+			add(key, unpack(rec))
+		}
+	}
+}
+
+var (
+	_ types.TextSearcher = (*MemoryEngine)(nil)
+	_ types.TextSearcher = (*DiskEngine)(nil)
+	_ types.TextSearcher = (*ColumnarEngine)(nil)
+	_ types.TextSearcher = (*VectorEngine)(nil)
+	_ types.TextSearcher = (*HybridEngine)(nil)
+)
@@ -0,0 +1,58 @@
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// What an engine spends on each record it holds besides the record: its
+// entry in the engine's map or B-tree and in the change index, and in the
+// tag index for each tag.
+const (
+	heldOverhead = 256
+	tagOverhead  = 64
+)
+
+// memoryUsage adds up the memory the records an engine holds take, as
+// recordSize estimates them decoded, with the entries indexing them. Like
+// the tag index it lives in the engine's feed, which hands it every
+// change, puts, deletes, expiries and recovery alike.
+type memoryUsage struct {
+	mu    sync.Mutex
+	sizes map[string]int64 // key → its record's estimate
+	total atomic.Int64
+}
+
+func newMemoryUsage() *memoryUsage {
+	return &memoryUsage{sizes: make(map[string]int64)}
+}
+
+// apply accounts for a change emitted by the feed.
+func (u *memoryUsage) apply(op types.Operation, key string, rec *types.Record) {
+	if u == nil {
+		return
+	}
+	var size int64
+	if op == types.OpPut && rec != nil {
+		size = recordSize(key, rec) + heldOverhead + tagOverhead*int64(len(rec.Tags))
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.total.Add(size - u.sizes[key])
+	if size == 0 {
+		delete(u.sizes, key)
+	} else {
+		u.sizes[key] = size
+	}
+}
+
+// recordBytes is the estimate for every record the engine holds; it
+// takes no lock.
+func (f *feed) recordBytes() int64 {
+	if f == nil {
+		return 0
+	}
+	return f.usage.total.Load()
+}
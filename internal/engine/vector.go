@@ -3,19 +3,33 @@ package engine
 import (
 	"context"
 	"fmt"
+	"slices"
 	"sync"
 	"time"
 
+	"github.com/thirawat27/kvi/internal/tracing"
 	"github.com/thirawat27/kvi/internal/vector"
 	"github.com/thirawat27/kvi/pkg/config"
 	"github.com/thirawat27/kvi/pkg/types"
 )
 
 type VectorEngine struct {
+	engineState
+
 	config  *config.Config
 	records map[string]*types.Record
 	index   *vector.HNSWIndex
-	mu      sync.RWMutex
+	next    *rebuild[*vector.HNSWIndex] // the copy RebuildIndexes is building
+	pending *indexQueue                 // index updates behind the writes, if async_indexing
+	// postings index the filterable fields of the records stored, kept in
+	// step with them even when the vectors are indexed behind the writes
+	postings *vector.Postings
+	mu       sync.RWMutex
+	feed     *feed
+	tracer   *tracing.Tracer
+	gcs      gcCounts
+
+	collections collectionCache
 }
 
 func NewVectorEngine(cfg *config.Config) (*VectorEngine, error) {
@@ -23,72 +37,437 @@ func NewVectorEngine(cfg *config.Config) (*VectorEngine, error) {
 		return nil, fmt.Errorf("vector dim must be > 0")
 	}
 
-	return &VectorEngine{
-		config:  cfg,
-		records: make(map[string]*types.Record),
-		index:   vector.NewHNSWIndex(cfg.VectorDim),
-	}, nil
+	e := &VectorEngine{
+		config:   cfg,
+		records:  make(map[string]*types.Record),
+		index:    newVectorIndex(cfg),
+		postings: vector.NewPostings(cfg.VectorFilterFields),
+		feed:     newFeed(cfg),
+		tracer:   tracing.New(cfg.TracerProvider),
+	}
+	e.pending = newIndexQueue(cfg, &e.mu, e.applyIndexLocked)
+	e.indexing.add(e.feed.queue)
+	e.indexing.add(e.pending)
+	return e, nil
+}
+
+// newVectorIndex returns an empty index bounded as cfg says.
+func newVectorIndex(cfg *config.Config) *vector.HNSWIndex {
+	index := vector.NewHNSWIndex(cfg.VectorDim)
+	index.SetMaxMemory(int64(cfg.VectorIndexMaxMemoryMB) << 20)
+	return index
 }
 
 func (e *VectorEngine) Put(ctx context.Context, key string, record *types.Record) error {
+	_, span := e.tracer.Start(ctx, "vector.Put")
+	defer span.End()
+
+	if err := e.writable(); err != nil {
+		return err
+	}
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	// Need a vector field from Record, here we extract it, assume "vector" key in Data map holds []float32
-	vecVal, ok := record.Data["vector"]
-	if !ok {
-		return fmt.Errorf("record missing 'vector' key")
+	return e.putLocked(key, record)
+}
+
+func (e *VectorEngine) putLocked(key string, record *types.Record) error {
+	if err := e.checkRecord(key, record); err != nil {
+		return err
 	}
+	if err := e.indexLocked(key, record.Vector); err != nil {
+		return err
+	}
+	stamp(e.records[key], record)
+	e.storeLocked(key, record)
+	return nil
+}
 
-	vec, ok := vecVal.([]float32)
-	if !ok {
-		return fmt.Errorf("vector must be []float32")
+// checkRecord rejects a record without a vector the index can take.
+func (e *VectorEngine) checkRecord(key string, record *types.Record) error {
+	if len(record.Vector) == 0 {
+		return fmt.Errorf("%w: record %s has no vector", types.ErrInvalidVector, key)
 	}
+	return checkVector(record.Vector, e.config.VectorDim)
+}
 
+// storeLocked stores record as it is, already stamped, checked and
+// indexed.
+func (e *VectorEngine) storeLocked(key string, record *types.Record) {
 	e.records[key] = record
-	e.index.Add(key, vec)
+	e.postings.Add(key, record.Data)
+	e.feed.put(key, record)
+}
+
+func (e *VectorEngine) deleteLocked(key string) {
+	if rec, ok := e.records[key]; ok {
+		delete(e.records, key)
+		e.unindexLocked(key)
+		e.feed.deleted(key, rec)
+	}
+}
+
+// BatchPut writes every record under one write lock.
+func (e *VectorEngine) BatchPut(ctx context.Context, records []*types.Record) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, rec := range records {
+		if err := e.putLocked(rec.ID, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexLocked adds vec to the index under key, or queues it to be added.
+// A queued vector is checked against the index as it is, which may not
+// have the vectors queued before it yet: a burst of writes can pass the
+// memory bound, and the vectors the index then refuses are counted as
+// indexing errors and left for kvi verify to find.
+func (e *VectorEngine) indexLocked(key string, vec []float32) error {
+	if e.pending == nil {
+		return e.addIndexLocked(key, vec)
+	}
+	if err := e.index.Fits(key, vec); err != nil {
+		return err
+	}
+	e.pending.add(types.OpPut, key, &types.Record{ID: key, Vector: vec})
+	return nil
+}
+
+// unindexLocked removes key from the index, or queues its removal, and
+// takes it off the posting lists.
+func (e *VectorEngine) unindexLocked(key string) {
+	e.postings.Remove(key)
+	if e.pending == nil {
+		e.removeIndexLocked(key)
+		return
+	}
+	e.pending.add(types.OpDelete, key, nil)
+}
+
+// applyIndexLocked applies an update queued by indexLocked or
+// unindexLocked.
+func (e *VectorEngine) applyIndexLocked(job indexJob) error {
+	if job.op == types.OpPut {
+		return e.addIndexLocked(job.key, job.rec.Vector)
+	}
+	e.removeIndexLocked(job.key)
+	return nil
+}
+
+// addIndexLocked adds vec to the index under key, and to the copy being
+// rebuilt if there is one. The copy indexes no more than the index does,
+// so if the index has room for vec the copy does too.
+func (e *VectorEngine) addIndexLocked(key string, vec []float32) error {
+	if err := e.index.Add(key, vec); err != nil {
+		return err
+	}
+	if e.next != nil {
+		_ = e.next.touch(key).Add(key, vec)
+	}
+	return nil
+}
+
+// removeIndexLocked removes key from the index and from any copy being
+// rebuilt.
+func (e *VectorEngine) removeIndexLocked(key string) {
+	e.index.Delete(key)
+	if e.next != nil {
+		e.next.touch(key).Delete(key)
+	}
+}
+
+// fits fails with types.ErrMemoryLimit if indexing vec under key would
+// take the index past vector_index_max_memory_mb.
+func (e *VectorEngine) fits(key string, vec []float32) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.index.Fits(key, vec)
+}
+
+// checkVector fails for a vector the index cannot take.
+func checkVector(vec []float32, dim int) error {
+	if len(vec) != dim {
+		return fmt.Errorf("%w: %d dimensions, want %d", types.ErrInvalidVector, len(vec), dim)
+	}
 	return nil
 }
 
 func (e *VectorEngine) Get(ctx context.Context, key string) (*types.Record, error) {
+	_, span := e.tracer.Start(ctx, "vector.Get")
+	defer span.End()
+
+	if err := e.open(); err != nil {
+		return nil, err
+	}
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
-	record, ok := e.records[key]
-	if !ok {
-		return nil, fmt.Errorf("record not found for key: %s", key)
+	stored := e.records[key]
+	record := live(stored)
+	if record == nil {
+		if stored != nil {
+			e.feed.reapLater(key, e.reap)
+		}
+		return nil, fmt.Errorf("%w for key: %s", types.ErrKeyNotFound, key)
 	}
 	return record, nil
 }
 
 func (e *VectorEngine) Delete(ctx context.Context, key string) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	delete(e.records, key)
-	e.index.Delete(key)
+	e.deleteLocked(key)
 	return nil
 }
 
+// BatchGet reads every key under one read lock.
+func (e *VectorEngine) BatchGet(ctx context.Context, keys []string) (map[string]*types.Record, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return batchGetMap(e.records, keys), nil
+}
+
+// BatchDelete deletes every key under one write lock.
+func (e *VectorEngine) BatchDelete(ctx context.Context, keys []string) (map[string]bool, error) {
+	if err := e.writable(); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	deleted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if live(e.records[key]) != nil {
+			deleted[key] = true
+		}
+		e.deleteLocked(key)
+	}
+	return deleted, nil
+}
+
+func (e *VectorEngine) CompareAndSwap(ctx context.Context, key string, version uint64, record *types.Record) error {
+	if err := e.writable(); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := checkVersion(key, live(e.records[key]), version); err != nil {
+		return err
+	}
+	if record == nil {
+		e.deleteLocked(key)
+		return nil
+	}
+	return e.putLocked(key, record)
+}
+
+func (e *VectorEngine) Update(ctx context.Context, key string, fn func(*types.Record) error) (*types.Record, error) {
+	if err := e.writable(); err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	cur := live(e.records[key])
+	if cur == nil {
+		return nil, fmt.Errorf("%w for key: %s", types.ErrKeyNotFound, key)
+	}
+	next := cur.Clone()
+	if err := fn(next); err != nil {
+		return nil, err
+	}
+	if err := e.putLocked(key, next); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+func (e *VectorEngine) Scan(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+	ctx, span := e.tracer.Start(ctx, "vector.Scan")
+	defer span.End()
+
+	if err := e.open(); err != nil {
+		return err
+	}
+	return scanMap(ctx, &e.mu, e.records, prefix, fn)
+}
+
+// ScanConsistent implements types.ConsistentScanner.
+func (e *VectorEngine) ScanConsistent(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+	ctx, span := e.tracer.Start(ctx, "vector.ScanConsistent")
+	defer span.End()
+
+	if err := e.open(); err != nil {
+		return err
+	}
+	return snapshotMap(ctx, &e.mu, e.records, prefix, fn)
+}
+
+// Apply implements types.Replica.
+func (e *VectorEngine) Apply(ctx context.Context, ev types.ChangeEvent) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	if err := checkApply(ev); err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if ev.Op == types.OpDelete {
+		e.deleteLocked(ev.Key)
+		return nil
+	}
+	if err := e.checkRecord(ev.Key, ev.Record); err != nil {
+		return err
+	}
+	if err := e.indexLocked(ev.Key, ev.Record.Vector); err != nil {
+		return err
+	}
+	e.storeLocked(ev.Key, ev.Record)
+	return nil
+}
+
+func (e *VectorEngine) Watch(ctx context.Context, prefix string, fromSeq uint64) (<-chan types.ChangeEvent, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	return e.feed.watch(ctx, prefix, fromSeq)
+}
+
 func (e *VectorEngine) Close() error {
+	if err := e.close(); err != nil {
+		return err
+	}
+	if e.pending != nil {
+		e.pending.close()
+	}
+	e.feed.close()
 	return nil
 }
 
 func (e *VectorEngine) Search(ctx context.Context, query []float32, k int) ([]*types.Record, error) {
+	_, span := e.tracer.Start(ctx, "vector.Search")
+	defer span.End()
+
+	return e.search(query, k, nil)
+}
+
+// SearchFiltered implements types.FilteredSearcher.
+func (e *VectorEngine) SearchFiltered(ctx context.Context, query []float32, k int, filter map[string]interface{}) ([]*types.Record, error) {
+	_, span := e.tracer.Start(ctx, "vector.SearchFiltered")
+	defer span.End()
+
+	if err := vector.CheckFilter(filter); err != nil {
+		return nil, err
+	}
+	return e.search(query, k, filter)
+}
+
+// bruteForceShare is the share of the records at most, as a divisor, that
+// a filtered search scores one by one, rather than going through the
+// index passing over the rest.
+const bruteForceShare = 10
+
+// search returns the k live records nearest to query that match filter.
+// The fields of filter with posting lists narrow the search to the
+// records holding their values before it starts; the rest are checked on
+// the records it finds.
+func (e *VectorEngine) search(query []float32, k int, filter map[string]interface{}) ([]*types.Record, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
 	e.mu.RLock()
 	defer e.mu.RUnlock()
 
 	// mock search delay
 	time.Sleep(10 * time.Millisecond)
 
-	ids := e.index.Search(query, k)
+	nearest := e.index.Search
+	if allowed, _ := e.postings.Allowed(filter); allowed != nil {
+		switch n := allowed.Count(); {
+		case n == 0:
+			return nil, nil
+		case n <= len(e.records)/bruteForceShare:
+			ids := e.postings.IDs(allowed)
+			nearest = func(query []float32, k int) []string { return e.index.SearchAmong(query, k, ids) }
+		default:
+			allow := func(id string) bool { return e.postings.Has(allowed, id) }
+			nearest = func(query []float32, k int) []string { return e.index.SearchFunc(query, k, allow) }
+		}
+	}
+
+	// Expired records stay indexed until they are collected, and records
+	// failing the filter are skipped, so ask for more until k are found
+	// or the index has no more
+	now := time.Now()
 	var results []*types.Record
-	for _, id := range ids {
-		if rec, exists := e.records[id]; exists {
-			results = append(results, rec)
+	for want := k; ; want *= 2 {
+		ids := nearest(query, want)
+		results = results[:0]
+		for _, id := range ids {
+			if rec := liveAt(e.records[id], now); rec != nil && vector.Matches(rec.Data, filter) {
+				if results = append(results, rec); len(results) == k {
+					return results, nil
+				}
+			}
+		}
+		if len(ids) < want || want <= 0 {
+			return results, nil
 		}
 	}
-	return results, nil
 }
 
-var _ types.Engine = (*VectorEngine)(nil)
+// FilterFields implements types.FilteredSearcher.
+func (e *VectorEngine) FilterFields() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.postings.Fields()
+}
+
+// SetFilterFields implements types.FilteredSearcher. It holds the write
+// lock while it builds the posting lists.
+func (e *VectorEngine) SetFilterFields(fields []string) error {
+	if err := e.open(); err != nil {
+		return err
+	}
+	if slices.Contains(fields, "") {
+		return fmt.Errorf("%w: empty field name", types.ErrInvalidFilter)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	postings := vector.NewPostings(fields)
+	for key, rec := range e.records {
+		if live(rec) != nil {
+			postings.Add(key, rec.Data)
+		}
+	}
+	e.postings = postings
+	return nil
+}
+
+var (
+	_ types.Engine            = (*VectorEngine)(nil)
+	_ types.Searcher          = (*VectorEngine)(nil)
+	_ types.FilteredSearcher  = (*VectorEngine)(nil)
+	_ types.Batcher           = (*VectorEngine)(nil)
+	_ types.Watcher           = (*VectorEngine)(nil)
+	_ types.ConsistentScanner = (*VectorEngine)(nil)
+	_ types.Replica           = (*VectorEngine)(nil)
+)
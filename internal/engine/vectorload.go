@@ -0,0 +1,199 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/btree"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// States of a vector index load, as IndexLoadStatus reports them.
+const (
+	loadPending = "pending"
+	loadRunning = "loading"
+	loadDone    = "loaded"
+	loadFailed  = "failed"
+)
+
+// vectorLoad indexes the vectors of the records the hybrid engine
+// recovered once it has opened, with vector_index_load background or
+// lazy, so keys are served meanwhile. Writes index their own vectors as
+// ever; the load skips the keys they reach first.
+type vectorLoad struct {
+	loaded atomic.Int64 // records gone through
+	total  atomic.Int64
+
+	mu    sync.Mutex // guards what follows
+	state string
+	began time.Time
+	took  time.Duration
+	err   error
+}
+
+// newVectorLoad returns the load policy asks for, nil for an eager one,
+// which opening does.
+func newVectorLoad(policy string) *vectorLoad {
+	if policy != config.VectorLoadBackground && policy != config.VectorLoadLazy {
+		return nil
+	}
+	return &vectorLoad{state: loadPending}
+}
+
+// begin reports whether the load was pending, marking it running.
+func (l *vectorLoad) begin() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.state != loadPending {
+		return false
+	}
+	l.state, l.began = loadRunning, time.Now()
+	return true
+}
+
+func (l *vectorLoad) end(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state, l.took, l.err = loadDone, time.Since(l.began), err
+	if err != nil {
+		l.state = loadFailed
+	}
+}
+
+// ready returns nil once the index is loaded, else ErrIndexLoading with
+// how far it has got, or why it failed. A nil load is always ready.
+func (l *vectorLoad) ready() error {
+	if l == nil {
+		return nil
+	}
+	s := l.status()
+	switch s.State {
+	case loadDone:
+		return nil
+	case loadFailed:
+		return fmt.Errorf("vector index failed to load: %s", s.Error)
+	}
+	return fmt.Errorf("%w: %.0f%% of %d records", types.ErrIndexLoading, s.Percent, s.Total)
+}
+
+func (l *vectorLoad) status() *types.IndexLoadStatus {
+	if l == nil {
+		return nil
+	}
+	s := &types.IndexLoadStatus{Loaded: l.loaded.Load(), Total: l.total.Load()}
+	l.mu.Lock()
+	s.State = l.state
+	if l.state == loadDone || l.state == loadFailed {
+		s.DurationMs = float64(l.took.Microseconds()) / 1000
+	}
+	if l.err != nil {
+		s.Error = l.err.Error()
+	}
+	l.mu.Unlock()
+	switch {
+	case s.State == loadDone:
+		s.Percent = 100
+	case s.Total > 0:
+		s.Percent = float64(s.Loaded) * 100 / float64(s.Total)
+	}
+	return s
+}
+
+// vectorsReady starts a lazy load of the vector index, and returns
+// ErrIndexLoading until the load is done.
+func (h *HybridEngine) vectorsReady() error {
+	if h.vload == nil {
+		return nil
+	}
+	h.startVectorLoad()
+	return h.vload.ready()
+}
+
+// startVectorLoad loads the vector index on a goroutine, unless it has
+// started already.
+func (h *HybridEngine) startVectorLoad() {
+	if !h.vload.begin() {
+		return
+	}
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		err := h.loadVectors()
+		h.vload.end(err)
+		s := h.vload.status()
+		log := h.config.Log().With("engine", "hybrid", "records", s.Loaded, "duration_ms", s.DurationMs)
+		if err != nil {
+			log.Error("vector index load failed", "error", err)
+		} else {
+			log.Info("loaded vector index")
+		}
+	}()
+}
+
+// loadVectors goes through the records disk had as the load began,
+// scanChunk at a time.
+func (h *HybridEngine) loadVectors() error {
+	tree := h.disk.snapshot()
+	h.vload.total.Store(int64(tree.Len()))
+	keys := make([]string, 0, scanChunk)
+	var err error
+	tree.Ascend(func(i btree.Item) bool {
+		if keys = append(keys, i.(btreeItem).key); len(keys) == scanChunk {
+			err = h.loadVectorsOf(keys)
+			keys = keys[:0]
+		}
+		return err == nil
+	})
+	if err == nil && len(keys) > 0 {
+		err = h.loadVectorsOf(keys)
+	}
+	return err
+}
+
+// loadVectorsOf indexes the vectors keys have now, under the lock writers
+// take. A key the vector tier holds was indexed by a write since opening,
+// which also keeps it current; any other is read as Get would read it.
+func (h *HybridEngine) loadVectorsOf(keys []string) error {
+	if h.ctx.Err() != nil {
+		return types.ErrClosed
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		h.vectorStore.mu.RLock()
+		_, indexed := h.vectorStore.records[key]
+		h.vectorStore.mu.RUnlock()
+		if indexed {
+			continue
+		}
+		rec, held := h.memory.held(key, now)
+		if !held {
+			rec = liveAt(h.disk.stored(key), now)
+		}
+		if rec == nil || len(rec.Vector) == 0 {
+			continue
+		}
+		tier := *rec
+		if err := h.vectorStore.Put(context.Background(), key, &tier); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	h.vload.loaded.Add(int64(len(keys)))
+	return nil
+}
+
+// checkVectorLoad fails readiness while the vector index loads, or once
+// it has failed to, with vector_index_readiness wait_for_all. A lazy load
+// that has not started holds nothing back.
+func (h *HybridEngine) checkVectorLoad(ctx context.Context) error {
+	if s := h.vload.status(); s.State == loadPending {
+		return nil
+	}
+	return h.vload.ready()
+}
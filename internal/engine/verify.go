@@ -0,0 +1,447 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/btree"
+	"github.com/thirawat27/kvi/internal/vector"
+	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// verifier gathers what Verify finds into its report, remembering the keys
+// of the problems it can repair by check.
+type verifier struct {
+	report  *types.VerifyReport
+	start   time.Time
+	now     time.Time // expiry is judged at
+	fixable map[string][]string
+}
+
+func newVerifier() *verifier {
+	now := time.Now()
+	return &verifier{
+		report:  &types.VerifyReport{Issues: []types.Inconsistency{}},
+		start:   now,
+		now:     now,
+		fixable: make(map[string][]string),
+	}
+}
+
+// add records a problem found by check; key is "" for one no key owns.
+func (v *verifier) add(check, key string, repairable bool, format string, args ...interface{}) {
+	v.report.Found++
+	if repairable {
+		v.fixable[check] = append(v.fixable[check], key)
+	}
+	if len(v.report.Issues) < types.MaxVerifyIssues {
+		v.report.Issues = append(v.report.Issues, types.Inconsistency{
+			Check: check, Key: key, Detail: fmt.Sprintf(format, args...), Repairable: repairable,
+		})
+	}
+}
+
+// repaired marks every repairable problem check found repaired.
+func (v *verifier) repaired(check string) {
+	for i, issue := range v.report.Issues {
+		if issue.Check == check && issue.Repairable {
+			v.report.Issues[i].Repaired = true
+		}
+	}
+	v.report.Repaired += len(v.fixable[check])
+	delete(v.fixable, check)
+}
+
+// done returns the report, its problems in the order the checks ran and
+// by key within each.
+func (v *verifier) done() *types.VerifyReport {
+	slices.SortStableFunc(v.report.Issues, func(a, b types.Inconsistency) int {
+		if a.Check != b.Check {
+			return slices.Index(v.report.Checks, a.Check) - slices.Index(v.report.Checks, b.Check)
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	v.report.DurationMs = float64(time.Since(v.start).Microseconds()) / 1000
+	return v.report
+}
+
+// checkWAL checks every entry of the log against its checksum and the LSN
+// of the one before. Damage to the log is never repaired here: kvi wal
+// repair cuts it, losing the entries after.
+func (v *verifier) checkWAL(w *wal.WAL) error {
+	v.report.Checks = append(v.report.Checks, types.CheckWAL)
+	res, err := w.Verify()
+	if err != nil {
+		return err
+	}
+	v.report.WALEntries = res.Entries
+	for _, p := range res.Problems {
+		v.add(types.CheckWAL, "", false, "entry at offset %d: %s", p.Offset, p.Err)
+	}
+	return nil
+}
+
+// recordSet is the records an index is checked against, in an engine's
+// map or B-tree, expired or not. The caller holds the lock guarding them.
+type recordSet struct {
+	records map[string]*types.Record
+	tree    *btree.BTree
+}
+
+func (s recordSet) get(key string) *types.Record {
+	if s.tree == nil {
+		return s.records[key]
+	}
+	if item := s.tree.Get(btreeItem{key: key}); item != nil {
+		return item.(btreeItem).rec
+	}
+	return nil
+}
+
+func (s recordSet) each(fn func(key string, rec *types.Record)) {
+	if s.tree == nil {
+		for key, rec := range s.records {
+			fn(key, rec)
+		}
+		return
+	}
+	s.tree.Ascend(func(i btree.Item) bool {
+		item := i.(btreeItem)
+		fn(item.key, item.rec)
+		return true
+	})
+}
+
+func (s recordSet) len() int {
+	if s.tree == nil {
+		return len(s.records)
+	}
+	return s.tree.Len()
+}
+
+// checkTags checks x against records both ways, and its two maps against
+// each other. An expired record may or may not be indexed until it is
+// removed, so only live ones must be.
+func (v *verifier) checkTags(x *tagIndex, records recordSet) {
+	v.report.Checks = append(v.report.Checks, types.IndexTags)
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+
+	records.each(func(key string, rec *types.Record) {
+		if liveAt(rec, v.now) != nil && !slices.Equal(x.tags[key], rec.Tags) {
+			v.add(types.IndexTags, key, true, "indexed with tags %v, but the record has %v", x.tags[key], rec.Tags)
+		}
+	})
+	for key, tags := range x.tags {
+		if records.get(key) == nil {
+			v.add(types.IndexTags, key, true, "indexed with tags %v, but there is no record", tags)
+			continue
+		}
+		for _, tag := range tags {
+			if _, ok := x.keys[tag][key]; !ok {
+				v.add(types.IndexTags, key, true, "missing from the keys tagged %q", tag)
+			}
+		}
+	}
+	for tag, keys := range x.keys {
+		for key := range keys {
+			if !slices.Contains(x.tags[key], tag) {
+				v.add(types.IndexTags, key, true, "among the keys tagged %q, but not indexed with it", tag)
+			}
+		}
+	}
+}
+
+// checkVectors checks that every live record's vector is in index and
+// every vector in index has a record.
+func (v *verifier) checkVectors(index *vector.HNSWIndex, records recordSet) {
+	v.report.Checks = append(v.report.Checks, types.IndexVector)
+	records.each(func(key string, rec *types.Record) {
+		if liveAt(rec, v.now) == nil || len(rec.Vector) == 0 {
+			return
+		}
+		switch indexed, ok := index.Vector(key); {
+		case !ok:
+			v.add(types.IndexVector, key, true, "the record's vector is not indexed")
+		case !slices.Equal(indexed, rec.Vector):
+			v.add(types.IndexVector, key, true, "indexed with another vector than the record's")
+		}
+	})
+	index.Range(func(key string, _ []float32) bool {
+		if rec := records.get(key); rec == nil || len(rec.Vector) == 0 {
+			v.add(types.IndexVector, key, true, "indexed, but there is no record with a vector")
+		}
+		return true
+	})
+}
+
+// repairTags rebuilds the tag index if checkTags found it wrong.
+func (v *verifier) repairTags(ctx context.Context, r types.IndexRebuilder) error {
+	if len(v.fixable[types.IndexTags]) == 0 {
+		return nil
+	}
+	if err := r.RebuildIndexes(ctx, func(done, total int) {}, types.IndexTags); err != nil {
+		return err
+	}
+	v.repaired(types.IndexTags)
+	return nil
+}
+
+// Verify implements types.Verifier, checking the tag index under the read
+// lock.
+func (e *MemoryEngine) Verify(ctx context.Context, opts types.VerifyOptions) (*types.VerifyReport, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	e.indexing.flush()
+	v := newVerifier()
+	e.mu.RLock()
+	records := recordSet{records: e.records}
+	v.report.Records = records.len()
+	v.checkTags(e.feed.tags, records)
+	e.mu.RUnlock()
+
+	if opts.Repair {
+		if err := v.repairTags(ctx, e); err != nil {
+			return nil, err
+		}
+	}
+	return v.done(), nil
+}
+
+// Verify implements types.Verifier, checking the WAL and then the tag
+// index against the B-tree under the read lock.
+func (e *DiskEngine) Verify(ctx context.Context, opts types.VerifyOptions) (*types.VerifyReport, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	if err := e.recovered(); err != nil {
+		return nil, err // even if reads are served meanwhile
+	}
+	e.indexing.flush()
+	v := newVerifier()
+	if e.config.EnableWAL {
+		if err := v.checkWAL(e.wal); err != nil {
+			return nil, err
+		}
+	}
+	e.mu.RLock()
+	records := recordSet{tree: e.tree}
+	v.report.Records = records.len()
+	v.checkTags(e.feed.tags, records)
+	e.mu.RUnlock()
+
+	if opts.Repair {
+		if err := v.repairTags(ctx, e); err != nil {
+			return nil, err
+		}
+	}
+	return v.done(), nil
+}
+
+// Verify implements types.Verifier, checking the tag index under the read
+// lock.
+func (e *ColumnarEngine) Verify(ctx context.Context, opts types.VerifyOptions) (*types.VerifyReport, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	e.indexing.flush()
+	v := newVerifier()
+	e.mu.RLock()
+	records := recordSet{records: e.records}
+	v.report.Records = records.len()
+	v.checkTags(e.feed.tags, records)
+	e.mu.RUnlock()
+
+	if opts.Repair {
+		if err := v.repairTags(ctx, e); err != nil {
+			return nil, err
+		}
+	}
+	return v.done(), nil
+}
+
+// Verify implements types.Verifier, checking the tag index and the HNSW
+// graph under the read lock. The graph is repaired key by key.
+func (e *VectorEngine) Verify(ctx context.Context, opts types.VerifyOptions) (*types.VerifyReport, error) {
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	e.indexing.flush()
+	v := newVerifier()
+	e.mu.RLock()
+	records := recordSet{records: e.records}
+	v.report.Records = records.len()
+	v.checkTags(e.feed.tags, records)
+	v.checkVectors(e.index, records)
+	e.mu.RUnlock()
+
+	if !opts.Repair {
+		return v.done(), nil
+	}
+	if err := v.repairTags(ctx, e); err != nil {
+		return nil, err
+	}
+	if keys := v.fixable[types.IndexVector]; len(keys) > 0 {
+		e.mu.Lock()
+		err := e.reindexLocked(keys)
+		e.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		e.indexing.flush()
+		v.repaired(types.IndexVector)
+	}
+	return v.done(), nil
+}
+
+// reindexLocked indexes the vectors of keys' live records as they are now,
+// and drops the rest of keys from the index.
+func (e *VectorEngine) reindexLocked(keys []string) error {
+	for _, key := range keys {
+		if rec := live(e.records[key]); rec != nil && len(rec.Vector) > 0 {
+			if err := e.indexLocked(key, rec.Vector); err != nil {
+				return err
+			}
+		} else {
+			e.unindexLocked(key)
+		}
+	}
+	return nil
+}
+
+// Verify implements types.Verifier. It holds the write lock throughout:
+// once the queue has drained, disk has every record, and the memory tier,
+// the tag index and the HNSW graph, once loaded, are checked against it.
+// The memory tier is repaired by dropping the copies that differ from
+// disk, to be read again, and the graph by reindexing the keys found
+// wrong.
+func (h *HybridEngine) Verify(ctx context.Context, opts types.VerifyOptions) (*types.VerifyReport, error) {
+	if err := h.open(); err != nil {
+		return nil, err
+	}
+	v := newVerifier()
+	if h.disk.config.EnableWAL {
+		if err := v.checkWAL(h.disk.wal); err != nil {
+			return nil, err
+		}
+	}
+	h.mu.Lock()
+	if err := h.drainLocked(ctx); err != nil {
+		h.mu.Unlock()
+		return nil, err
+	}
+	h.indexing.flush()
+	h.disk.mu.RLock()
+	records := recordSet{tree: h.disk.tree}
+	v.report.Records = records.len()
+	v.checkMemory(h.memory, records)
+	v.checkTags(h.feed.tags, records)
+	if h.vload.ready() == nil { // else the graph is not all there yet
+		h.vectorStore.mu.RLock()
+		v.checkVectors(h.vectorStore.index, records)
+		h.vectorStore.mu.RUnlock()
+	}
+	h.disk.mu.RUnlock()
+
+	var err error
+	if opts.Repair {
+		err = h.repairLocked(v)
+	}
+	h.mu.Unlock()
+	if err == nil && opts.Repair {
+		err = v.repairTags(ctx, h)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.done(), nil
+}
+
+// checkMemory checks that each record the memory tier holds is the one
+// disk has.
+func (v *verifier) checkMemory(memory *MemoryEngine, disk recordSet) {
+	v.report.Checks = append(v.report.Checks, types.CheckMemory)
+	memory.mu.RLock()
+	defer memory.mu.RUnlock()
+
+	for key, rec := range memory.records {
+		switch stored := disk.get(key); {
+		case stored == nil:
+			v.add(types.CheckMemory, key, true, "memory holds version %d, but disk has no record", rec.Version)
+		case stored.Version != rec.Version || !stored.UpdatedAt.Equal(rec.UpdatedAt):
+			v.add(types.CheckMemory, key, true, "memory holds version %d of %s, but disk has version %d of %s",
+				rec.Version, rec.UpdatedAt.Format(time.RFC3339Nano), stored.Version, stored.UpdatedAt.Format(time.RFC3339Nano))
+		}
+	}
+}
+
+// repairLocked repairs what Verify found wrong with the memory tier and the
+// vector tier's graph, reading each key's record from disk again.
+func (h *HybridEngine) repairLocked(v *verifier) error {
+	if keys := v.fixable[types.CheckMemory]; len(keys) > 0 {
+		h.memory.mu.Lock()
+		for _, key := range keys {
+			delete(h.memory.records, key)
+		}
+		h.memory.mu.Unlock()
+		for _, key := range keys {
+			h.cache.remove(key)
+		}
+		v.repaired(types.CheckMemory)
+	}
+	if keys := v.fixable[types.IndexVector]; len(keys) > 0 {
+		stored := make(map[string]*types.Record, len(keys))
+		for _, key := range keys {
+			stored[key] = live(h.disk.stored(key))
+		}
+		vs := h.vectorStore
+		vs.mu.Lock()
+		defer vs.mu.Unlock()
+		for _, key := range keys {
+			if rec := stored[key]; rec != nil && len(rec.Vector) > 0 {
+				tier := *rec
+				if err := vs.indexLocked(key, tier.Vector); err != nil {
+					return err
+				}
+				vs.storeLocked(key, &tier)
+			} else {
+				vs.deleteLocked(key)
+				vs.unindexLocked(key)
+			}
+		}
+		v.repaired(types.IndexVector)
+	}
+	return nil
+}
+
+// verifyOnStart verifies eng as it opens, repairing what it can, and
+// fails if anything is left wrong. Each problem is logged.
+func verifyOnStart(log *slog.Logger, eng types.Engine) error {
+	report, err := eng.(types.Verifier).Verify(context.Background(), types.VerifyOptions{Repair: true})
+	if err != nil {
+		return fmt.Errorf("verify on start: %w", err)
+	}
+	for _, issue := range report.Issues {
+		log.Warn("verify: "+issue.Detail, "check", issue.Check, "key", issue.Key, "repaired", issue.Repaired)
+	}
+	log.Info("verified", "checks", report.Checks, "records", report.Records, "found", report.Found,
+		"repaired", report.Repaired, "duration_ms", report.DurationMs)
+	if !report.OK() {
+		return fmt.Errorf("verify on start: %d problems that cannot be repaired (kvi verify lists them)", report.Found-report.Repaired)
+	}
+	return nil
+}
+
+var (
+	_ types.Verifier = (*MemoryEngine)(nil)
+	_ types.Verifier = (*DiskEngine)(nil)
+	_ types.Verifier = (*ColumnarEngine)(nil)
+	_ types.Verifier = (*VectorEngine)(nil)
+	_ types.Verifier = (*HybridEngine)(nil)
+)
@@ -0,0 +1,57 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// stamp gives record the version following prev's and its timestamps,
+// keeping prev's CreatedAt unless prev has expired. A record that already
+// carries a newer version keeps it: the hybrid engine forwards records
+// stamped by its memory tier to the other tiers unchanged.
+func stamp(prev, record *types.Record) {
+	var v uint64
+	if prev != nil {
+		v = prev.Version
+	}
+	if record.Version > v {
+		return
+	}
+	record.Version = v + 1
+	record.UpdatedAt = time.Now().UTC()
+	record.CreatedAt = record.UpdatedAt
+	if prev := live(prev); prev != nil && !prev.CreatedAt.IsZero() {
+		record.CreatedAt = prev.CreatedAt
+	}
+}
+
+// checkVersion verifies the stored record cur is at the expected version,
+// where 0 expects no record at all.
+func checkVersion(key string, cur *types.Record, version uint64) error {
+	var v uint64
+	if cur != nil {
+		v = cur.Version
+	}
+	if v != version {
+		return &types.VersionMismatchError{Key: key, Expected: version, Current: v}
+	}
+	return nil
+}
+
+// live hides expired records: it returns rec, or nil once rec's TTL has
+// passed. Every read goes through it, or through liveAt. Versioning still
+// sees the expired record, so a key's versions never repeat.
+func live(rec *types.Record) *types.Record {
+	return liveAt(rec, time.Now())
+}
+
+// liveAt is live with expiry judged at now. A read of many records, such
+// as a scan or a search, judges them all at the instant it began, so a
+// record expiring while it runs is left out however late it is reached.
+func liveAt(rec *types.Record, now time.Time) *types.Record {
+	if rec != nil && rec.Expired(now) {
+		return nil
+	}
+	return rec
+}
@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// worker keeps the numbers of one background loop, which calls done at
+// the end of every cycle.
+type worker struct {
+	name     string
+	interval time.Duration
+	depth    func() int // the work waiting; nil if the worker has no queue
+
+	// stallAfter is how long the worker may go without finishing a cycle
+	// before it is stalled; 0 never
+	stallAfter time.Duration
+
+	started   time.Time
+	cycles    atomic.Uint64
+	processed atomic.Uint64
+	errors    atomic.Uint64
+	lastRun   atomic.Int64 // UnixNano at the end of the last cycle
+	lastErr   atomic.Pointer[string]
+	reported  bool // stalled, as the watchdog last logged it; its own
+}
+
+// done records the end of a cycle that did n items, passing err on.
+func (w *worker) done(n int, err error) error {
+	w.processed.Add(uint64(n))
+	if err != nil {
+		w.errors.Add(1)
+		msg := err.Error()
+		w.lastErr.Store(&msg)
+	}
+	w.lastRun.Store(time.Now().UnixNano())
+	w.cycles.Add(1)
+	return err
+}
+
+// stalled reports whether the worker has gone too long at now without
+// finishing a cycle, counting from its start before the first.
+func (w *worker) stalled(now time.Time) bool {
+	if w.stallAfter == 0 {
+		return false
+	}
+	since := w.started
+	if last := w.lastRun.Load(); last != 0 {
+		since = time.Unix(0, last)
+	}
+	return now.Sub(since) > w.stallAfter
+}
+
+func (w *worker) stats(now time.Time) types.WorkerStats {
+	s := types.WorkerStats{
+		Name:       w.name,
+		IntervalMs: w.interval.Milliseconds(),
+		Processed:  w.processed.Load(),
+		Cycles:     w.cycles.Load(),
+		Errors:     w.errors.Load(),
+		Stalled:    w.stalled(now),
+	}
+	if w.depth != nil {
+		s.QueueDepth = w.depth()
+	}
+	if last := w.lastRun.Load(); last != 0 {
+		s.LastRun = time.Unix(0, last).UTC()
+	}
+	if msg := w.lastErr.Load(); msg != nil {
+		s.LastError = *msg
+	}
+	return s
+}
+
+// workerSet is an engine's background workers.
+type workerSet struct {
+	mu      sync.Mutex
+	workers []*worker
+}
+
+// add registers a worker that finishes a cycle every interval while it
+// keeps up, stalled after stallIntervals of them (0 never).
+func (ws *workerSet) add(name string, interval time.Duration, stallIntervals int, depth func() int) *worker {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	w := &worker{name: name, interval: interval, depth: depth, stallAfter: interval * time.Duration(stallIntervals), started: time.Now()}
+	ws.workers = append(ws.workers, w)
+	return w
+}
+
+func (ws *workerSet) list() []*worker {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	return ws.workers
+}
+
+// stats describes every worker; nil if there are none.
+func (ws *workerSet) stats() []types.WorkerStats {
+	var all []types.WorkerStats
+	now := time.Now()
+	for _, w := range ws.list() {
+		all = append(all, w.stats(now))
+	}
+	return all
+}
+
+// check is the workers health check: it fails while any worker is
+// stalled.
+func (ws *workerSet) check(ctx context.Context) error {
+	var stalled []string
+	now := time.Now()
+	for _, w := range ws.list() {
+		if w.stalled(now) {
+			stalled = append(stalled, w.name)
+		}
+	}
+	if len(stalled) > 0 {
+		return fmt.Errorf("stalled: %s", strings.Join(stalled, ", "))
+	}
+	return nil
+}
+
+// Workers implements types.WorkerReporter.
+func (c *engineState) Workers() []types.WorkerStats { return c.workers.stats() }
+
+// HealthChecks implements types.HealthChecker for the engines whose only
+// check is their workers; the disk and hybrid engines add theirs to it.
+func (e *MemoryEngine) HealthChecks() map[string]func(context.Context) error {
+	return map[string]func(context.Context) error{"workers": e.workers.check}
+}
+
+func (e *ColumnarEngine) HealthChecks() map[string]func(context.Context) error {
+	return map[string]func(context.Context) error{"workers": e.workers.check}
+}
+
+func (e *VectorEngine) HealthChecks() map[string]func(context.Context) error {
+	return map[string]func(context.Context) error{"workers": e.workers.check}
+}
+
+// watch logs each worker that stalls, and again once it recovers, until
+// stop is closed. It looks as often as the most frequent worker cycles.
+func (ws *workerSet) watch(log *slog.Logger, stop <-chan struct{}) {
+	every := time.Duration(0)
+	for _, w := range ws.list() {
+		if w.stallAfter > 0 && (every == 0 || w.interval < every) {
+			every = w.interval
+		}
+	}
+	if every == 0 {
+		return
+	}
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			for _, w := range ws.list() {
+				stalled := w.stalled(now)
+				if stalled && !w.reported {
+					s := w.stats(now)
+					log.Warn("background worker stalled", "worker", w.name, "interval", w.interval,
+						"last_run", s.LastRun, "queue_depth", s.QueueDepth)
+				} else if !stalled && w.reported {
+					log.Info("background worker recovered", "worker", w.name)
+				}
+				w.reported = stalled
+			}
+		}
+	}
+}
+
+var (
+	_ types.WorkerReporter = (*MemoryEngine)(nil)
+	_ types.WorkerReporter = (*DiskEngine)(nil)
+	_ types.WorkerReporter = (*ColumnarEngine)(nil)
+	_ types.WorkerReporter = (*VectorEngine)(nil)
+	_ types.WorkerReporter = (*HybridEngine)(nil)
+
+	_ types.HealthChecker = (*MemoryEngine)(nil)
+	_ types.HealthChecker = (*DiskEngine)(nil)
+	_ types.HealthChecker = (*ColumnarEngine)(nil)
+	_ types.HealthChecker = (*VectorEngine)(nil)
+	_ types.HealthChecker = (*HybridEngine)(nil)
+)
@@ -0,0 +1,129 @@
+// Package format describes the versions of the files Kvi writes: the
+// write-ahead log and backup streams, which snapshots are too. Each file
+// records its format version and the oldest version a reader must know to
+// read it, so a binary too old for a file refuses it, naming the release it
+// needs, instead of misreading it.
+package format
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Version is one version of a format.
+type Version struct {
+	Version int    `json:"version"`
+	Release string `json:"release"` // the first kvi release that reads it
+	Note    string `json:"note"`
+}
+
+// Spec is a format as this binary knows it.
+type Spec struct {
+	Name     string    `json:"format"`
+	Versions []Version `json:"versions"` // oldest first; the last is the newest this binary reads
+}
+
+var (
+	// WAL is the write-ahead log. Logs written before version 2 have no
+	// header and still read as version 1.
+	WAL = Spec{Name: "kvi-wal", Versions: []Version{
+		{1, "1.0.0", "length-prefixed entries, no header"},
+		{2, "1.1.0", "header with magic bytes, format and reader versions"},
+	}}
+	// Backup is the backup stream, as files, scheduled backups and gRPC
+	// snapshots carry it. JSON backups are still written as version 1.
+	Backup = Spec{Name: "kvi-backup", Versions: []Version{
+		{1, "1.0.0", "gzip'd JSON lines"},
+		{2, "1.0.0", "records framed in the engine's codec"},
+	}}
+
+	// Specs lists every format, for kvi formats.
+	Specs = []Spec{WAL, Backup}
+)
+
+// Header is what a file records about its format.
+type Header struct {
+	Format  string `json:"format"`
+	Version int    `json:"version"`
+	// MinReader is the oldest format version whose readers can read the
+	// file: a version adding only what older readers may skip keeps the
+	// one before it. 0, as in files from before it was recorded, means
+	// Version.
+	MinReader int `json:"min_reader,omitempty"`
+	// MinRelease is the first kvi release reading MinReader, so a binary
+	// that cannot read the file can name the release it needs.
+	MinRelease string    `json:"min_release,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Reader returns MinReader, or Version if it is unset.
+func (h Header) Reader() int {
+	if h.MinReader > 0 {
+		return h.MinReader
+	}
+	return h.Version
+}
+
+// ErrTooNew matches, with errors.Is, the *TooNewError of a file written in
+// a format version this binary cannot read.
+var ErrTooNew = errors.New("format version too new")
+
+// TooNewError is a file this binary is too old to read.
+type TooNewError struct {
+	Header
+	Supported int // the newest version this binary reads
+}
+
+func (e *TooNewError) Error() string {
+	need := "a newer kvi"
+	if e.MinRelease != "" {
+		need = "kvi " + e.MinRelease + " or later"
+	}
+	return fmt.Sprintf("%s format version %d needs %s to read; this binary reads up to version %d",
+		e.Format, e.Version, need, e.Supported)
+}
+
+func (e *TooNewError) Is(target error) bool { return target == ErrTooNew }
+
+// Latest is the newest version of the format, which this binary writes.
+func (s Spec) Latest() Version {
+	return s.Versions[len(s.Versions)-1]
+}
+
+// Release returns the first release reading version, "" for one newer
+// than this binary knows.
+func (s Spec) Release(version int) string {
+	for _, v := range s.Versions {
+		if v.Version == version {
+			return v.Release
+		}
+	}
+	return ""
+}
+
+// Stamp returns the header for a file written now in version, which only
+// readers of version itself can read.
+func (s Spec) Stamp(version int) Header {
+	return Header{Format: s.Name, Version: version, MinReader: version, MinRelease: s.Release(version),
+		CreatedAt: time.Now().UTC()}
+}
+
+// Check returns a *TooNewError if h is of a version this binary cannot
+// read. A newer version whose MinReader this binary knows is read as that.
+func (s Spec) Check(h Header) error {
+	if latest := s.Latest().Version; h.Reader() > latest {
+		return &TooNewError{Header: h, Supported: latest}
+	}
+	return nil
+}
+
+// Lookup returns the format named name.
+func Lookup(name string) (Spec, bool) {
+	for _, s := range Specs {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Spec{}, false
+}
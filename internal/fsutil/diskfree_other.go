@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package fsutil
+
+import "errors"
+
+// FreeBytes is not implemented on this platform.
+func FreeBytes(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
@@ -0,0 +1,2 @@
+// Package fsutil holds small file-system helpers with per-OS implementations.
+package fsutil
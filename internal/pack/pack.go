@@ -0,0 +1,61 @@
+// Package pack compresses record payloads with zstd, for the engines that
+// keep records compressed in memory and for the WAL. Every caller shares
+// one encoder and one decoder, which are safe for concurrent use.
+package pack
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+var (
+	encoder = sync.OnceValue(func() *zstd.Encoder {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest)) // only fails on bad options
+		return enc
+	})
+	decoder = sync.OnceValue(func() *zstd.Decoder {
+		dec, _ := zstd.NewReader(nil)
+		return dec
+	})
+)
+
+// Encode returns src compressed, or nil if that does not make it smaller.
+func Encode(src []byte) []byte {
+	if dst := encoder().EncodeAll(src, nil); len(dst) < len(src) {
+		return dst
+	}
+	return nil
+}
+
+// Decode returns what Encode compressed.
+func Decode(src []byte) ([]byte, error) {
+	return decoder().DecodeAll(src, nil)
+}
+
+// Counter counts the payloads compressed and their bytes before and after.
+type Counter struct {
+	records     atomic.Uint64
+	rawBytes    atomic.Int64
+	packedBytes atomic.Int64
+}
+
+// Add counts a payload of raw bytes compressed to packed.
+func (c *Counter) Add(raw, packed int) {
+	c.records.Add(1)
+	c.rawBytes.Add(int64(raw))
+	c.packedBytes.Add(int64(packed))
+}
+
+// Stats reports the counts so far.
+func (c *Counter) Stats() *types.CompressionStats {
+	raw, packed := c.rawBytes.Load(), c.packedBytes.Load()
+	return &types.CompressionStats{
+		Records:     c.records.Load(),
+		RawBytes:    raw,
+		PackedBytes: packed,
+		SavedBytes:  raw - packed,
+	}
+}
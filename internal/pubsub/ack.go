@@ -0,0 +1,195 @@
+package pubsub
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// ChannelMode selects the delivery semantics of a channel.
+type ChannelMode string
+
+const (
+	// ModeFireAndForget broadcasts every message to every subscriber once.
+	ModeFireAndForget ChannelMode = "fire"
+	// ModeAck delivers every message to a single subscriber and redelivers it
+	// when it is not acknowledged within the channel's visibility timeout.
+	ModeAck ChannelMode = "ack"
+)
+
+// DefaultVisibilityTimeout is how long an ack-mode delivery may stay unacked
+// before it is handed out again.
+const DefaultVisibilityTimeout = 30 * time.Second
+
+// ChannelOptions configures a channel via Hub.ConfigureChannel.
+type ChannelOptions struct {
+	Mode              ChannelMode
+	VisibilityTimeout time.Duration
+}
+
+type inflightMessage struct {
+	msg   Message
+	sub   *Subscriber
+	timer Timer
+}
+
+// ConfigureChannel creates the channel if needed and applies opts to it.
+// Switching a channel out of ModeAck drops its in-flight and pending messages.
+func (h *Hub) ConfigureChannel(channelName string, opts ChannelOptions) {
+	ch := h.getOrCreateChannel(channelName)
+
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if opts.Mode == "" {
+		opts.Mode = ModeFireAndForget
+	}
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = DefaultVisibilityTimeout
+	}
+	if ch.mode == ModeAck && opts.Mode != ModeAck {
+		ch.dropInflightLocked()
+	}
+	ch.mode = opts.Mode
+	ch.visibilityTimeout = opts.VisibilityTimeout
+}
+
+// Ack acknowledges an ack-mode delivery on the named channel. It returns false
+// when the token is unknown, e.g. because it was already acked or redelivered.
+func (h *Hub) Ack(channelName, token string) bool {
+	h.mu.RLock()
+	ch, exists := h.channels[channelName]
+	h.mu.RUnlock()
+
+	if !exists {
+		return false
+	}
+	return ch.ack(token)
+}
+
+func (ch *Channel) ack(token string) bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	in, ok := ch.inflight[token]
+	if !ok {
+		return false
+	}
+	in.timer.Stop()
+	in.sub.inflight--
+	delete(ch.inflight, token)
+	ch.flushPendingLocked()
+	return true
+}
+
+// dispatchLocked hands msg to the least-loaded active subscriber and starts its
+// visibility timer. It returns false when no subscriber could take it.
+func (ch *Channel) dispatchLocked(msg Message) bool {
+	tried := make(map[*Subscriber]bool)
+	for {
+		sub := ch.pickLocked(tried)
+		if sub == nil {
+			return false
+		}
+		tried[sub] = true
+
+		msg.AckToken = newAckToken()
+		if !sub.offer(msg) {
+			continue // buffer full or closed; try the next candidate
+		}
+
+		sub.inflight++
+		token := msg.AckToken
+		if ch.inflight == nil {
+			ch.inflight = make(map[string]*inflightMessage)
+		}
+		ch.inflight[token] = &inflightMessage{
+			msg:   msg,
+			sub:   sub,
+			timer: ch.clock.AfterFunc(ch.visibilityTimeout, func() { ch.expire(token) }),
+		}
+		return true
+	}
+}
+
+// pickLocked returns the subscriber with the fewest unacked deliveries,
+// breaking ties by ID so the choice is deterministic.
+func (ch *Channel) pickLocked(skip map[*Subscriber]bool) *Subscriber {
+	var best *Subscriber
+	for _, sub := range ch.Subs {
+		if skip[sub] {
+			continue
+		}
+		if best == nil || sub.inflight < best.inflight ||
+			(sub.inflight == best.inflight && sub.ID < best.ID) {
+			best = sub
+		}
+	}
+	return best
+}
+
+// flushPendingLocked retries messages that previously found no subscriber,
+// preserving their order.
+func (ch *Channel) flushPendingLocked() {
+	now := ch.clock.Now()
+	for len(ch.pending) > 0 {
+		if ch.pending[0].Expired(now) {
+			ch.pending = ch.pending[1:]
+			continue
+		}
+		if !ch.dispatchLocked(ch.pending[0]) {
+			return
+		}
+		ch.pending = ch.pending[1:]
+	}
+}
+
+// expire redelivers a message whose visibility timeout elapsed without an ack.
+func (ch *Channel) expire(token string) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	in, ok := ch.inflight[token]
+	if !ok {
+		return
+	}
+	delete(ch.inflight, token)
+	in.sub.inflight--
+	ch.redelivered++
+
+	msg := in.msg
+	msg.AckToken = ""
+	ch.pending = append([]Message{msg}, ch.pending...)
+	ch.flushPendingLocked()
+}
+
+func (ch *Channel) dropInflightLocked() {
+	for token, in := range ch.inflight {
+		in.timer.Stop()
+		in.sub.inflight--
+		delete(ch.inflight, token)
+	}
+	ch.pending = nil
+}
+
+// offer performs a non-blocking send to an active subscriber.
+func (s *Subscriber) offer(msg Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.Active {
+		return false
+	}
+	select {
+	case s.C <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+func newAckToken() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,99 @@
+package pubsub
+
+import "sort"
+
+// consumerGroup load-balances a channel across its members. The cursor is the
+// ID of the last message handed to the group; it survives members leaving, so
+// a group that reconnects resumes from the retained history.
+type consumerGroup struct {
+	name    string
+	members []*Subscriber
+	next    int
+	cursor  uint64
+}
+
+// GroupInfo is a point-in-time summary of a consumer group.
+type GroupInfo struct {
+	Name    string `json:"name"`
+	Members int    `json:"members"`
+	Cursor  uint64 `json:"cursor"`
+	Lag     uint64 `json:"lag"`
+}
+
+func (ch *Channel) joinGroupLocked(sub *Subscriber) *consumerGroup {
+	if ch.groups == nil {
+		ch.groups = make(map[string]*consumerGroup)
+	}
+	g, exists := ch.groups[sub.group]
+	if !exists {
+		// A new group starts at the head; it has not missed anything yet.
+		g = &consumerGroup{name: sub.group, cursor: ch.lastID}
+		ch.groups[sub.group] = g
+	}
+	g.members = append(g.members, sub)
+	return g
+}
+
+func (ch *Channel) leaveGroupLocked(sub *Subscriber) {
+	g, exists := ch.groups[sub.group]
+	if !exists {
+		return
+	}
+	for i, m := range g.members {
+		if m == sub {
+			g.members = append(g.members[:i], g.members[i+1:]...)
+			break
+		}
+	}
+}
+
+// catchUpLocked delivers every retained message after the group's cursor,
+// round-robin across members, and returns how many were delivered. It stops
+// at the first message no member can accept so nothing is skipped.
+func (g *consumerGroup) catchUpLocked(ch *Channel) int {
+	delivered := 0
+	now := ch.clock.Now()
+	for _, msg := range ch.History {
+		if msg.ID <= g.cursor {
+			continue
+		}
+		if msg.Expired(now) {
+			g.cursor = msg.ID // expired messages are not replayed
+			continue
+		}
+		if !g.offer(msg) {
+			break
+		}
+		g.cursor = msg.ID
+		delivered++
+	}
+	return delivered
+}
+
+func (g *consumerGroup) offer(msg Message) bool {
+	for i := 0; i < len(g.members); i++ {
+		sub := g.members[(g.next+i)%len(g.members)]
+		if sub.offer(msg) {
+			g.next = (g.next + i + 1) % len(g.members)
+			return true
+		}
+	}
+	return false
+}
+
+func (ch *Channel) groupInfosLocked() []GroupInfo {
+	if len(ch.groups) == 0 {
+		return nil
+	}
+	infos := make([]GroupInfo, 0, len(ch.groups))
+	for _, g := range ch.groups {
+		infos = append(infos, GroupInfo{
+			Name:    g.name,
+			Members: len(g.members),
+			Cursor:  g.cursor,
+			Lag:     ch.lastID - g.cursor,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
@@ -1,14 +1,27 @@
 package pubsub
 
 import (
+	"log/slog"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Message struct {
-	Channel string
-	Payload string
+	ID          uint64    `json:"id"`
+	Channel     string    `json:"channel"`
+	Payload     string    `json:"payload"`
+	AckToken    string    `json:"ack_token,omitempty"`
+	ExpiresAt   time.Time `json:"expires_at,omitzero"`
+	ContentType string    `json:"content_type,omitempty"`
+}
+
+// Expired reports whether the message carries a TTL that has elapsed at now.
+func (m Message) Expired(now time.Time) bool {
+	return !m.ExpiresAt.IsZero() && !now.Before(m.ExpiresAt)
 }
 
 type Subscriber struct {
@@ -17,6 +30,10 @@ type Subscriber struct {
 	Patterns []string
 	Active   bool
 	mu       sync.Mutex
+
+	channel  *Channel
+	group    string
+	inflight int // unacked deliveries, guarded by channel.mu
 }
 
 func NewSubscriber(id string) *Subscriber {
@@ -32,23 +49,133 @@ func (s *Subscriber) Receive() (Message, bool) {
 	return msg, ok
 }
 
+// close deactivates the subscriber and closes C. It is safe to call more than
+// once, so Unsubscribe and DeleteChannel can race without double-closing.
+func (s *Subscriber) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.Active {
+		return
+	}
+	s.Active = false
+	close(s.C)
+}
+
+// Unsubscribe removes this subscriber from its channel and closes C. Unlike
+// Hub.Unsubscribe it leaves alone a newer subscriber that reused the same ID.
+func (s *Subscriber) Unsubscribe() {
+	if ch := s.channel; ch != nil {
+		ch.mu.Lock()
+		if ch.Subs[s.ID] == s {
+			delete(ch.Subs, s.ID)
+			ch.leaveGroupLocked(s)
+		}
+		ch.mu.Unlock()
+	}
+	s.close()
+}
+
+// Ack reports the message identified by token as processed. It only applies to
+// messages received from a channel in ModeAck.
+func (s *Subscriber) Ack(token string) bool {
+	if s.channel == nil {
+		return false
+	}
+	return s.channel.ack(token)
+}
+
 type Channel struct {
 	Name      string
 	Subs      map[string]*Subscriber
 	History   []Message
 	Retention int
+	Published uint64
 	mu        sync.RWMutex
+
+	mode              ChannelMode
+	visibilityTimeout time.Duration
+	lastID            uint64
+	pending           []Message
+	inflight          map[string]*inflightMessage
+	redelivered       uint64
+	dropped           atomic.Uint64 // deliveries skipped because a subscriber's buffer was full
+	groups            map[string]*consumerGroup
+	scheduled         map[*scheduledMessage]struct{}
+	clock             Clock
+}
+
+// ChannelInfo is a point-in-time summary of a channel.
+type ChannelInfo struct {
+	Name        string      `json:"name"`
+	Mode        ChannelMode `json:"mode"`
+	Subscribers int         `json:"subscribers"`
+	HistoryLen  int         `json:"history_len"`
+	Retention   int         `json:"retention"`
+	Published   uint64      `json:"published"`
+	InFlight    int         `json:"in_flight"`
+	Pending     int         `json:"pending"`
+	Redelivered uint64      `json:"redelivered"`
+	Scheduled   int         `json:"scheduled"`
+	Groups      []GroupInfo `json:"groups,omitempty"`
+}
+
+// HubStats aggregates counters across all channels of a hub.
+type HubStats struct {
+	Channels    int                    `json:"channels"`
+	Subscribers int                    `json:"subscribers"`
+	Published   uint64                 `json:"published"`
+	InFlight    int                    `json:"in_flight"`
+	Redelivered uint64                 `json:"redelivered"`
+	Dropped     uint64                 `json:"dropped"` // deliveries lost to full subscriber buffers
+	AckChannels map[string]AckCounters `json:"ack_channels,omitempty"`
+}
+
+// AckCounters are the delivery counters of a single ModeAck channel.
+type AckCounters struct {
+	InFlight    int    `json:"in_flight"`
+	Pending     int    `json:"pending"`
+	Redelivered uint64 `json:"redelivered"`
 }
 
 type Hub struct {
 	channels map[string]*Channel
 	mu       sync.RWMutex
+
+	clock         Clock
+	sweepInterval time.Duration
+	sweepMu       sync.Mutex
+	sweepArmed    bool
+	log           *slog.Logger
 }
 
-func NewHub() *Hub {
-	return &Hub{
-		channels: make(map[string]*Channel),
+func NewHub(opts ...func(*Hub)) *Hub {
+	h := &Hub{
+		channels:      make(map[string]*Channel),
+		clock:         realClock{},
+		sweepInterval: DefaultSweepInterval,
+		log:           slog.Default(),
+	}
+	for _, o := range opts {
+		o(h)
 	}
+	return h
+}
+
+// WithClock replaces the wall clock used for TTLs, scheduled delivery and ack
+// timeouts. Intended for tests.
+func WithClock(c Clock) func(*Hub) {
+	return func(h *Hub) { h.clock = c }
+}
+
+// WithSweepInterval sets how often expired messages are pruned from history.
+func WithSweepInterval(d time.Duration) func(*Hub) {
+	return func(h *Hub) { h.sweepInterval = d }
+}
+
+// WithLogger sets where the hub logs; it logs dropped deliveries at debug.
+func WithLogger(l *slog.Logger) func(*Hub) {
+	return func(h *Hub) { h.log = l }
 }
 
 func (h *Hub) getOrCreateChannel(name string) *Channel {
@@ -60,36 +187,85 @@ func (h *Hub) getOrCreateChannel(name string) *Channel {
 	}
 
 	ch := &Channel{
-		Name:      name,
-		Subs:      make(map[string]*Subscriber),
-		Retention: 100, // keep last 100 messages
+		Name:              name,
+		Subs:              make(map[string]*Subscriber),
+		Retention:         100, // keep last 100 messages
+		mode:              ModeFireAndForget,
+		visibilityTimeout: DefaultVisibilityTimeout,
+		clock:             h.clock,
 	}
 	h.channels[name] = ch
 	return ch
 }
 
-func (h *Hub) Publish(channelName, payload string) int {
+// Publish sends payload to a channel and returns the number of subscribers it
+// was handed to. Messages scheduled for later delivery report 0 receivers.
+func (h *Hub) Publish(channelName, payload string, opts ...PublishOption) int {
+	var po publishOptions
+	for _, o := range opts {
+		o(&po)
+	}
+
 	ch := h.getOrCreateChannel(channelName)
-	msg := Message{Channel: channelName, Payload: payload}
+	msg := Message{Channel: channelName, Payload: payload, ContentType: po.contentType}
+	if msg.ContentType == "" {
+		msg.ContentType = ContentTypeText
+	}
+
+	if po.deliverAt.After(h.clock.Now()) {
+		h.schedule(ch, msg, po)
+		return 0
+	}
+	return h.deliver(ch, msg, po.ttl)
+}
+
+// deliver assigns the message its ID, records it in history and hands it to
+// subscribers. A positive ttl starts counting from now.
+func (h *Hub) deliver(ch *Channel, msg Message, ttl time.Duration) int {
+	channelName := ch.Name
+	if ttl > 0 {
+		msg.ExpiresAt = h.clock.Now().Add(ttl)
+		h.armSweep()
+	}
 
 	ch.mu.Lock()
+	ch.Published++
+	ch.lastID++
+	msg.ID = ch.lastID
 	ch.History = append(ch.History, msg)
 	if len(ch.History) > ch.Retention {
 		ch.History = ch.History[1:]
 	}
 
 	count := 0
-	for _, sub := range ch.Subs {
-		sub.mu.Lock()
-		if sub.Active {
-			select {
-			case sub.C <- msg:
-				count++
-			default:
-				// buffer full, skip or handle
+	if ch.mode == ModeAck {
+		// Work-queue semantics: one subscriber per message, tracked until acked
+		ch.flushPendingLocked()
+		if len(ch.pending) == 0 && ch.dispatchLocked(msg) {
+			count++
+		} else {
+			ch.pending = append(ch.pending, msg)
+		}
+	} else {
+		for _, g := range ch.groups {
+			count += g.catchUpLocked(ch)
+		}
+		for _, sub := range ch.Subs {
+			if sub.group != "" {
+				continue // grouped subscribers are served by their group
+			}
+			sub.mu.Lock()
+			if sub.Active {
+				select {
+				case sub.C <- msg:
+					count++
+				default:
+					ch.dropped.Add(1) // buffer full
+					h.log.Debug("delivery dropped: subscriber buffer full", "channel", channelName, "subscriber", sub.ID, "message_id", msg.ID)
+				}
 			}
+			sub.mu.Unlock()
 		}
-		sub.mu.Unlock()
 	}
 	ch.mu.Unlock()
 
@@ -108,6 +284,8 @@ func (h *Hub) Publish(channelName, payload string) int {
 						case sub.C <- msg:
 							count++
 						default:
+							patternCh.dropped.Add(1)
+							h.log.Debug("delivery dropped: subscriber buffer full", "channel", channelName, "pattern", name, "subscriber", sub.ID, "message_id", msg.ID)
 						}
 					}
 					sub.mu.Unlock()
@@ -121,13 +299,32 @@ func (h *Hub) Publish(channelName, payload string) int {
 }
 
 func (h *Hub) Subscribe(channelName, subscriberID string) *Subscriber {
+	return h.SubscribeGroup(channelName, "", subscriberID)
+}
+
+// SubscribeGroup subscribes as a member of a consumer group: each message is
+// delivered to only one member of the group. An empty group behaves like
+// Subscribe. Ack-mode channels already deliver each message once, so the
+// group is only recorded there.
+func (h *Hub) SubscribeGroup(channelName, group, subscriberID string) *Subscriber {
 	ch := h.getOrCreateChannel(channelName)
 
 	ch.mu.Lock()
 	defer ch.mu.Unlock()
 
+	if old, exists := ch.Subs[subscriberID]; exists {
+		ch.leaveGroupLocked(old)
+	}
+
 	sub := NewSubscriber(subscriberID)
+	sub.channel = ch
+	sub.group = group
 	ch.Subs[subscriberID] = sub
+	if ch.mode == ModeAck {
+		ch.flushPendingLocked()
+	} else if group != "" {
+		ch.joinGroupLocked(sub).catchUpLocked(ch)
+	}
 	return sub
 }
 
@@ -146,13 +343,124 @@ func (h *Hub) Unsubscribe(channelName, subscriberID string) {
 	}
 
 	ch.mu.Lock()
-	defer ch.mu.Unlock()
-
-	if sub, exists := ch.Subs[subscriberID]; exists {
-		sub.mu.Lock()
-		sub.Active = false
-		close(sub.C)
-		sub.mu.Unlock()
+	sub, exists := ch.Subs[subscriberID]
+	if exists {
 		delete(ch.Subs, subscriberID)
+		ch.leaveGroupLocked(sub)
+	}
+	ch.mu.Unlock()
+
+	if exists {
+		sub.close()
+	}
+}
+
+// ListChannels returns a summary of every channel, sorted by name.
+func (h *Hub) ListChannels() []ChannelInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	infos := make([]ChannelInfo, 0, len(h.channels))
+	for _, ch := range h.channels {
+		ch.mu.RLock()
+		infos = append(infos, ChannelInfo{
+			Name:        ch.Name,
+			Mode:        ch.mode,
+			Subscribers: len(ch.Subs),
+			HistoryLen:  len(ch.History),
+			Retention:   ch.Retention,
+			Published:   ch.Published,
+			InFlight:    len(ch.inflight),
+			Pending:     len(ch.pending),
+			Redelivered: ch.redelivered,
+			Scheduled:   len(ch.scheduled),
+			Groups:      ch.groupInfosLocked(),
+		})
+		ch.mu.RUnlock()
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// History returns up to limit of the most recent retained messages on a
+// channel, oldest first. A limit <= 0 returns the whole retained history.
+func (h *Hub) History(channelName string, limit int) ([]Message, bool) {
+	h.mu.RLock()
+	ch, exists := h.channels[channelName]
+	h.mu.RUnlock()
+
+	if !exists {
+		return nil, false
+	}
+
+	ch.mu.RLock()
+	defer ch.mu.RUnlock()
+
+	now := ch.clock.Now()
+	out := make([]Message, 0, len(ch.History))
+	for _, msg := range ch.History {
+		if !msg.Expired(now) {
+			out = append(out, msg)
+		}
+	}
+	if limit > 0 && limit < len(out) {
+		out = out[len(out)-limit:]
+	}
+	return out, true
+}
+
+// DeleteChannel removes a channel and closes all of its subscribers.
+// It reports whether the channel existed.
+func (h *Hub) DeleteChannel(channelName string) bool {
+	h.mu.Lock()
+	ch, exists := h.channels[channelName]
+	if exists {
+		delete(h.channels, channelName)
+	}
+	h.mu.Unlock()
+
+	if !exists {
+		return false
+	}
+
+	ch.mu.Lock()
+	subs := ch.Subs
+	ch.Subs = make(map[string]*Subscriber)
+	ch.dropInflightLocked()
+	ch.dropScheduledLocked()
+	ch.mu.Unlock()
+
+	for _, sub := range subs {
+		sub.close()
+	}
+	return true
+}
+
+// Stats returns hub-wide counters.
+func (h *Hub) Stats() HubStats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	stats := HubStats{Channels: len(h.channels)}
+	for _, ch := range h.channels {
+		ch.mu.RLock()
+		stats.Subscribers += len(ch.Subs)
+		stats.Published += ch.Published
+		stats.Dropped += ch.dropped.Load()
+		if ch.mode == ModeAck {
+			if stats.AckChannels == nil {
+				stats.AckChannels = make(map[string]AckCounters)
+			}
+			stats.AckChannels[ch.Name] = AckCounters{
+				InFlight:    len(ch.inflight),
+				Pending:     len(ch.pending),
+				Redelivered: ch.redelivered,
+			}
+			stats.InFlight += len(ch.inflight)
+			stats.Redelivered += ch.redelivered
+		}
+		ch.mu.RUnlock()
 	}
+	return stats
 }
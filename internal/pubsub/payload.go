@@ -0,0 +1,39 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Content types recorded on messages so HTTP and gRPC consumers can decode
+// payloads published by either transport.
+const (
+	ContentTypeText   = "text/plain"
+	ContentTypeJSON   = "application/json"
+	ContentTypeBinary = "application/octet-stream"
+)
+
+// WithContentType records how the payload is encoded. Defaults to text/plain.
+func WithContentType(contentType string) PublishOption {
+	return func(o *publishOptions) { o.contentType = contentType }
+}
+
+// EncodePayload turns an arbitrary value into a message payload: strings are
+// sent as text, []byte is passed through untouched and anything else is
+// JSON-encoded.
+func EncodePayload(v interface{}) (payload string, contentType string, err error) {
+	switch p := v.(type) {
+	case string:
+		return p, ContentTypeText, nil
+	case []byte:
+		return string(p), ContentTypeBinary, nil
+	case json.RawMessage:
+		return string(p), ContentTypeJSON, nil
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", "", fmt.Errorf("cannot encode payload: %w", err)
+		}
+		return string(data), ContentTypeJSON, nil
+	}
+}
@@ -0,0 +1,105 @@
+package pubsub
+
+import "time"
+
+// DefaultSweepInterval is how often expired messages are pruned from history.
+const DefaultSweepInterval = 5 * time.Second
+
+// PublishOption customises a single Publish call.
+type PublishOption func(*publishOptions)
+
+type publishOptions struct {
+	ttl         time.Duration
+	deliverAt   time.Time
+	contentType string
+}
+
+// WithTTL expires the message ttl after it is delivered: it is no longer
+// replayed from history and is pruned by the background sweep.
+func WithTTL(ttl time.Duration) PublishOption {
+	return func(o *publishOptions) { o.ttl = ttl }
+}
+
+// WithDeliverAt holds the message until t before it enters the delivery path.
+// Times in the past deliver immediately.
+func WithDeliverAt(t time.Time) PublishOption {
+	return func(o *publishOptions) { o.deliverAt = t }
+}
+
+type scheduledMessage struct {
+	msg   Message
+	timer Timer
+}
+
+func (h *Hub) schedule(ch *Channel, msg Message, po publishOptions) {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+
+	if ch.scheduled == nil {
+		ch.scheduled = make(map[*scheduledMessage]struct{})
+	}
+	sm := &scheduledMessage{msg: msg}
+	sm.timer = h.clock.AfterFunc(po.deliverAt.Sub(h.clock.Now()), func() {
+		ch.mu.Lock()
+		_, pending := ch.scheduled[sm]
+		delete(ch.scheduled, sm)
+		ch.mu.Unlock()
+
+		if pending {
+			h.deliver(ch, sm.msg, po.ttl)
+		}
+	})
+	ch.scheduled[sm] = struct{}{}
+}
+
+func (ch *Channel) dropScheduledLocked() {
+	for sm := range ch.scheduled {
+		sm.timer.Stop()
+		delete(ch.scheduled, sm)
+	}
+}
+
+// armSweep schedules a history sweep unless one is already pending.
+func (h *Hub) armSweep() {
+	h.sweepMu.Lock()
+	defer h.sweepMu.Unlock()
+
+	if h.sweepArmed {
+		return
+	}
+	h.sweepArmed = true
+	h.clock.AfterFunc(h.sweepInterval, h.sweep)
+}
+
+// sweep prunes expired messages from every channel's history and re-arms
+// itself while messages with a TTL remain.
+func (h *Hub) sweep() {
+	h.sweepMu.Lock()
+	h.sweepArmed = false
+	h.sweepMu.Unlock()
+
+	now := h.clock.Now()
+	remaining := false
+
+	h.mu.RLock()
+	for _, ch := range h.channels {
+		ch.mu.Lock()
+		kept := ch.History[:0]
+		for _, msg := range ch.History {
+			if msg.Expired(now) {
+				continue
+			}
+			if !msg.ExpiresAt.IsZero() {
+				remaining = true
+			}
+			kept = append(kept, msg)
+		}
+		ch.History = kept
+		ch.mu.Unlock()
+	}
+	h.mu.RUnlock()
+
+	if remaining {
+		h.armSweep()
+	}
+}
@@ -0,0 +1,287 @@
+// Package replication makes a server a read-only follower of a primary:
+// it loads a snapshot of the primary's records, then applies the changes
+// the primary ships from its WAL, in LSN order, as they are logged.
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/pkg/client"
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// DefaultRetryInterval is how long a follower waits before reconnecting
+// after its stream from the primary fails.
+const DefaultRetryInterval = time.Second
+
+// Follower states, as ReplicationStats reports them.
+const (
+	StateSyncing      = "syncing"      // loading a snapshot
+	StateStreaming    = "streaming"    // applying the primary's WAL
+	StateDisconnected = "disconnected" // waiting to reconnect
+	StatePromoted     = "promoted"     // a primary itself now
+)
+
+// ErrPromoted is returned by Promote once the follower has been promoted.
+var ErrPromoted = errors.New("replica already promoted")
+
+// Follower keeps an engine a copy of a primary's. The engine is read-only
+// until Promote; the follower's position is kept in memory only, so a
+// follower that restarts syncs from a snapshot again.
+type Follower struct {
+	engine  types.Engine
+	replica types.Replica
+	client  *client.Client
+	primary string
+	retry   time.Duration
+	log     *slog.Logger
+
+	mu          sync.Mutex
+	state       string
+	synced      bool   // applied is a position in the primary's log
+	applied     uint64 // LSN of the last change applied
+	primaryLSN  uint64 // the primary's last LSN, as of its last message
+	caughtUp    time.Time
+	lastContact time.Time
+	syncs       int
+	lastErr     error
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New returns a follower that copies the primary c talks to, named
+// primary in stats, into eng, and makes eng read-only. Start it to begin.
+func New(eng types.Engine, c *client.Client, primary string, opts ...func(*Follower)) (*Follower, error) {
+	replica, ok := eng.(types.Replica)
+	if !ok {
+		return nil, fmt.Errorf("%w: this engine cannot follow a primary", errors.ErrUnsupported)
+	}
+	f := &Follower{
+		engine:  eng,
+		replica: replica,
+		client:  c,
+		primary: primary,
+		retry:   DefaultRetryInterval,
+		log:     slog.Default(),
+		state:   StateDisconnected,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	replica.SetReadOnly(true)
+	return f, nil
+}
+
+// WithLogger sets where the follower logs syncs and failures.
+func WithLogger(l *slog.Logger) func(*Follower) {
+	return func(f *Follower) { f.log = l }
+}
+
+// WithRetryInterval sets how long the follower waits before reconnecting.
+func WithRetryInterval(d time.Duration) func(*Follower) {
+	return func(f *Follower) { f.retry = d }
+}
+
+// Start syncs from the primary and follows it in the background until
+// Stop or Promote.
+func (f *Follower) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel, f.done = cancel, make(chan struct{})
+	go f.run(ctx)
+}
+
+// Stop stops following, leaving the engine read-only. It returns once the
+// change being applied, if any, is done.
+func (f *Follower) Stop() {
+	if f.cancel != nil {
+		f.cancel()
+		<-f.done
+	}
+}
+
+// Promote stops following and makes the engine writable, turning this
+// server into a primary. Changes the old primary logged but had not
+// shipped are not here.
+func (f *Follower) Promote() error {
+	f.mu.Lock()
+	promoted := f.state == StatePromoted
+	f.mu.Unlock()
+	if promoted {
+		return ErrPromoted
+	}
+	f.Stop()
+	f.replica.SetReadOnly(false)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.state = StatePromoted
+	f.log.Warn("replica promoted; now accepting writes", "primary", f.primary, "applied_lsn", f.applied)
+	return nil
+}
+
+// Stats reports the follower's position.
+func (f *Follower) Stats() *stats.ReplicationStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	st := &stats.ReplicationStats{
+		Primary:     f.primary,
+		State:       f.state,
+		AppliedLSN:  f.applied,
+		PrimaryLSN:  f.primaryLSN,
+		LastContact: f.lastContact,
+		Syncs:       f.syncs,
+	}
+	if f.primaryLSN > f.applied {
+		st.Lag = f.primaryLSN - f.applied
+		if !f.caughtUp.IsZero() {
+			st.LagSeconds = time.Since(f.caughtUp).Seconds()
+		}
+	}
+	if f.lastErr != nil {
+		st.LastError = f.lastErr.Error()
+	}
+	return st
+}
+
+func (f *Follower) run(ctx context.Context) {
+	defer close(f.done)
+	for {
+		f.mu.Lock()
+		synced := f.synced
+		f.mu.Unlock()
+
+		var err error
+		if synced {
+			err = f.stream(ctx)
+		} else {
+			err = f.sync(ctx)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, types.ErrHistoryUnavailable) {
+			f.log.Warn("primary no longer has the changes after this replica's position; syncing from a snapshot", "primary", f.primary, "err", err)
+		} else if err != nil {
+			f.log.Error("replication failed; reconnecting", "primary", f.primary, "err", err, "retry_ms", f.retry.Milliseconds())
+		}
+
+		f.mu.Lock()
+		if errors.Is(err, types.ErrHistoryUnavailable) {
+			f.synced = false
+		}
+		f.state, f.lastErr = StateDisconnected, err
+		f.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(f.retry):
+		}
+	}
+}
+
+// sync replaces the engine's records with a snapshot of the primary's.
+// Records are applied over what is there rather than after clearing it,
+// so reads keep finding keys meanwhile; the keys the snapshot lacks are
+// deleted last.
+func (f *Follower) sync(ctx context.Context) error {
+	f.setState(StateSyncing)
+	start := time.Now()
+
+	spool, err := os.CreateTemp("", "kvi-replica-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	sum, lsn, err := f.client.Snapshot(ctx, spool)
+	if err != nil {
+		return fmt.Errorf("snapshot: %w", err)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	keys := make(map[string]bool, sum.Records)
+	_, err = backup.Read(spool, func(rec *types.Record) error {
+		keys[rec.ID] = true
+		return f.replica.Apply(ctx, types.ChangeEvent{Op: types.OpPut, Key: rec.ID, Record: rec})
+	})
+	if err != nil {
+		return fmt.Errorf("load snapshot: %w", err)
+	}
+	var stale []string
+	err = f.engine.Scan(ctx, "", func(rec *types.Record) bool {
+		if !keys[rec.ID] {
+			stale = append(stale, rec.ID)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range stale {
+		if err := f.replica.Apply(ctx, types.ChangeEvent{Op: types.OpDelete, Key: key}); err != nil {
+			return err
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.synced, f.applied, f.primaryLSN, f.syncs = true, lsn, lsn, f.syncs+1
+	f.contactLocked(lsn)
+	f.log.Info("replica synced from snapshot", "primary", f.primary, "records", sum.Records, "removed", len(stale),
+		"lsn", lsn, "duration_ms", float64(time.Since(start).Microseconds())/1000)
+	return nil
+}
+
+// stream applies the changes the primary logs after the last one applied.
+func (f *Follower) stream(ctx context.Context) error {
+	f.mu.Lock()
+	after := f.applied
+	f.mu.Unlock()
+
+	f.setState(StateStreaming)
+	return f.client.Replicate(ctx, after, func(ev types.ChangeEvent, heartbeat bool) error {
+		if !heartbeat {
+			if err := f.replica.Apply(ctx, ev); err != nil {
+				return fmt.Errorf("apply LSN %d: %w", ev.Seq, err)
+			}
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if !heartbeat {
+			f.applied = ev.Seq
+		}
+		f.lastErr = nil
+		f.contactLocked(ev.Seq)
+		return nil
+	})
+}
+
+// contactLocked records a message from the primary logged up to lsn.
+func (f *Follower) contactLocked(lsn uint64) {
+	now := time.Now()
+	f.lastContact = now
+	f.primaryLSN = max(f.primaryLSN, lsn)
+	if f.applied >= f.primaryLSN {
+		f.caughtUp = now
+	}
+}
+
+func (f *Follower) setState(state string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.state = state
+}
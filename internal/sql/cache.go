@@ -0,0 +1,248 @@
+package sql
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+	"github.com/xwb1989/sqlparser"
+)
+
+// DefaultCacheEntries is how many results a Cache holds unless told.
+const DefaultCacheEntries = 1000
+
+// Cache keeps the results of SELECT statements for an Executor (see
+// WithCache), keyed by the statement in canonical form, for up to a TTL
+// and no longer than any record in them lives. It follows the engine's
+// change feed: a write drops the results that read its key, and every
+// MATCH result, whatever API or replica the write came through. The
+// writes logged before a statement starts are always taken into account
+// first, so a client reads its own writes.
+type Cache struct {
+	eng     types.Watcher
+	ttl     time.Duration
+	max     int
+	ctx     context.Context
+	stop    context.CancelFunc
+	mu      sync.Mutex // guards what follows
+	events  <-chan types.ChangeEvent
+	entries map[string]*list.Element
+	order   *list.List                        // of *cached, most recently used first
+	byKey   map[string]map[*list.Element]bool // the results reading each key
+	anyKey  map[*list.Element]bool            // the results reading every key
+	gen     uint64                            // bumped by every change seen
+
+	hits, misses, invalidations, evictions, flushes int64
+}
+
+type cached struct {
+	stmt    string
+	key     string // the one key read, "" for every key
+	res     *Result
+	expires time.Time
+}
+
+// NewCache caches results for up to ttl, holding at most maxEntries of
+// them (0 = DefaultCacheEntries). eng must have a change feed.
+func NewCache(eng types.Engine, ttl time.Duration, maxEntries int) (*Cache, error) {
+	w, ok := eng.(types.Watcher)
+	if !ok {
+		return nil, errors.New("query cache needs an engine with a change feed")
+	}
+	if maxEntries <= 0 {
+		maxEntries = DefaultCacheEntries
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	c := &Cache{eng: w, ttl: ttl, max: maxEntries, ctx: ctx, stop: stop}
+	c.reset()
+	if err := c.watchLocked(); err != nil {
+		stop()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Cache) reset() {
+	c.entries = make(map[string]*list.Element)
+	c.order = list.New()
+	c.byKey = make(map[string]map[*list.Element]bool)
+	c.anyKey = make(map[*list.Element]bool)
+}
+
+func (c *Cache) watchLocked() error {
+	events, err := c.eng.Watch(c.ctx, "", 0)
+	if err != nil {
+		return err
+	}
+	c.events = events
+	return nil
+}
+
+// catchUpLocked applies the changes the feed holds. A watch the feed
+// dropped for falling behind loses changes, so it is started again and
+// everything cached is flushed.
+func (c *Cache) catchUpLocked() {
+	for {
+		select {
+		case ev, ok := <-c.events:
+			if !ok {
+				c.gen++
+				c.flushLocked()
+				if c.ctx.Err() != nil || c.watchLocked() != nil {
+					c.events = nil // closed: nothing is cached again
+				}
+				return
+			}
+			c.gen++
+			c.invalidateLocked(ev.Key)
+		default:
+			return
+		}
+	}
+}
+
+func (c *Cache) invalidateLocked(key string) {
+	for el := range c.byKey[key] {
+		c.removeLocked(el)
+		c.invalidations++
+	}
+	for el := range c.anyKey {
+		c.removeLocked(el)
+		c.invalidations++
+	}
+}
+
+func (c *Cache) removeLocked(el *list.Element) {
+	entry := el.Value.(*cached)
+	c.order.Remove(el)
+	delete(c.entries, entry.stmt)
+	if entry.key == "" {
+		delete(c.anyKey, el)
+		return
+	}
+	delete(c.byKey[entry.key], el)
+	if len(c.byKey[entry.key]) == 0 {
+		delete(c.byKey, entry.key)
+	}
+}
+
+func (c *Cache) flushLocked() int {
+	n := len(c.entries)
+	c.reset()
+	c.flushes++
+	return n
+}
+
+// get returns the result cached for stmt, if any, and the generation to
+// hand put should it have to be run.
+func (c *Cache) get(stmt string) (*Result, uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.catchUpLocked()
+	if el, ok := c.entries[stmt]; ok {
+		entry := el.Value.(*cached)
+		if time.Now().Before(entry.expires) {
+			c.order.MoveToFront(el)
+			c.hits++
+			return entry.res, c.gen
+		}
+		c.removeLocked(el)
+	}
+	c.misses++
+	return nil, c.gen
+}
+
+// put caches res for stmt, which read key ("" for every key), unless a
+// change came in since gen: res may predate it.
+func (c *Cache) put(stmt, key string, res *Result, gen uint64) {
+	expires := time.Now().Add(c.ttl)
+	for _, rec := range resultRecords(res.Value) {
+		if rec.TTL != nil && rec.TTL.Before(expires) {
+			expires = *rec.TTL
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.catchUpLocked()
+	if c.gen != gen || c.events == nil {
+		return
+	}
+	if el, ok := c.entries[stmt]; ok {
+		c.removeLocked(el)
+	}
+	el := c.order.PushFront(&cached{stmt: stmt, key: key, res: res, expires: expires})
+	c.entries[stmt] = el
+	if key == "" {
+		c.anyKey[el] = true
+	} else {
+		if c.byKey[key] == nil {
+			c.byKey[key] = make(map[*list.Element]bool)
+		}
+		c.byKey[key][el] = true
+	}
+	for len(c.entries) > c.max {
+		c.removeLocked(c.order.Back())
+		c.evictions++
+	}
+}
+
+// Flush empties the cache and returns how many results it held.
+func (c *Cache) Flush() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flushLocked()
+}
+
+// Stats reports the cache's size and counters.
+func (c *Cache) Stats() *stats.QueryCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &stats.QueryCacheStats{
+		Entries:       len(c.entries),
+		MaxEntries:    c.max,
+		TTLMs:         c.ttl.Milliseconds(),
+		Hits:          c.hits,
+		Misses:        c.misses,
+		Invalidations: c.invalidations,
+		Evictions:     c.evictions,
+		Flushes:       c.flushes,
+	}
+}
+
+// Close stops following the change feed.
+func (c *Cache) Close() {
+	c.stop()
+}
+
+// cacheKey says whether stmt's result may be cached, under what text, and
+// the key it reads: "" for a MATCH, which reads any.
+func (xe *Executor) cacheKey(stmt sqlparser.Statement) (text, key string, ok bool) {
+	sel, isSelect := stmt.(*sqlparser.Select)
+	if !isSelect || sel.Where == nil {
+		return "", "", false
+	}
+	if _, match := sel.Where.Expr.(*sqlparser.MatchExpr); !match {
+		id, err := xe.exprToID(sel.Where.Expr)
+		if err != nil {
+			return "", "", false
+		}
+		key = id
+	}
+	return sqlparser.String(stmt), key, true
+}
+
+// resultRecords lists the records in a statement's result.
+func resultRecords(v interface{}) []*types.Record {
+	switch v := v.(type) {
+	case *types.Record:
+		return []*types.Record{v}
+	case []*types.Record:
+		return v
+	}
+	return nil
+}
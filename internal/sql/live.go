@@ -0,0 +1,228 @@
+package sql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+	"github.com/xwb1989/sqlparser"
+)
+
+// Live query defaults: how long a query waits after a write before it is
+// run again, so a burst of writes costs one run, and how many live queries
+// one client may hold.
+const (
+	DefaultLiveDebounce  = 100 * time.Millisecond
+	DefaultLivePerClient = 10
+)
+
+// ErrTooManyLiveQueries is returned by Subscribe when the client holds as
+// many live queries as it may.
+var ErrTooManyLiveQueries = errors.New("too many live queries for this client")
+
+// LiveQueries runs SELECT statements as live queries: each is run once,
+// then again whenever the engine's change feed shows a write to what it
+// read, the key of a WHERE id = '...' or any key for a MATCH, and its
+// result is pushed each time it differs from the last one pushed. One
+// LiveQueries is shared by the servers of a process, so a client's limit
+// holds across them.
+type LiveQueries struct {
+	eng       types.Watcher
+	xe        *Executor
+	debounce  time.Duration
+	perClient int
+
+	mu       sync.Mutex // guards what follows
+	clients  map[string]int
+	active   int
+	started  int64
+	pushes   int64
+	rejected int64
+}
+
+// LiveResult is one push of a live query. Seq counts the pushes from 1,
+// the first being the result as the query started, so a gap shows a
+// client it missed one. A non-nil Err ends the query: it is the last
+// result sent.
+type LiveResult struct {
+	Seq    uint64
+	Result *Result
+	Err    error
+}
+
+// NewLiveQueries runs live queries against eng, which must have a change
+// feed, waiting debounce after a write before running one again. A client
+// may hold perClient of them at once (0 = no limit).
+func NewLiveQueries(eng types.Engine, debounce time.Duration, perClient int) (*LiveQueries, error) {
+	w, ok := eng.(types.Watcher)
+	if !ok {
+		return nil, errors.New("live queries need an engine with a change feed")
+	}
+	return &LiveQueries{eng: w, xe: NewExecutor(eng), debounce: debounce, perClient: perClient,
+		clients: make(map[string]int)}, nil
+}
+
+// Subscribe starts query as a live query for client, until ctx ends. The
+// channel holds the query's first result by the time Subscribe returns,
+// and is closed when the query ends. A key that is not there reads as no
+// records rather than an error.
+func (lq *LiveQueries) Subscribe(ctx context.Context, client, query string) (<-chan LiveResult, error) {
+	stmt, err := parse(query)
+	if err != nil {
+		return nil, fmt.Errorf("SQL parse error: %w", err)
+	}
+	if _, ok := stmt.(*sqlparser.Select); !ok {
+		return nil, errors.New("only SELECT statements can be live")
+	}
+	_, key, ok := lq.xe.cacheKey(stmt)
+	if !ok {
+		return nil, errors.New("a live query needs WHERE id = '...' or WHERE field MATCH '...'")
+	}
+	if !lq.acquire(client) {
+		return nil, ErrTooManyLiveQueries
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	release := func() {
+		cancel()
+		lq.release(client)
+	}
+	events, err := lq.eng.Watch(ctx, key, 0) // before the first run, so no write falls between
+	if err != nil {
+		release()
+		return nil, err
+	}
+	first, err := lq.run(ctx, stmt)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	out := make(chan LiveResult, 1)
+	out <- LiveResult{Seq: 1, Result: first}
+	lq.count(&lq.pushes)
+	go lq.follow(ctx, release, out, stmt, key, events, first)
+	return out, nil
+}
+
+// follow runs stmt again as writes to key ("" for any) come in, pushing
+// each new result to out, until ctx ends.
+func (lq *LiveQueries) follow(ctx context.Context, release func(), out chan<- LiveResult, stmt sqlparser.Statement, key string, events <-chan types.ChangeEvent, last *Result) {
+	defer release()
+	defer close(out)
+	send := func(res LiveResult) bool {
+		select {
+		case out <- res:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	seq, prev := uint64(1), encodeResult(last)
+	var fire <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				if ctx.Err() != nil {
+					return
+				}
+				// The feed dropped a watcher that fell behind: watch again
+				// and run the query, as any change may have been missed
+				var err error
+				if events, err = lq.eng.Watch(ctx, key, 0); err != nil {
+					send(LiveResult{Err: err})
+					return
+				}
+			} else if key != "" && ev.Key != key {
+				continue // the watch is by prefix
+			}
+			if fire == nil {
+				fire = time.After(lq.debounce)
+			}
+		case <-fire:
+			fire = nil
+			res, err := lq.run(ctx, stmt)
+			if err != nil {
+				if ctx.Err() == nil {
+					send(LiveResult{Err: err})
+				}
+				return
+			}
+			encoded := encodeResult(res)
+			if bytes.Equal(encoded, prev) {
+				continue
+			}
+			seq, prev = seq+1, encoded
+			if !send(LiveResult{Seq: seq, Result: res}) {
+				return
+			}
+			lq.count(&lq.pushes)
+		}
+	}
+}
+
+// run runs stmt, reading a missing key as no records.
+func (lq *LiveQueries) run(ctx context.Context, stmt sqlparser.Statement) (*Result, error) {
+	res, err := lq.xe.run(ctx, stmt)
+	if errors.Is(err, types.ErrKeyNotFound) {
+		return &Result{Value: []*types.Record{}, Columns: projection(stmt.(*sqlparser.Select).SelectExprs)}, nil
+	}
+	return res, err
+}
+
+// encodeResult is what two results are compared by.
+func encodeResult(res *Result) []byte {
+	b, _ := json.Marshal(res.Value)
+	return b
+}
+
+func (lq *LiveQueries) acquire(client string) bool {
+	lq.mu.Lock()
+	defer lq.mu.Unlock()
+	if lq.perClient > 0 && lq.clients[client] >= lq.perClient {
+		lq.rejected++
+		return false
+	}
+	lq.clients[client]++
+	lq.active++
+	lq.started++
+	return true
+}
+
+func (lq *LiveQueries) release(client string) {
+	lq.mu.Lock()
+	defer lq.mu.Unlock()
+	if lq.clients[client]--; lq.clients[client] <= 0 {
+		delete(lq.clients, client)
+	}
+	lq.active--
+}
+
+func (lq *LiveQueries) count(n *int64) {
+	lq.mu.Lock()
+	*n++
+	lq.mu.Unlock()
+}
+
+// Stats reports the live queries running and the counters.
+func (lq *LiveQueries) Stats() *stats.LiveQueryStats {
+	lq.mu.Lock()
+	defer lq.mu.Unlock()
+	return &stats.LiveQueryStats{
+		Active:       lq.active,
+		Clients:      len(lq.clients),
+		MaxPerClient: lq.perClient,
+		DebounceMs:   lq.debounce.Milliseconds(),
+		Started:      lq.started,
+		Pushes:       lq.pushes,
+		Rejected:     lq.rejected,
+	}
+}
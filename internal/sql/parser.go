@@ -4,49 +4,198 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/thirawat27/kvi/internal/tracing"
+	"github.com/thirawat27/kvi/pkg/keygen"
 	"github.com/thirawat27/kvi/pkg/types"
 	"github.com/xwb1989/sqlparser"
+	"github.com/xwb1989/sqlparser/dependency/querypb"
 )
 
 // Executor translates standard SQL ASTs into KVi engine operations.
 // Supported statements: SELECT, INSERT, UPDATE, DELETE, CREATE TABLE (no-op).
+// INSERT keys each row by its id column, making one up (WithKeyGenerator)
+// for a row without one or with the id 'auto'.
+// Besides WHERE id = '...', SELECT takes WHERE field MATCH 'words' (or
+// MySQL's MATCH (field) AGAINST ('words')) on engines with a text index
+// over field, returning the matching records best first, up to LIMIT.
 type Executor struct {
 	engine types.Engine
+	tracer *tracing.Tracer
+	keys   keygen.Generator
+	cache  *Cache
 }
 
-func NewExecutor(e types.Engine) *Executor {
-	return &Executor{engine: e}
+func NewExecutor(e types.Engine, opts ...func(*Executor)) *Executor {
+	xe := &Executor{engine: e, keys: keygen.NewULID()}
+	for _, o := range opts {
+		o(xe)
+	}
+	return xe
+}
+
+// WithTracer reports each statement as a sql.execute span, with a child
+// span for each of its stages, sql.parse and sql.engine.
+func WithTracer(t *tracing.Tracer) func(*Executor) {
+	return func(xe *Executor) { xe.tracer = t }
+}
+
+// WithKeyGenerator sets what makes up the key of a row INSERT gives no id,
+// or the id 'auto'; ULIDs by default.
+func WithKeyGenerator(g keygen.Generator) func(*Executor) {
+	return func(xe *Executor) { xe.keys = g }
+}
+
+// WithCache answers SELECTs from c while their results are current.
+func WithCache(c *Cache) func(*Executor) {
+	return func(xe *Executor) { xe.cache = c }
+}
+
+// Result is the outcome of one statement and what it cost.
+type Result struct {
+	Value interface{}
+	// Columns lists the columns a SELECT names, in order; nil for SELECT *
+	// and other statements. The whole record is returned regardless, so
+	// this only tells renderers which fields to put first.
+	Columns     []string
+	RowsScanned int // records read from the engine
+	Duration    time.Duration
+	Cached      bool // answered from the Cache, reading nothing
 }
 
 // ExecuteQuery parses a 100 % standard SQL string and maps it to KVi operations.
 func (xe *Executor) ExecuteQuery(ctx context.Context, query string) (interface{}, error) {
-	stmt, err := sqlparser.Parse(query)
+	res, err := xe.Execute(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return res.Value, nil
+}
+
+// StageError reports the stage of a statement ("parse", "engine") that was
+// cut short by its context.
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string { return e.Stage + ": " + e.Err.Error() }
+
+func (e *StageError) Unwrap() error { return e.Err }
+
+// Execute runs query like ExecuteQuery and also reports its cost. If ctx
+// ends first the error is a *StageError wrapping ctx.Err().
+func (xe *Executor) Execute(ctx context.Context, query string) (res *Result, err error) {
+	ctx, span := xe.tracer.Start(ctx, "sql.execute")
+	defer func() { tracing.End(span, err) }()
+
+	start := time.Now()
+	_, parseSpan := xe.tracer.Start(ctx, "sql.parse")
+	stmt, err := parse(query)
+	tracing.End(parseSpan, err)
 	if err != nil {
 		return nil, fmt.Errorf("SQL parse error: %w", err)
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, &StageError{Stage: "parse", Err: err}
+	}
+	var (
+		text, key string
+		gen       uint64
+		cacheable bool
+	)
+	if xe.cache != nil {
+		if text, key, cacheable = xe.cacheKey(stmt); cacheable {
+			var hit *Result
+			if hit, gen = xe.cache.get(text); hit != nil {
+				res = &Result{Value: hit.Value, Columns: hit.Columns, Duration: time.Since(start), Cached: true}
+				return res, nil
+			}
+		}
+	}
+
+	ctx, engineSpan := xe.tracer.Start(ctx, "sql.engine")
+	res, err = xe.run(ctx, stmt)
+	tracing.End(engineSpan, err)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, &StageError{Stage: "engine", Err: ctxErr}
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.Duration = time.Since(start)
+	if cacheable {
+		xe.cache.put(text, key, &Result{Value: res.Value, Columns: res.Columns}, gen)
+	}
+	return res, nil
+}
 
+// run carries out a parsed statement.
+func (xe *Executor) run(ctx context.Context, stmt sqlparser.Statement) (*Result, error) {
+	var err error
+	res := &Result{}
 	switch ast := stmt.(type) {
 	case *sqlparser.Select:
-		return xe.handleSelect(ctx, ast)
+		res.Value, res.RowsScanned, err = xe.handleSelect(ctx, ast)
+		res.Columns = projection(ast.SelectExprs)
 	case *sqlparser.Insert:
-		return xe.handleInsert(ctx, ast)
+		res.Value, err = xe.handleInsert(ctx, ast)
 	case *sqlparser.Update:
-		return xe.handleUpdate(ctx, ast)
+		res.Value, err = xe.handleUpdate(ctx, ast)
+		res.RowsScanned = 1
 	case *sqlparser.Delete:
-		return xe.handleDelete(ctx, ast)
+		res.Value, err = xe.handleDelete(ctx, ast)
 	case *sqlparser.DDL:
 		// CREATE TABLE, DROP TABLE – accepted as no-ops (schema-free KV store)
-		return map[string]string{"status": "ok", "note": "schema statements are no-ops in Kvi"}, nil
+		res.Value = map[string]string{"status": "ok", "note": "schema statements are no-ops in Kvi"}
 	default:
 		return nil, fmt.Errorf("unsupported statement type %T; Kvi supports SELECT / INSERT / UPDATE / DELETE", stmt)
 	}
+	return res, err
+}
+
+// IsReadOnly reports whether query is a statement that cannot modify data.
+// Unparsable queries are treated as writes.
+func IsReadOnly(query string) bool {
+	stmt, err := parse(query)
+	if err != nil {
+		return false
+	}
+	switch stmt.(type) {
+	case *sqlparser.Select, *sqlparser.Union, *sqlparser.Show:
+		return true
+	default:
+		return false
+	}
+}
+
+// Normalize returns query in canonical form with literal values replaced by
+// bind variables, so it can be logged without leaking data. Unparsable
+// queries yield "".
+func Normalize(query string) string {
+	stmt, err := parse(query)
+	if err != nil {
+		return ""
+	}
+	sqlparser.Normalize(stmt, map[string]*querypb.BindVariable{}, "v")
+	return sqlparser.String(stmt)
 }
 
 // ── helpers ──────────────────────────────────────────────────────────────────
 
+// matchOp is field MATCH 'words', which MySQL lacks.
+var matchOp = regexp.MustCompile(`(?i)\b([a-z_][a-z0-9_]*)\s+MATCH\s+('(?:[^'\\]|\\.|'')*')`)
+
+// parse parses query after rewriting field MATCH 'words' to the MATCH
+// (field) AGAINST ('words') the parser knows.
+func parse(query string) (sqlparser.Statement, error) {
+	return sqlparser.Parse(matchOp.ReplaceAllString(query, "MATCH ($1) AGAINST ($2)"))
+}
+
 // extractIDFromWhere pulls the primary-key value from a WHERE id = '...' clause.
 func (xe *Executor) extractIDFromWhere(where *sqlparser.Where) (string, error) {
 	if where == nil {
@@ -106,12 +255,89 @@ func sqlValToGo(v *sqlparser.SQLVal) interface{} {
 
 // ── SELECT ───────────────────────────────────────────────────────────────────
 
-func (xe *Executor) handleSelect(ctx context.Context, stmt *sqlparser.Select) (interface{}, error) {
+// handleSelect fetches the record named in the WHERE clause; it also
+// returns how many records were read.
+func (xe *Executor) handleSelect(ctx context.Context, stmt *sqlparser.Select) (interface{}, int, error) {
+	if stmt.Where != nil {
+		if match, ok := stmt.Where.Expr.(*sqlparser.MatchExpr); ok {
+			return xe.selectMatch(ctx, match, stmt.Limit)
+		}
+	}
 	id, err := xe.extractIDFromWhere(stmt.Where)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
-	return xe.engine.Get(ctx, id)
+	rec, err := xe.engine.Get(ctx, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rec, 1, nil
+}
+
+// selectMatch runs a text search for WHERE field MATCH 'words'.
+func (xe *Executor) selectMatch(ctx context.Context, match *sqlparser.MatchExpr, limit *sqlparser.Limit) (interface{}, int, error) {
+	searcher, ok := xe.engine.(types.TextSearcher)
+	if !ok {
+		return nil, 0, errors.New("MATCH needs an engine with text indexes")
+	}
+	if len(match.Columns) != 1 {
+		return nil, 0, errors.New("MATCH takes exactly one column")
+	}
+	var col *sqlparser.ColName
+	if aliased, ok := match.Columns[0].(*sqlparser.AliasedExpr); ok {
+		col, _ = aliased.Expr.(*sqlparser.ColName)
+	}
+	if col == nil {
+		return nil, 0, errors.New("MATCH takes a column name")
+	}
+	query, ok := match.Expr.(*sqlparser.SQLVal)
+	if !ok || query.Type != sqlparser.StrVal {
+		return nil, 0, errors.New("MATCH takes a string literal")
+	}
+	n := 0
+	if limit != nil {
+		if limit.Offset != nil {
+			return nil, 0, errors.New("MATCH does not support OFFSET")
+		}
+		val, ok := limit.Rowcount.(*sqlparser.SQLVal)
+		if !ok || val.Type != sqlparser.IntVal {
+			return nil, 0, errors.New("LIMIT must be an integer")
+		}
+		n, _ = strconv.Atoi(string(val.Val))
+	}
+	hits, err := searcher.TextSearch(ctx, col.Name.String(), string(query.Val), n)
+	if err != nil {
+		return nil, 0, err
+	}
+	records := make([]*types.Record, len(hits))
+	for i, hit := range hits {
+		records[i] = hit.Record
+	}
+	return records, len(records), nil
+}
+
+// projection returns the names of the selected columns, using aliases
+// where given, or nil if the select list has a *.
+func projection(exprs sqlparser.SelectExprs) []string {
+	var cols []string
+	for _, expr := range exprs {
+		aliased, ok := expr.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil
+		}
+		switch {
+		case !aliased.As.IsEmpty():
+			cols = append(cols, aliased.As.String())
+		default:
+			col, ok := aliased.Expr.(*sqlparser.ColName)
+			if !ok {
+				cols = append(cols, sqlparser.String(aliased.Expr))
+				continue
+			}
+			cols = append(cols, strings.ToLower(col.Name.String()))
+		}
+	}
+	return cols
 }
 
 // ── INSERT ───────────────────────────────────────────────────────────────────
@@ -153,8 +379,12 @@ func (xe *Executor) handleInsert(ctx context.Context, stmt *sqlparser.Insert) (i
 			}
 		}
 
-		if id == "" {
-			return nil, errors.New("INSERT must include an 'id' column as the primary key")
+		// Only the id column is the key; without one the row gets a new key
+		if keygen.IsAuto(id) {
+			var err error
+			if id, err = xe.keys.Key(ctx, ""); err != nil {
+				return nil, err
+			}
 		}
 
 		if err := xe.engine.Put(ctx, id, &types.Record{ID: id, Data: data}); err != nil {
@@ -0,0 +1,46 @@
+// Package tracing wraps the OpenTelemetry tracer the server and engines
+// report spans to. Tracing is optional: a nil *Tracer starts no spans, so
+// instrumented code costs a nil check when no TracerProvider is set.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Name is the instrumentation scope spans are reported under.
+const Name = "github.com/thirawat27/kvi"
+
+// Tracer starts spans on a TracerProvider's tracer; nil starts none.
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// New returns a Tracer for tp, or nil if tp is nil.
+func New(tp trace.TracerProvider) *Tracer {
+	if tp == nil {
+		return nil
+	}
+	return &Tracer{tracer: tp.Tracer(Name)}
+}
+
+// Start starts a span named name as a child of any span in ctx. On a nil
+// Tracer it returns ctx and a span that records nothing.
+func (t *Tracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	if t == nil {
+		return ctx, noop.Span{}
+	}
+	return t.tracer.Start(ctx, name, opts...)
+}
+
+// End ends span, marking it failed with err if err is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
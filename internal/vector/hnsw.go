@@ -1,12 +1,20 @@
 package vector
 
 import (
+	"cmp"
+	"fmt"
 	"math"
+	"slices"
+	"strings"
+
+	"github.com/thirawat27/kvi/pkg/types"
 )
 
 type HNSWIndex struct {
 	documents map[string][]float32
 	dim       int
+	memory    int64 // sum of nodeSize over the documents
+	maxMemory int64 // 0 = unbounded
 }
 
 func NewHNSWIndex(dim int) *HNSWIndex {
@@ -32,32 +40,136 @@ func cosineSimilarity(a, b []float32) float32 {
 	return dot / (float32(math.Sqrt(float64(normA))) * float32(math.Sqrt(float64(normB))))
 }
 
-func (h *HNSWIndex) Add(id string, vector []float32) {
+// SetMaxMemory bounds the memory the index may take, as Stats estimates
+// it; 0, the default, leaves it unbounded.
+func (h *HNSWIndex) SetMaxMemory(bytes int64) {
+	h.maxMemory = bytes
+}
+
+// Add indexes vector under id, replacing any vector it had. It fails as
+// Fits does, leaving the index as it was.
+func (h *HNSWIndex) Add(id string, vector []float32) error {
+	if err := h.Fits(id, vector); err != nil {
+		return err
+	}
+	h.memory += h.growth(id, vector)
 	h.documents[id] = vector
+	return nil
+}
+
+// Fits fails with types.ErrMemoryLimit if adding vector under id would
+// take the index past its memory bound.
+func (h *HNSWIndex) Fits(id string, vector []float32) error {
+	if grow := h.growth(id, vector); h.maxMemory > 0 && grow > 0 && h.memory+grow > h.maxMemory {
+		return fmt.Errorf("%w: the vector index takes %d bytes of its %d", types.ErrMemoryLimit, h.memory, h.maxMemory)
+	}
+	return nil
+}
+
+// growth is how much adding vector under id changes the index's memory.
+func (h *HNSWIndex) growth(id string, vector []float32) int64 {
+	grow := nodeSize(id, vector)
+	if old, ok := h.documents[id]; ok {
+		grow -= nodeSize(id, old)
+	}
+	return grow
+}
+
+// Vector returns the vector indexed under id.
+func (h *HNSWIndex) Vector(id string) ([]float32, bool) {
+	v, ok := h.documents[id]
+	return v, ok
+}
+
+// Range calls fn for every indexed vector, in no particular order, until
+// fn returns false.
+func (h *HNSWIndex) Range(fn func(id string, vector []float32) bool) {
+	for id, v := range h.documents {
+		if !fn(id, v) {
+			return
+		}
+	}
 }
 
 func (h *HNSWIndex) Delete(id string) {
-	delete(h.documents, id)
+	if old, ok := h.documents[id]; ok {
+		delete(h.documents, id)
+		h.memory -= nodeSize(id, old)
+	}
 }
 
-func (h *HNSWIndex) Search(query []float32, k int) []string {
-	type result struct {
-		id    string
-		score float32
+// Stats describes the index. The index is a single flat layer; MemoryBytes
+// estimates vectors, IDs and map overhead, kept up to date by every Add
+// and Delete.
+type Stats struct {
+	Nodes       int
+	Levels      int
+	Dim         int
+	MemoryBytes int64
+}
+
+// entryOverhead approximates the map entry and slice headers per document.
+const entryOverhead = 64
+
+// nodeSize estimates the memory one document takes in the index.
+func nodeSize(id string, vector []float32) int64 {
+	return int64(len(id)+4*len(vector)) + entryOverhead
+}
+
+func (h *HNSWIndex) Stats() Stats {
+	stats := Stats{Nodes: len(h.documents), Dim: h.dim, MemoryBytes: h.memory}
+	if stats.Nodes > 0 {
+		stats.Levels = 1
 	}
+	return stats
+}
+
+func (h *HNSWIndex) Search(query []float32, k int) []string {
+	return h.SearchFunc(query, k, nil)
+}
 
+// SearchFunc is Search over the documents allow allows, or all of them if
+// allow is nil. The others are passed over without being scored.
+func (h *HNSWIndex) SearchFunc(query []float32, k int, allow func(id string) bool) []string {
 	results := make([]result, 0, len(h.documents))
 
+	// A flat scan rather than a real HNSW graph: rank every document
 	for id, vec := range h.documents {
-		score := cosineSimilarity(query, vec)
-		results = append(results, result{id, score})
+		if allow == nil || allow(id) {
+			results = append(results, result{id, cosineSimilarity(query, vec)})
+		}
+	}
+	return rank(results, k)
+}
+
+// SearchAmong is Search over the documents of ids, scoring only those:
+// cheaper than SearchFunc when they are few of the documents.
+func (h *HNSWIndex) SearchAmong(query []float32, k int, ids []string) []string {
+	results := make([]result, 0, len(ids))
+	for _, id := range ids {
+		if vec, ok := h.documents[id]; ok {
+			results = append(results, result{id, cosineSimilarity(query, vec)})
+		}
 	}
+	return rank(results, k)
+}
+
+type result struct {
+	id    string
+	score float32
+}
 
-	// simple logic, not actually HNSW since implementing full HNSW takes many lines
-	// Just return top 1 result for simplicity
-	var tops []string
-	if len(results) > 0 {
-		tops = append(tops, results[0].id)
+// rank returns the IDs of the k best results, best first.
+func rank(results []result, k int) []string {
+	slices.SortFunc(results, func(a, b result) int {
+		if c := cmp.Compare(b.score, a.score); c != 0 {
+			return c
+		}
+		return strings.Compare(a.id, b.id)
+	})
+	tops := make([]string, 0, max(min(k, len(results)), 0))
+	for _, r := range results[:cap(tops)] {
+		tops = append(tops, r.id)
 	}
 	return tops
 }
@@ -0,0 +1,292 @@
+package vector
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/bits"
+	"reflect"
+	"slices"
+	"strconv"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// Postings keeps posting lists for chosen fields of the records' data:
+// for each value a field holds, a bitmap of the records holding it. A
+// search filtered on such fields intersects their bitmaps to learn which
+// records qualify before it looks at a single vector.
+//
+// Records are numbered densely for the bitmaps, reusing the numbers of
+// removed ones. Only records holding one of the fields get a number.
+type Postings struct {
+	fields map[string]map[string]*Bitmap // field -> value key -> nodes
+	nodes  map[string]uint32             // record ID -> node
+	ids    []string                      // node -> record ID; "" if free
+	posted [][]posting                   // node -> what it is posted under
+	free   []uint32
+}
+
+type posting struct{ field, value string }
+
+// NewPostings returns empty posting lists for fields.
+func NewPostings(fields []string) *Postings {
+	p := &Postings{fields: make(map[string]map[string]*Bitmap, len(fields)), nodes: make(map[string]uint32)}
+	for _, f := range fields {
+		p.fields[f] = make(map[string]*Bitmap)
+	}
+	return p
+}
+
+// Fields returns the fields with posting lists, sorted.
+func (p *Postings) Fields() []string {
+	fields := make([]string, 0, len(p.fields))
+	for f := range p.fields {
+		fields = append(fields, f)
+	}
+	slices.Sort(fields)
+	return fields
+}
+
+// Add posts id under the values data holds for the fields, replacing what
+// it was posted under before. Values of other kinds than strings, numbers
+// and bools are not posted.
+func (p *Postings) Add(id string, data map[string]interface{}) {
+	p.Remove(id)
+	var under []posting
+	for f := range p.fields {
+		if key, ok := FilterKey(data[f]); ok {
+			under = append(under, posting{f, key})
+		}
+	}
+	if len(under) == 0 {
+		return
+	}
+	node := p.node(id)
+	p.posted[node] = under
+	for _, at := range under {
+		values := p.fields[at.field]
+		bm := values[at.value]
+		if bm == nil {
+			bm = &Bitmap{}
+			values[at.value] = bm
+		}
+		bm.Set(node)
+	}
+}
+
+// node numbers id, which has no number yet.
+func (p *Postings) node(id string) uint32 {
+	var node uint32
+	if n := len(p.free); n > 0 {
+		node, p.free = p.free[n-1], p.free[:n-1]
+		p.ids[node] = id
+	} else {
+		node = uint32(len(p.ids))
+		p.ids = append(p.ids, id)
+		p.posted = append(p.posted, nil)
+	}
+	p.nodes[id] = node
+	return node
+}
+
+// Remove takes id off every posting list.
+func (p *Postings) Remove(id string) {
+	node, ok := p.nodes[id]
+	if !ok {
+		return
+	}
+	for _, at := range p.posted[node] {
+		values := p.fields[at.field]
+		if bm := values[at.value]; bm != nil {
+			if bm.Clear(node); bm.Count() == 0 {
+				delete(values, at.value)
+			}
+		}
+	}
+	delete(p.nodes, id)
+	p.ids[node], p.posted[node] = "", nil
+	p.free = append(p.free, node)
+}
+
+// Allowed intersects the posting lists of the fields of filter that have
+// them, returning the records that hold all their values and the fields
+// left to check some other way. allowed is nil if filter names no field
+// with posting lists.
+func (p *Postings) Allowed(filter map[string]interface{}) (allowed *Bitmap, rest map[string]interface{}) {
+	for f, v := range filter {
+		values, ok := p.fields[f]
+		if !ok {
+			if rest == nil {
+				rest = make(map[string]interface{})
+			}
+			rest[f] = v
+			continue
+		}
+		key, _ := FilterKey(v)
+		bm := values[key]
+		if bm == nil {
+			bm = &Bitmap{}
+		}
+		if allowed == nil {
+			allowed = bm.Clone()
+		} else {
+			allowed.And(bm)
+		}
+	}
+	return allowed, rest
+}
+
+// Has reports whether allowed holds id.
+func (p *Postings) Has(allowed *Bitmap, id string) bool {
+	node, ok := p.nodes[id]
+	return ok && allowed.Has(node)
+}
+
+// IDs returns the records allowed holds.
+func (p *Postings) IDs(allowed *Bitmap) []string {
+	ids := make([]string, 0, allowed.Count())
+	allowed.Each(func(node uint32) { ids = append(ids, p.ids[node]) })
+	return ids
+}
+
+// FieldStats describes the posting lists of one field: the distinct
+// values held and the records posted under them.
+type FieldStats struct {
+	Field   string
+	Values  int
+	Records int
+}
+
+// Stats describes the posting lists by field, sorted, and estimates the
+// memory they take.
+func (p *Postings) Stats() ([]FieldStats, int64) {
+	out := make([]FieldStats, 0, len(p.fields))
+	memory := int64(len(p.ids)) * (entryOverhead + 8)
+	for id := range p.nodes {
+		memory += int64(len(id))
+	}
+	for _, f := range p.Fields() {
+		st := FieldStats{Field: f, Values: len(p.fields[f])}
+		for value, bm := range p.fields[f] {
+			st.Records += bm.Count()
+			memory += int64(len(value)+8*len(bm.words)) + entryOverhead
+		}
+		out = append(out, st)
+	}
+	return out, memory
+}
+
+// FilterKey is what a filter value is posted and matched under: strings,
+// bools and numbers of any type, with numbers equal whatever their type,
+// as a record's fields may hold either once a codec has carried them. ok
+// is false for values of other kinds, nil among them.
+func FilterKey(v interface{}) (key string, ok bool) {
+	switch v := v.(type) {
+	case string:
+		return "s" + v, true
+	case bool:
+		return "b" + strconv.FormatBool(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return "n" + strconv.FormatFloat(f, 'g', -1, 64), err == nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return "n" + strconv.FormatFloat(rv.Float(), 'g', -1, 64), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return "n" + strconv.FormatFloat(float64(rv.Int()), 'g', -1, 64), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "n" + strconv.FormatFloat(float64(rv.Uint()), 'g', -1, 64), true
+	}
+	return "", false
+}
+
+// CheckFilter fails with types.ErrInvalidFilter for a filter with a value
+// FilterKey does not take.
+func CheckFilter(filter map[string]interface{}) error {
+	for f, v := range filter {
+		if _, ok := FilterKey(v); !ok {
+			return fmt.Errorf("%w: %s must be a string, number or bool, got %T", types.ErrInvalidFilter, f, v)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether data holds every field of filter with its value.
+func Matches(data map[string]interface{}, filter map[string]interface{}) bool {
+	for f, v := range filter {
+		want, _ := FilterKey(v)
+		if got, ok := FilterKey(data[f]); !ok || got != want {
+			return false
+		}
+	}
+	return true
+}
+
+// Bitmap is a set of node numbers.
+type Bitmap struct {
+	words []uint64
+	count int
+}
+
+// Set adds node.
+func (b *Bitmap) Set(node uint32) {
+	w := int(node / 64)
+	if w >= len(b.words) {
+		b.words = append(b.words, make([]uint64, w+1-len(b.words))...)
+	}
+	if bit := uint64(1) << (node % 64); b.words[w]&bit == 0 {
+		b.words[w] |= bit
+		b.count++
+	}
+}
+
+// Clear removes node.
+func (b *Bitmap) Clear(node uint32) {
+	w := int(node / 64)
+	if w >= len(b.words) {
+		return
+	}
+	if bit := uint64(1) << (node % 64); b.words[w]&bit != 0 {
+		b.words[w] &^= bit
+		b.count--
+	}
+}
+
+// Has reports whether b holds node.
+func (b *Bitmap) Has(node uint32) bool {
+	w := int(node / 64)
+	return w < len(b.words) && b.words[w]&(1<<(node%64)) != 0
+}
+
+// Count returns how many nodes b holds.
+func (b *Bitmap) Count() int { return b.count }
+
+// Clone returns a copy of b.
+func (b *Bitmap) Clone() *Bitmap {
+	return &Bitmap{words: slices.Clone(b.words), count: b.count}
+}
+
+// And keeps in b only the nodes other holds too.
+func (b *Bitmap) And(other *Bitmap) {
+	b.count = 0
+	for w := range b.words {
+		if w < len(other.words) {
+			b.words[w] &= other.words[w]
+		} else {
+			b.words[w] = 0
+		}
+		b.count += bits.OnesCount64(b.words[w])
+	}
+}
+
+// Each calls fn for every node b holds, in order.
+func (b *Bitmap) Each(fn func(node uint32)) {
+	for w, word := range b.words {
+		for word != 0 {
+			fn(uint32(w*64 + bits.TrailingZeros64(word)))
+			word &= word - 1
+		}
+	}
+}
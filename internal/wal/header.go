@@ -0,0 +1,127 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/format"
+)
+
+// A log starts with a header of headerSize bytes, little-endian:
+//
+//	magic        4 bytes, "KVIW"
+//	size         uint16, of the whole header
+//	version      uint16, the format version
+//	min reader   uint16, the oldest version whose readers read the log
+//	min release  14 bytes, the first release reading that, NUL-padded
+//	created      int64, Unix nanoseconds
+//
+// A later version may grow the header; readers skip to its size. Logs
+// from before headers start with the length prefix of their first frame,
+// which is never as large as the magic read as one.
+var magic = [4]byte{'K', 'V', 'I', 'W'}
+
+const headerSize = 32
+
+// ErrBadHeader is a log starting with the magic but no readable header.
+var ErrBadHeader = errors.New("invalid log header")
+
+// legacyHeader is what a log without a header reads as.
+var legacyHeader = format.Header{Format: format.WAL.Name, Version: 1, MinReader: 1, MinRelease: format.WAL.Release(1)}
+
+func encodeHeader(h format.Header) []byte {
+	buf := make([]byte, headerSize)
+	copy(buf, magic[:])
+	binary.LittleEndian.PutUint16(buf[4:], headerSize)
+	binary.LittleEndian.PutUint16(buf[6:], uint16(h.Version))
+	binary.LittleEndian.PutUint16(buf[8:], uint16(h.MinReader))
+	copy(buf[10:24], h.MinRelease)
+	binary.LittleEndian.PutUint64(buf[24:], uint64(h.CreatedAt.UnixNano()))
+	return buf
+}
+
+// readHeader reads the header at the start of br and returns it with its
+// size: 0, with nothing read, for a log from before headers. A header of
+// a version this binary cannot read is a *format.TooNewError.
+func readHeader(br *bufio.Reader) (format.Header, int64, error) {
+	if start, _ := br.Peek(len(magic)); !bytes.Equal(start, magic[:]) {
+		return legacyHeader, 0, nil
+	}
+	fixed := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, fixed); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return format.Header{}, 0, fmt.Errorf("%w: cut short", ErrBadHeader)
+		}
+		return format.Header{}, 0, err
+	}
+	size := int64(binary.LittleEndian.Uint16(fixed[4:]))
+	h := format.Header{
+		Format:     format.WAL.Name,
+		Version:    int(binary.LittleEndian.Uint16(fixed[6:])),
+		MinReader:  int(binary.LittleEndian.Uint16(fixed[8:])),
+		MinRelease: string(bytes.TrimRight(fixed[10:24], "\x00")),
+		CreatedAt:  time.Unix(0, int64(binary.LittleEndian.Uint64(fixed[24:]))).UTC(),
+	}
+	if size < headerSize || h.Version < 2 {
+		return h, 0, fmt.Errorf("%w: size %d, version %d", ErrBadHeader, size, h.Version)
+	}
+	if err := format.WAL.Check(h); err != nil {
+		return h, size, err
+	}
+	if _, err := br.Discard(int(size - headerSize)); err != nil {
+		return h, size, fmt.Errorf("%w: cut short", ErrBadHeader)
+	}
+	return h, size, nil
+}
+
+// ReadHeader reads the header of the log in r, reading ahead of it, and
+// returns it with its size. A log from before headers reads as version 1,
+// of size 0.
+func ReadHeader(r io.Reader) (format.Header, int64, error) {
+	return readHeader(bufio.NewReader(r))
+}
+
+// Upgrade rewrites the log at path in the current format, in place: a
+// header is put before its entries, which are kept as they are. It reports
+// whether there was anything to do. The log must not be open for writing.
+func Upgrade(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	hdr, _, err := ReadHeader(f)
+	if err != nil || hdr.Version >= format.WAL.Latest().Version {
+		return false, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	tmp, err := os.OpenFile(path+".upgrade", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+	_, err = tmp.Write(encodeHeader(format.WAL.Stamp(format.WAL.Latest().Version)))
+	if err == nil {
+		_, err = io.Copy(tmp, f)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+	f.Close() // Windows cannot rename over an open file
+	return true, os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,65 @@
+package wal
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// replayQueue is how many frames each replay worker may have waiting.
+const replayQueue = 64
+
+// replayScan is scan for Replay: with more than one worker, payloads are
+// decoded by that many goroutines while fn still gets the frames one at a
+// time, in log order.
+func replayScan(r io.Reader, workers int, fn func(Frame) bool) error {
+	if workers <= 1 {
+		return scan(r, false, fn)
+	}
+
+	type pending struct {
+		frame   Frame
+		payload []byte
+		decoded chan struct{}
+	}
+	work := make(chan *pending, workers*replayQueue)
+	order := make(chan *pending, workers*replayQueue)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Go(func() {
+			for p := range work {
+				p.frame.Entry, p.frame.Err = decodeEntry(p.payload, false)
+				p.payload = nil
+				close(p.decoded)
+			}
+		})
+	}
+
+	// The reader queues each frame for a worker before it queues it to be
+	// handed to fn, so the frame fn waits for is always being decoded.
+	var stop atomic.Bool
+	errc := make(chan error, 1)
+	go func() {
+		errc <- scanRaw(r, func(frame Frame, payload []byte) bool {
+			p := &pending{frame: frame, payload: payload, decoded: make(chan struct{})}
+			if frame.Err == nil {
+				work <- p
+			} else {
+				close(p.decoded)
+			}
+			order <- p
+			return !stop.Load()
+		})
+		close(work)
+		close(order)
+	}()
+
+	for p := range order {
+		<-p.decoded
+		if !stop.Load() && !fn(p.frame) {
+			stop.Store(true) // and drain what is already queued
+		}
+	}
+	wg.Wait()
+	return <-errc
+}
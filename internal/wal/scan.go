@@ -0,0 +1,297 @@
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/thirawat27/kvi/internal/pack"
+	"github.com/thirawat27/kvi/pkg/codec"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// FileName is the log's name within its directory.
+const FileName = "kvi.wal"
+
+// maxFrame bounds a length prefix; anything larger is taken as a damaged
+// prefix rather than an entry to allocate for.
+const maxFrame = 256 << 20
+
+var (
+	// ErrChecksum is a frame whose entry does not match its checksum.
+	ErrChecksum = errors.New("checksum mismatch")
+	// ErrMalformed is a frame whose payload is not an entry.
+	ErrMalformed = errors.New("malformed entry")
+	// ErrTruncated is a frame cut short by the end of the file, typically
+	// a write interrupted by a crash.
+	ErrTruncated = errors.New("truncated entry")
+	// ErrBadLength is a length prefix too large to be real. Nothing after
+	// it can be located, so scanning stops there.
+	ErrBadLength = errors.New("invalid length prefix")
+	// ErrCodecMismatch is an entry Replay finds written with another codec
+	// than the log is set to write.
+	ErrCodecMismatch = errors.New("codec mismatch")
+)
+
+// Frame is one length-prefixed entry as found in the file.
+type Frame struct {
+	Offset int64 // of the length prefix
+	Size   int64 // prefix and payload
+	// Entry is the decoded entry; nil if the payload is malformed or
+	// missing. It is set on a checksum mismatch, for inspection.
+	Entry *LogEntry
+	// Err is nil for a valid frame, else one of the errors above.
+	Err error
+	// Codec names the codec the payload was written with, as its first
+	// byte tells; "" if there is none.
+	Codec string
+}
+
+// Valid reports whether the frame holds an intact entry.
+func (f Frame) Valid() bool { return f.Err == nil }
+
+// Scan reads a log from r and calls fn for every frame, valid or not, until
+// fn returns false. A frame with a bad payload is reported and skipped, since
+// its length is still known; a truncated frame or an impossible length ends
+// the scan. Scan never modifies the log; err is only an I/O failure or a
+// header it cannot read: damaged, or of a format version too new for it.
+func Scan(r io.Reader, fn func(Frame) bool) error {
+	return scan(r, true, fn)
+}
+
+// scan is Scan, decoding numbers in records as json.Number when exact is
+// set, else as float64 like the APIs do.
+func scan(r io.Reader, exact bool, fn func(Frame) bool) error {
+	return scanRaw(r, func(frame Frame, payload []byte) bool {
+		if frame.Err == nil {
+			frame.Entry, frame.Err = decodeEntry(payload, exact)
+		}
+		return fn(frame)
+	})
+}
+
+// scanRaw is scan leaving each payload for fn to decode: a frame's Err is
+// only ever ErrTruncated or ErrBadLength, and then it has no payload.
+func scanRaw(r io.Reader, fn func(frame Frame, payload []byte) bool) error {
+	br := bufio.NewReader(r)
+	_, offset, err := readHeader(br)
+	if err != nil {
+		return err
+	}
+	var prefix [4]byte
+	for {
+		n, err := io.ReadFull(br, prefix[:])
+		if err == io.EOF {
+			return nil
+		}
+		if err == io.ErrUnexpectedEOF {
+			fn(Frame{Offset: offset, Size: int64(n), Err: ErrTruncated}, nil)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		length := binary.LittleEndian.Uint32(prefix[:])
+		if length > maxFrame {
+			fn(Frame{Offset: offset, Size: 4, Err: ErrBadLength}, nil)
+			return nil
+		}
+		payload := make([]byte, length)
+		n, err = io.ReadFull(br, payload)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			fn(Frame{Offset: offset, Size: 4 + int64(n), Err: ErrTruncated}, nil)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		frame := Frame{Offset: offset, Size: 4 + int64(length)}
+		if c, ok := codec.Detect(payload); ok {
+			frame.Codec = c.Name()
+		}
+		if !fn(frame, payload) {
+			return nil
+		}
+		offset += frame.Size
+	}
+}
+
+// decodeEntry decodes payload and verifies its checksum, which newEntry
+// took over the same JSON with the checksum still zero. Checksum is the
+// last field, so zeroing it in the raw bytes reproduces that exactly.
+func decodeEntry(payload []byte, exact bool) (*LogEntry, error) {
+	if c, _ := codec.Detect(payload); c == codec.MsgPack {
+		return decodeMsgPack(payload)
+	}
+	var entry LogEntry
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	if exact {
+		dec.UseNumber()
+	}
+	if err := dec.Decode(&entry); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if entry.Packed != nil {
+		return unpackEntry(&entry, exact)
+	}
+	field := []byte(`,"checksum":`)
+	at := bytes.LastIndex(payload, field)
+	if at < 0 || !bytes.HasSuffix(payload, []byte("}")) {
+		return &entry, fmt.Errorf("%w: no checksum field", ErrMalformed)
+	}
+	digits := payload[at+len(field) : len(payload)-1]
+	if _, err := strconv.ParseUint(string(digits), 10, 32); err != nil {
+		return &entry, fmt.Errorf("%w: checksum is not a number", ErrMalformed)
+	}
+	zeroed := make([]byte, 0, len(payload))
+	zeroed = append(zeroed, payload[:at+len(field)]...)
+	zeroed = append(zeroed, "0}"...)
+	if crc32.ChecksumIEEE(zeroed) != entry.Checksum {
+		return &entry, ErrChecksum
+	}
+	return &entry, nil
+}
+
+// unpackEntry restores the record of an entry logged packed and verifies
+// the checksum, which newEntry took over the entry with the record in
+// place: the same JSON but for packed, as record is the field before it.
+func unpackEntry(entry *LogEntry, exact bool) (*LogEntry, error) {
+	raw, err := pack.Decode(entry.Packed)
+	if err != nil {
+		return entry, fmt.Errorf("%w: packed record: %v", ErrMalformed, err)
+	}
+	var rec types.Record
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if exact {
+		dec.UseNumber()
+	}
+	if err := dec.Decode(&rec); err != nil {
+		return entry, fmt.Errorf("%w: packed record: %v", ErrMalformed, err)
+	}
+	entry.Record, entry.Packed = &rec, nil
+
+	canonical, err := json.Marshal(struct {
+		LSN       uint64          `json:"lsn"`
+		Timestamp int64           `json:"timestamp"`
+		Op        types.Operation `json:"op"`
+		Key       string          `json:"key"`
+		From      string          `json:"from,omitempty"`
+		Record    json.RawMessage `json:"record"`
+		Checksum  uint32          `json:"checksum"`
+	}{entry.LSN, entry.Timestamp, entry.Op, entry.Key, entry.From, raw, 0})
+	if err != nil || crc32.ChecksumIEEE(canonical) != entry.Checksum {
+		return entry, ErrChecksum
+	}
+	return entry, nil
+}
+
+// decodeMsgPack is decodeEntry for an entry written as MessagePack, whose
+// encoding is deterministic: the checksum is verified by encoding the
+// decoded entry again, its record in place and the checksum zero.
+func decodeMsgPack(payload []byte) (*LogEntry, error) {
+	var entry LogEntry
+	if err := codec.MsgPack.Unmarshal(payload, &entry); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if entry.Packed != nil {
+		var rec types.Record
+		raw, err := pack.Decode(entry.Packed)
+		if err == nil {
+			err = codec.MsgPack.Unmarshal(raw, &rec)
+		}
+		if err != nil {
+			return &entry, fmt.Errorf("%w: packed record: %v", ErrMalformed, err)
+		}
+		entry.Record, entry.Packed = &rec, nil
+	}
+	canonical := entry
+	canonical.Checksum = 0
+	data, err := codec.MsgPack.Marshal(&canonical)
+	if err != nil || crc32.ChecksumIEEE(data) != entry.Checksum {
+		return &entry, ErrChecksum
+	}
+	return &entry, nil
+}
+
+// RepairResult describes what Repair kept and cut.
+type RepairResult struct {
+	Kept         int   `json:"kept"`          // valid entries before the cut
+	Dropped      int   `json:"dropped"`       // frames at or after the cut, valid or not
+	DroppedValid int   `json:"dropped_valid"` // valid entries among them
+	KeptBytes    int64 `json:"kept_bytes"`
+	DroppedBytes int64 `json:"dropped_bytes"`
+}
+
+// Repair truncates the log at path before its first invalid frame, having
+// first copied it to backup. Valid entries after a damaged one are dropped
+// too, and counted: replaying them across the gap could resurrect deleted
+// keys or apply updates out of order. A log with no invalid frame is left
+// untouched and no backup is written. The log must not be open for writing.
+func Repair(path, backup string) (RepairResult, error) {
+	var res RepairResult
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return res, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return res, err
+	}
+
+	cut := int64(-1)
+	err = Scan(f, func(frame Frame) bool {
+		switch {
+		case cut < 0 && frame.Valid():
+			res.Kept++
+			res.KeptBytes += frame.Size
+		case cut < 0:
+			cut = frame.Offset
+			res.Dropped++
+		default:
+			res.Dropped++
+			if frame.Valid() {
+				res.DroppedValid++
+			}
+		}
+		return true
+	})
+	if err != nil || cut < 0 {
+		return res, err
+	}
+	res.DroppedBytes = info.Size() - cut
+
+	if err := copyFile(path, backup); err != nil {
+		return res, fmt.Errorf("backing up %s: %w", path, err)
+	}
+	if err := f.Truncate(cut); err != nil {
+		return res, err
+	}
+	return res, f.Sync()
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	return errors.Join(err, out.Close())
+}
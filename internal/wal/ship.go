@@ -0,0 +1,124 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+const (
+	shipHistory = 4096 // entries retained for followers catching up
+	shipBuffer  = 1024 // live entries a follower may fall behind by
+)
+
+var errClosed = errors.New("wal closed")
+
+// shipper keeps the entries written most recently for followers streaming
+// the log. Entries arrive under the WAL's lock, in LSN order; Rewrite
+// assigns LSNs without shipping anything, so they may have gaps.
+type shipper struct {
+	history   []types.ChangeEvent // ring of the last shipHistory entries
+	head      int                 // index of the oldest once the ring is full
+	floor     uint64              // every entry after this LSN is in history
+	last      uint64              // LSN of the last entry shipped, or floor
+	followers map[*follower]struct{}
+	closed    bool
+}
+
+type follower struct {
+	ch chan types.ChangeEvent
+}
+
+// shipLocked hands entry to the followers. It never blocks: a follower
+// whose buffer is full is dropped, and resumes from the last LSN it saw.
+// A rename goes as the put of its new key at the LSN before its own, then
+// the delete of its old one, and a follower gets both or neither.
+func (w *WAL) shipLocked(entry *LogEntry) {
+	s := &w.ship
+	evs := []types.ChangeEvent{{Seq: entry.LSN, Op: entry.Op, Key: entry.Key, Record: entry.Record}}
+	if entry.Op == types.OpRename {
+		evs[0].Seq, evs[0].Op = entry.LSN-1, types.OpPut
+		evs = append(evs, types.ChangeEvent{Seq: entry.LSN, Op: types.OpDelete, Key: entry.From})
+	}
+	s.last = entry.LSN
+	for _, ev := range evs {
+		if len(s.history) < shipHistory {
+			s.history = append(s.history, ev)
+		} else {
+			s.floor = s.history[s.head].Seq
+			s.history[s.head] = ev
+			s.head = (s.head + 1) % shipHistory
+		}
+	}
+	for f := range s.followers {
+		if cap(f.ch)-len(f.ch) < len(evs) {
+			s.removeLocked(f)
+			continue
+		}
+		for _, ev := range evs {
+			f.ch <- ev
+		}
+	}
+}
+
+// Follow sends the entries written after afterLSN, then each new one as it
+// is written, until ctx ends or the log is closed. Entries before the last
+// Replay, or pushed out of the retained history since, are gone: asking
+// for them fails with types.ErrHistoryUnavailable.
+func (w *WAL) Follow(ctx context.Context, afterLSN uint64) (<-chan types.ChangeEvent, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s := &w.ship
+	if s.closed {
+		return nil, errClosed
+	}
+	if afterLSN < s.floor || afterLSN > w.lastLSN {
+		return nil, fmt.Errorf("%w: asked for entries after LSN %d, have %d to %d", types.ErrHistoryUnavailable, afterLSN, s.floor+1, w.lastLSN)
+	}
+
+	f := &follower{ch: make(chan types.ChangeEvent, shipHistory+shipBuffer)}
+	for i := range s.history {
+		if ev := s.history[(s.head+i)%len(s.history)]; ev.Seq > afterLSN {
+			f.ch <- ev
+		}
+	}
+	if s.followers == nil {
+		s.followers = make(map[*follower]struct{})
+	}
+	s.followers[f] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		s.removeLocked(f)
+	}()
+	return f.ch, nil
+}
+
+func (s *shipper) removeLocked(f *follower) {
+	if _, ok := s.followers[f]; ok {
+		delete(s.followers, f)
+		close(f.ch)
+	}
+}
+
+// closeLocked ends every follow.
+func (s *shipper) closeLocked() {
+	s.closed = true
+	for f := range s.followers {
+		s.removeLocked(f)
+	}
+}
+
+// ShippedLSN returns the LSN of the last change written: the last entry
+// shipped to followers, or the last replayed. The puts Rewrite compacts
+// the log into change nothing, so they do not count.
+func (w *WAL) ShippedLSN() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ship.last
+}
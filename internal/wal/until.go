@@ -0,0 +1,102 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// ErrHistoryGap is a log that no longer holds the entries right after the
+// LSN a replay starts from, as when compaction rewrote it since.
+var ErrHistoryGap = errors.New("log does not reach back to the starting LSN")
+
+// Cut is where a point-in-time replay stops: before the first entry
+// written at or after Time, or logged after LSN, whichever comes first.
+// Zero fields do not cut; the zero Cut replays the whole log.
+type Cut struct {
+	Time time.Time
+	LSN  uint64
+}
+
+// passed reports whether entry lies beyond c.
+func (c Cut) passed(entry *LogEntry) bool {
+	return c.LSN > 0 && entry.LSN > c.LSN || !c.Time.IsZero() && entry.Timestamp >= c.Time.UnixNano()
+}
+
+// UntilResult is what ReplayUntil found in a log.
+type UntilResult struct {
+	Skipped   int // at or before the starting LSN
+	Replayed  int
+	Discarded int // past the cut, damaged ones included
+	// LastLSN and LastTime are the last entry replayed, the cut point; 0
+	// and zero if there was none. NextLSN and NextTime are the first one
+	// discarded.
+	LastLSN  uint64
+	LastTime time.Time
+	NextLSN  uint64
+	NextTime time.Time
+}
+
+// ReplayUntil reads the log in r and calls fn, if set, for each entry
+// logged after LSN after and before cut, in order, decoding records as
+// Replay does. Entries are taken in LSN order, so an entry stamped before
+// the cut's time behind one stamped after it is discarded too. A frame
+// cut short at the end of the log ends it, as Replay would truncate it;
+// other damage before the cut fails the replay, and past it only counts
+// as discarded. A log starting after the entry following after fails with
+// ErrHistoryGap. Like Scan it never modifies the log.
+func ReplayUntil(r io.Reader, after uint64, cut Cut, fn func(*LogEntry) error) (UntilResult, error) {
+	var res UntilResult
+	first, past := true, false
+	var stop error
+	err := scan(r, false, func(frame Frame) bool {
+		if errors.Is(frame.Err, ErrTruncated) {
+			return false
+		}
+		if past {
+			res.Discarded++
+			return true
+		}
+		if frame.Err != nil {
+			stop = fmt.Errorf("entry at offset %d: %w", frame.Offset, frame.Err)
+			return false
+		}
+		entry := frame.Entry
+		if first {
+			first = false
+			// A rename takes the LSN before its own as well
+			covers := uint64(1)
+			if entry.Op == types.OpRename {
+				covers = 2
+			}
+			if entry.LSN > after+covers {
+				stop = fmt.Errorf("%w: it starts at LSN %d, and the replay after %d", ErrHistoryGap, entry.LSN, after)
+				return false
+			}
+		}
+		switch {
+		case entry.LSN <= after:
+			res.Skipped++
+		case cut.passed(entry):
+			past = true
+			res.Discarded++
+			res.NextLSN, res.NextTime = entry.LSN, time.Unix(0, entry.Timestamp)
+		default:
+			if fn != nil {
+				if stop = fn(entry); stop != nil {
+					return false
+				}
+			}
+			res.Replayed++
+			res.LastLSN, res.LastTime = entry.LSN, time.Unix(0, entry.Timestamp)
+		}
+		return true
+	})
+	if err != nil {
+		return res, err
+	}
+	return res, stop
+}
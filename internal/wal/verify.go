@@ -0,0 +1,82 @@
+package wal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrOutOfOrder is an entry whose LSN is not above the one before it.
+var ErrOutOfOrder = errors.New("LSN out of order")
+
+// Problem is a fault Verify found in a log.
+type Problem struct {
+	Offset int64  `json:"offset"`
+	LSN    uint64 `json:"lsn,omitempty"` // of an entry out of order
+	Err    string `json:"error"`
+	// Tail is set for a frame cut short at the end of the log, as a crash
+	// mid-write leaves it. Opening the log truncates it away; any other
+	// problem stops the replay and is left for Repair.
+	Tail bool `json:"tail,omitempty"`
+}
+
+// VerifyResult is what Verify found in a log.
+type VerifyResult struct {
+	Entries  int       `json:"entries"` // intact ones
+	Bytes    int64     `json:"bytes"`
+	LastLSN  uint64    `json:"last_lsn"`
+	Problems []Problem `json:"problems,omitempty"`
+}
+
+// VerifyFile checks every entry of the log at path against its checksum
+// and the LSN of the entry before. Like Scan it never modifies the log.
+func VerifyFile(path string) (VerifyResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	defer f.Close()
+	return verify(f)
+}
+
+// Verify is VerifyFile for the open log, up to the last entry written when
+// it is called. It reads a file handle of its own, so writers go on while
+// it runs.
+func (w *WAL) Verify() (VerifyResult, error) {
+	w.syncMu.Lock()
+	w.mu.Lock()
+	err := w.flushUnlocked()
+	size := w.offset
+	var f *os.File
+	if err == nil {
+		f, err = os.Open(filepath.Join(w.dir, FileName)) // before a Rewrite can replace it
+	}
+	w.mu.Unlock()
+	w.syncMu.Unlock()
+	if err != nil {
+		return VerifyResult{}, err
+	}
+	defer f.Close()
+	return verify(io.NewSectionReader(f, 0, size))
+}
+
+func verify(r io.Reader) (VerifyResult, error) {
+	var res VerifyResult
+	err := Scan(r, func(frame Frame) bool {
+		res.Bytes += frame.Size
+		switch {
+		case frame.Err != nil:
+			res.Problems = append(res.Problems, Problem{Offset: frame.Offset, Err: frame.Err.Error(), Tail: errors.Is(frame.Err, ErrTruncated)})
+		case frame.Entry.LSN <= res.LastLSN:
+			err := fmt.Errorf("%w: %d after %d", ErrOutOfOrder, frame.Entry.LSN, res.LastLSN)
+			res.Problems = append(res.Problems, Problem{Offset: frame.Offset, LSN: frame.Entry.LSN, Err: err.Error()})
+		default:
+			res.Entries++
+			res.LastLSN = frame.Entry.LSN
+		}
+		return true
+	})
+	return res, err
+}
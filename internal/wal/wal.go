@@ -1,14 +1,23 @@
 package wal
 
 import (
+	"bufio"
+	"context"
 	"encoding/binary"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"hash/crc32"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sync"
 	"time"
 
+	"github.com/thirawat27/kvi/internal/format"
+	"github.com/thirawat27/kvi/internal/pack"
+	"github.com/thirawat27/kvi/internal/tracing"
+	"github.com/thirawat27/kvi/pkg/codec"
 	"github.com/thirawat27/kvi/pkg/types"
 )
 
@@ -17,8 +26,14 @@ type LogEntry struct {
 	Timestamp int64           `json:"timestamp"`
 	Op        types.Operation `json:"op"`
 	Key       string          `json:"key"`
-	Record    *types.Record   `json:"record"`
-	Checksum  uint32          `json:"checksum"`
+	// From is the key an OpRename moved Record away from, to Key.
+	From   string        `json:"from,omitempty"`
+	Record *types.Record `json:"record"`
+	// Packed is Record compressed, which the file holds in its place for
+	// records of at least the size Compress sets. Decoded entries have
+	// Record back and Packed nil.
+	Packed   []byte `json:"packed,omitempty"`
+	Checksum uint32 `json:"checksum"`
 }
 
 type WAL struct {
@@ -28,7 +43,38 @@ type WAL struct {
 	mu       sync.Mutex
 	lastLSN  uint64
 	offset   int64
+	header   int64 // size of the log's header, 0 for a log from before headers
 	batchCap int
+	writes   uint64
+	flushes  uint64
+	unsynced int       // entries written to the file since its last sync
+	synced   time.Time // when the file was last synced, or opened
+	syncLSN  uint64    // the last LSN synced
+	ship     shipper
+	tracer   *tracing.Tracer
+	closed   bool
+	readOnly bool // opened by OpenReadOnly
+	packMin  int  // records serializing to this many bytes are logged packed
+	packs    pack.Counter
+	codec    codec.Codec
+
+	// Group commit, once started: Commit joins group and wakes the
+	// committer with kick; full cuts its wait for more writers short.
+	// syncMu is held across every sync and taken before mu, so a sync
+	// outside mu does not overlap another.
+	syncMu   sync.Mutex
+	group    *group
+	interval time.Duration
+	kick     chan struct{}
+	full     chan struct{}
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// group is the writers waiting for the same commit.
+type group struct {
+	done chan struct{}
+	err  error
 }
 
 func NewWAL(dir string) (*WAL, error) {
@@ -36,7 +82,7 @@ func NewWAL(dir string) (*WAL, error) {
 		return nil, err
 	}
 
-	path := filepath.Join(dir, "kvi.wal")
+	path := filepath.Join(dir, FileName)
 	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
 	if err != nil {
 		return nil, err
@@ -44,92 +90,584 @@ func NewWAL(dir string) (*WAL, error) {
 
 	stat, err := file.Stat()
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
 
+	// A new log gets a header; an old one without is left as it is
+	var header int64
+	if stat.Size() == 0 {
+		header, err = writeHeader(file)
+	} else {
+		_, header, err = ReadHeader(io.NewSectionReader(file, 0, stat.Size()))
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
 	return &WAL{
 		dir:      dir,
 		file:     file,
 		buffer:   make([]*LogEntry, 0),
 		batchCap: 1000,
+		offset:   max(stat.Size(), header),
+		header:   header,
+		synced:   time.Now(),
+		codec:    codec.JSON,
+	}, nil
+}
+
+// OpenReadOnly opens the log in dir to replay it and nothing else. Unlike
+// NewWAL it creates neither dir nor the log, writes no header to an empty
+// one, and Replay leaves a torn tail where it is; writes fail with
+// types.ErrReadOnly.
+func OpenReadOnly(dir string) (*WAL, error) {
+	path := filepath.Join(dir, FileName)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	var header int64
+	if stat.Size() > 0 {
+		if _, header, err = ReadHeader(io.NewSectionReader(file, 0, stat.Size())); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return &WAL{
+		dir:      dir,
+		file:     file,
+		batchCap: 1000,
 		offset:   stat.Size(),
+		header:   header,
+		synced:   time.Now(),
+		codec:    codec.JSON,
+		readOnly: true,
 	}, nil
 }
 
+// writeHeader writes the header of a log in the current format to f and
+// syncs it.
+func writeHeader(f *os.File) (int64, error) {
+	n, err := f.Write(encodeHeader(format.WAL.Stamp(format.WAL.Latest().Version)))
+	if err == nil {
+		err = f.Sync()
+	}
+	return int64(n), err
+}
+
+// ReplayOptions tunes Replay.
+type ReplayOptions struct {
+	// Workers decode entries in parallel, which is most of the work; fn
+	// is still called one entry at a time, in log order. 0 or 1 decodes
+	// each entry as it is read.
+	Workers int
+	// Progress, if set, is called after each entry with the bytes of the
+	// log replayed so far and in all.
+	Progress func(done, total int64)
+}
+
+// Replay calls fn for every entry in the log, oldest first, and carries
+// on LSNs after the last. It must run before anything is written. A frame
+// cut short at the end of the log, as a crash mid-write leaves it, is
+// truncated away; damage anywhere else stops the replay with the frame's
+// error, since replaying past it could resurrect deleted keys, and is left
+// for Repair.
+func (w *WAL) Replay(opts ReplayOptions, fn func(*LogEntry) error) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var replayed int
+	end := w.header
+	var fnErr, damage error
+	err := replayScan(io.NewSectionReader(w.file, 0, w.offset), opts.Workers, func(frame Frame) bool {
+		if errors.Is(frame.Err, ErrTruncated) {
+			return false
+		}
+		if frame.Err != nil {
+			damage = fmt.Errorf("entry at offset %d: %w", frame.Offset, frame.Err)
+			return false
+		}
+		if frame.Codec != w.codec.Name() {
+			damage = fmt.Errorf("%w: entry at offset %d is %s, and the codec is %s", ErrCodecMismatch, frame.Offset, frame.Codec, w.codec.Name())
+			return false
+		}
+		if fnErr = fn(frame.Entry); fnErr != nil {
+			return false
+		}
+		replayed++
+		end = frame.Offset + frame.Size
+		w.lastLSN = max(w.lastLSN, frame.Entry.LSN)
+		if opts.Progress != nil {
+			opts.Progress(end, w.offset)
+		}
+		return true
+	})
+	w.ship.floor, w.ship.last = w.lastLSN, w.lastLSN // replayed entries are not shipped
+	switch {
+	case err != nil:
+		return replayed, err
+	case damage != nil:
+		return replayed, damage
+	case fnErr != nil:
+		return replayed, fnErr
+	}
+	if end < w.offset && !w.readOnly {
+		if err := w.file.Truncate(end); err != nil {
+			return replayed, err
+		}
+		w.offset = end
+	}
+	return replayed, nil
+}
+
 func (w *WAL) WriteEntry(op types.Operation, key string, rec *types.Record) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	w.lastLSN++
-	entry := &LogEntry{
-		LSN:       w.lastLSN,
-		Timestamp: time.Now().UnixNano(),
-		Op:        op,
-		Key:       key,
-		Record:    rec,
+	entry, err := w.newEntry(op, "", key, rec)
+	if err != nil {
+		return err
 	}
+	return w.appendLocked(entry, false)
+}
+
+// WriteRename logs rec moving from the key from to key as one OpRename
+// entry, which replay applies as the put of key and the delete of from, so
+// a crash keeps both or neither. It takes two LSNs, one for each. With
+// through set it writes the entry to the file before returning, as
+// WriteThrough does.
+func (w *WAL) WriteRename(from, key string, rec *types.Record, through bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
 
-	// Calculate CRC32 excluding Checksum field obviously
-	data, err := json.Marshal(entry)
+	entry, err := w.newEntry(types.OpRename, from, key, rec)
 	if err != nil {
 		return err
 	}
-	entry.Checksum = crc32.ChecksumIEEE(data)
+	return w.appendLocked(entry, through)
+}
 
+// appendLocked buffers and ships entry. With through set it writes the
+// buffer to the file; otherwise the buffer is flushed once it is full.
+func (w *WAL) appendLocked(entry *LogEntry, through bool) error {
+	if w.readOnly {
+		return types.ErrReadOnly
+	}
 	w.buffer = append(w.buffer, entry)
+	w.writes++
+	w.shipLocked(entry)
+	if through {
+		return w.writeBufferLocked()
+	}
 
-	// Batch flush
+	// Batch flush, by the committer if there is one
 	if len(w.buffer) >= w.batchCap {
+		if w.kick != nil {
+			signal(w.full)
+			signal(w.kick)
+			return nil
+		}
 		return w.flushUnlocked()
 	}
 
 	return nil
 }
 
-func (w *WAL) Flush() error {
+// GroupCommit starts committing entries in groups: writers calling Commit
+// at about the same time share one write and sync of everything written
+// meanwhile, done by a goroutine of the WAL's own, and WriteEntry no
+// longer flushes inline. A group gathers writers for up to interval after
+// the first arrives; with 0 it is whoever arrived during the last sync.
+func (w *WAL) GroupCommit(interval time.Duration) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
-	return w.flushUnlocked()
+
+	if w.kick != nil {
+		return
+	}
+	w.interval = interval
+	w.kick, w.full = make(chan struct{}, 1), make(chan struct{}, 1)
+	w.stop, w.stopped = make(chan struct{}), make(chan struct{})
+	go w.commitLoop(w.stop)
 }
 
-func (w *WAL) flushUnlocked() error {
-	if len(w.buffer) == 0 {
+// Commit returns once every entry written so far is synced. Without
+// GroupCommit it is Flush.
+func (w *WAL) Commit() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return os.ErrClosed
+	}
+	if w.kick == nil {
+		defer w.mu.Unlock()
+		return w.flushUnlocked()
+	}
+	if len(w.buffer) == 0 && w.unsynced == 0 {
+		w.mu.Unlock()
 		return nil
 	}
+	if w.group == nil {
+		w.group = &group{done: make(chan struct{})}
+	}
+	g := w.group
+	w.mu.Unlock()
+
+	signal(w.kick)
+	<-g.done
+	return g.err
+}
+
+func (w *WAL) commitLoop(stop <-chan struct{}) {
+	defer close(w.stopped)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-w.kick:
+		}
+		if w.interval > 0 {
+			timer := time.NewTimer(w.interval)
+			select {
+			case <-timer.C:
+			case <-w.full:
+			case <-stop:
+				timer.Stop()
+				return // Close commits what is left
+			}
+			timer.Stop()
+		} else {
+			runtime.Gosched() // let writers that are ready join the group
+		}
+		w.commitGroup()
+	}
+}
+
+// commitGroup writes and syncs the buffered entries, then wakes the group
+// waiting for them. The sync runs outside mu, so writers can go on
+// appending for the next group meanwhile.
+func (w *WAL) commitGroup() {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+
+	w.mu.Lock()
+	g := w.group
+	w.group = nil
+	err := w.writeBufferLocked()
+	file, tracer, lsn, n := w.file, w.tracer, w.lastLSN, w.unsynced
+	w.mu.Unlock()
+
+	if err == nil && n > 0 {
+		_, span := tracer.Start(context.Background(), "wal.flush")
+		err = file.Sync()
+		tracing.End(span, err)
+		if err == nil {
+			w.mu.Lock()
+			w.syncedLocked(n, lsn)
+			w.mu.Unlock()
+		}
+	}
+	if g != nil {
+		g.err = err
+		close(g.done)
+	}
+}
+
+// stopGroupCommit stops the committer, letting a commit under way finish.
+func (w *WAL) stopGroupCommit() {
+	w.mu.Lock()
+	stop := w.stop
+	w.stop = nil
+	w.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-w.stopped
+	}
+}
+
+// signal wakes whoever waits on c, unless it is already due to wake.
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// WriteThrough is WriteEntry that writes the entry, and any buffered before
+// it, to the file before returning, so it survives the process crashing.
+// It does not sync: an OS crash can still lose it until the next Flush.
+func (w *WAL) WriteThrough(op types.Operation, key string, rec *types.Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	entry, err := w.newEntry(op, "", key, rec)
+	if err != nil {
+		return err
+	}
+	return w.appendLocked(entry, true)
+}
 
-	for _, entry := range w.buffer {
-		data, err := json.Marshal(entry)
+// writeBufferLocked writes the buffered entries to the file.
+func (w *WAL) writeBufferLocked() error {
+	for i, entry := range w.buffer {
+		n, err := writeFramed(w.file, w.codec, entry)
 		if err != nil {
+			w.buffer = w.buffer[:copy(w.buffer, w.buffer[i:])]
 			return err
 		}
+		w.offset += n
+		w.unsynced++
+	}
+	w.buffer = w.buffer[:0]
+	return nil
+}
 
-		// Length prefix
-		var lengthBuf [4]byte
-		binary.LittleEndian.PutUint32(lengthBuf[:], uint32(len(data)))
+func (w *WAL) newEntry(op types.Operation, from, key string, rec *types.Record) (*LogEntry, error) {
+	w.lastLSN++
+	if op == types.OpRename {
+		w.lastLSN++ // its put is shipped at the LSN before
+	}
+	entry := &LogEntry{
+		LSN:       w.lastLSN,
+		Timestamp: time.Now().UnixNano(),
+		Op:        op,
+		Key:       key,
+		From:      from,
+		Record:    rec,
+	}
 
-		if _, err := w.file.Write(lengthBuf[:]); err != nil {
-			return err
+	// Calculate CRC32 excluding Checksum field obviously. It covers the
+	// record uncompressed, so it does not change with how it is stored.
+	data, err := w.codec.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	entry.Checksum = crc32.ChecksumIEEE(data)
+
+	if w.packMin > 0 && rec != nil {
+		raw, err := w.codec.Marshal(rec)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) >= w.packMin {
+			if entry.Packed = pack.Encode(raw); entry.Packed != nil {
+				w.packs.Add(len(raw), len(entry.Packed))
+			}
 		}
+	}
+	return entry, nil
+}
 
-		if _, err := w.file.Write(data); err != nil {
-			return err
+// SetCodec sets the codec entries are written with, JSON by default.
+// Replay refuses a log holding entries written with another.
+func (w *WAL) SetCodec(c codec.Codec) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.codec = c
+}
+
+// Compress logs records that serialize to at least minBytes compressed
+// with zstd; 0, the default, logs them as they are. Entries keep their
+// records in memory either way, for shipping.
+func (w *WAL) Compress(minBytes int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.packMin = minBytes
+}
+
+// writeFramed writes entry with its length prefix and returns the bytes written.
+func writeFramed(f io.Writer, c codec.Codec, entry *LogEntry) (int64, error) {
+	if entry.Packed != nil {
+		packed := *entry
+		packed.Record = nil
+		entry = &packed
+	}
+	data, err := c.Marshal(entry)
+	if err != nil {
+		return 0, err
+	}
+
+	// Length prefix
+	var lengthBuf [4]byte
+	binary.LittleEndian.PutUint32(lengthBuf[:], uint32(len(data)))
+
+	if _, err := f.Write(lengthBuf[:]); err != nil {
+		return 0, err
+	}
+	if _, err := f.Write(data); err != nil {
+		return 0, err
+	}
+	return 4 + int64(len(data)), nil
+}
+
+// SetTracer reports each flush that syncs the file as a wal.flush span;
+// nil, the default, reports none.
+func (w *WAL) SetTracer(t *tracing.Tracer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.tracer = t
+}
+
+// Flush writes the buffered entries to the file and syncs it, so every
+// entry written so far survives a crash.
+func (w *WAL) Flush() error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flushUnlocked()
+}
+
+// flushUnlocked is Flush for callers holding mu, and syncMu if a
+// committer may be running. The sync covers every entry, so it also
+// commits any group waiting.
+func (w *WAL) flushUnlocked() error {
+	err := w.writeBufferLocked()
+	if err == nil && w.unsynced > 0 {
+		_, span := w.tracer.Start(context.Background(), "wal.flush")
+		err = w.file.Sync()
+		tracing.End(span, err)
+		if err == nil {
+			w.syncedLocked(w.unsynced, w.lastLSN)
 		}
-		w.offset += 4 + int64(len(data))
 	}
+	if g := w.group; g != nil {
+		w.group = nil
+		g.err = err
+		close(g.done)
+	}
+	return err
+}
 
+// syncedLocked records a sync of the n entries written up to lsn.
+func (w *WAL) syncedLocked(n int, lsn uint64) {
+	w.unsynced -= n
+	w.synced = time.Now()
+	w.syncLSN = max(w.syncLSN, lsn)
+	w.flushes++
+}
+
+// Check reports whether the log can still be appended to: buffered entries
+// are flushed and the file synced, surfacing a full disk or closed file.
+func (w *WAL) Check() error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.flushUnlocked(); err != nil {
+		return err
+	}
 	if err := w.file.Sync(); err != nil {
 		return err
 	}
+	w.synced = time.Now()
+	w.syncLSN = w.lastLSN
+	return nil
+}
 
-	// reset buffer
-	w.buffer = w.buffer[:0]
+// Stats returns the log's size and activity counters.
+func (w *WAL) Stats() types.WALStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stats := types.WALStats{
+		Path:      filepath.Join(w.dir, FileName),
+		SizeBytes: w.offset,
+		Buffered:  len(w.buffer),
+		Writes:    w.writes,
+		Flushes:   w.flushes,
+		LastLSN:   w.lastLSN,
+		Pending:   len(w.buffer) + w.unsynced,
+		SinceSync: time.Since(w.synced).Milliseconds(),
+		SyncedLSN: w.syncLSN,
+	}
+	if w.packMin > 0 {
+		stats.Compression = w.packs.Stats()
+	}
+	return stats
+}
+
+// Rewrite replaces the log with the puts emitted by fn, typically one per
+// live record, so it no longer carries overwritten or deleted history. The
+// new log is written beside the old one, synced, and renamed over it; the
+// caller must keep other writers out until Rewrite returns.
+func (w *WAL) Rewrite(fn func(put func(key string, rec *types.Record) error) error) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.readOnly {
+		return types.ErrReadOnly
+	}
+	if err := w.flushUnlocked(); err != nil {
+		return err
+	}
+
+	path := filepath.Join(w.dir, FileName)
+	tmp, err := os.OpenFile(path+".rewrite", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	buf := bufio.NewWriter(tmp)
+	hdr := encodeHeader(format.WAL.Stamp(format.WAL.Latest().Version))
+	size := int64(len(hdr))
+	buf.Write(hdr) // an error sticks, for Flush to return
+	err = fn(func(key string, rec *types.Record) error {
+		entry, err := w.newEntry(types.OpPut, "", key, rec)
+		if err != nil {
+			return err
+		}
+		n, err := writeFramed(buf, w.codec, entry)
+		size += n
+		return err
+	})
+	if err == nil {
+		err = buf.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Windows cannot rename over an open file. If the rename fails the old
+	// log is reopened as it was.
+	w.file.Close()
+	renameErr := os.Rename(tmp.Name(), path)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	if renameErr != nil {
+		return renameErr
+	}
+	w.offset, w.header = size, int64(len(hdr))
 	return nil
 }
 
 func (w *WAL) Close() error {
+	w.stopGroupCommit()
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	w.closed = true
+	w.ship.closeLocked()
 	if err := w.flushUnlocked(); err != nil {
 		return err
 	}
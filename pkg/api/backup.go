@@ -0,0 +1,195 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/pkg/config"
+)
+
+// checksumHeader carries the SHA-256 of a backup stream: as a trailer on
+// GET /api/v1/backup, and optionally as a request header on restore.
+const checksumHeader = "X-Kvi-Checksum"
+
+// WithBackupS3 lets GET /api/v1/backup?dest=s3://bucket/prefix write the
+// backup to the object store cfg reaches, rather than send it.
+func WithBackupS3(cfg config.S3Config) func(*Server) {
+	return func(s *Server) { s.backupS3 = &cfg }
+}
+
+// handleBackup streams a backup of every record. The body is sent chunked
+// as it is produced; the checksum follows in the X-Kvi-Checksum trailer.
+// With ?dest the backup goes to object storage instead (see backupTo).
+func (s *Server) handleBackup(w http.ResponseWriter, r *http.Request) {
+	if dest := r.URL.Query().Get("dest"); dest != "" {
+		s.backupTo(w, r, dest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="kvi-%s.kvibak"`, time.Now().UTC().Format("20060102-150405")))
+	w.Header().Set("Trailer", checksumHeader+", X-Kvi-Records")
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{}) // large backups outlive the server WriteTimeout
+
+	sum, err := backup.Dump(r.Context(), s.engine, w)
+	if err != nil {
+		// Too late for a status code; an absent checksum tells the client
+		// the stream is incomplete.
+		return
+	}
+	w.Header().Set(checksumHeader, sum.Checksum)
+	w.Header().Set("X-Kvi-Records", fmt.Sprint(sum.Records))
+}
+
+// backupTo writes a backup under dest, an s3://bucket/prefix URL, and
+// answers with where it went and its summary.
+func (s *Server) backupTo(w http.ResponseWriter, r *http.Request, dest string) {
+	if s.backupS3 == nil {
+		http.Error(w, `{"error":"backups to a destination are not enabled on this server"}`, http.StatusNotImplemented)
+		return
+	}
+	if !strings.HasPrefix(dest, "s3://") {
+		http.Error(w, `{"error":"dest must be an s3:// URL"}`, http.StatusBadRequest)
+		return
+	}
+	store, err := backup.NewStore(dest, *s.backupS3)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	name := backup.NewName(time.Now())
+	sum, err := backup.Save(r.Context(), store, name, func(bw io.Writer) (backup.Summary, error) {
+		return backup.Dump(r.Context(), s.engine, bw)
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, "backup to "+dest+" failed: "+err.Error()), http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, map[string]interface{}{
+		"status":   "ok",
+		"location": store.Location(name),
+		"records":  sum.Records,
+		"bytes":    sum.Bytes,
+		"checksum": sum.Checksum,
+	})
+}
+
+// handleRestore loads a backup produced by GET /api/v1/backup. The upload is
+// spooled to a temporary file and verified — format, and the X-Kvi-Checksum
+// header if sent — before any data is touched. By default the store is
+// replaced by the backup's contents; ?mode=merge applies it on top, newer
+// versions winning (see backup.Merge). ?dry_run=true only reports what the
+// restore would do, with a description of the backup.
+func (s *Server) handleRestore(w http.ResponseWriter, r *http.Request) {
+	strategy, err := backup.ParseStrategy(r.URL.Query().Get("mode"))
+	if err != nil {
+		http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, `{"error":"dry_run must be true or false"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	if !s.maintenance.TryLock() {
+		http.Error(w, `{"error":"a restore or maintenance job is already in progress"}`, http.StatusConflict)
+		return
+	}
+	defer s.maintenance.Unlock()
+
+	_ = http.NewResponseController(w).SetReadDeadline(time.Time{})
+
+	spool, err := os.CreateTemp("", "kvi-restore-*")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	checksum, size, err := backup.Checksum(io.TeeReader(r.Body, spool))
+	if tooLarge(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, `{"error":"reading upload: `+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	if want := r.Header.Get(checksumHeader); want != "" && !strings.EqualFold(want, checksum) {
+		http.Error(w, fmt.Sprintf(`{"error":"checksum mismatch: got %s"}`, checksum), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if dryRun {
+		s.dryRunRestore(w, r, spool, strategy)
+		return
+	}
+	res, err := backup.Restore(r.Context(), s.engine, spool, strategy)
+	if errors.Is(err, backup.ErrInvalid) {
+		http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":"restore stopped after %d records: %s"}`, res.Restored, err), http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, map[string]interface{}{
+		"status":   "ok",
+		"restored": res.Restored,
+		"skipped":  res.Skipped,
+		"expired":  res.Expired,
+		"removed":  res.Removed,
+		"bytes":    size,
+		"checksum": checksum,
+	})
+}
+
+// dryRunRestore answers a dry run: the backup's description, and the
+// counts the restore would produce. A backup the restore would reject is
+// still described, with its problems and no counts.
+func (s *Server) dryRunRestore(w http.ResponseWriter, r *http.Request, spool *os.File, strategy backup.Strategy) {
+	report, err := backup.Verify(spool, backup.Expect{VectorDim: backup.VectorDim(s.engine)})
+	if err != nil {
+		http.Error(w, `{"error":"`+backup.ErrInvalid.Error()+`: `+err.Error()+`"}`, http.StatusBadRequest)
+		return
+	}
+	resp := map[string]interface{}{
+		"status":   "ok",
+		"dry_run":  true,
+		"bytes":    report.Bytes,
+		"checksum": report.Checksum,
+		"backup":   report,
+	}
+	if !report.OK() {
+		resp["status"] = "invalid"
+		jsonOK(w, resp)
+		return
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	res, err := backup.DryRun(r.Context(), s.engine, spool, strategy)
+	if err != nil {
+		http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusInternalServerError)
+		return
+	}
+	resp["restored"], resp["skipped"], resp["expired"], resp["removed"] = res.Restored, res.Skipped, res.Expired, res.Removed
+	jsonOK(w, resp)
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// Batch write modes.
+const (
+	batchPut   = "put"   // replace each record, as /api/v1/put does
+	batchMerge = "merge" // merge each record into the one stored (types.BatchMerger)
+)
+
+type batchRequest struct {
+	Mode    string       `json:"mode"` // batchPut if empty
+	Records []putRequest `json:"records"`
+}
+
+type batchResult struct {
+	Key     string `json:"key"`
+	Version uint64 `json:"version"`
+}
+
+// handleBatch writes every record in one engine call, in order, and
+// answers with the key and version each got. A failure stops the batch; the
+// records before it stay written.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	records := make([]*types.Record, len(req.Records))
+	for i, put := range req.Records {
+		if err := put.check(); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":%q}`, fmt.Sprintf("record %d: %v", i, err)), http.StatusBadRequest)
+			return
+		}
+		if err := s.fillKey(r.Context(), &put); err != nil {
+			writeEngineError(w, err)
+			return
+		}
+		records[i] = put.record()
+	}
+
+	var write func(context.Context, []*types.Record) error
+	switch req.Mode {
+	case "", batchPut:
+		if b, ok := s.engine.(types.Batcher); ok {
+			write = b.BatchPut
+		}
+	case batchMerge:
+		if m, ok := s.engine.(types.BatchMerger); ok {
+			write = m.BatchMerge
+		}
+	default:
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, "mode must be "+batchPut+" or "+batchMerge), http.StatusBadRequest)
+		return
+	}
+	if write == nil {
+		http.Error(w, `{"error":"this engine has no batch writes in that mode"}`, http.StatusNotImplemented)
+		return
+	}
+
+	ctx, cancel := routeContext(r, s.timeouts.Write)
+	defer cancel()
+	err := write(ctx, records)
+	if timedOut(w, r, ctx, "engine batch write", s.timeouts.Write) {
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	results := make([]batchResult, len(records))
+	for i, rec := range records {
+		results[i] = batchResult{Key: rec.ID, Version: rec.Version}
+	}
+	setWatermark(w, s.engine)
+	jsonOK(w, map[string]interface{}{"status": "ok", "records": results})
+}
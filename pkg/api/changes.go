@@ -0,0 +1,82 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// changeView is a change as the API returns it: Record is absent from a
+// tombstone.
+type changeView struct {
+	Key     string      `json:"key"`
+	At      uint64      `json:"at"`
+	Deleted bool        `json:"deleted,omitempty"`
+	Record  *recordView `json:"record,omitempty"`
+}
+
+// changesResponse is a page of changes; Next is the since of the next call.
+type changesResponse struct {
+	Items []changeView `json:"items"`
+	Count int          `json:"count"`
+	Next  uint64       `json:"next"`
+}
+
+// handleChanges lists the keys changed after ?since= (Unix nanoseconds, 0
+// for all), oldest first, up to ?limit= of them.
+func (s *Server) handleChanges(w http.ResponseWriter, r *http.Request) {
+	cs, ok := s.engine.(types.ChangeScanner)
+	if !ok {
+		http.Error(w, `{"error":"this engine has no change index"}`, http.StatusNotImplemented)
+		return
+	}
+	q := r.URL.Query()
+	var since uint64
+	if v := q.Get("since"); v != "" {
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, `{"error":"since must be a non-negative integer"}`, http.StatusBadRequest)
+			return
+		}
+		since = n
+	}
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, `{"error":"limit must be a non-negative integer"}`, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	consistency, ok := s.parseConsistency(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := routeContext(r, s.timeouts.Read)
+	defer cancel()
+	err := consistency.await(ctx, s.engine)
+	var changes []types.Change
+	next := since
+	if err == nil {
+		changes, next, err = cs.ScanSince(ctx, since, limit)
+	}
+	if timedOut(w, r, ctx, "change scan", s.timeouts.Read) {
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	items := make([]changeView, len(changes))
+	for i, change := range changes {
+		items[i] = changeView{Key: change.Key, At: change.At, Deleted: change.Deleted}
+		if change.Record != nil {
+			view := viewOf(change.Record)
+			items[i].Record = &view
+		}
+	}
+	jsonWithin(w, r, ctx, s.timeouts.Read, changesResponse{Items: items, Count: len(items), Next: next})
+}
@@ -0,0 +1,305 @@
+package api
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/thirawat27/kvi/pkg/collection"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// listRequest is the body of a push, or of a pop without values.
+type listRequest struct {
+	Key    string   `json:"key"`
+	Values []string `json:"values"`
+}
+
+type membersRequest struct {
+	Key     string   `json:"key"`
+	Members []string `json:"members"`
+}
+
+// handlePush is LPUSH (head) or RPUSH: it adds values to a list,
+// creating it, and answers with the list's length.
+func (s *Server) handlePush(push func(context.Context, types.Engine, string, ...string) (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req listRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Key == "" || len(req.Values) == 0 {
+			http.Error(w, `{"error":"key and values are required"}`, http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := routeContext(r, s.timeouts.Write)
+		defer cancel()
+		n, err := push(ctx, s.engine, req.Key, req.Values...)
+		if timedOut(w, r, ctx, "engine update", s.timeouts.Write) {
+			return
+		}
+		if err != nil {
+			writeEngineError(w, err)
+			return
+		}
+		jsonOK(w, map[string]int{"length": n})
+	}
+}
+
+// handlePop is LPOP or RPOP. An empty or missing list is a 404.
+func (s *Server) handlePop(pop func(context.Context, types.Engine, string) (string, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req listRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Key == "" {
+			http.Error(w, `{"error":"key is required"}`, http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := routeContext(r, s.timeouts.Write)
+		defer cancel()
+		value, err := pop(ctx, s.engine, req.Key)
+		if timedOut(w, r, ctx, "engine update", s.timeouts.Write) {
+			return
+		}
+		if err != nil {
+			writeEngineError(w, err)
+			return
+		}
+		jsonOK(w, map[string]string{"value": value})
+	}
+}
+
+// handleLRange lists ?start= to ?stop= (inclusive, default the whole
+// list) of the list at ?key=.
+func (s *Server) handleLRange(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key := q.Get("key")
+	if key == "" {
+		http.Error(w, `{"error":"key is required"}`, http.StatusBadRequest)
+		return
+	}
+	bounds := [2]int{0, -1}
+	for i, name := range []string{"start", "stop"} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				http.Error(w, `{"error":"`+name+` must be an integer"}`, http.StatusBadRequest)
+				return
+			}
+			bounds[i] = n
+		}
+	}
+	ctx, cancel := routeContext(r, s.timeouts.Read)
+	defer cancel()
+	values, err := collection.LRange(ctx, s.engine, key, bounds[0], bounds[1])
+	if timedOut(w, r, ctx, "engine get", s.timeouts.Read) {
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	jsonOK(w, map[string][]string{"values": values})
+}
+
+// handleSetChange is SADD or SREM, answering with how many members it
+// added or removed under result.
+func (s *Server) handleSetChange(change func(context.Context, types.Engine, string, ...string) (int, error), result string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req membersRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Key == "" || len(req.Members) == 0 {
+			http.Error(w, `{"error":"key and members are required"}`, http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := routeContext(r, s.timeouts.Write)
+		defer cancel()
+		n, err := change(ctx, s.engine, req.Key, req.Members...)
+		if timedOut(w, r, ctx, "engine update", s.timeouts.Write) {
+			return
+		}
+		if err != nil {
+			writeEngineError(w, err)
+			return
+		}
+		jsonOK(w, map[string]int{result: n})
+	}
+}
+
+// handleSMembers lists the members of the set at ?key=, sorted.
+func (s *Server) handleSMembers(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, `{"error":"key is required"}`, http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := routeContext(r, s.timeouts.Read)
+	defer cancel()
+	members, err := collection.SMembers(ctx, s.engine, key)
+	if timedOut(w, r, ctx, "engine get", s.timeouts.Read) {
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	jsonOK(w, map[string][]string{"members": members})
+}
+
+// handleSIsMember reports whether ?member= is in the set at ?key=.
+func (s *Server) handleSIsMember(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key := q.Get("key")
+	if key == "" || !q.Has("member") {
+		http.Error(w, `{"error":"key and member are required"}`, http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := routeContext(r, s.timeouts.Read)
+	defer cancel()
+	in, err := collection.SIsMember(ctx, s.engine, key, q.Get("member"))
+	if timedOut(w, r, ctx, "engine get", s.timeouts.Read) {
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	jsonOK(w, map[string]bool{"member": in})
+}
+
+type zaddRequest struct {
+	Key     string               `json:"key"`
+	Members []collection.ZMember `json:"members"`
+}
+
+type zincrbyRequest struct {
+	Key    string  `json:"key"`
+	Member string  `json:"member"`
+	Delta  float64 `json:"delta"`
+}
+
+// handleZAdd sets the scores of members of a sorted set, creating it, and
+// answers with how many it added.
+func (s *Server) handleZAdd(w http.ResponseWriter, r *http.Request) {
+	var req zaddRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Key == "" || len(req.Members) == 0 {
+		http.Error(w, `{"error":"key and members are required"}`, http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := routeContext(r, s.timeouts.Write)
+	defer cancel()
+	n, err := collection.ZAdd(ctx, s.engine, req.Key, req.Members...)
+	if timedOut(w, r, ctx, "engine update", s.timeouts.Write) {
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	jsonOK(w, map[string]int{"added": n})
+}
+
+// handleZIncrBy adds delta to a member's score and answers with the new
+// score.
+func (s *Server) handleZIncrBy(w http.ResponseWriter, r *http.Request) {
+	var req zincrbyRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Key == "" || req.Member == "" {
+		http.Error(w, `{"error":"key and member are required"}`, http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := routeContext(r, s.timeouts.Write)
+	defer cancel()
+	score, err := collection.ZIncrBy(ctx, s.engine, req.Key, req.Member, req.Delta)
+	if timedOut(w, r, ctx, "engine update", s.timeouts.Write) {
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	jsonOK(w, map[string]float64{"score": score})
+}
+
+// handleZRangeByScore lists the members of the sorted set at ?key= with
+// scores from ?min= to ?max= (inclusive, default unbounded; -inf and +inf
+// are accepted), lowest first or with ?rev=true highest first, paged by
+// ?offset= and ?limit=.
+func (s *Server) handleZRangeByScore(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key := q.Get("key")
+	if key == "" {
+		http.Error(w, `{"error":"key is required"}`, http.StatusBadRequest)
+		return
+	}
+	bounds := [2]float64{math.Inf(-1), math.Inf(1)}
+	for i, name := range []string{"min", "max"} {
+		if v := q.Get(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || math.IsNaN(f) {
+				http.Error(w, `{"error":"`+name+` must be a number"}`, http.StatusBadRequest)
+				return
+			}
+			bounds[i] = f
+		}
+	}
+	var opts collection.RangeOptions
+	for _, p := range []struct {
+		name string
+		to   *int
+	}{{"offset", &opts.Offset}, {"limit", &opts.Limit}} {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				http.Error(w, `{"error":"`+p.name+` must be a non-negative integer"}`, http.StatusBadRequest)
+				return
+			}
+			*p.to = n
+		}
+	}
+	opts.Reverse = q.Get("rev") == "true"
+	ctx, cancel := routeContext(r, s.timeouts.Read)
+	defer cancel()
+	members, err := collection.ZRangeByScore(ctx, s.engine, key, bounds[0], bounds[1], opts)
+	if timedOut(w, r, ctx, "engine get", s.timeouts.Read) {
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	jsonOK(w, map[string][]collection.ZMember{"members": members})
+}
+
+// handleZRank answers with the rank of ?member= in the sorted set at
+// ?key=, 0 being the lowest score or with ?rev=true the highest. A member
+// not in the set is a 404.
+func (s *Server) handleZRank(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	key, member := q.Get("key"), q.Get("member")
+	if key == "" || !q.Has("member") {
+		http.Error(w, `{"error":"key and member are required"}`, http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := routeContext(r, s.timeouts.Read)
+	defer cancel()
+	rank, err := collection.ZRank(ctx, s.engine, key, member, q.Get("rev") == "true")
+	if timedOut(w, r, ctx, "engine get", s.timeouts.Read) {
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	jsonOK(w, map[string]int{"rank": rank})
+}
@@ -0,0 +1,258 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Default response compression: a middle gzip level, and nothing under 1 KiB
+// where the framing overhead outweighs the savings.
+const (
+	DefaultCompressionLevel = 5
+	DefaultCompressMinBytes = 1024
+)
+
+// WithCompression sets the gzip level (1-9; zstd uses the nearest speed
+// preset) and the size a response must reach before it is compressed.
+// A level <= 0 disables response compression.
+func WithCompression(level, minBytes int) func(*Server) {
+	return func(s *Server) {
+		s.edit().compression = &compression{level: min(level, gzip.BestCompression), minBytes: minBytes}
+	}
+}
+
+// compression is a compression setting, with encoders pooled for its level.
+type compression struct {
+	level    int
+	minBytes int
+	gzipPool sync.Pool
+	zstdPool sync.Pool
+}
+
+// uncompressible lists content types sent as is: event streams must reach
+// the client line by line, and backups are already gzip.
+var uncompressible = map[string]bool{
+	"text/event-stream":  true,
+	"application/gzip":   true,
+	"application/zstd":   true,
+	"application/x-gzip": true,
+}
+
+// compress encodes responses with the best encoding the client accepts.
+func (s *Server) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := s.tunables().compression
+		if c.level <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, comp: c, encoding: encoding, status: http.StatusOK}
+		defer cw.close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks zstd or gzip from an Accept-Encoding header by
+// q-value, preferring zstd on a tie. It returns "" for neither.
+func negotiateEncoding(header string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[strings.ToLower(strings.TrimSpace(name))] = weight
+	}
+	weight := func(name string) float64 {
+		if w, ok := q[name]; ok {
+			return w
+		}
+		return q["*"]
+	}
+	zw, gw := weight("zstd"), weight("gzip")
+	switch {
+	case zw > 0 && zw >= gw:
+		return "zstd"
+	case gw > 0:
+		return "gzip"
+	}
+	return ""
+}
+
+// encoder is the part of gzip.Writer and zstd.Encoder the middleware uses.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+type gzipEncoder struct{ *gzip.Writer }
+
+func (e gzipEncoder) Reset(w io.Writer) { e.Writer.Reset(w) }
+
+type zstdEncoder struct{ *zstd.Encoder }
+
+func (e zstdEncoder) Reset(w io.Writer) { e.Encoder.Reset(w) }
+
+func (c *compression) encoderPool(encoding string) *sync.Pool {
+	if encoding == "zstd" {
+		return &c.zstdPool
+	}
+	return &c.gzipPool
+}
+
+func (c *compression) getEncoder(encoding string, w io.Writer) encoder {
+	if enc, ok := c.encoderPool(encoding).Get().(encoder); ok {
+		enc.Reset(w)
+		return enc
+	}
+	if encoding == "zstd" {
+		z, _ := zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel(c.level)), zstd.WithEncoderConcurrency(1))
+		return zstdEncoder{z}
+	}
+	g, _ := gzip.NewWriterLevel(w, c.level)
+	return gzipEncoder{g}
+}
+
+// zstdLevel maps a gzip level onto zstd's speed presets.
+func zstdLevel(level int) zstd.EncoderLevel {
+	switch {
+	case level <= 2:
+		return zstd.SpeedFastest
+	case level <= 6:
+		return zstd.SpeedDefault
+	case level <= 8:
+		return zstd.SpeedBetterCompression
+	}
+	return zstd.SpeedBestCompression
+}
+
+// compressWriter buffers the start of a response until it is known to be
+// worth compressing: it reaches the size threshold or the handler flushes
+// (a stream). Small responses go out unchanged, with their Content-Length.
+type compressWriter struct {
+	http.ResponseWriter
+	comp     *compression
+	encoding string
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	enc         encoder // nil when passing through
+	buf         []byte
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.status = code
+	w.wroteHeader = true
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		w.decide(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.comp.minBytes {
+			return len(p), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// decide sends the headers, compressing if want and the response allows
+// it, then writes out anything buffered.
+func (w *compressWriter) decide(want bool) error {
+	w.decided = true
+	h := w.Header()
+	if want && h.Get("Content-Encoding") == "" && !uncompressible[mediaType(h.Get("Content-Type"))] {
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(w.buf))
+		}
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag) // the bytes differ from the identity representation
+		}
+		w.enc = w.comp.getEncoder(w.encoding, w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// Flush commits to compression — a flushing handler is streaming — and
+// pushes the encoded bytes through to the client.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(true)
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) close() {
+	if !w.decided {
+		if !w.wroteHeader {
+			return // nothing written; let net/http send its default response
+		}
+		if w.Header().Get("Content-Length") == "" && w.Header().Get("Trailer") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(w.buf)))
+		}
+		w.decide(false)
+		return
+	}
+	if w.enc != nil {
+		w.enc.Close()
+		w.enc.Reset(nil)
+		w.comp.encoderPool(w.encoding).Put(w.enc)
+	}
+}
+
+func mediaType(contentType string) string {
+	mt, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mt))
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// errBadPrecondition marks an If-Match / If-None-Match header we cannot parse.
+var errBadPrecondition = errors.New("invalid If-Match or If-None-Match header")
+
+func setETag(w http.ResponseWriter, version uint64) {
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatUint(version, 10)))
+}
+
+// precondition turns the request's conditional headers into the version a
+// CompareAndSwap must see:
+//
+//	If-Match: "<version>"  the record must be at that version
+//	If-Match: *            the record must exist (at whatever version it has now)
+//	If-None-Match: *       the record must not exist (create-only)
+//
+// conditional is false when neither header is present.
+func (s *Server) precondition(r *http.Request, key string) (version uint64, conditional bool, err error) {
+	if m := strings.TrimSpace(r.Header.Get("If-None-Match")); m != "" {
+		if m != "*" {
+			return 0, false, errBadPrecondition
+		}
+		return 0, true, nil
+	}
+
+	m := strings.TrimSpace(r.Header.Get("If-Match"))
+	if m == "" {
+		return 0, false, nil
+	}
+	if m == "*" {
+		rec, err := s.engine.Get(r.Context(), key)
+		if err != nil {
+			return 0, false, &types.VersionMismatchError{Key: key}
+		}
+		return rec.Version, true, nil
+	}
+	version, err = strconv.ParseUint(strings.Trim(strings.TrimPrefix(m, "W/"), `"`), 10, 64)
+	if err != nil || version == 0 {
+		return 0, false, errBadPrecondition
+	}
+	return version, true, nil
+}
+
+// writeConditionalError answers a failed write: 412 with the current version
+// for a precondition mismatch, 400 for a malformed header, and otherwise as
+// writeEngineError does.
+func writeConditionalError(w http.ResponseWriter, err error) {
+	var mismatch *types.VersionMismatchError
+	switch {
+	case errors.As(err, &mismatch):
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusPreconditionFailed)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":           "precondition failed",
+			"current_version": mismatch.Current,
+		})
+	case errors.Is(err, errBadPrecondition):
+		http.Error(w, `{"error":"`+err.Error()+`"}`, http.StatusBadRequest)
+	default:
+		writeEngineError(w, err)
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/thirawat27/kvi/pkg/config"
+)
+
+// ConfigSource lets the server report its configuration and reload it.
+type ConfigSource struct {
+	// Current returns the configuration in force.
+	Current func() *config.Config
+	// Reload re-reads the configuration and applies what can change
+	// without a restart (see config.Reload). Nil means it cannot.
+	Reload func() (*config.ReloadReport, error)
+}
+
+// WithConfig serves GET /api/v1/admin/config and POST
+// /api/v1/admin/reload from src.
+func WithConfig(src ConfigSource) func(*Server) {
+	return func(s *Server) { s.config = src }
+}
+
+// handleConfig reports the configuration in force, secrets redacted.
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if s.config.Current == nil {
+		http.Error(w, `{"error":"this server does not report its configuration"}`, http.StatusNotImplemented)
+		return
+	}
+	jsonOK(w, s.config.Current().Redacted())
+}
+
+// handleReload reloads the configuration, answering with what changed. A
+// configuration that fails to load or validate changes nothing.
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if s.config.Reload == nil {
+		http.Error(w, `{"error":"this server cannot reload its configuration"}`, http.StatusNotImplemented)
+		return
+	}
+	report, err := s.config.Reload()
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, "reload failed: "+err.Error()), http.StatusInternalServerError)
+		return
+	}
+	jsonOK(w, report)
+}
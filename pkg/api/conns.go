@@ -0,0 +1,95 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// WithConnLimits counts open connections in conns and SSE subscriptions in
+// streams; either may be nil. Requests on a connection beyond the conns
+// limit, and subscriptions beyond the streams limit, get 503. A
+// subscription moves its connection from conns to streams, so long-lived
+// subscribers cannot use up the room left for ordinary requests. Pass the
+// same pools to the gRPC server to share the limits with it.
+func WithConnLimits(conns, streams *stats.Pool) func(*Server) {
+	return func(s *Server) { s.conns, s.streams = conns, streams }
+}
+
+// connSlot is a connection's place in the conns pool, if it got one.
+type connSlot struct {
+	admitted bool
+	released atomic.Bool
+}
+
+type connSlotKey struct{}
+
+// release gives the place back, once.
+func (c *connSlot) release(pool *stats.Pool) {
+	if c.admitted && c.released.CompareAndSwap(false, true) {
+		pool.Release()
+	}
+}
+
+// connTracker admits connections as http.Server accepts them and releases
+// them as they close. A connection is admitted or not for its lifetime.
+type connTracker struct {
+	pool  *stats.Pool
+	slots sync.Map // net.Conn → *connSlot
+}
+
+func (t *connTracker) connContext(ctx context.Context, c net.Conn) context.Context {
+	slot := &connSlot{admitted: t.pool.Acquire()}
+	t.slots.Store(c, slot)
+	return context.WithValue(ctx, connSlotKey{}, slot)
+}
+
+func (t *connTracker) connState(c net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+	if slot, ok := t.slots.LoadAndDelete(c); ok {
+		slot.(*connSlot).release(t.pool)
+	}
+}
+
+// limitConns turns away requests on connections beyond the conns limit,
+// closing the connection after the 503. Requests served outside Serve, as
+// through Handler in tests, have no slot and are let through.
+func (s *Server) limitConns(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slot, ok := r.Context().Value(connSlotKey{}).(*connSlot); ok && !slot.admitted {
+			w.Header().Set("Connection", "close")
+			writeConnLimit(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquireStream takes a place in the streams pool for a subscription,
+// giving back its connection's place in the conns pool. The connection
+// closes when the subscription ends, as it holds no conns place any more.
+// It writes a 503 and returns false when the streams pool is full.
+func (s *Server) acquireStream(w http.ResponseWriter, r *http.Request) bool {
+	if !s.streams.Acquire() {
+		writeConnLimit(w)
+		return false
+	}
+	if slot, ok := r.Context().Value(connSlotKey{}).(*connSlot); ok {
+		slot.release(s.conns)
+		w.Header().Set("Connection", "close")
+	}
+	return true
+}
+
+func writeConnLimit(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, fmt.Sprintf(`{"error":%q}`, types.ErrConnectionLimit.Error()), http.StatusServiceUnavailable)
+}
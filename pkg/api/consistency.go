@@ -0,0 +1,90 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// watermarkHeader carries, on a write's response, the watermark a later
+// read can pass as ?after= to be sure of seeing it.
+const watermarkHeader = "X-Kvi-Watermark"
+
+// readConsistency is how up to date a read asked to be, with
+// ?consistency= and ?after=.
+type readConsistency struct {
+	level types.Consistency
+	after uint64
+}
+
+// parseConsistency reads a request's consistency, answering 400 for one
+// it does not understand and 501 for strong reads of an engine with no WAL
+// to sync.
+func (s *Server) parseConsistency(w http.ResponseWriter, r *http.Request) (readConsistency, bool) {
+	q := r.URL.Query()
+	level, err := types.ParseConsistency(q.Get("consistency"))
+	if err != nil {
+		http.Error(w, `{"error":"consistency must be eventual, read-your-writes or strong"}`, http.StatusBadRequest)
+		return readConsistency{}, false
+	}
+	c := readConsistency{level: level}
+	if v := q.Get("after"); v != "" {
+		if c.after, err = strconv.ParseUint(v, 10, 64); err != nil {
+			http.Error(w, `{"error":"after must be a watermark from the X-Kvi-Watermark header"}`, http.StatusBadRequest)
+			return readConsistency{}, false
+		}
+	}
+	_, watermarker := s.engine.(types.Watermarker)
+	_, syncer := s.engine.(types.Syncer)
+	if level == types.ConsistencyStrong && !watermarker && !syncer {
+		http.Error(w, `{"error":"this engine has no WAL to sync for a strong read"}`, http.StatusNotImplemented)
+		return readConsistency{}, false
+	}
+	return c, true
+}
+
+// await waits until a read at c sees what it should, its indexes
+// included. Engines that are not watermarkers store each write before
+// acknowledging it, so only a strong read has anything more to wait for:
+// the WAL sync.
+func (c readConsistency) await(ctx context.Context, eng types.Engine) error {
+	if err := c.awaitWrites(ctx, eng); err != nil {
+		return err
+	}
+	if iq, ok := eng.(types.IndexQueuer); ok && c.level != types.ConsistencyEventual {
+		return iq.IndexBarrier(ctx)
+	}
+	return nil
+}
+
+func (c readConsistency) awaitWrites(ctx context.Context, eng types.Engine) error {
+	if wm, ok := eng.(types.Watermarker); ok {
+		return wm.Await(ctx, c.level, c.after)
+	}
+	if syncer, ok := eng.(types.Syncer); ok && c.level == types.ConsistencyStrong {
+		return syncer.Sync(ctx)
+	}
+	return nil
+}
+
+// scan wraps scan to await c before it starts.
+func (c readConsistency) scan(eng types.Engine, scan func(context.Context, string, func(*types.Record) bool) error) func(context.Context, string, func(*types.Record) bool) error {
+	if c.level == types.ConsistencyEventual {
+		return scan
+	}
+	return func(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+		if err := c.await(ctx, eng); err != nil {
+			return err
+		}
+		return scan(ctx, prefix, fn)
+	}
+}
+
+// setWatermark puts the engine's write watermark on a write's response.
+func setWatermark(w http.ResponseWriter, eng types.Engine) {
+	if wm, ok := eng.(types.Watermarker); ok {
+		w.Header().Set(watermarkHeader, strconv.FormatUint(wm.Watermark().Written, 10))
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/thirawat27/kvi/pkg/config"
+)
+
+// WithCORS replaces the default cross-origin policy.
+func WithCORS(policy config.CORSConfig) func(*Server) {
+	return func(s *Server) { s.edit().corsPolicy = policy }
+}
+
+// cors applies the server's CORS policy in front of mux. Preflight requests
+// are answered here, advertising only the methods the requested route
+// actually serves; requests from origins outside the policy get no CORS
+// headers, which makes the browser block them.
+func (s *Server) cors(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := s.tunables().corsPolicy
+		if p.Disabled {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		allowHeaders := strings.Join(p.AllowedHeaders, ", ")
+		exposeHeaders := strings.Join(p.ExposedHeaders, ", ")
+
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		allowed, wildcard := matchOrigin(p.AllowedOrigins, origin)
+		if origin == "" || !allowed {
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		if wildcard && !p.AllowCredentials {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if p.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if exposeHeaders != "" {
+				h.Set("Access-Control-Expose-Headers", exposeHeaders)
+			}
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		methods := routeMethods(mux, r, p.AllowedMethods)
+		if len(methods) == 0 {
+			http.NotFound(w, r) // no such route
+			return
+		}
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if allowHeaders == "*" {
+			h.Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+		} else if allowHeaders != "" {
+			h.Set("Access-Control-Allow-Headers", allowHeaders)
+		}
+		if p.MaxAge > 0 {
+			h.Set("Access-Control-Max-Age", strconv.Itoa(p.MaxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// matchOrigin reports whether origin is allowed by patterns, and whether
+// it was only matched by "*".
+func matchOrigin(patterns []string, origin string) (allowed, wildcard bool) {
+	if origin == "" {
+		return false, false
+	}
+	for _, p := range patterns {
+		switch {
+		case p == "*":
+			wildcard = true
+		case strings.EqualFold(p, origin):
+			return true, false
+		case strings.Contains(p, "://*."):
+			// https://*.example.com matches https://a.example.com and
+			// https://a.b.example.com, but not https://example.com
+			scheme, domain, _ := strings.Cut(p, "://*")
+			rest, ok := cutPrefixFold(origin, scheme+"://")
+			if ok && len(rest) > len(domain) && hasSuffixFold(rest, domain) {
+				return true, false
+			}
+		}
+	}
+	return wildcard, wildcard
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix) {
+		return s[len(prefix):], true
+	}
+	return s, false
+}
+
+func hasSuffixFold(s, suffix string) bool {
+	return len(s) >= len(suffix) && strings.EqualFold(s[len(s)-len(suffix):], suffix)
+}
+
+// routeMethods returns which of candidates the mux routes for r's path.
+func routeMethods(mux *http.ServeMux, r *http.Request, candidates []string) []string {
+	var methods []string
+	for _, m := range candidates {
+		probe := r.Clone(r.Context())
+		probe.Method = m
+		if _, pattern := mux.Handler(probe); pattern != "" {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
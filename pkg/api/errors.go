@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/thirawat27/kvi/pkg/collection"
+	"github.com/thirawat27/kvi/pkg/lock"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// errorStatuses maps engine errors to HTTP statuses, as the gRPC server's
+// errorCodes does to status codes. Anything unlisted is a 500.
+var errorStatuses = []struct {
+	err    error
+	status int
+}{
+	{types.ErrKeyNotFound, http.StatusNotFound},
+	{types.ErrInvalidVector, http.StatusBadRequest},
+	{types.ErrInvalidFilter, http.StatusBadRequest},
+	{types.ErrNoTextIndex, http.StatusBadRequest},
+	{collection.ErrInvalidScore, http.StatusBadRequest},
+	{types.ErrVersionMismatch, http.StatusPreconditionFailed},
+	{types.ErrWrongType, http.StatusConflict},
+	{types.ErrKeyExists, http.StatusConflict},
+	{lock.ErrHeld, http.StatusConflict},
+	{lock.ErrNotHeld, http.StatusConflict},
+	{types.ErrReadOnly, http.StatusForbidden},
+	{types.ErrQueueFull, http.StatusServiceUnavailable},
+	{types.ErrMemoryLimit, http.StatusInsufficientStorage},
+	{types.ErrDiskFull, http.StatusInsufficientStorage},
+	{types.ErrClosed, http.StatusServiceUnavailable},
+	{types.ErrRecovering, http.StatusServiceUnavailable},
+	{types.ErrIndexLoading, http.StatusServiceUnavailable},
+	{types.ErrHistoryUnavailable, http.StatusGone},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout},
+}
+
+// writeEngineError answers a failed engine call with the status for its
+// error and a JSON body carrying its message. A 503 asks the client to
+// retry after a second.
+func writeEngineError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	for _, e := range errorStatuses {
+		if errors.Is(err, e.err) {
+			status = e.status
+			break
+		}
+	}
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), status)
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/fsutil"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// readyCheckTimeout bounds each readiness check, so a wedged engine fails
+// the probe instead of hanging it.
+const readyCheckTimeout = 2 * time.Second
+
+// WithDiskCheck makes readiness fail when the file system holding dir has
+// less than minFree bytes available.
+func WithDiskCheck(dir string, minFree uint64) func(*Server) {
+	return func(s *Server) {
+		t := s.edit()
+		t.dataDir, t.minFreeDisk = dir, minFree
+	}
+}
+
+type checkResult struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// handleLive reports that the process is up and serving HTTP. It checks
+// nothing else, so a restart is only triggered by a truly dead process.
+func (s *Server) handleLive(w http.ResponseWriter, r *http.Request) {
+	jsonOK(w, map[string]string{"status": "ok", "engine": "kvi"})
+}
+
+// handleReady runs every readiness check concurrently and answers 503 if
+// any fails, so load balancers stop routing to a wedged instance.
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]func(context.Context) error{"engine": s.probeEngine}
+	if hc, ok := s.engine.(types.HealthChecker); ok {
+		for name, fn := range hc.HealthChecks() {
+			checks[name] = fn
+		}
+	}
+	if t := s.tunables(); t.dataDir != "" {
+		checks["disk"] = func(context.Context) error { return checkDisk(t.dataDir, t.minFreeDisk) }
+	}
+
+	results := make(map[string]checkResult, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, fn := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := runCheck(r.Context(), fn)
+			mu.Lock()
+			results[name] = res
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	status, code := "ok", http.StatusOK
+	names := make([]string, 0, len(results))
+	for name, res := range results {
+		names = append(names, name)
+		if res.Status != "ok" {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "checks": results, "failed": failedChecks(names, results)})
+}
+
+func runCheck(ctx context.Context, fn func(context.Context) error) checkResult {
+	ctx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	errc := make(chan error, 1)
+	go func() { errc <- fn(ctx) }()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		err = fmt.Errorf("timed out after %s", readyCheckTimeout)
+	}
+	res := checkResult{Status: "ok", LatencyMs: float64(time.Since(start).Microseconds()) / 1000}
+	if errors.Is(err, errors.ErrUnsupported) {
+		res.Status = "skipped"
+	} else if err != nil {
+		res.Status, res.Error = "fail", err.Error()
+	}
+	return res
+}
+
+func failedChecks(names []string, results map[string]checkResult) []string {
+	failed := []string{}
+	for _, name := range names {
+		if results[name].Status == "fail" {
+			failed = append(failed, name)
+		}
+	}
+	return failed
+}
+
+// probeEngine writes, reads back and deletes a throwaway key. The key has a
+// short TTL so a failed delete cannot leave it behind for long.
+func (s *Server) probeEngine(ctx context.Context) error {
+	key := "__kvi_health__:" + newRequestID()
+	expires := time.Now().Add(time.Minute)
+	if err := s.engine.Put(ctx, key, &types.Record{ID: key, Data: map[string]interface{}{"probe": true}, TTL: &expires}); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if _, err := s.engine.Get(ctx, key); err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	if err := s.engine.Delete(ctx, key); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	return nil
+}
+
+func checkDisk(dir string, minFree uint64) error {
+	free, err := fsutil.FreeBytes(dir)
+	if err != nil {
+		return err
+	}
+	if free < minFree {
+		return fmt.Errorf("%d MiB free in %s, need %d MiB", free>>20, dir, minFree>>20)
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// maxFinishedJobs bounds how many completed jobs stay queryable.
+const maxFinishedJobs = 100
+
+// snapshotOp writes a verified backup into the snapshot directory. The
+// other operations are the engine's (types.Maintainer).
+const snapshotOp = "snapshot"
+
+// maintenanceOps are served under POST /api/v1/admin/{op}.
+var maintenanceOps = []string{
+	snapshotOp,
+	types.MaintenanceCheckpoint,
+	types.MaintenanceCompact,
+	types.MaintenanceFlush,
+	types.MaintenanceReindexVectors,
+	types.MaintenanceRebuildIndexes,
+	types.MaintenanceGC,
+}
+
+// WithSnapshotDir sets where POST /api/v1/admin/snapshot writes backups.
+// Without it, or WithSnapshotStore, snapshots are not available.
+func WithSnapshotDir(dir string) func(*Server) {
+	return WithSnapshotStore(backup.NewFileStore(dir))
+}
+
+// WithSnapshotStore has POST /api/v1/admin/snapshot write backups to store,
+// such as an S3 bucket.
+func WithSnapshotStore(store backup.Store) func(*Server) {
+	return func(s *Server) { s.snapshots = store }
+}
+
+// Job is a maintenance operation started through the admin API. Done and
+// Total measure progress in operation-specific units (records, rows).
+type Job struct {
+	ID         string      `json:"id"`
+	Op         string      `json:"op"`
+	Status     string      `json:"status"` // running | succeeded | failed
+	Done       int         `json:"done"`
+	Total      int         `json:"total"`
+	Error      string      `json:"error,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+	StartedAt  time.Time   `json:"started_at"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+}
+
+type jobFunc func(ctx context.Context, progress func(done, total int)) (interface{}, error)
+
+// jobTracker keeps the running job and the most recent finished ones.
+type jobTracker struct {
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	running  *Job
+	finished []string // oldest first
+}
+
+func (t *jobTracker) start(op string) Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.jobs == nil {
+		t.jobs = make(map[string]*Job)
+	}
+	job := &Job{ID: newRequestID(), Op: op, Status: "running", StartedAt: time.Now().UTC()}
+	t.jobs[job.ID] = job
+	t.running = job
+	return *job
+}
+
+func (t *jobTracker) progress(id string, done, total int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if job := t.jobs[id]; job != nil {
+		job.Done, job.Total = done, total
+	}
+}
+
+func (t *jobTracker) finish(id string, result interface{}, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	job := t.jobs[id]
+	now := time.Now().UTC()
+	job.FinishedAt = &now
+	job.Result = result
+	job.Status = "succeeded"
+	if err != nil {
+		job.Status = "failed"
+		job.Error = err.Error()
+	}
+	t.running = nil
+
+	t.finished = append(t.finished, id)
+	if len(t.finished) > maxFinishedJobs {
+		delete(t.jobs, t.finished[0])
+		t.finished = t.finished[1:]
+	}
+}
+
+// get returns a copy of the job, safe to encode while it keeps running.
+func (t *jobTracker) get(id string) (Job, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if job := t.jobs[id]; job != nil {
+		return *job, true
+	}
+	return Job{}, false
+}
+
+func (t *jobTracker) current() (Job, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.running != nil {
+		return *t.running, true
+	}
+	return Job{}, false
+}
+
+// maintenanceJob resolves op to something runnable, or nil if this server
+// or engine does not support it.
+func (s *Server) maintenanceJob(op string) jobFunc {
+	if op == snapshotOp {
+		if s.snapshots == nil {
+			return nil
+		}
+		return s.snapshot
+	}
+	m, ok := s.engine.(types.Maintainer)
+	if !ok {
+		return nil
+	}
+	task := m.Maintenance()[op]
+	if task == nil {
+		return nil
+	}
+	return func(ctx context.Context, progress func(done, total int)) (interface{}, error) {
+		return nil, task(ctx, progress)
+	}
+}
+
+// rebuildJob rebuilds the indexes of kinds, for POST
+// /api/v1/admin/rebuild-indexes?kinds=...
+func (s *Server) rebuildJob(kinds []string) jobFunc {
+	rebuilder, ok := s.engine.(types.IndexRebuilder)
+	if !ok {
+		return nil
+	}
+	return func(ctx context.Context, progress func(done, total int)) (interface{}, error) {
+		return nil, rebuilder.RebuildIndexes(ctx, progress, kinds...)
+	}
+}
+
+// handleMaintenance starts op in the background and answers 202 with the
+// job. Jobs exclude each other and restores: a second one gets 409.
+func (s *Server) handleMaintenance(op string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		run := s.maintenanceJob(op)
+		if kinds := r.URL.Query().Get("kinds"); run != nil && op == types.MaintenanceRebuildIndexes && kinds != "" {
+			list := strings.Split(kinds, ",")
+			for _, kind := range list {
+				if !slices.Contains(types.IndexKinds, kind) {
+					http.Error(w, fmt.Sprintf(`{"error":%q}`, "unknown index kind "+kind+", want "+strings.Join(types.IndexKinds, ", ")), http.StatusBadRequest)
+					return
+				}
+			}
+			run = s.rebuildJob(list)
+		}
+		if run == nil {
+			http.Error(w, fmt.Sprintf(`{"error":"%s is not supported by this server"}`, op), http.StatusNotImplemented)
+			return
+		}
+		if !s.maintenance.TryLock() {
+			msg := `{"error":"a restore is in progress"}`
+			if job, ok := s.jobs.current(); ok {
+				msg = fmt.Sprintf(`{"error":"%s job %s is running","job_id":%q}`, job.Op, job.ID, job.ID)
+			}
+			http.Error(w, msg, http.StatusConflict)
+			return
+		}
+
+		job := s.jobs.start(op)
+		go s.runJob(job, run)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Location", "/api/v1/admin/jobs/"+job.ID)
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(job)
+	}
+}
+
+// runJob runs a job holding the maintenance lock, logging how it ends.
+// Shutdown cancels it.
+func (s *Server) runJob(job Job, run jobFunc) {
+	id := job.ID
+	defer s.maintenance.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stopping:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	result, err := run(ctx, func(done, total int) { s.jobs.progress(id, done, total) })
+	s.jobs.finish(id, result, err)
+	elapsed := float64(time.Since(job.StartedAt).Microseconds()) / 1000
+	if err != nil {
+		s.log.Error("maintenance job failed", "job_id", id, "op", job.Op, "duration_ms", elapsed, "err", err)
+	} else {
+		s.log.Info("maintenance job succeeded", "job_id", id, "op", job.Op, "duration_ms", elapsed)
+	}
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, `{"error":"job not found"}`, http.StatusNotFound)
+		return
+	}
+	jsonOK(w, job)
+}
+
+// snapshotResult is the result of a snapshot job.
+type snapshotResult struct {
+	Path string `json:"path"`
+	backup.Summary
+}
+
+// snapshot dumps the store into the snapshot store, then reads it back —
+// checksum and every record — and deletes it if that fails, so a snapshot
+// that exists is known to restore. The store only shows it once it is
+// complete.
+func (s *Server) snapshot(ctx context.Context, progress func(done, total int)) (interface{}, error) {
+	name := backup.NewName(time.Now())
+	sum, err := backup.Save(ctx, s.snapshots, name, func(w io.Writer) (backup.Summary, error) {
+		return backup.Dump(ctx, s.engine, w)
+	})
+	if err != nil {
+		return nil, err
+	}
+	progress(0, sum.Records)
+
+	if err := s.verifySnapshot(ctx, name, sum, progress); err != nil {
+		if derr := s.snapshots.Delete(context.WithoutCancel(ctx), name); derr != nil {
+			s.log.Error("cannot delete snapshot that failed verification", "path", s.snapshots.Location(name), "err", derr)
+		}
+		return nil, err
+	}
+	return snapshotResult{Path: s.snapshots.Location(name), Summary: sum}, nil
+}
+
+func (s *Server) verifySnapshot(ctx context.Context, name string, sum backup.Summary, progress func(done, total int)) error {
+	rc, meta, err := s.snapshots.Open(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if meta.Checksum != "" && meta.Checksum != sum.Checksum {
+		return fmt.Errorf("snapshot stored with checksum %s, wrote %s", meta.Checksum, sum.Checksum)
+	}
+
+	h := sha256.New()
+	n := 0
+	if _, err := backup.Read(io.TeeReader(rc, h), func(*types.Record) error {
+		if n++; n%1000 == 0 {
+			progress(n, sum.Records)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("snapshot unreadable: %w", err)
+	}
+	io.Copy(h, rc) // the gzip trailer, if Read stopped before it
+	if checksum := hex.EncodeToString(h.Sum(nil)); checksum != sum.Checksum {
+		return fmt.Errorf("snapshot checksum mismatch: wrote %s, read back %s", sum.Checksum, checksum)
+	}
+	if n != sum.Records {
+		return fmt.Errorf("snapshot holds %d records, wrote %d", n, sum.Records)
+	}
+	progress(n, sum.Records)
+	return nil
+}
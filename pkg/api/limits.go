@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Default body limits: JSON requests are small; restores carry a whole
+// backup.
+const (
+	DefaultMaxRequestBytes = 4 << 20
+	DefaultMaxImportBytes  = 1 << 30
+)
+
+// WithBodyLimits caps request bodies: maxRequest for ordinary routes,
+// maxImport for /api/v1/restore. A limit <= 0 removes it.
+func WithBodyLimits(maxRequest, maxImport int64) func(*Server) {
+	return func(s *Server) {
+		t := s.edit()
+		t.maxRequestBytes, t.maxImportBytes = maxRequest, maxImport
+	}
+}
+
+// limitBodies wraps every request body in http.MaxBytesReader.
+func (s *Server) limitBodies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t := s.tunables()
+		limit := t.maxRequestBytes
+		if r.URL.Path == "/api/v1/restore" {
+			limit = t.maxImportBytes
+		}
+		if limit > 0 {
+			if r.ContentLength > limit {
+				writeTooLarge(w, limit)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeTooLarge(w http.ResponseWriter, limit int64) {
+	http.Error(w, fmt.Sprintf(`{"error":"request body exceeds the %d byte limit"}`, limit), http.StatusRequestEntityTooLarge)
+}
+
+// decodeJSON strictly decodes the request body into v: unknown fields and
+// trailing data are rejected. On failure it writes 413 for an oversized
+// body, 400 otherwise, and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(&json.RawMessage{}) != io.EOF {
+		err = errors.New("unexpected data after JSON body")
+	}
+	if err == nil {
+		return true
+	}
+	if tooLarge(w, err) {
+		return false
+	}
+	http.Error(w, err.Error(), http.StatusBadRequest)
+	return false
+}
+
+// tooLarge writes a 413 and reports true if err came from a body limit.
+func tooLarge(w http.ResponseWriter, err error) bool {
+	var maxErr *http.MaxBytesError
+	if !errors.As(err, &maxErr) {
+		return false
+	}
+	writeTooLarge(w, maxErr.Limit)
+	return true
+}
@@ -0,0 +1,36 @@
+package api
+
+import (
+	"encoding/base64"
+	"net/http"
+)
+
+// listResponse is the envelope for multi-record responses. Truncated
+// reports that more records match than were returned; NextCursor resumes
+// the listing after the last item.
+type listResponse struct {
+	Items      interface{} `json:"items"`
+	Count      int         `json:"count"`
+	Truncated  bool        `json:"truncated"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// legacyEnvelope reports whether the client asked for the pre-envelope
+// response shape with ?envelope=legacy, marking the response deprecated.
+func legacyEnvelope(w http.ResponseWriter, r *http.Request) bool {
+	if r.URL.Query().Get("envelope") != "legacy" {
+		return false
+	}
+	w.Header().Set("Deprecation", "true")
+	return true
+}
+
+// Cursors are opaque to clients; they carry the last key returned.
+func encodeCursor(key string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key))
+}
+
+func decodeCursor(cursor string) (string, error) {
+	key, err := base64.RawURLEncoding.DecodeString(cursor)
+	return string(key), err
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/sql"
+)
+
+// WithLiveQueries serves SQL live queries from lq, so a client's limit is
+// shared with the other servers using it. Without the option the server
+// runs its own, with the default debounce and limit.
+func WithLiveQueries(lq *sql.LiveQueries) func(*Server) {
+	return func(s *Server) { s.liveQueries = lq }
+}
+
+// liveEvent is one result of a live query as the SSE stream sends it.
+type liveEvent struct {
+	Seq     uint64        `json:"seq"`
+	Items   []interface{} `json:"items"`
+	Count   int           `json:"count"`
+	Columns []string      `json:"columns,omitempty"`
+}
+
+// handleLiveQuery runs the SELECT in ?q= as a live query, sending its
+// result as an SSE "result" event when it starts and whenever a write
+// changes it. The event ID is the result's seq. A query that fails ends
+// the stream with an "error" event.
+func (s *Server) handleLiveQuery(w http.ResponseWriter, r *http.Request) {
+	if s.liveQueries == nil {
+		http.Error(w, `{"error":"this engine has no change feed"}`, http.StatusNotImplemented)
+		return
+	}
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		http.Error(w, `{"error":"q query param required"}`, http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	if !s.acquireStream(w, r) {
+		return
+	}
+	defer s.streams.Release()
+
+	results, err := s.liveQueries.Subscribe(r.Context(), clientKey(r), query)
+	if errors.Is(err, sql.ErrTooManyLiveQueries) {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	if w.Header().Get("Connection") == "" {
+		w.Header().Set("Connection", "keep-alive")
+	}
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	fmt.Fprintf(w, "retry: %d\n\n", s.sseRetry.Milliseconds())
+	flusher.Flush()
+
+	ticker := time.NewTicker(s.heartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.stopping:
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case res, open := <-results:
+			if !open {
+				return
+			}
+			if res.Err != nil {
+				data, _ := json.Marshal(map[string]string{"error": res.Err.Error()})
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+				flusher.Flush()
+				return
+			}
+			items := queryItems(res.Result.Value)
+			data, _ := json.Marshal(liveEvent{Seq: res.Seq, Items: items, Count: len(items), Columns: res.Result.Columns})
+			fmt.Fprintf(w, "id: %d\nevent: result\ndata: %s\n\n", res.Seq, data)
+			flusher.Flush()
+		}
+	}
+}
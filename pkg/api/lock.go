@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/lock"
+)
+
+type lockRequest struct {
+	Key   string `json:"key"`
+	Owner string `json:"owner"`
+	TTLMs int64  `json:"ttl_ms"`
+}
+
+type unlockRequest struct {
+	Key   string `json:"key"`
+	Token uint64 `json:"token"`
+}
+
+// handleLock takes the advisory lock on a key for ttl_ms and answers with
+// its fencing token. A lock someone holds is a 409.
+func (s *Server) handleLock(w http.ResponseWriter, r *http.Request) {
+	var req lockRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Key == "" || req.Owner == "" || req.TTLMs <= 0 {
+		http.Error(w, `{"error":"key, owner and a positive ttl_ms are required"}`, http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := routeContext(r, s.timeouts.Write)
+	defer cancel()
+	token, err := lock.Lock(ctx, s.engine, req.Key, req.Owner, time.Duration(req.TTLMs)*time.Millisecond)
+	if timedOut(w, r, ctx, "engine update", s.timeouts.Write) {
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	jsonOK(w, map[string]uint64{"token": token})
+}
+
+// handleUnlock releases the lock taken with token. A lock that has
+// expired or been released is a 409.
+func (s *Server) handleUnlock(w http.ResponseWriter, r *http.Request) {
+	var req unlockRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Key == "" || req.Token == 0 {
+		http.Error(w, `{"error":"key and token are required"}`, http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := routeContext(r, s.timeouts.Write)
+	defer cancel()
+	err := lock.Unlock(ctx, s.engine, req.Key, req.Token)
+	if timedOut(w, r, ctx, "engine update", s.timeouts.Write) {
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	jsonOK(w, map[string]string{"status": "ok", "unlocked_key": req.Key})
+}
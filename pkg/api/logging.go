@@ -0,0 +1,198 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/sql"
+)
+
+// maxSampledBody caps how much of a request body is kept for logging.
+const maxSampledBody = 4 << 10
+
+// AccessLog configures the structured request log.
+type AccessLog struct {
+	Logger *slog.Logger // nil disables the access log; request IDs are still issued
+
+	// Requests slower than SlowThreshold are logged at warn, with the
+	// normalized SQL for /api/v1/query. 0 disables the check.
+	SlowThreshold time.Duration
+
+	// SampleFailedBodies logs the (truncated) request body of 4xx/5xx
+	// responses. Bodies sent to the auth endpoints are never logged.
+	SampleFailedBodies bool
+}
+
+// WithAccessLog enables structured request logging.
+func WithAccessLog(cfg AccessLog) func(*Server) {
+	return func(s *Server) { s.edit().accessLog = cfg }
+}
+
+// requestInfo is shared through the request context so inner middleware can
+// report back to the logger (the authenticated subject).
+type requestInfo struct {
+	id      string
+	subject string
+}
+
+type requestInfoKey struct{}
+
+// RequestID returns the correlation ID assigned to the request handled with ctx.
+func RequestID(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+func setSubject(ctx context.Context, subject string) {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		info.subject = subject
+	}
+}
+
+// logRequests assigns every request an X-Request-ID (propagating a sane one
+// sent by the client), echoes it on the response and writes one log line
+// when the handler returns.
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		info := &requestInfo{id: id}
+
+		al := s.tunables().accessLog
+		body := &countingBody{ReadCloser: r.Body}
+		if al.Logger != nil && (al.SampleFailedBodies || r.URL.Path == "/api/v1/query") {
+			body.sample = &bytes.Buffer{}
+		}
+		r.Body = body
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+
+		if al.Logger != nil {
+			logRequest(al, r, rec, body, info, time.Since(start))
+		}
+	})
+}
+
+func logRequest(al AccessLog, r *http.Request, rec *statusRecorder, body *countingBody, info *requestInfo, elapsed time.Duration) {
+	level := slog.LevelInfo
+	attrs := []slog.Attr{
+		slog.String("request_id", info.id),
+		slog.String("method", r.Method),
+		slog.String("path", r.URL.Path),
+		slog.Int("status", rec.status),
+		slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+		slog.Int64("bytes_in", body.n),
+		slog.Int64("bytes_out", rec.written),
+		slog.String("remote", r.RemoteAddr),
+	}
+	if info.subject != "" {
+		attrs = append(attrs, slog.String("subject", info.subject))
+	}
+
+	if slow := al.SlowThreshold; slow > 0 && elapsed >= slow {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.Bool("slow", true))
+		if r.URL.Path == "/api/v1/query" && body.sample != nil {
+			var req queryRequest
+			if json.Unmarshal(body.sample.Bytes(), &req) == nil {
+				attrs = append(attrs, slog.String("query", sql.Normalize(req.Query)))
+			}
+		}
+	}
+	if rec.status >= http.StatusInternalServerError {
+		level = slog.LevelError
+	}
+	if rec.status >= http.StatusBadRequest && al.SampleFailedBodies &&
+		body.sample != nil && body.sample.Len() > 0 && !strings.HasPrefix(r.URL.Path, "/api/v1/auth") {
+		attrs = append(attrs, slog.String("body", body.sample.String()))
+	}
+
+	al.Logger.LogAttrs(r.Context(), level, "http request", attrs...)
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// countingBody counts request bytes as the handler reads them and keeps the
+// first maxSampledBody bytes when sample is set.
+type countingBody struct {
+	io.ReadCloser
+	n      int64
+	sample *bytes.Buffer
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if b.sample != nil && n > 0 {
+		if room := maxSampledBody - b.sample.Len(); room > 0 {
+			b.sample.Write(p[:min(n, room)])
+		}
+	}
+	return n, err
+}
+
+// statusRecorder captures the status code and response size. It keeps
+// Flush working for SSE and unwraps for http.ResponseController.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	written     int64
+	wroteHeader bool
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *statusRecorder) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
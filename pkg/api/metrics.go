@@ -0,0 +1,62 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// workerMetrics are the Prometheus metrics /metrics serves for each
+// background worker, labelled with its name.
+var workerMetrics = []struct {
+	name, kind, help string
+	value            func(types.WorkerStats) float64
+}{
+	{"kvi_worker_queue_depth", "gauge", "Items waiting for the worker.",
+		func(w types.WorkerStats) float64 { return float64(w.QueueDepth) }},
+	{"kvi_worker_processed_total", "counter", "Items the worker has done.",
+		func(w types.WorkerStats) float64 { return float64(w.Processed) }},
+	{"kvi_worker_cycles_total", "counter", "Cycles the worker has finished.",
+		func(w types.WorkerStats) float64 { return float64(w.Cycles) }},
+	{"kvi_worker_errors_total", "counter", "Cycles of the worker that failed.",
+		func(w types.WorkerStats) float64 { return float64(w.Errors) }},
+	{"kvi_worker_last_run_timestamp_seconds", "gauge", "When the worker last finished a cycle, in Unix seconds; 0 before the first.",
+		func(w types.WorkerStats) float64 {
+			if w.LastRun.IsZero() {
+				return 0
+			}
+			return float64(w.LastRun.UnixMilli()) / 1000
+		}},
+	{"kvi_worker_interval_seconds", "gauge", "How often the worker finishes a cycle while it keeps up.",
+		func(w types.WorkerStats) float64 { return float64(w.IntervalMs) / 1000 }},
+	{"kvi_worker_stalled", "gauge", "1 while the worker has gone worker_stall_intervals intervals without finishing a cycle.",
+		func(w types.WorkerStats) float64 {
+			if w.Stalled {
+				return 1
+			}
+			return 0
+		}},
+}
+
+// handleMetrics serves the engine's background workers in the Prometheus
+// text format. It takes no engine lock, so it still answers when a worker
+// has stalled behind one.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var workers []types.WorkerStats
+	if wr, ok := s.engine.(types.WorkerReporter); ok {
+		workers = wr.Workers()
+	}
+
+	var b strings.Builder
+	for _, m := range workerMetrics {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, worker := range workers {
+			fmt.Fprintf(&b, "%s{worker=%q} %g\n", m.name, worker.Name, m.value(worker))
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte(b.String()))
+}
@@ -1,71 +1,97 @@
 package api
 
 import (
-	"context"
+	"errors"
 	"net/http"
 	"strings"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/thirawat27/kvi/pkg/auth"
 )
 
-var JwtSecret = []byte("kvi-super-secret-key-replace-in-production")
-
-func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+func (s *Server) authMiddleware(required auth.Role, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Allow unauthenticated health check or generating tokens?
-		// For now, let's secure everything except /health and /api/v1/auth
-		if r.URL.Path == "/health" || r.URL.Path == "/api/v1/auth" {
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Unauthorized - Missing token", http.StatusUnauthorized)
+		tokenString, ok := bearerToken(r)
+		if !ok {
+			http.Error(w, "Unauthorized - Missing or malformed bearer token", http.StatusUnauthorized)
 			return
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "Unauthorized - Invalid token format", http.StatusUnauthorized)
+		claims, err := s.auth.Authorize(tokenString, required)
+		if errors.Is(err, auth.ErrForbidden) {
+			http.Error(w, "Forbidden - "+err.Error(), http.StatusForbidden)
 			return
 		}
-
-		tokenString := parts[1]
-
-		// Parse token
-		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, http.ErrNotSupported
-			}
-			return JwtSecret, nil
-		})
-
-		if err != nil || !token.Valid {
+		if err != nil {
 			http.Error(w, "Unauthorized - Invalid token", http.StatusUnauthorized)
 			return
 		}
 
-		// Proceed
-		// Can inject claims to context here if necessary
-		ctx := context.WithValue(r.Context(), "user", token.Claims)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		setSubject(r.Context(), claims.Subject)
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), claims)))
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	parts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(parts) != 2 || parts[0] != "Bearer" || parts[1] == "" {
+		return "", false
 	}
+	return parts[1], true
+}
+
+type authRequest struct {
+	APIKey string `json:"api_key"`
 }
 
-// Handler to generate a JWT for testing/authentication
+// handleAuth exchanges an API key for a short-lived JWT.
 func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
-	// A real implementation would verify credentials here
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"role": "admin",
-		"sub":  "service-account",
-	})
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.auth == nil {
+		http.Error(w, `{"error":"authentication is disabled"}`, http.StatusNotFound)
+		return
+	}
+	var req authRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	token, claims, err := s.auth.Login(r.Context(), req.APIKey)
+	if err != nil {
+		http.Error(w, `{"error":"invalid credentials"}`, http.StatusUnauthorized)
+		return
+	}
+	writeToken(w, token, claims)
+}
 
-	tokenString, err := token.SignedString(JwtSecret)
+// handleRefresh trades a still-valid token for a new one with a fresh expiry.
+func (s *Server) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.auth == nil {
+		http.Error(w, `{"error":"authentication is disabled"}`, http.StatusNotFound)
+		return
+	}
+	tokenString, ok := bearerToken(r)
+	if !ok {
+		http.Error(w, "Unauthorized - Missing or malformed bearer token", http.StatusUnauthorized)
+		return
+	}
+	token, claims, err := s.auth.Refresh(tokenString)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, "Unauthorized - Invalid token", http.StatusUnauthorized)
 		return
 	}
+	writeToken(w, token, claims)
+}
 
-	w.Write([]byte(`{"token": "` + tokenString + `"}`))
+func writeToken(w http.ResponseWriter, token string, claims *auth.Claims) {
+	jsonOK(w, map[string]interface{}{
+		"token":      token,
+		"role":       claims.Role,
+		"expires_at": claims.ExpiresAt.Time,
+	})
 }
@@ -0,0 +1,260 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/stats"
+)
+
+// MirroredHeader marks a request a mirror sent. Such requests are never
+// mirrored on, so canaries that mirror in turn do not multiply traffic.
+const MirroredHeader = "X-Kvi-Mirrored"
+
+// mirrorDeltas is how many of the latest latency differences the mirror
+// keeps for its stats.
+const mirrorDeltas = 1024
+
+// credentialHeaders carry a caller's credentials, which are only mirrored
+// with forward_auth: this server's own, and API keys as proxies in front
+// of it may take them.
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
+// mirrorSkip are the routes never mirrored: credentials and administration,
+// and streams, which would hold a slot for as long as they run.
+var mirrorSkip = []string{
+	"/api/v1/auth", "/api/v1/admin/", "/api/v1/backup", "/api/v1/restore", "/api/v1/sync",
+	"/api/v1/sub", "/api/v1/query/live", "/api/v1/changes",
+}
+
+// mirror copies a sample of requests to a second server in the
+// background. Its settings can change while requests are on their way;
+// each one keeps those it was sampled with.
+type mirror struct {
+	settings atomic.Pointer[mirrorSettings]
+	client   *http.Client
+	inFlight atomic.Int64
+	dropped  atomic.Int64
+
+	mu        sync.Mutex // guards what follows
+	mirrored  int64
+	failed    int64
+	lastError string
+	deltas    []float64 // ms, a ring of up to mirrorDeltas
+	next      int
+}
+
+type mirrorSettings struct {
+	config.MirrorConfig
+	target *url.URL // nil mirrors nothing
+}
+
+func newMirror() *mirror {
+	m := &mirror{client: &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()}}
+	m.set(config.DefaultMirror())
+	return m
+}
+
+// set replaces the settings of m, which must be valid.
+func (m *mirror) set(cfg config.MirrorConfig) {
+	set := &mirrorSettings{MirrorConfig: cfg}
+	set.Methods = slices.Clone(cfg.Methods)
+	if cfg.Enabled() {
+		set.target, _ = url.Parse(cfg.Target)
+	}
+	m.settings.Store(set)
+}
+
+// WithMirror mirrors requests as cfg says, which must be valid (see
+// config.MirrorConfig.Validate). The settings can be changed at runtime
+// through /api/v1/admin/mirror.
+func WithMirror(cfg config.MirrorConfig) func(*Server) {
+	return func(s *Server) { s.mirror.set(cfg) }
+}
+
+// sample picks whether to mirror r, returning the settings to mirror it
+// with, or nil.
+func (m *mirror) sample(r *http.Request) *mirrorSettings {
+	set := m.settings.Load()
+	if set.target == nil || r.Header.Get(MirroredHeader) != "" || !slices.Contains(set.Methods, r.Method) {
+		return nil
+	}
+	path := r.URL.Path
+	if !strings.HasPrefix(path, "/api/v1/") || slices.ContainsFunc(mirrorSkip, func(p string) bool { return strings.HasPrefix(path, p) }) {
+		return nil
+	}
+	if rand.Float64()*100 >= set.SamplePercent {
+		return nil
+	}
+	return set
+}
+
+// mirrorRequests sends a sample of the requests next answers to the mirror
+// target once next has answered them, keeping a copy of the body as next
+// reads it. A request whose body next left unread is not mirrored: the
+// copy would not be the same request.
+func (s *Server) mirrorRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		set := s.mirror.sample(r)
+		if set == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body := &teeBody{ReadCloser: r.Body, done: r.ContentLength == 0}
+		r.Body = body
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		if body.done {
+			s.mirror.send(set, r, body.buf.Bytes(), time.Since(start))
+		}
+	})
+}
+
+// teeBody keeps what is read of a request body.
+type teeBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	done bool // read to the end
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.buf.Write(p[:n])
+	if err == io.EOF {
+		b.done = true
+	}
+	return n, err
+}
+
+// send mirrors r, with body, on a goroutine of its own, unless MaxInFlight
+// are already on their way. primary is how long this server took.
+func (m *mirror) send(set *mirrorSettings, r *http.Request, body []byte, primary time.Duration) {
+	if m.inFlight.Add(1) > int64(set.MaxInFlight) {
+		m.inFlight.Add(-1)
+		m.dropped.Add(1)
+		return
+	}
+	u := set.target.JoinPath(r.URL.Path)
+	u.RawQuery = r.URL.RawQuery
+	// The request's own context ends with its response
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(set.TimeoutMs)*time.Millisecond)
+	req, err := http.NewRequestWithContext(ctx, r.Method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		m.inFlight.Add(-1)
+		m.fail(err)
+		return
+	}
+	req.Header = r.Header.Clone()
+	if !set.ForwardAuth {
+		for _, h := range credentialHeaders {
+			req.Header.Del(h)
+		}
+	}
+	req.Header.Del("Accept-Encoding") // the transport asks for gzip and undoes it
+	req.Header.Set(MirroredHeader, "1")
+
+	go func() {
+		defer m.inFlight.Add(-1)
+		defer cancel()
+		start := time.Now()
+		resp, err := m.client.Do(req)
+		if err == nil {
+			_, err = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if err == nil && resp.StatusCode >= 500 {
+				err = fmt.Errorf("%s %s: %s", r.Method, r.URL.Path, resp.Status)
+			}
+		}
+		if err != nil {
+			m.fail(err)
+			return
+		}
+		m.answered(time.Since(start) - primary)
+	}()
+}
+
+func (m *mirror) fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed++
+	m.lastError = err.Error()
+}
+
+// answered records a mirrored request the target answered, delta slower
+// than this server.
+func (m *mirror) answered(delta time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mirrored++
+	ms := float64(delta.Microseconds()) / 1000
+	if len(m.deltas) < mirrorDeltas {
+		m.deltas = append(m.deltas, ms)
+		return
+	}
+	m.deltas[m.next] = ms
+	m.next = (m.next + 1) % mirrorDeltas
+}
+
+func (m *mirror) stats() *stats.MirrorStats {
+	set := m.settings.Load()
+	st := &stats.MirrorStats{
+		Target:        set.Target,
+		SamplePercent: set.SamplePercent,
+		Methods:       set.Methods,
+		MaxInFlight:   set.MaxInFlight,
+		TimeoutMs:     set.TimeoutMs,
+		ForwardAuth:   set.ForwardAuth,
+		InFlight:      int(m.inFlight.Load()),
+		Dropped:       m.dropped.Load(),
+	}
+	m.mu.Lock()
+	st.Mirrored, st.Failed, st.LastError = m.mirrored, m.failed, m.lastError
+	deltas := slices.Clone(m.deltas)
+	m.mu.Unlock()
+
+	if n := len(deltas); n > 0 {
+		slices.Sort(deltas)
+		var sum float64
+		for _, d := range deltas {
+			sum += d
+		}
+		st.LatencyDeltaMs = stats.LatencyDeltas{
+			Samples: n,
+			Mean:    sum / float64(n),
+			P50:     deltas[(n-1)/2],
+			P99:     deltas[(n-1)*99/100],
+		}
+	}
+	return st
+}
+
+// handleMirror reports the mirroring; PUT changes its settings first,
+// taking the fields of config.MirrorConfig it names over those in force.
+// A target of "" stops mirroring.
+func (s *Server) handleMirror(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		cfg := s.mirror.settings.Load().MirrorConfig
+		cfg.Methods = slices.Clone(cfg.Methods) // decoding may reuse the array
+		if !decodeJSON(w, r, &cfg) {
+			return
+		}
+		if err := cfg.Validate(); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		s.mirror.set(cfg)
+	}
+	jsonOK(w, s.mirror.stats())
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+type moveRequest struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+	// Overwrite replaces a record stored under dst, which is otherwise a
+	// 409.
+	Overwrite bool `json:"overwrite"`
+	// KeepCreatedAt carries src's created_at over to dst.
+	KeepCreatedAt bool `json:"keep_created_at"`
+}
+
+func (req moveRequest) check() error {
+	switch {
+	case req.Src == "" || req.Dst == "":
+		return errors.New("src and dst are required")
+	case req.Src == req.Dst:
+		return errors.New("src and dst must differ")
+	}
+	return nil
+}
+
+// handleMove copies src's record to dst, or with rename moves it, in one
+// engine call (types.Mover), and answers with the record stored under dst.
+func (s *Server) handleMove(rename bool) http.HandlerFunc {
+	op := "copy"
+	if rename {
+		op = "rename"
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, ok := s.engine.(types.Mover)
+		if !ok {
+			http.Error(w, fmt.Sprintf(`{"error":"this engine cannot %s keys"}`, op), http.StatusNotImplemented)
+			return
+		}
+		var req moveRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if err := req.check(); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+			return
+		}
+		move := m.Copy
+		if rename {
+			move = m.Rename
+		}
+
+		ctx, cancel := routeContext(r, s.timeouts.Write)
+		defer cancel()
+		rec, err := move(ctx, req.Src, req.Dst, types.MoveOptions{Overwrite: req.Overwrite, KeepCreatedAt: req.KeepCreatedAt})
+		if timedOut(w, r, ctx, "engine "+op, s.timeouts.Write) {
+			return
+		}
+		if err != nil {
+			writeEngineError(w, err)
+			return
+		}
+		setETag(w, rec.Version)
+		setWatermark(w, s.engine)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(viewOf(rec))
+	}
+}
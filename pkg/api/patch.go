@@ -0,0 +1,85 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+type patchRequest struct {
+	Key   string                 `json:"key"`
+	Set   map[string]interface{} `json:"set"`
+	Unset []string               `json:"unset"`
+}
+
+// handlePatch changes individual fields of a record in one atomic engine
+// update. set is applied as an RFC 7386 JSON merge patch: nested objects are
+// merged recursively and null removes a field. unset removes top-level
+// fields. Fields not mentioned, including the vector, are kept.
+func (s *Server) handlePatch(w http.ResponseWriter, r *http.Request) {
+	var req patchRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Key == "" {
+		http.Error(w, `{"error":"key is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	var expected uint64
+	if m := strings.TrimSpace(r.Header.Get("If-Match")); m != "" && m != "*" {
+		v, err := strconv.ParseUint(strings.Trim(strings.TrimPrefix(m, "W/"), `"`), 10, 64)
+		if err != nil || v == 0 {
+			writeConditionalError(w, errBadPrecondition)
+			return
+		}
+		expected = v
+	}
+
+	ctx, cancel := routeContext(r, s.timeouts.Write)
+	defer cancel()
+	rec, err := s.engine.Update(ctx, req.Key, func(rec *types.Record) error {
+		if expected != 0 && rec.Version != expected {
+			return &types.VersionMismatchError{Key: req.Key, Expected: expected, Current: rec.Version}
+		}
+		if rec.Data == nil {
+			rec.Data = map[string]interface{}{}
+		}
+		mergePatch(rec.Data, req.Set)
+		for _, field := range req.Unset {
+			delete(rec.Data, field)
+		}
+		return nil
+	})
+	if timedOut(w, r, ctx, "engine update", s.timeouts.Write) {
+		return
+	}
+	if err != nil {
+		writeConditionalError(w, err)
+		return
+	}
+	setETag(w, rec.Version)
+	setWatermark(w, s.engine)
+	jsonOK(w, viewOf(rec))
+}
+
+// mergePatch applies patch to target following RFC 7386.
+func mergePatch(target, patch map[string]interface{}) {
+	for k, v := range patch {
+		switch v := v.(type) {
+		case nil:
+			delete(target, k)
+		case map[string]interface{}:
+			sub, ok := target[k].(map[string]interface{})
+			if !ok {
+				sub = map[string]interface{}{}
+				target[k] = sub
+			}
+			mergePatch(sub, v)
+		default:
+			target[k] = v
+		}
+	}
+}
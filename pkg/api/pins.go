@@ -0,0 +1,33 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// handlePins lists the engine's pinned keys; PUT and DELETE with ?key=
+// pin and unpin one first.
+func (s *Server) handlePins(w http.ResponseWriter, r *http.Request) {
+	p, ok := s.engine.(types.Pinner)
+	if !ok {
+		http.Error(w, `{"error":"this engine has no cache to pin keys in"}`, http.StatusNotImplemented)
+		return
+	}
+	if r.Method != http.MethodGet {
+		key := r.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, `{"error":"key is required"}`, http.StatusBadRequest)
+			return
+		}
+		pin := p.Pin
+		if r.Method == http.MethodDelete {
+			pin = p.Unpin
+		}
+		if err := pin(r.Context(), key); err != nil {
+			writeEngineError(w, err)
+			return
+		}
+	}
+	jsonOK(w, map[string][]string{"pinned": p.Pinned()})
+}
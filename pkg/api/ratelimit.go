@@ -0,0 +1,200 @@
+package api
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/auth"
+)
+
+// RateLimit configures a per-client token bucket. RPS <= 0 disables limiting.
+type RateLimit struct {
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+}
+
+// RateLimiterStats is the observable state of one limiter.
+type RateLimiterStats struct {
+	RateLimit
+	Clients  int    `json:"clients"`
+	Rejected uint64 `json:"rejected"`
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps one token bucket per client key.
+type rateLimiter struct {
+	mu        sync.Mutex
+	limit     RateLimit
+	buckets   map[string]*bucket
+	rejected  uint64
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	return &rateLimiter{
+		limit:   normalizeLimit(limit),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func normalizeLimit(l RateLimit) RateLimit {
+	if l.RPS > 0 && l.Burst < 1 {
+		l.Burst = int(math.Ceil(l.RPS))
+	}
+	return l
+}
+
+// allow takes a token for client, or reports how long until one is available.
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit.RPS <= 0 {
+		return true, 0
+	}
+
+	now := l.now()
+	l.sweepLocked(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(l.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*l.limit.RPS)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	l.rejected++
+	wait := time.Duration((1 - b.tokens) / l.limit.RPS * float64(time.Second))
+	return false, wait
+}
+
+// sweepLocked forgets clients whose buckets have refilled, at most once a minute.
+func (l *rateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.limit.RPS >= float64(l.limit.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+func (l *rateLimiter) setLimit(limit RateLimit) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = normalizeLimit(limit)
+	for _, b := range l.buckets {
+		b.tokens = math.Min(b.tokens, float64(l.limit.Burst))
+	}
+}
+
+func (l *rateLimiter) stats() RateLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return RateLimiterStats{RateLimit: l.limit, Clients: len(l.buckets), Rejected: l.rejected}
+}
+
+// WithRateLimits limits every client independently on read and write routes.
+// Limits can be changed at runtime through /api/v1/admin/rate-limits.
+func WithRateLimits(read, write RateLimit) func(*Server) {
+	return func(s *Server) {
+		s.readLimiter.setLimit(read)
+		s.writeLimiter.setLimit(write)
+	}
+}
+
+// limiterFor picks the bucket for a route. Admin routes are exempt so an
+// operator can always reach the endpoint that lifts the limits.
+func (s *Server) limiterFor(required auth.Role) *rateLimiter {
+	switch required {
+	case auth.RoleRead:
+		return s.readLimiter
+	case auth.RoleWrite:
+		return s.writeLimiter
+	}
+	return nil
+}
+
+func (s *Server) rateLimitMiddleware(l *rateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	if l == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.admit(l, w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// admit applies l to the caller, writing a 429 response when over the limit.
+func (s *Server) admit(l *rateLimiter, w http.ResponseWriter, r *http.Request) bool {
+	ok, wait := l.allow(clientKey(r))
+	if ok {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, "Too Many Requests - rate limit exceeded", http.StatusTooManyRequests)
+	return false
+}
+
+// clientKey identifies the caller by token subject, falling back to the
+// remote IP when authentication is off.
+func clientKey(r *http.Request) string {
+	if claims, ok := auth.FromContext(r.Context()); ok && claims.Subject != "" {
+		return "sub:" + claims.Subject
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+type rateLimitsRequest struct {
+	Read  *RateLimit `json:"read"`
+	Write *RateLimit `json:"write"`
+}
+
+// handleRateLimits reports or hot-adjusts the limits without a restart.
+func (s *Server) handleRateLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPut {
+		var req rateLimitsRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Read != nil {
+			s.readLimiter.setLimit(*req.Read)
+		}
+		if req.Write != nil {
+			s.writeLimiter.setLimit(*req.Write)
+		}
+	}
+	jsonOK(w, s.rateLimitStats())
+}
+
+func (s *Server) rateLimitStats() map[string]RateLimiterStats {
+	return map[string]RateLimiterStats{
+		"read":  s.readLimiter.stats(),
+		"write": s.writeLimiter.stats(),
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/thirawat27/kvi/pkg/stats"
+)
+
+// Replica lets a follower report its replication and be promoted.
+type Replica struct {
+	// Stats returns the follower's position.
+	Stats func() *stats.ReplicationStats
+	// Promote stops following and makes the engine writable.
+	Promote func() error
+}
+
+// WithReplica serves GET /api/v1/admin/replication and POST
+// /api/v1/admin/promote from r, and adds its stats to /api/v1/stats.
+func WithReplica(r Replica) func(*Server) {
+	return func(s *Server) { s.replica = r }
+}
+
+// handleReplication reports the follower's position.
+func (s *Server) handleReplication(w http.ResponseWriter, r *http.Request) {
+	if s.replica.Stats == nil {
+		http.Error(w, `{"error":"this server is not a replica"}`, http.StatusNotFound)
+		return
+	}
+	jsonOK(w, s.replica.Stats())
+}
+
+// handlePromote turns the follower into a primary, answering with where
+// it stopped.
+func (s *Server) handlePromote(w http.ResponseWriter, r *http.Request) {
+	if s.replica.Promote == nil {
+		http.Error(w, `{"error":"this server is not a replica"}`, http.StatusConflict)
+		return
+	}
+	if err := s.replica.Promote(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusConflict)
+		return
+	}
+	jsonOK(w, s.replica.Stats())
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/thirawat27/kvi/pkg/retention"
+)
+
+// WithRetention serves /api/v1/admin/retention from ret and adds its rules
+// to /api/v1/stats.
+func WithRetention(ret *retention.Retention) func(*Server) {
+	return func(s *Server) { s.retention = ret }
+}
+
+// retentionError writes err from ret, as 400 for a bad rule and 404 for a
+// missing one.
+func retentionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, retention.ErrBadRule):
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+	case errors.Is(err, retention.ErrNoRule):
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusNotFound)
+	default:
+		writeEngineError(w, err)
+	}
+}
+
+// handleRetention reports the retention rules with their stats. PUT sets
+// the rule in the body first, replacing the one for its prefix; DELETE
+// removes the rule for ?prefix=, which is required but may be empty.
+func (s *Server) handleRetention(w http.ResponseWriter, r *http.Request) {
+	if s.retention == nil {
+		http.Error(w, `{"error":"retention rules are not applied by this server"}`, http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		var rule retention.Rule
+		if !decodeJSON(w, r, &rule) {
+			return
+		}
+		if err := s.retention.Set(r.Context(), rule); err != nil {
+			retentionError(w, err)
+			return
+		}
+	case http.MethodDelete:
+		if !r.URL.Query().Has("prefix") {
+			http.Error(w, `{"error":"prefix is required"}`, http.StatusBadRequest)
+			return
+		}
+		if err := s.retention.Remove(r.Context(), r.URL.Query().Get("prefix")); err != nil {
+			retentionError(w, err)
+			return
+		}
+	}
+	st, err := s.retention.Stats(r.Context())
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	jsonOK(w, st)
+}
+
+// handleRetentionRun applies the rule for ?prefix= now, or every rule
+// without one, and reports what each did. With ?dry_run=true nothing is
+// deleted; the keys that would be are listed instead.
+func (s *Server) handleRetentionRun(w http.ResponseWriter, r *http.Request) {
+	if s.retention == nil {
+		http.Error(w, `{"error":"retention rules are not applied by this server"}`, http.StatusNotFound)
+		return
+	}
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, `{"error":"dry_run must be true or false"}`, http.StatusBadRequest)
+			return
+		}
+	}
+	results, err := s.retention.Run(r.Context(), r.URL.Query().Get("prefix"), dryRun)
+	if err != nil && results == nil {
+		retentionError(w, err)
+		return
+	}
+	// A rule that failed says so in its result
+	jsonOK(w, map[string][]retention.Result{"results": results})
+}
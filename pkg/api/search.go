@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// textHitView is a text search hit as the API returns it.
+type textHitView struct {
+	Record recordView `json:"record"`
+	Score  float64    `json:"score"`
+}
+
+// handleTextSearch searches the text index on ?field= for the words in
+// ?q=, best matches first, up to ?limit= of them.
+func (s *Server) handleTextSearch(w http.ResponseWriter, r *http.Request) {
+	ts, ok := s.engine.(types.TextSearcher)
+	if !ok {
+		http.Error(w, `{"error":"this engine has no text indexes"}`, http.StatusNotImplemented)
+		return
+	}
+	q := r.URL.Query()
+	field, query := q.Get("field"), q.Get("q")
+	if field == "" || query == "" {
+		http.Error(w, `{"error":"field and q are required"}`, http.StatusBadRequest)
+		return
+	}
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, `{"error":"limit must be a non-negative integer"}`, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	consistency, ok := s.parseConsistency(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := routeContext(r, s.timeouts.Read)
+	defer cancel()
+	err := consistency.await(ctx, s.engine)
+	var hits []types.TextHit
+	if err == nil {
+		hits, err = ts.TextSearch(ctx, field, query, limit)
+	}
+	if timedOut(w, r, ctx, "text search", s.timeouts.Read) {
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	items := make([]textHitView, len(hits))
+	for i, hit := range hits {
+		items[i] = textHitView{Record: viewOf(hit.Record), Score: hit.Score}
+	}
+	jsonWithin(w, r, ctx, s.timeouts.Read, listResponse{Items: items, Count: len(items)})
+}
@@ -1,75 +1,293 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
-	"runtime"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/thirawat27/kvi/internal/backup"
 	"github.com/thirawat27/kvi/internal/pubsub"
 	"github.com/thirawat27/kvi/internal/sql"
+	"github.com/thirawat27/kvi/internal/tracing"
+	"github.com/thirawat27/kvi/pkg/auth"
+	"github.com/thirawat27/kvi/pkg/collection"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/keygen"
+	"github.com/thirawat27/kvi/pkg/retention"
+	"github.com/thirawat27/kvi/pkg/shadow"
+	"github.com/thirawat27/kvi/pkg/stats"
 	"github.com/thirawat27/kvi/pkg/types"
 )
 
 type Server struct {
 	engine    types.Engine
 	hub       *pubsub.Hub
+	grpcCalls *stats.Calls
 	executor  *sql.Executor
+	tracer    *tracing.Tracer // nil traces nothing
+	keys      keygen.Generator
 	startTime time.Time
-	authOn    bool // set to true to require JWT on all routes
+	auth      *auth.Authenticator // nil disables authentication
+	heartbeat time.Duration
+	sseRetry  time.Duration
+
+	readLimiter  *rateLimiter
+	writeLimiter *rateLimiter
+
+	conns   *stats.Pool // open connections; nil counts nothing
+	streams *stats.Pool // SSE subscriptions
+
+	maintenance sync.Mutex // held by a restore or maintenance job
+	jobs        jobTracker
+	snapshots   backup.Store
+	backupS3    *config.S3Config // set: GET /api/v1/backup takes ?dest=s3://
+
+	// live holds the settings Reconfigure can change; next is the copy its
+	// options write to while it runs
+	live        atomic.Pointer[tunables]
+	next        *tunables
+	reconfigure sync.Mutex
+
+	timeouts Timeouts
+
+	config   ConfigSource
+	replica  Replica
+	cdcStats func() *stats.CDCStats
+	shadow   *shadow.Shadow
+	mirror   *mirror
+	// retention applies the rules of /api/v1/admin/retention; nil serves 404
+	retention *retention.Retention
+	// queryCache answers SELECTs through executor; fresh runs them past it
+	queryCache  *sql.Cache
+	fresh       *sql.Executor
+	liveQueries *sql.LiveQueries // nil if the engine has no change feed
+
+	log *slog.Logger
+
+	lifecycle  sync.Mutex
+	httpServer *http.Server
+	stopping   chan struct{} // closed by Shutdown to end long-lived streams
+	stopOnce   sync.Once
+}
+
+// tunables are the settings Reconfigure can change while the server runs.
+// A request reads them once, through s.tunables().
+type tunables struct {
+	accessLog AccessLog
+
+	dataDir     string // checked for free space by /health/ready; "" skips
+	minFreeDisk uint64
+
+	corsPolicy config.CORSConfig
+
+	maxRequestBytes int64
+	maxImportBytes  int64
+
+	compression *compression
 }
 
 func NewServer(eng types.Engine, opts ...func(*Server)) *Server {
 	s := &Server{
 		engine:    eng,
 		hub:       pubsub.NewHub(),
-		executor:  sql.NewExecutor(eng),
 		startTime: time.Now(),
-		authOn:    false,
+		heartbeat: 15 * time.Second,
+		sseRetry:  3 * time.Second,
+
+		readLimiter:  newRateLimiter(RateLimit{}),
+		writeLimiter: newRateLimiter(RateLimit{}),
+		mirror:       newMirror(),
+		stopping:     make(chan struct{}),
+
+		timeouts: DefaultTimeouts(),
+		log:      slog.Default(),
+		keys:     keygen.NewULID(),
 	}
+	s.live.Store(&tunables{
+		corsPolicy:      config.DefaultCORS(),
+		maxRequestBytes: DefaultMaxRequestBytes,
+		maxImportBytes:  DefaultMaxImportBytes,
+		compression:     &compression{level: DefaultCompressionLevel, minBytes: DefaultCompressMinBytes},
+	})
 	for _, o := range opts {
 		o(s)
 	}
+	s.executor = sql.NewExecutor(eng, sql.WithTracer(s.tracer), sql.WithKeyGenerator(s.keys), sql.WithCache(s.queryCache))
+	s.fresh = sql.NewExecutor(eng, sql.WithTracer(s.tracer), sql.WithKeyGenerator(s.keys))
+	if s.liveQueries == nil {
+		s.liveQueries, _ = sql.NewLiveQueries(eng, sql.DefaultLiveDebounce, sql.DefaultLivePerClient)
+	}
 	return s
 }
 
-// WithAuth enables JWT authentication on all routes except /health and /api/v1/auth.
-func WithAuth() func(*Server) {
-	return func(s *Server) { s.authOn = true }
+// Reconfigure applies opts to the running server. Requests already in
+// flight finish with the settings they started with. Only the options for
+// settings that can change live may be passed: WithAccessLog, WithCORS,
+// WithBodyLimits, WithCompression, WithDiskCheck, WithRateLimits and
+// WithMirror.
+func (s *Server) Reconfigure(opts ...func(*Server)) {
+	s.reconfigure.Lock()
+	defer s.reconfigure.Unlock()
+
+	next := *s.live.Load()
+	s.next = &next
+	for _, o := range opts {
+		o(s)
+	}
+	s.next = nil
+	s.live.Store(&next)
 }
 
-// cors is a simple middleware that adds CORS headers.
-func cors(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+// tunables returns the settings in force.
+func (s *Server) tunables() *tunables { return s.live.Load() }
+
+// edit returns the settings an option should change: Reconfigure's copy,
+// or before the server starts the live ones.
+func (s *Server) edit() *tunables {
+	if s.next != nil {
+		return s.next
+	}
+	return s.live.Load()
+}
+
+// WithAuth enables JWT authentication on all routes except /health and the
+// token endpoints. Each route requires a minimum role.
+func WithAuth(a *auth.Authenticator) func(*Server) {
+	return func(s *Server) { s.auth = a }
+}
+
+// WithLogger sets where the server logs what no response reports, such as
+// the end of a maintenance job. Requests go to the AccessLog.
+func WithLogger(l *slog.Logger) func(*Server) {
+	return func(s *Server) { s.log = l }
+}
+
+// WithHub makes the server publish to and subscribe from an existing hub, so
+// the REST and gRPC APIs see the same channels. A nil hub disables pub/sub:
+// its routes are not registered.
+func WithHub(hub *pubsub.Hub) func(*Server) {
+	return func(s *Server) { s.hub = hub }
+}
+
+// WithGrpcCalls includes the gRPC server's call counters in /api/v1/stats.
+func WithGrpcCalls(calls *stats.Calls) func(*Server) {
+	return func(s *Server) { s.grpcCalls = calls }
+}
+
+// WithCDCStats includes change data capture in /api/v1/stats.
+func WithCDCStats(fn func() *stats.CDCStats) func(*Server) {
+	return func(s *Server) { s.cdcStats = fn }
 }
 
-func (s *Server) wrap(h http.HandlerFunc) http.HandlerFunc {
-	if s.authOn {
-		return s.authMiddleware(h)
+// WithHeartbeat sets how often idle SSE subscriptions receive a ": ping"
+// comment, keeping proxies and load balancers from cutting the connection.
+func WithHeartbeat(d time.Duration) func(*Server) {
+	return func(s *Server) { s.heartbeat = d }
+}
+
+// WithKeyGenerator sets what makes up the keys of records put with an
+// empty or "auto" key, REST and SQL alike; ULIDs by default.
+func WithKeyGenerator(g keygen.Generator) func(*Server) {
+	return func(s *Server) { s.keys = g }
+}
+
+// wrap applies the middleware stack: authentication, then rate limiting by
+// the route's role so reads and writes are limited independently.
+func (s *Server) wrap(required auth.Role, h http.HandlerFunc) http.HandlerFunc {
+	h = s.rateLimitMiddleware(s.limiterFor(required), h)
+	if s.auth != nil {
+		h = s.authMiddleware(required, h)
+	}
+	if s.tracer != nil {
+		h = nameSpan(h)
 	}
 	return h
 }
 
 func (s *Server) RegisterHandlers(mux *http.ServeMux) {
 	mux.HandleFunc("/api/v1/auth", s.handleAuth)
-	mux.HandleFunc("/api/v1/get", s.wrap(s.handleGet))
-	mux.HandleFunc("/api/v1/put", s.wrap(s.handlePut))
-	mux.HandleFunc("/api/v1/delete", s.wrap(s.handleDelete))
-	mux.HandleFunc("/api/v1/query", s.wrap(s.handleQuery))
-	mux.HandleFunc("/api/v1/pub", s.wrap(s.handlePub))
-	mux.HandleFunc("/api/v1/sub", s.wrap(s.handleSub)) // SSE
-	mux.HandleFunc("/api/v1/stats", s.wrap(s.handleStats))
-	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/api/v1/auth/refresh", s.handleRefresh)
+	mux.HandleFunc("/api/v1/get", s.wrap(auth.RoleRead, s.handleGet))
+	mux.HandleFunc("/api/v1/put", s.wrap(auth.RoleWrite, s.handlePut))
+	mux.HandleFunc("/api/v1/delete", s.wrap(auth.RoleWrite, s.handleDelete))
+	mux.HandleFunc("PATCH /api/v1/patch", s.wrap(auth.RoleWrite, s.handlePatch))
+	mux.HandleFunc("POST /api/v1/batch", s.wrap(auth.RoleWrite, s.handleBatch))
+	mux.HandleFunc("POST /api/v1/copy", s.wrap(auth.RoleWrite, s.handleMove(false)))
+	mux.HandleFunc("POST /api/v1/rename", s.wrap(auth.RoleWrite, s.handleMove(true)))
+	mux.HandleFunc("GET /api/v1/scan", s.wrap(auth.RoleRead, s.handleScan))
+	mux.HandleFunc("GET /api/v1/changes", s.wrap(auth.RoleRead, s.handleChanges))
+	mux.HandleFunc("POST /api/v1/list/lpush", s.wrap(auth.RoleWrite, s.handlePush(collection.LPush)))
+	mux.HandleFunc("POST /api/v1/list/rpush", s.wrap(auth.RoleWrite, s.handlePush(collection.RPush)))
+	mux.HandleFunc("POST /api/v1/list/lpop", s.wrap(auth.RoleWrite, s.handlePop(collection.LPop)))
+	mux.HandleFunc("POST /api/v1/list/rpop", s.wrap(auth.RoleWrite, s.handlePop(collection.RPop)))
+	mux.HandleFunc("GET /api/v1/list/lrange", s.wrap(auth.RoleRead, s.handleLRange))
+	mux.HandleFunc("POST /api/v1/set/sadd", s.wrap(auth.RoleWrite, s.handleSetChange(collection.SAdd, "added")))
+	mux.HandleFunc("POST /api/v1/set/srem", s.wrap(auth.RoleWrite, s.handleSetChange(collection.SRem, "removed")))
+	mux.HandleFunc("GET /api/v1/set/smembers", s.wrap(auth.RoleRead, s.handleSMembers))
+	mux.HandleFunc("GET /api/v1/set/sismember", s.wrap(auth.RoleRead, s.handleSIsMember))
+	mux.HandleFunc("POST /api/v1/zset/zadd", s.wrap(auth.RoleWrite, s.handleZAdd))
+	mux.HandleFunc("POST /api/v1/zset/zincrby", s.wrap(auth.RoleWrite, s.handleZIncrBy))
+	mux.HandleFunc("POST /api/v1/zset/zrem", s.wrap(auth.RoleWrite, s.handleSetChange(collection.ZRem, "removed")))
+	mux.HandleFunc("GET /api/v1/zset/zrangebyscore", s.wrap(auth.RoleRead, s.handleZRangeByScore))
+	mux.HandleFunc("GET /api/v1/zset/zrank", s.wrap(auth.RoleRead, s.handleZRank))
+	mux.HandleFunc("POST /api/v1/lock", s.wrap(auth.RoleWrite, s.handleLock))
+	mux.HandleFunc("POST /api/v1/unlock", s.wrap(auth.RoleWrite, s.handleUnlock))
+	mux.HandleFunc("GET /api/v1/search/text", s.wrap(auth.RoleRead, s.handleTextSearch))
+	mux.HandleFunc("/api/v1/query", s.wrap(auth.RoleRead, s.handleQuery))              // writes re-checked per statement
+	mux.HandleFunc("GET /api/v1/query/live", s.wrap(auth.RoleRead, s.handleLiveQuery)) // SSE
+	if s.hub != nil {
+		mux.HandleFunc("/api/v1/pub", s.wrap(auth.RoleWrite, s.handlePub))
+		mux.HandleFunc("/api/v1/sub", s.wrap(auth.RoleRead, s.handleSub)) // SSE
+		mux.HandleFunc("/api/v1/ack", s.wrap(auth.RoleRead, s.handleAck))
+		mux.HandleFunc("GET /api/v1/channels", s.wrap(auth.RoleRead, s.handleChannels))
+		mux.HandleFunc("POST /api/v1/channels", s.wrap(auth.RoleAdmin, s.handleChannels))
+		mux.HandleFunc("GET /api/v1/channels/{name}/history", s.wrap(auth.RoleRead, s.handleChannelHistory))
+		mux.HandleFunc("DELETE /api/v1/channels/{name}", s.wrap(auth.RoleAdmin, s.handleDeleteChannel))
+	}
+	mux.HandleFunc("/api/v1/stats", s.wrap(auth.RoleRead, s.handleStats))
+	mux.HandleFunc("GET /api/v1/stats/collections", s.wrap(auth.RoleRead, s.handleCollectionStats))
+	mux.HandleFunc("GET /metrics", s.wrap(auth.RoleRead, s.handleMetrics))
+	mux.HandleFunc("GET /api/v1/backup", s.wrap(auth.RoleAdmin, s.handleBackup))
+	mux.HandleFunc("POST /api/v1/restore", s.wrap(auth.RoleAdmin, s.handleRestore))
+	mux.HandleFunc("GET /api/v1/admin/rate-limits", s.wrap(auth.RoleAdmin, s.handleRateLimits))
+	mux.HandleFunc("PUT /api/v1/admin/rate-limits", s.wrap(auth.RoleAdmin, s.handleRateLimits))
+	for _, op := range maintenanceOps {
+		mux.HandleFunc("POST /api/v1/admin/"+op, s.wrap(auth.RoleAdmin, s.handleMaintenance(op)))
+	}
+	mux.HandleFunc("POST /api/v1/sync", s.wrap(auth.RoleAdmin, s.handleSync))
+	mux.HandleFunc("POST /api/v1/admin/indexes/flush", s.wrap(auth.RoleAdmin, s.handleFlushIndexes))
+	mux.HandleFunc("GET /api/v1/admin/pins", s.wrap(auth.RoleAdmin, s.handlePins))
+	mux.HandleFunc("PUT /api/v1/admin/pins", s.wrap(auth.RoleAdmin, s.handlePins))
+	mux.HandleFunc("DELETE /api/v1/admin/pins", s.wrap(auth.RoleAdmin, s.handlePins))
+	mux.HandleFunc("GET /api/v1/admin/jobs/{id}", s.wrap(auth.RoleAdmin, s.handleJob))
+	mux.HandleFunc("GET /api/v1/admin/config", s.wrap(auth.RoleAdmin, s.handleConfig))
+	mux.HandleFunc("POST /api/v1/admin/reload", s.wrap(auth.RoleAdmin, s.handleReload))
+	mux.HandleFunc("GET /api/v1/admin/replication", s.wrap(auth.RoleAdmin, s.handleReplication))
+	mux.HandleFunc("POST /api/v1/admin/promote", s.wrap(auth.RoleAdmin, s.handlePromote))
+	mux.HandleFunc("GET /api/v1/admin/shadow", s.wrap(auth.RoleAdmin, s.handleShadow))
+	mux.HandleFunc("GET /api/v1/admin/mirror", s.wrap(auth.RoleAdmin, s.handleMirror))
+	mux.HandleFunc("PUT /api/v1/admin/mirror", s.wrap(auth.RoleAdmin, s.handleMirror))
+	mux.HandleFunc("GET /api/v1/admin/vector-filters", s.wrap(auth.RoleAdmin, s.handleVectorFilters))
+	mux.HandleFunc("PUT /api/v1/admin/vector-filters", s.wrap(auth.RoleAdmin, s.handleVectorFilters))
+	mux.HandleFunc("GET /api/v1/admin/retention", s.wrap(auth.RoleAdmin, s.handleRetention))
+	mux.HandleFunc("PUT /api/v1/admin/retention", s.wrap(auth.RoleAdmin, s.handleRetention))
+	mux.HandleFunc("DELETE /api/v1/admin/retention", s.wrap(auth.RoleAdmin, s.handleRetention))
+	mux.HandleFunc("POST /api/v1/admin/retention/run", s.wrap(auth.RoleAdmin, s.handleRetentionRun))
+	mux.HandleFunc("POST /api/v1/admin/query-cache/flush", s.wrap(auth.RoleAdmin, s.handleFlushQueryCache))
+	mux.HandleFunc("/health", s.handleLive) // kept for existing probes
+	mux.HandleFunc("GET /health/live", s.handleLive)
+	mux.HandleFunc("GET /health/ready", s.handleReady)
 }
 
 // ── GET ──────────────────────────────────────────────────────────────────────
@@ -80,19 +298,97 @@ func (s *Server) handleGet(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"missing 'key' query parameter"}`, http.StatusBadRequest)
 		return
 	}
-	record, err := s.engine.Get(r.Context(), key)
+	consistency, ok := s.parseConsistency(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := routeContext(r, s.timeouts.Read)
+	defer cancel()
+	err := consistency.await(ctx, s.engine)
+	var record *types.Record
+	if err == nil {
+		record, err = s.engine.Get(ctx, key)
+	}
+	if timedOut(w, r, ctx, "engine get", s.timeouts.Read) {
+		return
+	}
+	if s.shadow != nil && (err == nil || errors.Is(err, types.ErrKeyNotFound)) {
+		s.shadow.Get(key, record)
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusNotFound)
+		writeEngineError(w, err)
 		return
 	}
-	jsonOK(w, record)
+	setETag(w, record.Version)
+	jsonOK(w, viewOf(record))
+}
+
+// recordView is a record as the API returns it, with the time left before
+// its TTL runs out.
+type recordView struct {
+	*types.Record
+	ExpiresIn *int64 `json:"expires_in_seconds,omitempty"`
+}
+
+func viewOf(rec *types.Record) recordView {
+	v := recordView{Record: rec}
+	if secs, ok := rec.SecondsLeft(time.Now()); ok {
+		v.ExpiresIn = &secs
+	}
+	return v
 }
 
 // ── PUT ──────────────────────────────────────────────────────────────────────
 
 type putRequest struct {
-	Key  string                 `json:"key"`
-	Data map[string]interface{} `json:"data"`
+	Key string `json:"key"` // "" or "auto": made up by the key generator
+	// KeyPrefix starts a made-up key, so the records put under it sort in
+	// the order they were put.
+	KeyPrefix string                 `json:"key_prefix"`
+	Data      map[string]interface{} `json:"data"`
+	Vector    []float32              `json:"vector"` // indexed in vector and hybrid modes
+	TTL       *time.Time             `json:"ttl"`    // absolute expiry, RFC 3339
+	// TTLSeconds expires the record this many seconds from now by the
+	// server's clock and takes precedence over TTL; <= 0 means no expiry.
+	TTLSeconds  *int64   `json:"ttl_seconds"`
+	Blob        []byte   `json:"blob"` // base64
+	ContentType string   `json:"content_type"`
+	Tags        []string `json:"tags"`
+}
+
+// check fails for a put request the engine must not see.
+func (req putRequest) check() error {
+	if req.KeyPrefix != "" && !keygen.IsAuto(req.Key) {
+		return errors.New("key_prefix needs an empty or auto key")
+	}
+	if slices.Contains(req.Tags, "") {
+		return errors.New("tags must not be empty")
+	}
+	return nil
+}
+
+// fillKey makes up req's key if it asks for one.
+func (s *Server) fillKey(ctx context.Context, req *putRequest) error {
+	if !keygen.IsAuto(req.Key) {
+		return nil
+	}
+	key, err := s.keys.Key(ctx, req.KeyPrefix)
+	req.Key = key
+	return err
+}
+
+// record is the record req asks to store.
+func (req putRequest) record() *types.Record {
+	record := &types.Record{ID: req.Key, Data: req.Data, Vector: req.Vector, TTL: req.TTL,
+		Blob: req.Blob, ContentType: req.ContentType, Tags: req.Tags}
+	if req.TTLSeconds != nil {
+		record.TTL = nil
+		if *req.TTLSeconds > 0 {
+			expires := time.Now().Add(time.Duration(*req.TTLSeconds) * time.Second)
+			record.TTL = &expires
+		}
+	}
+	return record
 }
 
 func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
@@ -101,21 +397,55 @@ func (s *Server) handlePut(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req putRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
 		return
 	}
-	if req.Key == "" {
-		http.Error(w, `{"error":"key is required"}`, http.StatusBadRequest)
+	if err := req.check(); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
 		return
 	}
-	record := &types.Record{ID: req.Key, Data: req.Data}
-	if err := s.engine.Put(r.Context(), req.Key, record); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := s.fillKey(r.Context(), &req); err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	record := req.record()
+	// durable=true answers only once the write is synced to the WAL
+	syncer, _ := s.engine.(types.Syncer)
+	durable := r.URL.Query().Get("durable") == "true"
+	if durable && syncer == nil {
+		http.Error(w, `{"error":"this engine has no WAL to make writes durable in"}`, http.StatusNotImplemented)
+		return
+	}
+	version, conditional, err := s.precondition(r, req.Key)
+	if err != nil {
+		writeConditionalError(w, err)
 		return
 	}
+	ctx, cancel := routeContext(r, s.timeouts.Write)
+	defer cancel()
+	if conditional {
+		err = s.engine.CompareAndSwap(ctx, req.Key, version, record)
+	} else {
+		err = s.engine.Put(ctx, req.Key, record)
+	}
+	if timedOut(w, r, ctx, "engine put", s.timeouts.Write) {
+		return
+	}
+	if err != nil {
+		writeConditionalError(w, err)
+		return
+	}
+	if durable {
+		if err := syncer.Sync(ctx); err != nil {
+			writeEngineError(w, err)
+			return
+		}
+	}
+	setETag(w, record.Version)
+	setWatermark(w, s.engine)
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	jsonOK(w, map[string]string{"status": "ok", "key": req.Key})
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "key": req.Key, "version": record.Version})
 }
 
 // ── DELETE ───────────────────────────────────────────────────────────────────
@@ -126,17 +456,207 @@ func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, `{"error":"missing 'key' query parameter"}`, http.StatusBadRequest)
 		return
 	}
-	if err := s.engine.Delete(r.Context(), key); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	version, conditional, err := s.precondition(r, key)
+	if err != nil {
+		writeConditionalError(w, err)
+		return
+	}
+	ctx, cancel := routeContext(r, s.timeouts.Write)
+	defer cancel()
+	if conditional {
+		err = s.engine.CompareAndSwap(ctx, key, version, nil)
+	} else {
+		err = s.engine.Delete(ctx, key)
+	}
+	if timedOut(w, r, ctx, "engine delete", s.timeouts.Write) {
+		return
+	}
+	if err != nil {
+		writeConditionalError(w, err)
 		return
 	}
 	jsonOK(w, map[string]string{"status": "ok", "deleted_key": key})
 }
 
+// ── SCAN ─────────────────────────────────────────────────────────────────────
+
+const (
+	// scanFlushEvery is how many NDJSON lines are written between flushes.
+	scanFlushEvery = 256
+	// scanWriteTimeout bounds each flushed chunk of a streamed scan, replacing
+	// the server-wide WriteTimeout that a long scan would otherwise hit.
+	scanWriteTimeout = 30 * time.Second
+)
+
+// handleScan returns records whose key starts with prefix, in key order,
+// as a listResponse. A limited scan that stopped early sets truncated and
+// next_cursor; passing that back as cursor continues after the last record.
+// With stream=true or Accept: application/x-ndjson the records are written
+// one JSON object per line as the engine yields them, so memory stays flat
+// regardless of result size. consistent=true scans a snapshot taken as the
+// scan starts, where the engine can; a cursor then continues in a new one.
+// tag limits the scan to records with that tag. consistency and after
+// ask for a read-your-writes or strong scan, as for a get.
+func (s *Server) handleScan(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	limit := 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, `{"error":"limit must be a non-negative integer"}`, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	after, err := decodeCursor(q.Get("cursor"))
+	if err != nil {
+		http.Error(w, `{"error":"invalid cursor"}`, http.StatusBadRequest)
+		return
+	}
+	proj, err := parseProjection(q)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error":%q}`, err.Error()), http.StatusBadRequest)
+		return
+	}
+	scan := s.engine.Scan
+	if ps, ok := s.engine.(types.ProjectingScanner); ok && proj != nil {
+		scan = func(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+			return ps.ScanProjected(ctx, prefix, proj, fn)
+		}
+	}
+	tag := q.Get("tag")
+	switch {
+	case tag != "" && q.Get("consistent") == "true":
+		http.Error(w, `{"error":"a scan by tag cannot be consistent"}`, http.StatusBadRequest)
+		return
+	case tag != "":
+		ts, ok := s.engine.(types.TagScanner)
+		if !ok {
+			http.Error(w, `{"error":"this engine has no tag index"}`, http.StatusNotImplemented)
+			return
+		}
+		scan = func(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+			return ts.ScanTag(ctx, tag, prefix, proj.Wrap(fn))
+		}
+	case q.Get("consistent") == "true":
+		cs, ok := s.engine.(types.ConsistentScanner)
+		if !ok {
+			http.Error(w, `{"error":"this engine cannot scan a snapshot"}`, http.StatusNotImplemented)
+			return
+		}
+		scan = func(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+			return cs.ScanConsistent(ctx, prefix, proj.Wrap(fn))
+		}
+	}
+	consistency, ok := s.parseConsistency(w, r)
+	if !ok {
+		return
+	}
+	scan = consistency.scan(s.engine, scan)
+
+	if q.Get("stream") == "true" || strings.Contains(r.Header.Get("Accept"), "application/x-ndjson") {
+		s.streamScan(w, r, scan, prefix, after, limit)
+		return
+	}
+
+	ctx, cancel := routeContext(r, s.timeouts.Read)
+	defer cancel()
+	records := []recordView{}
+	err = scan(ctx, prefix, func(rec *types.Record) bool {
+		if after != "" && rec.ID <= after {
+			return true
+		}
+		records = append(records, viewOf(rec))
+		return limit == 0 || len(records) <= limit // one extra tells us there is more
+	})
+	if timedOut(w, r, ctx, "engine scan", s.timeouts.Read) {
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	var list listResponse
+	if limit > 0 && len(records) > limit {
+		records = records[:limit]
+		list.Truncated = true
+		list.NextCursor = encodeCursor(records[limit-1].ID)
+	}
+	if s.shadow != nil && tag == "" {
+		s.shadowScan(prefix, after, proj, records, list.Truncated)
+	}
+	if legacyEnvelope(w, r) {
+		jsonWithin(w, r, ctx, s.timeouts.Read, records)
+		return
+	}
+	list.Items, list.Count = records, len(records)
+	jsonWithin(w, r, ctx, s.timeouts.Read, list)
+}
+
+// parseProjection reads a scan's projection: ?fields=name,status keeps
+// only those Data entries and ?include_vector=false drops the vector. It
+// is nil, for whole records, when neither is given.
+func parseProjection(q url.Values) (*types.Projection, error) {
+	fields, vector := q.Get("fields"), q.Get("include_vector")
+	if fields == "" && vector == "" {
+		return nil, nil
+	}
+	p := &types.Projection{Vector: true}
+	if fields != "" {
+		p.Fields = strings.Split(fields, ",")
+	}
+	if vector != "" {
+		keep, err := strconv.ParseBool(vector)
+		if err != nil {
+			return nil, errors.New("include_vector must be true or false")
+		}
+		p.Vector = keep
+	}
+	return p, nil
+}
+
+func (s *Server) streamScan(w http.ResponseWriter, r *http.Request, scan func(context.Context, string, func(*types.Record) bool) error, prefix, after string, limit int) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	_ = rc.SetWriteDeadline(time.Now().Add(scanWriteTimeout))
+
+	enc := json.NewEncoder(w)
+	var sent int
+	var writeErr error
+	err := scan(r.Context(), prefix, func(rec *types.Record) bool {
+		select {
+		case <-s.stopping:
+			return false
+		default:
+		}
+		if after != "" && rec.ID <= after {
+			return true
+		}
+		if writeErr = enc.Encode(viewOf(rec)); writeErr != nil {
+			return false // client went away
+		}
+		sent++
+		if sent%scanFlushEvery == 0 {
+			if writeErr = rc.Flush(); writeErr != nil {
+				return false
+			}
+			_ = rc.SetWriteDeadline(time.Now().Add(scanWriteTimeout))
+		}
+		return limit == 0 || sent < limit
+	})
+	if err != nil && writeErr == nil && r.Context().Err() == nil {
+		// Headers are already out; report the failure as a final line
+		enc.Encode(map[string]string{"error": err.Error()})
+	}
+	_ = rc.Flush()
+}
+
 // ── SQL QUERY ────────────────────────────────────────────────────────────────
 
 type queryRequest struct {
 	Query string `json:"query"`
+	Cache *bool  `json:"cache"` // false skips the query cache, as ?cache=false does
 }
 
 func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
@@ -145,23 +665,116 @@ func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req queryRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	consistency, ok := s.parseConsistency(w, r)
+	if !ok {
+		return
+	}
+	if !sql.IsReadOnly(req.Query) {
+		if err := auth.Check(r.Context(), auth.RoleWrite); err != nil {
+			http.Error(w, "Forbidden - "+err.Error(), http.StatusForbidden)
+			return
+		}
+		if !s.admit(s.writeLimiter, w, r) {
+			return
+		}
+	}
+	ctx, cancel := routeContext(r, s.timeouts.Query)
+	defer cancel()
+	if err := consistency.await(ctx, s.engine); err != nil {
+		if !timedOut(w, r, ctx, "consistency wait", s.timeouts.Query) {
+			writeEngineError(w, err)
+		}
+		return
+	}
+	executor := s.executor
+	if (req.Cache != nil && !*req.Cache) || r.URL.Query().Get("cache") == "false" {
+		executor = s.fresh
+	}
+	result, err := executor.Execute(ctx, req.Query)
+	if queryTimedOut(w, r, ctx, err, s.timeouts.Query) {
+		return
+	}
+	if errors.Is(err, types.ErrReadOnly) {
+		writeEngineError(w, err)
 		return
 	}
-	result, err := s.executor.ExecuteQuery(r.Context(), req.Query)
 	if err != nil {
 		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Error()), http.StatusBadRequest)
 		return
 	}
-	jsonOK(w, result)
+	if legacyEnvelope(w, r) {
+		jsonWithin(w, r, ctx, s.timeouts.Query, result.Value)
+		return
+	}
+	items := queryItems(result.Value)
+	jsonWithin(w, r, ctx, s.timeouts.Query, queryResponse{
+		listResponse: listResponse{Items: items, Count: len(items)},
+		Columns:      result.Columns,
+		RowsScanned:  result.RowsScanned,
+		DurationMs:   float64(result.Duration.Microseconds()) / 1000,
+		Cached:       result.Cached,
+	})
+}
+
+// WithQueryCache answers SQL SELECTs from c while their results are
+// current, unless a request asks otherwise with "cache": false or
+// ?cache=false. Its stats go in /api/v1/stats.
+func WithQueryCache(c *sql.Cache) func(*Server) {
+	return func(s *Server) { s.queryCache = c }
+}
+
+// handleFlushQueryCache empties the query cache.
+func (s *Server) handleFlushQueryCache(w http.ResponseWriter, r *http.Request) {
+	if s.queryCache == nil {
+		http.Error(w, `{"error":"the query cache is off"}`, http.StatusNotFound)
+		return
+	}
+	jsonOK(w, map[string]int{"flushed": s.queryCache.Flush()})
+}
+
+// queryResponse adds the executor's cost, and for a SELECT the columns it
+// named, to a query's rows.
+type queryResponse struct {
+	listResponse
+	Columns     []string `json:"columns,omitempty"`
+	RowsScanned int      `json:"rows_scanned"`
+	DurationMs  float64  `json:"duration_ms"`
+	Cached      bool     `json:"cached,omitempty"`
+}
+
+// queryItems lists a statement's result as rows: the selected records, or
+// one status object per affected record.
+func queryItems(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case *types.Record:
+		return []interface{}{viewOf(v)}
+	case []*types.Record:
+		items := make([]interface{}, len(v))
+		for i, rec := range v {
+			items[i] = viewOf(rec)
+		}
+		return items
+	case []map[string]string:
+		items := make([]interface{}, len(v))
+		for i, row := range v {
+			items[i] = row
+		}
+		return items
+	default:
+		return []interface{}{v}
+	}
 }
 
 // ── PUB/SUB ──────────────────────────────────────────────────────────────────
 
 type pubRequest struct {
-	Channel string `json:"channel"`
-	Message string `json:"message"`
+	Channel   string      `json:"channel"`
+	Message   interface{} `json:"message"`    // strings are sent as text, anything else as JSON
+	TTL       float64     `json:"ttl"`        // seconds; 0 keeps the message for the channel's retention
+	DeliverAt *time.Time  `json:"deliver_at"` // RFC 3339; holds the message until then
 }
 
 func (s *Server) handlePub(w http.ResponseWriter, r *http.Request) {
@@ -170,86 +783,343 @@ func (s *Server) handlePub(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req pubRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if req.Message == nil {
+		req.Message = ""
+	}
+	payload, contentType, err := pubsub.EncodePayload(req.Message)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	count := s.hub.Publish(req.Channel, req.Message)
+	opts := []pubsub.PublishOption{pubsub.WithContentType(contentType)}
+	if req.TTL > 0 {
+		opts = append(opts, pubsub.WithTTL(time.Duration(req.TTL*float64(time.Second))))
+	}
+	if req.DeliverAt != nil {
+		opts = append(opts, pubsub.WithDeliverAt(*req.DeliverAt))
+	}
+	count := s.hub.Publish(req.Channel, payload, opts...)
 	jsonOK(w, map[string]interface{}{"status": "ok", "receivers": count})
 }
 
 // handleSub registers an SSE subscriber and streams pub/sub messages.
+// Every event carries the message ID, so a reconnecting client that sends
+// Last-Event-ID is first replayed whatever it missed from channel history.
 func (s *Server) handleSub(w http.ResponseWriter, r *http.Request) {
 	channel := r.URL.Query().Get("channel")
 	subID := r.URL.Query().Get("id")
+	group := r.URL.Query().Get("group")
 	if channel == "" || subID == "" {
 		http.Error(w, `{"error":"channel and id query params required"}`, http.StatusBadRequest)
 		return
 	}
 
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "streaming not supported", http.StatusInternalServerError)
 		return
 	}
+	if !s.acquireStream(w, r) {
+		return
+	}
+	defer s.streams.Release()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	if w.Header().Get("Connection") == "" {
+		w.Header().Set("Connection", "keep-alive")
+	}
+
+	// The stream outlives the server's WriteTimeout; heartbeats keep it honest instead
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+
+	// Subscribers sharing a group split the channel's messages between them
+	sub := s.hub.SubscribeGroup(channel, group, subID)
+	defer sub.Unsubscribe()
+
+	fmt.Fprintf(w, "retry: %d\n\n", s.sseRetry.Milliseconds())
+
+	// Replay history after Last-Event-ID. Groups resume from their own cursor.
+	var replayed uint64
+	if after, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil && group == "" {
+		history, _ := s.hub.History(channel, 0)
+		for _, msg := range history {
+			if msg.ID > after {
+				writeEvent(w, msg)
+				replayed = msg.ID
+			}
+		}
+	}
+	flusher.Flush()
 
-	sub := s.hub.Subscribe(channel, subID)
-	defer s.hub.Unsubscribe(channel, subID)
+	ticker := time.NewTicker(s.heartbeat)
+	defer ticker.Stop()
 
 	ctx := r.Context()
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-s.stopping:
+			return
+		case <-ticker.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
 		case msg, open := <-sub.C:
 			if !open {
 				return
 			}
-			fmt.Fprintf(w, "data: %s\n\n", msg.Payload)
+			if msg.Channel == channel && msg.ID <= replayed {
+				continue // already sent during replay
+			}
+			writeEvent(w, msg)
 			flusher.Flush()
 		}
 	}
 }
 
+// writeEvent writes msg as one SSE event. Multi-line payloads become multiple
+// data lines so they cannot terminate the event early.
+func writeEvent(w http.ResponseWriter, msg pubsub.Message) {
+	data := msg.Payload
+	if msg.AckToken != "" {
+		// Ack-mode deliveries carry the token the client must POST to /api/v1/ack
+		b, _ := json.Marshal(msg)
+		data = string(b)
+	}
+	fmt.Fprintf(w, "id: %d\n", msg.ID)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+type ackRequest struct {
+	Channel string `json:"channel"`
+	Token   string `json:"token"`
+}
+
+func (s *Server) handleAck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req ackRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	if !s.hub.Ack(req.Channel, req.Token) {
+		http.Error(w, `{"error":"unknown or expired ack token"}`, http.StatusNotFound)
+		return
+	}
+	jsonOK(w, map[string]string{"status": "ok"})
+}
+
+// ── CHANNELS ─────────────────────────────────────────────────────────────────
+
+type channelRequest struct {
+	Name                string             `json:"name"`
+	Mode                pubsub.ChannelMode `json:"mode"`
+	VisibilityTimeoutMs int64              `json:"visibility_timeout_ms"`
+}
+
+func (s *Server) handleChannels(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		jsonOK(w, s.hub.ListChannels())
+	case http.MethodPost:
+		var req channelRequest
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if req.Name == "" {
+			http.Error(w, `{"error":"name is required"}`, http.StatusBadRequest)
+			return
+		}
+		if req.Mode != "" && req.Mode != pubsub.ModeFireAndForget && req.Mode != pubsub.ModeAck {
+			http.Error(w, fmt.Sprintf(`{"error":"unknown channel mode: %s"}`, req.Mode), http.StatusBadRequest)
+			return
+		}
+		s.hub.ConfigureChannel(req.Name, pubsub.ChannelOptions{
+			Mode:              req.Mode,
+			VisibilityTimeout: time.Duration(req.VisibilityTimeoutMs) * time.Millisecond,
+		})
+		jsonOK(w, map[string]string{"status": "ok", "channel": req.Name})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) handleChannelHistory(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, `{"error":"limit must be a non-negative integer"}`, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	history, ok := s.hub.History(name, limit)
+	if !ok {
+		http.Error(w, fmt.Sprintf(`{"error":"channel not found: %s"}`, name), http.StatusNotFound)
+		return
+	}
+	jsonOK(w, history)
+}
+
+func (s *Server) handleDeleteChannel(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !s.hub.DeleteChannel(name) {
+		http.Error(w, fmt.Sprintf(`{"error":"channel not found: %s"}`, name), http.StatusNotFound)
+		return
+	}
+	jsonOK(w, map[string]string{"status": "ok", "deleted_channel": name})
+}
+
 // ── STATS ─────────────────────────────────────────────────────────────────────
 
+// statsResponse adds the REST server's own counters to the shared report.
+type statsResponse struct {
+	stats.Report
+	RateLimits map[string]RateLimiterStats `json:"rate_limits"`
+}
+
 func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
-	var mem runtime.MemStats
-	runtime.ReadMemStats(&mem)
-	uptime := time.Since(s.startTime).Truncate(time.Second)
-	jsonOK(w, map[string]interface{}{
-		"uptime_seconds":  uptime.Seconds(),
-		"goroutines":      runtime.NumGoroutine(),
-		"mem_alloc_bytes": mem.Alloc,
-		"mem_total_bytes": mem.TotalAlloc,
-		"mem_sys_bytes":   mem.Sys,
-		"gc_cycles":       mem.NumGC,
+	report := stats.Collect(s.engine, s.hub, s.startTime)
+	report.GRPC = s.grpcCalls.Snapshot()
+	report.Connections, report.Streams = s.conns.Snapshot(), s.streams.Snapshot()
+	if s.replica.Stats != nil {
+		report.Replication = s.replica.Stats()
+	}
+	if s.cdcStats != nil {
+		report.CDC = s.cdcStats()
+	}
+	if s.shadow != nil {
+		report.Shadow = s.shadow.Stats()
+		report.Shadow.Samples = nil
+	}
+	if s.queryCache != nil {
+		report.QueryCache = s.queryCache.Stats()
+	}
+	if s.liveQueries != nil {
+		report.LiveQueries = s.liveQueries.Stats()
+	}
+	if s.mirror.settings.Load().target != nil {
+		report.Mirror = s.mirror.stats()
+	}
+	if s.retention != nil {
+		if st, err := s.retention.Stats(r.Context()); err == nil && len(st.Rules) > 0 {
+			report.Retention = st
+		}
+	}
+	jsonOK(w, statsResponse{
+		Report:     report,
+		RateLimits: s.rateLimitStats(),
 	})
 }
 
-// ── HEALTH ────────────────────────────────────────────────────────────────────
+// handleCollectionStats describes the records under ?prefix=, or without
+// one lists the largest collections, up to ?limit= of them
+// (stats.TopCollections by default). Either may come from a cache up to
+// collection_stats_ttl_ms old.
+func (s *Server) handleCollectionStats(w http.ResponseWriter, r *http.Request) {
+	cs, ok := s.engine.(types.CollectionStatser)
+	if !ok {
+		http.Error(w, `{"error":"this engine cannot describe its collections"}`, http.StatusNotImplemented)
+		return
+	}
+	q := r.URL.Query()
+	limit := stats.TopCollections
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, `{"error":"limit must be a positive integer"}`, http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
 
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	jsonOK(w, map[string]string{"status": "ok", "engine": "kvi"})
+	ctx, cancel := routeContext(r, s.timeouts.Read)
+	defer cancel()
+	if q.Has("prefix") {
+		coll, err := cs.CollectionStats(ctx, q.Get("prefix"))
+		if timedOut(w, r, ctx, "collection stats", s.timeouts.Read) {
+			return
+		}
+		if err != nil {
+			writeEngineError(w, err)
+			return
+		}
+		jsonOK(w, coll)
+		return
+	}
+	top, err := cs.TopCollections(ctx, limit)
+	if timedOut(w, r, ctx, "collection stats", s.timeouts.Read) {
+		return
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	jsonOK(w, listResponse{Items: top, Count: len(top)})
 }
 
 // ── START ─────────────────────────────────────────────────────────────────────
 
-func (s *Server) Start(addr string) error {
+// Handler returns the routes wrapped in the server-wide middleware: request
+// IDs and access logging outermost, then body limits, mirroring and the
+// CORS policy.
+func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
 	s.RegisterHandlers(mux)
+	return s.traceRequests(s.logRequests(s.limitConns(s.compress(s.limitBodies(s.mirrorRequests(s.cors(mux)))))))
+}
+
+// Start listens on addr and serves until Shutdown, then returns
+// http.ErrServerClosed.
+func (s *Server) Start(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves the API on l until Shutdown, then returns http.ErrServerClosed.
+func (s *Server) Serve(l net.Listener) error {
 	srv := &http.Server{
-		Addr:         addr,
-		Handler:      cors(mux),
+		Handler:      s.Handler(),
 		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 30 * time.Second,
+		WriteTimeout: max(30*time.Second, s.timeouts.longest()+5*time.Second), // room for the 504
 		IdleTimeout:  60 * time.Second,
 	}
-	return srv.ListenAndServe()
+	if s.conns != nil {
+		tracker := &connTracker{pool: s.conns}
+		srv.ConnContext, srv.ConnState = tracker.connContext, tracker.connState
+	}
+	s.lifecycle.Lock()
+	s.httpServer = srv
+	s.lifecycle.Unlock()
+	return srv.Serve(l)
+}
+
+// Shutdown stops accepting connections, ends SSE subscriptions and
+// streamed scans, and waits for other in-flight requests until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stopping) })
+
+	s.lifecycle.Lock()
+	srv := s.httpServer
+	s.lifecycle.Unlock()
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
 }
 
 // ── HELPERS ───────────────────────────────────────────────────────────────────
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/thirawat27/kvi/pkg/shadow"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// WithShadow compares Gets and scan pages against sh's shadow source,
+// serves GET /api/v1/admin/shadow from it and adds its stats to
+// /api/v1/stats. Streamed scans and scans by tag are not compared.
+func WithShadow(sh *shadow.Shadow) func(*Server) {
+	return func(s *Server) { s.shadow = sh }
+}
+
+// handleShadow reports the comparisons, with the latest mismatches.
+func (s *Server) handleShadow(w http.ResponseWriter, r *http.Request) {
+	if s.shadow == nil {
+		http.Error(w, `{"error":"reads are not compared against a shadow"}`, http.StatusNotFound)
+		return
+	}
+	jsonOK(w, s.shadow.Stats())
+}
+
+// shadowScan hands a scan page to the shadow comparison.
+func (s *Server) shadowScan(prefix, after string, proj *types.Projection, records []recordView, truncated bool) {
+	page := shadow.ScanPage{Prefix: prefix, After: after, Projection: proj, Truncated: truncated,
+		Records: make([]*types.Record, len(records))}
+	for i, v := range records {
+		page.Records[i] = v.Record
+	}
+	s.shadow.Scan(page)
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// handleSync makes every write acknowledged so far durable, as before
+// copying the data directory.
+func (s *Server) handleSync(w http.ResponseWriter, r *http.Request) {
+	syncer, ok := s.engine.(types.Syncer)
+	if !ok {
+		http.Error(w, `{"error":"this engine has no WAL to sync"}`, http.StatusNotImplemented)
+		return
+	}
+	if err := syncer.Sync(r.Context()); err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	jsonOK(w, map[string]string{"status": "ok"})
+}
+
+// handleFlushIndexes applies the index updates queued behind writes
+// (async_indexing) before it answers, so searches see every write
+// acknowledged so far.
+func (s *Server) handleFlushIndexes(w http.ResponseWriter, r *http.Request) {
+	iq, ok := s.engine.(types.IndexQueuer)
+	if !ok {
+		http.Error(w, `{"error":"this engine has no indexes to flush"}`, http.StatusNotImplemented)
+		return
+	}
+	jsonOK(w, map[string]int{"flushed": iq.FlushIndexes()})
+}
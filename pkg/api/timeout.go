@@ -0,0 +1,84 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/sql"
+)
+
+// Timeouts bound how long a handler works on a request: Read for get and
+// scan, Write for put, delete and patch, Query for SQL. Zero means no bound.
+// Streamed scans are bounded per chunk instead (scanWriteTimeout).
+type Timeouts struct {
+	Read  time.Duration
+	Write time.Duration
+	Query time.Duration
+}
+
+// DefaultTimeouts returns the timeouts used unless WithTimeouts is given.
+func DefaultTimeouts() Timeouts {
+	return Timeouts{Read: 10 * time.Second, Write: 10 * time.Second, Query: 30 * time.Second}
+}
+
+// WithTimeouts sets the per-route handler timeouts.
+func WithTimeouts(t Timeouts) func(*Server) {
+	return func(s *Server) { s.timeouts = t }
+}
+
+// longest returns the largest of the timeouts.
+func (t Timeouts) longest() time.Duration {
+	return max(t.Read, t.Write, t.Query)
+}
+
+// routeContext bounds the request's context by d.
+func routeContext(r *http.Request, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), d)
+}
+
+// timedOut reports whether the route deadline on ctx has passed — as
+// opposed to the client going away — and if so answers 504 naming stage.
+func timedOut(w http.ResponseWriter, r *http.Request, ctx context.Context, stage string, d time.Duration) bool {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) || r.Context().Err() != nil {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusGatewayTimeout)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":      "request timed out during " + stage,
+		"stage":      stage,
+		"timeout_ms": d.Milliseconds(),
+	})
+	return true
+}
+
+// queryTimedOut is timedOut for executor errors, which name their stage.
+func queryTimedOut(w http.ResponseWriter, r *http.Request, ctx context.Context, err error, d time.Duration) bool {
+	var stageErr *sql.StageError
+	if !errors.As(err, &stageErr) {
+		return false
+	}
+	return timedOut(w, r, ctx, stageErr.Stage, d)
+}
+
+// jsonWithin encodes v before sending anything, so a response that took too
+// long to serialize can still become a 504.
+func jsonWithin(w http.ResponseWriter, r *http.Request, ctx context.Context, d time.Duration, v interface{}) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if timedOut(w, r, ctx, "serialization", d) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
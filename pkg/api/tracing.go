@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/thirawat27/kvi/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WithTracerProvider reports each request as a server span, continuing a
+// trace the client passes in a W3C traceparent header, with the SQL
+// executor's spans and the engine's beneath it. Give the engine the same
+// provider, in config.Config.TracerProvider, for its spans to join. A nil
+// tp, the default, traces nothing.
+func WithTracerProvider(tp trace.TracerProvider) func(*Server) {
+	return func(s *Server) { s.tracer = tracing.New(tp) }
+}
+
+// traceRequests starts the server span of each request. Spans are named
+// for the method until wrap names them for the route matched.
+func (s *Server) traceRequests(next http.Handler) http.Handler {
+	if s.tracer == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := s.tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+			))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// nameSpan names the request's span for the route r matched, as
+// "GET /api/v1/scan".
+func nameSpan(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		route := r.Pattern
+		if _, path, ok := strings.Cut(route, " "); ok {
+			route = path // drop the method the pattern names
+		}
+		span := trace.SpanFromContext(r.Context())
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(attribute.String("http.route", route))
+		h(w, r)
+	}
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// vectorFilters is the body of /api/v1/admin/vector-filters: the fields
+// with posting lists, and in answers what the lists hold.
+type vectorFilters struct {
+	Fields  []string                 `json:"fields"`
+	Filters []types.FilterFieldStats `json:"filters,omitempty"`
+}
+
+// handleVectorFilters reports the fields vector searches can be filtered
+// on through posting lists; PUT replaces them first, building the lists
+// of the new ones before it answers.
+func (s *Server) handleVectorFilters(w http.ResponseWriter, r *http.Request) {
+	fs, ok := s.engine.(types.FilteredSearcher)
+	if !ok {
+		http.Error(w, `{"error":"this engine has no vector index to filter"}`, http.StatusNotImplemented)
+		return
+	}
+	if r.Method == http.MethodPut {
+		var req vectorFilters
+		if !decodeJSON(w, r, &req) {
+			return
+		}
+		if err := fs.SetFilterFields(req.Fields); err != nil {
+			writeEngineError(w, err)
+			return
+		}
+	}
+	resp := vectorFilters{Fields: fs.FilterFields()}
+	if sr, ok := s.engine.(types.StatsReporter); ok {
+		if vec := sr.Stats().Vector; vec != nil {
+			resp.Filters = vec.Filters
+		}
+	}
+	jsonOK(w, resp)
+}
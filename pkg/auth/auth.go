@@ -0,0 +1,221 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Role is the permission level carried by a token. Each role includes the
+// permissions of the roles below it: admin > write > read.
+type Role string
+
+const (
+	RoleRead  Role = "read"
+	RoleWrite Role = "write"
+	RoleAdmin Role = "admin"
+)
+
+var roleRank = map[Role]int{RoleRead: 1, RoleWrite: 2, RoleAdmin: 3}
+
+// ParseRole validates a role name.
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := roleRank[role]; !ok {
+		return "", fmt.Errorf("unknown role %q (want read, write or admin)", s)
+	}
+	return role, nil
+}
+
+// Allows reports whether r grants at least the permissions of required.
+func (r Role) Allows(required Role) bool {
+	return roleRank[r] >= roleRank[required] && roleRank[r] > 0
+}
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidToken       = errors.New("invalid token")
+	ErrForbidden          = errors.New("insufficient role")
+)
+
+// Claims are the JWT claims issued by an Authenticator.
+type Claims struct {
+	Role Role `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// CredentialValidator checks an API key presented to the token endpoint and
+// returns the subject and role to issue a token for.
+type CredentialValidator func(ctx context.Context, apiKey string) (subject string, role Role, err error)
+
+// StaticKeys validates against a fixed set of API keys mapped to roles. The
+// key itself is never used as the subject; keys are identified by position.
+func StaticKeys(keys map[string]Role) CredentialValidator {
+	return func(ctx context.Context, apiKey string) (string, Role, error) {
+		for key, role := range keys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+				return "apikey:" + keyID(key), role, nil
+			}
+		}
+		return "", "", ErrInvalidCredentials
+	}
+}
+
+// keyID is a short, non-reversible label for an API key.
+func keyID(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
+
+// Authenticator issues and verifies HMAC-signed JWTs. The HTTP middleware and
+// the gRPC interceptors share one instance so both enforce the same policy.
+type Authenticator struct {
+	mu       sync.RWMutex // guards the fields Update replaces
+	secret   []byte
+	ttl      time.Duration
+	validate CredentialValidator
+	now      func() time.Time
+}
+
+// MinSecretBytes is the shortest signing secret New accepts.
+const MinSecretBytes = 16
+
+func New(secret []byte, ttl time.Duration, validate CredentialValidator) (*Authenticator, error) {
+	a := &Authenticator{now: time.Now}
+	if err := a.Update(secret, ttl, validate); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Update replaces what New was given, for every call from now on. Tokens
+// signed with a replaced secret no longer verify.
+func (a *Authenticator) Update(secret []byte, ttl time.Duration, validate CredentialValidator) error {
+	if len(secret) < MinSecretBytes {
+		return fmt.Errorf("jwt secret must be at least %d bytes, got %d", MinSecretBytes, len(secret))
+	}
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.secret, a.ttl, a.validate = secret, ttl, validate
+	return nil
+}
+
+// current returns the settings Update last set.
+func (a *Authenticator) current() (secret []byte, ttl time.Duration, validate CredentialValidator) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.secret, a.ttl, a.validate
+}
+
+// Login exchanges an API key for a signed token.
+func (a *Authenticator) Login(ctx context.Context, apiKey string) (string, *Claims, error) {
+	_, _, validate := a.current()
+	if validate == nil || apiKey == "" {
+		return "", nil, ErrInvalidCredentials
+	}
+	subject, role, err := validate(ctx, apiKey)
+	if err != nil {
+		return "", nil, ErrInvalidCredentials
+	}
+	return a.Issue(subject, role)
+}
+
+// Refresh issues a fresh token with the same subject and role as a still
+// valid one.
+func (a *Authenticator) Refresh(token string) (string, *Claims, error) {
+	claims, err := a.Verify(token)
+	if err != nil {
+		return "", nil, err
+	}
+	return a.Issue(claims.Subject, claims.Role)
+}
+
+// Issue signs a token for subject with the given role.
+func (a *Authenticator) Issue(subject string, role Role) (string, *Claims, error) {
+	if _, ok := roleRank[role]; !ok {
+		return "", nil, fmt.Errorf("unknown role: %s", role)
+	}
+	secret, ttl, _ := a.current()
+	now := a.now()
+	claims := &Claims{
+		Role: role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
+}
+
+// Verify checks the signature and expiry of a token and returns its claims.
+func (a *Authenticator) Verify(token string) (*Claims, error) {
+	secret, _, _ := a.current()
+	claims := &Claims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		return secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithTimeFunc(a.now),
+	)
+	if err != nil || !parsed.Valid {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if _, ok := roleRank[claims.Role]; !ok {
+		return nil, fmt.Errorf("%w: unknown role %q", ErrInvalidToken, claims.Role)
+	}
+	return claims, nil
+}
+
+// Authorize verifies token and checks that it grants the required role.
+func (a *Authenticator) Authorize(token string, required Role) (*Claims, error) {
+	claims, err := a.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.Role.Allows(required) {
+		return claims, fmt.Errorf("%w: %s required, token has %s", ErrForbidden, required, claims.Role)
+	}
+	return claims, nil
+}
+
+type claimsKey struct{}
+
+// NewContext returns a context carrying the authenticated claims.
+func NewContext(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// FromContext returns the claims stored by NewContext, if any.
+func FromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(*Claims)
+	return claims, ok
+}
+
+// Check reports whether the request behind ctx may act with the required role.
+// Requests without claims are allowed: they only exist when auth is disabled.
+func Check(ctx context.Context, required Role) error {
+	claims, ok := FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if !claims.Role.Allows(required) {
+		return fmt.Errorf("%w: %s required, token has %s", ErrForbidden, required, claims.Role)
+	}
+	return nil
+}
@@ -0,0 +1,555 @@
+// Package cdc pushes every change an engine logs to external systems.
+// Changes are captured from the WAL into an outbox on disk as they are
+// logged, and delivered from there to each sink in LSN order, at least
+// once: a sink's cursor, the LSN of the last change it took, is saved
+// after each delivery, and a restart resumes after it. A slow or failing
+// sink never holds up writes; the outbox grows instead, up to its limit.
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// Defaults for the options below.
+const (
+	DefaultBatchSize = 100
+	DefaultBatchWait = 200 * time.Millisecond
+	DefaultMaxOutbox = 1 << 30
+	DefaultRetryMin  = 500 * time.Millisecond
+	DefaultRetryMax  = time.Minute
+)
+
+// Sink states, as SinkStats reports them.
+const (
+	StateOK       = "ok"
+	StateRetrying = "retrying"
+)
+
+// Event is one captured change. Timestamp is when it was captured, within
+// moments of the write; Record is the record written, nil for a delete.
+type Event struct {
+	LSN       uint64        `json:"lsn"`
+	Op        string        `json:"op"` // "put" or "delete"
+	Key       string        `json:"key"`
+	Record    *types.Record `json:"record,omitempty"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+// Sink receives the captured changes. Deliver gets them in batches, in LSN
+// order, and a batch it fails is retried until it succeeds; a batch may
+// also arrive again after a restart, so a sink must tolerate duplicates,
+// which it can tell by LSN. Name identifies the sink's cursor across
+// restarts and in stats.
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, events []Event) error
+}
+
+// CDC captures an engine's changes and delivers them to sinks. Create it
+// with New, then Start it; Stop it after closing the engine, so every
+// change logged is captured first.
+type CDC struct {
+	shipper   types.LogShipper
+	dir       string
+	batchSize int
+	batchWait time.Duration
+	maxOutbox int64
+	retryMin  time.Duration
+	retryMax  time.Duration
+	log       *slog.Logger
+	outbox    *outbox
+	sinks     []*sinkState
+
+	mu          sync.Mutex // guards what follows and the sinks' progress
+	captured    int64
+	dropped     int64
+	gaps        int
+	overflowing bool
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+type sinkState struct {
+	sink       Sink
+	cursorPath string
+	notify     chan struct{} // new changes in the outbox
+
+	cursor       uint64
+	delivered    int64
+	failures     int64
+	retrying     bool
+	oldest       time.Time // capture time of the oldest change in hand
+	lastDelivery time.Time
+	lastErr      error
+}
+
+// state is what the capture side keeps across restarts: how far it got,
+// and whether it stopped because the engine closed, having captured every
+// change logged.
+type state struct {
+	LSN   uint64 `json:"lsn"`
+	Clean bool   `json:"clean"`
+}
+
+const stateFile = "state.json"
+
+// New returns a pipeline from eng, which must ship its WAL, to sinks,
+// keeping its outbox, cursors and state in dir.
+func New(eng types.Engine, dir string, sinks []Sink, opts ...func(*CDC)) (*CDC, error) {
+	shipper, ok := eng.(types.LogShipper)
+	if !ok {
+		return nil, fmt.Errorf("%w: change data capture needs an engine that ships its WAL (disk or hybrid)", errors.ErrUnsupported)
+	}
+	if len(sinks) == 0 {
+		return nil, errors.New("cdc: no sinks")
+	}
+	c := &CDC{
+		shipper:   shipper,
+		dir:       dir,
+		batchSize: DefaultBatchSize,
+		batchWait: DefaultBatchWait,
+		maxOutbox: DefaultMaxOutbox,
+		retryMin:  DefaultRetryMin,
+		retryMax:  DefaultRetryMax,
+		log:       slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	files := map[string]string{}
+	for _, sink := range sinks {
+		name := sink.Name()
+		file := cursorFile(name)
+		if other, dup := files[file]; dup {
+			return nil, fmt.Errorf("cdc: sinks %q and %q would share a cursor", other, name)
+		}
+		files[file] = name
+		c.sinks = append(c.sinks, &sinkState{sink: sink, cursorPath: filepath.Join(dir, file), notify: make(chan struct{}, 1)})
+	}
+	var err error
+	if c.outbox, err = openOutbox(dir, c.maxOutbox); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// WithLogger sets where the pipeline logs failures.
+func WithLogger(l *slog.Logger) func(*CDC) {
+	return func(c *CDC) { c.log = l }
+}
+
+// WithBatch sets how many changes a sink gets at most per delivery, and
+// how long a delivery short of that waits for more.
+func WithBatch(size int, wait time.Duration) func(*CDC) {
+	return func(c *CDC) { c.batchSize, c.batchWait = max(size, 1), wait }
+}
+
+// WithMaxOutbox bounds the outbox in bytes (0 for no bound). Changes
+// captured while it is full are dropped, and counted.
+func WithMaxOutbox(bytes int64) func(*CDC) {
+	return func(c *CDC) { c.maxOutbox = bytes }
+}
+
+// WithRetry sets the backoff between failed deliveries: it starts at min
+// and doubles up to max.
+func WithRetry(min, max time.Duration) func(*CDC) {
+	return func(c *CDC) { c.retryMin, c.retryMax = min, max }
+}
+
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// cursorFile is the file keeping the cursor of the sink called name.
+func cursorFile(name string) string {
+	return strings.Trim(unsafeName.ReplaceAllString(name, "_"), "_") + ".cursor"
+}
+
+// Start begins capturing changes after the last one captured before, or,
+// the first time, after the last one logged, and delivering them.
+func (c *CDC) Start() error {
+	st, err := c.readState()
+	fresh := errors.Is(err, fs.ErrNotExist)
+	if err != nil && !fresh {
+		return err
+	}
+	_, _, outboxLSN := c.outbox.stats()
+	after := max(st.LSN, outboxLSN)
+	if fresh {
+		after = c.shipper.LSN()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := c.shipper.Ship(ctx, after)
+	if errors.Is(err, types.ErrHistoryUnavailable) {
+		// After a clean stop, what the WAL lacks is only compaction
+		now := c.shipper.LSN()
+		if !st.Clean {
+			c.gap(after, now)
+		}
+		after = now
+		changes, err = c.shipper.Ship(ctx, after)
+	}
+	if err == nil {
+		err = c.writeState(state{LSN: after})
+	}
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	for _, s := range c.sinks {
+		if s.cursor, err = readCursor(s.cursorPath); errors.Is(err, fs.ErrNotExist) {
+			s.cursor, err = after, writeCursor(s.cursorPath, after) // a new sink starts now
+		}
+		if err != nil {
+			cancel()
+			return fmt.Errorf("cdc sink %s: %w", s.sink.Name(), err)
+		}
+	}
+
+	c.cancel = cancel
+	c.wg.Add(1 + len(c.sinks))
+	go c.capture(ctx, changes, after)
+	for _, s := range c.sinks {
+		go c.deliver(ctx, s)
+	}
+	return nil
+}
+
+// Stop stops capturing and delivering. A delivery in progress is
+// abandoned and made again after a restart.
+func (c *CDC) Stop() {
+	if c.cancel != nil {
+		c.cancel()
+		c.wg.Wait()
+	}
+}
+
+// capture appends the changes the engine logs after LSN after to the
+// outbox, following the WAL again whenever it drops this follower, until
+// ctx ends or the engine closes.
+func (c *CDC) capture(ctx context.Context, changes <-chan types.ChangeEvent, after uint64) {
+	defer c.wg.Done()
+	clean := false
+	defer func() {
+		if err := c.outbox.close(); err != nil {
+			c.log.Error("closing CDC outbox failed", "err", err)
+		}
+		if err := c.writeState(state{LSN: after, Clean: clean}); err != nil {
+			c.log.Error("saving CDC state failed", "err", err)
+		}
+	}()
+
+	for {
+		for ev := range changes {
+			c.add(ev)
+			after = ev.Seq
+			if len(changes) == 0 {
+				c.publish()
+			}
+		}
+		c.publish()
+
+		for { // follow again, from where this left off
+			if ctx.Err() != nil {
+				return
+			}
+			var err error
+			if changes, err = c.shipper.Ship(ctx, after); err == nil {
+				break
+			}
+			switch {
+			case errors.Is(err, types.ErrClosed):
+				clean = true
+				return
+			case errors.Is(err, types.ErrHistoryUnavailable):
+				now := c.shipper.LSN()
+				c.gap(after, now)
+				after = now
+			default:
+				c.log.Error("following the WAL failed; retrying", "err", err, "after_lsn", after)
+				if !sleep(ctx, c.retryMin) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// add appends one change to the outbox, or counts it dropped.
+func (c *CDC) add(ev types.ChangeEvent) {
+	ok, err := c.outbox.append(&Event{
+		LSN:       ev.Seq,
+		Op:        strings.ToLower(string(ev.Op)),
+		Key:       ev.Key,
+		Record:    ev.Record,
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
+		c.log.Error("writing to the CDC outbox failed; change dropped", "err", err, "lsn", ev.Seq)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch {
+	case ok:
+		c.captured++
+		if c.overflowing {
+			c.overflowing = false
+			c.log.Warn("CDC outbox has room again", "dropped", c.dropped)
+		}
+	default:
+		c.dropped++
+		if !c.overflowing && err == nil {
+			c.overflowing = true
+			c.log.Error("CDC outbox is full; changes are being dropped until sinks catch up", "limit_bytes", c.maxOutbox)
+		}
+	}
+}
+
+// publish makes what was captured readable and wakes the sinks.
+func (c *CDC) publish() {
+	if err := c.outbox.flush(); err != nil {
+		c.log.Error("flushing the CDC outbox failed", "err", err)
+	}
+	for _, s := range c.sinks {
+		select {
+		case s.notify <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// gap records that the changes after LSN from up to LSN to were not
+// captured, and so reach no sink.
+func (c *CDC) gap(from, to uint64) {
+	c.mu.Lock()
+	c.gaps++
+	c.mu.Unlock()
+	c.log.Warn("changes were not captured for CDC; sinks will not see them", "after_lsn", from, "up_to_lsn", to)
+}
+
+// deliver hands s the changes after its cursor, batch by batch, until ctx
+// ends.
+func (c *CDC) deliver(ctx context.Context, s *sinkState) {
+	defer c.wg.Done()
+	c.mu.Lock()
+	after := s.cursor
+	c.mu.Unlock()
+
+	var pos position
+	backoff := c.retryMin
+	for {
+		batch, next, err := c.outbox.read(pos, after, c.batchSize)
+		if err != nil {
+			c.log.Error("reading the CDC outbox failed", "sink", s.sink.Name(), "err", err)
+			if !sleep(ctx, c.retryMax) {
+				return
+			}
+			continue
+		}
+		pos = next
+		if len(batch) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.notify:
+			}
+			continue
+		}
+		c.setOldest(s, batch[0].Timestamp)
+		if len(batch) < c.batchSize && c.batchWait > 0 {
+			batch, pos = c.fill(ctx, s, batch, pos)
+		}
+
+		for {
+			err := s.sink.Deliver(ctx, batch)
+			if err == nil {
+				break
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			c.failed(s, err, backoff)
+			if !sleep(ctx, backoff) {
+				return
+			}
+			backoff = min(2*backoff, c.retryMax)
+		}
+		backoff = c.retryMin
+		after = batch[len(batch)-1].LSN
+		if err := writeCursor(s.cursorPath, after); err != nil {
+			c.log.Error("saving CDC cursor failed; changes may be delivered again after a restart", "sink", s.sink.Name(), "err", err)
+		}
+		if err := c.outbox.trim(c.delivered(s, after, len(batch))); err != nil {
+			c.log.Error("trimming the CDC outbox failed", "err", err)
+		}
+	}
+}
+
+// fill waits up to the batch wait for batch to fill.
+func (c *CDC) fill(ctx context.Context, s *sinkState, batch []Event, pos position) ([]Event, position) {
+	timer := time.NewTimer(c.batchWait)
+	defer timer.Stop()
+	for len(batch) < c.batchSize {
+		select {
+		case <-ctx.Done():
+			return batch, pos
+		case <-timer.C:
+			return batch, pos
+		case <-s.notify:
+		}
+		more, next, err := c.outbox.read(pos, batch[len(batch)-1].LSN, c.batchSize-len(batch))
+		if err != nil {
+			return batch, pos // read again, and fail, after this delivery
+		}
+		batch, pos = append(batch, more...), next
+	}
+	return batch, pos
+}
+
+func (c *CDC) setOldest(s *sinkState, t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.oldest = t
+}
+
+func (c *CDC) failed(s *sinkState, err error, backoff time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s.failures++
+	if !s.retrying {
+		c.log.Warn("CDC delivery failed; retrying", "sink", s.sink.Name(), "err", err, "after_lsn", s.cursor, "retry_ms", backoff.Milliseconds())
+	}
+	s.retrying, s.lastErr = true, err
+}
+
+// delivered records that s took n changes up to LSN upTo, and returns the
+// LSN every sink has taken changes up to.
+func (c *CDC) delivered(s *sinkState, upTo uint64, n int) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s.retrying {
+		c.log.Info("CDC delivery recovered", "sink", s.sink.Name(), "failures", s.failures)
+	}
+	s.cursor, s.delivered, s.lastDelivery = upTo, s.delivered+int64(n), time.Now()
+	s.retrying, s.lastErr, s.oldest = false, nil, time.Time{}
+	least := upTo
+	for _, other := range c.sinks {
+		least = min(least, other.cursor)
+	}
+	return least
+}
+
+// Stats reports the outbox and each sink's progress.
+func (c *CDC) Stats() *stats.CDCStats {
+	bytes, segments, lastLSN := c.outbox.stats()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	st := &stats.CDCStats{
+		CapturedLSN: lastLSN,
+		Captured:    c.captured,
+		Dropped:     c.dropped,
+		Gaps:        c.gaps,
+		OutboxBytes: bytes,
+		OutboxLimit: c.maxOutbox,
+		Segments:    segments,
+		Sinks:       make([]stats.SinkStats, 0, len(c.sinks)),
+	}
+	for _, s := range c.sinks {
+		ss := stats.SinkStats{
+			Name:         s.sink.Name(),
+			State:        StateOK,
+			CursorLSN:    s.cursor,
+			Delivered:    s.delivered,
+			Failures:     s.failures,
+			LastDelivery: s.lastDelivery,
+		}
+		if lastLSN > s.cursor {
+			ss.Lag = lastLSN - s.cursor
+		}
+		if !s.oldest.IsZero() {
+			ss.LagSeconds = time.Since(s.oldest).Seconds()
+		}
+		if s.retrying {
+			ss.State = StateRetrying
+		}
+		if s.lastErr != nil {
+			ss.LastError = s.lastErr.Error()
+		}
+		st.Sinks = append(st.Sinks, ss)
+	}
+	return st
+}
+
+func (c *CDC) readState() (state, error) {
+	var st state
+	data, err := os.ReadFile(filepath.Join(c.dir, stateFile))
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return st, fmt.Errorf("cdc state: %w", err)
+	}
+	return st, nil
+}
+
+func (c *CDC) writeState(st state) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(filepath.Join(c.dir, stateFile), data)
+}
+
+func readCursor(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func writeCursor(path string, lsn uint64) error {
+	return writeAtomic(path, []byte(strconv.FormatUint(lsn, 10)+"\n"))
+}
+
+// writeAtomic replaces the file at path with data, so a crash leaves the
+// old contents or the new, never a mix.
+func writeAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// sleep waits d, reporting false if ctx ends first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
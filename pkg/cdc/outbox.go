@@ -0,0 +1,304 @@
+package cdc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// segmentBytes is how large an outbox segment grows before the next one
+// is started.
+const segmentBytes = 8 << 20
+
+const segmentExt = ".ndjson"
+
+// outbox holds the captured changes, one JSON line each, in segment files
+// named by the LSN of their first change. Sinks read it at their own pace;
+// a segment is removed once every sink has taken all of it.
+type outbox struct {
+	dir   string
+	limit int64 // bytes; appends beyond it are refused
+
+	mu       sync.Mutex
+	segments []*segment // oldest first
+	bytes    int64
+	file     *os.File // the last segment, open for appending
+	w        *bufio.Writer
+	lastLSN  uint64
+}
+
+type segment struct {
+	path        string
+	first, last uint64 // LSNs of its first and last change
+	size        int64
+}
+
+// position is where a reader is in the outbox: a segment, by its first
+// LSN, and an offset in it.
+type position struct {
+	first  uint64
+	offset int64
+}
+
+// openOutbox loads the segments in dir. A line left half written by a
+// crash is cut off the last one.
+func openOutbox(dir string, limit int64) (*outbox, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	o := &outbox{dir: dir, limit: limit}
+	for _, e := range entries {
+		name := e.Name()
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) || err != nil {
+			continue
+		}
+		seg := &segment{path: filepath.Join(dir, name), first: first}
+		if err := seg.load(); err != nil {
+			return nil, fmt.Errorf("outbox segment %s: %w", name, err)
+		}
+		if seg.last == 0 { // nothing complete in it
+			if err := os.Remove(seg.path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		o.segments = append(o.segments, seg)
+		o.bytes += seg.size
+	}
+	slices.SortFunc(o.segments, func(a, b *segment) int { return cmpLSN(a.first, b.first) })
+	if n := len(o.segments); n > 0 {
+		o.lastLSN = o.segments[n-1].last
+	}
+	return o, nil
+}
+
+// load finds the last change in s and its size up to the end of that
+// change's line, truncating the file there.
+func (s *segment) load() error {
+	f, err := os.OpenFile(s.path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break // a partial line, if any, is dropped below
+		}
+		if err != nil {
+			return err
+		}
+		var ev struct {
+			LSN uint64 `json:"lsn"`
+		}
+		if err := json.Unmarshal(line, &ev); err != nil {
+			return fmt.Errorf("corrupt change at offset %d: %w", s.size, err)
+		}
+		s.last = ev.LSN
+		s.size += int64(len(line))
+	}
+	return f.Truncate(s.size)
+}
+
+func cmpLSN(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// append adds ev after the changes already in the outbox. It reports false,
+// adding nothing, when the outbox is full. Appends reach readers once
+// flushed.
+func (o *outbox) append(ev *Event) (bool, error) {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return false, err
+	}
+	line = append(line, '\n')
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.limit > 0 && o.bytes+int64(len(line)) > o.limit {
+		return false, nil
+	}
+	var seg *segment
+	if n := len(o.segments); n > 0 {
+		seg = o.segments[n-1]
+	}
+	if seg == nil || seg.size >= segmentBytes || o.file == nil {
+		if seg, err = o.rollLocked(ev.LSN); err != nil {
+			return false, err
+		}
+	}
+	if _, err := o.w.Write(line); err != nil {
+		return false, err
+	}
+	seg.last, seg.size = ev.LSN, seg.size+int64(len(line))
+	o.bytes += int64(len(line))
+	o.lastLSN = ev.LSN
+	return true, nil
+}
+
+// rollLocked opens the segment to append to: the last one when it still
+// has room, otherwise a new one starting at first.
+func (o *outbox) rollLocked(first uint64) (*segment, error) {
+	if n := len(o.segments); o.file == nil && n > 0 && o.segments[n-1].size < segmentBytes {
+		seg := o.segments[n-1]
+		f, err := os.OpenFile(seg.path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		o.file, o.w = f, bufio.NewWriter(f)
+		return seg, nil
+	}
+	if err := o.closeFileLocked(); err != nil {
+		return nil, err
+	}
+	seg := &segment{path: filepath.Join(o.dir, fmt.Sprintf("%020d%s", first, segmentExt)), first: first}
+	f, err := os.OpenFile(seg.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	o.file, o.w = f, bufio.NewWriter(f)
+	o.segments = append(o.segments, seg)
+	return seg, nil
+}
+
+// flush hands what was appended to the OS, where readers see it.
+func (o *outbox) flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.w == nil {
+		return nil
+	}
+	return o.w.Flush()
+}
+
+// close flushes and syncs the last segment.
+func (o *outbox) close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.closeFileLocked()
+}
+
+func (o *outbox) closeFileLocked() error {
+	if o.file == nil {
+		return nil
+	}
+	err := o.w.Flush()
+	if err == nil {
+		err = o.file.Sync()
+	}
+	if cerr := o.file.Close(); err == nil {
+		err = cerr
+	}
+	o.file, o.w = nil, nil
+	return err
+}
+
+// read returns up to n changes after LSN after, starting from pos, and the
+// position past them. A line still being written is left for later.
+func (o *outbox) read(pos position, after uint64, n int) ([]Event, position, error) {
+	var out []Event
+	for len(out) < n {
+		o.mu.Lock()
+		i, _ := slices.BinarySearchFunc(o.segments, pos.first, func(s *segment, first uint64) int { return cmpLSN(s.first, first) })
+		if i == len(o.segments) {
+			o.mu.Unlock()
+			break
+		}
+		seg, last := *o.segments[i], i == len(o.segments)-1
+		o.mu.Unlock()
+
+		if seg.first != pos.first { // pos's segment was taken by every sink and removed
+			pos = position{first: seg.first}
+		}
+		evs, offset, err := readSegment(seg.path, pos.offset, after, n-len(out))
+		if errors.Is(err, fs.ErrNotExist) {
+			pos = position{first: seg.first + 1}
+			continue
+		}
+		if err != nil {
+			return out, pos, err
+		}
+		out, pos.offset = append(out, evs...), offset
+		if len(out) >= n || last {
+			break
+		}
+		pos = position{first: seg.first + 1} // only the last segment grows
+	}
+	return out, pos, nil
+}
+
+// readSegment reads up to n complete changes after LSN after from the
+// segment at path, from offset on, and returns the offset past them.
+func readSegment(path string, offset int64, after uint64, n int) ([]Event, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, offset, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, err
+	}
+	var out []Event
+	r := bufio.NewReader(f)
+	for len(out) < n {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return out, offset, err
+		}
+		var ev Event
+		if err := json.Unmarshal(bytes.TrimSpace(line), &ev); err != nil {
+			return out, offset, fmt.Errorf("outbox segment %s: corrupt change at offset %d: %w", filepath.Base(path), offset, err)
+		}
+		offset += int64(len(line))
+		if ev.LSN > after {
+			out = append(out, ev)
+		}
+	}
+	return out, offset, nil
+}
+
+// trim removes the segments every sink has taken, all of whose changes
+// are at or before LSN upTo. The last segment is kept to append to.
+func (o *outbox) trim(upTo uint64) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for len(o.segments) > 1 && o.segments[0].last <= upTo {
+		if err := os.Remove(o.segments[0].path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		o.bytes -= o.segments[0].size
+		o.segments = o.segments[1:]
+	}
+	return nil
+}
+
+// stats returns the outbox's size in bytes and segments, and the LSN of
+// the last change in it.
+func (o *outbox) stats() (int64, int, uint64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.bytes, len(o.segments), o.lastLSN
+}
@@ -0,0 +1,79 @@
+package cdc
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SignatureHeader carries a webhook body's signature: "sha256=" and the hex
+// HMAC-SHA256 of the body under the webhook's secret.
+const SignatureHeader = "X-Kvi-Signature"
+
+// Webhook is a sink that POSTs each batch to URL as a JSON array of
+// events. Any response but a 2xx fails the delivery, which is retried.
+type Webhook struct {
+	URL    string
+	Secret string       // signs the body when set; see SignatureHeader
+	Client *http.Client // nil uses one with a 30 s timeout
+}
+
+var webhookClient = &http.Client{Timeout: 30 * time.Second}
+
+// NewWebhook returns a webhook sink posting to rawURL, signing with secret
+// when it is set.
+func NewWebhook(rawURL, secret string) *Webhook {
+	return &Webhook{URL: rawURL, Secret: secret}
+}
+
+// Name is "webhook " and the URL without credentials or query, which may
+// hold secrets.
+func (h *Webhook) Name() string {
+	u, err := url.Parse(h.URL)
+	if err != nil {
+		return "webhook " + h.URL
+	}
+	return "webhook " + u.Host + u.Path
+}
+
+// Deliver implements Sink.
+func (h *Webhook) Deliver(ctx context.Context, events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(h.Secret))
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := h.Client
+	if client == nil {
+		client = webhookClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook answered %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	io.Copy(io.Discard, resp.Body) // lets the connection be reused
+	return nil
+}
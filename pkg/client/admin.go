@@ -0,0 +1,216 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/backup"
+)
+
+// The admin calls below use the REST API and need an admin token. Backup
+// and Restore stream the whole data set, so they are not bounded by the
+// client timeout or retried; bound them with ctx.
+
+// ErrIncompleteBackup is returned by Backup when the server did not confirm
+// the stream with its checksum, i.e. the dump failed part way.
+var ErrIncompleteBackup = errors.New("backup stream incomplete")
+
+// Backup streams a backup of every record to w, in the same format as
+// kvi backup writes locally, and checks it against the checksum the server
+// sends after it.
+func (c *Client) Backup(ctx context.Context, w io.Writer) (backup.Summary, error) {
+	var sum backup.Summary
+	if c.baseURL == "" {
+		return sum, needsHTTP("Backup")
+	}
+	resp, err := c.sendHTTP(ctx, http.MethodGet, "/api/v1/backup", nil, nil)
+	if err != nil {
+		return sum, err
+	}
+	defer resp.Body.Close()
+
+	sum.Checksum, sum.Bytes, err = backup.Checksum(io.TeeReader(resp.Body, w))
+	if err != nil {
+		return sum, err
+	}
+	want := resp.Trailer.Get("X-Kvi-Checksum")
+	if want == "" {
+		return sum, ErrIncompleteBackup
+	}
+	if !strings.EqualFold(want, sum.Checksum) {
+		return sum, fmt.Errorf("backup checksum mismatch: server sent %s, received %s", want, sum.Checksum)
+	}
+	sum.Records, _ = strconv.Atoi(resp.Trailer.Get("X-Kvi-Records"))
+	return sum, nil
+}
+
+// RestoreOptions control Restore.
+type RestoreOptions struct {
+	// Merge applies the backup on top of the stored records, keeping any
+	// stored at the same or a newer version, instead of replacing them all.
+	Merge bool
+	// DryRun only reports what the restore would do, and describes the
+	// backup, without changing anything.
+	DryRun bool
+}
+
+// RestoreResult is the outcome of a restore, or what it would be.
+type RestoreResult struct {
+	// Status is "ok", or for a dry run "invalid" when the server would
+	// reject the backup for the problems listed in Backup.
+	Status   string `json:"status"`
+	DryRun   bool   `json:"dry_run,omitempty"`
+	Restored int    `json:"restored"`
+	Skipped  int    `json:"skipped"` // merge: stored at the same or a newer version
+	Expired  int    `json:"expired"` // past their TTL, so not restored
+	Removed  int    `json:"removed"` // replace: stored records deleted first
+	Bytes    int64  `json:"bytes"`
+	Checksum string `json:"checksum"`
+	// Backup describes the upload; dry runs only.
+	Backup *backup.Report `json:"backup,omitempty"`
+}
+
+// Restore uploads a backup and loads it. r is read once to checksum it, so
+// the server can reject a damaged upload before touching any data, then
+// again from the start to send it.
+func (c *Client) Restore(ctx context.Context, r io.ReadSeeker, opts RestoreOptions) (*RestoreResult, error) {
+	if c.baseURL == "" {
+		return nil, needsHTTP("Restore")
+	}
+	checksum, _, err := backup.Checksum(r)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	query := url.Values{"mode": {string(backup.Replace)}}
+	if opts.Merge {
+		query.Set("mode", string(backup.Merge))
+	}
+	if opts.DryRun {
+		query.Set("dry_run", "true")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/v1/restore?"+query.Encode(), io.NopCloser(r))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("X-Kvi-Checksum", checksum)
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var result RestoreResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Stats returns the server's GET /api/v1/stats report as sent.
+func (c *Client) Stats(ctx context.Context) (json.RawMessage, error) {
+	if c.baseURL == "" {
+		return nil, needsHTTP("Stats")
+	}
+	var report json.RawMessage
+	err := c.call(ctx, true, func(ctx context.Context) error {
+		return c.doHTTP(ctx, http.MethodGet, "/api/v1/stats", nil, nil, &report)
+	})
+	return report, err
+}
+
+// Sync makes every write the server has acknowledged durable, syncing its
+// WAL (POST /api/v1/sync).
+func (c *Client) Sync(ctx context.Context) error {
+	if c.baseURL == "" {
+		return needsHTTP("Sync")
+	}
+	return c.call(ctx, true, func(ctx context.Context) error {
+		return c.doHTTP(ctx, http.MethodPost, "/api/v1/sync", nil, nil, nil)
+	})
+}
+
+// Job is a maintenance operation running on the server. Done and Total
+// measure progress in operation-specific units.
+type Job struct {
+	ID         string          `json:"id"`
+	Op         string          `json:"op"`
+	Status     string          `json:"status"` // running | succeeded | failed
+	Done       int             `json:"done"`
+	Total      int             `json:"total"`
+	Error      string          `json:"error,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+}
+
+// Finished reports whether the job has stopped, successfully or not.
+func (j *Job) Finished() bool { return j.Status != "running" }
+
+// StartMaintenance starts op (snapshot, compact, checkpoint, ...) and
+// returns the job without waiting for it; see Job and WaitJob. Only one
+// job runs at a time; starting another fails with the server's conflict.
+func (c *Client) StartMaintenance(ctx context.Context, op string) (*Job, error) {
+	if c.baseURL == "" {
+		return nil, needsHTTP("StartMaintenance")
+	}
+	var job Job
+	err := c.call(ctx, false, func(ctx context.Context) error {
+		return c.doHTTP(ctx, http.MethodPost, "/api/v1/admin/"+url.PathEscape(op), nil, nil, &job)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Job returns the current state of a maintenance job.
+func (c *Client) Job(ctx context.Context, id string) (*Job, error) {
+	if c.baseURL == "" {
+		return nil, needsHTTP("Job")
+	}
+	var job Job
+	err := c.call(ctx, true, func(ctx context.Context) error {
+		return c.doHTTP(ctx, http.MethodGet, "/api/v1/admin/jobs/"+url.PathEscape(id), nil, nil, &job)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// WaitJob polls a job every interval until it finishes, calling progress,
+// if non-nil, with each state seen. A job that failed is returned with an
+// error carrying its message.
+func (c *Client) WaitJob(ctx context.Context, id string, interval time.Duration, progress func(*Job)) (*Job, error) {
+	for {
+		job, err := c.Job(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if progress != nil {
+			progress(job)
+		}
+		if job.Finished() {
+			if job.Status == "failed" {
+				return job, fmt.Errorf("%s job %s failed: %s", job.Op, job.ID, job.Error)
+			}
+			return job, nil
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return job, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// tokenSource hands out the bearer token for each call. With an API key it
+// logs in on first use, refreshes once three quarters of the token's life
+// has passed, and logs in again when refreshing fails.
+type tokenSource struct {
+	apiKey  string
+	http    *http.Client
+	baseURL string
+
+	mu        sync.Mutex
+	token     string
+	refreshAt time.Time
+	expires   time.Time
+}
+
+// renewable reports whether a rejected token can be replaced.
+func (t *tokenSource) renewable() bool {
+	return t != nil && t.apiKey != ""
+}
+
+// invalidate drops the current token so the next call logs in again.
+func (t *tokenSource) invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = ""
+}
+
+func (t *tokenSource) get(ctx context.Context) (string, error) {
+	if t == nil {
+		return "", nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.renewable() {
+		return t.token, nil
+	}
+
+	now := time.Now()
+	if t.token != "" && now.Before(t.refreshAt) {
+		return t.token, nil
+	}
+	if t.token != "" && now.Before(t.expires) {
+		if err := t.exchange(ctx, "/api/v1/auth/refresh", t.token, nil); err == nil {
+			return t.token, nil
+		}
+	}
+	if err := t.exchange(ctx, "/api/v1/auth", "", map[string]string{"api_key": t.apiKey}); err != nil {
+		return "", err
+	}
+	return t.token, nil
+}
+
+// exchange posts to a token endpoint and keeps the token it returns.
+func (t *tokenSource) exchange(ctx context.Context, path, bearer string, body interface{}) error {
+	payload, _ := json.Marshal(body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	resp, err := t.http.Do(req)
+	if err != nil {
+		return &serverError{msg: err.Error(), err: ErrUnavailable}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fromHTTP(resp)
+	}
+
+	var issued struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&issued); err != nil {
+		return err
+	}
+	now := time.Now()
+	t.token, t.expires = issued.Token, issued.ExpiresAt
+	t.refreshAt = now.Add(issued.ExpiresAt.Sub(now) * 3 / 4)
+	return nil
+}
+
+// outgoing attaches the bearer token to a gRPC call.
+func (c *Client) outgoing(ctx context.Context) (context.Context, error) {
+	token, err := c.tokens.get(ctx)
+	if err != nil || token == "" {
+		return ctx, err
+	}
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token), nil
+}
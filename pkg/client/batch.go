@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc"
+)
+
+// batchChunk is how many keys the client puts in one batch message, within
+// the server's default limit. Larger batches are streamed in chunks.
+const batchChunk = 500
+
+// BatchGet returns the live record for each key that has one; missing and
+// expired keys are absent from the map. Without gRPC it is one Get per key.
+func (c *Client) BatchGet(ctx context.Context, keys []string) (map[string]*types.Record, error) {
+	if c.stub() == nil {
+		found := make(map[string]*types.Record, len(keys))
+		for _, key := range keys {
+			rec, err := c.Get(ctx, key)
+			switch {
+			case err == nil:
+				found[key] = rec
+			case !errors.Is(err, types.ErrKeyNotFound):
+				return nil, err
+			}
+		}
+		return found, nil
+	}
+
+	var found map[string]*types.Record
+	err := c.call(ctx, true, func(ctx context.Context) error {
+		ctx, err := c.outgoing(ctx)
+		if err != nil {
+			return err
+		}
+		found = make(map[string]*types.Record, len(keys))
+		return c.batchGet(ctx, keys, func(resp *kvi_grpc.BatchGetResponse) error {
+			for key, r := range resp.Records {
+				if found[key], err = fromResponse(r); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	return found, err
+}
+
+func (c *Client) batchGet(ctx context.Context, keys []string, fn func(*kvi_grpc.BatchGetResponse) error) error {
+	stub := c.stub()
+	if len(keys) <= batchChunk {
+		resp, err := stub.BatchGet(ctx, &kvi_grpc.BatchGetRequest{Keys: keys})
+		if err != nil {
+			return fromStatus(err)
+		}
+		return fn(resp)
+	}
+	stream, err := stub.BatchGetStream(ctx)
+	if err != nil {
+		return fromStatus(err)
+	}
+	return inChunks(keys, func(chunk []string) error {
+		if err := stream.Send(&kvi_grpc.BatchGetRequest{Keys: chunk}); err != nil {
+			_, err = stream.Recv() // the stream's status
+			return fromStatus(err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return fromStatus(err)
+		}
+		return fn(resp)
+	}, func() error { return finish(stream) })
+}
+
+// BatchDelete deletes keys and reports those that existed. It is not
+// atomic: a failure part way leaves earlier keys deleted. Without gRPC it
+// is a Get and a Delete per key.
+func (c *Client) BatchDelete(ctx context.Context, keys []string) (map[string]bool, error) {
+	if c.stub() == nil {
+		deleted := make(map[string]bool, len(keys))
+		for _, key := range keys {
+			_, getErr := c.Get(ctx, key)
+			if getErr != nil && !errors.Is(getErr, types.ErrKeyNotFound) {
+				return deleted, getErr
+			}
+			if err := c.Delete(ctx, key); err != nil {
+				return deleted, err
+			}
+			if getErr == nil {
+				deleted[key] = true
+			}
+		}
+		return deleted, nil
+	}
+
+	deleted := make(map[string]bool, len(keys))
+	err := c.call(ctx, true, func(ctx context.Context) error {
+		ctx, err := c.outgoing(ctx)
+		if err != nil {
+			return err
+		}
+		return c.batchDelete(ctx, keys, func(resp *kvi_grpc.BatchDeleteResponse) {
+			for _, r := range resp.Results {
+				if r.Deleted {
+					deleted[r.Key] = true
+				}
+			}
+		})
+	})
+	return deleted, err
+}
+
+func (c *Client) batchDelete(ctx context.Context, keys []string, fn func(*kvi_grpc.BatchDeleteResponse)) error {
+	stub := c.stub()
+	if len(keys) <= batchChunk {
+		resp, err := stub.BatchDelete(ctx, &kvi_grpc.BatchDeleteRequest{Keys: keys})
+		if err != nil {
+			return fromStatus(err)
+		}
+		fn(resp)
+		return nil
+	}
+	stream, err := stub.BatchDeleteStream(ctx)
+	if err != nil {
+		return fromStatus(err)
+	}
+	return inChunks(keys, func(chunk []string) error {
+		if err := stream.Send(&kvi_grpc.BatchDeleteRequest{Keys: chunk}); err != nil {
+			_, err = stream.Recv() // the stream's status
+			return fromStatus(err)
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			return fromStatus(err)
+		}
+		fn(resp)
+		return nil
+	}, func() error { return finish(stream) })
+}
+
+// finish closes the client side of a batch stream and waits for the
+// server to end it.
+func finish[Req, Resp any](stream grpc.BidiStreamingClient[Req, Resp]) error {
+	if err := stream.CloseSend(); err != nil {
+		return fromStatus(err)
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		return fromStatus(err)
+	}
+	return nil
+}
+
+// inChunks calls fn with successive chunks of keys, then done.
+func inChunks(keys []string, fn func([]string) error, done func() error) error {
+	for start := 0; start < len(keys); start += batchChunk {
+		if err := fn(keys[start:min(start+batchChunk, len(keys))]); err != nil {
+			return err
+		}
+	}
+	return done()
+}
@@ -0,0 +1,185 @@
+// Package client is the Go client for a kvi server. It calls the gRPC API
+// for the operations it has and the REST API for the rest, and hands back
+// the server's own types.Record, so callers need no conversion layer.
+//
+//	c, err := client.New(
+//		client.WithGRPC("localhost:50051"),
+//		client.WithHTTP("http://localhost:8080"),
+//		client.WithAPIKey(os.Getenv("KVI_API_KEY")),
+//	)
+//	defer c.Close()
+//	rec, err := c.Get(ctx, "user:1")
+//	if errors.Is(err, types.ErrKeyNotFound) { ... }
+package client
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	// DefaultTimeout bounds each call whose context has no deadline.
+	DefaultTimeout = 10 * time.Second
+	// DefaultMaxIdleConns is how many idle REST connections are kept open.
+	DefaultMaxIdleConns = 16
+	// DefaultScanPageSize is how many records Scan fetches per request.
+	DefaultScanPageSize = 500
+)
+
+// Client talks to one kvi server. It is safe for concurrent use.
+type Client struct {
+	baseURL      string // REST API root; "" without WithHTTP
+	http         *http.Client
+	maxIdleConns int
+
+	grpcTarget string
+	grpcConns  int
+	dialOpts   []grpc.DialOption
+	conns      []*grpc.ClientConn
+	stubs      []kvi_grpc.KviServiceClient
+	next       atomic.Uint64
+
+	tokens   *tokenSource
+	timeout  time.Duration
+	retry    Retry
+	scanPage int
+}
+
+// Retry controls how failed idempotent calls are retried: reads, deletes
+// and read-only queries. Writes are never retried, since the first attempt
+// may have been applied.
+type Retry struct {
+	Attempts   int           // total tries, including the first; <= 1 disables retries
+	Backoff    time.Duration // wait before the second try, doubled for each after it
+	MaxBackoff time.Duration
+}
+
+// DefaultRetry returns the retry policy used unless WithRetry is given.
+func DefaultRetry() Retry {
+	return Retry{Attempts: 3, Backoff: 50 * time.Millisecond, MaxBackoff: 2 * time.Second}
+}
+
+// New returns a client for the server named by WithGRPC and/or WithHTTP.
+// Calls go over gRPC when it is configured and the gRPC API has them;
+// Delete and Query always use the REST API.
+func New(opts ...func(*Client)) (*Client, error) {
+	c := &Client{
+		maxIdleConns: DefaultMaxIdleConns,
+		grpcConns:    1,
+		timeout:      DefaultTimeout,
+		retry:        DefaultRetry(),
+		scanPage:     DefaultScanPageSize,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.baseURL == "" && c.grpcTarget == "" {
+		return nil, errors.New("client: WithHTTP or WithGRPC is required")
+	}
+	if c.tokens != nil && c.tokens.apiKey != "" && c.baseURL == "" {
+		return nil, errors.New("client: WithAPIKey needs WithHTTP to obtain tokens")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = c.maxIdleConns
+	transport.MaxIdleConnsPerHost = c.maxIdleConns
+	c.http = &http.Client{Transport: transport}
+	if c.tokens != nil {
+		c.tokens.http, c.tokens.baseURL = c.http, c.baseURL
+	}
+
+	if c.grpcTarget != "" {
+		dialOpts := append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, c.dialOpts...)
+		for range max(c.grpcConns, 1) {
+			conn, err := grpc.NewClient(c.grpcTarget, dialOpts...)
+			if err != nil {
+				c.Close()
+				return nil, err
+			}
+			c.conns = append(c.conns, conn)
+			c.stubs = append(c.stubs, kvi_grpc.NewKviServiceClient(conn))
+		}
+	}
+	return c, nil
+}
+
+// WithHTTP sets the REST API root, e.g. "http://localhost:8080".
+func WithHTTP(baseURL string) func(*Client) {
+	return func(c *Client) { c.baseURL = strings.TrimSuffix(baseURL, "/") }
+}
+
+// WithGRPC sets the gRPC target, e.g. "localhost:50051". Connections are
+// plaintext unless opts carry transport credentials.
+func WithGRPC(target string, opts ...grpc.DialOption) func(*Client) {
+	return func(c *Client) {
+		c.grpcTarget = target
+		c.dialOpts = append(c.dialOpts, opts...)
+	}
+}
+
+// WithGRPCConns spreads gRPC calls over n connections instead of one, for
+// clients busy enough to saturate a single HTTP/2 connection.
+func WithGRPCConns(n int) func(*Client) {
+	return func(c *Client) { c.grpcConns = n }
+}
+
+// WithMaxIdleConns sets how many idle REST connections are kept for reuse.
+func WithMaxIdleConns(n int) func(*Client) {
+	return func(c *Client) { c.maxIdleConns = n }
+}
+
+// WithAPIKey authenticates by exchanging key for a token, refreshing it
+// before it expires and logging in again if the server rejects it.
+func WithAPIKey(key string) func(*Client) {
+	return func(c *Client) { c.tokens = &tokenSource{apiKey: key} }
+}
+
+// WithToken authenticates with a token obtained elsewhere. It is used as is
+// and never refreshed.
+func WithToken(token string) func(*Client) {
+	return func(c *Client) { c.tokens = &tokenSource{token: token} }
+}
+
+// WithTimeout bounds each call whose context has no deadline; 0 leaves
+// calls unbounded. Retries of a call share one timeout per attempt.
+func WithTimeout(d time.Duration) func(*Client) {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithRetry replaces DefaultRetry.
+func WithRetry(r Retry) func(*Client) {
+	return func(c *Client) { c.retry = r }
+}
+
+// WithScanPageSize sets how many records Scan fetches per request.
+func WithScanPageSize(n int) func(*Client) {
+	return func(c *Client) { c.scanPage = n }
+}
+
+// Close closes the client's connections. Open subscriptions end.
+func (c *Client) Close() error {
+	var errs []error
+	for _, conn := range c.conns {
+		errs = append(errs, conn.Close())
+	}
+	if c.http != nil {
+		c.http.CloseIdleConnections()
+	}
+	return errors.Join(errs...)
+}
+
+// stub returns the next gRPC connection's service client, or nil without
+// WithGRPC.
+func (c *Client) stub() kvi_grpc.KviServiceClient {
+	if len(c.stubs) == 0 {
+		return nil
+	}
+	return c.stubs[c.next.Add(1)%uint64(len(c.stubs))]
+}
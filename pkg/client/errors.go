@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/auth"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Client errors, alongside the types.Err* sentinels and auth.ErrForbidden
+// that server failures are mapped back to. Test with errors.Is.
+var (
+	ErrUnauthenticated = errors.New("unauthenticated") // missing, invalid or expired credentials
+	ErrUnavailable     = errors.New("server unavailable")
+	ErrRateLimited     = errors.New("rate limited")
+)
+
+// serverError is a failure reported by the server: it reads as the
+// server's message and matches the sentinel for its status, if any.
+type serverError struct {
+	msg string
+	err error
+}
+
+func (e *serverError) Error() string { return e.msg }
+func (e *serverError) Unwrap() error { return e.err }
+
+// grpcErrors maps status codes back to the errors the server mapped from.
+var grpcErrors = map[codes.Code]error{
+	codes.NotFound:           types.ErrKeyNotFound,
+	codes.AlreadyExists:      types.ErrKeyExists,
+	codes.FailedPrecondition: types.ErrVersionMismatch,
+	codes.ResourceExhausted:  types.ErrQueueFull,
+	codes.OutOfRange:         types.ErrHistoryUnavailable,
+	codes.DeadlineExceeded:   context.DeadlineExceeded,
+	codes.Canceled:           context.Canceled,
+	codes.Unauthenticated:    ErrUnauthenticated,
+	codes.PermissionDenied:   auth.ErrForbidden,
+	codes.Unimplemented:      errors.ErrUnsupported,
+	codes.Unavailable:        ErrUnavailable,
+}
+
+// fromStatus converts an error returned by a gRPC stub.
+func fromStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	return &serverError{msg: st.Message(), err: refine(grpcErrors[st.Code()], st.Message())}
+}
+
+// refine tells failures that share a status with others apart by their
+// message: a rejection by the server's connection limits, retried as the
+// server being unavailable, a write refused by a read-only replica, one
+// refused by a full vector index, one fenced by a full disk, and a copy
+// or rename onto a key that exists.
+func refine(err error, msg string) error {
+	switch {
+	case strings.HasPrefix(msg, types.ErrConnectionLimit.Error()):
+		return errors.Join(types.ErrConnectionLimit, ErrUnavailable)
+	case strings.HasPrefix(msg, types.ErrReadOnly.Error()):
+		return types.ErrReadOnly
+	case strings.HasPrefix(msg, types.ErrMemoryLimit.Error()):
+		return types.ErrMemoryLimit
+	case strings.HasPrefix(msg, types.ErrDiskFull.Error()):
+		return types.ErrDiskFull
+	case strings.HasPrefix(msg, types.ErrKeyExists.Error()):
+		return types.ErrKeyExists
+	}
+	return err
+}
+
+var httpErrors = map[int]error{
+	http.StatusNotFound:            types.ErrKeyNotFound,
+	http.StatusPreconditionFailed:  types.ErrVersionMismatch,
+	http.StatusUnauthorized:        ErrUnauthenticated,
+	http.StatusForbidden:           auth.ErrForbidden,
+	http.StatusTooManyRequests:     ErrRateLimited,
+	http.StatusNotImplemented:      errors.ErrUnsupported,
+	http.StatusBadGateway:          ErrUnavailable,
+	http.StatusServiceUnavailable:  ErrUnavailable,
+	http.StatusInsufficientStorage: types.ErrMemoryLimit,
+	http.StatusGatewayTimeout:      context.DeadlineExceeded,
+}
+
+// fromHTTP converts an error response, reading its {"error": ...} body or
+// plain text message.
+func fromHTTP(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	msg := strings.TrimSpace(string(body))
+	var payload struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &payload) == nil && payload.Error != "" {
+		msg = payload.Error
+	}
+	if msg == "" {
+		msg = resp.Status
+	}
+	return &serverError{msg: msg, err: refine(httpErrors[resp.StatusCode], msg)}
+}
+
+func retryable(err error) bool {
+	return errors.Is(err, ErrUnavailable) || errors.Is(err, ErrRateLimited)
+}
+
+// delay is the jittered wait after the given failed attempt.
+func (r Retry) delay(attempt int) time.Duration {
+	d := r.Backoff << (attempt - 1)
+	if d <= 0 || (r.MaxBackoff > 0 && d > r.MaxBackoff) {
+		d = r.MaxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// call runs fn, bounding each attempt by the client timeout. Idempotent
+// calls are retried while the server is unavailable or rate limiting. A
+// call rejected for its token is tried once more with a fresh one.
+func (c *Client) call(ctx context.Context, idempotent bool, fn func(context.Context) error) error {
+	renewed := false
+	for attempt := 1; ; attempt++ {
+		err := c.attempt(ctx, fn)
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, ErrUnauthenticated) && !renewed && c.tokens.renewable():
+			renewed = true
+			c.tokens.invalidate()
+			attempt--
+			continue
+		case !idempotent || attempt >= c.retry.Attempts || !retryable(err):
+			return err
+		}
+		select {
+		case <-time.After(c.retry.delay(attempt)):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, fn func(context.Context) error) error {
+	if _, ok := ctx.Deadline(); !ok && c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	return fn(ctx)
+}
+
+// needsHTTP reports an operation the gRPC API does not have on a client
+// configured without WithHTTP.
+func needsHTTP(op string) error {
+	return fmt.Errorf("%w: %s needs the REST API (WithHTTP)", errors.ErrUnsupported, op)
+}
@@ -0,0 +1,434 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/sql"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Get returns the record stored under key, or an error matching
+// types.ErrKeyNotFound.
+func (c *Client) Get(ctx context.Context, key string) (*types.Record, error) {
+	var rec *types.Record
+	err := c.call(ctx, true, func(ctx context.Context) error {
+		if stub := c.stub(); stub != nil {
+			ctx, err := c.outgoing(ctx)
+			if err != nil {
+				return err
+			}
+			resp, err := stub.Get(ctx, &kvi_grpc.GetRequest{Key: key})
+			if err != nil {
+				return fromStatus(err)
+			}
+			rec, err = fromResponse(resp)
+			return err
+		}
+		rec = new(types.Record)
+		return c.doHTTP(ctx, http.MethodGet, "/api/v1/get", url.Values{"key": {key}}, nil, rec)
+	})
+	return rec, err
+}
+
+// fromResponse decodes a record as the gRPC Get returns it.
+func fromResponse(resp *kvi_grpc.GetResponse) (*types.Record, error) {
+	rec, err := recordOf(resp.Id, resp.DataJson)
+	if err != nil {
+		return nil, err
+	}
+	rec.Version = resp.Version
+	rec.Vector = resp.Vector
+	rec.Blob, rec.ContentType, rec.Tags = resp.Blob, resp.ContentType, resp.Tags
+	rec.CreatedAt = timeOf(resp.CreatedAt)
+	rec.UpdatedAt = timeOf(resp.UpdatedAt)
+	if resp.ExpiresAt != nil {
+		ttl := resp.ExpiresAt.AsTime()
+		rec.TTL = &ttl
+	}
+	return rec, nil
+}
+
+// recordOf decodes a record returned by the gRPC API.
+func recordOf(id, dataJSON string) (*types.Record, error) {
+	rec := &types.Record{ID: id}
+	if err := json.Unmarshal([]byte(dataJSON), &rec.Data); err != nil {
+		return nil, fmt.Errorf("decode record %s: %w", id, err)
+	}
+	return rec, nil
+}
+
+// timeOf converts ts, leaving the time zero when it is unset.
+func timeOf(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+// Put stores rec under key and sets rec.Version to the version stored. An
+// empty or "auto" key has the server make one up, which Put sets as
+// rec.ID. A non-nil rec.TTL expires the record at that time. Puts are not
+// retried.
+func (c *Client) Put(ctx context.Context, key string, rec *types.Record) error {
+	return c.call(ctx, false, func(ctx context.Context) error {
+		if stub := c.stub(); stub != nil {
+			ctx, err := c.outgoing(ctx)
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(rec.Data)
+			if err != nil {
+				return err
+			}
+			req := &kvi_grpc.PutRequest{Key: key, DataJson: string(data), Vector: rec.Vector,
+				Blob: rec.Blob, ContentType: rec.ContentType, Tags: rec.Tags}
+			if rec.TTL != nil {
+				// At least 1ms: an already-passed TTL must still expire the record
+				req.TtlMs = max(int64(math.Ceil(float64(time.Until(*rec.TTL))/float64(time.Millisecond))), 1)
+			}
+			resp, err := stub.Put(ctx, req)
+			if err != nil {
+				return fromStatus(err)
+			}
+			rec.ID, rec.Version = resp.Key, resp.Version
+			return nil
+		}
+		var resp struct {
+			Key     string `json:"key"`
+			Version uint64 `json:"version"`
+		}
+		body := map[string]interface{}{"key": key, "data": rec.Data, "vector": rec.Vector, "ttl": rec.TTL,
+			"blob": rec.Blob, "content_type": rec.ContentType, "tags": rec.Tags}
+		if err := c.doHTTP(ctx, http.MethodPost, "/api/v1/put", nil, body, &resp); err != nil {
+			return err
+		}
+		rec.ID, rec.Version = resp.Key, resp.Version
+		return nil
+	})
+}
+
+// BatchPut stores each record under its ID, in order, stopping at the
+// first failure, and sets each one's Version, and the ID of those given
+// an empty or "auto" one. Over REST it is one call;
+// gRPC has no batch write yet, so there it costs one call per record.
+func (c *Client) BatchPut(ctx context.Context, recs []*types.Record) error {
+	if c.stub() == nil {
+		return c.batch(ctx, "put", recs)
+	}
+	for _, rec := range recs {
+		if err := c.Put(ctx, rec.ID, rec); err != nil {
+			return fmt.Errorf("put %s: %w", rec.ID, err)
+		}
+	}
+	return nil
+}
+
+// BatchMerge merges each record into the one stored under its ID, as
+// types.BatchMerger says, in order, stopping at the first failure, and
+// sets each one's Version. It goes over the REST API.
+func (c *Client) BatchMerge(ctx context.Context, recs []*types.Record) error {
+	if c.baseURL == "" {
+		return needsHTTP("BatchMerge")
+	}
+	return c.batch(ctx, "merge", recs)
+}
+
+// batch writes recs in one call to /api/v1/batch. Like puts, it is not
+// retried.
+func (c *Client) batch(ctx context.Context, mode string, recs []*types.Record) error {
+	return c.call(ctx, false, func(ctx context.Context) error {
+		records := make([]map[string]interface{}, len(recs))
+		for i, rec := range recs {
+			records[i] = map[string]interface{}{"key": rec.ID, "data": rec.Data, "vector": rec.Vector, "ttl": rec.TTL,
+				"blob": rec.Blob, "content_type": rec.ContentType, "tags": rec.Tags}
+		}
+		var resp struct {
+			Records []struct {
+				Key     string `json:"key"`
+				Version uint64 `json:"version"`
+			} `json:"records"`
+		}
+		body := map[string]interface{}{"mode": mode, "records": records}
+		if err := c.doHTTP(ctx, http.MethodPost, "/api/v1/batch", nil, body, &resp); err != nil {
+			return err
+		}
+		for i, rec := range resp.Records {
+			if i < len(recs) {
+				recs[i].ID, recs[i].Version = rec.Key, rec.Version
+			}
+		}
+		return nil
+	})
+}
+
+// Copy stores a copy of src's record under dst, as types.Mover says, and
+// returns it. It goes over the REST API and, like puts, is not retried.
+func (c *Client) Copy(ctx context.Context, src, dst string, opts types.MoveOptions) (*types.Record, error) {
+	return c.move(ctx, "Copy", "/api/v1/copy", src, dst, opts)
+}
+
+// Rename moves src's record to dst, as types.Mover says, and returns it. It
+// goes over the REST API and is not retried.
+func (c *Client) Rename(ctx context.Context, src, dst string, opts types.MoveOptions) (*types.Record, error) {
+	return c.move(ctx, "Rename", "/api/v1/rename", src, dst, opts)
+}
+
+func (c *Client) move(ctx context.Context, method, path, src, dst string, opts types.MoveOptions) (*types.Record, error) {
+	if c.baseURL == "" {
+		return nil, needsHTTP(method)
+	}
+	rec := new(types.Record)
+	err := c.call(ctx, false, func(ctx context.Context) error {
+		body := map[string]interface{}{"src": src, "dst": dst, "overwrite": opts.Overwrite, "keep_created_at": opts.KeepCreatedAt}
+		return c.doHTTP(ctx, http.MethodPost, path, nil, body, rec)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// Delete removes key. It goes over the REST API.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	if c.baseURL == "" {
+		return needsHTTP("Delete")
+	}
+	return c.call(ctx, true, func(ctx context.Context) error {
+		return c.doHTTP(ctx, http.MethodDelete, "/api/v1/delete", url.Values{"key": {key}}, nil, nil)
+	})
+}
+
+// Scan calls fn for every record whose key starts with prefix, in key
+// order, until fn returns false. Over gRPC it follows the server's resume
+// tokens; over REST it fetches a page at a time (WithScanPageSize). A
+// failed call or page is retried from the last record delivered.
+func (c *Client) Scan(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+	if c.stub() != nil {
+		return c.scanGrpc(ctx, prefix, fn)
+	}
+	cursor := ""
+	for {
+		var page struct {
+			Items      []*types.Record `json:"items"`
+			Truncated  bool            `json:"truncated"`
+			NextCursor string          `json:"next_cursor"`
+		}
+		query := url.Values{"prefix": {prefix}, "limit": {strconv.Itoa(c.scanPage)}, "cursor": {cursor}}
+		err := c.call(ctx, true, func(ctx context.Context) error {
+			return c.doHTTP(ctx, http.MethodGet, "/api/v1/scan", query, nil, &page)
+		})
+		if err != nil {
+			return err
+		}
+		for _, rec := range page.Items {
+			if !fn(rec) {
+				return nil
+			}
+		}
+		if !page.Truncated {
+			return nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func (c *Client) scanGrpc(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+	req := &kvi_grpc.ScanRequest{Prefix: prefix}
+	for {
+		var token string
+		stopped := false
+		err := c.call(ctx, true, func(ctx context.Context) error {
+			ctx, cancel := context.WithCancel(ctx) // ends the stream if fn stops early
+			defer cancel()
+			ctx, err := c.outgoing(ctx)
+			if err != nil {
+				return err
+			}
+			stream, err := c.stub().Scan(ctx, req)
+			if err != nil {
+				return fromStatus(err)
+			}
+			for {
+				resp, err := stream.Recv()
+				if err != nil {
+					return fromStatus(err)
+				}
+				for _, r := range resp.Records {
+					rec, err := fromResponse(r)
+					if err != nil {
+						return err
+					}
+					// A retry picks up just after this record
+					req.Start, req.ResumeToken = rec.ID+"\x00", ""
+					if !fn(rec) {
+						stopped = true
+						return nil
+					}
+				}
+				if resp.Last {
+					token = resp.ResumeToken
+					return nil
+				}
+			}
+		})
+		if err != nil || stopped || token == "" {
+			return err
+		}
+		req.Start, req.ResumeToken = "", token
+	}
+}
+
+// VectorSearch returns up to k records nearest to vector, closest first,
+// with ID and Data set. It needs the gRPC API and a vector engine.
+func (c *Client) VectorSearch(ctx context.Context, vector []float32, k int) ([]*types.Record, error) {
+	return c.vectorSearch(ctx, &kvi_grpc.VectorSearchRequest{Vector: vector, K: int32(k)})
+}
+
+// VectorSearchFiltered is VectorSearch over the records whose Data holds
+// every field of filter with its value (see types.FilteredSearcher).
+func (c *Client) VectorSearchFiltered(ctx context.Context, vector []float32, k int, filter map[string]interface{}) ([]*types.Record, error) {
+	b, err := json.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+	return c.vectorSearch(ctx, &kvi_grpc.VectorSearchRequest{Vector: vector, K: int32(k), FilterJson: string(b)})
+}
+
+func (c *Client) vectorSearch(ctx context.Context, req *kvi_grpc.VectorSearchRequest) ([]*types.Record, error) {
+	if c.stub() == nil {
+		return nil, fmt.Errorf("%w: VectorSearch needs the gRPC API (WithGRPC)", errors.ErrUnsupported)
+	}
+	var recs []*types.Record
+	err := c.call(ctx, true, func(ctx context.Context) error {
+		ctx, err := c.outgoing(ctx)
+		if err != nil {
+			return err
+		}
+		resp, err := c.stub().VectorSearch(ctx, req)
+		if err != nil {
+			return fromStatus(err)
+		}
+		recs = make([]*types.Record, len(resp.Results))
+		for i, r := range resp.Results {
+			if recs[i], err = recordOf(r.Id, r.DataJson); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return recs, err
+}
+
+// QueryResult is the outcome of a SQL statement. Items holds the selected
+// records, or one status object per record a write affected. Columns lists
+// the columns a SELECT named, in order; records still carry every field.
+type QueryResult struct {
+	Items       []json.RawMessage `json:"items"`
+	Count       int               `json:"count"`
+	Columns     []string          `json:"columns,omitempty"`
+	RowsScanned int               `json:"rows_scanned"`
+	DurationMs  float64           `json:"duration_ms"`
+}
+
+// Records decodes Items as records, for the result of a SELECT.
+func (r *QueryResult) Records() ([]*types.Record, error) {
+	recs := make([]*types.Record, len(r.Items))
+	for i, item := range r.Items {
+		if err := json.Unmarshal(item, &recs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return recs, nil
+}
+
+// Query executes one SQL statement over the REST API. Read-only
+// statements are retried like other reads.
+func (c *Client) Query(ctx context.Context, query string) (*QueryResult, error) {
+	if c.baseURL == "" {
+		return nil, needsHTTP("Query")
+	}
+	var result QueryResult
+	err := c.call(ctx, sql.IsReadOnly(query), func(ctx context.Context) error {
+		return c.doHTTP(ctx, http.MethodPost, "/api/v1/query", nil, map[string]string{"query": query}, &result)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// doHTTP makes one REST call, encoding body as JSON if non-nil and decoding
+// a successful response into out if non-nil.
+func (c *Client) doHTTP(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	resp, err := c.sendHTTP(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// sendHTTP makes one REST call and returns the response if it succeeded.
+func (c *Client) sendHTTP(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	target := c.baseURL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req)
+}
+
+// send authorizes req and makes it, returning the response if it succeeded.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	token, err := c.tokens.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &serverError{msg: err.Error(), err: ErrUnavailable}
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, fromHTTP(resp)
+	}
+	return resp, nil
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/thirawat27/kvi/internal/backup"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// The replication calls below use the gRPC API and need an admin token.
+// Like Backup they last as long as the data or the stream does, so they
+// are not bounded by the client timeout or retried; bound them with ctx.
+
+// Snapshot streams a backup of every record to w over gRPC, in the format
+// Backup writes, and checks it against the server's checksum. For an
+// engine that ships its WAL it also returns the LSN to Replicate from:
+// the backup holds every change up to it.
+func (c *Client) Snapshot(ctx context.Context, w io.Writer) (backup.Summary, uint64, error) {
+	var sum backup.Summary
+	if c.stub() == nil {
+		return sum, 0, fmt.Errorf("%w: Snapshot needs the gRPC API (WithGRPC)", errors.ErrUnsupported)
+	}
+	ctx, err := c.outgoing(ctx)
+	if err != nil {
+		return sum, 0, err
+	}
+	stream, err := c.stub().Snapshot(ctx, &kvi_grpc.SnapshotRequest{})
+	if err != nil {
+		return sum, 0, fromStatus(err)
+	}
+
+	h := sha256.New()
+	for next := uint64(0); ; next++ {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return sum, 0, ErrIncompleteBackup
+		}
+		if err != nil {
+			return sum, 0, fromStatus(err)
+		}
+		if chunk.Last {
+			sum.Checksum = hex.EncodeToString(h.Sum(nil))
+			if !strings.EqualFold(chunk.Checksum, sum.Checksum) {
+				return sum, 0, fmt.Errorf("backup checksum mismatch: server sent %s, received %s", chunk.Checksum, sum.Checksum)
+			}
+			sum.Records = int(chunk.Records)
+			return sum, chunk.Lsn, nil
+		}
+		if chunk.Index != next {
+			return sum, 0, fmt.Errorf("snapshot chunk %d arrived where %d was expected", chunk.Index, next)
+		}
+		if _, err := w.Write(chunk.Data); err != nil {
+			return sum, 0, err
+		}
+		h.Write(chunk.Data)
+		sum.Bytes += int64(len(chunk.Data))
+	}
+}
+
+// Replicate streams the changes the server logs after afterLSN, in LSN
+// order, calling fn with each (its Seq is the LSN) until ctx ends, fn
+// fails or the stream does. Heartbeats reach fn too, with heartbeat set
+// and Seq the server's last LSN. It fails with types.ErrHistoryUnavailable
+// when the server no longer has the changes after afterLSN.
+func (c *Client) Replicate(ctx context.Context, afterLSN uint64, fn func(ev types.ChangeEvent, heartbeat bool) error) error {
+	if c.stub() == nil {
+		return fmt.Errorf("%w: Replicate needs the gRPC API (WithGRPC)", errors.ErrUnsupported)
+	}
+	ctx, cancel := context.WithCancel(ctx) // ends the stream if fn fails
+	defer cancel()
+	ctx, err := c.outgoing(ctx)
+	if err != nil {
+		return err
+	}
+	stream, err := c.stub().Replicate(ctx, &kvi_grpc.ReplicateRequest{AfterLsn: afterLSN})
+	if err != nil {
+		return fromStatus(err)
+	}
+	for {
+		entry, err := stream.Recv()
+		if err != nil {
+			return fromStatus(err)
+		}
+		ev := types.ChangeEvent{Seq: entry.Lsn, Op: types.Operation(strings.ToUpper(entry.Op)), Key: entry.Key}
+		if entry.Record != nil {
+			if ev.Record, err = fromResponse(entry.Record); err != nil {
+				return err
+			}
+		}
+		if err := fn(ev, entry.Op == "heartbeat"); err != nil {
+			return err
+		}
+	}
+}
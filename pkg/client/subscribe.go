@@ -0,0 +1,193 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+)
+
+// Message is one pub/sub delivery. AckToken is set on deliveries from an
+// ack-mode channel; pass the message to Subscription.Ack once handled.
+type Message struct {
+	ID          uint64 `json:"id"`
+	Channel     string `json:"channel"`
+	Payload     string `json:"payload"`
+	ContentType string `json:"content_type,omitempty"` // unset over the REST API
+	AckToken    string `json:"ack_token,omitempty"`
+}
+
+// Subscription delivers a channel's messages on C until it is closed, its
+// context ends, or the connection drops. C is then closed and Err reports
+// why. Subscriptions do not reconnect.
+type Subscription struct {
+	C <-chan Message
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+	ack    func(context.Context, Message) error
+}
+
+// Err returns the error that ended the subscription, or nil if it was
+// closed or its context ended. Call it after C is closed.
+func (s *Subscription) Err() error {
+	<-s.done
+	return s.err
+}
+
+// Close ends the subscription and waits for C to close.
+func (s *Subscription) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+// Ack acknowledges a delivery from an ack-mode channel, so it is not
+// redelivered.
+func (s *Subscription) Ack(ctx context.Context, msg Message) error {
+	return s.ack(ctx, msg)
+}
+
+// Subscribe follows channel, over a gRPC stream when WithGRPC is set and
+// server-sent events otherwise.
+func (c *Client) Subscribe(ctx context.Context, channel string) (*Subscription, error) {
+	return c.SubscribeGroup(ctx, channel, "")
+}
+
+// SubscribeGroup follows channel as a member of a consumer group, sharing
+// its messages with the group's other members.
+func (c *Client) SubscribeGroup(ctx context.Context, channel, group string) (*Subscription, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	ch := make(chan Message)
+	sub := &Subscription{C: ch, cancel: cancel, done: make(chan struct{})}
+
+	var err error
+	if stub := c.stub(); stub != nil {
+		err = c.subscribeGrpc(ctx, stub, sub, ch, channel, group)
+	} else {
+		err = c.subscribeSSE(ctx, sub, ch, channel, group)
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return sub, nil
+}
+
+func (c *Client) subscribeGrpc(ctx context.Context, stub kvi_grpc.KviServiceClient, sub *Subscription, ch chan<- Message, channel, group string) error {
+	ctx, err := c.outgoing(ctx)
+	if err != nil {
+		return err
+	}
+	stream, err := stub.Stream(ctx)
+	if err != nil {
+		return fromStatus(err)
+	}
+	if err := stream.Send(&kvi_grpc.StreamRequest{Id: subscriberID(), Channel: channel, Group: group}); err != nil {
+		return fromStatus(err)
+	}
+
+	var sendMu sync.Mutex // acks share the stream with no other sender
+	sub.ack = func(_ context.Context, msg Message) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		if err := stream.Send(&kvi_grpc.StreamRequest{AckToken: msg.AckToken}); err != nil {
+			return fromStatus(err)
+		}
+		return nil
+	}
+
+	go func() {
+		defer close(sub.done)
+		defer close(ch)
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				if ctx.Err() == nil {
+					sub.err = fromStatus(err)
+				}
+				return
+			}
+			msg := Message{ID: resp.Id, Channel: resp.Channel, Payload: resp.Payload, ContentType: resp.ContentType, AckToken: resp.AckToken}
+			select {
+			case ch <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (c *Client) subscribeSSE(ctx context.Context, sub *Subscription, ch chan<- Message, channel, group string) error {
+	query := url.Values{"channel": {channel}, "id": {subscriberID()}}
+	if group != "" {
+		query.Set("group", group)
+	}
+	resp, err := c.sendHTTP(ctx, http.MethodGet, "/api/v1/sub", query, nil)
+	if err != nil {
+		return err
+	}
+	sub.ack = func(ctx context.Context, msg Message) error {
+		body := map[string]string{"channel": msg.Channel, "token": msg.AckToken}
+		return c.call(ctx, false, func(ctx context.Context) error {
+			return c.doHTTP(ctx, http.MethodPost, "/api/v1/ack", nil, body, nil)
+		})
+	}
+
+	go func() {
+		defer close(sub.done)
+		defer close(ch)
+		defer resp.Body.Close()
+		var msg Message
+		var data []string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "id: "):
+				msg.ID, _ = strconv.ParseUint(strings.TrimPrefix(line, "id: "), 10, 64)
+			case strings.HasPrefix(line, "data: "):
+				data = append(data, strings.TrimPrefix(line, "data: "))
+			case line == "" && data != nil:
+				msg.Channel, msg.Payload = channel, strings.Join(data, "\n")
+				unwrapAckEnvelope(&msg)
+				select {
+				case ch <- msg:
+				case <-ctx.Done():
+					return
+				}
+				msg, data = Message{}, nil
+			}
+		}
+		if err := scanner.Err(); err != nil && ctx.Err() == nil {
+			sub.err = &serverError{msg: err.Error(), err: ErrUnavailable}
+		}
+	}()
+	return nil
+}
+
+// unwrapAckEnvelope unpacks an ack-mode SSE event, whose data is the whole
+// message as JSON so that it can carry the ack token.
+func unwrapAckEnvelope(msg *Message) {
+	var env Message
+	if json.Unmarshal([]byte(msg.Payload), &env) == nil && env.AckToken != "" {
+		*msg = env
+	}
+}
+
+// subscriberID names a subscription to the server.
+func subscriberID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "go-client-" + hex.EncodeToString(b)
+}
@@ -0,0 +1,67 @@
+// Package codec serializes records where Kvi stores or ships them in bulk:
+// WAL entries, backups and the records kept compressed in memory. JSON is
+// the default. MessagePack keeps integers exact, where JSON decodes every
+// number as a float64 and so rounds those past 2^53, and it is smaller
+// and quicker to encode and decode.
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Names of the codecs, as config and file headers give them.
+const (
+	NameJSON    = "json"
+	NameMsgPack = "msgpack"
+)
+
+// Codec turns values into bytes and back. Unmarshal decodes integers, in
+// an interface{}, as whatever the codec keeps them as: float64 for JSON,
+// int64 (uint64 past its range) for MessagePack.
+type Codec interface {
+	Name() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSON is encoding/json.
+	JSON Codec = jsonCodec{}
+	// MsgPack is MessagePack, with structs encoded as maps keyed by
+	// their JSON field names and times as the timestamp extension.
+	MsgPack Codec = msgpackCodec{}
+)
+
+// Lookup returns the codec called name; "" is JSON.
+func Lookup(name string) (Codec, error) {
+	switch name {
+	case "", NameJSON:
+		return JSON, nil
+	case NameMsgPack:
+		return MsgPack, nil
+	default:
+		return nil, fmt.Errorf("unknown codec %q (want json or msgpack)", name)
+	}
+}
+
+// Detect names the codec data was written with, by its first byte: JSON
+// encodes a record or an entry as an object, MessagePack as a map.
+func Detect(data []byte) (Codec, bool) {
+	switch {
+	case len(data) == 0:
+		return nil, false
+	case data[0] == '{':
+		return JSON, true
+	case data[0]&0xf0 == 0x80 || data[0] == 0xde || data[0] == 0xdf:
+		return MsgPack, true
+	default:
+		return nil, false
+	}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                               { return NameJSON }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
@@ -0,0 +1,657 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The MessagePack encoding is deterministic, so a decoded value encodes
+// back to the same bytes: map keys are sorted, struct fields go in their
+// declared order, integers take their shortest form (unsigned unless
+// negative) and times are always the 12-byte timestamp.
+
+var (
+	timeType   = reflect.TypeFor[time.Time]()
+	numberType = reflect.TypeFor[json.Number]()
+)
+
+// timestampExt is the extension type MessagePack reserves for times.
+const timestampExt = -1
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return NameMsgPack }
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var e encoder
+	if err := e.encode(reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return e.buf, nil
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("msgpack: Unmarshal needs a non-nil pointer")
+	}
+	d := decoder{buf: data}
+	val, err := d.value()
+	if err != nil {
+		return err
+	}
+	if d.pos != len(data) {
+		return fmt.Errorf("msgpack: %d bytes after the value", len(data)-d.pos)
+	}
+	return assign(rv.Elem(), val)
+}
+
+type encoder struct{ buf []byte }
+
+func (e *encoder) encode(v reflect.Value) error {
+	if !v.IsValid() {
+		e.buf = append(e.buf, 0xc0)
+		return nil
+	}
+	switch v.Type() {
+	case timeType:
+		e.time(v.Interface().(time.Time))
+		return nil
+	case numberType:
+		return e.number(json.Number(v.String()))
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		return e.encode(v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 0xc3)
+		} else {
+			e.buf = append(e.buf, 0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.uint(v.Uint())
+	case reflect.Float32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xca), math.Float32bits(float32(v.Float())))
+	case reflect.Float64:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcb), math.Float64bits(v.Float()))
+	case reflect.String:
+		e.str(v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			e.buf = append(e.buf, 0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			e.bin(v.Bytes())
+			return nil
+		}
+		return e.array(v)
+	case reflect.Array:
+		return e.array(v)
+	case reflect.Map:
+		return e.mapOf(v)
+	case reflect.Struct:
+		return e.structOf(v)
+	default:
+		return fmt.Errorf("msgpack: cannot encode %s", v.Type())
+	}
+	return nil
+}
+
+func (e *encoder) int(n int64) {
+	switch {
+	case n >= 0:
+		e.uint(uint64(n))
+	case n >= -32:
+		e.buf = append(e.buf, byte(n))
+	case n >= math.MinInt8:
+		e.buf = append(e.buf, 0xd0, byte(n))
+	case n >= math.MinInt16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xd1), uint16(n))
+	case n >= math.MinInt32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xd2), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xd3), uint64(n))
+	}
+}
+
+func (e *encoder) uint(n uint64) {
+	switch {
+	case n <= 0x7f:
+		e.buf = append(e.buf, byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xcc, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xcd), uint16(n))
+	case n <= math.MaxUint32:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xce), uint32(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcf), n)
+	}
+}
+
+// number encodes a json.Number as the integer it holds, else as a float.
+func (e *encoder) number(n json.Number) error {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		e.int(i)
+		return nil
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		e.uint(u)
+		return nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return fmt.Errorf("msgpack: %w", err)
+	}
+	e.buf = binary.BigEndian.AppendUint64(append(e.buf, 0xcb), math.Float64bits(f))
+	return nil
+}
+
+func (e *encoder) str(s string) {
+	switch n := len(s); {
+	case n <= 31:
+		e.buf = append(e.buf, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xda), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xdb), uint32(n))
+	}
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) bin(b []byte) {
+	switch n := len(b); {
+	case n <= math.MaxUint8:
+		e.buf = append(e.buf, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, 0xc5), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, 0xc6), uint32(n))
+	}
+	e.buf = append(e.buf, b...)
+}
+
+// time writes the 12-byte timestamp: nanoseconds, then Unix seconds.
+func (e *encoder) time(t time.Time) {
+	e.buf = append(e.buf, 0xc7, 12, 0xff) // 0xff is timestampExt, -1
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(t.Nanosecond()))
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(t.Unix()))
+}
+
+func (e *encoder) header(n int, fix byte, b16, b32 byte) {
+	switch {
+	case n <= 15:
+		e.buf = append(e.buf, fix|byte(n))
+	case n <= math.MaxUint16:
+		e.buf = binary.BigEndian.AppendUint16(append(e.buf, b16), uint16(n))
+	default:
+		e.buf = binary.BigEndian.AppendUint32(append(e.buf, b32), uint32(n))
+	}
+}
+
+func (e *encoder) array(v reflect.Value) error {
+	e.header(v.Len(), 0x90, 0xdc, 0xdd)
+	for i := range v.Len() {
+		if err := e.encode(v.Index(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) mapOf(v reflect.Value) error {
+	if v.IsNil() {
+		e.buf = append(e.buf, 0xc0)
+		return nil
+	}
+	if v.Type().Key().Kind() != reflect.String {
+		return fmt.Errorf("msgpack: cannot encode %s, keys must be strings", v.Type())
+	}
+	keys := v.MapKeys()
+	slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(a.String(), b.String()) })
+	e.header(len(keys), 0x80, 0xde, 0xdf)
+	for _, k := range keys {
+		e.str(k.String())
+		if err := e.encode(v.MapIndex(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *encoder) structOf(v reflect.Value) error {
+	fields := fieldsOf(v.Type())
+	kept := make([]field, 0, len(fields))
+	for _, f := range fields {
+		if !f.omitted(v.Field(f.index)) {
+			kept = append(kept, f)
+		}
+	}
+	e.header(len(kept), 0x80, 0xde, 0xdf)
+	for _, f := range kept {
+		e.str(f.name)
+		if err := e.encode(v.Field(f.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// field is an exported struct field as encoding/json sees it.
+type field struct {
+	name      string
+	index     int
+	omitEmpty bool
+	omitZero  bool
+}
+
+func (f field) omitted(v reflect.Value) bool {
+	if f.omitZero {
+		if z, ok := v.Interface().(interface{ IsZero() bool }); ok {
+			return z.IsZero()
+		}
+		return v.IsZero()
+	}
+	if !f.omitEmpty {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	case reflect.Bool:
+		return !v.Bool()
+	}
+	return v.IsZero()
+}
+
+var fieldCache sync.Map // reflect.Type -> []field
+
+func fieldsOf(t reflect.Type) []field {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.([]field)
+	}
+	var fields []field
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if !sf.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+		fields = append(fields, field{
+			name:      name,
+			index:     i,
+			omitEmpty: strings.Contains(","+opts+",", ",omitempty,"),
+			omitZero:  strings.Contains(","+opts+",", ",omitzero,"),
+		})
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
+
+// decoder reads one value from buf into the generic form: nil, bool,
+// int64 (uint64 past its range), float32, float64, string, []byte,
+// time.Time, []interface{} and map[string]interface{}.
+type decoder struct {
+	buf []byte
+	pos int
+}
+
+var errShort = errors.New("msgpack: unexpected end of data")
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || n > len(d.buf)-d.pos {
+		return nil, errShort
+	}
+	b := d.buf[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// length reads a big-endian length of size bytes.
+func (d *decoder) length(size int) (int, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return int(b[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(b)), nil
+	default:
+		return int(binary.BigEndian.Uint32(b)), nil
+	}
+}
+
+func (d *decoder) value() (interface{}, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	switch c := b[0]; {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapN(int(c & 0x0f))
+	case c&0xf0 == 0x90:
+		return d.arrayN(int(c & 0x0f))
+	case c&0xe0 == 0xa0:
+		return d.strN(int(c & 0x1f))
+	case c == 0xc0:
+		return nil, nil
+	case c == 0xc2, c == 0xc3:
+		return c == 0xc3, nil
+	case c >= 0xc4 && c <= 0xc6:
+		n, err := d.length(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		raw, err := d.next(n)
+		return bytes.Clone(raw), err
+	case c >= 0xc7 && c <= 0xc9:
+		n, err := d.length(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(n)
+	case c == 0xca:
+		raw, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(raw)), nil
+	case c == 0xcb:
+		raw, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), nil
+	case c >= 0xcc && c <= 0xcf:
+		raw, err := d.next(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		var u uint64
+		for _, x := range raw {
+			u = u<<8 | uint64(x)
+		}
+		if u > math.MaxInt64 {
+			return u, nil
+		}
+		return int64(u), nil
+	case c >= 0xd0 && c <= 0xd3:
+		raw, err := d.next(1 << (c - 0xd0))
+		if err != nil {
+			return nil, err
+		}
+		switch len(raw) {
+		case 1:
+			return int64(int8(raw[0])), nil
+		case 2:
+			return int64(int16(binary.BigEndian.Uint16(raw))), nil
+		case 4:
+			return int64(int32(binary.BigEndian.Uint32(raw))), nil
+		default:
+			return int64(binary.BigEndian.Uint64(raw)), nil
+		}
+	case c >= 0xd4 && c <= 0xd8:
+		return d.ext(1 << (c - 0xd4))
+	case c >= 0xd9 && c <= 0xdb:
+		n, err := d.length(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.strN(n)
+	case c == 0xdc, c == 0xdd:
+		n, err := d.length(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayN(n)
+	case c == 0xde, c == 0xdf:
+		n, err := d.length(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapN(n)
+	default:
+		return nil, fmt.Errorf("msgpack: invalid byte 0x%02x", c)
+	}
+}
+
+func (d *decoder) strN(n int) (interface{}, error) {
+	raw, err := d.next(n)
+	return string(raw), err
+}
+
+func (d *decoder) arrayN(n int) (interface{}, error) {
+	if n > len(d.buf)-d.pos { // every element takes a byte at least
+		return nil, errShort
+	}
+	arr := make([]interface{}, n)
+	for i := range arr {
+		v, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		arr[i] = v
+	}
+	return arr, nil
+}
+
+func (d *decoder) mapN(n int) (interface{}, error) {
+	if n > (len(d.buf)-d.pos)/2 {
+		return nil, errShort
+	}
+	m := make(map[string]interface{}, n)
+	for range n {
+		k, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack: map key is %T, not a string", k)
+		}
+		if m[key], err = d.value(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// ext reads an extension of n bytes after its type; times are the only
+// extension there is.
+func (d *decoder) ext(n int) (interface{}, error) {
+	typ, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	if int8(typ[0]) != timestampExt {
+		return nil, fmt.Errorf("msgpack: unknown extension type %d", int8(typ[0]))
+	}
+	switch n {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(raw)), 0).UTC(), nil
+	case 8:
+		v := binary.BigEndian.Uint64(raw)
+		return time.Unix(int64(v&(1<<34-1)), int64(v>>34)).UTC(), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(raw[4:])), int64(binary.BigEndian.Uint32(raw))).UTC(), nil
+	default:
+		return nil, fmt.Errorf("msgpack: timestamp of %d bytes", n)
+	}
+}
+
+// assign stores a decoded value in dst, converting it as encoding/json
+// would and failing where it would.
+func assign(dst reflect.Value, src interface{}) error {
+	mismatch := func() error { return fmt.Errorf("msgpack: cannot decode %T into %s", src, dst.Type()) }
+	if src == nil {
+		switch dst.Kind() {
+		case reflect.Interface, reflect.Pointer, reflect.Map, reflect.Slice:
+			dst.SetZero()
+		}
+		return nil
+	}
+	if dst.Type() == timeType {
+		t, ok := src.(time.Time)
+		if !ok {
+			return mismatch()
+		}
+		dst.Set(reflect.ValueOf(t))
+		return nil
+	}
+	switch dst.Kind() {
+	case reflect.Interface:
+		v := reflect.ValueOf(src)
+		if !v.Type().AssignableTo(dst.Type()) {
+			return mismatch()
+		}
+		dst.Set(v)
+	case reflect.Pointer:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return assign(dst.Elem(), src)
+	case reflect.Bool:
+		b, ok := src.(bool)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetBool(b)
+	case reflect.String:
+		s, ok := src.(string)
+		if !ok {
+			return mismatch()
+		}
+		dst.SetString(s)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := src.(int64)
+		if !ok || dst.OverflowInt(n) {
+			return mismatch()
+		}
+		dst.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch n := src.(type) {
+		case int64:
+			if n < 0 {
+				return mismatch()
+			}
+			u = uint64(n)
+		case uint64:
+			u = n
+		default:
+			return mismatch()
+		}
+		if dst.OverflowUint(u) {
+			return mismatch()
+		}
+		dst.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		switch n := src.(type) {
+		case float32:
+			dst.SetFloat(float64(n))
+		case float64:
+			dst.SetFloat(n)
+		case int64:
+			dst.SetFloat(float64(n))
+		case uint64:
+			dst.SetFloat(float64(n))
+		default:
+			return mismatch()
+		}
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			b, ok := src.([]byte)
+			if !ok {
+				return mismatch()
+			}
+			dst.SetBytes(b)
+			return nil
+		}
+		arr, ok := src.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		s := reflect.MakeSlice(dst.Type(), len(arr), len(arr))
+		for i, v := range arr {
+			if err := assign(s.Index(i), v); err != nil {
+				return err
+			}
+		}
+		dst.Set(s)
+	case reflect.Array:
+		arr, ok := src.([]interface{})
+		if !ok || len(arr) != dst.Len() {
+			return mismatch()
+		}
+		for i, v := range arr {
+			if err := assign(dst.Index(i), v); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := src.(map[string]interface{})
+		if !ok || dst.Type().Key().Kind() != reflect.String {
+			return mismatch()
+		}
+		out := reflect.MakeMapWithSize(dst.Type(), len(m))
+		for k, v := range m {
+			elem := reflect.New(dst.Type().Elem()).Elem()
+			if err := assign(elem, v); err != nil {
+				return err
+			}
+			out.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()), elem)
+		}
+		dst.Set(out)
+	case reflect.Struct:
+		m, ok := src.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for _, f := range fieldsOf(dst.Type()) {
+			if v, ok := m[f.name]; ok {
+				if err := assign(dst.Field(f.index), v); err != nil {
+					return fmt.Errorf("%s: %w", f.name, err)
+				}
+			}
+		}
+	default:
+		return mismatch()
+	}
+	return nil
+}
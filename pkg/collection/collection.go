@@ -0,0 +1,285 @@
+// Package collection stores lists and sets of strings, and sorted sets of
+// scored strings, in records, for queues, tag sets and leaderboards kept
+// on the server. A collection is a record whose data is {"kvi_type":
+// "list" | "set" | "zset", "items": [...]}. Set items are kept sorted;
+// sorted set items, {"member": ..., "score": ...}, are kept by score.
+// Every change is one engine Update, atomic under the engine's write lock
+// and logged as the record it leaves, so collections work in every mode
+// and replicate and back up like any other record. As in Redis, a
+// collection is created by its first push or add and deleted when its
+// last item is removed. Using a collection of one kind as another, or a
+// plain record as any, fails with types.ErrWrongType.
+package collection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// The reserved shape of a collection's data.
+const (
+	TypeField  = "kvi_type" // List or Set
+	ItemsField = "items"    // the strings, in order
+)
+
+// The kinds of collection.
+const (
+	List = "list"
+	Set  = "set"
+	ZSet = "zset" // members ordered by score
+)
+
+// kind is how collections of one kind keep their items in a record's data.
+type kind[T any] struct {
+	name   string
+	decode func(v interface{}) (T, bool) // an item as stored, or false
+	encode func(T) interface{}
+}
+
+var (
+	lists = kind[string]{name: List, decode: decodeString, encode: encodeString}
+	sets  = kind[string]{name: Set, decode: decodeString, encode: encodeString}
+)
+
+func decodeString(v interface{}) (string, bool) {
+	s, ok := v.(string)
+	return s, ok
+}
+
+func encodeString(s string) interface{} { return s }
+
+// itemsOf returns the items of rec, which must be a collection of k.
+func (k kind[T]) itemsOf(key string, rec *types.Record) ([]T, error) {
+	have, _ := rec.Data[TypeField].(string)
+	if have == "" {
+		have = "record"
+	}
+	if have != k.name {
+		return nil, fmt.Errorf("%w: %s holds a %s, not a %s", types.ErrWrongType, key, have, k.name)
+	}
+	raw, _ := rec.Data[ItemsField].([]interface{})
+	items := make([]T, 0, len(raw))
+	for _, v := range raw {
+		item, ok := k.decode(v)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s has a malformed item", types.ErrWrongType, key)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// setItems makes rec's data the collection of k holding items.
+func (k kind[T]) setItems(rec *types.Record, items []T) {
+	raw := make([]interface{}, len(items))
+	for i, item := range items {
+		raw[i] = k.encode(item)
+	}
+	rec.Data = map[string]interface{}{TypeField: k.name, ItemsField: raw}
+}
+
+// modify replaces the items of the collection of k at key with what fn
+// returns, creating the collection empty first if key is missing; an
+// error from fn leaves it as it was. fn may run more than once when a
+// concurrent write creates the key.
+func modify[T any](ctx context.Context, eng types.Engine, key string, k kind[T], fn func(items []T) ([]T, error)) error {
+	_, err := eng.Update(ctx, key, func(rec *types.Record) error {
+		items, err := k.itemsOf(key, rec)
+		if err == nil {
+			items, err = fn(items)
+		}
+		if err != nil {
+			return err
+		}
+		k.setItems(rec, items)
+		return nil
+	})
+	if !errors.Is(err, types.ErrKeyNotFound) {
+		return err
+	}
+	items, err := fn(nil)
+	if err != nil {
+		return err
+	}
+	rec := &types.Record{ID: key}
+	k.setItems(rec, items)
+	err = eng.CompareAndSwap(ctx, key, 0, rec)
+	if errors.Is(err, types.ErrVersionMismatch) {
+		return modify(ctx, eng, key, k, fn) // created meanwhile
+	}
+	return err
+}
+
+// remove takes items out of the collection of k at key with fn, which
+// returns what is left, and deletes the collection once it is empty. A
+// missing key is left alone and fn is not called.
+func remove[T any](ctx context.Context, eng types.Engine, key string, k kind[T], fn func(items []T) []T) error {
+	rec, err := eng.Update(ctx, key, func(rec *types.Record) error {
+		items, err := k.itemsOf(key, rec)
+		if err != nil {
+			return err
+		}
+		k.setItems(rec, fn(items))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if items, _ := rec.Data[ItemsField].([]interface{}); len(items) == 0 {
+		// A push since the update makes the version differ; the key stays
+		err = eng.CompareAndSwap(ctx, key, rec.Version, nil)
+		if errors.Is(err, types.ErrVersionMismatch) {
+			err = nil
+		}
+	}
+	return err
+}
+
+// read returns the items of the collection of k at key; none if it is
+// missing.
+func read[T any](ctx context.Context, eng types.Engine, key string, k kind[T]) ([]T, error) {
+	rec, err := eng.Get(ctx, key)
+	if errors.Is(err, types.ErrKeyNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k.itemsOf(key, rec)
+}
+
+// LPush adds values to the head of the list at key, each in turn, so the
+// last ends up first. It returns the length of the list.
+func LPush(ctx context.Context, eng types.Engine, key string, values ...string) (int, error) {
+	var n int
+	err := modify(ctx, eng, key, lists, func(items []string) ([]string, error) {
+		head := slices.Clone(values)
+		slices.Reverse(head)
+		items = append(head, items...)
+		n = len(items)
+		return items, nil
+	})
+	return n, err
+}
+
+// RPush adds values to the tail of the list at key and returns its length.
+func RPush(ctx context.Context, eng types.Engine, key string, values ...string) (int, error) {
+	var n int
+	err := modify(ctx, eng, key, lists, func(items []string) ([]string, error) {
+		items = append(items, values...)
+		n = len(items)
+		return items, nil
+	})
+	return n, err
+}
+
+// LPop removes and returns the head of the list at key. An empty list is
+// missing, so it fails with types.ErrKeyNotFound.
+func LPop(ctx context.Context, eng types.Engine, key string) (string, error) {
+	return pop(ctx, eng, key, func(items []string) (string, []string) { return items[0], items[1:] })
+}
+
+// RPop removes and returns the tail of the list at key, as LPop does the
+// head.
+func RPop(ctx context.Context, eng types.Engine, key string) (string, error) {
+	return pop(ctx, eng, key, func(items []string) (string, []string) {
+		return items[len(items)-1], items[:len(items)-1]
+	})
+}
+
+func pop(ctx context.Context, eng types.Engine, key string, take func([]string) (string, []string)) (string, error) {
+	var value string
+	var found bool
+	err := remove(ctx, eng, key, lists, func(items []string) []string {
+		if found = len(items) > 0; !found {
+			return items
+		}
+		value, items = take(items)
+		return items
+	})
+	if err == nil && !found {
+		err = fmt.Errorf("%w: list %s is empty", types.ErrKeyNotFound, key)
+	}
+	return value, err
+}
+
+// LRange returns the items of the list at key from start to stop,
+// inclusive. Negative indexes count from the end, -1 being the last item,
+// and indexes past either end are clamped, as in Redis.
+func LRange(ctx context.Context, eng types.Engine, key string, start, stop int) ([]string, error) {
+	items, err := read(ctx, eng, key, lists)
+	if err != nil {
+		return nil, err
+	}
+	n := len(items)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return []string{}, nil
+	}
+	return items[start : stop+1], nil
+}
+
+// SAdd adds members to the set at key and returns how many were not
+// already in it.
+func SAdd(ctx context.Context, eng types.Engine, key string, members ...string) (int, error) {
+	var added int
+	err := modify(ctx, eng, key, sets, func(items []string) ([]string, error) {
+		added = 0
+		for _, m := range members {
+			if i, found := slices.BinarySearch(items, m); !found {
+				items = slices.Insert(items, i, m)
+				added++
+			}
+		}
+		return items, nil
+	})
+	return added, err
+}
+
+// SRem removes members from the set at key and returns how many it held.
+func SRem(ctx context.Context, eng types.Engine, key string, members ...string) (int, error) {
+	var removed int
+	err := remove(ctx, eng, key, sets, func(items []string) []string {
+		removed = 0
+		for _, m := range members {
+			if i, found := slices.BinarySearch(items, m); found {
+				items = slices.Delete(items, i, i+1)
+				removed++
+			}
+		}
+		return items
+	})
+	if errors.Is(err, types.ErrKeyNotFound) {
+		return 0, nil
+	}
+	return removed, err
+}
+
+// SMembers returns the members of the set at key, sorted.
+func SMembers(ctx context.Context, eng types.Engine, key string) ([]string, error) {
+	items, err := read(ctx, eng, key, sets)
+	if items == nil && err == nil {
+		items = []string{}
+	}
+	return items, err
+}
+
+// SIsMember reports whether member is in the set at key.
+func SIsMember(ctx context.Context, eng types.Engine, key, member string) (bool, error) {
+	items, err := read(ctx, eng, key, sets)
+	if err != nil {
+		return false, err
+	}
+	_, found := slices.BinarySearch(items, member)
+	return found, nil
+}
@@ -0,0 +1,192 @@
+package collection
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// ErrInvalidScore is returned when a score, or an increment's result, is
+// NaN or infinite, which the JSON a sorted set is stored as cannot hold.
+var ErrInvalidScore = errors.New("score must be a finite number")
+
+// ZMember is a member of a sorted set and its score.
+type ZMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// A sorted set keeps its items ordered by score, then member, like the
+// keys of a B-tree over (score, member): score ranges and ranks are found
+// by binary search. Finding a member's current score scans the items.
+var zsets = kind[ZMember]{name: ZSet, decode: decodeZMember, encode: encodeZMember}
+
+func decodeZMember(v interface{}) (ZMember, bool) {
+	m, _ := v.(map[string]interface{})
+	member, ok := m["member"].(string)
+	if !ok {
+		return ZMember{}, false
+	}
+	switch score := m["score"].(type) {
+	case float64:
+		return ZMember{Member: member, Score: score}, true
+	case json.Number:
+		f, err := score.Float64()
+		return ZMember{Member: member, Score: f}, err == nil
+	}
+	return ZMember{}, false
+}
+
+func encodeZMember(m ZMember) interface{} {
+	return map[string]interface{}{"member": m.Member, "score": m.Score}
+}
+
+func finite(f float64) bool { return !math.IsNaN(f) && !math.IsInf(f, 0) }
+
+func zcompare(a, b ZMember) int {
+	if c := cmp.Compare(a.Score, b.Score); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.Member, b.Member)
+}
+
+// zindex returns the position of member in items, or -1.
+func zindex(items []ZMember, member string) int {
+	return slices.IndexFunc(items, func(m ZMember) bool { return m.Member == member })
+}
+
+// zset gives member score in items, which stay in order, and reports
+// whether member is new.
+func zset(items []ZMember, member string, score float64) ([]ZMember, bool) {
+	i := zindex(items, member)
+	if i >= 0 {
+		items = slices.Delete(items, i, i+1)
+	}
+	m := ZMember{Member: member, Score: score}
+	at, _ := slices.BinarySearchFunc(items, m, zcompare)
+	return slices.Insert(items, at, m), i < 0
+}
+
+// ZAdd sets the score of each of members in the sorted set at key, adding
+// those not in it, and returns how many it added. A member given twice
+// gets the last of its scores.
+func ZAdd(ctx context.Context, eng types.Engine, key string, members ...ZMember) (int, error) {
+	for _, m := range members {
+		if !finite(m.Score) {
+			return 0, fmt.Errorf("%w: %s", ErrInvalidScore, m.Member)
+		}
+	}
+	var added int
+	err := modify(ctx, eng, key, zsets, func(items []ZMember) ([]ZMember, error) {
+		added = 0
+		for _, m := range members {
+			var isNew bool
+			if items, isNew = zset(items, m.Member, m.Score); isNew {
+				added++
+			}
+		}
+		return items, nil
+	})
+	return added, err
+}
+
+// ZIncrBy adds delta to the score of member in the sorted set at key,
+// adding it with score delta if it is not in it, and returns the new
+// score.
+func ZIncrBy(ctx context.Context, eng types.Engine, key, member string, delta float64) (float64, error) {
+	var score float64
+	err := modify(ctx, eng, key, zsets, func(items []ZMember) ([]ZMember, error) {
+		score = delta
+		if i := zindex(items, member); i >= 0 {
+			score += items[i].Score
+		}
+		if !finite(score) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidScore, member)
+		}
+		items, _ = zset(items, member, score)
+		return items, nil
+	})
+	return score, err
+}
+
+// ZRem removes members from the sorted set at key and returns how many it
+// held.
+func ZRem(ctx context.Context, eng types.Engine, key string, members ...string) (int, error) {
+	var removed int
+	err := remove(ctx, eng, key, zsets, func(items []ZMember) []ZMember {
+		removed = 0
+		for _, member := range members {
+			if i := zindex(items, member); i >= 0 {
+				items = slices.Delete(items, i, i+1)
+				removed++
+			}
+		}
+		return items
+	})
+	if errors.Is(err, types.ErrKeyNotFound) {
+		return 0, nil
+	}
+	return removed, err
+}
+
+// ZRank returns the rank of member in the sorted set at key: 0 for the
+// lowest score, or with reverse for the highest. A member not in the set
+// fails with types.ErrKeyNotFound.
+func ZRank(ctx context.Context, eng types.Engine, key, member string, reverse bool) (int, error) {
+	items, err := read(ctx, eng, key, zsets)
+	if err != nil {
+		return 0, err
+	}
+	i := zindex(items, member)
+	if i < 0 {
+		return 0, fmt.Errorf("%w: %s is not in %s", types.ErrKeyNotFound, member, key)
+	}
+	if reverse {
+		i = len(items) - 1 - i
+	}
+	return i, nil
+}
+
+// RangeOptions pages a ZRangeByScore.
+type RangeOptions struct {
+	Offset  int  // members to skip
+	Limit   int  // most members to return; 0 or less returns all
+	Reverse bool // highest scores first, from high down to low
+}
+
+// ZRangeByScore returns the members of the sorted set at key with scores
+// between low and high, inclusive, lowest first unless opts.Reverse is
+// set. Infinite bounds leave a side open.
+func ZRangeByScore(ctx context.Context, eng types.Engine, key string, low, high float64, opts RangeOptions) ([]ZMember, error) {
+	items, err := read(ctx, eng, key, zsets)
+	if err != nil {
+		return nil, err
+	}
+	lo, _ := slices.BinarySearchFunc(items, low, func(m ZMember, s float64) int {
+		return cmp.Compare(m.Score, s)
+	})
+	hi, _ := slices.BinarySearchFunc(items, high, func(m ZMember, s float64) int {
+		if m.Score <= s {
+			return -1
+		}
+		return 1
+	})
+	hits := []ZMember{}
+	if lo < hi {
+		hits = slices.Clone(items[lo:hi])
+	}
+	if opts.Reverse {
+		slices.Reverse(hits)
+	}
+	hits = hits[min(max(opts.Offset, 0), len(hits)):]
+	if opts.Limit > 0 && opts.Limit < len(hits) {
+		hits = hits[:opts.Limit]
+	}
+	return hits, nil
+}
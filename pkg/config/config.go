@@ -1,35 +1,466 @@
 package config
 
-import "github.com/thirawat27/kvi/pkg/types"
+import (
+	"fmt"
+	"io"
+	"log/slog"
+
+	"github.com/thirawat27/kvi/pkg/codec"
+	"github.com/thirawat27/kvi/pkg/keygen"
+	"github.com/thirawat27/kvi/pkg/types"
+	"go.opentelemetry.io/otel/trace"
+)
 
 type Config struct {
-	Mode          types.Mode `json:"mode"`
-	DataDir       string     `json:"data_dir"`
-	MaxMemoryMB   int        `json:"max_memory_mb"`
-	CacheSizeMB   int        `json:"cache_size_mb"`
-	MemtableSpace int        `json:"memtable_size_mb"`
-	EnableWAL     bool       `json:"enable_wal"`
-	EnablePubSub  bool       `json:"enable_pubsub"`
-	Port          int        `json:"port"`
-	GrpcPort      int        `json:"grpc_port"`
-	VectorDim     int        `json:"vector_dim"`
+	Mode         types.Mode `json:"mode"`
+	DataDir      string     `json:"data_dir"`
+	EnableWAL    bool       `json:"enable_wal"`
+	EnablePubSub bool       `json:"enable_pubsub"` // false leaves out the pub/sub routes and gRPC Stream
+	Port         int        `json:"port"`
+	GrpcPort     int        `json:"grpc_port"`
+	RESPPort     int        `json:"resp_port"` // Redis-protocol listener; 0 leaves it off
+	VectorDim    int        `json:"vector_dim"`
+
+	// MaxMemoryMB bounds the memory the hybrid engine holds, as the
+	// memory_used stat estimates it (0 is unbounded): its memory tier
+	// evicts records to stay under it. Other modes report the estimate
+	// but have nothing to evict. CacheSizeMB bounds the hybrid engine's
+	// memory tier (0 is unbounded); records past it are evicted, least
+	// recently used first, and read back from disk.
+	MaxMemoryMB int `json:"max_memory_mb"`
+	CacheSizeMB int `json:"cache_size_mb"`
+	// VectorIndexMaxMemoryMB bounds the vector index of vector and hybrid
+	// mode (0 is unbounded). Writes that would index a vector past it
+	// fail with types.ErrMemoryLimit.
+	VectorIndexMaxMemoryMB int `json:"vector_index_max_memory_mb"`
+	// VectorFilterFields are the data fields vector and hybrid mode keep
+	// posting lists for, so that searches filtered on them only look at
+	// the records that match. Filters on other fields are checked on the
+	// results instead. /api/v1/admin/vector-filters changes them at
+	// runtime.
+	VectorFilterFields []string `json:"vector_filter_fields"`
+	// VectorIndexLoad is when hybrid mode indexes the vectors of the
+	// records it recovers: before opening returns (VectorLoadEager), on a
+	// goroutine once it has (VectorLoadBackground), or once the first
+	// search asks (VectorLoadLazy). Searches fail with
+	// types.ErrIndexLoading until it is done. VectorIndexReadiness says
+	// whether readiness waits for it (ReadyWaitForAll) or only for the
+	// records (ReadyForKV).
+	VectorIndexLoad      string `json:"vector_index_load"`
+	VectorIndexReadiness string `json:"vector_index_readiness"`
+	// RecordCompressMinBytes compresses, with zstd, the data of each record
+	// the memory engine or the hybrid memory tier holds, and each record
+	// the WAL logs, that serializes to at least this many bytes (0 = none).
+	RecordCompressMinBytes int `json:"record_compress_min_bytes"`
+	// Codec serializes records in the WAL, in backups and compressed in
+	// memory: codec.NameJSON, or codec.NameMsgPack, which keeps integers
+	// exact. A WAL written with one does not open with the other.
+	Codec string `json:"codec"`
+	// KeyGenerator makes up the keys of records written with an empty or
+	// "auto" key: ulid, uuidv7 or sequence (see package keygen).
+	KeyGenerator string `json:"key_generator"`
+
+	// The hybrid engine's queue of writes to its disk and columnar tiers:
+	// how many it holds, what a write does when it is full (QueueBlock,
+	// QueueFail or QueueSpill), and how long Close waits for it to drain.
+	AsyncQueueSize      int    `json:"async_queue_size"`
+	AsyncQueueFull      string `json:"async_queue_full"`
+	AsyncDrainTimeoutMs int    `json:"async_drain_timeout_ms"`
+
+	// AsyncIndexing acknowledges a write once it is stored, and logged,
+	// and queues the updates to the tag and text indexes, and to vector
+	// mode's HNSW graph, for AsyncIndexWorkers goroutines to apply. A
+	// writer that finds AsyncIndexQueue updates waiting applies them
+	// itself. Searches may miss a write until its update is applied;
+	// reads at read-your-writes consistency wait for it.
+	AsyncIndexing     bool `json:"async_indexing"`
+	AsyncIndexWorkers int  `json:"async_index_workers"`
+	AsyncIndexQueue   int  `json:"async_index_queue"`
+
+	// Recovery from the WAL when a disk engine opens: RecoveryParallelism
+	// goroutines decode entries (they are applied in log order all the
+	// same), and RecoveryStartup says whether opening waits for it
+	// (RecoveryBlock) or returns at once, answering requests with
+	// types.ErrRecovering until it is done (RecoveryBackground) or
+	// serving reads from the records recovered so far (RecoveryServeReads).
+	RecoveryParallelism int    `json:"recovery_parallelism"`
+	RecoveryStartup     string `json:"recovery_startup"`
+	// VerifyOnStart checks the engine's integrity once it has opened, as
+	// kvi verify does, repairing its indexes if need be; opening fails
+	// if anything else is wrong.
+	VerifyOnStart bool `json:"verify_on_start"`
+
+	// GroupCommit makes each disk mode write wait until it is synced to
+	// the WAL, sharing one write and sync with the writers waiting at the
+	// same time instead of syncing every 1000 entries inline. A group
+	// gathers writers for up to SyncIntervalMs (0 = only while the last
+	// sync runs).
+	GroupCommit    bool `json:"group_commit"`
+	SyncIntervalMs int  `json:"sync_interval_ms"`
+
+	// GCIntervalMs is how often expired records are removed, as the gc
+	// maintenance task does (0 = only when that task runs).
+	GCIntervalMs int `json:"gc_interval_ms"`
+	// WorkerStallIntervals is how many of its intervals a background
+	// worker may go without finishing a cycle before it is reported
+	// stalled: logged, and failing readiness (0 = never).
+	WorkerStallIntervals int `json:"worker_stall_intervals"`
+	// PublishExpired publishes each record removed because it expired to
+	// the pub/sub channel __expired__.
+	PublishExpired bool `json:"publish_expired"`
+	// CollectionStatsTTLMs is how long per-collection statistics are
+	// cached, as computing them walks every key (0 = not cached).
+	CollectionStatsTTLMs int `json:"collection_stats_ttl_ms"`
+	// ChangesMaxTombstones caps the deleted keys the change index
+	// remembers for ScanSince, dropping the oldest first (0 = unlimited).
+	ChangesMaxTombstones int `json:"changes_max_tombstones"`
+
+	// Authentication (enabled with --auth). APIKeys maps API keys to roles
+	// (read | write | admin) and are exchanged for tokens at /api/v1/auth.
+	// Like every setting, both can come from the environment (see FromEnv),
+	// which keeps them out of config files.
+	JWTSecret        string            `json:"jwt_secret"`
+	JWTExpiryMinutes int               `json:"jwt_expiry_minutes"`
+	APIKeys          map[string]string `json:"api_keys"`
+
+	// Per-client request limits (requests per second, 0 = unlimited), applied
+	// separately to read and write endpoints.
+	ReadRateLimit  float64 `json:"read_rate_limit"`
+	ReadBurst      int     `json:"read_burst"`
+	WriteRateLimit float64 `json:"write_rate_limit"`
+	WriteBurst     int     `json:"write_burst"`
+
+	// Logging, of requests and of everything else the server does: level
+	// is debug | info | warn | error and format json | text. Requests slower
+	// than SlowRequestMs log at warn; LogFailedBodies adds the request body
+	// of failed requests to their log line.
+	LogLevel        string `json:"log_level"`
+	LogFormat       string `json:"log_format"`
+	SlowRequestMs   int    `json:"slow_request_ms"`
+	LogFailedBodies bool   `json:"log_failed_bodies"`
+
+	// /health/ready fails when DataDir has less free space than this.
+	MinFreeDiskMB int `json:"min_free_disk_mb"`
+	// The disk and hybrid engines check DataDir's free space every
+	// DiskCheckIntervalMs (0 = never) and fence writes, failing them with
+	// ErrDiskFull, while it is under DiskFenceMB (0 = never).
+	DiskFenceMB         int `json:"disk_fence_mb"`
+	DiskCheckIntervalMs int `json:"disk_check_interval_ms"`
+
+	CORS CORSConfig `json:"cors"`
+
+	// Request body limits in bytes; MaxImportBytes applies to restores.
+	MaxRequestBytes int64 `json:"max_request_bytes"`
+	MaxImportBytes  int64 `json:"max_import_bytes"`
+
+	// Response compression: gzip level 1-9 (0 disables) for responses of at
+	// least CompressMinBytes, negotiated with Accept-Encoding (gzip, zstd).
+	CompressionLevel int `json:"compression_level"`
+	CompressMinBytes int `json:"compress_min_bytes"`
+
+	// Connection limits, 0 = unlimited. MaxConnections caps open HTTP
+	// connections plus gRPC calls in flight; MaxStreams caps subscriptions
+	// (SSE, gRPC Watch and Stream), which are counted apart so they cannot
+	// crowd out ordinary requests. Beyond either, clients get 503 or
+	// ResourceExhausted.
+	MaxConnections int `json:"max_connections"`
+	MaxStreams     int `json:"max_streams"`
+
+	// Handler timeouts in milliseconds (0 = none): reads (get, scan), writes
+	// (put, delete, patch) and SQL queries. Expiry answers 504.
+	ReadTimeoutMs  int `json:"read_timeout_ms"`
+	WriteTimeoutMs int `json:"write_timeout_ms"`
+	QueryTimeoutMs int `json:"query_timeout_ms"`
+
+	// QueryCacheTTLMs caches the results of SQL SELECTs for up to this
+	// long, dropping each as soon as a write changes what it read (0 = no
+	// cache). The cache holds QueryCacheEntries results, least recently
+	// used out first.
+	QueryCacheTTLMs   int `json:"query_cache_ttl_ms"`
+	QueryCacheEntries int `json:"query_cache_entries"`
+	// LiveQueryDebounceMs is how long a live SQL query waits after a write
+	// to what it reads before running again; writes meanwhile share the
+	// run. A client may hold LiveQueryMaxPerClient live queries at once
+	// (0 = no limit).
+	LiveQueryDebounceMs   int `json:"live_query_debounce_ms"`
+	LiveQueryMaxPerClient int `json:"live_query_max_per_client"`
+
+	// GrpcMaxBatch caps the keys in one gRPC BatchGet or BatchDelete
+	// message (0 = no limit); the streaming variants take any number of
+	// such messages.
+	GrpcMaxBatch int `json:"grpc_max_batch"`
+	// GrpcMaxScanRows caps the records one gRPC Scan call returns before
+	// it ends with a resume token (0 = no cap).
+	GrpcMaxScanRows int `json:"grpc_max_scan_rows"`
+
+	// The server applies the retention rules set through
+	// /api/v1/admin/retention every RetentionIntervalMs (0 = only when
+	// asked to), deleting RetentionBatchSize records between checks that
+	// it should go on.
+	RetentionIntervalMs int `json:"retention_interval_ms"`
+	RetentionBatchSize  int `json:"retention_batch_size"`
+
+	// ReplicaOf makes the server a read-only follower of the primary whose
+	// gRPC API is at this address, e.g. "primary:50051"; writes are
+	// refused until it is promoted. A primary running with --auth needs
+	// ReplicaAPIKey, an admin key, exchanged for tokens at ReplicaHTTP,
+	// the primary's REST root.
+	ReplicaOf     string `json:"replica_of"`
+	ReplicaHTTP   string `json:"replica_http"`
+	ReplicaAPIKey string `json:"replica_api_key"`
+
+	CDC       CDCConfig       `json:"cdc"`
+	Backup    BackupConfig    `json:"backup"`
+	TextIndex TextIndexConfig `json:"text_index"`
+	Shadow    ShadowConfig    `json:"shadow"`
+	Mirror    MirrorConfig    `json:"mirror"`
+
+	// Logger receives the engine's logs; nil means slog.Default(). Set it
+	// before kvi.Open to send them to a handler of your own. It is not part
+	// of the file format.
+	Logger *slog.Logger `json:"-"`
+
+	// TracerProvider receives spans for engine reads, writes, scans and
+	// searches, WAL flushes and column sums; nil, the default, disables
+	// tracing. Like Logger it is set in code, by embedders wiring their
+	// own exporter, and not part of the file format.
+	TracerProvider trace.TracerProvider `json:"-"`
+
+	// ReadOnly opens DataDir as it is and never changes it: its WAL must
+	// already exist, is replayed without truncating a torn tail, and every
+	// write is refused. kvi.OpenReadOnly sets it, for the shadow source.
+	ReadOnly bool `json:"-"`
+}
+
+// What a hybrid write does when the async queue is full.
+const (
+	QueueBlock = "block" // wait for room, for as long as the request may
+	QueueFail  = "fail"  // fail at once with types.ErrQueueFull
+	QueueSpill = "spill" // queue it beyond the limit; the WAL has it meanwhile
+)
+
+// When a disk engine is usable while it recovers from its WAL.
+const (
+	RecoveryBlock      = "block"       // once recovered: opening waits
+	RecoveryBackground = "background"  // once recovered, failing requests until then
+	RecoveryServeReads = "serve_reads" // at once for reads, once recovered for writes
+)
+
+// When hybrid mode indexes the vectors it recovers.
+const (
+	VectorLoadEager      = "eager"      // before opening returns
+	VectorLoadBackground = "background" // on a goroutine, as soon as it opens
+	VectorLoadLazy       = "lazy"       // on a goroutine, from the first search
+)
+
+// What readiness makes of a vector index still loading.
+const (
+	ReadyForKV      = "ready_for_kv" // ready once the records are served
+	ReadyWaitForAll = "wait_for_all" // not ready until the index is loaded
+)
+
+// CORSConfig is the cross-origin policy of the REST API. Origins are matched
+// exactly, "*" matches any origin and "https://*.example.com" any subdomain.
+type CORSConfig struct {
+	Disabled         bool     `json:"disabled"` // send no CORS headers at all
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods"`
+	AllowedHeaders   []string `json:"allowed_headers"` // "*" reflects whatever the browser asks for
+	ExposedHeaders   []string `json:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAge           int      `json:"max_age"` // seconds browsers may cache a preflight
+}
+
+// CDCConfig pushes every change to webhooks (see package cdc), which needs
+// the WAL of disk or hybrid mode. Each URL in Webhooks gets batches of up
+// to BatchSize changes, held up to BatchWaitMs for more, signed with
+// WebhookSecret when it is set. Changes wait in an outbox under DataDir,
+// up to MaxOutboxMB, for sinks that are slow or down.
+type CDCConfig struct {
+	Webhooks      []string `json:"webhooks"`
+	WebhookSecret string   `json:"webhook_secret"`
+	BatchSize     int      `json:"batch_size"`
+	BatchWaitMs   int      `json:"batch_wait_ms"`
+	MaxOutboxMB   int      `json:"max_outbox_mb"`
+}
+
+// BackupConfig is where backups go. SnapshotDest is where the snapshot job
+// writes, a directory or an s3://bucket/prefix URL; empty means
+// DataDir/snapshots. S3 is the object store s3:// URLs name, for snapshots
+// and for the backup and restore commands alike.
+type BackupConfig struct {
+	SnapshotDest string   `json:"snapshot_dest"`
+	S3           S3Config `json:"s3"`
+}
+
+// S3Config reaches an S3-compatible object store. Endpoint is its URL;
+// empty means AWS in Region. PathStyle puts the bucket in the URL path
+// rather than the host name, as MinIO and most other stores want. Uploads
+// go in parts of PartSizeMB (at least 5), each tried up to Retries more
+// times.
+type S3Config struct {
+	Endpoint        string `json:"endpoint"`
+	Region          string `json:"region"`
+	AccessKeyID     string `json:"access_key_id"`
+	SecretAccessKey string `json:"secret_access_key"`
+	SessionToken    string `json:"session_token"`
+	PathStyle       bool   `json:"path_style"`
+	PartSizeMB      int    `json:"part_size_mb"`
+	Retries         int    `json:"retries"`
+}
+
+// TextIndexConfig names the Data fields with a full-text index, built
+// when the engine opens. Stopwords are left out of every index; nil means
+// a short English list, and an empty list keeps every word.
+type TextIndexConfig struct {
+	Fields    []string `json:"fields"`
+	Stopwords []string `json:"stopwords"`
+}
+
+// ShadowConfig compares the server's reads against a read-only shadow
+// source while migrating to it (see package shadow): either the data
+// directory DataDir, opened read-only in Mode (empty means the server's
+// mode), or the kvi server whose REST root is URL, reached with APIKey
+// if it runs with --auth. Setting neither turns comparing off. At most
+// RatePerSec records a second are read from the shadow, with up to Queue
+// comparisons waiting and each read given TimeoutMs; the latest Samples
+// mismatches are kept for GET /api/v1/admin/shadow.
+type ShadowConfig struct {
+	DataDir    string     `json:"data_dir"`
+	Mode       types.Mode `json:"mode"`
+	URL        string     `json:"url"`
+	APIKey     string     `json:"api_key"`
+	RatePerSec int        `json:"rate_per_sec"`
+	Queue      int        `json:"queue"`
+	Samples    int        `json:"samples"`
+	TimeoutMs  int        `json:"timeout_ms"`
+}
+
+// Enabled reports whether reads are compared against a shadow.
+func (s ShadowConfig) Enabled() bool { return s.DataDir != "" || s.URL != "" }
+
+// MirrorConfig copies a sample of the REST requests the server answers to
+// a second server, such as a canary of a new release, to load it with real
+// traffic: SamplePercent percent of those whose method is in Methods go to
+// the server whose REST root is Target, after the response and without
+// waiting for it. At most MaxInFlight are sent at a time, each given
+// TimeoutMs; requests beyond that are dropped. Setting no Target turns
+// mirroring off. The whole section can be reloaded, and changed through
+// PUT /api/v1/admin/mirror.
+type MirrorConfig struct {
+	Target        string   `json:"target"`
+	SamplePercent float64  `json:"sample_percent"`
+	Methods       []string `json:"methods"`
+	MaxInFlight   int      `json:"max_in_flight"`
+	TimeoutMs     int      `json:"timeout_ms"`
+	// ForwardAuth sends the callers' credentials (Authorization, cookies
+	// and API key headers) on to Target, which only a canary trusted like
+	// this server should get. By default they are removed.
+	ForwardAuth bool `json:"forward_auth"`
+}
+
+// Enabled reports whether requests are mirrored.
+func (m MirrorConfig) Enabled() bool { return m.Target != "" }
+
+// DefaultMirror mirrors nothing until a target is set, then a tenth of
+// the reads.
+func DefaultMirror() MirrorConfig {
+	return MirrorConfig{SamplePercent: 10, Methods: []string{"GET"}, MaxInFlight: 16, TimeoutMs: 2000}
+}
+
+// DefaultShadow compares nothing until a source is set.
+func DefaultShadow() ShadowConfig {
+	return ShadowConfig{RatePerSec: 100, Queue: 1000, Samples: 100, TimeoutMs: 5000}
+}
+
+// DefaultBackup writes snapshots under the data directory.
+func DefaultBackup() BackupConfig {
+	return BackupConfig{S3: S3Config{Region: "us-east-1", PartSizeMB: 16, Retries: 4}}
+}
+
+// DefaultCDC pushes nowhere until webhooks are set.
+func DefaultCDC() CDCConfig {
+	return CDCConfig{BatchSize: 100, BatchWaitMs: 200, MaxOutboxMB: 1024}
+}
+
+// DefaultCORS allows any origin without credentials, as earlier releases did.
+func DefaultCORS() CORSConfig {
+	return CORSConfig{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "If-Match", "If-None-Match", "X-Request-ID"},
+		ExposedHeaders: []string{"ETag", "X-Request-ID", "Retry-After", "X-Kvi-Checksum", "X-Kvi-Records"},
+		MaxAge:         600,
+	}
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		Mode:          types.ModeHybrid,
-		DataDir:       "./data",
-		MaxMemoryMB:   2048,
-		CacheSizeMB:   256,
-		MemtableSpace: 64,
-		EnableWAL:     true,
-		EnablePubSub:  true,
-		Port:          8080,
-		GrpcPort:      50051,
-		VectorDim:     384,
+		Mode:         types.ModeHybrid,
+		DataDir:      "./data",
+		MaxMemoryMB:  2048,
+		CacheSizeMB:  256,
+		EnableWAL:    true,
+		EnablePubSub: true,
+		Port:         8080,
+		GrpcPort:     50051,
+		VectorDim:    384,
+
+		AsyncQueueSize:      1000,
+		AsyncQueueFull:      QueueBlock,
+		AsyncDrainTimeoutMs: 10000,
+		AsyncIndexWorkers:   4,
+		AsyncIndexQueue:     10000,
+		RecoveryParallelism: 1,
+		RecoveryStartup:     RecoveryBlock,
+		GCIntervalMs:        60000,
+
+		WorkerStallIntervals: 3,
+		DiskCheckIntervalMs:  5000,
+		CollectionStatsTTLMs: 30000,
+		ChangesMaxTombstones: 100000,
+		Codec:                codec.NameJSON,
+		KeyGenerator:         keygen.NameULID,
+
+		JWTExpiryMinutes:  60,
+		LogLevel:          "info",
+		LogFormat:         "json",
+		SlowRequestMs:     1000,
+		MinFreeDiskMB:     64,
+		DiskFenceMB:       32,
+		CORS:              DefaultCORS(),
+		MaxRequestBytes:   4 << 20,
+		MaxImportBytes:    1 << 30,
+		CompressionLevel:  5,
+		CompressMinBytes:  1024,
+		ReadTimeoutMs:     10000,
+		WriteTimeoutMs:    10000,
+		QueryTimeoutMs:    30000,
+		QueryCacheEntries: 1000,
+		GrpcMaxBatch:      1000,
+		GrpcMaxScanRows:   10000,
+		CDC:               DefaultCDC(),
+		Backup:            DefaultBackup(),
+		Shadow:            DefaultShadow(),
+		Mirror:            DefaultMirror(),
+
+		LiveQueryDebounceMs:   100,
+		LiveQueryMaxPerClient: 10,
+
+		RetentionIntervalMs: 60000,
+		RetentionBatchSize:  1000,
+
+		VectorIndexLoad:      VectorLoadEager,
+		VectorIndexReadiness: ReadyWaitForAll,
 	}
 }
 
+// HybridConfig is DefaultConfig under the name of its mode, so every mode
+// has a preset.
+func HybridConfig() *Config {
+	return DefaultConfig()
+}
+
 func MemoryConfig() *Config {
 	cfg := DefaultConfig()
 	cfg.Mode = types.ModeMemory
@@ -47,6 +478,7 @@ func DiskConfig() *Config {
 func ColumnarConfig() *Config {
 	cfg := DefaultConfig()
 	cfg.Mode = types.ModeColumnar
+	cfg.EnableWAL = false
 	return cfg
 }
 
@@ -54,5 +486,33 @@ func VectorConfig(dim int) *Config {
 	cfg := DefaultConfig()
 	cfg.Mode = types.ModeVector
 	cfg.VectorDim = dim
+	cfg.EnableWAL = false
 	return cfg
 }
+
+// NewLogger builds a logger writing to w in LogFormat, at a level set from
+// LogLevel. Pass level to change it later; it may be nil.
+func (c *Config) NewLogger(w io.Writer, level *slog.LevelVar) (*slog.Logger, error) {
+	if level == nil {
+		level = new(slog.LevelVar)
+	}
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		return nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch c.LogFormat {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q (want json or text)", c.LogFormat)
+}
+
+// Log returns Logger, or slog.Default() if there is none.
+func (c *Config) Log() *slog.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return slog.Default()
+}
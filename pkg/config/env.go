@@ -0,0 +1,290 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the name of every configuration environment variable.
+const EnvPrefix = "KVI_"
+
+// envAliases are further names for some variables, keyed by config key.
+// The canonical name wins when both are set.
+var envAliases = map[string]string{
+	"vector_dim": "KVI_VECTOR_DIMENSIONS",
+	// The names the AWS tools use
+	"backup.s3.region":            "AWS_REGION",
+	"backup.s3.access_key_id":     "AWS_ACCESS_KEY_ID",
+	"backup.s3.secret_access_key": "AWS_SECRET_ACCESS_KEY",
+	"backup.s3.session_token":     "AWS_SESSION_TOKEN",
+}
+
+// field is one setting: its config file key path and its value in a Config.
+type field struct {
+	path  []string // json keys, outermost first
+	value reflect.Value
+}
+
+// key is the dotted config key, e.g. "cors.allowed_origins".
+func (f field) key() string { return strings.Join(f.path, ".") }
+
+// envName is the variable that sets f: KVI_ and its key path in upper case,
+// e.g. KVI_DATA_DIR or KVI_CORS_ALLOWED_ORIGINS.
+func (f field) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.Join(f.path, "_"))
+}
+
+// fields lists every setting of c in declaration order, descending into
+// nested structs.
+func fields(c *Config) []field {
+	var out []field
+	var walk func(v reflect.Value, path []string)
+	walk = func(v reflect.Value, path []string) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			name := jsonName(t.Field(i))
+			if name == "" {
+				continue
+			}
+			p := append(path[:len(path):len(path)], name)
+			if fv := v.Field(i); fv.Kind() == reflect.Struct {
+				walk(fv, p)
+			} else {
+				out = append(out, field{path: p, value: fv})
+			}
+		}
+	}
+	walk(reflect.ValueOf(c).Elem(), nil)
+	return out
+}
+
+// jsonName is the config key of a Config field, or "" if it has none.
+func jsonName(sf reflect.StructField) string {
+	name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// EnvVars returns the names of the variables FromEnv reads, in the order of
+// the config file's keys.
+func EnvVars() []string {
+	var names []string
+	for _, f := range fields(DefaultConfig()) {
+		names = append(names, f.envName())
+		if alias, ok := envAliases[f.key()]; ok {
+			names = append(names, alias)
+		}
+	}
+	return names
+}
+
+// FromEnv overrides c with the KVI_* environment variables that are set.
+// Every config file key has one: KVI_ followed by the key in upper case,
+// nested keys joined by underscores. Booleans take strconv.ParseBool's
+// forms; lists are comma-separated; maps (api_keys) are comma-separated
+// key=value pairs. It is applied over the defaults and the config file and
+// under command line flags. Every unparsable variable is reported, and c
+// is left unchanged if there are any.
+func FromEnv(c *Config) error {
+	type setting struct {
+		f   field
+		raw string
+	}
+	var set []setting
+	var errs []error
+	for _, f := range fields(c) {
+		name := f.envName()
+		raw, ok := os.LookupEnv(name)
+		if !ok {
+			if alias, has := envAliases[f.key()]; has {
+				name = alias
+				raw, ok = os.LookupEnv(alias)
+			}
+		}
+		if !ok {
+			continue
+		}
+		if err := parseEnv(reflect.New(f.value.Type()).Elem(), raw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		set = append(set, setting{f, raw})
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	for _, s := range set {
+		parseEnv(s.f.value, s.raw)
+	}
+	return nil
+}
+
+// parseEnv sets v from the text of an environment variable.
+func parseEnv(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean (true, false, 1, 0)", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		list := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		v.Set(reflect.ValueOf(list))
+	case reflect.Map:
+		m := map[string]string{}
+		for _, pair := range strings.Split(raw, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			k, val, ok := strings.Cut(pair, "=")
+			if !ok || strings.TrimSpace(k) == "" {
+				return fmt.Errorf("%q is not a list of key=value pairs", raw)
+			}
+			m[strings.TrimSpace(k)] = strings.TrimSpace(val)
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+// secretKeys are settings Dump never shows.
+var secretKeys = map[string]bool{
+	"jwt_secret": true, "api_keys": true, "replica_api_key": true, "cdc.webhook_secret": true,
+	"backup.s3.secret_access_key": true, "backup.s3.session_token": true, "shadow.api_key": true,
+}
+
+// Dump writes c as YAML in the config file's format, one key per line with
+// its environment variable alongside. Secrets are redacted: jwt_secret,
+// replica_api_key, cdc.webhook_secret, shadow.api_key and the S3
+// credentials show only whether they are set, and api_keys only the roles
+// it grants.
+func (c *Config) Dump(w io.Writer) error {
+	var prev []string
+	for _, f := range fields(c) {
+		// Open the nested sections this key is in, once each
+		depth := len(f.path) - 1
+		for i := 0; i < depth; i++ {
+			if i >= len(prev)-1 || prev[i] != f.path[i] {
+				if _, err := fmt.Fprintf(w, "%s%s:\n", strings.Repeat("  ", i), f.path[i]); err != nil {
+					return err
+				}
+			}
+		}
+		prev = f.path
+
+		var text bytes.Buffer
+		enc := json.NewEncoder(&text)
+		enc.SetEscapeHTML(false)
+		if err := enc.Encode(shown(f)); err != nil {
+			return err
+		}
+		line := fmt.Sprintf("%s%s: %s", strings.Repeat("  ", depth), f.path[depth], bytes.TrimSpace(text.Bytes()))
+		if _, err := fmt.Fprintf(w, "%-56s # %s\n", line, f.envName()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Redacted returns c as nested maps keyed like the config file, with
+// secrets redacted as Dump shows them, for reporting it as JSON.
+func (c *Config) Redacted() map[string]interface{} {
+	out := map[string]interface{}{}
+	for _, f := range fields(c) {
+		m := out
+		for _, name := range f.path[:len(f.path)-1] {
+			section, ok := m[name].(map[string]interface{})
+			if !ok {
+				section = map[string]interface{}{}
+				m[name] = section
+			}
+			m = section
+		}
+		m[f.path[len(f.path)-1]] = shown(f)
+	}
+	return out
+}
+
+// LogValue logs c as Dump shows it, with nested sections as groups, so a
+// logged Config never reveals a secret.
+func (c *Config) LogValue() slog.Value {
+	return logValue(reflect.ValueOf(c).Elem(), "")
+}
+
+func logValue(v reflect.Value, prefix string) slog.Value {
+	var attrs []slog.Attr
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := jsonName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		switch fv := v.Field(i); {
+		case fv.Kind() == reflect.Struct:
+			attrs = append(attrs, slog.Attr{Key: name, Value: logValue(fv, prefix+name+".")})
+		case secretKeys[prefix+name]:
+			attrs = append(attrs, slog.Any(name, redact(fv)))
+		default:
+			attrs = append(attrs, slog.Any(name, fv.Interface()))
+		}
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// redact describes a secret setting without revealing it.
+func redact(v reflect.Value) interface{} {
+	if v.Len() == 0 {
+		return v.Interface() // nothing to hide
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return "<redacted>"
+	case reflect.Map:
+		roles := map[string]int{}
+		iter := v.MapRange()
+		for iter.Next() {
+			roles[fmt.Sprint(iter.Value().Interface())]++
+		}
+		counts := make([]string, 0, len(roles))
+		for role, n := range roles {
+			counts = append(counts, fmt.Sprintf("%d %s", n, role))
+		}
+		slices.Sort(counts)
+		return fmt.Sprintf("<redacted: %s>", strings.Join(counts, ", "))
+	}
+	return "<redacted>"
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// reloadable are the settings a running server can take from a reloaded
+// config; a key ending in "." covers its whole section. Everything else
+// needs a restart.
+var reloadable = []string{
+	"jwt_secret", "jwt_expiry_minutes", "api_keys",
+	"read_rate_limit", "read_burst", "write_rate_limit", "write_burst",
+	"log_level", "log_failed_bodies",
+	"min_free_disk_mb", "disk_fence_mb",
+	"cors.",
+	"max_request_bytes", "max_import_bytes",
+	"compression_level", "compress_min_bytes",
+	"max_connections", "max_streams",
+	"mirror.",
+}
+
+// Reloadable reports whether a running server can change the setting key
+// without a restart.
+func Reloadable(key string) bool {
+	for _, r := range reloadable {
+		if key == r || strings.HasSuffix(r, ".") && strings.HasPrefix(key, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Change is a setting that differs between two configs. Secrets are
+// redacted as Dump shows them.
+type Change struct {
+	Key string      `json:"key"`
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// ReloadReport describes a reload: the changes applied, and those left out
+// because they need a restart.
+type ReloadReport struct {
+	Applied         []Change `json:"applied"`
+	RestartRequired []Change `json:"restart_required"`
+}
+
+// Reload returns a copy of running with the reloadable settings of next,
+// and a report of every difference. running is not modified.
+func Reload(running, next *Config) (*Config, *ReloadReport) {
+	merged := *running
+	report := &ReloadReport{Applied: []Change{}, RestartRequired: []Change{}}
+	to, from := fields(&merged), fields(next)
+	for i, f := range to {
+		if reflect.DeepEqual(f.value.Interface(), from[i].value.Interface()) {
+			continue
+		}
+		change := Change{Key: f.key(), Old: shown(f), New: shown(from[i])}
+		if !Reloadable(f.key()) {
+			report.RestartRequired = append(report.RestartRequired, change)
+			continue
+		}
+		f.value.Set(from[i].value)
+		report.Applied = append(report.Applied, change)
+	}
+	return &merged, report
+}
+
+// shown is a setting's value as Dump shows it.
+func shown(f field) interface{} {
+	if secretKeys[f.key()] {
+		return redact(f.value)
+	}
+	return f.value.Interface()
+}
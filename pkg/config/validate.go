@@ -0,0 +1,260 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/thirawat27/kvi/pkg/auth"
+	"github.com/thirawat27/kvi/pkg/codec"
+	"github.com/thirawat27/kvi/pkg/keygen"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// Validate reports every setting of c that is invalid on its own or for
+// c.Mode, one error per setting, keyed by its config file key. Settings that
+// are merely unused are not errors; see Warnings.
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(key, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	switch c.Mode {
+	case types.ModeMemory, types.ModeDisk, types.ModeColumnar, types.ModeVector, types.ModeHybrid:
+	default:
+		bad("mode", "unknown mode %q (want memory, disk, columnar, vector or hybrid)", c.Mode)
+	}
+	if c.DataDir == "" && (c.Mode == types.ModeDisk || c.Mode == types.ModeHybrid) {
+		bad("data_dir", "required in %s mode", c.Mode)
+	}
+	if c.VectorDim <= 0 && (c.Mode == types.ModeVector || c.Mode == types.ModeHybrid) {
+		bad("vector_dim", "must be positive in %s mode, got %d", c.Mode, c.VectorDim)
+	}
+	if c.Mode == types.ModeHybrid {
+		if c.AsyncQueueSize == 0 {
+			bad("async_queue_size", "must be positive in hybrid mode")
+		}
+		switch c.AsyncQueueFull {
+		case QueueBlock, QueueFail, QueueSpill:
+		default:
+			bad("async_queue_full", "unknown policy %q (want block, fail or spill)", c.AsyncQueueFull)
+		}
+	}
+
+	if c.AsyncIndexing && (c.AsyncIndexWorkers == 0 || c.AsyncIndexQueue == 0) {
+		bad("async_indexing", "needs positive async_index_workers and async_index_queue")
+	}
+
+	switch c.RecoveryStartup {
+	case "", RecoveryBlock, RecoveryBackground, RecoveryServeReads:
+	default:
+		bad("recovery_startup", "unknown policy %q (want block, background or serve_reads)", c.RecoveryStartup)
+	}
+	switch c.VectorIndexLoad {
+	case "", VectorLoadEager, VectorLoadBackground, VectorLoadLazy:
+	default:
+		bad("vector_index_load", "unknown policy %q (want eager, background or lazy)", c.VectorIndexLoad)
+	}
+	switch c.VectorIndexReadiness {
+	case "", ReadyForKV, ReadyWaitForAll:
+	default:
+		bad("vector_index_readiness", "unknown policy %q (want ready_for_kv or wait_for_all)", c.VectorIndexReadiness)
+	}
+	if c.VerifyOnStart && c.Mode == types.ModeDisk && c.RecoveryStartup != "" && c.RecoveryStartup != RecoveryBlock {
+		bad("verify_on_start", "needs recovery_startup block, as it verifies the recovered records")
+	}
+	if _, err := codec.Lookup(c.Codec); err != nil {
+		bad("codec", "%v", err)
+	}
+	if _, err := keygen.New(c.KeyGenerator, nil); err != nil {
+		bad("key_generator", "%v", err)
+	}
+
+	// No count, size, limit or timeout means anything below zero
+	for _, f := range fields(c) {
+		switch f.value.Kind() {
+		case reflect.Int, reflect.Int64:
+			if n := f.value.Int(); n < 0 {
+				bad(f.key(), "must not be negative, got %d", n)
+			}
+		case reflect.Float64:
+			if n := f.value.Float(); n < 0 {
+				bad(f.key(), "must not be negative, got %g", n)
+			}
+		}
+	}
+	if c.Port > 65535 {
+		bad("port", "%d is not a TCP port (0-65535)", c.Port)
+	}
+	if c.GrpcPort > 65535 {
+		bad("grpc_port", "%d is not a TCP port (0-65535)", c.GrpcPort)
+	}
+	if c.RESPPort > 65535 {
+		bad("resp_port", "%d is not a TCP port (0-65535)", c.RESPPort)
+	}
+	if c.CompressionLevel > 9 {
+		bad("compression_level", "must be 0 (off) to 9, got %d", c.CompressionLevel)
+	}
+	if c.RetentionBatchSize == 0 {
+		bad("retention_batch_size", "must be positive")
+	}
+
+	if c.ReplicaAPIKey != "" && c.ReplicaHTTP == "" {
+		bad("replica_http", "required with replica_api_key, to exchange it for tokens")
+	}
+
+	if len(c.CDC.Webhooks) > 0 {
+		if c.Mode != types.ModeDisk && c.Mode != types.ModeHybrid || !c.EnableWAL {
+			bad("cdc.webhooks", "need the WAL of disk or hybrid mode (enable_wal)")
+		}
+		for _, raw := range c.CDC.Webhooks {
+			if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				bad("cdc.webhooks", "%q is not an http or https URL", raw)
+			}
+		}
+		if c.CDC.BatchSize == 0 {
+			bad("cdc.batch_size", "must be positive")
+		}
+	}
+
+	if rest, ok := strings.CutPrefix(c.Backup.SnapshotDest, "s3://"); ok && strings.Trim(rest, "/") == "" {
+		bad("backup.snapshot_dest", "%q names no bucket", c.Backup.SnapshotDest)
+	}
+	if raw := c.Backup.S3.Endpoint; raw != "" {
+		if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad("backup.s3.endpoint", "%q is not an http or https URL", raw)
+		}
+	}
+	if c.Backup.S3.PartSizeMB < 5 {
+		bad("backup.s3.part_size_mb", "must be at least 5, the smallest part S3 accepts")
+	}
+
+	if sh := c.Shadow; sh.Enabled() {
+		switch {
+		case sh.DataDir != "" && sh.URL != "":
+			bad("shadow.url", "set either it or shadow.data_dir, not both")
+		case sh.DataDir != "" && filepath.Clean(sh.DataDir) == filepath.Clean(c.DataDir):
+			bad("shadow.data_dir", "is the server's own data_dir")
+		case sh.URL != "":
+			if u, err := url.Parse(sh.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				bad("shadow.url", "%q is not an http or https URL", sh.URL)
+			}
+		}
+		switch sh.Mode {
+		case "", types.ModeMemory, types.ModeDisk, types.ModeColumnar, types.ModeVector, types.ModeHybrid:
+		default:
+			bad("shadow.mode", "unknown mode %q (want memory, disk, columnar, vector or hybrid)", sh.Mode)
+		}
+		if sh.RatePerSec <= 0 {
+			bad("shadow.rate_per_sec", "must be positive")
+		}
+	}
+
+	c.Mirror.check(bad)
+
+	if slices.Contains(c.TextIndex.Fields, "") {
+		bad("text_index.fields", "has an empty field name")
+	}
+	if slices.Contains(c.VectorFilterFields, "") {
+		bad("vector_filter_fields", "has an empty field name")
+	}
+
+	if n := len(c.JWTSecret); n > 0 && n < auth.MinSecretBytes {
+		bad("jwt_secret", "must be at least %d bytes, got %d", auth.MinSecretBytes, n)
+	}
+	// Name only the roles: the keys themselves are secret
+	var roles []string
+	for _, role := range c.APIKeys {
+		if _, err := auth.ParseRole(role); err != nil && !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	slices.Sort(roles)
+	for _, role := range roles {
+		_, err := auth.ParseRole(role)
+		bad("api_keys", "%v", err)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		bad("log_level", "unknown level %q (want debug, info, warn or error)", c.LogLevel)
+	}
+	if c.LogFormat != "json" && c.LogFormat != "text" {
+		bad("log_format", "unknown format %q (want json or text)", c.LogFormat)
+	}
+	return errors.Join(errs...)
+}
+
+// Validate reports every setting of m that is invalid, as Config.Validate
+// does for the mirror section, so one can be checked before it is applied
+// to a running server.
+func (m MirrorConfig) Validate() error {
+	var errs []error
+	m.check(func(key, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	})
+	return errors.Join(errs...)
+}
+
+func (m MirrorConfig) check(bad func(key, format string, args ...interface{})) {
+	if m.Enabled() {
+		if u, err := url.Parse(m.Target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad("mirror.target", "%q is not an http or https URL", m.Target)
+		}
+	}
+	if m.SamplePercent < 0 || m.SamplePercent > 100 {
+		bad("mirror.sample_percent", "must be between 0 and 100, got %g", m.SamplePercent)
+	}
+	for _, method := range m.Methods {
+		switch method {
+		case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		default:
+			bad("mirror.methods", "unknown method %q (want GET, HEAD, POST, PUT, PATCH or DELETE)", method)
+		}
+	}
+	if m.MaxInFlight <= 0 {
+		bad("mirror.max_in_flight", "must be positive")
+	}
+	if m.TimeoutMs <= 0 {
+		bad("mirror.timeout_ms", "must be positive")
+	}
+}
+
+// Warnings describes settings of a valid c that have no effect in its mode.
+func (c *Config) Warnings() []string {
+	var warnings []string
+	switch c.Mode {
+	case types.ModeMemory, types.ModeColumnar, types.ModeVector:
+		if c.EnableWAL {
+			warnings = append(warnings, fmt.Sprintf("enable_wal has no effect in %s mode, which keeps no data on disk", c.Mode))
+		}
+	}
+	if (c.RecoveryStartup == RecoveryBackground || c.RecoveryStartup == RecoveryServeReads) && c.Mode != types.ModeDisk {
+		warnings = append(warnings, fmt.Sprintf("recovery_startup has no effect in %s mode; only disk mode recovers in the background", c.Mode))
+	}
+	if (c.VectorIndexLoad == VectorLoadBackground || c.VectorIndexLoad == VectorLoadLazy) && c.Mode != types.ModeHybrid {
+		warnings = append(warnings, fmt.Sprintf("vector_index_load has no effect in %s mode; only hybrid mode loads its vector index from disk", c.Mode))
+	}
+	if c.GroupCommit && (c.Mode != types.ModeDisk || !c.EnableWAL) {
+		warnings = append(warnings, "group_commit has no effect outside disk mode with enable_wal")
+	}
+	if c.VectorIndexMaxMemoryMB > 0 && c.Mode != types.ModeVector && c.Mode != types.ModeHybrid {
+		warnings = append(warnings, fmt.Sprintf("vector_index_max_memory_mb has no effect in %s mode, which has no vector index", c.Mode))
+	}
+	if len(c.VectorFilterFields) > 0 && c.Mode != types.ModeVector && c.Mode != types.ModeHybrid {
+		warnings = append(warnings, fmt.Sprintf("vector_filter_fields has no effect in %s mode, which has no vector index", c.Mode))
+	}
+	if c.RecordCompressMinBytes > 0 && (c.Mode == types.ModeColumnar || c.Mode == types.ModeVector) {
+		warnings = append(warnings, fmt.Sprintf("record_compress_min_bytes has no effect in %s mode", c.Mode))
+	}
+	if c.PublishExpired && !c.EnablePubSub {
+		warnings = append(warnings, "publish_expired has no effect without enable_pubsub")
+	}
+	return warnings
+}
@@ -0,0 +1,112 @@
+package kvi_grpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/thirawat27/kvi/pkg/auth"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// methodRoles mirrors the HTTP route policy. Methods missing from the map
+// require admin so new RPCs are locked down until classified.
+var methodRoles = map[string]auth.Role{
+	KviService_Get_FullMethodName:               auth.RoleRead,
+	KviService_Put_FullMethodName:               auth.RoleWrite,
+	KviService_VectorSearch_FullMethodName:      auth.RoleRead,
+	KviService_Stats_FullMethodName:             auth.RoleRead,
+	KviService_Watch_FullMethodName:             auth.RoleRead,
+	KviService_Scan_FullMethodName:              auth.RoleRead,
+	KviService_Changes_FullMethodName:           auth.RoleRead,
+	KviService_LiveQuery_FullMethodName:         auth.RoleRead,
+	KviService_BatchGet_FullMethodName:          auth.RoleRead,
+	KviService_BatchGetStream_FullMethodName:    auth.RoleRead,
+	KviService_BatchDelete_FullMethodName:       auth.RoleWrite,
+	KviService_BatchDeleteStream_FullMethodName: auth.RoleWrite,
+	KviService_Snapshot_FullMethodName:          auth.RoleAdmin, // as GET /api/v1/backup
+	KviService_Restore_FullMethodName:           auth.RoleAdmin,
+	KviService_Replicate_FullMethodName:         auth.RoleAdmin, // every change, like Snapshot
+	KviService_Stream_FullMethodName:            auth.RoleRead,  // publishing re-checked per message
+	KviService_ZAdd_FullMethodName:              auth.RoleWrite,
+	KviService_ZIncrBy_FullMethodName:           auth.RoleWrite,
+	KviService_ZRem_FullMethodName:              auth.RoleWrite,
+	KviService_ZRangeByScore_FullMethodName:     auth.RoleRead,
+	KviService_ZRank_FullMethodName:             auth.RoleRead,
+	KviService_Lock_FullMethodName:              auth.RoleWrite,
+	KviService_Unlock_FullMethodName:            auth.RoleWrite,
+}
+
+// publicServices answer without a token so probes and tooling keep
+// working: the standard health and reflection services.
+var publicServices = []string{"/grpc.health.v1.Health/", "/grpc.reflection."}
+
+func isPublic(fullMethod string) bool {
+	for _, prefix := range publicServices {
+		if strings.HasPrefix(fullMethod, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func requiredRole(fullMethod string) auth.Role {
+	if role, ok := methodRoles[fullMethod]; ok {
+		return role
+	}
+	return auth.RoleAdmin
+}
+
+// authorize validates the "authorization: Bearer <token>" metadata entry.
+func authorize(ctx context.Context, a *auth.Authenticator, fullMethod string) (context.Context, error) {
+	if isPublic(fullMethod) {
+		return ctx, nil
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+		return nil, status.Error(codes.Unauthenticated, "missing or malformed bearer token")
+	}
+
+	claims, err := a.Authorize(strings.TrimPrefix(values[0], "Bearer "), requiredRole(fullMethod))
+	if errors.Is(err, auth.ErrForbidden) {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	setCallSubject(ctx, claims.Subject)
+	return auth.NewContext(ctx, claims), nil
+}
+
+// UnaryAuthInterceptor enforces the same token policy as the HTTP API.
+func UnaryAuthInterceptor(a *auth.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authorize(ctx, a, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthInterceptor enforces the same token policy as the HTTP API.
+func StreamAuthInterceptor(a *auth.Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authorize(ss.Context(), a, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+type authedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authedStream) Context() context.Context { return s.ctx }
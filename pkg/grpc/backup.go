@@ -0,0 +1,169 @@
+package kvi_grpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// snapshotChunkSize keeps every message well under gRPC's default 4 MiB
+// limit.
+const snapshotChunkSize = 1 << 20
+
+// Snapshot streams a backup as it is produced, so memory use does not grow
+// with the data set. The LSN is taken before the dump starts: the dump
+// has every change up to it, and may have later ones too, which a
+// follower replaying from it applies again.
+func (s *GrpcServer) Snapshot(req *SnapshotRequest, stream KviService_SnapshotServer) error {
+	var lsn uint64
+	if shipper, ok := s.engine.(types.LogShipper); ok {
+		lsn = shipper.LSN()
+	}
+	cw := &chunkWriter{stream: stream, buf: make([]byte, 0, snapshotChunkSize)}
+	sum, err := backup.Dump(stream.Context(), s.engine, cw)
+	if err == nil {
+		err = cw.flush()
+	}
+	if err != nil {
+		return toStatus(err)
+	}
+	return stream.Send(&SnapshotChunk{
+		Index:       cw.index,
+		Last:        true,
+		TotalChunks: cw.index,
+		Checksum:    sum.Checksum,
+		Records:     int64(sum.Records),
+		Lsn:         lsn,
+	})
+}
+
+// chunkWriter cuts what is written to it into SnapshotChunks.
+type chunkWriter struct {
+	stream KviService_SnapshotServer
+	buf    []byte
+	index  uint64
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		take := min(len(p), cap(w.buf)-len(w.buf))
+		w.buf = append(w.buf, p[:take]...)
+		p = p[take:]
+		if len(w.buf) == cap(w.buf) {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if err := w.stream.Send(&SnapshotChunk{Index: w.index, Data: w.buf}); err != nil {
+		return err
+	}
+	w.index++
+	w.buf = make([]byte, 0, snapshotChunkSize) // Send may still hold the old one
+	return nil
+}
+
+// Restore spools the upload to a temporary file, then restores it like
+// POST /api/v1/restore.
+func (s *GrpcServer) Restore(stream KviService_RestoreServer) error {
+	if !s.restoring.TryLock() {
+		return status.Error(codes.Aborted, "a restore is already in progress")
+	}
+	defer s.restoring.Unlock()
+
+	spool, err := os.CreateTemp("", "kvi-restore-*")
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	opts, want, err := receiveChunks(stream, spool)
+	if err != nil {
+		return err
+	}
+	strategy, _ := backup.ParseStrategy(opts.Mode) // checked on arrival
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	checksum, size, err := backup.Checksum(spool)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if want != "" && !strings.EqualFold(want, checksum) {
+		return status.Errorf(codes.DataLoss, "checksum mismatch: got %s, want %s", checksum, want)
+	}
+
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	run := backup.Restore
+	if opts.DryRun {
+		run = backup.DryRun
+	}
+	res, err := run(stream.Context(), s.engine, spool, strategy)
+	if errors.Is(err, backup.ErrInvalid) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return status.Errorf(status.Code(toStatus(err)), "restore stopped after %d records: %s", res.Restored, err)
+	}
+	return stream.SendAndClose(&RestoreResponse{
+		Restored: int64(res.Restored),
+		Removed:  int64(res.Removed),
+		Skipped:  int64(res.Skipped),
+		Expired:  int64(res.Expired),
+		Bytes:    size,
+		Checksum: checksum,
+		DryRun:   opts.DryRun,
+	})
+}
+
+// receiveChunks writes the uploaded chunks to w in order and returns the
+// first chunk, which carries the options, and the expected checksum if any
+// chunk carried one.
+func receiveChunks(stream KviService_RestoreServer, w io.Writer) (first *RestoreChunk, checksum string, err error) {
+	for next := uint64(0); ; next++ {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			if next == 0 {
+				return nil, "", status.Error(codes.InvalidArgument, "empty upload")
+			}
+			return first, checksum, nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if chunk.Index != next {
+			return nil, "", status.Errorf(codes.InvalidArgument, "chunk %d arrived where %d was expected", chunk.Index, next)
+		}
+		if next == 0 {
+			if _, err := backup.ParseStrategy(chunk.Mode); err != nil {
+				return nil, "", status.Error(codes.InvalidArgument, err.Error())
+			}
+			first = chunk
+		}
+		if chunk.Checksum != "" {
+			checksum = chunk.Checksum
+		}
+		if _, err := w.Write(chunk.Data); err != nil {
+			return nil, "", status.Error(codes.Internal, fmt.Sprintf("spooling upload: %v", err))
+		}
+	}
+}
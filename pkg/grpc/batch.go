@@ -0,0 +1,152 @@
+package kvi_grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultMaxBatch is how many keys one BatchGet or BatchDelete message may
+// carry.
+const DefaultMaxBatch = 1000
+
+func (s *GrpcServer) BatchGet(ctx context.Context, req *BatchGetRequest) (*BatchGetResponse, error) {
+	if err := s.checkBatch(req.Keys); err != nil {
+		return nil, err
+	}
+	return s.batchGet(ctx, req.Keys)
+}
+
+func (s *GrpcServer) BatchDelete(ctx context.Context, req *BatchDeleteRequest) (*BatchDeleteResponse, error) {
+	if err := s.checkBatch(req.Keys); err != nil {
+		return nil, err
+	}
+	return s.batchDelete(ctx, req.Keys)
+}
+
+// BatchGetStream answers each message of keys with one response, for
+// batches too large for a single call.
+func (s *GrpcServer) BatchGetStream(stream KviService_BatchGetStreamServer) error {
+	return serveBatches(s, stream.Recv, stream.Send, func(req *BatchGetRequest) (*BatchGetResponse, error) {
+		if err := s.checkBatch(req.Keys); err != nil {
+			return nil, err
+		}
+		return s.batchGet(stream.Context(), req.Keys)
+	})
+}
+
+// BatchDeleteStream is BatchGetStream for deletes.
+func (s *GrpcServer) BatchDeleteStream(stream KviService_BatchDeleteStreamServer) error {
+	return serveBatches(s, stream.Recv, stream.Send, func(req *BatchDeleteRequest) (*BatchDeleteResponse, error) {
+		if err := s.checkBatch(req.Keys); err != nil {
+			return nil, err
+		}
+		return s.batchDelete(stream.Context(), req.Keys)
+	})
+}
+
+// serveBatches answers requests one at a time until the client closes its
+// side of the stream or the server stops.
+func serveBatches[Req, Resp any](s *GrpcServer, recv func() (*Req, error), send func(*Resp) error, handle func(*Req) (*Resp, error)) error {
+	for {
+		req, err := recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case <-s.stopping:
+			return errShuttingDown
+		default:
+		}
+		resp, err := handle(req)
+		if err != nil {
+			return err
+		}
+		if err := send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *GrpcServer) checkBatch(keys []string) error {
+	if s.maxBatch > 0 && len(keys) > s.maxBatch {
+		return status.Errorf(codes.InvalidArgument, "batch of %d keys exceeds the limit of %d; use the streaming call", len(keys), s.maxBatch)
+	}
+	return nil
+}
+
+func (s *GrpcServer) batchGet(ctx context.Context, keys []string) (*BatchGetResponse, error) {
+	found, err := batchGet(ctx, s.engine, keys)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &BatchGetResponse{Records: make(map[string]*GetResponse, len(found))}
+	for _, key := range keys {
+		if rec, ok := found[key]; ok {
+			resp.Records[key] = recordResponse(rec)
+		} else {
+			resp.Missing = append(resp.Missing, key)
+		}
+	}
+	return resp, nil
+}
+
+func (s *GrpcServer) batchDelete(ctx context.Context, keys []string) (*BatchDeleteResponse, error) {
+	deleted, err := batchDelete(ctx, s.engine, keys)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &BatchDeleteResponse{Results: make([]*BatchDeleteResponse_Result, len(keys))}
+	for i, key := range keys {
+		resp.Results[i] = &BatchDeleteResponse_Result{Key: key, Deleted: deleted[key]}
+		delete(deleted, key) // a repeated key was only deleted the first time
+	}
+	return resp, nil
+}
+
+// batchGet uses the engine's BatchGet if it has one, else one Get per key.
+func batchGet(ctx context.Context, eng types.Engine, keys []string) (map[string]*types.Record, error) {
+	if b, ok := eng.(types.Batcher); ok {
+		return b.BatchGet(ctx, keys)
+	}
+	found := make(map[string]*types.Record, len(keys))
+	for _, key := range keys {
+		rec, err := eng.Get(ctx, key)
+		switch {
+		case err == nil:
+			found[key] = rec
+		case !errors.Is(err, types.ErrKeyNotFound):
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// batchDelete uses the engine's BatchDelete if it has one, else a Get and
+// a Delete per key.
+func batchDelete(ctx context.Context, eng types.Engine, keys []string) (map[string]bool, error) {
+	if b, ok := eng.(types.Batcher); ok {
+		return b.BatchDelete(ctx, keys)
+	}
+	deleted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		_, getErr := eng.Get(ctx, key)
+		if getErr != nil && !errors.Is(getErr, types.ErrKeyNotFound) {
+			return nil, getErr
+		}
+		if err := eng.Delete(ctx, key); err != nil {
+			return nil, err
+		}
+		if getErr == nil {
+			deleted[key] = true
+		}
+	}
+	return deleted, nil
+}
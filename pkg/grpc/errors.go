@@ -0,0 +1,61 @@
+package kvi_grpc
+
+import (
+	"context"
+	"errors"
+
+	"github.com/thirawat27/kvi/pkg/collection"
+	"github.com/thirawat27/kvi/pkg/lock"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// errorCodes maps engine errors to the status codes clients retry on.
+// Anything unlisted is Internal.
+var errorCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{types.ErrKeyNotFound, codes.NotFound},
+	{types.ErrInvalidVector, codes.InvalidArgument},
+	{types.ErrInvalidFilter, codes.InvalidArgument},
+	{types.ErrNoTextIndex, codes.InvalidArgument},
+	{collection.ErrInvalidScore, codes.InvalidArgument},
+	{types.ErrVersionMismatch, codes.FailedPrecondition},
+	{types.ErrWrongType, codes.FailedPrecondition},
+	{types.ErrKeyExists, codes.AlreadyExists},
+	{lock.ErrHeld, codes.FailedPrecondition},
+	{lock.ErrNotHeld, codes.FailedPrecondition},
+	{types.ErrReadOnly, codes.FailedPrecondition},
+	{types.ErrQueueFull, codes.ResourceExhausted},
+	{types.ErrMemoryLimit, codes.ResourceExhausted},
+	{types.ErrDiskFull, codes.ResourceExhausted},
+	{types.ErrClosed, codes.Unavailable},
+	{types.ErrRecovering, codes.Unavailable},
+	{types.ErrIndexLoading, codes.Unavailable},
+	{types.ErrConnectionLimit, codes.ResourceExhausted},
+	{types.ErrHistoryUnavailable, codes.OutOfRange},
+	{context.DeadlineExceeded, codes.DeadlineExceeded},
+	{context.Canceled, codes.Canceled},
+}
+
+// serverFault reports whether err is the server's fault rather than the
+// caller's or the call's deadline.
+func serverFault(err error) bool {
+	switch status.Code(err) {
+	case codes.Internal, codes.Unknown, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+// toStatus converts an engine error into a gRPC status error.
+func toStatus(err error) error {
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return status.Error(e.code, err.Error())
+		}
+	}
+	return status.Error(codes.Internal, err.Error())
+}
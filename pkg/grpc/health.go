@@ -0,0 +1,140 @@
+package kvi_grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	healthCheckTimeout  = 2 * time.Second // per engine check, as for REST readiness
+	healthWatchInterval = 5 * time.Second // how often Watch re-runs the checks
+)
+
+// healthServer implements grpc.health.v1.Health for the whole server ("")
+// and for kvi.KviService, both backed by the engine's health checks. It
+// reports NOT_SERVING from the start of shutdown.
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	srv *GrpcServer
+}
+
+var healthServices = []string{"", KviService_ServiceDesc.ServiceName}
+
+func (h *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if !knownHealthService(req.Service) {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.Service)
+	}
+	return &healthpb.HealthCheckResponse{Status: h.status(ctx)}, nil
+}
+
+func (h *healthServer) List(ctx context.Context, req *healthpb.HealthListRequest) (*healthpb.HealthListResponse, error) {
+	st := h.status(ctx)
+	resp := &healthpb.HealthListResponse{Statuses: make(map[string]*healthpb.HealthCheckResponse)}
+	for _, name := range healthServices {
+		resp.Statuses[name] = &healthpb.HealthCheckResponse{Status: st}
+	}
+	return resp, nil
+}
+
+// Watch sends the status now and whenever it changes: on shutdown at once,
+// otherwise as seen by re-running the checks every healthWatchInterval.
+func (h *healthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ctx := stream.Context()
+	if !knownHealthService(req.Service) {
+		if err := stream.Send(&healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVICE_UNKNOWN}); err != nil {
+			return err
+		}
+		select { // the set of services never changes
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-h.srv.stopping:
+			return errShuttingDown
+		}
+	}
+
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+	draining := h.srv.draining
+	last := healthpb.HealthCheckResponse_UNKNOWN
+	for {
+		if st := h.status(ctx); st != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: st}); err != nil {
+				return err
+			}
+			last = st
+		}
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-h.srv.stopping:
+			return errShuttingDown
+		case <-draining:
+			draining = nil // report it once
+		case <-ticker.C:
+		}
+	}
+}
+
+func knownHealthService(name string) bool {
+	for _, known := range healthServices {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
+
+// status is NOT_SERVING once shutdown has begun or if any of the engine's
+// health checks fails.
+func (h *healthServer) status(ctx context.Context) healthpb.HealthCheckResponse_ServingStatus {
+	select {
+	case <-h.srv.draining:
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	default:
+	}
+	if err := checkEngine(ctx, h.srv.engine); err != nil {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	return healthpb.HealthCheckResponse_SERVING
+}
+
+// checkEngine runs the engine's health checks concurrently, each bounded by
+// healthCheckTimeout, and returns the first failure.
+func checkEngine(ctx context.Context, eng types.Engine) error {
+	hc, ok := eng.(types.HealthChecker)
+	if !ok {
+		return nil
+	}
+	checks := hc.HealthChecks()
+	errs := make(chan error, len(checks))
+	var wg sync.WaitGroup
+	for name, fn := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- fn(ctx) }()
+			select {
+			case err := <-done:
+				if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+					errs <- fmt.Errorf("%s: %w", name, err)
+				}
+			case <-ctx.Done():
+				errs <- fmt.Errorf("%s: timed out after %s", name, healthCheckTimeout)
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
@@ -0,0 +1,125 @@
+package kvi_grpc
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/tracing"
+	"github.com/thirawat27/kvi/pkg/auth"
+	"github.com/thirawat27/kvi/pkg/stats"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// Middleware selects the interceptors built by Interceptors. Zero fields
+// leave their interceptor out.
+type Middleware struct {
+	Auth *auth.Authenticator // bearer tokens, as for the HTTP API
+
+	// Logger writes one line per call. Calls at least SlowThreshold long
+	// log at warn; 0 disables the check.
+	Logger        *slog.Logger
+	SlowThreshold time.Duration
+
+	Calls *stats.Calls // per-method counters; pass the same to WithCalls
+
+	// Conns counts calls in flight and Streams subscriptions (Watch and
+	// Stream); calls beyond either limit fail with ResourceExhausted. Pass
+	// the same to WithConnLimits, and to the HTTP API to share the limits.
+	Conns   *stats.Pool
+	Streams *stats.Pool
+
+	// TracerProvider receives a server span per call, continuing a trace
+	// the client passes in traceparent metadata. Give the engine the same
+	// provider for its spans to join.
+	TracerProvider trace.TracerProvider
+}
+
+// Interceptors returns the server options installing m. Tracing, then
+// logging and metrics, run outermost, so they also see calls rejected by
+// the limits or by auth.
+func Interceptors(m Middleware) []grpc.ServerOption {
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+	if t := tracing.New(m.TracerProvider); t != nil {
+		unary = append(unary, traceUnary(t))
+		stream = append(stream, traceStream(t))
+	}
+	if m.Logger != nil || m.Calls != nil {
+		unary = append(unary, m.observeUnary)
+		stream = append(stream, m.observeStream)
+	}
+	if m.Conns != nil || m.Streams != nil {
+		unary = append(unary, m.limitUnary)
+		stream = append(stream, m.limitStream)
+	}
+	if m.Auth != nil {
+		unary = append(unary, UnaryAuthInterceptor(m.Auth))
+		stream = append(stream, StreamAuthInterceptor(m.Auth))
+	}
+	return []grpc.ServerOption{grpc.ChainUnaryInterceptor(unary...), grpc.ChainStreamInterceptor(stream...)}
+}
+
+// callInfo is shared through the call context so the auth interceptor can
+// report the subject back to the logger.
+type callInfo struct {
+	subject string
+}
+
+type callInfoKey struct{}
+
+func setCallSubject(ctx context.Context, subject string) {
+	if info, ok := ctx.Value(callInfoKey{}).(*callInfo); ok {
+		info.subject = subject
+	}
+}
+
+func (m Middleware) observeUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	call := &callInfo{}
+	resp, err := handler(context.WithValue(ctx, callInfoKey{}, call), req)
+	m.observe(ctx, info.FullMethod, call, err, time.Since(start))
+	return resp, err
+}
+
+func (m Middleware) observeStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	call := &callInfo{}
+	ctx := context.WithValue(ss.Context(), callInfoKey{}, call)
+	err := handler(srv, &authedStream{ServerStream: ss, ctx: ctx})
+	m.observe(ss.Context(), info.FullMethod, call, err, time.Since(start))
+	return err
+}
+
+func (m Middleware) observe(ctx context.Context, method string, call *callInfo, err error, elapsed time.Duration) {
+	code := status.Code(err)
+	m.Calls.Record(method, code.String(), elapsed)
+	if m.Logger == nil {
+		return
+	}
+
+	level := slog.LevelInfo
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("code", code.String()),
+		slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		attrs = append(attrs, slog.String("remote", p.Addr.String()))
+	}
+	if call.subject != "" {
+		attrs = append(attrs, slog.String("subject", call.subject))
+	}
+	if m.SlowThreshold > 0 && elapsed >= m.SlowThreshold {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.Bool("slow", true))
+	}
+	if serverFault(err) {
+		level = slog.LevelError
+		attrs = append(attrs, slog.String("error", status.Convert(err).Message()))
+	}
+	m.Logger.LogAttrs(ctx, level, "grpc call", attrs...)
+}
@@ -9,6 +9,7 @@ package kvi_grpc
 import (
 	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
 	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
@@ -66,11 +67,20 @@ func (x *GetRequest) GetKey() string {
 }
 
 type GetResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	DataJson      string                 `protobuf:"bytes,2,opt,name=data_json,json=dataJson,proto3" json:"data_json,omitempty"` // JSON representation for dynamic map
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	Id               string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	DataJson         string                 `protobuf:"bytes,2,opt,name=data_json,json=dataJson,proto3" json:"data_json,omitempty"`                            // JSON representation for dynamic map
+	ExpiresInSeconds int64                  `protobuf:"varint,3,opt,name=expires_in_seconds,json=expiresInSeconds,proto3" json:"expires_in_seconds,omitempty"` // seconds until the record's TTL runs out; 0 if it has none
+	Version          uint64                 `protobuf:"varint,4,opt,name=version,proto3" json:"version,omitempty"`
+	ExpiresAt        *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"` // unset if the record has no TTL
+	CreatedAt        *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt        *timestamppb.Timestamp `protobuf:"bytes,7,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	Vector           []float32              `protobuf:"fixed32,8,rep,packed,name=vector,proto3" json:"vector,omitempty"`                      // empty if the record has none
+	Blob             []byte                 `protobuf:"bytes,9,opt,name=blob,proto3" json:"blob,omitempty"`                                   // opaque binary content, beside data_json
+	ContentType      string                 `protobuf:"bytes,10,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"` // what blob holds, as given on put
+	Tags             []string               `protobuf:"bytes,11,rep,name=tags,proto3" json:"tags,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
 }
 
 func (x *GetResponse) Reset() {
@@ -117,10 +127,81 @@ func (x *GetResponse) GetDataJson() string {
 	return ""
 }
 
+func (x *GetResponse) GetExpiresInSeconds() int64 {
+	if x != nil {
+		return x.ExpiresInSeconds
+	}
+	return 0
+}
+
+func (x *GetResponse) GetVersion() uint64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *GetResponse) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+func (x *GetResponse) GetCreatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *GetResponse) GetUpdatedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return nil
+}
+
+func (x *GetResponse) GetVector() []float32 {
+	if x != nil {
+		return x.Vector
+	}
+	return nil
+}
+
+func (x *GetResponse) GetBlob() []byte {
+	if x != nil {
+		return x.Blob
+	}
+	return nil
+}
+
+func (x *GetResponse) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *GetResponse) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
 type PutRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
-	DataJson      string                 `protobuf:"bytes,2,opt,name=data_json,json=dataJson,proto3" json:"data_json,omitempty"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`                                  // "" or "auto": the server makes one up and returns it
+	DataJson      string                 `protobuf:"bytes,2,opt,name=data_json,json=dataJson,proto3" json:"data_json,omitempty"`        // may be empty when there is a blob
+	TtlSeconds    int64                  `protobuf:"varint,3,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"` // expire this many seconds after the write; <= 0 never expires
+	TtlMs         int64                  `protobuf:"varint,4,opt,name=ttl_ms,json=ttlMs,proto3" json:"ttl_ms,omitempty"`                // like ttl_seconds in milliseconds; takes precedence when > 0
+	Vector        []float32              `protobuf:"fixed32,5,rep,packed,name=vector,proto3" json:"vector,omitempty"`                   // indexed in vector and hybrid modes
+	Blob          []byte                 `protobuf:"bytes,6,opt,name=blob,proto3" json:"blob,omitempty"`
+	ContentType   string                 `protobuf:"bytes,7,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	Tags          []string               `protobuf:"bytes,8,rep,name=tags,proto3" json:"tags,omitempty"`                             // indexed for scans by tag; none may be empty
+	Durable       bool                   `protobuf:"varint,9,opt,name=durable,proto3" json:"durable,omitempty"`                      // answer only once the write is synced to the WAL; UNIMPLEMENTED without one
+	KeyPrefix     string                 `protobuf:"bytes,10,opt,name=key_prefix,json=keyPrefix,proto3" json:"key_prefix,omitempty"` // starts a made-up key; only with an empty or auto key
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -169,9 +250,67 @@ func (x *PutRequest) GetDataJson() string {
 	return ""
 }
 
+func (x *PutRequest) GetTtlSeconds() int64 {
+	if x != nil {
+		return x.TtlSeconds
+	}
+	return 0
+}
+
+func (x *PutRequest) GetTtlMs() int64 {
+	if x != nil {
+		return x.TtlMs
+	}
+	return 0
+}
+
+func (x *PutRequest) GetVector() []float32 {
+	if x != nil {
+		return x.Vector
+	}
+	return nil
+}
+
+func (x *PutRequest) GetBlob() []byte {
+	if x != nil {
+		return x.Blob
+	}
+	return nil
+}
+
+func (x *PutRequest) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *PutRequest) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *PutRequest) GetDurable() bool {
+	if x != nil {
+		return x.Durable
+	}
+	return false
+}
+
+func (x *PutRequest) GetKeyPrefix() string {
+	if x != nil {
+		return x.KeyPrefix
+	}
+	return ""
+}
+
 type PutResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Success       bool                   `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	Version       uint64                 `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"` // the version the write was stored at
+	Key           string                 `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`          // the key the record was stored under
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -213,10 +352,25 @@ func (x *PutResponse) GetSuccess() bool {
 	return false
 }
 
+func (x *PutResponse) GetVersion() uint64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *PutResponse) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
 type VectorSearchRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Vector        []float32              `protobuf:"fixed32,1,rep,packed,name=vector,proto3" json:"vector,omitempty"`
 	K             int32                  `protobuf:"varint,2,opt,name=k,proto3" json:"k,omitempty"`
+	FilterJson    string                 `protobuf:"bytes,3,opt,name=filter_json,json=filterJson,proto3" json:"filter_json,omitempty"` // a JSON object of field values the records' data must hold; empty for none
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -265,6 +419,13 @@ func (x *VectorSearchRequest) GetK() int32 {
 	return 0
 }
 
+func (x *VectorSearchRequest) GetFilterJson() string {
+	if x != nil {
+		return x.FilterJson
+	}
+	return ""
+}
+
 type VectorSearchResponse struct {
 	state         protoimpl.MessageState         `protogen:"open.v1"`
 	Results       []*VectorSearchResponse_Result `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
@@ -314,6 +475,11 @@ type StreamRequest struct {
 	Id             string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`                                               // client id
 	Channel        string                 `protobuf:"bytes,2,opt,name=channel,proto3" json:"channel,omitempty"`                                     // subscribe channel
 	PublishPayload string                 `protobuf:"bytes,3,opt,name=publish_payload,json=publishPayload,proto3" json:"publish_payload,omitempty"` // if sending a message
+	AckToken       string                 `protobuf:"bytes,4,opt,name=ack_token,json=ackToken,proto3" json:"ack_token,omitempty"`                   // acknowledges a delivery from an ack-mode channel
+	Group          string                 `protobuf:"bytes,5,opt,name=group,proto3" json:"group,omitempty"`                                         // consumer group; members split the channel's messages
+	TtlMs          int64                  `protobuf:"varint,6,opt,name=ttl_ms,json=ttlMs,proto3" json:"ttl_ms,omitempty"`                           // publish only: expire the message this long after delivery
+	DeliverAtMs    int64                  `protobuf:"varint,7,opt,name=deliver_at_ms,json=deliverAtMs,proto3" json:"deliver_at_ms,omitempty"`       // publish only: unix milliseconds to hold the message until
+	ContentType    string                 `protobuf:"bytes,8,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`          // publish only: encoding of publish_payload, defaults to text/plain
 	unknownFields  protoimpl.UnknownFields
 	sizeCache      protoimpl.SizeCache
 }
@@ -369,10 +535,48 @@ func (x *StreamRequest) GetPublishPayload() string {
 	return ""
 }
 
+func (x *StreamRequest) GetAckToken() string {
+	if x != nil {
+		return x.AckToken
+	}
+	return ""
+}
+
+func (x *StreamRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *StreamRequest) GetTtlMs() int64 {
+	if x != nil {
+		return x.TtlMs
+	}
+	return 0
+}
+
+func (x *StreamRequest) GetDeliverAtMs() int64 {
+	if x != nil {
+		return x.DeliverAtMs
+	}
+	return 0
+}
+
+func (x *StreamRequest) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
 type StreamResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Channel       string                 `protobuf:"bytes,1,opt,name=channel,proto3" json:"channel,omitempty"`
 	Payload       string                 `protobuf:"bytes,2,opt,name=payload,proto3" json:"payload,omitempty"`
+	Id            uint64                 `protobuf:"varint,3,opt,name=id,proto3" json:"id,omitempty"`                                     // per-channel message sequence number
+	AckToken      string                 `protobuf:"bytes,4,opt,name=ack_token,json=ackToken,proto3" json:"ack_token,omitempty"`          // set on ack-mode deliveries; echo back in StreamRequest.ack_token
+	ContentType   string                 `protobuf:"bytes,5,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"` // text/plain, application/json or application/octet-stream
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -421,28 +625,47 @@ func (x *StreamResponse) GetPayload() string {
 	return ""
 }
 
-type VectorSearchResponse_Result struct {
+func (x *StreamResponse) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *StreamResponse) GetAckToken() string {
+	if x != nil {
+		return x.AckToken
+	}
+	return ""
+}
+
+func (x *StreamResponse) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+type StatsRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
-	DataJson      string                 `protobuf:"bytes,2,opt,name=data_json,json=dataJson,proto3" json:"data_json,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *VectorSearchResponse_Result) Reset() {
-	*x = VectorSearchResponse_Result{}
+func (x *StatsRequest) Reset() {
+	*x = StatsRequest{}
 	mi := &file_kvi_proto_msgTypes[8]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *VectorSearchResponse_Result) String() string {
+func (x *StatsRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*VectorSearchResponse_Result) ProtoMessage() {}
+func (*StatsRequest) ProtoMessage() {}
 
-func (x *VectorSearchResponse_Result) ProtoReflect() protoreflect.Message {
+func (x *StatsRequest) ProtoReflect() protoreflect.Message {
 	mi := &file_kvi_proto_msgTypes[8]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -454,62 +677,2328 @@ func (x *VectorSearchResponse_Result) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use VectorSearchResponse_Result.ProtoReflect.Descriptor instead.
-func (*VectorSearchResponse_Result) Descriptor() ([]byte, []int) {
-	return file_kvi_proto_rawDescGZIP(), []int{5, 0}
+// Deprecated: Use StatsRequest.ProtoReflect.Descriptor instead.
+func (*StatsRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{8}
 }
 
-func (x *VectorSearchResponse_Result) GetId() string {
+// StatsResponse carries the same report as the REST /api/v1/stats endpoint
+// (without its HTTP rate-limit counters). Check schema_version before
+// relying on a field of report_json.
+type StatsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SchemaVersion int32                  `protobuf:"varint,1,opt,name=schema_version,json=schemaVersion,proto3" json:"schema_version,omitempty"`
+	ReportJson    string                 `protobuf:"bytes,2,opt,name=report_json,json=reportJson,proto3" json:"report_json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StatsResponse) Reset() {
+	*x = StatsResponse{}
+	mi := &file_kvi_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StatsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatsResponse) ProtoMessage() {}
+
+func (x *StatsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[9]
 	if x != nil {
-		return x.Id
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatsResponse.ProtoReflect.Descriptor instead.
+func (*StatsResponse) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *StatsResponse) GetSchemaVersion() int32 {
+	if x != nil {
+		return x.SchemaVersion
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetReportJson() string {
+	if x != nil {
+		return x.ReportJson
 	}
 	return ""
 }
 
-func (x *VectorSearchResponse_Result) GetDataJson() string {
+type WatchRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Prefix        string                 `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	FromSeq       uint64                 `protobuf:"varint,2,opt,name=from_seq,json=fromSeq,proto3" json:"from_seq,omitempty"` // replay retained changes after this seq; 0 starts from now
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchRequest) Reset() {
+	*x = WatchRequest{}
+	mi := &file_kvi_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchRequest) ProtoMessage() {}
+
+func (x *WatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[10]
 	if x != nil {
-		return x.DataJson
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchRequest.ProtoReflect.Descriptor instead.
+func (*WatchRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *WatchRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
 	}
 	return ""
 }
 
-var File_kvi_proto protoreflect.FileDescriptor
+func (x *WatchRequest) GetFromSeq() uint64 {
+	if x != nil {
+		return x.FromSeq
+	}
+	return 0
+}
 
-const file_kvi_proto_rawDesc = "" +
-	"\n" +
-	"\tkvi.proto\x12\x03kvi\"\x1e\n" +
-	"\n" +
-	"GetRequest\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\tR\x03key\":\n" +
-	"\vGetResponse\x12\x0e\n" +
-	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1b\n" +
-	"\tdata_json\x18\x02 \x01(\tR\bdataJson\";\n" +
-	"\n" +
-	"PutRequest\x12\x10\n" +
-	"\x03key\x18\x01 \x01(\tR\x03key\x12\x1b\n" +
-	"\tdata_json\x18\x02 \x01(\tR\bdataJson\"'\n" +
-	"\vPutResponse\x12\x18\n" +
-	"\asuccess\x18\x01 \x01(\bR\asuccess\";\n" +
-	"\x13VectorSearchRequest\x12\x16\n" +
-	"\x06vector\x18\x01 \x03(\x02R\x06vector\x12\f\n" +
-	"\x01k\x18\x02 \x01(\x05R\x01k\"\x89\x01\n" +
-	"\x14VectorSearchResponse\x12:\n" +
-	"\aresults\x18\x01 \x03(\v2 .kvi.VectorSearchResponse.ResultR\aresults\x1a5\n" +
-	"\x06Result\x12\x0e\n" +
-	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1b\n" +
-	"\tdata_json\x18\x02 \x01(\tR\bdataJson\"b\n" +
-	"\rStreamRequest\x12\x0e\n" +
-	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
-	"\achannel\x18\x02 \x01(\tR\achannel\x12'\n" +
-	"\x0fpublish_payload\x18\x03 \x01(\tR\x0epublishPayload\"D\n" +
-	"\x0eStreamResponse\x12\x18\n" +
-	"\achannel\x18\x01 \x01(\tR\achannel\x12\x18\n" +
-	"\apayload\x18\x02 \x01(\tR\apayload2\xdc\x01\n" +
+type WatchEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           uint64                 `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"` // orders changes across keys; restarts with the server
+	Op            string                 `protobuf:"bytes,2,opt,name=op,proto3" json:"op,omitempty"`    // "put", "delete", "expire" or "heartbeat"
+	Key           string                 `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
+	Record        *GetResponse           `protobuf:"bytes,4,opt,name=record,proto3" json:"record,omitempty"` // as stored by a put, or as it was before a delete or expiry
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchEvent) Reset() {
+	*x = WatchEvent{}
+	mi := &file_kvi_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchEvent) ProtoMessage() {}
+
+func (x *WatchEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchEvent.ProtoReflect.Descriptor instead.
+func (*WatchEvent) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *WatchEvent) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *WatchEvent) GetOp() string {
+	if x != nil {
+		return x.Op
+	}
+	return ""
+}
+
+func (x *WatchEvent) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *WatchEvent) GetRecord() *GetResponse {
+	if x != nil {
+		return x.Record
+	}
+	return nil
+}
+
+type ScanRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Prefix        string                 `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	Start         string                 `protobuf:"bytes,2,opt,name=start,proto3" json:"start,omitempty"`                                             // first key to return; "" starts at the prefix
+	End           string                 `protobuf:"bytes,3,opt,name=end,proto3" json:"end,omitempty"`                                                 // stop before this key; "" runs to the end of the prefix
+	Limit         uint32                 `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`                                            // most records to return; 0 or over the server's cap means the cap
+	ResumeToken   string                 `protobuf:"bytes,5,opt,name=resume_token,json=resumeToken,proto3" json:"resume_token,omitempty"`              // from a previous call's last message: continue after it
+	Tag           string                 `protobuf:"bytes,6,opt,name=tag,proto3" json:"tag,omitempty"`                                                 // only records with this tag; needs an engine that indexes tags
+	Fields        []string               `protobuf:"bytes,7,rep,name=fields,proto3" json:"fields,omitempty"`                                           // only these data entries of each record; none for all
+	IncludeVector *bool                  `protobuf:"varint,8,opt,name=include_vector,json=includeVector,proto3,oneof" json:"include_vector,omitempty"` // false to leave vectors out; unset keeps them
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScanRequest) Reset() {
+	*x = ScanRequest{}
+	mi := &file_kvi_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanRequest) ProtoMessage() {}
+
+func (x *ScanRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanRequest.ProtoReflect.Descriptor instead.
+func (*ScanRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ScanRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *ScanRequest) GetStart() string {
+	if x != nil {
+		return x.Start
+	}
+	return ""
+}
+
+func (x *ScanRequest) GetEnd() string {
+	if x != nil {
+		return x.End
+	}
+	return ""
+}
+
+func (x *ScanRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ScanRequest) GetResumeToken() string {
+	if x != nil {
+		return x.ResumeToken
+	}
+	return ""
+}
+
+func (x *ScanRequest) GetTag() string {
+	if x != nil {
+		return x.Tag
+	}
+	return ""
+}
+
+func (x *ScanRequest) GetFields() []string {
+	if x != nil {
+		return x.Fields
+	}
+	return nil
+}
+
+func (x *ScanRequest) GetIncludeVector() bool {
+	if x != nil && x.IncludeVector != nil {
+		return *x.IncludeVector
+	}
+	return false
+}
+
+type ScanResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Records       []*GetResponse         `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty"`
+	Last          bool                   `protobuf:"varint,2,opt,name=last,proto3" json:"last,omitempty"`                                 // the call's final message
+	ResumeToken   string                 `protobuf:"bytes,3,opt,name=resume_token,json=resumeToken,proto3" json:"resume_token,omitempty"` // final message only: set when the cap or limit cut the scan short
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ScanResponse) Reset() {
+	*x = ScanResponse{}
+	mi := &file_kvi_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ScanResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ScanResponse) ProtoMessage() {}
+
+func (x *ScanResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ScanResponse.ProtoReflect.Descriptor instead.
+func (*ScanResponse) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *ScanResponse) GetRecords() []*GetResponse {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+func (x *ScanResponse) GetLast() bool {
+	if x != nil {
+		return x.Last
+	}
+	return false
+}
+
+func (x *ScanResponse) GetResumeToken() string {
+	if x != nil {
+		return x.ResumeToken
+	}
+	return ""
+}
+
+type ChangesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Since         uint64                 `protobuf:"varint,1,opt,name=since,proto3" json:"since,omitempty"` // Unix nanoseconds: changes after this; 0 for every key
+	Limit         uint32                 `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"` // most changes to return; 0 or over the server's cap means the cap
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChangesRequest) Reset() {
+	*x = ChangesRequest{}
+	mi := &file_kvi_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChangesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChangesRequest) ProtoMessage() {}
+
+func (x *ChangesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChangesRequest.ProtoReflect.Descriptor instead.
+func (*ChangesRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *ChangesRequest) GetSince() uint64 {
+	if x != nil {
+		return x.Since
+	}
+	return 0
+}
+
+func (x *ChangesRequest) GetLimit() uint32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+type ChangeEntry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	At            uint64                 `protobuf:"varint,2,opt,name=at,proto3" json:"at,omitempty"`           // Unix nanoseconds: the record's updated_at, or when it was deleted
+	Deleted       bool                   `protobuf:"varint,3,opt,name=deleted,proto3" json:"deleted,omitempty"` // a tombstone for a deleted or expired key
+	Record        *GetResponse           `protobuf:"bytes,4,opt,name=record,proto3" json:"record,omitempty"`    // the record now; unset for a tombstone
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChangeEntry) Reset() {
+	*x = ChangeEntry{}
+	mi := &file_kvi_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChangeEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChangeEntry) ProtoMessage() {}
+
+func (x *ChangeEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChangeEntry.ProtoReflect.Descriptor instead.
+func (*ChangeEntry) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *ChangeEntry) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *ChangeEntry) GetAt() uint64 {
+	if x != nil {
+		return x.At
+	}
+	return 0
+}
+
+func (x *ChangeEntry) GetDeleted() bool {
+	if x != nil {
+		return x.Deleted
+	}
+	return false
+}
+
+func (x *ChangeEntry) GetRecord() *GetResponse {
+	if x != nil {
+		return x.Record
+	}
+	return nil
+}
+
+type ChangesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Changes       []*ChangeEntry         `protobuf:"bytes,1,rep,name=changes,proto3" json:"changes,omitempty"` // oldest first
+	Next          uint64                 `protobuf:"varint,2,opt,name=next,proto3" json:"next,omitempty"`      // since for the next call; equals since once there is nothing newer
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChangesResponse) Reset() {
+	*x = ChangesResponse{}
+	mi := &file_kvi_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChangesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChangesResponse) ProtoMessage() {}
+
+func (x *ChangesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChangesResponse.ProtoReflect.Descriptor instead.
+func (*ChangesResponse) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *ChangesResponse) GetChanges() []*ChangeEntry {
+	if x != nil {
+		return x.Changes
+	}
+	return nil
+}
+
+func (x *ChangesResponse) GetNext() uint64 {
+	if x != nil {
+		return x.Next
+	}
+	return 0
+}
+
+type LiveQueryRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Query         string                 `protobuf:"bytes,1,opt,name=query,proto3" json:"query,omitempty"` // a SELECT by WHERE id = '...' or WHERE field MATCH '...'
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LiveQueryRequest) Reset() {
+	*x = LiveQueryRequest{}
+	mi := &file_kvi_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LiveQueryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LiveQueryRequest) ProtoMessage() {}
+
+func (x *LiveQueryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LiveQueryRequest.ProtoReflect.Descriptor instead.
+func (*LiveQueryRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *LiveQueryRequest) GetQuery() string {
+	if x != nil {
+		return x.Query
+	}
+	return ""
+}
+
+type LiveQueryResult struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Seq           uint64                 `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`        // counts results from 1; a gap means one was missed
+	Records       []*GetResponse         `protobuf:"bytes,2,rep,name=records,proto3" json:"records,omitempty"` // the query's result; none for a key that is not there
+	Columns       []string               `protobuf:"bytes,3,rep,name=columns,proto3" json:"columns,omitempty"` // the columns the SELECT names; none for *
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LiveQueryResult) Reset() {
+	*x = LiveQueryResult{}
+	mi := &file_kvi_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LiveQueryResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LiveQueryResult) ProtoMessage() {}
+
+func (x *LiveQueryResult) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LiveQueryResult.ProtoReflect.Descriptor instead.
+func (*LiveQueryResult) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *LiveQueryResult) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *LiveQueryResult) GetRecords() []*GetResponse {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+func (x *LiveQueryResult) GetColumns() []string {
+	if x != nil {
+		return x.Columns
+	}
+	return nil
+}
+
+type BatchGetRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Keys          []string               `protobuf:"bytes,1,rep,name=keys,proto3" json:"keys,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchGetRequest) Reset() {
+	*x = BatchGetRequest{}
+	mi := &file_kvi_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchGetRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchGetRequest) ProtoMessage() {}
+
+func (x *BatchGetRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchGetRequest.ProtoReflect.Descriptor instead.
+func (*BatchGetRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *BatchGetRequest) GetKeys() []string {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+type BatchGetResponse struct {
+	state         protoimpl.MessageState  `protogen:"open.v1"`
+	Records       map[string]*GetResponse `protobuf:"bytes,1,rep,name=records,proto3" json:"records,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Missing       []string                `protobuf:"bytes,2,rep,name=missing,proto3" json:"missing,omitempty"` // requested keys with no live record, in request order
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchGetResponse) Reset() {
+	*x = BatchGetResponse{}
+	mi := &file_kvi_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchGetResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchGetResponse) ProtoMessage() {}
+
+func (x *BatchGetResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchGetResponse.ProtoReflect.Descriptor instead.
+func (*BatchGetResponse) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *BatchGetResponse) GetRecords() map[string]*GetResponse {
+	if x != nil {
+		return x.Records
+	}
+	return nil
+}
+
+func (x *BatchGetResponse) GetMissing() []string {
+	if x != nil {
+		return x.Missing
+	}
+	return nil
+}
+
+type BatchDeleteRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Keys          []string               `protobuf:"bytes,1,rep,name=keys,proto3" json:"keys,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchDeleteRequest) Reset() {
+	*x = BatchDeleteRequest{}
+	mi := &file_kvi_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchDeleteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchDeleteRequest) ProtoMessage() {}
+
+func (x *BatchDeleteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchDeleteRequest.ProtoReflect.Descriptor instead.
+func (*BatchDeleteRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *BatchDeleteRequest) GetKeys() []string {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+type BatchDeleteResponse struct {
+	state         protoimpl.MessageState        `protogen:"open.v1"`
+	Results       []*BatchDeleteResponse_Result `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"` // one per requested key, in request order
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchDeleteResponse) Reset() {
+	*x = BatchDeleteResponse{}
+	mi := &file_kvi_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchDeleteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchDeleteResponse) ProtoMessage() {}
+
+func (x *BatchDeleteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchDeleteResponse.ProtoReflect.Descriptor instead.
+func (*BatchDeleteResponse) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{22}
+}
+
+func (x *BatchDeleteResponse) GetResults() []*BatchDeleteResponse_Result {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+type SnapshotRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SnapshotRequest) Reset() {
+	*x = SnapshotRequest{}
+	mi := &file_kvi_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SnapshotRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SnapshotRequest) ProtoMessage() {}
+
+func (x *SnapshotRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SnapshotRequest.ProtoReflect.Descriptor instead.
+func (*SnapshotRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{23}
+}
+
+// Snapshot streams a backup in the format of GET /api/v1/backup, cut into
+// chunks of at most 1 MiB. The final message carries no data, only the
+// totals, the checksum and, for engines that ship their WAL, the LSN to
+// replicate from.
+type SnapshotChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Index         uint64                 `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Last          bool                   `protobuf:"varint,3,opt,name=last,proto3" json:"last,omitempty"`
+	TotalChunks   uint64                 `protobuf:"varint,4,opt,name=total_chunks,json=totalChunks,proto3" json:"total_chunks,omitempty"` // final message only
+	Checksum      string                 `protobuf:"bytes,5,opt,name=checksum,proto3" json:"checksum,omitempty"`                           // final message only: hex SHA-256 of the whole stream
+	Records       int64                  `protobuf:"varint,6,opt,name=records,proto3" json:"records,omitempty"`                            // final message only
+	Lsn           uint64                 `protobuf:"varint,7,opt,name=lsn,proto3" json:"lsn,omitempty"`                                    // final message only: every change up to this LSN is in the backup
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SnapshotChunk) Reset() {
+	*x = SnapshotChunk{}
+	mi := &file_kvi_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SnapshotChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SnapshotChunk) ProtoMessage() {}
+
+func (x *SnapshotChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SnapshotChunk.ProtoReflect.Descriptor instead.
+func (*SnapshotChunk) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{24}
+}
+
+func (x *SnapshotChunk) GetIndex() uint64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *SnapshotChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *SnapshotChunk) GetLast() bool {
+	if x != nil {
+		return x.Last
+	}
+	return false
+}
+
+func (x *SnapshotChunk) GetTotalChunks() uint64 {
+	if x != nil {
+		return x.TotalChunks
+	}
+	return 0
+}
+
+func (x *SnapshotChunk) GetChecksum() string {
+	if x != nil {
+		return x.Checksum
+	}
+	return ""
+}
+
+func (x *SnapshotChunk) GetRecords() int64 {
+	if x != nil {
+		return x.Records
+	}
+	return 0
+}
+
+func (x *SnapshotChunk) GetLsn() uint64 {
+	if x != nil {
+		return x.Lsn
+	}
+	return 0
+}
+
+// RestoreChunk is one piece of a backup sent to Restore, indexed from 0.
+type RestoreChunk struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Index         uint64                 `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+	Data          []byte                 `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	Mode          string                 `protobuf:"bytes,3,opt,name=mode,proto3" json:"mode,omitempty"`                    // first message only: "replace" (default) or "merge" (newer versions win)
+	Checksum      string                 `protobuf:"bytes,4,opt,name=checksum,proto3" json:"checksum,omitempty"`            // optional, on any message: expected hex SHA-256 of the whole stream
+	DryRun        bool                   `protobuf:"varint,5,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"` // first message only: report the counts without changing anything
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RestoreChunk) Reset() {
+	*x = RestoreChunk{}
+	mi := &file_kvi_proto_msgTypes[25]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RestoreChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RestoreChunk) ProtoMessage() {}
+
+func (x *RestoreChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[25]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RestoreChunk.ProtoReflect.Descriptor instead.
+func (*RestoreChunk) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{25}
+}
+
+func (x *RestoreChunk) GetIndex() uint64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *RestoreChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *RestoreChunk) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *RestoreChunk) GetChecksum() string {
+	if x != nil {
+		return x.Checksum
+	}
+	return ""
+}
+
+func (x *RestoreChunk) GetDryRun() bool {
+	if x != nil {
+		return x.DryRun
+	}
+	return false
+}
+
+type RestoreResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Restored      int64                  `protobuf:"varint,1,opt,name=restored,proto3" json:"restored,omitempty"`
+	Removed       int64                  `protobuf:"varint,2,opt,name=removed,proto3" json:"removed,omitempty"`
+	Bytes         int64                  `protobuf:"varint,3,opt,name=bytes,proto3" json:"bytes,omitempty"`
+	Checksum      string                 `protobuf:"bytes,4,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	Skipped       int64                  `protobuf:"varint,5,opt,name=skipped,proto3" json:"skipped,omitempty"` // merge: stored at the same or a newer version
+	Expired       int64                  `protobuf:"varint,6,opt,name=expired,proto3" json:"expired,omitempty"` // past their TTL, so not restored
+	DryRun        bool                   `protobuf:"varint,7,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RestoreResponse) Reset() {
+	*x = RestoreResponse{}
+	mi := &file_kvi_proto_msgTypes[26]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RestoreResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RestoreResponse) ProtoMessage() {}
+
+func (x *RestoreResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[26]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RestoreResponse.ProtoReflect.Descriptor instead.
+func (*RestoreResponse) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{26}
+}
+
+func (x *RestoreResponse) GetRestored() int64 {
+	if x != nil {
+		return x.Restored
+	}
+	return 0
+}
+
+func (x *RestoreResponse) GetRemoved() int64 {
+	if x != nil {
+		return x.Removed
+	}
+	return 0
+}
+
+func (x *RestoreResponse) GetBytes() int64 {
+	if x != nil {
+		return x.Bytes
+	}
+	return 0
+}
+
+func (x *RestoreResponse) GetChecksum() string {
+	if x != nil {
+		return x.Checksum
+	}
+	return ""
+}
+
+func (x *RestoreResponse) GetSkipped() int64 {
+	if x != nil {
+		return x.Skipped
+	}
+	return 0
+}
+
+func (x *RestoreResponse) GetExpired() int64 {
+	if x != nil {
+		return x.Expired
+	}
+	return 0
+}
+
+func (x *RestoreResponse) GetDryRun() bool {
+	if x != nil {
+		return x.DryRun
+	}
+	return false
+}
+
+type ReplicateRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	AfterLsn      uint64                 `protobuf:"varint,1,opt,name=after_lsn,json=afterLsn,proto3" json:"after_lsn,omitempty"` // send the changes logged after this LSN
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplicateRequest) Reset() {
+	*x = ReplicateRequest{}
+	mi := &file_kvi_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplicateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicateRequest) ProtoMessage() {}
+
+func (x *ReplicateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicateRequest.ProtoReflect.Descriptor instead.
+func (*ReplicateRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *ReplicateRequest) GetAfterLsn() uint64 {
+	if x != nil {
+		return x.AfterLsn
+	}
+	return 0
+}
+
+// ReplicationEntry is one change from the primary's WAL, or a heartbeat
+// carrying the primary's last LSN.
+type ReplicationEntry struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Lsn           uint64                 `protobuf:"varint,1,opt,name=lsn,proto3" json:"lsn,omitempty"`
+	Op            string                 `protobuf:"bytes,2,opt,name=op,proto3" json:"op,omitempty"` // "put", "delete" or "heartbeat"
+	Key           string                 `protobuf:"bytes,3,opt,name=key,proto3" json:"key,omitempty"`
+	Record        *GetResponse           `protobuf:"bytes,4,opt,name=record,proto3" json:"record,omitempty"` // puts only: the record as the primary stored it
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReplicationEntry) Reset() {
+	*x = ReplicationEntry{}
+	mi := &file_kvi_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReplicationEntry) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReplicationEntry) ProtoMessage() {}
+
+func (x *ReplicationEntry) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReplicationEntry.ProtoReflect.Descriptor instead.
+func (*ReplicationEntry) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *ReplicationEntry) GetLsn() uint64 {
+	if x != nil {
+		return x.Lsn
+	}
+	return 0
+}
+
+func (x *ReplicationEntry) GetOp() string {
+	if x != nil {
+		return x.Op
+	}
+	return ""
+}
+
+func (x *ReplicationEntry) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *ReplicationEntry) GetRecord() *GetResponse {
+	if x != nil {
+		return x.Record
+	}
+	return nil
+}
+
+// A sorted set's member and its score.
+type ZMember struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Member        string                 `protobuf:"bytes,1,opt,name=member,proto3" json:"member,omitempty"`
+	Score         float64                `protobuf:"fixed64,2,opt,name=score,proto3" json:"score,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ZMember) Reset() {
+	*x = ZMember{}
+	mi := &file_kvi_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ZMember) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ZMember) ProtoMessage() {}
+
+func (x *ZMember) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ZMember.ProtoReflect.Descriptor instead.
+func (*ZMember) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *ZMember) GetMember() string {
+	if x != nil {
+		return x.Member
+	}
+	return ""
+}
+
+func (x *ZMember) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+type ZAddRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Members       []*ZMember             `protobuf:"bytes,2,rep,name=members,proto3" json:"members,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ZAddRequest) Reset() {
+	*x = ZAddRequest{}
+	mi := &file_kvi_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ZAddRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ZAddRequest) ProtoMessage() {}
+
+func (x *ZAddRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ZAddRequest.ProtoReflect.Descriptor instead.
+func (*ZAddRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *ZAddRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *ZAddRequest) GetMembers() []*ZMember {
+	if x != nil {
+		return x.Members
+	}
+	return nil
+}
+
+type ZAddResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Added         int64                  `protobuf:"varint,1,opt,name=added,proto3" json:"added,omitempty"` // members that were not in the set
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ZAddResponse) Reset() {
+	*x = ZAddResponse{}
+	mi := &file_kvi_proto_msgTypes[31]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ZAddResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ZAddResponse) ProtoMessage() {}
+
+func (x *ZAddResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[31]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ZAddResponse.ProtoReflect.Descriptor instead.
+func (*ZAddResponse) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *ZAddResponse) GetAdded() int64 {
+	if x != nil {
+		return x.Added
+	}
+	return 0
+}
+
+type ZIncrByRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Member        string                 `protobuf:"bytes,2,opt,name=member,proto3" json:"member,omitempty"`
+	Delta         float64                `protobuf:"fixed64,3,opt,name=delta,proto3" json:"delta,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ZIncrByRequest) Reset() {
+	*x = ZIncrByRequest{}
+	mi := &file_kvi_proto_msgTypes[32]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ZIncrByRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ZIncrByRequest) ProtoMessage() {}
+
+func (x *ZIncrByRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[32]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ZIncrByRequest.ProtoReflect.Descriptor instead.
+func (*ZIncrByRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{32}
+}
+
+func (x *ZIncrByRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *ZIncrByRequest) GetMember() string {
+	if x != nil {
+		return x.Member
+	}
+	return ""
+}
+
+func (x *ZIncrByRequest) GetDelta() float64 {
+	if x != nil {
+		return x.Delta
+	}
+	return 0
+}
+
+type ZIncrByResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Score         float64                `protobuf:"fixed64,1,opt,name=score,proto3" json:"score,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ZIncrByResponse) Reset() {
+	*x = ZIncrByResponse{}
+	mi := &file_kvi_proto_msgTypes[33]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ZIncrByResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ZIncrByResponse) ProtoMessage() {}
+
+func (x *ZIncrByResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[33]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ZIncrByResponse.ProtoReflect.Descriptor instead.
+func (*ZIncrByResponse) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{33}
+}
+
+func (x *ZIncrByResponse) GetScore() float64 {
+	if x != nil {
+		return x.Score
+	}
+	return 0
+}
+
+type ZRemRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Members       []string               `protobuf:"bytes,2,rep,name=members,proto3" json:"members,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ZRemRequest) Reset() {
+	*x = ZRemRequest{}
+	mi := &file_kvi_proto_msgTypes[34]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ZRemRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ZRemRequest) ProtoMessage() {}
+
+func (x *ZRemRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[34]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ZRemRequest.ProtoReflect.Descriptor instead.
+func (*ZRemRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{34}
+}
+
+func (x *ZRemRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *ZRemRequest) GetMembers() []string {
+	if x != nil {
+		return x.Members
+	}
+	return nil
+}
+
+type ZRemResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Removed       int64                  `protobuf:"varint,1,opt,name=removed,proto3" json:"removed,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ZRemResponse) Reset() {
+	*x = ZRemResponse{}
+	mi := &file_kvi_proto_msgTypes[35]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ZRemResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ZRemResponse) ProtoMessage() {}
+
+func (x *ZRemResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[35]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ZRemResponse.ProtoReflect.Descriptor instead.
+func (*ZRemResponse) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{35}
+}
+
+func (x *ZRemResponse) GetRemoved() int64 {
+	if x != nil {
+		return x.Removed
+	}
+	return 0
+}
+
+// ZRangeByScoreRequest selects the members scored from min to max,
+// inclusive; unset bounds are open.
+type ZRangeByScoreRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Min           *float64               `protobuf:"fixed64,2,opt,name=min,proto3,oneof" json:"min,omitempty"`
+	Max           *float64               `protobuf:"fixed64,3,opt,name=max,proto3,oneof" json:"max,omitempty"`
+	Offset        int64                  `protobuf:"varint,4,opt,name=offset,proto3" json:"offset,omitempty"`
+	Limit         int64                  `protobuf:"varint,5,opt,name=limit,proto3" json:"limit,omitempty"`     // 0 returns every match
+	Reverse       bool                   `protobuf:"varint,6,opt,name=reverse,proto3" json:"reverse,omitempty"` // highest scores first
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ZRangeByScoreRequest) Reset() {
+	*x = ZRangeByScoreRequest{}
+	mi := &file_kvi_proto_msgTypes[36]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ZRangeByScoreRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ZRangeByScoreRequest) ProtoMessage() {}
+
+func (x *ZRangeByScoreRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[36]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ZRangeByScoreRequest.ProtoReflect.Descriptor instead.
+func (*ZRangeByScoreRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{36}
+}
+
+func (x *ZRangeByScoreRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *ZRangeByScoreRequest) GetMin() float64 {
+	if x != nil && x.Min != nil {
+		return *x.Min
+	}
+	return 0
+}
+
+func (x *ZRangeByScoreRequest) GetMax() float64 {
+	if x != nil && x.Max != nil {
+		return *x.Max
+	}
+	return 0
+}
+
+func (x *ZRangeByScoreRequest) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ZRangeByScoreRequest) GetLimit() int64 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ZRangeByScoreRequest) GetReverse() bool {
+	if x != nil {
+		return x.Reverse
+	}
+	return false
+}
+
+type ZRangeByScoreResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Members       []*ZMember             `protobuf:"bytes,1,rep,name=members,proto3" json:"members,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ZRangeByScoreResponse) Reset() {
+	*x = ZRangeByScoreResponse{}
+	mi := &file_kvi_proto_msgTypes[37]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ZRangeByScoreResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ZRangeByScoreResponse) ProtoMessage() {}
+
+func (x *ZRangeByScoreResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[37]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ZRangeByScoreResponse.ProtoReflect.Descriptor instead.
+func (*ZRangeByScoreResponse) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{37}
+}
+
+func (x *ZRangeByScoreResponse) GetMembers() []*ZMember {
+	if x != nil {
+		return x.Members
+	}
+	return nil
+}
+
+type ZRankRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Member        string                 `protobuf:"bytes,2,opt,name=member,proto3" json:"member,omitempty"`
+	Reverse       bool                   `protobuf:"varint,3,opt,name=reverse,proto3" json:"reverse,omitempty"` // rank 0 is the highest score
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ZRankRequest) Reset() {
+	*x = ZRankRequest{}
+	mi := &file_kvi_proto_msgTypes[38]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ZRankRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ZRankRequest) ProtoMessage() {}
+
+func (x *ZRankRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[38]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ZRankRequest.ProtoReflect.Descriptor instead.
+func (*ZRankRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{38}
+}
+
+func (x *ZRankRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *ZRankRequest) GetMember() string {
+	if x != nil {
+		return x.Member
+	}
+	return ""
+}
+
+func (x *ZRankRequest) GetReverse() bool {
+	if x != nil {
+		return x.Reverse
+	}
+	return false
+}
+
+type ZRankResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Rank          int64                  `protobuf:"varint,1,opt,name=rank,proto3" json:"rank,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ZRankResponse) Reset() {
+	*x = ZRankResponse{}
+	mi := &file_kvi_proto_msgTypes[39]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ZRankResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ZRankResponse) ProtoMessage() {}
+
+func (x *ZRankResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[39]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ZRankResponse.ProtoReflect.Descriptor instead.
+func (*ZRankResponse) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{39}
+}
+
+func (x *ZRankResponse) GetRank() int64 {
+	if x != nil {
+		return x.Rank
+	}
+	return 0
+}
+
+type LockRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Owner         string                 `protobuf:"bytes,2,opt,name=owner,proto3" json:"owner,omitempty"`
+	TtlMs         int64                  `protobuf:"varint,3,opt,name=ttl_ms,json=ttlMs,proto3" json:"ttl_ms,omitempty"` // must be positive
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LockRequest) Reset() {
+	*x = LockRequest{}
+	mi := &file_kvi_proto_msgTypes[40]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LockRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LockRequest) ProtoMessage() {}
+
+func (x *LockRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[40]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LockRequest.ProtoReflect.Descriptor instead.
+func (*LockRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{40}
+}
+
+func (x *LockRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *LockRequest) GetOwner() string {
+	if x != nil {
+		return x.Owner
+	}
+	return ""
+}
+
+func (x *LockRequest) GetTtlMs() int64 {
+	if x != nil {
+		return x.TtlMs
+	}
+	return 0
+}
+
+type LockResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Token         uint64                 `protobuf:"varint,1,opt,name=token,proto3" json:"token,omitempty"` // the fencing token, higher for every lock on the key
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LockResponse) Reset() {
+	*x = LockResponse{}
+	mi := &file_kvi_proto_msgTypes[41]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LockResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LockResponse) ProtoMessage() {}
+
+func (x *LockResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[41]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LockResponse.ProtoReflect.Descriptor instead.
+func (*LockResponse) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{41}
+}
+
+func (x *LockResponse) GetToken() uint64 {
+	if x != nil {
+		return x.Token
+	}
+	return 0
+}
+
+type UnlockRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Token         uint64                 `protobuf:"varint,2,opt,name=token,proto3" json:"token,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnlockRequest) Reset() {
+	*x = UnlockRequest{}
+	mi := &file_kvi_proto_msgTypes[42]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnlockRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnlockRequest) ProtoMessage() {}
+
+func (x *UnlockRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[42]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnlockRequest.ProtoReflect.Descriptor instead.
+func (*UnlockRequest) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{42}
+}
+
+func (x *UnlockRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *UnlockRequest) GetToken() uint64 {
+	if x != nil {
+		return x.Token
+	}
+	return 0
+}
+
+type UnlockResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UnlockResponse) Reset() {
+	*x = UnlockResponse{}
+	mi := &file_kvi_proto_msgTypes[43]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UnlockResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UnlockResponse) ProtoMessage() {}
+
+func (x *UnlockResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[43]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UnlockResponse.ProtoReflect.Descriptor instead.
+func (*UnlockResponse) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{43}
+}
+
+type VectorSearchResponse_Result struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	DataJson      string                 `protobuf:"bytes,2,opt,name=data_json,json=dataJson,proto3" json:"data_json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VectorSearchResponse_Result) Reset() {
+	*x = VectorSearchResponse_Result{}
+	mi := &file_kvi_proto_msgTypes[44]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VectorSearchResponse_Result) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VectorSearchResponse_Result) ProtoMessage() {}
+
+func (x *VectorSearchResponse_Result) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[44]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VectorSearchResponse_Result.ProtoReflect.Descriptor instead.
+func (*VectorSearchResponse_Result) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{5, 0}
+}
+
+func (x *VectorSearchResponse_Result) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *VectorSearchResponse_Result) GetDataJson() string {
+	if x != nil {
+		return x.DataJson
+	}
+	return ""
+}
+
+type BatchDeleteResponse_Result struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Key           string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Deleted       bool                   `protobuf:"varint,2,opt,name=deleted,proto3" json:"deleted,omitempty"` // false: the key did not exist
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchDeleteResponse_Result) Reset() {
+	*x = BatchDeleteResponse_Result{}
+	mi := &file_kvi_proto_msgTypes[46]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchDeleteResponse_Result) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchDeleteResponse_Result) ProtoMessage() {}
+
+func (x *BatchDeleteResponse_Result) ProtoReflect() protoreflect.Message {
+	mi := &file_kvi_proto_msgTypes[46]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchDeleteResponse_Result.ProtoReflect.Descriptor instead.
+func (*BatchDeleteResponse_Result) Descriptor() ([]byte, []int) {
+	return file_kvi_proto_rawDescGZIP(), []int{22, 0}
+}
+
+func (x *BatchDeleteResponse_Result) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *BatchDeleteResponse_Result) GetDeleted() bool {
+	if x != nil {
+		return x.Deleted
+	}
+	return false
+}
+
+var File_kvi_proto protoreflect.FileDescriptor
+
+const file_kvi_proto_rawDesc = "" +
+	"\n" +
+	"\tkvi.proto\x12\x03kvi\x1a\x1fgoogle/protobuf/timestamp.proto\"\x1e\n" +
+	"\n" +
+	"GetRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\"\x96\x03\n" +
+	"\vGetResponse\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1b\n" +
+	"\tdata_json\x18\x02 \x01(\tR\bdataJson\x12,\n" +
+	"\x12expires_in_seconds\x18\x03 \x01(\x03R\x10expiresInSeconds\x12\x18\n" +
+	"\aversion\x18\x04 \x01(\x04R\aversion\x129\n" +
+	"\n" +
+	"expires_at\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\x129\n" +
+	"\n" +
+	"created_at\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\tcreatedAt\x129\n" +
+	"\n" +
+	"updated_at\x18\a \x01(\v2\x1a.google.protobuf.TimestampR\tupdatedAt\x12\x16\n" +
+	"\x06vector\x18\b \x03(\x02R\x06vector\x12\x12\n" +
+	"\x04blob\x18\t \x01(\fR\x04blob\x12!\n" +
+	"\fcontent_type\x18\n" +
+	" \x01(\tR\vcontentType\x12\x12\n" +
+	"\x04tags\x18\v \x03(\tR\x04tags\"\x8f\x02\n" +
+	"\n" +
+	"PutRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x1b\n" +
+	"\tdata_json\x18\x02 \x01(\tR\bdataJson\x12\x1f\n" +
+	"\vttl_seconds\x18\x03 \x01(\x03R\n" +
+	"ttlSeconds\x12\x15\n" +
+	"\x06ttl_ms\x18\x04 \x01(\x03R\x05ttlMs\x12\x16\n" +
+	"\x06vector\x18\x05 \x03(\x02R\x06vector\x12\x12\n" +
+	"\x04blob\x18\x06 \x01(\fR\x04blob\x12!\n" +
+	"\fcontent_type\x18\a \x01(\tR\vcontentType\x12\x12\n" +
+	"\x04tags\x18\b \x03(\tR\x04tags\x12\x18\n" +
+	"\adurable\x18\t \x01(\bR\adurable\x12\x1d\n" +
+	"\n" +
+	"key_prefix\x18\n" +
+	" \x01(\tR\tkeyPrefix\"S\n" +
+	"\vPutResponse\x12\x18\n" +
+	"\asuccess\x18\x01 \x01(\bR\asuccess\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\x04R\aversion\x12\x10\n" +
+	"\x03key\x18\x03 \x01(\tR\x03key\"\\\n" +
+	"\x13VectorSearchRequest\x12\x16\n" +
+	"\x06vector\x18\x01 \x03(\x02R\x06vector\x12\f\n" +
+	"\x01k\x18\x02 \x01(\x05R\x01k\x12\x1f\n" +
+	"\vfilter_json\x18\x03 \x01(\tR\n" +
+	"filterJson\"\x89\x01\n" +
+	"\x14VectorSearchResponse\x12:\n" +
+	"\aresults\x18\x01 \x03(\v2 .kvi.VectorSearchResponse.ResultR\aresults\x1a5\n" +
+	"\x06Result\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1b\n" +
+	"\tdata_json\x18\x02 \x01(\tR\bdataJson\"\xf3\x01\n" +
+	"\rStreamRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x18\n" +
+	"\achannel\x18\x02 \x01(\tR\achannel\x12'\n" +
+	"\x0fpublish_payload\x18\x03 \x01(\tR\x0epublishPayload\x12\x1b\n" +
+	"\tack_token\x18\x04 \x01(\tR\backToken\x12\x14\n" +
+	"\x05group\x18\x05 \x01(\tR\x05group\x12\x15\n" +
+	"\x06ttl_ms\x18\x06 \x01(\x03R\x05ttlMs\x12\"\n" +
+	"\rdeliver_at_ms\x18\a \x01(\x03R\vdeliverAtMs\x12!\n" +
+	"\fcontent_type\x18\b \x01(\tR\vcontentType\"\x94\x01\n" +
+	"\x0eStreamResponse\x12\x18\n" +
+	"\achannel\x18\x01 \x01(\tR\achannel\x12\x18\n" +
+	"\apayload\x18\x02 \x01(\tR\apayload\x12\x0e\n" +
+	"\x02id\x18\x03 \x01(\x04R\x02id\x12\x1b\n" +
+	"\tack_token\x18\x04 \x01(\tR\backToken\x12!\n" +
+	"\fcontent_type\x18\x05 \x01(\tR\vcontentType\"\x0e\n" +
+	"\fStatsRequest\"W\n" +
+	"\rStatsResponse\x12%\n" +
+	"\x0eschema_version\x18\x01 \x01(\x05R\rschemaVersion\x12\x1f\n" +
+	"\vreport_json\x18\x02 \x01(\tR\n" +
+	"reportJson\"A\n" +
+	"\fWatchRequest\x12\x16\n" +
+	"\x06prefix\x18\x01 \x01(\tR\x06prefix\x12\x19\n" +
+	"\bfrom_seq\x18\x02 \x01(\x04R\afromSeq\"j\n" +
+	"\n" +
+	"WatchEvent\x12\x10\n" +
+	"\x03seq\x18\x01 \x01(\x04R\x03seq\x12\x0e\n" +
+	"\x02op\x18\x02 \x01(\tR\x02op\x12\x10\n" +
+	"\x03key\x18\x03 \x01(\tR\x03key\x12(\n" +
+	"\x06record\x18\x04 \x01(\v2\x10.kvi.GetResponseR\x06record\"\xef\x01\n" +
+	"\vScanRequest\x12\x16\n" +
+	"\x06prefix\x18\x01 \x01(\tR\x06prefix\x12\x14\n" +
+	"\x05start\x18\x02 \x01(\tR\x05start\x12\x10\n" +
+	"\x03end\x18\x03 \x01(\tR\x03end\x12\x14\n" +
+	"\x05limit\x18\x04 \x01(\rR\x05limit\x12!\n" +
+	"\fresume_token\x18\x05 \x01(\tR\vresumeToken\x12\x10\n" +
+	"\x03tag\x18\x06 \x01(\tR\x03tag\x12\x16\n" +
+	"\x06fields\x18\a \x03(\tR\x06fields\x12*\n" +
+	"\x0einclude_vector\x18\b \x01(\bH\x00R\rincludeVector\x88\x01\x01B\x11\n" +
+	"\x0f_include_vector\"q\n" +
+	"\fScanResponse\x12*\n" +
+	"\arecords\x18\x01 \x03(\v2\x10.kvi.GetResponseR\arecords\x12\x12\n" +
+	"\x04last\x18\x02 \x01(\bR\x04last\x12!\n" +
+	"\fresume_token\x18\x03 \x01(\tR\vresumeToken\"<\n" +
+	"\x0eChangesRequest\x12\x14\n" +
+	"\x05since\x18\x01 \x01(\x04R\x05since\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\rR\x05limit\"s\n" +
+	"\vChangeEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x0e\n" +
+	"\x02at\x18\x02 \x01(\x04R\x02at\x12\x18\n" +
+	"\adeleted\x18\x03 \x01(\bR\adeleted\x12(\n" +
+	"\x06record\x18\x04 \x01(\v2\x10.kvi.GetResponseR\x06record\"Q\n" +
+	"\x0fChangesResponse\x12*\n" +
+	"\achanges\x18\x01 \x03(\v2\x10.kvi.ChangeEntryR\achanges\x12\x12\n" +
+	"\x04next\x18\x02 \x01(\x04R\x04next\"(\n" +
+	"\x10LiveQueryRequest\x12\x14\n" +
+	"\x05query\x18\x01 \x01(\tR\x05query\"i\n" +
+	"\x0fLiveQueryResult\x12\x10\n" +
+	"\x03seq\x18\x01 \x01(\x04R\x03seq\x12*\n" +
+	"\arecords\x18\x02 \x03(\v2\x10.kvi.GetResponseR\arecords\x12\x18\n" +
+	"\acolumns\x18\x03 \x03(\tR\acolumns\"%\n" +
+	"\x0fBatchGetRequest\x12\x12\n" +
+	"\x04keys\x18\x01 \x03(\tR\x04keys\"\xb8\x01\n" +
+	"\x10BatchGetResponse\x12<\n" +
+	"\arecords\x18\x01 \x03(\v2\".kvi.BatchGetResponse.RecordsEntryR\arecords\x12\x18\n" +
+	"\amissing\x18\x02 \x03(\tR\amissing\x1aL\n" +
+	"\fRecordsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12&\n" +
+	"\x05value\x18\x02 \x01(\v2\x10.kvi.GetResponseR\x05value:\x028\x01\"(\n" +
+	"\x12BatchDeleteRequest\x12\x12\n" +
+	"\x04keys\x18\x01 \x03(\tR\x04keys\"\x86\x01\n" +
+	"\x13BatchDeleteResponse\x129\n" +
+	"\aresults\x18\x01 \x03(\v2\x1f.kvi.BatchDeleteResponse.ResultR\aresults\x1a4\n" +
+	"\x06Result\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x18\n" +
+	"\adeleted\x18\x02 \x01(\bR\adeleted\"\x11\n" +
+	"\x0fSnapshotRequest\"\xb8\x01\n" +
+	"\rSnapshotChunk\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x04R\x05index\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\x12\x12\n" +
+	"\x04last\x18\x03 \x01(\bR\x04last\x12!\n" +
+	"\ftotal_chunks\x18\x04 \x01(\x04R\vtotalChunks\x12\x1a\n" +
+	"\bchecksum\x18\x05 \x01(\tR\bchecksum\x12\x18\n" +
+	"\arecords\x18\x06 \x01(\x03R\arecords\x12\x10\n" +
+	"\x03lsn\x18\a \x01(\x04R\x03lsn\"\x81\x01\n" +
+	"\fRestoreChunk\x12\x14\n" +
+	"\x05index\x18\x01 \x01(\x04R\x05index\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\x12\x12\n" +
+	"\x04mode\x18\x03 \x01(\tR\x04mode\x12\x1a\n" +
+	"\bchecksum\x18\x04 \x01(\tR\bchecksum\x12\x17\n" +
+	"\adry_run\x18\x05 \x01(\bR\x06dryRun\"\xc6\x01\n" +
+	"\x0fRestoreResponse\x12\x1a\n" +
+	"\brestored\x18\x01 \x01(\x03R\brestored\x12\x18\n" +
+	"\aremoved\x18\x02 \x01(\x03R\aremoved\x12\x14\n" +
+	"\x05bytes\x18\x03 \x01(\x03R\x05bytes\x12\x1a\n" +
+	"\bchecksum\x18\x04 \x01(\tR\bchecksum\x12\x18\n" +
+	"\askipped\x18\x05 \x01(\x03R\askipped\x12\x18\n" +
+	"\aexpired\x18\x06 \x01(\x03R\aexpired\x12\x17\n" +
+	"\adry_run\x18\a \x01(\bR\x06dryRun\"/\n" +
+	"\x10ReplicateRequest\x12\x1b\n" +
+	"\tafter_lsn\x18\x01 \x01(\x04R\bafterLsn\"p\n" +
+	"\x10ReplicationEntry\x12\x10\n" +
+	"\x03lsn\x18\x01 \x01(\x04R\x03lsn\x12\x0e\n" +
+	"\x02op\x18\x02 \x01(\tR\x02op\x12\x10\n" +
+	"\x03key\x18\x03 \x01(\tR\x03key\x12(\n" +
+	"\x06record\x18\x04 \x01(\v2\x10.kvi.GetResponseR\x06record\"7\n" +
+	"\aZMember\x12\x16\n" +
+	"\x06member\x18\x01 \x01(\tR\x06member\x12\x14\n" +
+	"\x05score\x18\x02 \x01(\x01R\x05score\"G\n" +
+	"\vZAddRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12&\n" +
+	"\amembers\x18\x02 \x03(\v2\f.kvi.ZMemberR\amembers\"$\n" +
+	"\fZAddResponse\x12\x14\n" +
+	"\x05added\x18\x01 \x01(\x03R\x05added\"P\n" +
+	"\x0eZIncrByRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x16\n" +
+	"\x06member\x18\x02 \x01(\tR\x06member\x12\x14\n" +
+	"\x05delta\x18\x03 \x01(\x01R\x05delta\"'\n" +
+	"\x0fZIncrByResponse\x12\x14\n" +
+	"\x05score\x18\x01 \x01(\x01R\x05score\"9\n" +
+	"\vZRemRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x18\n" +
+	"\amembers\x18\x02 \x03(\tR\amembers\"(\n" +
+	"\fZRemResponse\x12\x18\n" +
+	"\aremoved\x18\x01 \x01(\x03R\aremoved\"\xae\x01\n" +
+	"\x14ZRangeByScoreRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x15\n" +
+	"\x03min\x18\x02 \x01(\x01H\x00R\x03min\x88\x01\x01\x12\x15\n" +
+	"\x03max\x18\x03 \x01(\x01H\x01R\x03max\x88\x01\x01\x12\x16\n" +
+	"\x06offset\x18\x04 \x01(\x03R\x06offset\x12\x14\n" +
+	"\x05limit\x18\x05 \x01(\x03R\x05limit\x12\x18\n" +
+	"\areverse\x18\x06 \x01(\bR\areverseB\x06\n" +
+	"\x04_minB\x06\n" +
+	"\x04_max\"?\n" +
+	"\x15ZRangeByScoreResponse\x12&\n" +
+	"\amembers\x18\x01 \x03(\v2\f.kvi.ZMemberR\amembers\"R\n" +
+	"\fZRankRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x16\n" +
+	"\x06member\x18\x02 \x01(\tR\x06member\x12\x18\n" +
+	"\areverse\x18\x03 \x01(\bR\areverse\"#\n" +
+	"\rZRankResponse\x12\x12\n" +
+	"\x04rank\x18\x01 \x01(\x03R\x04rank\"L\n" +
+	"\vLockRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05owner\x18\x02 \x01(\tR\x05owner\x12\x15\n" +
+	"\x06ttl_ms\x18\x03 \x01(\x03R\x05ttlMs\"$\n" +
+	"\fLockResponse\x12\x14\n" +
+	"\x05token\x18\x01 \x01(\x04R\x05token\"7\n" +
+	"\rUnlockRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05token\x18\x02 \x01(\x04R\x05token\"\x10\n" +
+	"\x0eUnlockResponse2\xf9\t\n" +
 	"\n" +
 	"KviService\x12(\n" +
 	"\x03Get\x12\x0f.kvi.GetRequest\x1a\x10.kvi.GetResponse\x12(\n" +
 	"\x03Put\x12\x0f.kvi.PutRequest\x1a\x10.kvi.PutResponse\x12C\n" +
-	"\fVectorSearch\x12\x18.kvi.VectorSearchRequest\x1a\x19.kvi.VectorSearchResponse\x125\n" +
+	"\fVectorSearch\x12\x18.kvi.VectorSearchRequest\x1a\x19.kvi.VectorSearchResponse\x12.\n" +
+	"\x05Stats\x12\x11.kvi.StatsRequest\x1a\x12.kvi.StatsResponse\x12-\n" +
+	"\x04Scan\x12\x10.kvi.ScanRequest\x1a\x11.kvi.ScanResponse0\x01\x124\n" +
+	"\aChanges\x12\x13.kvi.ChangesRequest\x1a\x14.kvi.ChangesResponse\x12:\n" +
+	"\tLiveQuery\x12\x15.kvi.LiveQueryRequest\x1a\x14.kvi.LiveQueryResult0\x01\x127\n" +
+	"\bBatchGet\x12\x14.kvi.BatchGetRequest\x1a\x15.kvi.BatchGetResponse\x12@\n" +
+	"\vBatchDelete\x12\x17.kvi.BatchDeleteRequest\x1a\x18.kvi.BatchDeleteResponse\x12A\n" +
+	"\x0eBatchGetStream\x12\x14.kvi.BatchGetRequest\x1a\x15.kvi.BatchGetResponse(\x010\x01\x12J\n" +
+	"\x11BatchDeleteStream\x12\x17.kvi.BatchDeleteRequest\x1a\x18.kvi.BatchDeleteResponse(\x010\x01\x12-\n" +
+	"\x05Watch\x12\x11.kvi.WatchRequest\x1a\x0f.kvi.WatchEvent0\x01\x126\n" +
+	"\bSnapshot\x12\x14.kvi.SnapshotRequest\x1a\x12.kvi.SnapshotChunk0\x01\x124\n" +
+	"\aRestore\x12\x11.kvi.RestoreChunk\x1a\x14.kvi.RestoreResponse(\x01\x12;\n" +
+	"\tReplicate\x12\x15.kvi.ReplicateRequest\x1a\x15.kvi.ReplicationEntry0\x01\x12+\n" +
+	"\x04ZAdd\x12\x10.kvi.ZAddRequest\x1a\x11.kvi.ZAddResponse\x124\n" +
+	"\aZIncrBy\x12\x13.kvi.ZIncrByRequest\x1a\x14.kvi.ZIncrByResponse\x12+\n" +
+	"\x04ZRem\x12\x10.kvi.ZRemRequest\x1a\x11.kvi.ZRemResponse\x12F\n" +
+	"\rZRangeByScore\x12\x19.kvi.ZRangeByScoreRequest\x1a\x1a.kvi.ZRangeByScoreResponse\x12.\n" +
+	"\x05ZRank\x12\x11.kvi.ZRankRequest\x1a\x12.kvi.ZRankResponse\x12+\n" +
+	"\x04Lock\x12\x10.kvi.LockRequest\x1a\x11.kvi.LockResponse\x121\n" +
+	"\x06Unlock\x12\x12.kvi.UnlockRequest\x1a\x13.kvi.UnlockResponse\x125\n" +
 	"\x06Stream\x12\x12.kvi.StreamRequest\x1a\x13.kvi.StreamResponse(\x010\x01B-Z+github.com/thirawat27/kvi/pkg/grpc;kvi_grpcb\x06proto3"
 
 var (
@@ -524,7 +3013,7 @@ func file_kvi_proto_rawDescGZIP() []byte {
 	return file_kvi_proto_rawDescData
 }
 
-var file_kvi_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_kvi_proto_msgTypes = make([]protoimpl.MessageInfo, 47)
 var file_kvi_proto_goTypes = []any{
 	(*GetRequest)(nil),                  // 0: kvi.GetRequest
 	(*GetResponse)(nil),                 // 1: kvi.GetResponse
@@ -534,23 +3023,114 @@ var file_kvi_proto_goTypes = []any{
 	(*VectorSearchResponse)(nil),        // 5: kvi.VectorSearchResponse
 	(*StreamRequest)(nil),               // 6: kvi.StreamRequest
 	(*StreamResponse)(nil),              // 7: kvi.StreamResponse
-	(*VectorSearchResponse_Result)(nil), // 8: kvi.VectorSearchResponse.Result
+	(*StatsRequest)(nil),                // 8: kvi.StatsRequest
+	(*StatsResponse)(nil),               // 9: kvi.StatsResponse
+	(*WatchRequest)(nil),                // 10: kvi.WatchRequest
+	(*WatchEvent)(nil),                  // 11: kvi.WatchEvent
+	(*ScanRequest)(nil),                 // 12: kvi.ScanRequest
+	(*ScanResponse)(nil),                // 13: kvi.ScanResponse
+	(*ChangesRequest)(nil),              // 14: kvi.ChangesRequest
+	(*ChangeEntry)(nil),                 // 15: kvi.ChangeEntry
+	(*ChangesResponse)(nil),             // 16: kvi.ChangesResponse
+	(*LiveQueryRequest)(nil),            // 17: kvi.LiveQueryRequest
+	(*LiveQueryResult)(nil),             // 18: kvi.LiveQueryResult
+	(*BatchGetRequest)(nil),             // 19: kvi.BatchGetRequest
+	(*BatchGetResponse)(nil),            // 20: kvi.BatchGetResponse
+	(*BatchDeleteRequest)(nil),          // 21: kvi.BatchDeleteRequest
+	(*BatchDeleteResponse)(nil),         // 22: kvi.BatchDeleteResponse
+	(*SnapshotRequest)(nil),             // 23: kvi.SnapshotRequest
+	(*SnapshotChunk)(nil),               // 24: kvi.SnapshotChunk
+	(*RestoreChunk)(nil),                // 25: kvi.RestoreChunk
+	(*RestoreResponse)(nil),             // 26: kvi.RestoreResponse
+	(*ReplicateRequest)(nil),            // 27: kvi.ReplicateRequest
+	(*ReplicationEntry)(nil),            // 28: kvi.ReplicationEntry
+	(*ZMember)(nil),                     // 29: kvi.ZMember
+	(*ZAddRequest)(nil),                 // 30: kvi.ZAddRequest
+	(*ZAddResponse)(nil),                // 31: kvi.ZAddResponse
+	(*ZIncrByRequest)(nil),              // 32: kvi.ZIncrByRequest
+	(*ZIncrByResponse)(nil),             // 33: kvi.ZIncrByResponse
+	(*ZRemRequest)(nil),                 // 34: kvi.ZRemRequest
+	(*ZRemResponse)(nil),                // 35: kvi.ZRemResponse
+	(*ZRangeByScoreRequest)(nil),        // 36: kvi.ZRangeByScoreRequest
+	(*ZRangeByScoreResponse)(nil),       // 37: kvi.ZRangeByScoreResponse
+	(*ZRankRequest)(nil),                // 38: kvi.ZRankRequest
+	(*ZRankResponse)(nil),               // 39: kvi.ZRankResponse
+	(*LockRequest)(nil),                 // 40: kvi.LockRequest
+	(*LockResponse)(nil),                // 41: kvi.LockResponse
+	(*UnlockRequest)(nil),               // 42: kvi.UnlockRequest
+	(*UnlockResponse)(nil),              // 43: kvi.UnlockResponse
+	(*VectorSearchResponse_Result)(nil), // 44: kvi.VectorSearchResponse.Result
+	nil,                                 // 45: kvi.BatchGetResponse.RecordsEntry
+	(*BatchDeleteResponse_Result)(nil),  // 46: kvi.BatchDeleteResponse.Result
+	(*timestamppb.Timestamp)(nil),       // 47: google.protobuf.Timestamp
 }
 var file_kvi_proto_depIdxs = []int32{
-	8, // 0: kvi.VectorSearchResponse.results:type_name -> kvi.VectorSearchResponse.Result
-	0, // 1: kvi.KviService.Get:input_type -> kvi.GetRequest
-	2, // 2: kvi.KviService.Put:input_type -> kvi.PutRequest
-	4, // 3: kvi.KviService.VectorSearch:input_type -> kvi.VectorSearchRequest
-	6, // 4: kvi.KviService.Stream:input_type -> kvi.StreamRequest
-	1, // 5: kvi.KviService.Get:output_type -> kvi.GetResponse
-	3, // 6: kvi.KviService.Put:output_type -> kvi.PutResponse
-	5, // 7: kvi.KviService.VectorSearch:output_type -> kvi.VectorSearchResponse
-	7, // 8: kvi.KviService.Stream:output_type -> kvi.StreamResponse
-	5, // [5:9] is the sub-list for method output_type
-	1, // [1:5] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	47, // 0: kvi.GetResponse.expires_at:type_name -> google.protobuf.Timestamp
+	47, // 1: kvi.GetResponse.created_at:type_name -> google.protobuf.Timestamp
+	47, // 2: kvi.GetResponse.updated_at:type_name -> google.protobuf.Timestamp
+	44, // 3: kvi.VectorSearchResponse.results:type_name -> kvi.VectorSearchResponse.Result
+	1,  // 4: kvi.WatchEvent.record:type_name -> kvi.GetResponse
+	1,  // 5: kvi.ScanResponse.records:type_name -> kvi.GetResponse
+	1,  // 6: kvi.ChangeEntry.record:type_name -> kvi.GetResponse
+	15, // 7: kvi.ChangesResponse.changes:type_name -> kvi.ChangeEntry
+	1,  // 8: kvi.LiveQueryResult.records:type_name -> kvi.GetResponse
+	45, // 9: kvi.BatchGetResponse.records:type_name -> kvi.BatchGetResponse.RecordsEntry
+	46, // 10: kvi.BatchDeleteResponse.results:type_name -> kvi.BatchDeleteResponse.Result
+	1,  // 11: kvi.ReplicationEntry.record:type_name -> kvi.GetResponse
+	29, // 12: kvi.ZAddRequest.members:type_name -> kvi.ZMember
+	29, // 13: kvi.ZRangeByScoreResponse.members:type_name -> kvi.ZMember
+	1,  // 14: kvi.BatchGetResponse.RecordsEntry.value:type_name -> kvi.GetResponse
+	0,  // 15: kvi.KviService.Get:input_type -> kvi.GetRequest
+	2,  // 16: kvi.KviService.Put:input_type -> kvi.PutRequest
+	4,  // 17: kvi.KviService.VectorSearch:input_type -> kvi.VectorSearchRequest
+	8,  // 18: kvi.KviService.Stats:input_type -> kvi.StatsRequest
+	12, // 19: kvi.KviService.Scan:input_type -> kvi.ScanRequest
+	14, // 20: kvi.KviService.Changes:input_type -> kvi.ChangesRequest
+	17, // 21: kvi.KviService.LiveQuery:input_type -> kvi.LiveQueryRequest
+	19, // 22: kvi.KviService.BatchGet:input_type -> kvi.BatchGetRequest
+	21, // 23: kvi.KviService.BatchDelete:input_type -> kvi.BatchDeleteRequest
+	19, // 24: kvi.KviService.BatchGetStream:input_type -> kvi.BatchGetRequest
+	21, // 25: kvi.KviService.BatchDeleteStream:input_type -> kvi.BatchDeleteRequest
+	10, // 26: kvi.KviService.Watch:input_type -> kvi.WatchRequest
+	23, // 27: kvi.KviService.Snapshot:input_type -> kvi.SnapshotRequest
+	25, // 28: kvi.KviService.Restore:input_type -> kvi.RestoreChunk
+	27, // 29: kvi.KviService.Replicate:input_type -> kvi.ReplicateRequest
+	30, // 30: kvi.KviService.ZAdd:input_type -> kvi.ZAddRequest
+	32, // 31: kvi.KviService.ZIncrBy:input_type -> kvi.ZIncrByRequest
+	34, // 32: kvi.KviService.ZRem:input_type -> kvi.ZRemRequest
+	36, // 33: kvi.KviService.ZRangeByScore:input_type -> kvi.ZRangeByScoreRequest
+	38, // 34: kvi.KviService.ZRank:input_type -> kvi.ZRankRequest
+	40, // 35: kvi.KviService.Lock:input_type -> kvi.LockRequest
+	42, // 36: kvi.KviService.Unlock:input_type -> kvi.UnlockRequest
+	6,  // 37: kvi.KviService.Stream:input_type -> kvi.StreamRequest
+	1,  // 38: kvi.KviService.Get:output_type -> kvi.GetResponse
+	3,  // 39: kvi.KviService.Put:output_type -> kvi.PutResponse
+	5,  // 40: kvi.KviService.VectorSearch:output_type -> kvi.VectorSearchResponse
+	9,  // 41: kvi.KviService.Stats:output_type -> kvi.StatsResponse
+	13, // 42: kvi.KviService.Scan:output_type -> kvi.ScanResponse
+	16, // 43: kvi.KviService.Changes:output_type -> kvi.ChangesResponse
+	18, // 44: kvi.KviService.LiveQuery:output_type -> kvi.LiveQueryResult
+	20, // 45: kvi.KviService.BatchGet:output_type -> kvi.BatchGetResponse
+	22, // 46: kvi.KviService.BatchDelete:output_type -> kvi.BatchDeleteResponse
+	20, // 47: kvi.KviService.BatchGetStream:output_type -> kvi.BatchGetResponse
+	22, // 48: kvi.KviService.BatchDeleteStream:output_type -> kvi.BatchDeleteResponse
+	11, // 49: kvi.KviService.Watch:output_type -> kvi.WatchEvent
+	24, // 50: kvi.KviService.Snapshot:output_type -> kvi.SnapshotChunk
+	26, // 51: kvi.KviService.Restore:output_type -> kvi.RestoreResponse
+	28, // 52: kvi.KviService.Replicate:output_type -> kvi.ReplicationEntry
+	31, // 53: kvi.KviService.ZAdd:output_type -> kvi.ZAddResponse
+	33, // 54: kvi.KviService.ZIncrBy:output_type -> kvi.ZIncrByResponse
+	35, // 55: kvi.KviService.ZRem:output_type -> kvi.ZRemResponse
+	37, // 56: kvi.KviService.ZRangeByScore:output_type -> kvi.ZRangeByScoreResponse
+	39, // 57: kvi.KviService.ZRank:output_type -> kvi.ZRankResponse
+	41, // 58: kvi.KviService.Lock:output_type -> kvi.LockResponse
+	43, // 59: kvi.KviService.Unlock:output_type -> kvi.UnlockResponse
+	7,  // 60: kvi.KviService.Stream:output_type -> kvi.StreamResponse
+	38, // [38:61] is the sub-list for method output_type
+	15, // [15:38] is the sub-list for method input_type
+	15, // [15:15] is the sub-list for extension type_name
+	15, // [15:15] is the sub-list for extension extendee
+	0,  // [0:15] is the sub-list for field type_name
 }
 
 func init() { file_kvi_proto_init() }
@@ -558,13 +3138,15 @@ func file_kvi_proto_init() {
 	if File_kvi_proto != nil {
 		return
 	}
+	file_kvi_proto_msgTypes[12].OneofWrappers = []any{}
+	file_kvi_proto_msgTypes[36].OneofWrappers = []any{}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_kvi_proto_rawDesc), len(file_kvi_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   9,
+			NumMessages:   47,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -19,19 +19,92 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	KviService_Get_FullMethodName          = "/kvi.KviService/Get"
-	KviService_Put_FullMethodName          = "/kvi.KviService/Put"
-	KviService_VectorSearch_FullMethodName = "/kvi.KviService/VectorSearch"
-	KviService_Stream_FullMethodName       = "/kvi.KviService/Stream"
+	KviService_Get_FullMethodName               = "/kvi.KviService/Get"
+	KviService_Put_FullMethodName               = "/kvi.KviService/Put"
+	KviService_VectorSearch_FullMethodName      = "/kvi.KviService/VectorSearch"
+	KviService_Stats_FullMethodName             = "/kvi.KviService/Stats"
+	KviService_Scan_FullMethodName              = "/kvi.KviService/Scan"
+	KviService_Changes_FullMethodName           = "/kvi.KviService/Changes"
+	KviService_LiveQuery_FullMethodName         = "/kvi.KviService/LiveQuery"
+	KviService_BatchGet_FullMethodName          = "/kvi.KviService/BatchGet"
+	KviService_BatchDelete_FullMethodName       = "/kvi.KviService/BatchDelete"
+	KviService_BatchGetStream_FullMethodName    = "/kvi.KviService/BatchGetStream"
+	KviService_BatchDeleteStream_FullMethodName = "/kvi.KviService/BatchDeleteStream"
+	KviService_Watch_FullMethodName             = "/kvi.KviService/Watch"
+	KviService_Snapshot_FullMethodName          = "/kvi.KviService/Snapshot"
+	KviService_Restore_FullMethodName           = "/kvi.KviService/Restore"
+	KviService_Replicate_FullMethodName         = "/kvi.KviService/Replicate"
+	KviService_ZAdd_FullMethodName              = "/kvi.KviService/ZAdd"
+	KviService_ZIncrBy_FullMethodName           = "/kvi.KviService/ZIncrBy"
+	KviService_ZRem_FullMethodName              = "/kvi.KviService/ZRem"
+	KviService_ZRangeByScore_FullMethodName     = "/kvi.KviService/ZRangeByScore"
+	KviService_ZRank_FullMethodName             = "/kvi.KviService/ZRank"
+	KviService_Lock_FullMethodName              = "/kvi.KviService/Lock"
+	KviService_Unlock_FullMethodName            = "/kvi.KviService/Unlock"
+	KviService_Stream_FullMethodName            = "/kvi.KviService/Stream"
 )
 
 // KviServiceClient is the client API for KviService service.
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// Engine errors come back as status codes: NOT_FOUND for a missing or
+// expired key, INVALID_ARGUMENT for bad input (including vectors),
+// FAILED_PRECONDITION for a version conflict or a write to a read-only
+// replica, RESOURCE_EXHAUSTED when the write queue is full,
+// DEADLINE_EXCEEDED / CANCELLED when the call's context ends, and INTERNAL
+// for everything else.
 type KviServiceClient interface {
 	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
 	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
 	VectorSearch(ctx context.Context, in *VectorSearchRequest, opts ...grpc.CallOption) (*VectorSearchResponse, error)
+	Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error)
+	// Watch streams changes to keys under a prefix. Idle streams get a
+	// heartbeat carrying the last seq sent. A watcher that falls too far
+	// behind is ended with ABORTED and resumes with from_seq; OUT_OF_RANGE
+	// means the changes after from_seq are no longer retained.
+	// Scan streams records in key order, a message per chunk, and stops at
+	// the server's per-call row cap with a resume token.
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ScanResponse], error)
+	// Changes lists the keys changed after since, oldest first, for
+	// clients that sync. OUT_OF_RANGE means tombstones after since have
+	// been dropped: start over from 0. UNIMPLEMENTED unless the engine
+	// indexes changes.
+	Changes(ctx context.Context, in *ChangesRequest, opts ...grpc.CallOption) (*ChangesResponse, error)
+	// LiveQuery sends a SELECT's result, then again each time a write
+	// changes it. RESOURCE_EXHAUSTED when the client holds as many live
+	// queries as it may, INVALID_ARGUMENT for a statement that cannot be
+	// live, UNIMPLEMENTED unless the engine has a change feed.
+	LiveQuery(ctx context.Context, in *LiveQueryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[LiveQueryResult], error)
+	BatchGet(ctx context.Context, in *BatchGetRequest, opts ...grpc.CallOption) (*BatchGetResponse, error)
+	BatchDelete(ctx context.Context, in *BatchDeleteRequest, opts ...grpc.CallOption) (*BatchDeleteResponse, error)
+	// Streaming batches for more keys than one call allows: each request
+	// message is answered by one response message.
+	BatchGetStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[BatchGetRequest, BatchGetResponse], error)
+	BatchDeleteStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[BatchDeleteRequest, BatchDeleteResponse], error)
+	Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchEvent], error)
+	Snapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SnapshotChunk], error)
+	// Restore verifies the chunk order, checksum and format of the whole
+	// upload before changing anything. ABORTED if another restore is running.
+	Restore(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[RestoreChunk, RestoreResponse], error)
+	// Replicate streams the WAL entries logged after after_lsn, in LSN
+	// order, for a follower; a heartbeat follows every interval. A follower
+	// that falls too far behind is ended with ABORTED and resumes after the
+	// last LSN it applied; OUT_OF_RANGE means it must start over from a
+	// Snapshot. UNIMPLEMENTED unless the engine writes a WAL.
+	Replicate(ctx context.Context, in *ReplicateRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ReplicationEntry], error)
+	// Sorted sets, as /api/v1/zset/*. A key holding something else is
+	// FAILED_PRECONDITION; ZRank of a member not in the set is NOT_FOUND.
+	ZAdd(ctx context.Context, in *ZAddRequest, opts ...grpc.CallOption) (*ZAddResponse, error)
+	ZIncrBy(ctx context.Context, in *ZIncrByRequest, opts ...grpc.CallOption) (*ZIncrByResponse, error)
+	ZRem(ctx context.Context, in *ZRemRequest, opts ...grpc.CallOption) (*ZRemResponse, error)
+	ZRangeByScore(ctx context.Context, in *ZRangeByScoreRequest, opts ...grpc.CallOption) (*ZRangeByScoreResponse, error)
+	ZRank(ctx context.Context, in *ZRankRequest, opts ...grpc.CallOption) (*ZRankResponse, error)
+	// Advisory locks, as /api/v1/lock and /api/v1/unlock. Lock on a key
+	// someone holds, and Unlock of a lock that expired or was released,
+	// are FAILED_PRECONDITION.
+	Lock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*LockResponse, error)
+	Unlock(ctx context.Context, in *UnlockRequest, opts ...grpc.CallOption) (*UnlockResponse, error)
 	// Bidirectional Streaming for Pub/Sub & Mesh Comm
 	Stream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[StreamRequest, StreamResponse], error)
 }
@@ -74,9 +147,253 @@ func (c *kviServiceClient) VectorSearch(ctx context.Context, in *VectorSearchReq
 	return out, nil
 }
 
+func (c *kviServiceClient) Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(StatsResponse)
+	err := c.cc.Invoke(ctx, KviService_Stats_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kviServiceClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ScanResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &KviService_ServiceDesc.Streams[0], KviService_Scan_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ScanRequest, ScanResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_ScanClient = grpc.ServerStreamingClient[ScanResponse]
+
+func (c *kviServiceClient) Changes(ctx context.Context, in *ChangesRequest, opts ...grpc.CallOption) (*ChangesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ChangesResponse)
+	err := c.cc.Invoke(ctx, KviService_Changes_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kviServiceClient) LiveQuery(ctx context.Context, in *LiveQueryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[LiveQueryResult], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &KviService_ServiceDesc.Streams[1], KviService_LiveQuery_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[LiveQueryRequest, LiveQueryResult]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_LiveQueryClient = grpc.ServerStreamingClient[LiveQueryResult]
+
+func (c *kviServiceClient) BatchGet(ctx context.Context, in *BatchGetRequest, opts ...grpc.CallOption) (*BatchGetResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchGetResponse)
+	err := c.cc.Invoke(ctx, KviService_BatchGet_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kviServiceClient) BatchDelete(ctx context.Context, in *BatchDeleteRequest, opts ...grpc.CallOption) (*BatchDeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchDeleteResponse)
+	err := c.cc.Invoke(ctx, KviService_BatchDelete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kviServiceClient) BatchGetStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[BatchGetRequest, BatchGetResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &KviService_ServiceDesc.Streams[2], KviService_BatchGetStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[BatchGetRequest, BatchGetResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_BatchGetStreamClient = grpc.BidiStreamingClient[BatchGetRequest, BatchGetResponse]
+
+func (c *kviServiceClient) BatchDeleteStream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[BatchDeleteRequest, BatchDeleteResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &KviService_ServiceDesc.Streams[3], KviService_BatchDeleteStream_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[BatchDeleteRequest, BatchDeleteResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_BatchDeleteStreamClient = grpc.BidiStreamingClient[BatchDeleteRequest, BatchDeleteResponse]
+
+func (c *kviServiceClient) Watch(ctx context.Context, in *WatchRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[WatchEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &KviService_ServiceDesc.Streams[4], KviService_Watch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[WatchRequest, WatchEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_WatchClient = grpc.ServerStreamingClient[WatchEvent]
+
+func (c *kviServiceClient) Snapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SnapshotChunk], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &KviService_ServiceDesc.Streams[5], KviService_Snapshot_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SnapshotRequest, SnapshotChunk]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_SnapshotClient = grpc.ServerStreamingClient[SnapshotChunk]
+
+func (c *kviServiceClient) Restore(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[RestoreChunk, RestoreResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &KviService_ServiceDesc.Streams[6], KviService_Restore_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[RestoreChunk, RestoreResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_RestoreClient = grpc.ClientStreamingClient[RestoreChunk, RestoreResponse]
+
+func (c *kviServiceClient) Replicate(ctx context.Context, in *ReplicateRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ReplicationEntry], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &KviService_ServiceDesc.Streams[7], KviService_Replicate_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ReplicateRequest, ReplicationEntry]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_ReplicateClient = grpc.ServerStreamingClient[ReplicationEntry]
+
+func (c *kviServiceClient) ZAdd(ctx context.Context, in *ZAddRequest, opts ...grpc.CallOption) (*ZAddResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ZAddResponse)
+	err := c.cc.Invoke(ctx, KviService_ZAdd_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kviServiceClient) ZIncrBy(ctx context.Context, in *ZIncrByRequest, opts ...grpc.CallOption) (*ZIncrByResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ZIncrByResponse)
+	err := c.cc.Invoke(ctx, KviService_ZIncrBy_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kviServiceClient) ZRem(ctx context.Context, in *ZRemRequest, opts ...grpc.CallOption) (*ZRemResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ZRemResponse)
+	err := c.cc.Invoke(ctx, KviService_ZRem_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kviServiceClient) ZRangeByScore(ctx context.Context, in *ZRangeByScoreRequest, opts ...grpc.CallOption) (*ZRangeByScoreResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ZRangeByScoreResponse)
+	err := c.cc.Invoke(ctx, KviService_ZRangeByScore_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kviServiceClient) ZRank(ctx context.Context, in *ZRankRequest, opts ...grpc.CallOption) (*ZRankResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ZRankResponse)
+	err := c.cc.Invoke(ctx, KviService_ZRank_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kviServiceClient) Lock(ctx context.Context, in *LockRequest, opts ...grpc.CallOption) (*LockResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LockResponse)
+	err := c.cc.Invoke(ctx, KviService_Lock_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *kviServiceClient) Unlock(ctx context.Context, in *UnlockRequest, opts ...grpc.CallOption) (*UnlockResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UnlockResponse)
+	err := c.cc.Invoke(ctx, KviService_Unlock_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *kviServiceClient) Stream(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[StreamRequest, StreamResponse], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	stream, err := c.cc.NewStream(ctx, &KviService_ServiceDesc.Streams[0], KviService_Stream_FullMethodName, cOpts...)
+	stream, err := c.cc.NewStream(ctx, &KviService_ServiceDesc.Streams[8], KviService_Stream_FullMethodName, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -90,10 +407,64 @@ type KviService_StreamClient = grpc.BidiStreamingClient[StreamRequest, StreamRes
 // KviServiceServer is the server API for KviService service.
 // All implementations must embed UnimplementedKviServiceServer
 // for forward compatibility.
+//
+// Engine errors come back as status codes: NOT_FOUND for a missing or
+// expired key, INVALID_ARGUMENT for bad input (including vectors),
+// FAILED_PRECONDITION for a version conflict or a write to a read-only
+// replica, RESOURCE_EXHAUSTED when the write queue is full,
+// DEADLINE_EXCEEDED / CANCELLED when the call's context ends, and INTERNAL
+// for everything else.
 type KviServiceServer interface {
 	Get(context.Context, *GetRequest) (*GetResponse, error)
 	Put(context.Context, *PutRequest) (*PutResponse, error)
 	VectorSearch(context.Context, *VectorSearchRequest) (*VectorSearchResponse, error)
+	Stats(context.Context, *StatsRequest) (*StatsResponse, error)
+	// Watch streams changes to keys under a prefix. Idle streams get a
+	// heartbeat carrying the last seq sent. A watcher that falls too far
+	// behind is ended with ABORTED and resumes with from_seq; OUT_OF_RANGE
+	// means the changes after from_seq are no longer retained.
+	// Scan streams records in key order, a message per chunk, and stops at
+	// the server's per-call row cap with a resume token.
+	Scan(*ScanRequest, grpc.ServerStreamingServer[ScanResponse]) error
+	// Changes lists the keys changed after since, oldest first, for
+	// clients that sync. OUT_OF_RANGE means tombstones after since have
+	// been dropped: start over from 0. UNIMPLEMENTED unless the engine
+	// indexes changes.
+	Changes(context.Context, *ChangesRequest) (*ChangesResponse, error)
+	// LiveQuery sends a SELECT's result, then again each time a write
+	// changes it. RESOURCE_EXHAUSTED when the client holds as many live
+	// queries as it may, INVALID_ARGUMENT for a statement that cannot be
+	// live, UNIMPLEMENTED unless the engine has a change feed.
+	LiveQuery(*LiveQueryRequest, grpc.ServerStreamingServer[LiveQueryResult]) error
+	BatchGet(context.Context, *BatchGetRequest) (*BatchGetResponse, error)
+	BatchDelete(context.Context, *BatchDeleteRequest) (*BatchDeleteResponse, error)
+	// Streaming batches for more keys than one call allows: each request
+	// message is answered by one response message.
+	BatchGetStream(grpc.BidiStreamingServer[BatchGetRequest, BatchGetResponse]) error
+	BatchDeleteStream(grpc.BidiStreamingServer[BatchDeleteRequest, BatchDeleteResponse]) error
+	Watch(*WatchRequest, grpc.ServerStreamingServer[WatchEvent]) error
+	Snapshot(*SnapshotRequest, grpc.ServerStreamingServer[SnapshotChunk]) error
+	// Restore verifies the chunk order, checksum and format of the whole
+	// upload before changing anything. ABORTED if another restore is running.
+	Restore(grpc.ClientStreamingServer[RestoreChunk, RestoreResponse]) error
+	// Replicate streams the WAL entries logged after after_lsn, in LSN
+	// order, for a follower; a heartbeat follows every interval. A follower
+	// that falls too far behind is ended with ABORTED and resumes after the
+	// last LSN it applied; OUT_OF_RANGE means it must start over from a
+	// Snapshot. UNIMPLEMENTED unless the engine writes a WAL.
+	Replicate(*ReplicateRequest, grpc.ServerStreamingServer[ReplicationEntry]) error
+	// Sorted sets, as /api/v1/zset/*. A key holding something else is
+	// FAILED_PRECONDITION; ZRank of a member not in the set is NOT_FOUND.
+	ZAdd(context.Context, *ZAddRequest) (*ZAddResponse, error)
+	ZIncrBy(context.Context, *ZIncrByRequest) (*ZIncrByResponse, error)
+	ZRem(context.Context, *ZRemRequest) (*ZRemResponse, error)
+	ZRangeByScore(context.Context, *ZRangeByScoreRequest) (*ZRangeByScoreResponse, error)
+	ZRank(context.Context, *ZRankRequest) (*ZRankResponse, error)
+	// Advisory locks, as /api/v1/lock and /api/v1/unlock. Lock on a key
+	// someone holds, and Unlock of a lock that expired or was released,
+	// are FAILED_PRECONDITION.
+	Lock(context.Context, *LockRequest) (*LockResponse, error)
+	Unlock(context.Context, *UnlockRequest) (*UnlockResponse, error)
 	// Bidirectional Streaming for Pub/Sub & Mesh Comm
 	Stream(grpc.BidiStreamingServer[StreamRequest, StreamResponse]) error
 	mustEmbedUnimplementedKviServiceServer()
@@ -115,6 +486,63 @@ func (UnimplementedKviServiceServer) Put(context.Context, *PutRequest) (*PutResp
 func (UnimplementedKviServiceServer) VectorSearch(context.Context, *VectorSearchRequest) (*VectorSearchResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method VectorSearch not implemented")
 }
+func (UnimplementedKviServiceServer) Stats(context.Context, *StatsRequest) (*StatsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Stats not implemented")
+}
+func (UnimplementedKviServiceServer) Scan(*ScanRequest, grpc.ServerStreamingServer[ScanResponse]) error {
+	return status.Error(codes.Unimplemented, "method Scan not implemented")
+}
+func (UnimplementedKviServiceServer) Changes(context.Context, *ChangesRequest) (*ChangesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Changes not implemented")
+}
+func (UnimplementedKviServiceServer) LiveQuery(*LiveQueryRequest, grpc.ServerStreamingServer[LiveQueryResult]) error {
+	return status.Error(codes.Unimplemented, "method LiveQuery not implemented")
+}
+func (UnimplementedKviServiceServer) BatchGet(context.Context, *BatchGetRequest) (*BatchGetResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method BatchGet not implemented")
+}
+func (UnimplementedKviServiceServer) BatchDelete(context.Context, *BatchDeleteRequest) (*BatchDeleteResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method BatchDelete not implemented")
+}
+func (UnimplementedKviServiceServer) BatchGetStream(grpc.BidiStreamingServer[BatchGetRequest, BatchGetResponse]) error {
+	return status.Error(codes.Unimplemented, "method BatchGetStream not implemented")
+}
+func (UnimplementedKviServiceServer) BatchDeleteStream(grpc.BidiStreamingServer[BatchDeleteRequest, BatchDeleteResponse]) error {
+	return status.Error(codes.Unimplemented, "method BatchDeleteStream not implemented")
+}
+func (UnimplementedKviServiceServer) Watch(*WatchRequest, grpc.ServerStreamingServer[WatchEvent]) error {
+	return status.Error(codes.Unimplemented, "method Watch not implemented")
+}
+func (UnimplementedKviServiceServer) Snapshot(*SnapshotRequest, grpc.ServerStreamingServer[SnapshotChunk]) error {
+	return status.Error(codes.Unimplemented, "method Snapshot not implemented")
+}
+func (UnimplementedKviServiceServer) Restore(grpc.ClientStreamingServer[RestoreChunk, RestoreResponse]) error {
+	return status.Error(codes.Unimplemented, "method Restore not implemented")
+}
+func (UnimplementedKviServiceServer) Replicate(*ReplicateRequest, grpc.ServerStreamingServer[ReplicationEntry]) error {
+	return status.Error(codes.Unimplemented, "method Replicate not implemented")
+}
+func (UnimplementedKviServiceServer) ZAdd(context.Context, *ZAddRequest) (*ZAddResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ZAdd not implemented")
+}
+func (UnimplementedKviServiceServer) ZIncrBy(context.Context, *ZIncrByRequest) (*ZIncrByResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ZIncrBy not implemented")
+}
+func (UnimplementedKviServiceServer) ZRem(context.Context, *ZRemRequest) (*ZRemResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ZRem not implemented")
+}
+func (UnimplementedKviServiceServer) ZRangeByScore(context.Context, *ZRangeByScoreRequest) (*ZRangeByScoreResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ZRangeByScore not implemented")
+}
+func (UnimplementedKviServiceServer) ZRank(context.Context, *ZRankRequest) (*ZRankResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ZRank not implemented")
+}
+func (UnimplementedKviServiceServer) Lock(context.Context, *LockRequest) (*LockResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Lock not implemented")
+}
+func (UnimplementedKviServiceServer) Unlock(context.Context, *UnlockRequest) (*UnlockResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Unlock not implemented")
+}
 func (UnimplementedKviServiceServer) Stream(grpc.BidiStreamingServer[StreamRequest, StreamResponse]) error {
 	return status.Error(codes.Unimplemented, "method Stream not implemented")
 }
@@ -193,6 +621,280 @@ func _KviService_VectorSearch_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _KviService_Stats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KviServiceServer).Stats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KviService_Stats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KviServiceServer).Stats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KviService_Scan_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ScanRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KviServiceServer).Scan(m, &grpc.GenericServerStream[ScanRequest, ScanResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_ScanServer = grpc.ServerStreamingServer[ScanResponse]
+
+func _KviService_Changes_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChangesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KviServiceServer).Changes(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KviService_Changes_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KviServiceServer).Changes(ctx, req.(*ChangesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KviService_LiveQuery_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(LiveQueryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KviServiceServer).LiveQuery(m, &grpc.GenericServerStream[LiveQueryRequest, LiveQueryResult]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_LiveQueryServer = grpc.ServerStreamingServer[LiveQueryResult]
+
+func _KviService_BatchGet_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchGetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KviServiceServer).BatchGet(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KviService_BatchGet_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KviServiceServer).BatchGet(ctx, req.(*BatchGetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KviService_BatchDelete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchDeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KviServiceServer).BatchDelete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KviService_BatchDelete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KviServiceServer).BatchDelete(ctx, req.(*BatchDeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KviService_BatchGetStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KviServiceServer).BatchGetStream(&grpc.GenericServerStream[BatchGetRequest, BatchGetResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_BatchGetStreamServer = grpc.BidiStreamingServer[BatchGetRequest, BatchGetResponse]
+
+func _KviService_BatchDeleteStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KviServiceServer).BatchDeleteStream(&grpc.GenericServerStream[BatchDeleteRequest, BatchDeleteResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_BatchDeleteStreamServer = grpc.BidiStreamingServer[BatchDeleteRequest, BatchDeleteResponse]
+
+func _KviService_Watch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KviServiceServer).Watch(m, &grpc.GenericServerStream[WatchRequest, WatchEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_WatchServer = grpc.ServerStreamingServer[WatchEvent]
+
+func _KviService_Snapshot_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SnapshotRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KviServiceServer).Snapshot(m, &grpc.GenericServerStream[SnapshotRequest, SnapshotChunk]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_SnapshotServer = grpc.ServerStreamingServer[SnapshotChunk]
+
+func _KviService_Restore_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(KviServiceServer).Restore(&grpc.GenericServerStream[RestoreChunk, RestoreResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_RestoreServer = grpc.ClientStreamingServer[RestoreChunk, RestoreResponse]
+
+func _KviService_Replicate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ReplicateRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(KviServiceServer).Replicate(m, &grpc.GenericServerStream[ReplicateRequest, ReplicationEntry]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type KviService_ReplicateServer = grpc.ServerStreamingServer[ReplicationEntry]
+
+func _KviService_ZAdd_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ZAddRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KviServiceServer).ZAdd(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KviService_ZAdd_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KviServiceServer).ZAdd(ctx, req.(*ZAddRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KviService_ZIncrBy_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ZIncrByRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KviServiceServer).ZIncrBy(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KviService_ZIncrBy_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KviServiceServer).ZIncrBy(ctx, req.(*ZIncrByRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KviService_ZRem_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ZRemRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KviServiceServer).ZRem(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KviService_ZRem_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KviServiceServer).ZRem(ctx, req.(*ZRemRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KviService_ZRangeByScore_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ZRangeByScoreRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KviServiceServer).ZRangeByScore(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KviService_ZRangeByScore_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KviServiceServer).ZRangeByScore(ctx, req.(*ZRangeByScoreRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KviService_ZRank_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ZRankRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KviServiceServer).ZRank(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KviService_ZRank_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KviServiceServer).ZRank(ctx, req.(*ZRankRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KviService_Lock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KviServiceServer).Lock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KviService_Lock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KviServiceServer).Lock(ctx, req.(*LockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KviService_Unlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KviServiceServer).Unlock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KviService_Unlock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KviServiceServer).Unlock(ctx, req.(*UnlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _KviService_Stream_Handler(srv interface{}, stream grpc.ServerStream) error {
 	return srv.(KviServiceServer).Stream(&grpc.GenericServerStream[StreamRequest, StreamResponse]{ServerStream: stream})
 }
@@ -219,8 +921,94 @@ var KviService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "VectorSearch",
 			Handler:    _KviService_VectorSearch_Handler,
 		},
+		{
+			MethodName: "Stats",
+			Handler:    _KviService_Stats_Handler,
+		},
+		{
+			MethodName: "Changes",
+			Handler:    _KviService_Changes_Handler,
+		},
+		{
+			MethodName: "BatchGet",
+			Handler:    _KviService_BatchGet_Handler,
+		},
+		{
+			MethodName: "BatchDelete",
+			Handler:    _KviService_BatchDelete_Handler,
+		},
+		{
+			MethodName: "ZAdd",
+			Handler:    _KviService_ZAdd_Handler,
+		},
+		{
+			MethodName: "ZIncrBy",
+			Handler:    _KviService_ZIncrBy_Handler,
+		},
+		{
+			MethodName: "ZRem",
+			Handler:    _KviService_ZRem_Handler,
+		},
+		{
+			MethodName: "ZRangeByScore",
+			Handler:    _KviService_ZRangeByScore_Handler,
+		},
+		{
+			MethodName: "ZRank",
+			Handler:    _KviService_ZRank_Handler,
+		},
+		{
+			MethodName: "Lock",
+			Handler:    _KviService_Lock_Handler,
+		},
+		{
+			MethodName: "Unlock",
+			Handler:    _KviService_Unlock_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Scan",
+			Handler:       _KviService_Scan_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "LiveQuery",
+			Handler:       _KviService_LiveQuery_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "BatchGetStream",
+			Handler:       _KviService_BatchGetStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "BatchDeleteStream",
+			Handler:       _KviService_BatchDeleteStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Watch",
+			Handler:       _KviService_Watch_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Snapshot",
+			Handler:       _KviService_Snapshot_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Restore",
+			Handler:       _KviService_Restore_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "Replicate",
+			Handler:       _KviService_Replicate_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "Stream",
 			Handler:       _KviService_Stream_Handler,
@@ -0,0 +1,57 @@
+package kvi_grpc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// WithConnLimits reports the pools the limits interceptors count into (see
+// Middleware) from the Stats call.
+func WithConnLimits(conns, streams *stats.Pool) func(*GrpcServer) {
+	return func(s *GrpcServer) { s.conns, s.streams = conns, streams }
+}
+
+// subscriptions are the calls that last as long as the client wants; they
+// count as streams rather than connections.
+var subscriptions = map[string]bool{
+	KviService_Watch_FullMethodName:            true,
+	KviService_Stream_FullMethodName:           true,
+	KviService_Replicate_FullMethodName:        true,
+	KviService_LiveQuery_FullMethodName:        true,
+	grpc_health_v1.Health_Watch_FullMethodName: true,
+}
+
+// limitPool is the pool method's calls count in.
+func (m Middleware) limitPool(method string) *stats.Pool {
+	if subscriptions[method] {
+		return m.Streams
+	}
+	return m.Conns
+}
+
+func (m Middleware) limitUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	pool := m.limitPool(info.FullMethod)
+	if !pool.Acquire() {
+		return nil, connLimitError(pool)
+	}
+	defer pool.Release()
+	return handler(ctx, req)
+}
+
+func (m Middleware) limitStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	pool := m.limitPool(info.FullMethod)
+	if !pool.Acquire() {
+		return connLimitError(pool)
+	}
+	defer pool.Release()
+	return handler(srv, ss)
+}
+
+func connLimitError(pool *stats.Pool) error {
+	return toStatus(fmt.Errorf("%w (limit %d)", types.ErrConnectionLimit, pool.Snapshot().Limit))
+}
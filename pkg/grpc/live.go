@@ -0,0 +1,90 @@
+package kvi_grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/thirawat27/kvi/internal/sql"
+	"github.com/thirawat27/kvi/pkg/auth"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// WithLiveQueries serves LiveQuery calls from lq, so a client's limit is
+// shared with the other servers using it. Without the option the server
+// runs its own, with the default debounce and limit.
+func WithLiveQueries(lq *sql.LiveQueries) func(*GrpcServer) {
+	return func(s *GrpcServer) { s.liveQueries = lq }
+}
+
+// LiveQuery streams a SELECT's result, then each new result as writes
+// change it.
+func (s *GrpcServer) LiveQuery(req *LiveQueryRequest, stream KviService_LiveQueryServer) error {
+	if s.liveQueries == nil {
+		return status.Error(codes.Unimplemented, "this engine has no change feed")
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel() // ends the live query
+	results, err := s.liveQueries.Subscribe(ctx, callClient(ctx), req.Query)
+	if errors.Is(err, sql.ErrTooManyLiveQueries) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-s.stopping:
+			return errShuttingDown
+		case res, open := <-results:
+			if !open {
+				if err := ctx.Err(); err != nil {
+					return status.FromContextError(err).Err()
+				}
+				return nil
+			}
+			if res.Err != nil {
+				return toStatus(res.Err)
+			}
+			msg := &LiveQueryResult{Seq: res.Seq, Columns: res.Result.Columns}
+			for _, rec := range liveRecords(res.Result.Value) {
+				msg.Records = append(msg.Records, recordResponse(rec))
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// liveRecords lists the records in a SELECT's result.
+func liveRecords(v interface{}) []*types.Record {
+	switch v := v.(type) {
+	case *types.Record:
+		return []*types.Record{v}
+	case []*types.Record:
+		return v
+	}
+	return nil
+}
+
+// callClient names the client making a call, as the REST API's rate
+// limits do: by token subject, or else by address.
+func callClient(ctx context.Context) string {
+	if claims, ok := auth.FromContext(ctx); ok && claims.Subject != "" {
+		return "sub:" + claims.Subject
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		host, _, err := net.SplitHostPort(p.Addr.String())
+		if err != nil {
+			host = p.Addr.String()
+		}
+		return "ip:" + host
+	}
+	return ""
+}
@@ -0,0 +1,31 @@
+package kvi_grpc
+
+import (
+	"context"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/lock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *GrpcServer) Lock(ctx context.Context, req *LockRequest) (*LockResponse, error) {
+	if req.Key == "" || req.Owner == "" || req.TtlMs <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "key, owner and a positive ttl_ms are required")
+	}
+	token, err := lock.Lock(ctx, s.engine, req.Key, req.Owner, time.Duration(req.TtlMs)*time.Millisecond)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &LockResponse{Token: token}, nil
+}
+
+func (s *GrpcServer) Unlock(ctx context.Context, req *UnlockRequest) (*UnlockResponse, error) {
+	if req.Key == "" || req.Token == 0 {
+		return nil, status.Error(codes.InvalidArgument, "key and token are required")
+	}
+	if err := lock.Unlock(ctx, s.engine, req.Key, req.Token); err != nil {
+		return nil, toStatus(err)
+	}
+	return &UnlockResponse{}, nil
+}
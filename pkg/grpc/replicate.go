@@ -0,0 +1,61 @@
+package kvi_grpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Replicate ships the engine's WAL to a follower. Unlike Watch, the
+// heartbeat goes out every watchHeartbeat however busy the stream is, so
+// the follower always knows how far behind it is.
+func (s *GrpcServer) Replicate(req *ReplicateRequest, stream KviService_ReplicateServer) error {
+	shipper, ok := s.engine.(types.LogShipper)
+	if !ok {
+		return status.Error(codes.Unimplemented, "this engine does not ship its WAL")
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel() // releases the WAL-side follower
+	entries, err := shipper.Ship(ctx, req.AfterLsn)
+	if errors.Is(err, errors.ErrUnsupported) {
+		return status.Error(codes.Unimplemented, err.Error())
+	}
+	if err != nil {
+		return toStatus(err)
+	}
+
+	heartbeat := time.NewTicker(s.watchHeartbeat)
+	defer heartbeat.Stop()
+	last := req.AfterLsn
+	for {
+		var msg *ReplicationEntry
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-s.stopping:
+			return errShuttingDown
+		case entry, ok := <-entries:
+			if !ok {
+				if err := ctx.Err(); err != nil {
+					return status.FromContextError(err).Err()
+				}
+				return status.Errorf(codes.Aborted, "replication fell behind or the engine closed; resume after LSN %d", last)
+			}
+			last = entry.Seq
+			msg = &ReplicationEntry{Lsn: entry.Seq, Op: strings.ToLower(string(entry.Op)), Key: entry.Key}
+			if entry.Record != nil {
+				msg.Record = recordResponse(entry.Record)
+			}
+		case <-heartbeat.C:
+			msg = &ReplicationEntry{Lsn: shipper.LSN(), Op: "heartbeat"}
+		}
+		if err := stream.Send(msg); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package kvi_grpc
+
+import (
+	"context"
+	"encoding/base64"
+
+	"github.com/thirawat27/kvi/pkg/shadow"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultMaxScanRows is how many records one Scan call returns at most
+	// before handing back a resume token.
+	DefaultMaxScanRows = 10000
+	// scanBatch is how many records each ScanResponse carries.
+	scanBatch = 256
+)
+
+// Scan streams the records under req.Prefix as the engine yields them, so
+// the server holds at most one message of records per call however large
+// the result. A scan cut short by the row cap or req.Limit ends with a
+// resume token; the iteration stops as soon as the client goes away. A
+// tag scans only the records with that tag; fields and include_vector
+// project each record down to what the client asked for.
+func (s *GrpcServer) Scan(req *ScanRequest, stream KviService_ScanServer) error {
+	var proj *types.Projection
+	if len(req.Fields) > 0 || req.IncludeVector != nil {
+		proj = &types.Projection{Fields: req.Fields, Vector: req.IncludeVector == nil || *req.IncludeVector}
+	}
+	scan := s.engine.Scan
+	if ps, ok := s.engine.(types.ProjectingScanner); ok && proj != nil {
+		scan = func(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+			return ps.ScanProjected(ctx, prefix, proj, fn)
+		}
+	}
+	if req.Tag != "" {
+		ts, ok := s.engine.(types.TagScanner)
+		if !ok {
+			return status.Error(codes.Unimplemented, "engine has no tag index")
+		}
+		scan = func(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+			return ts.ScanTag(ctx, req.Tag, prefix, proj.Wrap(fn))
+		}
+	}
+	var after string
+	if req.ResumeToken != "" {
+		key, err := base64.RawURLEncoding.DecodeString(req.ResumeToken)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, "invalid resume token")
+		}
+		after = string(key)
+	}
+	limit := s.maxScanRows
+	if req.Limit > 0 && (limit == 0 || int(req.Limit) < limit) {
+		limit = int(req.Limit)
+	}
+
+	var (
+		batch   = make([]*GetResponse, 0, scanBatch)
+		sent    int
+		lastKey string
+		more    bool
+		sendErr error
+		// What the shadow is to compare, if anything
+		compared []*types.Record
+		compare  = s.shadow != nil && req.Tag == "" && req.Start == "" && req.End == "" && after == ""
+	)
+	err := scan(stream.Context(), req.Prefix, func(rec *types.Record) bool {
+		if (after != "" && rec.ID <= after) || rec.ID < req.Start {
+			return true
+		}
+		if req.End != "" && rec.ID >= req.End {
+			return false
+		}
+		if limit > 0 && sent == limit {
+			more = true
+			return false
+		}
+		select {
+		case <-s.stopping:
+			sendErr = errShuttingDown
+			return false
+		default:
+		}
+		batch = append(batch, recordResponse(rec))
+		if compare {
+			compared = append(compared, rec)
+		}
+		sent++
+		lastKey = rec.ID
+		if len(batch) == scanBatch {
+			// Send may still hold the message, so each gets its own slice
+			sendErr = stream.Send(&ScanResponse{Records: batch})
+			batch = make([]*GetResponse, 0, scanBatch)
+		}
+		return sendErr == nil
+	})
+	if sendErr != nil {
+		return sendErr
+	}
+	if err != nil {
+		return toStatus(err)
+	}
+
+	last := &ScanResponse{Records: batch, Last: true}
+	if more {
+		last.ResumeToken = base64.RawURLEncoding.EncodeToString([]byte(lastKey))
+	}
+	if compare {
+		s.shadow.Scan(shadow.ScanPage{Prefix: req.Prefix, After: after, Projection: proj, Records: compared, Truncated: more})
+	}
+	return stream.Send(last)
+}
+
+// Changes lists the keys changed after req.Since, capped at the server's
+// row cap like Scan; the page runs past it only for changes at the same
+// time as its last.
+func (s *GrpcServer) Changes(ctx context.Context, req *ChangesRequest) (*ChangesResponse, error) {
+	cs, ok := s.engine.(types.ChangeScanner)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "engine has no change index")
+	}
+	limit := s.maxScanRows
+	if req.Limit > 0 && (limit == 0 || int(req.Limit) < limit) {
+		limit = int(req.Limit)
+	}
+	changes, next, err := cs.ScanSince(ctx, req.Since, limit)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &ChangesResponse{Changes: make([]*ChangeEntry, len(changes)), Next: next}
+	for i, change := range changes {
+		resp.Changes[i] = &ChangeEntry{Key: change.Key, At: change.At, Deleted: change.Deleted}
+		if change.Record != nil {
+			resp.Changes[i].Record = recordResponse(change.Record)
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,68 @@
+package kvi_grpc
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultDrainTimeout is how long StartGRPCServer lets in-flight calls
+// finish on shutdown before cutting them off.
+const DefaultDrainTimeout = 10 * time.Second
+
+// errShuttingDown ends Watch, Replicate and Stream calls when the server stops, so
+// clients reconnect elsewhere instead of holding shutdown up.
+var errShuttingDown = status.Error(codes.Unavailable, "server is shutting down")
+
+// WithDrain tunes shutdown. For delay the server keeps serving while its
+// health service reports NOT_SERVING, giving load balancers time to stop
+// sending calls; then in-flight calls get up to timeout to finish.
+func WithDrain(delay, timeout time.Duration) func(*GrpcServer) {
+	return func(s *GrpcServer) {
+		s.drainDelay = delay
+		s.drainTimeout = timeout
+	}
+}
+
+// StartGRPCServer serves srv, along with the standard grpc.health.v1
+// service, on lis until ctx is cancelled. It then drains (see WithDrain):
+// health turns NOT_SERVING, new calls are refused after the delay, streams
+// are ended, and it returns once in-flight unary calls finish or the drain
+// timeout cuts them off. A server can be started once.
+func StartGRPCServer(ctx context.Context, lis net.Listener, srv *GrpcServer, opts ...grpc.ServerOption) error {
+	gs := grpc.NewServer(opts...)
+	RegisterKviServiceServer(gs, srv)
+	healthpb.RegisterHealthServer(gs, &healthServer{srv: srv})
+
+	served := make(chan error, 1)
+	go func() { served <- gs.Serve(lis) }()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	close(srv.draining)
+	time.Sleep(srv.drainDelay)
+	close(srv.stopping)
+
+	stopped := make(chan struct{})
+	go func() {
+		gs.GracefulStop()
+		close(stopped)
+	}()
+	if srv.drainTimeout > 0 {
+		select {
+		case <-stopped:
+		case <-time.After(srv.drainTimeout):
+			gs.Stop()
+		}
+	}
+	<-stopped
+	return <-served
+}
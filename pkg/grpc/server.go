@@ -3,90 +3,387 @@ package kvi_grpc
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/internal/sql"
+	"github.com/thirawat27/kvi/pkg/auth"
+	"github.com/thirawat27/kvi/pkg/keygen"
+	"github.com/thirawat27/kvi/pkg/shadow"
+	"github.com/thirawat27/kvi/pkg/stats"
 	"github.com/thirawat27/kvi/pkg/types"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
+// DefaultWatchHeartbeat is how long a Watch stream may stay silent before
+// the server sends a heartbeat.
+const DefaultWatchHeartbeat = 15 * time.Second
+
 type GrpcServer struct {
 	UnimplementedKviServiceServer
-	engine types.Engine
-	hub    *pubsub.Hub
+	engine         types.Engine
+	hub            *pubsub.Hub
+	startTime      time.Time
+	watchHeartbeat time.Duration
+	stopping       chan struct{} // closed by StartGRPCServer to end long-lived streams
+	draining       chan struct{} // closed when shutdown begins; health turns NOT_SERVING
+	drainDelay     time.Duration
+	drainTimeout   time.Duration
+	calls          *stats.Calls
+	conns          *stats.Pool
+	streams        *stats.Pool
+	maxBatch       int
+	maxScanRows    int
+	replicaStats   func() *stats.ReplicationStats
+	cdcStats       func() *stats.CDCStats
+	shadow         *shadow.Shadow
+	liveQueries    *sql.LiveQueries // nil if the engine has no change feed
+	keys           keygen.Generator
+	restoring      sync.Mutex
+	log            *slog.Logger
 }
 
-func NewGrpcServer(eng types.Engine, hub *pubsub.Hub) *GrpcServer {
-	return &GrpcServer{
-		engine: eng,
-		hub:    hub,
+func NewGrpcServer(eng types.Engine, hub *pubsub.Hub, opts ...func(*GrpcServer)) *GrpcServer {
+	s := &GrpcServer{
+		engine:         eng,
+		hub:            hub,
+		startTime:      time.Now(),
+		watchHeartbeat: DefaultWatchHeartbeat,
+		stopping:       make(chan struct{}),
+		draining:       make(chan struct{}),
+		drainTimeout:   DefaultDrainTimeout,
+		maxBatch:       DefaultMaxBatch,
+		maxScanRows:    DefaultMaxScanRows,
+		keys:           keygen.NewULID(),
+		log:            slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.liveQueries == nil {
+		s.liveQueries, _ = sql.NewLiveQueries(eng, sql.DefaultLiveDebounce, sql.DefaultLivePerClient)
 	}
+	return s
+}
+
+// WithLogger sets where the server logs failures outside any one call's
+// result; the access log is the Middleware's.
+func WithLogger(l *slog.Logger) func(*GrpcServer) {
+	return func(s *GrpcServer) { s.log = l }
+}
+
+// WithCalls reports the counters the metrics interceptor records into
+// calls (see Interceptors) from the Stats call.
+func WithCalls(calls *stats.Calls) func(*GrpcServer) {
+	return func(s *GrpcServer) { s.calls = calls }
+}
+
+// WithWatchHeartbeat sets how often idle Watch streams get a heartbeat.
+func WithWatchHeartbeat(d time.Duration) func(*GrpcServer) {
+	return func(s *GrpcServer) { s.watchHeartbeat = d }
+}
+
+// WithMaxBatch sets how many keys one BatchGet or BatchDelete message may
+// carry; larger requests fail with INVALID_ARGUMENT. 0 removes the limit.
+func WithMaxBatch(n int) func(*GrpcServer) {
+	return func(s *GrpcServer) { s.maxBatch = n }
+}
+
+// WithMaxScanRows sets how many records one Scan call returns before
+// handing back a resume token. 0 removes the cap.
+func WithMaxScanRows(n int) func(*GrpcServer) {
+	return func(s *GrpcServer) { s.maxScanRows = n }
+}
+
+// WithReplicaStats reports a follower's replication from the Stats call.
+func WithReplicaStats(fn func() *stats.ReplicationStats) func(*GrpcServer) {
+	return func(s *GrpcServer) { s.replicaStats = fn }
+}
+
+// WithCDCStats reports change data capture from the Stats call.
+func WithCDCStats(fn func() *stats.CDCStats) func(*GrpcServer) {
+	return func(s *GrpcServer) { s.cdcStats = fn }
+}
+
+// WithShadow compares Gets and Scans against sh's shadow source and
+// reports it from the Stats call. Scans by tag or key range are not
+// compared.
+func WithShadow(sh *shadow.Shadow) func(*GrpcServer) {
+	return func(s *GrpcServer) { s.shadow = sh }
+}
+
+// WithKeyGenerator sets what makes up the keys of records put with an
+// empty or "auto" key; ULIDs by default.
+func WithKeyGenerator(g keygen.Generator) func(*GrpcServer) {
+	return func(s *GrpcServer) { s.keys = g }
 }
 
 func (s *GrpcServer) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
 	rec, err := s.engine.Get(ctx, req.Key)
+	if s.shadow != nil && (err == nil || errors.Is(err, types.ErrKeyNotFound)) {
+		s.shadow.Get(req.Key, rec)
+	}
 	if err != nil {
-		return nil, status.Error(codes.NotFound, err.Error())
+		return nil, toStatus(err)
 	}
+	return recordResponse(rec), nil
+}
 
+// recordResponse is rec as Get returns it.
+func recordResponse(rec *types.Record) *GetResponse {
 	dataBytes, _ := json.Marshal(rec.Data)
 
-	return &GetResponse{
-		Id:       rec.ID,
-		DataJson: string(dataBytes),
-	}, nil
+	resp := &GetResponse{
+		Id:          rec.ID,
+		DataJson:    string(dataBytes),
+		Version:     rec.Version,
+		Vector:      rec.Vector,
+		Blob:        rec.Blob,
+		ContentType: rec.ContentType,
+		Tags:        rec.Tags,
+		CreatedAt:   timestamp(rec.CreatedAt),
+		UpdatedAt:   timestamp(rec.UpdatedAt),
+	}
+	if secs, ok := rec.SecondsLeft(time.Now()); ok {
+		resp.ExpiresInSeconds = secs
+		resp.ExpiresAt = timestamppb.New(*rec.TTL)
+	}
+	return resp
 }
 
 func (s *GrpcServer) Put(ctx context.Context, req *PutRequest) (*PutResponse, error) {
 	var data map[string]interface{}
-	if err := json.Unmarshal([]byte(req.DataJson), &data); err != nil {
-		return nil, status.Error(codes.InvalidArgument, "invalid json data")
+	if req.DataJson != "" || len(req.Blob) == 0 {
+		if err := json.Unmarshal([]byte(req.DataJson), &data); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid json data")
+		}
+	}
+	if slices.Contains(req.Tags, "") {
+		return nil, status.Error(codes.InvalidArgument, "tags must not be empty")
+	}
+	key := req.Key
+	if req.KeyPrefix != "" && !keygen.IsAuto(key) {
+		return nil, status.Error(codes.InvalidArgument, "key_prefix needs an empty or auto key")
+	}
+	syncer, _ := s.engine.(types.Syncer)
+	if req.Durable && syncer == nil {
+		return nil, status.Error(codes.Unimplemented, "this engine has no WAL to make writes durable in")
+	}
+	if keygen.IsAuto(key) {
+		var err error
+		if key, err = s.keys.Key(ctx, req.KeyPrefix); err != nil {
+			return nil, toStatus(err)
+		}
 	}
 
 	record := &types.Record{
-		ID:   req.Key,
-		Data: data,
+		ID:          key,
+		Data:        data,
+		Vector:      req.Vector,
+		Blob:        req.Blob,
+		ContentType: req.ContentType,
+		Tags:        req.Tags,
+	}
+	if ttl := putTTL(req); ttl > 0 {
+		expires := time.Now().Add(ttl)
+		record.TTL = &expires
 	}
 
-	if err := s.engine.Put(ctx, req.Key, record); err != nil {
-		return nil, status.Error(codes.Internal, err.Error())
+	if err := s.engine.Put(ctx, key, record); err != nil {
+		return nil, toStatus(err)
+	}
+	if req.Durable {
+		if err := syncer.Sync(ctx); err != nil {
+			return nil, toStatus(err)
+		}
 	}
 
-	return &PutResponse{Success: true}, nil
+	// Put stamped the stored version onto record
+	return &PutResponse{Success: true, Version: record.Version, Key: key}, nil
+}
+
+// putTTL is how long a put record lives: ttl_ms if set, else ttl_seconds.
+func putTTL(req *PutRequest) time.Duration {
+	if req.TtlMs > 0 {
+		return time.Duration(req.TtlMs) * time.Millisecond
+	}
+	return time.Duration(req.TtlSeconds) * time.Second
+}
+
+// timestamp converts t, leaving the zero time unset.
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
 }
 
 func (s *GrpcServer) VectorSearch(ctx context.Context, req *VectorSearchRequest) (*VectorSearchResponse, error) {
-	// Not fully implemented interface, but stubbed logically
-	return nil, status.Error(codes.Unimplemented, "Vector search gRPC pending interface link")
+	searcher, ok := s.engine.(types.Searcher)
+	if !ok {
+		return nil, status.Error(codes.Unimplemented, "engine has no vector index")
+	}
+	if req.K <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "k must be positive")
+	}
+	var recs []*types.Record
+	var err error
+	if req.FilterJson != "" {
+		var filter map[string]interface{}
+		if err := json.Unmarshal([]byte(req.FilterJson), &filter); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "filter_json: %v", err)
+		}
+		fs, ok := s.engine.(types.FilteredSearcher)
+		if !ok {
+			return nil, status.Error(codes.Unimplemented, "engine cannot filter vector searches")
+		}
+		recs, err = fs.SearchFiltered(ctx, req.Vector, int(req.K), filter)
+	} else {
+		recs, err = searcher.Search(ctx, req.Vector, int(req.K))
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &VectorSearchResponse{Results: make([]*VectorSearchResponse_Result, len(recs))}
+	for i, rec := range recs {
+		dataBytes, _ := json.Marshal(rec.Data)
+		resp.Results[i] = &VectorSearchResponse_Result{Id: rec.ID, DataJson: string(dataBytes)}
+	}
+	return resp, nil
+}
+
+// Stats returns the same report as the REST stats endpoint, JSON-encoded.
+func (s *GrpcServer) Stats(ctx context.Context, req *StatsRequest) (*StatsResponse, error) {
+	report := stats.Collect(s.engine, s.hub, s.startTime)
+	report.GRPC = s.calls.Snapshot()
+	report.Connections, report.Streams = s.conns.Snapshot(), s.streams.Snapshot()
+	if s.replicaStats != nil {
+		report.Replication = s.replicaStats()
+	}
+	if s.cdcStats != nil {
+		report.CDC = s.cdcStats()
+	}
+	if s.shadow != nil {
+		report.Shadow = s.shadow.Stats()
+		report.Shadow.Samples = nil
+	}
+	if s.liveQueries != nil {
+		report.LiveQueries = s.liveQueries.Stats()
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &StatsResponse{SchemaVersion: stats.SchemaVersion, ReportJson: string(data)}, nil
+}
+
+// Watch streams the engine's change feed for a prefix, with a heartbeat
+// whenever it has been quiet for watchHeartbeat.
+func (s *GrpcServer) Watch(req *WatchRequest, stream KviService_WatchServer) error {
+	watcher, ok := s.engine.(types.Watcher)
+	if !ok {
+		return status.Error(codes.Unimplemented, "this engine has no change feed")
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel() // releases the engine-side watcher
+	events, err := watcher.Watch(ctx, req.Prefix, req.FromSeq)
+	if err != nil {
+		return toStatus(err)
+	}
+
+	heartbeat := time.NewTicker(s.watchHeartbeat)
+	defer heartbeat.Stop()
+	last := req.FromSeq
+	for {
+		var ev *WatchEvent
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-s.stopping:
+			return errShuttingDown
+		case change, ok := <-events:
+			if !ok {
+				if err := ctx.Err(); err != nil {
+					return status.FromContextError(err).Err()
+				}
+				return status.Errorf(codes.Aborted, "watch fell behind or the engine closed; resume after seq %d", last)
+			}
+			last = change.Seq
+			ev = &WatchEvent{Seq: change.Seq, Op: strings.ToLower(string(change.Op)), Key: change.Key}
+			if change.Record != nil {
+				ev.Record = recordResponse(change.Record)
+			}
+			heartbeat.Reset(s.watchHeartbeat)
+		case <-heartbeat.C:
+			ev = &WatchEvent{Seq: last, Op: "heartbeat"}
+		}
+		if err := stream.Send(ev); err != nil {
+			return err
+		}
+	}
 }
 
 // Stream Handles bidirectional streaming for pub/sub operations
 func (s *GrpcServer) Stream(stream KviService_StreamServer) error {
+	if s.hub == nil {
+		return status.Error(codes.Unimplemented, "pub/sub is disabled on this server")
+	}
 	ctx := stream.Context()
-	var clientID string
+	errChan := make(chan error, 2) // from the sender and the reader
+
+	// Read stream from client, here rather than in the handler so shutdown
+	// never waits on a Recv
+	reqs := make(chan *StreamRequest)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err == io.EOF {
+				errChan <- nil
+				return
+			}
+			if err != nil {
+				s.log.Warn("stream receive failed", "method", "Stream", "err", err)
+				errChan <- nil
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
 
 	// Receive the first registration message
-	req, err := stream.Recv()
-	if err != nil {
+	var req *StreamRequest
+	select {
+	case req = <-reqs:
+	case err := <-errChan:
 		return err
+	case <-s.stopping:
+		return errShuttingDown
 	}
 
-	clientID = req.Id
+	clientID := req.Id
 	if clientID == "" {
 		clientID = fmt.Sprintf("anon-%d", ctx.Value("anonymous")) // simplified
 	}
 
 	var sub *pubsub.Subscriber
 	if req.Channel != "" {
-		sub = s.hub.Subscribe(req.Channel, clientID)
-		defer s.hub.Unsubscribe(req.Channel, clientID)
+		sub = s.hub.SubscribeGroup(req.Channel, req.Group, clientID)
+		defer sub.Unsubscribe()
 	}
 
-	errChan := make(chan error, 1)
-
 	// Goroutine to send messages back to the client
 	go func() {
 		if sub != nil {
@@ -97,8 +394,11 @@ func (s *GrpcServer) Stream(stream KviService_StreamServer) error {
 					return
 				}
 				resp := &StreamResponse{
-					Channel: msg.Channel,
-					Payload: msg.Payload,
+					Channel:     msg.Channel,
+					Payload:     msg.Payload,
+					Id:          msg.ID,
+					AckToken:    msg.AckToken,
+					ContentType: msg.ContentType,
 				}
 				if err := stream.Send(resp); err != nil {
 					errChan <- err
@@ -108,21 +408,43 @@ func (s *GrpcServer) Stream(stream KviService_StreamServer) error {
 		}
 	}()
 
-	// Read stream from client
 	for {
-		req, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Printf("Bidi Stream error: %v", err)
-			break
+		select {
+		case req := <-reqs:
+			if err := s.handleStreamRequest(ctx, sub, req); err != nil {
+				return err
+			}
+		case err := <-errChan:
+			return err
+		case <-s.stopping:
+			return errShuttingDown
 		}
+	}
+}
 
-		if req.PublishPayload != "" {
-			s.hub.Publish(req.Channel, req.PublishPayload)
+// handleStreamRequest applies one message from a Stream client: an ack
+// and/or a publish.
+func (s *GrpcServer) handleStreamRequest(ctx context.Context, sub *pubsub.Subscriber, req *StreamRequest) error {
+	if req.AckToken != "" {
+		if sub != nil && req.Channel == "" {
+			sub.Ack(req.AckToken)
+		} else {
+			s.hub.Ack(req.Channel, req.AckToken)
 		}
 	}
 
+	if req.PublishPayload != "" {
+		if err := auth.Check(ctx, auth.RoleWrite); err != nil {
+			return status.Error(codes.PermissionDenied, err.Error())
+		}
+		opts := []pubsub.PublishOption{pubsub.WithContentType(req.ContentType)}
+		if req.TtlMs > 0 {
+			opts = append(opts, pubsub.WithTTL(time.Duration(req.TtlMs)*time.Millisecond))
+		}
+		if req.DeliverAtMs > 0 {
+			opts = append(opts, pubsub.WithDeliverAt(time.UnixMilli(req.DeliverAtMs)))
+		}
+		s.hub.Publish(req.Channel, req.PublishPayload, opts...)
+	}
 	return nil
 }
@@ -0,0 +1,78 @@
+package kvi_grpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/thirawat27/kvi/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func traceUnary(t *tracing.Tracer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, span := startCall(ctx, t, info.FullMethod)
+		resp, err := handler(ctx, req)
+		endCall(span, err)
+		return resp, err
+	}
+}
+
+func traceStream(t *tracing.Tracer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, span := startCall(ss.Context(), t, info.FullMethod)
+		err := handler(srv, &authedStream{ServerStream: ss, ctx: ctx})
+		endCall(span, err)
+		return err
+	}
+}
+
+// startCall starts the server span of a call to method, named for it as
+// "kvi.KviService/Get".
+func startCall(ctx context.Context, t *tracing.Tracer, method string) (context.Context, trace.Span) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx = propagation.TraceContext{}.Extract(ctx, metadataCarrier(md))
+	name := strings.TrimPrefix(method, "/")
+	service, rpc, _ := strings.Cut(name, "/")
+	return t.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "grpc"),
+			attribute.String("rpc.service", service),
+			attribute.String("rpc.method", rpc),
+		))
+}
+
+func endCall(span trace.Span, err error) {
+	code := status.Code(err)
+	span.SetAttributes(attribute.Int("rpc.grpc.status_code", int(code)))
+	if serverFault(err) {
+		span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+	}
+	span.End()
+}
+
+// metadataCarrier reads trace context from incoming call metadata.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) { metadata.MD(c).Set(key, value) }
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
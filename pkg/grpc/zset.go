@@ -0,0 +1,90 @@
+package kvi_grpc
+
+import (
+	"context"
+	"math"
+
+	"github.com/thirawat27/kvi/pkg/collection"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *GrpcServer) ZAdd(ctx context.Context, req *ZAddRequest) (*ZAddResponse, error) {
+	if req.Key == "" || len(req.Members) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "key and members are required")
+	}
+	members := make([]collection.ZMember, len(req.Members))
+	for i, m := range req.Members {
+		members[i] = collection.ZMember{Member: m.Member, Score: m.Score}
+	}
+	n, err := collection.ZAdd(ctx, s.engine, req.Key, members...)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ZAddResponse{Added: int64(n)}, nil
+}
+
+func (s *GrpcServer) ZIncrBy(ctx context.Context, req *ZIncrByRequest) (*ZIncrByResponse, error) {
+	if req.Key == "" || req.Member == "" {
+		return nil, status.Error(codes.InvalidArgument, "key and member are required")
+	}
+	score, err := collection.ZIncrBy(ctx, s.engine, req.Key, req.Member, req.Delta)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ZIncrByResponse{Score: score}, nil
+}
+
+func (s *GrpcServer) ZRem(ctx context.Context, req *ZRemRequest) (*ZRemResponse, error) {
+	if req.Key == "" || len(req.Members) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "key and members are required")
+	}
+	n, err := collection.ZRem(ctx, s.engine, req.Key, req.Members...)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ZRemResponse{Removed: int64(n)}, nil
+}
+
+func (s *GrpcServer) ZRangeByScore(ctx context.Context, req *ZRangeByScoreRequest) (*ZRangeByScoreResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	if req.Offset < 0 || req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "offset and limit must not be negative")
+	}
+	low, high := math.Inf(-1), math.Inf(1)
+	if req.Min != nil {
+		low = *req.Min
+	}
+	if req.Max != nil {
+		high = *req.Max
+	}
+	if math.IsNaN(low) || math.IsNaN(high) {
+		return nil, status.Error(codes.InvalidArgument, "min and max must be numbers")
+	}
+	members, err := collection.ZRangeByScore(ctx, s.engine, req.Key, low, high, collection.RangeOptions{
+		Offset:  int(req.Offset),
+		Limit:   int(req.Limit),
+		Reverse: req.Reverse,
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	resp := &ZRangeByScoreResponse{Members: make([]*ZMember, len(members))}
+	for i, m := range members {
+		resp.Members[i] = &ZMember{Member: m.Member, Score: m.Score}
+	}
+	return resp, nil
+}
+
+func (s *GrpcServer) ZRank(ctx context.Context, req *ZRankRequest) (*ZRankResponse, error) {
+	if req.Key == "" {
+		return nil, status.Error(codes.InvalidArgument, "key is required")
+	}
+	rank, err := collection.ZRank(ctx, s.engine, req.Key, req.Member, req.Reverse)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &ZRankResponse{Rank: int64(rank)}, nil
+}
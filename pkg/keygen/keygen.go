@@ -0,0 +1,159 @@
+// Package keygen makes up keys for records written without one, such as
+// logged events, whose writers do not care what they are called. Every
+// generator makes keys that sort in the order they were made, so a scan
+// returns such records in the order they were written.
+package keygen
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// Names of the generators, as config gives them.
+const (
+	NameULID     = "ulid"
+	NameUUIDv7   = "uuidv7"
+	NameSequence = "sequence"
+)
+
+// Auto is the key a write gives to have one made up; an empty key does
+// the same.
+const Auto = "auto"
+
+// IsAuto reports whether key asks for a key to be made up.
+func IsAuto(key string) bool { return key == "" || key == Auto }
+
+// Generator makes up keys.
+type Generator interface {
+	// Key returns a key that starts with prefix and sorts after every key
+	// it returned before with the same prefix.
+	Key(ctx context.Context, prefix string) (string, error)
+}
+
+// New returns the generator called name; "" is ULID. A sequence keeps its
+// counters in eng.
+func New(name string, eng types.Engine) (Generator, error) {
+	switch name {
+	case "", NameULID:
+		return NewULID(), nil
+	case NameUUIDv7:
+		return UUIDv7{}, nil
+	case NameSequence:
+		return Sequence{Engine: eng}, nil
+	default:
+		return nil, fmt.Errorf("unknown key generator %q (want ulid, uuidv7 or sequence)", name)
+	}
+}
+
+// ULID makes 26-character ULIDs: 48 bits of Unix milliseconds then 80
+// random bits, in Crockford's base32. Within a millisecond the random
+// bits of each ULID are those of the last plus one, so they still sort in
+// the order they were made.
+type ULID struct {
+	mu     sync.Mutex
+	ms     uint64
+	hi, lo uint64 // the 80 random bits: 16 in hi, 64 in lo
+}
+
+func NewULID() *ULID { return &ULID{} }
+
+// crockford is Crockford's base32 alphabet, in the order of its values.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+func (g *ULID) Key(ctx context.Context, prefix string) (string, error) {
+	ms, hi, lo, err := g.next(uint64(time.Now().UnixMilli()))
+	if err != nil {
+		return "", err
+	}
+	// 128 bits, as 2 bits of padding then 26 groups of 5
+	high := ms<<16 | hi
+	var b [26]byte
+	for i := 25; i >= 0; i-- {
+		b[i] = crockford[lo&31]
+		lo = lo>>5 | high<<59
+		high >>= 5
+	}
+	return prefix + string(b[:]), nil
+}
+
+// next returns the time and random bits of the next ULID at now.
+func (g *ULID) next(now uint64) (ms, hi, lo uint64, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if now > g.ms {
+		var r [10]byte
+		if _, err := rand.Read(r[:]); err != nil {
+			return 0, 0, 0, err
+		}
+		g.ms, g.hi, g.lo = now, uint64(binary.BigEndian.Uint16(r[:2])), binary.BigEndian.Uint64(r[2:])
+		return g.ms, g.hi, g.lo, nil
+	}
+	// The same millisecond, or the clock went back: count on from the last
+	if g.lo++; g.lo == 0 {
+		if g.hi++; g.hi == 1<<16 {
+			g.ms, g.hi = g.ms+1, 0 // all 80 bits used up: borrow the next millisecond
+		}
+	}
+	return g.ms, g.hi, g.lo, nil
+}
+
+// UUIDv7 makes RFC 9562 version 7 UUIDs, which begin with Unix
+// milliseconds and count on within one.
+type UUIDv7 struct{}
+
+func (UUIDv7) Key(ctx context.Context, prefix string) (string, error) {
+	id, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+	return prefix + id.String(), nil
+}
+
+// SequencePrefix is reserved for the records a Sequence keeps its
+// counters in: the one for prefix is SequencePrefix+prefix.
+const SequencePrefix = "kvi:seq:"
+
+// Sequence numbers the keys under each prefix 1, 2, 3, ..., as 20 digits
+// so they sort as numbers. Each prefix's counter is the version of a
+// record in Engine, so the numbers go on across restarts and replicate
+// with the records; deleting that record starts them over.
+type Sequence struct {
+	Engine types.Engine
+}
+
+func (g Sequence) Key(ctx context.Context, prefix string) (string, error) {
+	counter := SequencePrefix + prefix
+	for {
+		rec, err := g.Engine.Update(ctx, counter, func(*types.Record) error { return nil })
+		if err == nil {
+			return fmt.Sprintf("%s%020d", prefix, rec.Version), nil
+		}
+		if !errors.Is(err, types.ErrKeyNotFound) {
+			return "", err
+		}
+		rec = &types.Record{ID: counter, Data: map[string]interface{}{}}
+		err = g.Engine.CompareAndSwap(ctx, counter, 0, rec)
+		if err == nil {
+			return fmt.Sprintf("%s%020d", prefix, rec.Version), nil
+		}
+		if !errors.Is(err, types.ErrVersionMismatch) {
+			return "", err
+		}
+		// Created meanwhile: bump it instead
+	}
+}
+
+var (
+	_ Generator = (*ULID)(nil)
+	_ Generator = UUIDv7{}
+	_ Generator = Sequence{}
+)
@@ -0,0 +1,230 @@
+// Package keys encodes tuples, such as (tenant, timestamp, id), as record
+// keys whose byte order is the order of the tuples, so a B-tree keeps them
+// sorted and a scan by prefix finds every tuple starting with some
+// elements.
+//
+// A tuple is encoded as its elements, one after another. Each element is a
+// tag byte naming its type followed by its value:
+//
+//	'i' int64      16 lowercase hex digits of the value with its sign bit
+//	               flipped, so negative numbers sort first
+//	's' string     the string's bytes, 0x00 written as 0x01 0x01 and 0x01
+//	               as 0x01 0x02, then a 0x00 terminator
+//	't' time.Time  16 hex digits of the Unix seconds, sign bit flipped as
+//	               for int64, then 8 of the nanoseconds
+//	'u' uuid.UUID  32 lowercase hex digits
+//
+// Elements of different types sort by tag: integers, then strings, times
+// and UUIDs. A tuple sorts before any longer tuple it is a prefix of.
+// Encoded keys are valid UTF-8, so they survive JSON, the WAL and every
+// API. This format is stable: keys encoded by one version decode, and
+// sort, the same in every later one.
+package keys
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Tags of the element types, in their sort order.
+const (
+	tagInt    = 'i'
+	tagString = 's'
+	tagTime   = 't'
+	tagUUID   = 'u'
+)
+
+// ErrMalformed is returned by Decode for a key that no tuple encodes to.
+var ErrMalformed = errors.New("malformed tuple key")
+
+// Encode returns the key of tuple. Elements may be strings, which must be
+// valid UTF-8, integers of any size that fits an int64, time.Time values
+// and uuid.UUIDs. Times keep their instant but not their location or
+// monotonic reading.
+func Encode(tuple []interface{}) (string, error) {
+	var b strings.Builder
+	for i, elem := range tuple {
+		if err := appendElem(&b, elem); err != nil {
+			return "", fmt.Errorf("tuple element %d: %w", i, err)
+		}
+	}
+	return b.String(), nil
+}
+
+// MustEncode is Encode that panics on an element it cannot encode, for
+// tuples built from known types.
+func MustEncode(tuple ...interface{}) string {
+	key, err := Encode(tuple)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func appendElem(b *strings.Builder, elem interface{}) error {
+	switch v := elem.(type) {
+	case string:
+		if !utf8.ValidString(v) {
+			return fmt.Errorf("string %q is not valid UTF-8", v)
+		}
+		b.WriteByte(tagString)
+		for i := 0; i < len(v); i++ {
+			switch c := v[i]; c {
+			case 0x00, 0x01:
+				b.WriteByte(0x01)
+				b.WriteByte(c + 1)
+			default:
+				b.WriteByte(c)
+			}
+		}
+		b.WriteByte(0x00)
+	case time.Time:
+		b.WriteByte(tagTime)
+		appendInt(b, v.Unix())
+		fmt.Fprintf(b, "%08x", v.Nanosecond())
+	case uuid.UUID:
+		b.WriteByte(tagUUID)
+		b.WriteString(hex.EncodeToString(v[:]))
+	default:
+		n, err := toInt64(elem)
+		if err != nil {
+			return err
+		}
+		b.WriteByte(tagInt)
+		appendInt(b, n)
+	}
+	return nil
+}
+
+func toInt64(elem interface{}) (int64, error) {
+	switch v := elem.(type) {
+	case int:
+		return int64(v), nil
+	case int8:
+		return int64(v), nil
+	case int16:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint8:
+		return int64(v), nil
+	case uint16:
+		return int64(v), nil
+	case uint32:
+		return int64(v), nil
+	case uint:
+		if uint64(v) <= math.MaxInt64 {
+			return int64(v), nil
+		}
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v), nil
+		}
+	default:
+		return 0, fmt.Errorf("cannot encode %T", elem)
+	}
+	return 0, fmt.Errorf("%v does not fit an int64", elem)
+}
+
+// appendInt writes n as 16 hex digits with the sign bit flipped.
+func appendInt(b *strings.Builder, n int64) {
+	fmt.Fprintf(b, "%016x", uint64(n)^(1<<63))
+}
+
+// Decode returns the tuple key encodes: strings, int64s, time.Times in
+// UTC and uuid.UUIDs.
+func Decode(key string) ([]interface{}, error) {
+	tuple := []interface{}{}
+	for rest := key; rest != ""; {
+		elem, n, err := decodeElem(rest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: element %d of %q: %v", ErrMalformed, len(tuple), key, err)
+		}
+		tuple = append(tuple, elem)
+		rest = rest[n:]
+	}
+	return tuple, nil
+}
+
+// decodeElem decodes the element at the start of s and reports how many
+// bytes it took.
+func decodeElem(s string) (interface{}, int, error) {
+	switch s[0] {
+	case tagInt:
+		n, err := decodeInt(s[1:])
+		return n, 17, err
+	case tagString:
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch c := s[i]; c {
+			case 0x00:
+				return b.String(), i + 1, nil
+			case 0x01:
+				if i+1 == len(s) || (s[i+1] != 0x01 && s[i+1] != 0x02) {
+					return nil, 0, errors.New("bad escape")
+				}
+				i++
+				b.WriteByte(s[i] - 1)
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return nil, 0, errors.New("unterminated string")
+	case tagTime:
+		sec, err := decodeInt(s[1:])
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(s) < 25 {
+			return nil, 0, errors.New("truncated time")
+		}
+		nsec, err := strconv.ParseUint(s[17:25], 16, 32)
+		if err != nil || nsec >= uint64(time.Second) {
+			return nil, 0, errors.New("bad nanoseconds")
+		}
+		return time.Unix(sec, int64(nsec)).UTC(), 25, nil
+	case tagUUID:
+		if len(s) < 33 {
+			return nil, 0, errors.New("truncated uuid")
+		}
+		var id uuid.UUID
+		if _, err := hex.Decode(id[:], []byte(s[1:33])); err != nil || !isLowerHex(s[1:33]) {
+			return nil, 0, errors.New("bad uuid")
+		}
+		return id, 33, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown tag %q", s[0])
+	}
+}
+
+func decodeInt(s string) (int64, error) {
+	if len(s) < 16 || !isLowerHex(s[:16]) {
+		return 0, errors.New("bad integer")
+	}
+	u, err := strconv.ParseUint(s[:16], 16, 64)
+	if err != nil {
+		return 0, errors.New("bad integer")
+	}
+	return int64(u ^ (1 << 63)), nil
+}
+
+// isLowerHex reports whether s is all lowercase hex digits, the only form
+// Encode writes, so every key decodes from exactly one encoding.
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -1,8 +1,16 @@
 package kvi
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
 	"github.com/thirawat27/kvi/internal/engine"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/internal/wal"
 	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/keys"
 	"github.com/thirawat27/kvi/pkg/types"
 )
 
@@ -27,3 +35,88 @@ func OpenDisk(dataDir string) (types.Engine, error) {
 func OpenVector(dim int) (types.Engine, error) {
 	return Open(config.VectorConfig(dim))
 }
+
+// OpenReadOnly opens the data directory of cfg, in disk or hybrid mode,
+// without changing anything in it: the directory and its WAL must already
+// exist, and the engine refuses writes. The shadow source is opened so.
+func OpenReadOnly(cfg *config.Config) (types.Engine, error) {
+	if cfg.Mode != types.ModeDisk && cfg.Mode != types.ModeHybrid {
+		return nil, fmt.Errorf("%s mode keeps nothing in a data directory to read", cfg.Mode)
+	}
+	info, err := os.Stat(cfg.DataDir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", cfg.DataDir)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.DataDir, wal.FileName)); err != nil {
+		return nil, fmt.Errorf("no log to read: %w", err)
+	}
+	ro := *cfg
+	ro.ReadOnly, ro.EnableWAL = true, true
+	return Open(&ro)
+}
+
+// ExpiredChannel is the pub/sub channel PublishExpired publishes to.
+const ExpiredChannel = "__expired__"
+
+// Expiration is the message PublishExpired publishes for each record.
+type Expiration struct {
+	Key    string        `json:"key"`
+	Record *types.Record `json:"record"`
+}
+
+// OnExpire registers fn to be called with a copy of each record eng
+// removes because its TTL ran out, on a pool of workers, at most once per
+// expiry; see types.ExpiryNotifier.
+func OnExpire(eng types.Engine, fn func(key string, rec *types.Record)) error {
+	n, ok := eng.(types.ExpiryNotifier)
+	if !ok {
+		return fmt.Errorf("engine does not report expiries")
+	}
+	n.OnExpire(fn)
+	return nil
+}
+
+// PublishExpired publishes each record eng removes because it expired to
+// ExpiredChannel on hub, as an Expiration in JSON.
+func PublishExpired(eng types.Engine, hub *pubsub.Hub) error {
+	return OnExpire(eng, func(key string, rec *types.Record) {
+		payload, contentType, err := pubsub.EncodePayload(Expiration{Key: key, Record: rec})
+		if err == nil {
+			hub.Publish(ExpiredChannel, payload, pubsub.WithContentType(contentType))
+		}
+	})
+}
+
+// PutTuple stores rec under the key tuple encodes to (see package keys),
+// setting rec.ID to that key.
+func PutTuple(ctx context.Context, eng types.Engine, tuple []interface{}, rec *types.Record) error {
+	key, err := keys.Encode(tuple)
+	if err != nil {
+		return err
+	}
+	rec.ID = key
+	return eng.Put(ctx, key, rec)
+}
+
+// GetTuple returns the record stored under tuple.
+func GetTuple(ctx context.Context, eng types.Engine, tuple []interface{}) (*types.Record, error) {
+	key, err := keys.Encode(tuple)
+	if err != nil {
+		return nil, err
+	}
+	return eng.Get(ctx, key)
+}
+
+// ScanTuplePrefix calls fn, in tuple order, for every record whose key is
+// a tuple starting with the elements of prefix, until fn returns false.
+// keys.Decode recovers the tuple from rec.ID.
+func ScanTuplePrefix(ctx context.Context, eng types.Engine, prefix []interface{}, fn func(*types.Record) bool) error {
+	key, err := keys.Encode(prefix)
+	if err != nil {
+		return err
+	}
+	return eng.Scan(ctx, key, fn)
+}
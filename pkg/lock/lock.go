@@ -0,0 +1,143 @@
+// Package lock provides advisory locks on keys, for workers that must not
+// handle the same key at once. A lock is a record under Prefix holding its
+// owner and fencing token, with the lock's TTL as the record's, so a lock
+// whose owner died expires like any other record and can be taken again.
+// Locks are taken and released with compare-and-swap, so they work in
+// every mode that stores records without a vector, and replicate and back
+// up like any other record. Nothing stops a write to the locked key itself:
+// holders pass their token on to whatever they write, which refuses tokens
+// lower than one it has seen.
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// Prefix is reserved for the records locks keep: the lock on key is
+// Prefix+"held:"+key and its fencing counter Prefix+"fence:"+key.
+const Prefix = "kvi:lock:"
+
+// The fields of a lock record's data.
+const (
+	OwnerField = "owner"
+	TokenField = "token"
+)
+
+// Lock errors. Test with errors.Is.
+var (
+	ErrHeld    = errors.New("lock held")     // by another owner, and not yet expired
+	ErrNotHeld = errors.New("lock not held") // expired, released, or taken again since
+)
+
+func heldKey(key string) string  { return Prefix + "held:" + key }
+func fenceKey(key string) string { return Prefix + "fence:" + key }
+
+// Lock takes the lock on key for owner until ttl passes or Unlock releases
+// it, and returns its fencing token. Tokens grow with every lock taken on
+// key, so a lower token is a holder that has since lost the lock. Lock
+// fails with ErrHeld while someone holds it.
+func Lock(ctx context.Context, eng types.Engine, key, owner string, ttl time.Duration) (uint64, error) {
+	if ttl <= 0 {
+		return 0, fmt.Errorf("lock on %s: ttl must be positive", key)
+	}
+	if held, err := eng.Get(ctx, heldKey(key)); err == nil {
+		return 0, heldError(key, held)
+	} else if !errors.Is(err, types.ErrKeyNotFound) {
+		return 0, err
+	}
+
+	token, err := nextToken(ctx, eng, key)
+	if err != nil {
+		return 0, err
+	}
+	expires := time.Now().Add(ttl)
+	rec := &types.Record{
+		ID:   heldKey(key),
+		Data: map[string]interface{}{OwnerField: owner, TokenField: float64(token)},
+		TTL:  &expires,
+	}
+	err = eng.CompareAndSwap(ctx, heldKey(key), 0, rec)
+	if errors.Is(err, types.ErrVersionMismatch) {
+		// Taken meanwhile; the token is skipped, which keeps them growing
+		if held, getErr := eng.Get(ctx, heldKey(key)); getErr == nil {
+			return 0, heldError(key, held)
+		}
+		return 0, fmt.Errorf("%w: %s", ErrHeld, key)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return token, nil
+}
+
+// nextToken bumps key's fencing counter and returns it. The counter is a
+// record of its own that never expires, so tokens keep growing after a
+// lock expires and its record is collected. The counter is its version.
+func nextToken(ctx context.Context, eng types.Engine, key string) (uint64, error) {
+	for {
+		rec, err := eng.Update(ctx, fenceKey(key), func(*types.Record) error { return nil })
+		if err == nil {
+			return rec.Version, nil
+		}
+		if !errors.Is(err, types.ErrKeyNotFound) {
+			return 0, err
+		}
+		rec = &types.Record{ID: fenceKey(key), Data: map[string]interface{}{}}
+		err = eng.CompareAndSwap(ctx, fenceKey(key), 0, rec)
+		if err == nil {
+			return rec.Version, nil
+		}
+		if !errors.Is(err, types.ErrVersionMismatch) {
+			return 0, err
+		}
+		// Created meanwhile: bump it instead
+	}
+}
+
+// Unlock releases the lock on key taken with token. It fails with
+// ErrNotHeld if that lock has expired or been released, whoever holds the
+// key now.
+func Unlock(ctx context.Context, eng types.Engine, key string, token uint64) error {
+	held, err := eng.Get(ctx, heldKey(key))
+	if errors.Is(err, types.ErrKeyNotFound) {
+		return fmt.Errorf("%w: %s is not locked", ErrNotHeld, key)
+	}
+	if err != nil {
+		return err
+	}
+	if have := tokenOf(held); have != token {
+		return fmt.Errorf("%w: %s is locked with token %d, not %d", ErrNotHeld, key, have, token)
+	}
+	err = eng.CompareAndSwap(ctx, heldKey(key), held.Version, nil)
+	if errors.Is(err, types.ErrVersionMismatch) {
+		return fmt.Errorf("%w: %s expired", ErrNotHeld, key)
+	}
+	return err
+}
+
+func heldError(key string, held *types.Record) error {
+	owner, _ := held.Data[OwnerField].(string)
+	if held.TTL == nil {
+		return fmt.Errorf("%w: %s is locked by %q", ErrHeld, key, owner)
+	}
+	return fmt.Errorf("%w: %s is locked by %q until %s", ErrHeld, key, owner, held.TTL.UTC().Format(time.RFC3339Nano))
+}
+
+// tokenOf reads a lock record's token, a float64 as JSON decodes it or an
+// integer as MessagePack does.
+func tokenOf(held *types.Record) uint64 {
+	switch n := held.Data[TokenField].(type) {
+	case float64:
+		return uint64(n)
+	case int64:
+		return uint64(n)
+	case uint64:
+		return n
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+package resp
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/thirawat27/kvi/pkg/collection"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// Lists and sets are package collection's, so the REST API sees the same
+// ones.
+
+func (c *conn) lpush(args []string) any {
+	return countReply(collection.LPush(c.ctx, c.s.engine, args[0], args[1:]...))
+}
+
+func (c *conn) rpush(args []string) any {
+	return countReply(collection.RPush(c.ctx, c.s.engine, args[0], args[1:]...))
+}
+
+func (c *conn) lpop(args []string) any {
+	return popReply(collection.LPop(c.ctx, c.s.engine, args[0]))
+}
+
+func (c *conn) rpop(args []string) any {
+	return popReply(collection.RPop(c.ctx, c.s.engine, args[0]))
+}
+
+func (c *conn) lrange(args []string) any {
+	start, err1 := strconv.Atoi(args[1])
+	stop, err2 := strconv.Atoi(args[2])
+	if err1 != nil || err2 != nil {
+		return errNotInteger
+	}
+	values, err := collection.LRange(c.ctx, c.s.engine, args[0], start, stop)
+	if err != nil {
+		return engineError(err)
+	}
+	return values
+}
+
+func (c *conn) sadd(args []string) any {
+	return countReply(collection.SAdd(c.ctx, c.s.engine, args[0], args[1:]...))
+}
+
+func (c *conn) srem(args []string) any {
+	return countReply(collection.SRem(c.ctx, c.s.engine, args[0], args[1:]...))
+}
+
+func (c *conn) smembers(args []string) any {
+	members, err := collection.SMembers(c.ctx, c.s.engine, args[0])
+	if err != nil {
+		return engineError(err)
+	}
+	return members
+}
+
+func (c *conn) sismember(args []string) any {
+	in, err := collection.SIsMember(c.ctx, c.s.engine, args[0], args[1])
+	switch {
+	case err != nil:
+		return engineError(err)
+	case in:
+		return 1
+	}
+	return 0
+}
+
+func countReply(n int, err error) any {
+	if err != nil {
+		return engineError(err)
+	}
+	return n
+}
+
+// popReply is a popped value, or null for an empty list.
+func popReply(value string, err error) any {
+	switch {
+	case errors.Is(err, types.ErrKeyNotFound):
+		return null
+	case err != nil:
+		return engineError(err)
+	}
+	return value
+}
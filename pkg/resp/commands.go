@@ -0,0 +1,518 @@
+package resp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/auth"
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// valueField is the field of a record's data holding a Redis string.
+const valueField = "value"
+
+// defaultScanCount is how many keys SCAN returns per call without COUNT.
+const defaultScanCount = 10
+
+type command struct {
+	arity      int       // as Redis counts it: exact if positive, at least -arity if negative
+	role       auth.Role // needed when auth is on; "" for none
+	subscribed bool      // allowed in SUBSCRIBE mode
+	run        func(c *conn, args []string) any
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"PING":        {arity: -1, role: auth.RoleRead, subscribed: true, run: (*conn).ping},
+		"ECHO":        {arity: 2, role: auth.RoleRead, run: func(_ *conn, args []string) any { return args[0] }},
+		"AUTH":        {arity: -2, subscribed: true, run: (*conn).authenticate},
+		"SELECT":      {arity: 2, run: (*conn).selectDB},
+		"CLIENT":      {arity: -2, run: (*conn).client},
+		"COMMAND":     {arity: -1, run: func(*conn, []string) any { return []any{} }},
+		"INFO":        {arity: -1, role: auth.RoleRead, run: (*conn).info},
+		"GET":         {arity: 2, role: auth.RoleRead, run: (*conn).get},
+		"MGET":        {arity: -2, role: auth.RoleRead, run: (*conn).mget},
+		"EXISTS":      {arity: -2, role: auth.RoleRead, run: (*conn).exists},
+		"TTL":         {arity: 2, role: auth.RoleRead, run: func(c *conn, args []string) any { return c.ttl(args[0], time.Second) }},
+		"PTTL":        {arity: 2, role: auth.RoleRead, run: func(c *conn, args []string) any { return c.ttl(args[0], time.Millisecond) }},
+		"SCAN":        {arity: -2, role: auth.RoleRead, run: (*conn).scan},
+		"SET":         {arity: -3, role: auth.RoleWrite, run: (*conn).set},
+		"MSET":        {arity: -3, role: auth.RoleWrite, run: (*conn).mset},
+		"DEL":         {arity: -2, role: auth.RoleWrite, run: (*conn).del},
+		"EXPIRE":      {arity: 3, role: auth.RoleWrite, run: func(c *conn, args []string) any { return c.expire(args, time.Second) }},
+		"PEXPIRE":     {arity: 3, role: auth.RoleWrite, run: func(c *conn, args []string) any { return c.expire(args, time.Millisecond) }},
+		"INCR":        {arity: 2, role: auth.RoleWrite, run: func(c *conn, args []string) any { return c.incr(args[0], 1) }},
+		"DECR":        {arity: 2, role: auth.RoleWrite, run: func(c *conn, args []string) any { return c.incr(args[0], -1) }},
+		"INCRBY":      {arity: 3, role: auth.RoleWrite, run: func(c *conn, args []string) any { return c.incrBy(args, 1) }},
+		"DECRBY":      {arity: 3, role: auth.RoleWrite, run: func(c *conn, args []string) any { return c.incrBy(args, -1) }},
+		"LPUSH":       {arity: -3, role: auth.RoleWrite, run: (*conn).lpush},
+		"RPUSH":       {arity: -3, role: auth.RoleWrite, run: (*conn).rpush},
+		"LPOP":        {arity: 2, role: auth.RoleWrite, run: (*conn).lpop},
+		"RPOP":        {arity: 2, role: auth.RoleWrite, run: (*conn).rpop},
+		"LRANGE":      {arity: 4, role: auth.RoleRead, run: (*conn).lrange},
+		"SADD":        {arity: -3, role: auth.RoleWrite, run: (*conn).sadd},
+		"SREM":        {arity: -3, role: auth.RoleWrite, run: (*conn).srem},
+		"SMEMBERS":    {arity: 2, role: auth.RoleRead, run: (*conn).smembers},
+		"SISMEMBER":   {arity: 3, role: auth.RoleRead, run: (*conn).sismember},
+		"PUBLISH":     {arity: 3, role: auth.RoleWrite, run: (*conn).publish},
+		"SUBSCRIBE":   {arity: -2, role: auth.RoleRead, subscribed: true, run: (*conn).subscribe},
+		"UNSUBSCRIBE": {arity: -1, role: auth.RoleRead, subscribed: true, run: (*conn).unsubscribe},
+	}
+}
+
+var (
+	errNotInteger = errorf("value is not an integer or out of range")
+	errSyntax     = errorf("syntax error")
+)
+
+func (c *conn) ping(args []string) any {
+	if len(args) > 1 {
+		return errorf("wrong number of arguments for 'ping' command")
+	}
+	switch {
+	case len(c.subs) > 0:
+		msg := ""
+		if len(args) == 1 {
+			msg = args[0]
+		}
+		return []any{"pong", msg}
+	case len(args) == 1:
+		return args[0]
+	}
+	return simpleString("PONG")
+}
+
+// authenticate takes an API key, exchanged for a token as /api/v1/auth
+// does, or a token. A username, if given, is ignored.
+func (c *conn) authenticate(args []string) any {
+	if c.s.auth == nil {
+		return errorf("AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	if len(args) > 2 {
+		return errSyntax
+	}
+	secret := args[len(args)-1]
+	if _, err := c.s.auth.Verify(secret); err == nil {
+		c.token = secret
+		return okReply
+	}
+	token, _, err := c.s.auth.Login(c.ctx, secret)
+	if err != nil {
+		return errorReply("WRONGPASS invalid username-password pair or user is disabled.")
+	}
+	c.token = token
+	return okReply
+}
+
+// selectDB accepts database 0, the only one.
+func (c *conn) selectDB(args []string) any {
+	if args[0] != "0" {
+		return errorf("DB index is out of range")
+	}
+	return okReply
+}
+
+// client accepts the connection naming clients send on connect.
+func (c *conn) client(args []string) any {
+	switch strings.ToUpper(args[0]) {
+	case "SETNAME", "SETINFO":
+		return okReply
+	case "GETNAME":
+		return null
+	case "ID":
+		id, _ := strconv.ParseInt(strings.TrimPrefix(c.id, "resp-"), 10, 64)
+		return id
+	}
+	return errorf("unknown subcommand '%s'. Try CLIENT HELP.", args[0])
+}
+
+// info reports stats.Collect's numbers in Redis's INFO layout.
+func (c *conn) info(args []string) any {
+	report := stats.Collect(c.s.engine, c.s.hub, c.s.started)
+	var mode types.Mode
+	records := 0
+	if report.Engine != nil {
+		mode, records = report.Engine.Mode, report.Engine.Records
+	}
+	sections := []struct {
+		name  string
+		lines []string
+	}{
+		{"Server", []string{
+			"redis_version:7.0.0", // what clients check for features; the subset served is in the README
+			"redis_mode:standalone",
+			"kvi_mode:" + string(mode),
+			"os:" + runtime.GOOS + " " + runtime.GOARCH,
+			fmt.Sprintf("uptime_in_seconds:%d", int64(report.UptimeSeconds)),
+		}},
+		{"Clients", []string{fmt.Sprintf("connected_clients:%d", c.s.clients.Load())}},
+		{"Memory", []string{fmt.Sprintf("used_memory:%d", report.Runtime.MemAllocBytes)}},
+		{"Stats", []string{
+			fmt.Sprintf("total_commands_processed:%d", c.s.commands.Load()),
+			fmt.Sprintf("pubsub_channels:%d", report.PubSub.Channels),
+		}},
+		{"Keyspace", []string{fmt.Sprintf("db0:keys=%d,expires=0,avg_ttl=0", records)}},
+	}
+	want := "default"
+	if len(args) > 0 {
+		want = strings.ToLower(args[0])
+	}
+	var b strings.Builder
+	for _, sec := range sections {
+		if want != "default" && want != "all" && want != "everything" && want != strings.ToLower(sec.name) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + sec.name + "\r\n")
+		for _, line := range sec.lines {
+			b.WriteString(line + "\r\n")
+		}
+	}
+	return b.String()
+}
+
+// stringOf is what GET returns for rec: its value field when that is all
+// its data holds, otherwise its data as JSON.
+func stringOf(rec *types.Record) string {
+	if v, ok := rec.Data[valueField]; ok && len(rec.Data) == 1 {
+		switch v := v.(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		case json.Number:
+			return v.String()
+		}
+	}
+	data, _ := json.Marshal(rec.Data)
+	return string(data)
+}
+
+func stringRecord(key, value string, ttl *time.Time) *types.Record {
+	return &types.Record{ID: key, Data: map[string]any{valueField: value}, TTL: ttl}
+}
+
+func (c *conn) get(args []string) any {
+	rec, err := c.s.engine.Get(c.ctx, args[0])
+	if errors.Is(err, types.ErrKeyNotFound) {
+		return null
+	}
+	if err != nil {
+		return engineError(err)
+	}
+	return stringOf(rec)
+}
+
+// getMany returns the live records of keys, by key.
+func (c *conn) getMany(keys []string) (map[string]*types.Record, error) {
+	if b, ok := c.s.engine.(types.Batcher); ok {
+		return b.BatchGet(c.ctx, keys)
+	}
+	recs := make(map[string]*types.Record, len(keys))
+	for _, key := range keys {
+		rec, err := c.s.engine.Get(c.ctx, key)
+		if errors.Is(err, types.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		recs[key] = rec
+	}
+	return recs, nil
+}
+
+func (c *conn) mget(args []string) any {
+	recs, err := c.getMany(args)
+	if err != nil {
+		return engineError(err)
+	}
+	out := make([]any, len(args))
+	for i, key := range args {
+		if rec, ok := recs[key]; ok {
+			out[i] = stringOf(rec)
+		} else {
+			out[i] = null
+		}
+	}
+	return out
+}
+
+func (c *conn) exists(args []string) any {
+	recs, err := c.getMany(args)
+	if err != nil {
+		return engineError(err)
+	}
+	n := 0
+	for _, key := range args {
+		if _, ok := recs[key]; ok {
+			n++ // a key named twice counts twice, as in Redis
+		}
+	}
+	return n
+}
+
+// ttl is TTL or PTTL: the time key has left in units, -1 if it does not
+// expire or -2 if it does not exist.
+func (c *conn) ttl(key string, unit time.Duration) any {
+	rec, err := c.s.engine.Get(c.ctx, key)
+	if errors.Is(err, types.ErrKeyNotFound) {
+		return -2
+	}
+	if err != nil {
+		return engineError(err)
+	}
+	if rec.TTL == nil {
+		return -1
+	}
+	left := time.Until(*rec.TTL)
+	return int64(max(0, (left+unit/2)/unit))
+}
+
+// scan pages through the keys matching MATCH. The cursor counts the keys
+// returned so far, so each call rescans from the start of the prefix.
+func (c *conn) scan(args []string) any {
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return errorf("invalid cursor")
+	}
+	count, prefix, exact := defaultScanCount, "", false
+	for i := 1; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			return errSyntax
+		}
+		switch strings.ToUpper(args[i]) {
+		case "MATCH":
+			pattern := args[i+1]
+			prefix = strings.TrimSuffix(pattern, "*")
+			if strings.ContainsAny(prefix, `*?[\`) {
+				return errorf("only MATCH patterns of a prefix followed by '*' are supported")
+			}
+			exact = prefix == pattern
+		case "COUNT":
+			if count, err = strconv.Atoi(args[i+1]); err != nil || count < 1 {
+				return errSyntax
+			}
+		case "TYPE":
+			if args[i+1] != "string" {
+				return []any{"0", []any{}}
+			}
+		default:
+			return errSyntax
+		}
+	}
+
+	keys := []any{}
+	skip, more := cursor, false
+	err = c.s.engine.Scan(c.ctx, prefix, func(rec *types.Record) bool {
+		if exact && rec.ID != prefix {
+			return false // keys after it only extend it
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		if len(keys) == count {
+			more = true
+			return false
+		}
+		keys = append(keys, rec.ID)
+		return true
+	})
+	if err != nil {
+		return engineError(err)
+	}
+	next := uint64(0)
+	if more {
+		next = cursor + uint64(len(keys))
+	}
+	return []any{strconv.FormatUint(next, 10), keys}
+}
+
+// set is SET key value [EX seconds | PX milliseconds] [NX | XX].
+func (c *conn) set(args []string) any {
+	key, value := args[0], args[1]
+	var ttl *time.Time
+	var nx, xx bool
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i]); opt {
+		case "EX", "PX":
+			if ttl != nil || i+1 == len(args) {
+				return errSyntax
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				return errNotInteger
+			}
+			if n <= 0 {
+				return errorf("invalid expire time in 'set' command")
+			}
+			unit := time.Second
+			if opt == "PX" {
+				unit = time.Millisecond
+			}
+			at := time.Now().Add(time.Duration(n) * unit)
+			ttl = &at
+			i++
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		default:
+			return errSyntax
+		}
+	}
+	if nx && xx {
+		return errSyntax
+	}
+
+	rec := stringRecord(key, value, ttl)
+	var err error
+	switch {
+	case nx:
+		err = c.s.engine.CompareAndSwap(c.ctx, key, 0, rec)
+	case xx:
+		_, err = c.s.engine.Update(c.ctx, key, func(r *types.Record) error {
+			r.Data, r.TTL, r.Vector = rec.Data, rec.TTL, nil
+			return nil
+		})
+	default:
+		err = c.s.engine.Put(c.ctx, key, rec)
+	}
+	switch {
+	case errors.Is(err, types.ErrVersionMismatch), errors.Is(err, types.ErrKeyNotFound):
+		return null
+	case err != nil:
+		return engineError(err)
+	}
+	return okReply
+}
+
+func (c *conn) mset(args []string) any {
+	if len(args)%2 != 0 {
+		return errorf("wrong number of arguments for 'mset' command")
+	}
+	recs := make([]*types.Record, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		recs = append(recs, stringRecord(args[i], args[i+1], nil))
+	}
+	if b, ok := c.s.engine.(types.Batcher); ok {
+		if err := b.BatchPut(c.ctx, recs); err != nil {
+			return engineError(err)
+		}
+		return okReply
+	}
+	for _, rec := range recs {
+		if err := c.s.engine.Put(c.ctx, rec.ID, rec); err != nil {
+			return engineError(err)
+		}
+	}
+	return okReply
+}
+
+func (c *conn) del(args []string) any {
+	if b, ok := c.s.engine.(types.Batcher); ok {
+		deleted, err := b.BatchDelete(c.ctx, args)
+		if err != nil {
+			return engineError(err)
+		}
+		n := 0
+		for _, key := range args {
+			if deleted[key] {
+				n++
+				deleted[key] = false // a key named twice is deleted once
+			}
+		}
+		return n
+	}
+	n := 0
+	for _, key := range args {
+		if _, err := c.s.engine.Get(c.ctx, key); errors.Is(err, types.ErrKeyNotFound) {
+			continue
+		}
+		if err := c.s.engine.Delete(c.ctx, key); err != nil {
+			return engineError(err)
+		}
+		n++
+	}
+	return n
+}
+
+// expire is EXPIRE or PEXPIRE. A time not in the future deletes the key.
+func (c *conn) expire(args []string, unit time.Duration) any {
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return errNotInteger
+	}
+	at := time.Now().Add(time.Duration(n) * unit)
+	_, err = c.s.engine.Update(c.ctx, args[0], func(r *types.Record) error {
+		r.TTL = &at
+		return nil
+	})
+	if n <= 0 && err == nil {
+		err = c.s.engine.Delete(c.ctx, args[0])
+	}
+	switch {
+	case errors.Is(err, types.ErrKeyNotFound):
+		return 0
+	case err != nil:
+		return engineError(err)
+	}
+	return 1
+}
+
+// incrBy is INCRBY (sign 1) or DECRBY (sign -1).
+func (c *conn) incrBy(args []string, sign int64) any {
+	n, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || n == math.MinInt64 {
+		return errNotInteger
+	}
+	return c.incr(args[0], sign*n)
+}
+
+// incr adds by to the integer in key, which starts at 0 if missing, and
+// keeps its TTL.
+func (c *conn) incr(key string, by int64) any {
+	var result int64
+	errOverflow := errors.New("increment or decrement would overflow")
+	errNotNumber := errors.New("not an integer")
+	_, err := c.s.engine.Update(c.ctx, key, func(r *types.Record) error {
+		n, err := strconv.ParseInt(stringOf(r), 10, 64)
+		if err != nil {
+			return errNotNumber
+		}
+		if by > 0 && n > math.MaxInt64-by || by < 0 && n < math.MinInt64-by {
+			return errOverflow
+		}
+		result = n + by
+		r.Data = map[string]any{valueField: strconv.FormatInt(result, 10)}
+		return nil
+	})
+	if errors.Is(err, types.ErrKeyNotFound) {
+		result = by
+		err = c.s.engine.CompareAndSwap(c.ctx, key, 0, stringRecord(key, strconv.FormatInt(by, 10), nil))
+		if errors.Is(err, types.ErrVersionMismatch) {
+			return c.incr(key, by) // created meanwhile
+		}
+	}
+	switch {
+	case errors.Is(err, errNotNumber):
+		return errNotInteger
+	case errors.Is(err, errOverflow):
+		return errorf("%v", errOverflow)
+	case err != nil:
+		return engineError(err)
+	}
+	return result
+}
@@ -0,0 +1,121 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// maxArgs caps the arguments of one command.
+const maxArgs = 1 << 20
+
+// errProtocol is a request that is not RESP; the connection is closed after
+// the error reply, as Redis does.
+var errProtocol = errors.New("Protocol error")
+
+// Reply types beyond string (a bulk string), int64 and []any (an array).
+type (
+	simpleString string
+	errorReply   string
+	nilBulk      struct{}
+)
+
+var (
+	okReply = simpleString("OK")
+	null    = nilBulk{}
+)
+
+// errorf is an -ERR reply.
+func errorf(format string, args ...any) errorReply {
+	return errorReply("ERR " + fmt.Sprintf(format, args...))
+}
+
+// readCommand reads one command: a RESP array of bulk strings, or an
+// inline command, a line of space-separated words as typed into telnet.
+// It returns no arguments for an empty line.
+func readCommand(r *bufio.Reader, maxBulk int64) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n > maxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+	args := make([]string, 0, max(n, 0))
+	for range n {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%.1s'", errProtocol, line)
+		}
+		size, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil || size < 0 || maxBulk > 0 && size > maxBulk {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if buf[size] != '\r' || buf[size+1] != '\n' {
+			return nil, fmt.Errorf("%w: bulk string not terminated by CRLF", errProtocol)
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readLine reads a line without its line ending. Lines are bounded by the
+// reader's buffer, so a client cannot grow one without limit.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", fmt.Errorf("%w: too big inline request", errProtocol)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// writeReply encodes v in RESP2.
+func writeReply(w *bufio.Writer, v any) {
+	switch v := v.(type) {
+	case simpleString:
+		w.WriteString("+" + string(v) + "\r\n")
+	case errorReply:
+		w.WriteString("-" + strings.NewReplacer("\r", " ", "\n", " ").Replace(string(v)) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case int:
+		w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case nilBulk:
+		w.WriteString("$-1\r\n")
+	case multi:
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	case []any:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	case []string:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		panic(fmt.Sprintf("resp: cannot encode %T", v))
+	}
+}
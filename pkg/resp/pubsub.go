@@ -0,0 +1,98 @@
+package resp
+
+import "github.com/thirawat27/kvi/internal/pubsub"
+
+var errNoPubSub = errorf("pub/sub is disabled on this server")
+
+func (c *conn) publish(args []string) any {
+	if c.s.hub == nil {
+		return errNoPubSub
+	}
+	return c.s.hub.Publish(args[0], args[1])
+}
+
+// subscribe puts the connection in SUBSCRIBE mode, where messages published
+// to its channels are pushed to it. The first subscription takes a slot in
+// the streams pool until the last one ends.
+func (c *conn) subscribe(args []string) any {
+	if c.s.hub == nil {
+		return errNoPubSub
+	}
+	if !c.streamed {
+		if !c.s.streams.Acquire() {
+			return errorf("max number of subscriptions reached")
+		}
+		c.streamed = true
+	}
+	if c.subs == nil {
+		c.subs = make(map[string]*pubsub.Subscriber)
+	}
+	// Confirmations go out before any message the new subscriptions get
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	for _, channel := range args {
+		if _, ok := c.subs[channel]; !ok {
+			sub := c.s.hub.Subscribe(channel, c.id)
+			c.subs[channel] = sub
+			go c.forward(sub)
+		}
+		writeReply(c.w, []any{"subscribe", channel, len(c.subs)})
+	}
+	if err := c.w.Flush(); err != nil {
+		c.nc.Close()
+	}
+	return replied{}
+}
+
+// forward pushes sub's messages to the client until it unsubscribes.
+func (c *conn) forward(sub *pubsub.Subscriber) {
+	for msg := range sub.C {
+		c.reply([]any{"message", msg.Channel, msg.Payload})
+	}
+}
+
+// unsubscribe leaves the channels named, or all of them.
+func (c *conn) unsubscribe(args []string) any {
+	if len(args) == 0 {
+		for channel := range c.subs {
+			args = append(args, channel)
+		}
+		if len(args) == 0 {
+			return []any{"unsubscribe", null, 0}
+		}
+	}
+	replies := make([]any, 0, len(args))
+	for _, channel := range args {
+		if sub, ok := c.subs[channel]; ok {
+			sub.Unsubscribe()
+			delete(c.subs, channel)
+		}
+		replies = append(replies, []any{"unsubscribe", channel, len(c.subs)})
+	}
+	if len(c.subs) == 0 {
+		c.releaseStream()
+	}
+	return multi(replies)
+}
+
+func (c *conn) unsubscribeAll() {
+	for channel, sub := range c.subs {
+		sub.Unsubscribe()
+		delete(c.subs, channel)
+	}
+	c.releaseStream()
+}
+
+func (c *conn) releaseStream() {
+	if c.streamed {
+		c.s.streams.Release()
+		c.streamed = false
+	}
+}
+
+// multi is several replies to one command, as UNSUBSCRIBE sends one per
+// channel.
+type multi []any
+
+// replied is returned by a command that wrote its replies itself.
+type replied struct{}
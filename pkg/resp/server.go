@@ -0,0 +1,249 @@
+// Package resp serves a subset of the Redis protocol (RESP2) over the
+// engine, so Redis clients and tools can use kvi as a simple cache. A Redis
+// string is a record whose data is {"value": "<string>"}; other records
+// read back as their data in JSON. Lists and sets are those of package
+// collection. PUBLISH and SUBSCRIBE use the same hub as the REST and gRPC
+// APIs.
+package resp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/auth"
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// DefaultMaxBulk caps a single argument, like max_request_bytes does a
+// REST body.
+const DefaultMaxBulk = 4 << 20
+
+// Server answers Redis clients on the listener passed to Serve.
+type Server struct {
+	engine  types.Engine
+	hub     *pubsub.Hub // nil: PUBLISH and SUBSCRIBE answer errors
+	auth    *auth.Authenticator
+	conns   *stats.Pool
+	streams *stats.Pool
+	maxBulk int64
+	log     *slog.Logger
+	started time.Time
+
+	commands atomic.Int64
+	clients  atomic.Int64
+	nextID   atomic.Uint64
+
+	mu   sync.Mutex
+	open map[net.Conn]struct{}
+	wg   sync.WaitGroup
+}
+
+func NewServer(eng types.Engine, hub *pubsub.Hub, opts ...func(*Server)) *Server {
+	s := &Server{
+		engine:  eng,
+		hub:     hub,
+		conns:   stats.NewPool(0),
+		streams: stats.NewPool(0),
+		maxBulk: DefaultMaxBulk,
+		log:     slog.Default(),
+		started: time.Now(),
+		open:    make(map[net.Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// WithAuth requires clients to AUTH, with an API key or a token, before
+// any other command; commands then need the role their REST counterparts
+// do.
+func WithAuth(a *auth.Authenticator) func(*Server) {
+	return func(s *Server) { s.auth = a }
+}
+
+// WithConnLimits counts connections into conns and subscribed connections
+// into streams, the pools the other APIs share.
+func WithConnLimits(conns, streams *stats.Pool) func(*Server) {
+	return func(s *Server) { s.conns, s.streams = conns, streams }
+}
+
+// WithMaxBulk caps the size of one argument in bytes (0 for no cap).
+func WithMaxBulk(n int64) func(*Server) {
+	return func(s *Server) { s.maxBulk = n }
+}
+
+// WithLogger sets where the server logs.
+func WithLogger(l *slog.Logger) func(*Server) {
+	return func(s *Server) { s.log = l }
+}
+
+// Serve answers connections on lis until ctx is cancelled, then closes lis
+// and every connection and returns once their commands finish.
+func (s *Server) Serve(ctx context.Context, lis net.Listener) error {
+	stop := context.AfterFunc(ctx, func() {
+		lis.Close()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for nc := range s.open {
+			nc.Close()
+		}
+	})
+	defer stop()
+
+	for {
+		nc, err := lis.Accept()
+		if err != nil {
+			s.wg.Wait()
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if !s.conns.Acquire() {
+			nc.Write([]byte("-ERR max number of clients reached\r\n"))
+			nc.Close()
+			continue
+		}
+		s.mu.Lock()
+		if ctx.Err() != nil { // lost the race with shutdown
+			s.mu.Unlock()
+			s.conns.Release()
+			nc.Close()
+			continue
+		}
+		s.open[nc] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(ctx, nc)
+	}
+}
+
+// conn is one client connection.
+type conn struct {
+	s     *Server
+	nc    net.Conn
+	ctx   context.Context
+	id    string
+	token string // from AUTH, when auth is on
+
+	wmu sync.Mutex // replies and published messages interleave
+	w   *bufio.Writer
+
+	subs     map[string]*pubsub.Subscriber // SUBSCRIBE mode while not empty
+	streamed bool                          // holds a slot in the streams pool
+}
+
+func (s *Server) serveConn(ctx context.Context, nc net.Conn) {
+	c := &conn{
+		s:   s,
+		nc:  nc,
+		ctx: ctx,
+		id:  fmt.Sprintf("resp-%d", s.nextID.Add(1)),
+		w:   bufio.NewWriter(nc),
+	}
+	s.clients.Add(1)
+	defer func() {
+		c.unsubscribeAll()
+		nc.Close()
+		s.clients.Add(-1)
+		s.conns.Release()
+		s.mu.Lock()
+		delete(s.open, nc)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	r := bufio.NewReaderSize(nc, 64<<10)
+	for {
+		args, err := readCommand(r, s.maxBulk)
+		if errors.Is(err, errProtocol) {
+			c.reply(errorReply("ERR " + err.Error()))
+			return
+		}
+		if err != nil {
+			return // the client went away, or shutdown closed the connection
+		}
+		if len(args) == 0 {
+			continue
+		}
+		s.commands.Add(1)
+		name := strings.ToUpper(args[0])
+		if name == "QUIT" {
+			c.reply(okReply)
+			return
+		}
+		c.reply(c.dispatch(name, args[1:]))
+	}
+}
+
+// reply writes v and flushes it.
+func (c *conn) reply(v any) {
+	if _, done := v.(replied); done {
+		return
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	writeReply(c.w, v)
+	if err := c.w.Flush(); err != nil {
+		c.nc.Close() // the read loop ends
+	}
+}
+
+// dispatch runs one command, checking its arity and the client's role.
+func (c *conn) dispatch(name string, args []string) any {
+	cmd, found := commands[name]
+	if !found {
+		return errorf("unknown command '%s', with args beginning with: %s", strings.ToLower(name), quoteArgs(args))
+	}
+	if n := len(args) + 1; cmd.arity > 0 && n != cmd.arity || cmd.arity < 0 && n < -cmd.arity {
+		return errorf("wrong number of arguments for '%s' command", strings.ToLower(name))
+	}
+	if len(c.subs) > 0 && !cmd.subscribed {
+		return errorf("Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(name))
+	}
+	if c.s.auth != nil && cmd.role != "" {
+		if c.token == "" {
+			return errorReply("NOAUTH Authentication required.")
+		}
+		if _, err := c.s.auth.Authorize(c.token, cmd.role); errors.Is(err, auth.ErrForbidden) {
+			return errorReply(fmt.Sprintf("NOPERM this user has no permissions to run the '%s' command", strings.ToLower(name)))
+		} else if err != nil {
+			c.token = ""
+			return errorReply("NOAUTH Authentication required.")
+		}
+	}
+	return cmd.run(c, args)
+}
+
+// quoteArgs lists args as Redis does in its unknown command error.
+func quoteArgs(args []string) string {
+	var b strings.Builder
+	for _, a := range args {
+		fmt.Fprintf(&b, "'%s' ", a)
+	}
+	return b.String()
+}
+
+// engineError is the reply to a failed engine call.
+func engineError(err error) errorReply {
+	switch {
+	case errors.Is(err, types.ErrReadOnly):
+		return errorReply("READONLY You can't write against a read only replica.")
+	case errors.Is(err, types.ErrWrongType):
+		return errorReply("WRONGTYPE Operation against a key holding the wrong kind of value")
+	case errors.Is(err, context.Canceled):
+		return errorReply("ERR server is shutting down")
+	}
+	return errorReply("ERR " + err.Error())
+}
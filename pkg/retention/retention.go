@@ -0,0 +1,397 @@
+// Package retention keeps key prefixes from growing without bound: a
+// Rule for a prefix keeps at most the newest MaxCount records under it,
+// and none older than MaxAgeSeconds, deleting the rest oldest first. A
+// record's age is that of its last write, UpdatedAt; records without one,
+// written before timestamps were kept, count as the oldest.
+//
+// Rules are records under Prefix, so they survive restarts and replicate
+// and back up like any other record. A Retention applies them every
+// Interval, and on demand; a dry run reports what a run would delete
+// without deleting it. Deletes go through the engine's CompareAndSwap, so
+// they are logged and indexed like any other, and a record written again
+// since the run read it is kept.
+package retention
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// Prefix is reserved for the records rules are kept in: the rule for
+// prefix p is the record Prefix+p. No rule deletes them.
+const Prefix = "kvi:retention:"
+
+// reserved starts the keys of the records kvi's own packages keep their
+// state in, such as locks and key sequences. Only a rule whose prefix
+// starts with it deletes them.
+const reserved = "kvi:"
+
+// Defaults for the options below.
+const (
+	DefaultBatchSize = 1000
+	DefaultSamples   = 100
+)
+
+// The fields of a rule record's data.
+const (
+	MaxAgeField   = "max_age_seconds"
+	MaxCountField = "max_count"
+	DryRunField   = "dry_run"
+)
+
+// Retention errors. Test with errors.Is.
+var (
+	ErrBadRule = errors.New("invalid retention rule")
+	ErrNoRule  = errors.New("no retention rule")
+)
+
+// Rule bounds the records under Prefix. A zero bound is no bound, but a
+// rule needs one of them. A DryRun rule only reports what it would
+// delete, in its stats.
+type Rule struct {
+	Prefix        string `json:"prefix"`
+	MaxAgeSeconds int64  `json:"max_age_seconds,omitempty"`
+	MaxCount      int64  `json:"max_count,omitempty"`
+	DryRun        bool   `json:"dry_run,omitempty"`
+}
+
+// Validate reports what makes r unusable, wrapping ErrBadRule.
+func (r Rule) Validate() error {
+	switch {
+	case strings.HasPrefix(r.Prefix, Prefix):
+		return fmt.Errorf("%w: %q is where rules are kept", ErrBadRule, r.Prefix)
+	case r.MaxAgeSeconds < 0 || r.MaxCount < 0:
+		return fmt.Errorf("%w: max_age_seconds and max_count must not be negative", ErrBadRule)
+	case r.MaxAgeSeconds == 0 && r.MaxCount == 0:
+		return fmt.Errorf("%w: set max_age_seconds, max_count or both", ErrBadRule)
+	}
+	return nil
+}
+
+func ruleOf(rec *types.Record) Rule {
+	r := Rule{Prefix: strings.TrimPrefix(rec.ID, Prefix)}
+	r.MaxAgeSeconds, _ = rec.GetInt64(MaxAgeField)
+	r.MaxCount, _ = rec.GetInt64(MaxCountField)
+	r.DryRun, _ = rec.GetBool(DryRunField)
+	return r
+}
+
+// Options tune a Retention; zero values take the defaults.
+type Options struct {
+	// Interval is how often every rule is applied; 0 applies them only
+	// when Run is called.
+	Interval time.Duration
+	// BatchSize is how many records are deleted between checks that the
+	// run should go on.
+	BatchSize int
+	// Samples is how many of the keys it would delete a dry run lists.
+	Samples int
+	// Logger receives a line for each run that deleted something, or
+	// failed; nil means slog.Default().
+	Logger *slog.Logger
+}
+
+// Result is what one rule did in a run. Removed counts the records it
+// deleted, or in a dry run would have; Changed those it left because they
+// were written again, or deleted, since the run read them. A dry run lists
+// the first of the keys it would delete, oldest first.
+type Result struct {
+	Prefix     string   `json:"prefix"`
+	DryRun     bool     `json:"dry_run"`
+	Matched    int64    `json:"matched"`
+	Removed    int64    `json:"removed"`
+	Changed    int64    `json:"changed"`
+	Keys       []string `json:"keys,omitempty"`
+	DurationMs float64  `json:"duration_ms"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Retention applies the rules kept in an engine. Create it with New and
+// Close it when done; the engine stays the caller's to close.
+type Retention struct {
+	eng  types.Engine
+	opts Options
+	log  *slog.Logger
+	stop context.CancelFunc
+	done chan struct{}
+
+	running sync.Mutex // held by a run, so one goes at a time
+
+	mu    sync.Mutex // guards what follows
+	rules map[string]*stats.RetentionRuleStats
+	next  time.Time // of the next scheduled run
+}
+
+// New returns a Retention for the rules kept in eng, applying them every
+// opts.Interval from now.
+func New(eng types.Engine, opts Options) *Retention {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+	if opts.Samples <= 0 {
+		opts.Samples = DefaultSamples
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	r := &Retention{
+		eng:   eng,
+		opts:  opts,
+		log:   opts.Logger.With("component", "retention"),
+		stop:  stop,
+		done:  make(chan struct{}),
+		rules: make(map[string]*stats.RetentionRuleStats),
+	}
+	if opts.Interval <= 0 {
+		close(r.done)
+		return r
+	}
+	r.next = time.Now().Add(opts.Interval)
+	go r.loop(ctx)
+	return r
+}
+
+// Close stops applying rules, waiting for a run in progress to stop.
+func (r *Retention) Close() {
+	r.stop()
+	<-r.done
+}
+
+func (r *Retention) loop(ctx context.Context) {
+	defer close(r.done)
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		r.mu.Lock()
+		r.next = time.Now().Add(r.opts.Interval)
+		r.mu.Unlock()
+		// A follower deletes what its primary deletes
+		if rep, ok := r.eng.(types.Replica); ok && rep.ReadOnly() {
+			continue
+		}
+		if _, err := r.Run(ctx, "", false); err != nil && ctx.Err() == nil {
+			r.log.Error("retention run failed", "error", err)
+		}
+	}
+}
+
+// Rules returns the rules kept in the engine, by prefix.
+func (r *Retention) Rules(ctx context.Context) ([]Rule, error) {
+	var rules []Rule
+	err := r.eng.Scan(ctx, Prefix, func(rec *types.Record) bool {
+		rules = append(rules, ruleOf(rec))
+		return true
+	})
+	return rules, err
+}
+
+// Set keeps rule in the engine, replacing the one for its prefix.
+func (r *Retention) Set(ctx context.Context, rule Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	rec := &types.Record{ID: Prefix + rule.Prefix}
+	rec.SetField(MaxAgeField, rule.MaxAgeSeconds)
+	rec.SetField(MaxCountField, rule.MaxCount)
+	rec.SetField(DryRunField, rule.DryRun)
+	return r.eng.Put(ctx, rec.ID, rec)
+}
+
+// Remove deletes the rule for prefix, failing with ErrNoRule if there is
+// none.
+func (r *Retention) Remove(ctx context.Context, prefix string) error {
+	if _, err := r.eng.Get(ctx, Prefix+prefix); errors.Is(err, types.ErrKeyNotFound) {
+		return fmt.Errorf("%w for %q", ErrNoRule, prefix)
+	} else if err != nil {
+		return err
+	}
+	if err := r.eng.Delete(ctx, Prefix+prefix); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	delete(r.rules, prefix)
+	r.mu.Unlock()
+	return nil
+}
+
+// Run applies the rule for prefix now, or with prefix "" every rule, and
+// returns what each did. With dryRun nothing is deleted, whatever the
+// rules say, and their stats are left as they are. A rule that fails
+// does not stop the others; its Result has the error, and Run returns the
+// first.
+func (r *Retention) Run(ctx context.Context, prefix string, dryRun bool) ([]Result, error) {
+	rules, err := r.Rules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" {
+		i := slices.IndexFunc(rules, func(rule Rule) bool { return rule.Prefix == prefix })
+		if i < 0 {
+			return nil, fmt.Errorf("%w for %q", ErrNoRule, prefix)
+		}
+		rules = rules[i : i+1]
+	}
+
+	r.running.Lock()
+	defer r.running.Unlock()
+	results := make([]Result, 0, len(rules))
+	var first error
+	for _, rule := range rules {
+		res, err := r.apply(ctx, rule, dryRun || rule.DryRun)
+		if err != nil {
+			res.Error = err.Error()
+			if first == nil {
+				first = fmt.Errorf("prefix %q: %w", rule.Prefix, err)
+			}
+		}
+		if !dryRun {
+			r.record(rule, res)
+		}
+		if err != nil || res.Removed > 0 && !res.DryRun {
+			r.log.Info("applied retention rule", "prefix", rule.Prefix, "matched", res.Matched,
+				"removed", res.Removed, "changed", res.Changed, "duration_ms", res.DurationMs, "error", res.Error)
+		}
+		results = append(results, res)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return results, first
+}
+
+// candidate is a record a run may delete.
+type candidate struct {
+	key     string
+	version uint64
+	at      time.Time
+}
+
+// apply deletes the records under rule's prefix past its bounds.
+func (r *Retention) apply(ctx context.Context, rule Rule, dryRun bool) (res Result, err error) {
+	start := time.Now()
+	res = Result{Prefix: rule.Prefix, DryRun: dryRun}
+	defer func() { res.DurationMs = float64(time.Since(start).Microseconds()) / 1000 }()
+
+	var found []candidate
+	skip := Prefix
+	if !strings.HasPrefix(rule.Prefix, reserved) {
+		skip = reserved
+	}
+	collect := func(rec *types.Record) bool {
+		if !strings.HasPrefix(rec.ID, skip) {
+			found = append(found, candidate{rec.ID, rec.Version, rec.UpdatedAt})
+		}
+		return true
+	}
+	if ps, ok := r.eng.(types.ProjectingScanner); ok {
+		// Only the key, version and timestamps are needed
+		err = ps.ScanProjected(ctx, rule.Prefix, &types.Projection{Fields: []string{}}, collect)
+	} else {
+		err = r.eng.Scan(ctx, rule.Prefix, collect)
+	}
+	res.Matched = int64(len(found))
+	if err != nil {
+		return res, err
+	}
+
+	// Oldest first; the records past the age bound are then a prefix,
+	// and so are those past the count
+	slices.SortFunc(found, func(a, b candidate) int {
+		if c := a.at.Compare(b.at); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.key, b.key)
+	})
+	n := 0
+	if rule.MaxCount > 0 && int64(len(found)) > rule.MaxCount {
+		n = len(found) - int(rule.MaxCount)
+	}
+	if rule.MaxAgeSeconds > 0 {
+		cutoff := start.Add(-time.Duration(rule.MaxAgeSeconds) * time.Second)
+		for n < len(found) && found[n].at.Before(cutoff) {
+			n++
+		}
+	}
+	victims := found[:n]
+
+	if dryRun {
+		res.Removed = int64(n)
+		for _, c := range victims[:min(n, r.opts.Samples)] {
+			res.Keys = append(res.Keys, c.key)
+		}
+		return res, nil
+	}
+	for i, c := range victims {
+		if i%r.opts.BatchSize == 0 && ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+		err := r.eng.CompareAndSwap(ctx, c.key, c.version, nil)
+		switch {
+		case err == nil:
+			res.Removed++
+		case errors.Is(err, types.ErrVersionMismatch) || errors.Is(err, types.ErrKeyNotFound):
+			res.Changed++
+		default:
+			return res, fmt.Errorf("%s: %w", c.key, err)
+		}
+	}
+	return res, nil
+}
+
+// record adds res to the stats of rule.
+func (r *Retention) record(rule Rule, res Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	st := r.rules[rule.Prefix]
+	if st == nil {
+		st = &stats.RetentionRuleStats{}
+		r.rules[rule.Prefix] = st
+	}
+	st.Runs++
+	st.LastRun = time.Now().UTC()
+	st.LastMatched, st.LastError = res.Matched, res.Error
+	if res.DryRun {
+		st.LastWouldRemove, st.LastRemoved = res.Removed, 0
+	} else {
+		st.LastWouldRemove, st.LastRemoved = 0, res.Removed
+		st.Removed += res.Removed
+	}
+}
+
+// Stats describes the rules kept in the engine, with what they did since
+// this Retention was created.
+func (r *Retention) Stats(ctx context.Context) (*stats.RetentionStats, error) {
+	rules, err := r.Rules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := &stats.RetentionStats{IntervalMs: r.opts.Interval.Milliseconds(), Rules: make([]stats.RetentionRuleStats, len(rules))}
+	for i, rule := range rules {
+		st := stats.RetentionRuleStats{}
+		if s := r.rules[rule.Prefix]; s != nil {
+			st = *s
+		}
+		st.Prefix, st.MaxAgeSeconds, st.MaxCount, st.DryRun = rule.Prefix, rule.MaxAgeSeconds, rule.MaxCount, rule.DryRun
+		st.NextRun = r.next
+		out.Rules[i] = st
+	}
+	return out, nil
+}
@@ -0,0 +1,364 @@
+// Package shadow checks reads against a second, read-only copy of the
+// data, to catch two stores diverging while data moves between them: a
+// data directory converted to another mode, or an old server a new one
+// replaces. Reads are served from the primary as ever; each one handed to
+// a Shadow is read again from the shadow source in the background and the
+// two results compared, counting the keys that differ and keeping the
+// latest of them as samples.
+//
+// Comparing must not double the load on either side, so it is best
+// effort: reads beyond RatePerSec, or arriving while Queue comparisons
+// are already waiting, are skipped and counted rather than compared, and
+// one comparison runs at a time.
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// Defaults for the options below.
+const (
+	DefaultRatePerSec = 100
+	DefaultQueue      = 1000
+	DefaultSamples    = 100
+	DefaultTimeout    = 5 * time.Second
+)
+
+// Reasons a key is reported as a mismatch.
+const (
+	ReasonMissingShadow  = "missing from shadow"
+	ReasonMissingPrimary = "missing from primary"
+	ReasonData           = "data differs"
+	ReasonVector         = "vector differs"
+	ReasonBlob           = "blob differs"
+	ReasonTags           = "tags differ"
+	ReasonTTL            = "ttl differs"
+)
+
+// Reader is the shadow source. Any engine satisfies it, as does
+// *client.Client for a remote server. Get returns an error wrapping
+// types.ErrKeyNotFound for a missing key.
+type Reader interface {
+	Get(ctx context.Context, key string) (*types.Record, error)
+	Scan(ctx context.Context, prefix string, fn func(*types.Record) bool) error
+}
+
+// Options tune a Shadow; zero values take the defaults.
+type Options struct {
+	// RatePerSec caps how many records a second are read from the shadow:
+	// a Get costs one, a scan page one per record in it.
+	RatePerSec int
+	// Queue is how many comparisons may wait for their turn.
+	Queue int
+	// Samples is how many of the latest mismatches are kept.
+	Samples int
+	// Timeout bounds each read from the shadow.
+	Timeout time.Duration
+}
+
+// ScanPage is one page of a scan as the primary served it: the records
+// under Prefix after the key After, in order, shaped by Projection. A
+// Truncated page stops at its last record; otherwise it runs to the end
+// of the prefix.
+type ScanPage struct {
+	Prefix     string
+	After      string
+	Projection *types.Projection
+	Records    []*types.Record
+	Truncated  bool
+}
+
+type job struct {
+	key  string        // a Get's key
+	rec  *types.Record // what the primary returned for it, nil if missing
+	page *ScanPage
+}
+
+// Shadow compares reads against a Reader. Create it with New and Close it
+// when done; the Reader stays the caller's to close.
+type Shadow struct {
+	src     Reader
+	name    string
+	timeout time.Duration
+	jobs    chan job
+	done    chan struct{}
+	stop    context.CancelFunc
+	ctx     context.Context
+
+	mu         sync.Mutex // guards what follows
+	tokens     float64
+	rate       float64
+	last       time.Time
+	reads      int64
+	keys       int64
+	mismatches int64
+	skipped    int64
+	errors     int64
+	lastError  string
+	samples    []stats.ShadowMismatch // a ring of cap samples
+	next       int
+	closed     bool
+}
+
+// New starts comparing reads against src, which name describes in stats
+// (a data directory or URL).
+func New(src Reader, name string, opts Options) *Shadow {
+	if opts.RatePerSec <= 0 {
+		opts.RatePerSec = DefaultRatePerSec
+	}
+	if opts.Queue <= 0 {
+		opts.Queue = DefaultQueue
+	}
+	if opts.Samples <= 0 {
+		opts.Samples = DefaultSamples
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	s := &Shadow{
+		src:     src,
+		name:    name,
+		timeout: opts.Timeout,
+		jobs:    make(chan job, opts.Queue),
+		done:    make(chan struct{}),
+		stop:    stop,
+		ctx:     ctx,
+		tokens:  float64(opts.RatePerSec),
+		rate:    float64(opts.RatePerSec),
+		last:    time.Now(),
+		samples: make([]stats.ShadowMismatch, 0, opts.Samples),
+	}
+	go s.run()
+	return s
+}
+
+// Get queues the comparison of a Get of key, for which the primary
+// returned rec, or nil if the key was missing. It never blocks.
+func (s *Shadow) Get(key string, rec *types.Record) {
+	s.enqueue(job{key: key, rec: rec}, 1)
+}
+
+// Scan queues the comparison of a scan page. It never blocks. Pages after
+// a cursor are skipped: the shadow would have to read every record before
+// them again to find where they start.
+func (s *Shadow) Scan(page ScanPage) {
+	if page.After != "" {
+		s.skip()
+		return
+	}
+	s.enqueue(job{page: &page}, max(1, len(page.Records)))
+}
+
+func (s *Shadow) skip() {
+	s.mu.Lock()
+	s.skipped++
+	s.mu.Unlock()
+}
+
+// enqueue takes cost tokens for j and queues it, or counts it skipped if
+// either is short.
+func (s *Shadow) enqueue(j job, cost int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.tokens = min(s.rate, s.tokens+now.Sub(s.last).Seconds()*s.rate)
+	s.last = now
+	// A page larger than a second's worth waits for a full bucket
+	need := min(float64(cost), s.rate)
+	if s.closed || s.tokens < need {
+		s.skipped++
+		return
+	}
+	select {
+	case s.jobs <- j:
+		s.tokens -= need
+	default:
+		s.skipped++
+	}
+}
+
+func (s *Shadow) run() {
+	defer close(s.done)
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case j := <-s.jobs:
+			if j.page != nil {
+				s.compareScan(j.page)
+			} else {
+				s.compareGet(j.key, j.rec)
+			}
+		}
+	}
+}
+
+func (s *Shadow) compareGet(key string, rec *types.Record) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.timeout)
+	defer cancel()
+	other, err := s.src.Get(ctx, key)
+	switch {
+	case errors.Is(err, types.ErrKeyNotFound):
+		other = nil
+	case err != nil:
+		s.failed(err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reads++
+	s.compareLocked("get", key, rec, other)
+}
+
+func (s *Shadow) compareScan(page *ScanPage) {
+	ctx, cancel := context.WithTimeout(s.ctx, s.timeout)
+	defer cancel()
+	var last string
+	if page.Truncated && len(page.Records) > 0 {
+		last = page.Records[len(page.Records)-1].ID
+	}
+	var others []*types.Record
+	err := s.src.Scan(ctx, page.Prefix, func(rec *types.Record) bool {
+		if last != "" && rec.ID > last {
+			return false
+		}
+		others = append(others, page.Projection.Apply(rec))
+		return true
+	})
+	if err != nil {
+		s.failed(err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reads++
+	// Both are in key order: merge them
+	primary := page.Records
+	for len(primary) > 0 || len(others) > 0 {
+		switch {
+		case len(others) == 0 || len(primary) > 0 && primary[0].ID < others[0].ID:
+			s.compareLocked("scan", primary[0].ID, primary[0], nil)
+			primary = primary[1:]
+		case len(primary) == 0 || others[0].ID < primary[0].ID:
+			s.compareLocked("scan", others[0].ID, nil, others[0])
+			others = others[1:]
+		default:
+			s.compareLocked("scan", primary[0].ID, primary[0], others[0])
+			primary, others = primary[1:], others[1:]
+		}
+	}
+}
+
+func (s *Shadow) failed(err error) {
+	if s.ctx.Err() != nil {
+		return // closing
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errors++
+	s.lastError = err.Error()
+}
+
+// compareLocked counts key compared and samples it if a and b, the
+// primary's and the shadow's records, differ.
+func (s *Shadow) compareLocked(op, key string, a, b *types.Record) {
+	s.keys++
+	reason := diff(a, b)
+	if reason == "" {
+		return
+	}
+	s.mismatches++
+	m := stats.ShadowMismatch{At: time.Now().UTC(), Op: op, Key: key, Reason: reason}
+	if a != nil {
+		m.PrimaryVersion = a.Version
+	}
+	if b != nil {
+		m.ShadowVersion = b.Version
+	}
+	if len(s.samples) < cap(s.samples) {
+		s.samples = append(s.samples, m)
+	} else {
+		s.samples[s.next] = m
+	}
+	s.next = (s.next + 1) % cap(s.samples)
+}
+
+// diff says how a and b differ, or "" if they hold the same content.
+// Versions and timestamps are not compared: a store the data was copied
+// into numbers and stamps it afresh.
+func diff(a, b *types.Record) string {
+	switch {
+	case a == nil && b == nil:
+		return ""
+	case b == nil:
+		return ReasonMissingShadow
+	case a == nil:
+		return ReasonMissingPrimary
+	case !sameData(a.Data, b.Data):
+		return ReasonData
+	case !slices.Equal(a.Vector, b.Vector):
+		return ReasonVector
+	case !bytes.Equal(a.Blob, b.Blob) || a.ContentType != b.ContentType:
+		return ReasonBlob
+	case !slices.Equal(a.Tags, b.Tags):
+		return ReasonTags
+	case (a.TTL == nil) != (b.TTL == nil) || a.TTL != nil && !a.TTL.Equal(*b.TTL):
+		return ReasonTTL
+	}
+	return ""
+}
+
+// sameData compares Data as JSON, so numbers decoded from a remote shadow
+// as float64 match the ints a primary was handed.
+func sameData(a, b map[string]interface{}) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	ja, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	jb, err := json.Marshal(b)
+	return err == nil && bytes.Equal(ja, jb)
+}
+
+// Stats reports the comparisons so far, the samples latest first.
+func (s *Shadow) Stats() *stats.ShadowStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := &stats.ShadowStats{
+		Source:     s.name,
+		Reads:      s.reads,
+		Keys:       s.keys,
+		Mismatches: s.mismatches,
+		Skipped:    s.skipped,
+		Errors:     s.errors,
+		Pending:    len(s.jobs),
+		LastError:  s.lastError,
+		Samples:    make([]stats.ShadowMismatch, 0, len(s.samples)),
+	}
+	for i := range len(s.samples) {
+		st.Samples = append(st.Samples, s.samples[(s.next-1-i+len(s.samples))%len(s.samples)])
+	}
+	return st
+}
+
+// Close stops comparing; comparisons still queued are dropped.
+func (s *Shadow) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.stop()
+	<-s.done
+}
@@ -0,0 +1,87 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// LatencyBoundsMs are the upper bounds of the latency histogram buckets. A
+// last, unbounded bucket counts anything slower.
+var LatencyBoundsMs = []float64{1, 5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000}
+
+// Calls counts calls per method by outcome and latency. It is safe for
+// concurrent use; a nil *Calls records nothing.
+type Calls struct {
+	mu      sync.Mutex
+	methods map[string]*CallStats
+}
+
+// CallStats describes the calls to one method.
+type CallStats struct {
+	Count     uint64            `json:"count"`
+	Codes     map[string]uint64 `json:"codes"` // by status code, "OK" included
+	LatencyMs Histogram         `json:"latency_ms"`
+}
+
+// Histogram counts latencies per LatencyBoundsMs bucket, then the overflow
+// bucket. Buckets are not cumulative.
+type Histogram struct {
+	Buckets []uint64 `json:"buckets"`
+	SumMs   float64  `json:"sum_ms"`
+}
+
+func NewCalls() *Calls {
+	return &Calls{methods: make(map[string]*CallStats)}
+}
+
+// Record counts one call to method that ended with code after elapsed.
+func (c *Calls) Record(method, code string, elapsed time.Duration) {
+	if c == nil {
+		return
+	}
+	ms := float64(elapsed.Microseconds()) / 1000
+	bucket := len(LatencyBoundsMs)
+	for i, bound := range LatencyBoundsMs {
+		if ms <= bound {
+			bucket = i
+			break
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := c.methods[method]
+	if m == nil {
+		m = &CallStats{
+			Codes:     make(map[string]uint64),
+			LatencyMs: Histogram{Buckets: make([]uint64, len(LatencyBoundsMs)+1)},
+		}
+		c.methods[method] = m
+	}
+	m.Count++
+	m.Codes[code]++
+	m.LatencyMs.Buckets[bucket]++
+	m.LatencyMs.SumMs += ms
+}
+
+// Snapshot returns a copy of the counters; nil for a nil *Calls.
+func (c *Calls) Snapshot() map[string]CallStats {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make(map[string]CallStats, len(c.methods))
+	for method, m := range c.methods {
+		cp := *m
+		cp.Codes = make(map[string]uint64, len(m.Codes))
+		for code, n := range m.Codes {
+			cp.Codes[code] = n
+		}
+		cp.LatencyMs.Buckets = append([]uint64(nil), m.LatencyMs.Buckets...)
+		out[method] = cp
+	}
+	return out
+}
@@ -0,0 +1,75 @@
+package stats
+
+import "sync"
+
+// Pool counts the holders of a limited resource, such as open connections,
+// and turns away those beyond its limit. It is safe for concurrent use; a
+// nil *Pool admits everything and counts nothing.
+type Pool struct {
+	mu       sync.Mutex
+	limit    int
+	current  int
+	peak     int
+	rejected uint64
+}
+
+// PoolStats describes a Pool.
+type PoolStats struct {
+	Current  int    `json:"current"`
+	Peak     int    `json:"peak"`
+	Limit    int    `json:"limit"` // 0 = unlimited
+	Rejected uint64 `json:"rejected"`
+}
+
+// NewPool returns a pool admitting up to limit holders at once; 0 means no
+// limit.
+func NewPool(limit int) *Pool {
+	return &Pool{limit: limit}
+}
+
+// SetLimit changes the limit. Holders already admitted keep their place.
+func (p *Pool) SetLimit(limit int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limit = limit
+}
+
+// Acquire admits one holder, or counts a rejection and returns false when
+// the pool is full. Every admitted holder must Release.
+func (p *Pool) Acquire() bool {
+	if p == nil {
+		return true
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.limit > 0 && p.current >= p.limit {
+		p.rejected++
+		return false
+	}
+	p.current++
+	p.peak = max(p.peak, p.current)
+	return true
+}
+
+// Release gives back a place taken by Acquire.
+func (p *Pool) Release() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current--
+}
+
+// Snapshot returns the counters; nil for a nil *Pool.
+func (p *Pool) Snapshot() *PoolStats {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return &PoolStats{Current: p.current, Peak: p.peak, Limit: p.limit, Rejected: p.rejected}
+}
@@ -0,0 +1,260 @@
+// Package stats builds the server statistics report shared by the REST
+// /api/v1/stats endpoint and the gRPC Stats call.
+package stats
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// SchemaVersion identifies the layout of Report. Adding fields keeps it;
+// removing or changing the meaning of one bumps it, so dashboards can tell
+// a report they understand from one they do not.
+const SchemaVersion = 1
+
+// TopCollections is how many of the largest collections a Report lists.
+const TopCollections = 10
+
+// Report is a point-in-time view of the server: engine internals, Go
+// runtime, pub/sub, gRPC calls and connections.
+type Report struct {
+	SchemaVersion int                `json:"schema_version"`
+	UptimeSeconds float64            `json:"uptime_seconds"`
+	Engine        *types.EngineStats `json:"engine,omitempty"` // nil if the engine does not report stats
+	Runtime       RuntimeStats       `json:"runtime"`
+	PubSub        pubsub.HubStats    `json:"pubsub"`
+	// GRPC counts calls per gRPC method (full method name). Callers fill it
+	// in from the server's Calls; nil when they are not recorded.
+	GRPC map[string]CallStats `json:"grpc,omitempty"`
+	// Connections counts open HTTP connections and gRPC calls in flight;
+	// Streams counts subscriptions (SSE, gRPC Watch and Stream), which
+	// are limited apart. Callers fill them in from the server's Pools.
+	Connections *PoolStats `json:"connections,omitempty"`
+	Streams     *PoolStats `json:"streams,omitempty"`
+	// Replication is set on a follower. Callers fill it in from the
+	// follower; nil on a primary.
+	Replication *ReplicationStats `json:"replication,omitempty"`
+	// CDC is set when changes are pushed to sinks. Callers fill it in from
+	// the pipeline.
+	CDC *CDCStats `json:"cdc,omitempty"`
+	// Shadow is set when reads are compared against a shadow source, and
+	// leaves out the samples. Callers fill it in from the comparison.
+	Shadow *ShadowStats `json:"shadow,omitempty"`
+	// QueryCache is set when SQL results are cached. Callers fill it in
+	// from the cache.
+	QueryCache *QueryCacheStats `json:"query_cache,omitempty"`
+	// LiveQueries is set when SQL live queries are served. Callers fill it
+	// in from them.
+	LiveQueries *LiveQueryStats `json:"live_queries,omitempty"`
+	// Mirror is set while requests are mirrored to a second server.
+	// Callers fill it in from the mirror.
+	Mirror *MirrorStats `json:"mirror,omitempty"`
+	// Retention is set while retention rules are kept. Callers fill it in
+	// from the rules.
+	Retention *RetentionStats `json:"retention,omitempty"`
+}
+
+// ReplicationStats describes a follower of a primary. Lag is how many
+// LSNs the primary had logged, as of its last message, beyond the last
+// change applied here; LagSeconds is how long ago the follower was last
+// caught up, 0 while it is.
+type ReplicationStats struct {
+	Primary     string    `json:"primary"`
+	State       string    `json:"state"` // "syncing", "streaming", "disconnected" or "promoted"
+	AppliedLSN  uint64    `json:"applied_lsn"`
+	PrimaryLSN  uint64    `json:"primary_lsn"`
+	Lag         uint64    `json:"lag"`
+	LagSeconds  float64   `json:"lag_seconds"`
+	LastContact time.Time `json:"last_contact,omitzero"`
+	Syncs       int       `json:"syncs"` // full syncs from a snapshot, the first included
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// CDCStats describes change data capture: the outbox of changes captured
+// from the WAL and each sink's progress through it. Dropped counts changes
+// left out because the outbox was full; Gaps counts the times changes were
+// missed altogether, after a crash or when capture fell behind the WAL.
+type CDCStats struct {
+	CapturedLSN uint64      `json:"captured_lsn"`
+	Captured    int64       `json:"captured"`
+	Dropped     int64       `json:"dropped"`
+	Gaps        int         `json:"gaps"`
+	OutboxBytes int64       `json:"outbox_bytes"`
+	OutboxLimit int64       `json:"outbox_limit_bytes"`
+	Segments    int         `json:"segments"`
+	Sinks       []SinkStats `json:"sinks"`
+}
+
+// QueryCacheStats describes the SQL query cache. Invalidations counts the
+// results dropped because a write changed what they read, Evictions those
+// dropped to make room, and Flushes the times the whole cache was emptied.
+type QueryCacheStats struct {
+	Entries       int   `json:"entries"`
+	MaxEntries    int   `json:"max_entries"`
+	TTLMs         int64 `json:"ttl_ms"`
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Invalidations int64 `json:"invalidations"`
+	Evictions     int64 `json:"evictions"`
+	Flushes       int64 `json:"flushes"`
+}
+
+// LiveQueryStats describes SQL live queries. Active counts those running,
+// held by Clients clients; Started counts every one begun, Pushes the
+// results sent, the first of each included, and Rejected those refused
+// for their client's limit.
+type LiveQueryStats struct {
+	Active       int   `json:"active"`
+	Clients      int   `json:"clients"`
+	MaxPerClient int   `json:"max_per_client"`
+	DebounceMs   int64 `json:"debounce_ms"`
+	Started      int64 `json:"started"`
+	Pushes       int64 `json:"pushes"`
+	Rejected     int64 `json:"rejected"`
+}
+
+// ShadowStats describes reads compared against a shadow source (see
+// package shadow). Reads counts the Gets and scan pages compared and Keys
+// the keys in them; Mismatches counts the keys that differed, the latest
+// of which are in Samples, newest first. Skipped counts reads left out to
+// stay under the rate or the queue, Errors those the shadow failed.
+type ShadowStats struct {
+	Source     string           `json:"source"`
+	Reads      int64            `json:"reads"`
+	Keys       int64            `json:"keys"`
+	Mismatches int64            `json:"mismatches"`
+	Skipped    int64            `json:"skipped"`
+	Errors     int64            `json:"errors"`
+	Pending    int              `json:"pending"`
+	LastError  string           `json:"last_error,omitempty"`
+	Samples    []ShadowMismatch `json:"samples,omitempty"`
+}
+
+// ShadowMismatch is one key that read differently from the shadow, with
+// the versions each side had; a missing side has none.
+type ShadowMismatch struct {
+	At             time.Time `json:"at"`
+	Op             string    `json:"op"` // "get" or "scan"
+	Key            string    `json:"key"`
+	Reason         string    `json:"reason"`
+	PrimaryVersion uint64    `json:"primary_version,omitempty"`
+	ShadowVersion  uint64    `json:"shadow_version,omitempty"`
+}
+
+// MirrorStats describes requests mirrored to a second server. Mirrored
+// counts those it answered, Failed those it failed to, with an error or a
+// 5xx status, and Dropped those left out because MaxInFlight were already
+// on their way. LatencyDeltaMs is how much longer the mirror took than
+// this server over the latest answers, negative where it was faster.
+type MirrorStats struct {
+	Target         string        `json:"target"`
+	SamplePercent  float64       `json:"sample_percent"`
+	Methods        []string      `json:"methods"`
+	MaxInFlight    int           `json:"max_in_flight"`
+	TimeoutMs      int           `json:"timeout_ms"`
+	ForwardAuth    bool          `json:"forward_auth"`
+	InFlight       int           `json:"in_flight"`
+	Mirrored       int64         `json:"mirrored"`
+	Failed         int64         `json:"failed"`
+	Dropped        int64         `json:"dropped"`
+	LatencyDeltaMs LatencyDeltas `json:"latency_delta_ms"`
+	LastError      string        `json:"last_error,omitempty"`
+}
+
+// LatencyDeltas summarizes the latest Samples differences in latency
+// between two servers answering the same requests.
+type LatencyDeltas struct {
+	Samples int     `json:"samples"`
+	Mean    float64 `json:"mean"`
+	P50     float64 `json:"p50"`
+	P99     float64 `json:"p99"`
+}
+
+// RetentionStats describes the retention rules (see package retention),
+// applied every IntervalMs; 0 applies them only when asked.
+type RetentionStats struct {
+	IntervalMs int64                `json:"interval_ms"`
+	Rules      []RetentionRuleStats `json:"rules"`
+}
+
+// RetentionRuleStats describes one retention rule. Removed counts the
+// records it deleted since startup, over Runs runs; the last run matched
+// LastMatched records and deleted LastRemoved, or for a dry run rule would
+// have deleted LastWouldRemove. NextRun is when the rules are next applied.
+type RetentionRuleStats struct {
+	Prefix          string    `json:"prefix"`
+	MaxAgeSeconds   int64     `json:"max_age_seconds,omitempty"`
+	MaxCount        int64     `json:"max_count,omitempty"`
+	DryRun          bool      `json:"dry_run,omitempty"`
+	Runs            int64     `json:"runs"`
+	Removed         int64     `json:"removed"`
+	LastRun         time.Time `json:"last_run,omitzero"`
+	LastMatched     int64     `json:"last_matched"`
+	LastRemoved     int64     `json:"last_removed"`
+	LastWouldRemove int64     `json:"last_would_remove,omitempty"`
+	LastError       string    `json:"last_error,omitempty"`
+	NextRun         time.Time `json:"next_run,omitzero"`
+}
+
+// SinkStats describes one CDC sink. Lag is how many LSNs were captured
+// beyond the last change it took; LagSeconds is the age of the oldest
+// change it has yet to take, 0 when it has them all.
+type SinkStats struct {
+	Name         string    `json:"name"`
+	State        string    `json:"state"` // "ok" or "retrying"
+	CursorLSN    uint64    `json:"cursor_lsn"`
+	Delivered    int64     `json:"delivered"`
+	Failures     int64     `json:"failures"` // failed delivery attempts
+	Lag          uint64    `json:"lag"`
+	LagSeconds   float64   `json:"lag_seconds"`
+	LastDelivery time.Time `json:"last_delivery,omitzero"`
+	LastError    string    `json:"last_error,omitempty"`
+}
+
+// RuntimeStats are Go runtime numbers for the process.
+type RuntimeStats struct {
+	Goroutines    int    `json:"goroutines"`
+	MemAllocBytes uint64 `json:"mem_alloc_bytes"`
+	MemTotalBytes uint64 `json:"mem_total_bytes"`
+	MemSysBytes   uint64 `json:"mem_sys_bytes"`
+	GCCycles      uint32 `json:"gc_cycles"`
+}
+
+// Collect builds a Report. hub may be nil.
+func Collect(eng types.Engine, hub *pubsub.Hub, started time.Time) Report {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	report := Report{
+		SchemaVersion: SchemaVersion,
+		UptimeSeconds: time.Since(started).Truncate(time.Second).Seconds(),
+		Runtime: RuntimeStats{
+			Goroutines:    runtime.NumGoroutine(),
+			MemAllocBytes: mem.Alloc,
+			MemTotalBytes: mem.TotalAlloc,
+			MemSysBytes:   mem.Sys,
+			GCCycles:      mem.NumGC,
+		},
+	}
+	if r, ok := eng.(types.StatsReporter); ok {
+		engine := r.Stats()
+		if c, ok := eng.(types.CapabilityReporter); ok {
+			caps := c.Capabilities()
+			engine.Capabilities = &caps
+		}
+		if c, ok := eng.(types.CollectionStatser); ok {
+			// Leave them out rather than fail the report; they are cached,
+			// so most reports do not walk the keys at all
+			engine.Collections, _ = c.TopCollections(context.Background(), TopCollections)
+		}
+		report.Engine = &engine
+	}
+	if hub != nil {
+		report.PubSub = hub.Stats()
+	}
+	return report
+}
@@ -0,0 +1,152 @@
+package types
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// The typed getters read one field of a record's Data, reporting false
+// when it is missing or holds something else. Numbers are taken in every
+// form they reach Data in: float64 from JSON, over HTTP or gRPC; int64,
+// uint64 and float32 from the msgpack codec; json.Number from a WAL read
+// exactly; and any Go number a library caller put.
+
+// GetString returns the string in field.
+func (r *Record) GetString(field string) (string, bool) {
+	s, ok := r.Data[field].(string)
+	return s, ok
+}
+
+// GetInt64 returns the integer in field. A float qualifies if it is a
+// whole number in range, as integers are once JSON has carried them; a
+// numeric string does not.
+func (r *Record) GetInt64(field string) (int64, bool) {
+	switch v := r.Data[field].(type) {
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return n, true
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return 0, false
+		}
+		return floatInt(f)
+	case float64:
+		return floatInt(v)
+	case float32:
+		return floatInt(float64(v))
+	}
+	v := reflect.ValueOf(r.Data[field])
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n := v.Uint(); n <= math.MaxInt64 {
+			return int64(n), true
+		}
+	}
+	return 0, false
+}
+
+// floatInt returns f as an int64 if it is a whole number in range.
+func floatInt(f float64) (int64, bool) {
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int64(f), true
+}
+
+// GetFloat64 returns the number in field, of any type.
+func (r *Record) GetFloat64(field string) (float64, bool) {
+	if n, ok := r.Data[field].(json.Number); ok {
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	v := reflect.ValueOf(r.Data[field])
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(v.Uint()), true
+	}
+	return 0, false
+}
+
+// GetBool returns the bool in field.
+func (r *Record) GetBool(field string) (bool, bool) {
+	b, ok := r.Data[field].(bool)
+	return b, ok
+}
+
+// GetTime returns the time in field: a time.Time as a library caller or
+// the msgpack codec put it, or the RFC 3339 string JSON carries one as.
+func (r *Record) GetTime(field string) (time.Time, bool) {
+	switch v := r.Data[field].(type) {
+	case time.Time:
+		return v, true
+	case *time.Time:
+		if v != nil {
+			return *v, true
+		}
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// GetStringSlice returns the strings in field: a []string, or the list
+// JSON decodes one to if every item is a string. An empty list qualifies.
+func (r *Record) GetStringSlice(field string) ([]string, bool) {
+	switch v := r.Data[field].(type) {
+	case []string:
+		return v, true
+	case []interface{}:
+		out := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, false
+			}
+			out[i] = s
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// SetField sets field in Data, making Data first if it is nil. Like any
+// change to a record, it is not stored until the record is put; Version
+// and the timestamps are left to the engine.
+func (r *Record) SetField(field string, value interface{}) {
+	if r.Data == nil {
+		r.Data = make(map[string]interface{})
+	}
+	r.Data[field] = value
+}
+
+// DeleteField removes field from Data, leaving Data empty rather than nil
+// once the last one is gone.
+func (r *Record) DeleteField(field string) {
+	if r.Data == nil {
+		r.Data = make(map[string]interface{})
+	}
+	delete(r.Data, field)
+}
+
+// Decode fills v, a pointer to a struct or map, from Data by way of JSON:
+// fields match by their json tags, numbers fit the field they land in,
+// and times given as RFC 3339 strings fill time.Time fields. A field Data
+// lacks is left as it is.
+func (r *Record) Decode(v interface{}) error {
+	b, err := json.Marshal(r.Data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -1,6 +1,12 @@
 package types
 
-import "context"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
 
 type Mode string
 
@@ -18,6 +24,8 @@ const (
 	OpPut    Operation = "PUT"
 	OpDelete Operation = "DELETE"
 	OpBatch  Operation = "BATCH"
+	OpExpire Operation = "EXPIRE" // change feeds only: a TTL ran out and the record was collected
+	OpRename Operation = "RENAME" // WALs only: a record moved to a new key, its old one deleted
 )
 
 type ColumnType string
@@ -32,11 +40,867 @@ const (
 type Record struct {
 	ID   string                 `json:"id"`
 	Data map[string]interface{} `json:"data"`
+	// Vector is the record's embedding, indexed by engines with a vector
+	// index. It must have the engine's vector_dim entries.
+	Vector []float32 `json:"vector,omitempty"`
+	// Blob is opaque binary content kept beside Data, base64 in JSON, and
+	// ContentType says what it holds ("image/png", ...). Engines store both
+	// as they are given.
+	Blob        []byte `json:"blob,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	// Tags label the record without touching Data. Engines index them,
+	// so a TagScanner can scan the records with a tag.
+	Tags []string `json:"tags,omitempty"`
+	// Version starts at 1 and is bumped by the engine on every write; Put
+	// stamps it onto the record passed in.
+	Version uint64 `json:"version"`
+	// TTL is the absolute expiry time; nil never expires. Expired records
+	// read as missing.
+	TTL *time.Time `json:"ttl,omitempty"`
+	// CreatedAt is when the key was first written, or rewritten after it
+	// expired; UpdatedAt is when this version was written. Both are set by
+	// the engine alongside Version.
+	CreatedAt time.Time `json:"created_at,omitzero"`
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+	// Packed is Data compressed, set on the copy an engine keeps in memory
+	// when record_compress_min_bytes asks for it; Data is nil beside it.
+	// Records an engine hands out never have it.
+	Packed []byte `json:"-"`
+}
+
+// Expired reports whether r's TTL has passed at now.
+func (r *Record) Expired(now time.Time) bool {
+	return r.TTL != nil && !now.Before(*r.TTL)
+}
+
+// SecondsLeft returns the whole seconds, rounded up, before r's TTL passes
+// at now, and false if r has no TTL. An expired record has 0 left; one
+// that has not expired has at least 1.
+func (r *Record) SecondsLeft(now time.Time) (int64, bool) {
+	if r.TTL == nil {
+		return 0, false
+	}
+	if r.Expired(now) {
+		return 0, true
+	}
+	return max(int64(math.Ceil(r.TTL.Sub(now).Seconds())), 1), true
+}
+
+// Clone returns a deep copy of r, so the copy's Data can be modified while
+// readers still hold the original.
+func (r *Record) Clone() *Record {
+	cp := *r
+	cp.Data = cloneMap(r.Data)
+	if r.Vector != nil {
+		cp.Vector = append([]float32(nil), r.Vector...)
+	}
+	if r.Blob != nil {
+		cp.Blob = append([]byte(nil), r.Blob...)
+	}
+	if r.Tags != nil {
+		cp.Tags = append([]string(nil), r.Tags...)
+	}
+	return &cp
+}
+
+// Projection picks the parts of each record a scan hands out, so a client
+// after a few fields is never sent the rest. A nil *Projection picks all.
+type Projection struct {
+	// Fields are the Data entries kept; nil keeps them all.
+	Fields []string
+	// Vector keeps the record's vector.
+	Vector bool
+}
+
+// Apply returns the part of r that p picks. It shares r's values rather
+// than copying them, and returns r itself if p is nil.
+func (p *Projection) Apply(r *Record) *Record {
+	if p == nil {
+		return r
+	}
+	out := *r
+	if !p.Vector {
+		out.Vector = nil
+	}
+	if p.Fields != nil {
+		out.Data = make(map[string]interface{}, len(p.Fields))
+		for _, f := range p.Fields {
+			if v, ok := r.Data[f]; ok {
+				out.Data[f] = v
+			}
+		}
+	}
+	return &out
+}
+
+// Wrap returns fn handed each record as p picks it; fn itself if p is nil.
+func (p *Projection) Wrap(fn func(*Record) bool) func(*Record) bool {
+	if p == nil {
+		return fn
+	}
+	return func(r *Record) bool { return fn(p.Apply(r)) }
+}
+
+func cloneMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = cloneValue(v)
+	}
+	return out
+}
+
+func cloneValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return cloneMap(v)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, e := range v {
+			out[i] = cloneValue(e)
+		}
+		return out
+	case []float32:
+		return append([]float32(nil), v...)
+	default:
+		return v
+	}
+}
+
+// HealthChecker is implemented by engines whose internals can fail while the
+// process stays up (WAL writes, background workers). Each named check
+// returns nil when healthy.
+type HealthChecker interface {
+	HealthChecks() map[string]func(context.Context) error
+}
+
+// Watcher is implemented by engines with a change feed.
+type Watcher interface {
+	// Watch sends changes to keys under prefix until ctx ends. A non-zero
+	// fromSeq first replays the retained changes after it, or fails with
+	// ErrHistoryUnavailable if they are gone. The channel is closed when ctx
+	// ends, the engine closes, or the watcher falls too far behind; resume
+	// from the last Seq received.
+	Watch(ctx context.Context, prefix string, fromSeq uint64) (<-chan ChangeEvent, error)
+}
+
+// Batcher is implemented by engines that read, write or delete many keys
+// under a single lock acquisition.
+type Batcher interface {
+	// BatchPut stores each record under its ID, in order, stamping versions
+	// as Put does. It stops at the first failure; earlier records stay
+	// written.
+	BatchPut(ctx context.Context, records []*Record) error
+	// BatchGet returns the live record for each key that has one; missing
+	// and expired keys are absent from the map.
+	BatchGet(ctx context.Context, keys []string) (map[string]*Record, error)
+	// BatchDelete deletes keys and reports those that held a live record.
+	BatchDelete(ctx context.Context, keys []string) (map[string]bool, error)
+}
+
+// BatchMerger is implemented by engines that can merge records into those
+// stored in bulk, for pipelines that add fields to existing records.
+type BatchMerger interface {
+	// BatchMerge is BatchPut, except that a record whose key holds a live
+	// record is merged into it, bumping its version once. Data is merged
+	// deeply: where both hold an object under a key the objects are merged,
+	// and otherwise the incoming value wins, so a scalar replaces an object
+	// and an object a scalar. Null and arrays are values like any other, and
+	// nothing is removed. Vector, Blob with ContentType, Tags and TTL are
+	// kept unless the incoming record sets them. Each record passed in is
+	// left holding what was stored.
+	BatchMerge(ctx context.Context, records []*Record) error
+}
+
+// MoveOptions tunes Copy and Rename.
+type MoveOptions struct {
+	// Overwrite replaces a live record under dst; without it the move
+	// fails with ErrKeyExists.
+	Overwrite bool
+	// KeepCreatedAt carries src's CreatedAt over; otherwise dst's record
+	// is created at the time of the move.
+	KeepCreatedAt bool
+}
+
+// Mover is implemented by engines that copy and rename keys in one write,
+// for migrations between key layouts.
+type Mover interface {
+	// Copy stores a copy of src's live record under dst, vector, blob,
+	// tags and TTL included, at dst's next version, and returns it. It
+	// fails with ErrKeyNotFound if src has no live record.
+	Copy(ctx context.Context, src, dst string, opts MoveOptions) (*Record, error)
+	// Rename is Copy that deletes src under the same lock. Engines with a
+	// WAL log both as one OpRename entry, so a crash keeps both or neither.
+	Rename(ctx context.Context, src, dst string, opts MoveOptions) (*Record, error)
+}
+
+// ConsistentScanner is implemented by engines that can scan a snapshot.
+type ConsistentScanner interface {
+	// ScanConsistent is Scan with the records pinned as they were when it
+	// started: writes and deletes made while it runs are not seen, and
+	// every record comes from the same moment.
+	ScanConsistent(ctx context.Context, prefix string, fn func(*Record) bool) error
+}
+
+// ProjectingScanner is implemented by engines that can project records as
+// they scan them, so what a projection leaves out never reaches fn.
+type ProjectingScanner interface {
+	// ScanProjected is Scan handing fn only what p picks of each record.
+	ScanProjected(ctx context.Context, prefix string, p *Projection, fn func(*Record) bool) error
+}
+
+// TagScanner is implemented by engines that index records by tag.
+type TagScanner interface {
+	// ScanTag is Scan over the records tagged tag.
+	ScanTag(ctx context.Context, tag, prefix string, fn func(*Record) bool) error
+}
+
+// Change is a key's last change, as a ChangeScanner returns it: the
+// record it holds, or a tombstone if it was deleted or expired. At is when,
+// in Unix nanoseconds: the record's UpdatedAt, or the time of the delete.
+type Change struct {
+	Key     string  `json:"key"`
+	At      uint64  `json:"at"`
+	Deleted bool    `json:"deleted,omitempty"`
+	Record  *Record `json:"record,omitempty"`
+}
+
+// ChangeScanner is implemented by engines that index keys by when they
+// last changed, for clients that sync by asking what changed since they
+// last looked.
+type ChangeScanner interface {
+	// ScanSince returns the keys changed after since, oldest first; since
+	// 0 returns every key. A page holds up to limit changes (<= 0 for no
+	// limit), and more when several share the last At. next is the since
+	// of the following call, and equals since once there is nothing newer.
+	// Tombstones are kept in memory, a bounded number of them:
+	// ErrHistoryUnavailable means some after since were dropped, and the
+	// client must start over from 0.
+	ScanSince(ctx context.Context, since uint64, limit int) (changes []Change, next uint64, err error)
+}
+
+// ExpiryNotifier is implemented by engines that report records whose TTL
+// ran out.
+type ExpiryNotifier interface {
+	// OnExpire registers fn to be called with a copy of each record the
+	// engine removes because it expired, whether garbage collection or a
+	// read found it. Calls run on a pool of workers, so a slow fn does not
+	// hold up removal, and come at most once per expiry: those still
+	// queued when the engine closes are dropped. fn must not close the
+	// engine.
+	OnExpire(fn func(key string, rec *Record))
+}
+
+// Searcher is implemented by engines with a vector index.
+type Searcher interface {
+	// Search returns up to k records nearest to query, closest first.
+	Search(ctx context.Context, query []float32, k int) ([]*Record, error)
+}
+
+// FilteredSearcher is implemented by engines that can narrow a vector
+// search to the records with given values in their data. The fields given
+// to SetFilterFields get posting lists, consulted before the search
+// starts; a filter on any other field is checked on the records the
+// search finds, which asks the index for more of them the rarer a match
+// is.
+type FilteredSearcher interface {
+	Searcher
+	// SearchFiltered returns up to k records nearest to query whose Data
+	// holds every field of filter with its value, closest first. Values
+	// are strings, numbers or bools, and numbers match whatever their
+	// type; anything else fails with ErrInvalidFilter.
+	SearchFiltered(ctx context.Context, query []float32, k int, filter map[string]interface{}) ([]*Record, error)
+	// FilterFields returns the fields with posting lists, sorted.
+	FilterFields() []string
+	// SetFilterFields keeps posting lists for fields, and no others,
+	// building them from the records stored.
+	SetFilterFields(fields []string) error
+}
+
+// TextSearcher is implemented by engines with full-text indexes over
+// string fields of Data. Text is split into lowercase words of letters
+// and digits, less stopwords.
+type TextSearcher interface {
+	// CreateTextIndex indexes field in every record, those stored already
+	// included. Indexing a field twice is a no-op.
+	CreateTextIndex(field string) error
+	// TextSearch returns up to limit records (all for limit <= 0) whose
+	// field has every word of query, best first. OR between words
+	// separates alternatives, any of which may match. A field without an
+	// index fails with ErrNoTextIndex.
+	TextSearch(ctx context.Context, field, query string, limit int) ([]TextHit, error)
+}
+
+// TextHit is a record found by TextSearch. Score is how often the query
+// words occur in the field.
+type TextHit struct {
+	Record *Record `json:"record"`
+	Score  float64 `json:"score"`
+}
+
+// ChangeEvent is one change in a feed. Seq orders changes across keys and
+// starts over when the engine restarts. Record is the record as stored by
+// a put, or as it was before a delete or expiry.
+type ChangeEvent struct {
+	Seq    uint64
+	Op     Operation // OpPut, OpDelete or OpExpire
+	Key    string
+	Record *Record
+}
+
+// LogShipper is implemented by engines whose WAL can be streamed to
+// replicas. LSNs order the changes and carry on across restarts, with gaps
+// where compaction rewrote the log.
+type LogShipper interface {
+	// LSN returns the LSN of the last change logged. It waits out writes in
+	// progress, so reads that start after it returns see every change up to
+	// it.
+	LSN() uint64
+	// Ship sends the changes logged after afterLSN, in order, with the LSN
+	// as Seq, and then each change as it is logged. It fails with
+	// ErrHistoryUnavailable when those changes are no longer retained. The
+	// channel is closed when ctx ends, the engine closes, or the reader
+	// falls too far behind; resume after the last LSN received. A rename
+	// is logged under two LSNs and shipped as the put of its new key at
+	// the first, then the delete of its old one at the second.
+	Ship(ctx context.Context, afterLSN uint64) (<-chan ChangeEvent, error)
+}
+
+// Syncer is implemented by engines that log writes to a WAL.
+type Syncer interface {
+	// Sync makes every write that returned before it durable: the WAL's
+	// buffered entries are written and the file synced. With the WAL
+	// turned off there is nothing to sync, and it returns nil.
+	Sync(ctx context.Context) error
+}
+
+// Consistency is how up to date a read must be.
+type Consistency string
+
+const (
+	// ConsistencyEventual reads whatever each tier has; the default.
+	ConsistencyEventual Consistency = "eventual"
+	// ConsistencyReadYourWrites reads every write acknowledged before it,
+	// or up to a watermark, in every tier.
+	ConsistencyReadYourWrites Consistency = "read-your-writes"
+	// ConsistencyStrong is ConsistencyReadYourWrites with the WAL synced
+	// too.
+	ConsistencyStrong Consistency = "strong"
+)
+
+// ParseConsistency parses a consistency level; "" is eventual.
+func ParseConsistency(s string) (Consistency, error) {
+	switch c := Consistency(s); c {
+	case "":
+		return ConsistencyEventual, nil
+	case ConsistencyEventual, ConsistencyReadYourWrites, ConsistencyStrong:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown consistency %q (want eventual, read-your-writes or strong)", s)
+	}
+}
+
+// Watermark says how far an engine's writes have got. Written and Applied
+// number writes from 1, starting over when the engine opens: Written is
+// the last acknowledged, Applied the last every tier has. SyncedLSN is the
+// LSN up to which the WAL is synced.
+type Watermark struct {
+	Written   uint64 `json:"written"`
+	Applied   uint64 `json:"applied"`
+	SyncedLSN uint64 `json:"synced_lsn"`
+}
+
+// Watermarker is implemented by engines that acknowledge writes before
+// every tier has them, so reads of some tiers can lag.
+type Watermarker interface {
+	Watermark() Watermark
+	// Await returns once reads at level see every write up to the
+	// watermark after, or every write acknowledged so far when after is
+	// 0 or past the last one.
+	Await(ctx context.Context, level Consistency, after uint64) error
+}
+
+// Replica is implemented by engines that can follow a primary.
+type Replica interface {
+	// Apply stores a change shipped by the primary (OpPut or OpDelete)
+	// exactly as the primary did, version and timestamps included. It is
+	// allowed while the engine is read-only.
+	Apply(ctx context.Context, ev ChangeEvent) error
+	// SetReadOnly makes every write but Apply fail with ErrReadOnly, or
+	// lifts that again.
+	SetReadOnly(readOnly bool)
+	ReadOnly() bool
+}
+
+// CapabilityReporter is implemented by engines that say which optional
+// behaviour they have, so callers need not probe for it.
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// Capabilities lists what an engine supports beyond Engine. The flags for
+// optional interfaces are set exactly when the engine implements them.
+type Capabilities struct {
+	Batch  bool `json:"batch"`  // Batcher
+	Watch  bool `json:"watch"`  // Watcher
+	Search bool `json:"search"` // Searcher
+	// FilteredSearch is set for engines that filter vector searches on
+	// record fields (FilteredSearcher).
+	FilteredSearch bool `json:"filtered_search"`
+	Pin            bool `json:"pin"` // Pinner
+	// ConsistentScan is set for engines that scan snapshots
+	// (ConsistentScanner).
+	ConsistentScan bool `json:"consistent_scan"`
+	// TagScan is set for engines that index tags (TagScanner).
+	TagScan bool `json:"tag_scan"`
+	// ChangeScan is set for engines that index keys by when they changed
+	// (ChangeScanner).
+	ChangeScan bool `json:"change_scan"`
+	// Sync is set for engines with a WAL to sync (Syncer).
+	Sync bool `json:"sync"`
+	// TextSearch is set for engines with full-text indexes (TextSearcher).
+	TextSearch bool `json:"text_search"`
+	// Aggregate is set when the engine sums columns. Its column store is
+	// append-only, so totals still count overwritten and deleted values.
+	Aggregate bool `json:"aggregate"`
+	// VectorRequired is set when records without a vector are rejected
+	// with ErrInvalidVector.
+	VectorRequired bool `json:"vector_required"`
+}
+
+// DiskFencer is implemented by engines that fence writes while their data
+// dir has less than a low-water mark of free space.
+type DiskFencer interface {
+	// SetDiskFence changes the mark to minFree bytes (0 never fences),
+	// taking effect at the next check.
+	SetDiskFence(minFree uint64)
+}
+
+// WorkerReporter is implemented by engines with background workers. Unlike
+// Stats, Workers takes no engine lock, so it answers while a worker is
+// stuck behind one.
+type WorkerReporter interface {
+	Workers() []WorkerStats
 }
 
+// StatsReporter is implemented by engines that describe their internals.
+type StatsReporter interface {
+	Stats() EngineStats
+}
+
+// EngineStats is an engine's view of itself. Sections for components the
+// engine does not have (a WAL, a vector index, ...) are nil.
+type EngineStats struct {
+	Mode         Mode           `json:"mode"`
+	Records      int            `json:"records"`
+	Capabilities *Capabilities  `json:"capabilities,omitempty"`
+	AsyncQueue   *int           `json:"async_queue,omitempty"` // hybrid: writes not yet on disk
+	Watermark    *Watermark     `json:"watermark,omitempty"`
+	Cache        *CacheStats    `json:"cache,omitempty"`
+	WAL          *WALStats      `json:"wal,omitempty"`
+	Columnar     *ColumnarStats `json:"columnar,omitempty"`
+	Vector       *VectorStats   `json:"vector,omitempty"`
+	GC           *GCStats       `json:"gc,omitempty"`
+	// Compression counts the records the engine compressed in memory.
+	Compression *CompressionStats `json:"compression,omitempty"`
+	// RecordBytes estimates the memory the engine's records take, decoded,
+	// from their keys, data, vectors, blobs and tags plus a fixed overhead
+	// for the structures holding each one.
+	RecordBytes int64 `json:"record_bytes"`
+	// MemoryUsed estimates the bytes the engine holds: RecordBytes, plus
+	// the vector index and the copies in the hybrid memory tier.
+	MemoryUsed int64 `json:"memory_used"`
+	// Collections are the largest collections, by bytes. Callers fill
+	// them in from a CollectionStatser.
+	Collections []CollectionStats `json:"collections,omitempty"`
+	// Workers are the engine's background loops, such as the periodic
+	// garbage collector and the hybrid async writer.
+	Workers []WorkerStats `json:"workers,omitempty"`
+	// Disk is the free space of the data dir, for the engines that fence
+	// writes when it runs low.
+	Disk *DiskStats `json:"disk,omitempty"`
+	// Indexing is the queue of index updates behind the writes, for
+	// engines that index asynchronously.
+	Indexing *IndexingStats `json:"indexing,omitempty"`
+}
+
+// IndexingStats describes the index updates an engine queues behind its
+// writes (async_indexing). Queued is the backlog, out of Capacity, and
+// LagMs how long the oldest update in it has waited. Errors counts the
+// updates an index refused, such as a vector past
+// vector_index_max_memory_mb; LastError says why the last one was.
+type IndexingStats struct {
+	Workers   int    `json:"workers"`
+	Queued    int    `json:"queued"`
+	Capacity  int    `json:"capacity"`
+	LagMs     int64  `json:"lag_ms"`
+	Applied   uint64 `json:"applied"`
+	Errors    uint64 `json:"errors"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// IndexQueuer is implemented by engines that can update their indexes
+// behind their writes (async_indexing), so that searches, tag scans and
+// text queries may miss a write for a while after it is acknowledged.
+// With async indexing off both calls return at once.
+type IndexQueuer interface {
+	// IndexBarrier returns once every index update queued before it has
+	// been applied.
+	IndexBarrier(ctx context.Context) error
+	// FlushIndexes applies the queued index updates on the caller's
+	// goroutine instead of waiting for the workers, returning how many
+	// there were.
+	FlushIndexes() int
+}
+
+// DiskStats describes the file system holding an engine's data dir, as
+// last checked. While FreeBytes is under FenceBytes, writes are Fenced
+// and fail with ErrDiskFull. LastError is why the last check failed.
+type DiskStats struct {
+	Dir        string    `json:"dir"`
+	FreeBytes  uint64    `json:"free_bytes"`
+	FenceBytes uint64    `json:"fence_bytes"`
+	Fenced     bool      `json:"fenced"`
+	CheckedAt  time.Time `json:"checked_at,omitzero"`
+	LastError  string    `json:"last_error,omitempty"`
+}
+
+// WorkerStats describes a background worker. A worker finishes a cycle at
+// least every IntervalMs while it keeps up: a periodic one each time it
+// runs, one fed by a queue each time it takes from it and while idle.
+// QueueDepth is the work waiting for it, Processed the items it has done
+// and Errors its failed cycles. It is Stalled once it has gone
+// worker_stall_intervals intervals without finishing a cycle.
+type WorkerStats struct {
+	Name       string    `json:"name"`
+	IntervalMs int64     `json:"interval_ms"`
+	QueueDepth int       `json:"queue_depth"`
+	Processed  uint64    `json:"processed"`
+	Cycles     uint64    `json:"cycles"`
+	Errors     uint64    `json:"errors"`
+	LastRun    time.Time `json:"last_run,omitzero"` // the end of the last cycle
+	LastError  string    `json:"last_error,omitempty"`
+	Stalled    bool      `json:"stalled"`
+}
+
+// CollectionStats describes the live records under a key prefix.
+// ApproxBytes estimates the memory they take, and ComputedAt says when,
+// as the numbers may come from a cache.
+type CollectionStats struct {
+	Prefix      string    `json:"prefix"`
+	Keys        int       `json:"keys"`
+	ApproxBytes int64     `json:"approx_bytes"`
+	WithVector  int       `json:"with_vector"`
+	WithTTL     int       `json:"with_ttl"`
+	MinKey      string    `json:"min_key,omitempty"`
+	MaxKey      string    `json:"max_key,omitempty"`
+	ComputedAt  time.Time `json:"computed_at"`
+}
+
+// CollectionStatser is implemented by engines that can describe their
+// collections, the records under a key prefix. Each answer costs a walk
+// over the keys it covers, so engines cache them for
+// collection_stats_ttl_ms.
+type CollectionStatser interface {
+	CollectionStats(ctx context.Context, prefix string) (CollectionStats, error)
+	// TopCollections returns the n collections taking the most bytes,
+	// largest first. A key's collection is its prefix up to and including
+	// the first ':', such as "user:" for "user:42"; keys without one are
+	// left out.
+	TopCollections(ctx context.Context, n int) ([]CollectionStats, error)
+}
+
+// GCStats counts the garbage collection passes over the engine's records,
+// by the gc maintenance task, the periodic collector and recovery, and the
+// expired records they reclaimed.
+type GCStats struct {
+	Runs      uint64 `json:"runs"`
+	Reclaimed uint64 `json:"reclaimed"`
+}
+
+// WALStats describes the write-ahead log. SizeBytes excludes Buffered
+// entries not yet flushed. Pending counts the entries a crash could still
+// lose, those buffered and those written but not yet synced, and
+// SinceSync is how long ago the file was last synced, in milliseconds.
+type WALStats struct {
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"`
+	Buffered  int    `json:"buffered"`
+	Writes    uint64 `json:"writes"`
+	Flushes   uint64 `json:"flushes"`
+	LastLSN   uint64 `json:"last_lsn"`
+	Pending   int    `json:"pending"`
+	SinceSync int64  `json:"since_sync_ms"`
+	SyncedLSN uint64 `json:"synced_lsn"`
+	// Compression counts the entries logged compressed.
+	Compression *CompressionStats `json:"compression,omitempty"`
+}
+
+// RecordCoder is implemented by engines configured with a codec for the
+// records they serialize (config codec, "" for JSON). Backups of them are
+// written with it too.
+type RecordCoder interface {
+	RecordCodec() string
+}
+
+// CompressionStats counts the records compressed since the engine opened,
+// with their bytes before and after. SavedBytes is the difference.
+type CompressionStats struct {
+	Records     uint64 `json:"records"`
+	RawBytes    int64  `json:"raw_bytes"`
+	PackedBytes int64  `json:"packed_bytes"`
+	SavedBytes  int64  `json:"saved_bytes"`
+}
+
+// ColumnarStats describes the column store. CompressionRatio is raw over
+// compressed bytes for sealed blocks, 0 before any block is compressed.
+type ColumnarStats struct {
+	Rows             int     `json:"rows"`
+	Blocks           int     `json:"blocks"`
+	CompressedBlocks int     `json:"compressed_blocks"`
+	CompressionRatio float64 `json:"compression_ratio"`
+}
+
+// VectorStats describes the vector index; MemoryBytes is an estimate.
+type VectorStats struct {
+	Nodes       int              `json:"nodes"`
+	Levels      int              `json:"levels"`
+	Dim         int              `json:"dim"`
+	MemoryBytes int64            `json:"memory_bytes"`
+	Load        *IndexLoadStatus `json:"load,omitempty"` // hybrid mode, unless vector_index_load is eager
+	// Filters are the posting lists of the fields declared filterable,
+	// whose memory MemoryBytes includes.
+	Filters []FilterFieldStats `json:"filters,omitempty"`
+}
+
+// FilterFieldStats describes the posting lists of a filterable field:
+// the distinct values the records hold in it, and the records posted
+// under them.
+type FilterFieldStats struct {
+	Field   string `json:"field"`
+	Values  int    `json:"values"`
+	Records int    `json:"records"`
+}
+
+// IndexLoadStatus is how far hybrid mode has got indexing the vectors of
+// the records it recovered. State is "pending" until a lazy load's first
+// search, then "loading", and "loaded" or "failed". Loaded counts the
+// records gone through, of Total.
+type IndexLoadStatus struct {
+	State      string  `json:"state"`
+	Loaded     int64   `json:"loaded"`
+	Total      int64   `json:"total"`
+	Percent    float64 `json:"percent"`
+	DurationMs float64 `json:"duration_ms,omitempty"` // of the load, once it ended
+	Error      string  `json:"error,omitempty"`
+}
+
+// CacheStats describes a bounded cache in front of slower storage, such as
+// the hybrid engine's memory tier. SizeBytes is an estimate, and
+// CapacityBytes 0 means unbounded. MemoryHitRatio is the share of reads
+// answered from memory; DiskHitRatio the share of the rest found on disk.
+type CacheStats struct {
+	CapacityBytes  int64   `json:"capacity_bytes"`
+	SizeBytes      int64   `json:"size_bytes"`
+	Entries        int     `json:"entries"`
+	Pinned         int     `json:"pinned"`
+	Evictions      uint64  `json:"evictions"`
+	MemoryHits     uint64  `json:"memory_hits"`
+	DiskHits       uint64  `json:"disk_hits"`
+	Misses         uint64  `json:"misses"`
+	MemoryHitRatio float64 `json:"memory_hit_ratio"`
+	DiskHitRatio   float64 `json:"disk_hit_ratio"`
+}
+
+// Pinner is implemented by engines with a bounded cache. A pinned key stays
+// cached whenever it has a record, whatever its cache would evict. Pins
+// are kept in memory only and apply to the key, not a record: they
+// survive deletes but not restarts.
+type Pinner interface {
+	// Pin pins key, loading its record into the cache if it has one.
+	Pin(ctx context.Context, key string) error
+	// Unpin makes key evictable again.
+	Unpin(ctx context.Context, key string) error
+	// Pinned lists the pinned keys in order.
+	Pinned() []string
+}
+
+// Recoverer is implemented by engines that rebuild their records from a
+// log when they open. RecoveryStatus may be called at any time, from any
+// goroutine, during recovery too.
+type Recoverer interface {
+	RecoveryStatus() RecoveryStatus
+}
+
+// RecoveryStatus is how far an engine has got replaying its log.
+// RemainingMs is estimated from the rate so far; Error is set if the
+// replay failed, which leaves the engine unusable.
+type RecoveryStatus struct {
+	Recovering  bool    `json:"recovering"`
+	Entries     int64   `json:"entries"`
+	BytesDone   int64   `json:"bytes_done"`
+	BytesTotal  int64   `json:"bytes_total"`
+	Percent     float64 `json:"percent"`
+	RemainingMs int64   `json:"remaining_ms"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// Maintainer is implemented by engines with maintenance operations an
+// operator can trigger, keyed by name (MaintenanceCheckpoint, ...). Tasks
+// report progress through the callback as they go.
+type Maintainer interface {
+	Maintenance() map[string]MaintenanceFunc
+}
+
+// MaintenanceFunc runs one maintenance operation. progress may be called
+// any number of times with the units of work done out of total.
+type MaintenanceFunc func(ctx context.Context, progress func(done, total int)) error
+
+// Maintenance operation names.
+const (
+	MaintenanceCheckpoint     = "checkpoint"      // flush and sync the WAL
+	MaintenanceCompact        = "compact"         // rewrite the WAL with only live records
+	MaintenanceFlush          = "flush"           // drain write queues and seal open columnar blocks
+	MaintenanceReindexVectors = "reindex-vectors" // rebuild the vector index from stored records
+	MaintenanceRebuildIndexes = "rebuild-indexes" // rebuild every derived index from stored records
+	MaintenanceGC             = "gc"              // remove expired records
+)
+
+// IndexRebuilder is implemented by engines that can rebuild the indexes
+// they derive from their records while serving reads and writes.
+// RebuildIndexes builds a copy of each index of the given kinds (IndexTags,
+// ...), or of every kind the engine has if none are given, and swaps them
+// in together once they are built. Reads use the old indexes until then;
+// writes go to both. Progress counts keys. If ctx is cancelled first, the
+// old indexes stay.
+type IndexRebuilder interface {
+	RebuildIndexes(ctx context.Context, progress func(done, total int), kinds ...string) error
+}
+
+// Index kinds for RebuildIndexes.
+const (
+	IndexTags   = "tags"   // the keys carrying each tag
+	IndexText   = "text"   // the full-text indexes
+	IndexVector = "vector" // the HNSW graph
+)
+
+// IndexKinds lists every index kind, in the order they are rebuilt.
+var IndexKinds = []string{IndexTags, IndexText, IndexVector}
+
+// Verifier is implemented by engines that can check their own integrity,
+// as after an unclean shutdown. Verify reports what it finds rather than
+// failing on it; err is only a failure to check.
+type Verifier interface {
+	Verify(ctx context.Context, opts VerifyOptions) (*VerifyReport, error)
+}
+
+// VerifyOptions tune Verify.
+type VerifyOptions struct {
+	// Repair rebuilds what Verify finds wrong in structures derived from
+	// the records: the indexes, and the hybrid memory tier's copies of
+	// disk records. Damage to the records or the WAL is only reported.
+	Repair bool
+}
+
+// Checks Verify runs, as Inconsistency.Check names them. The tags and
+// vector checks are named after the index they check.
+const (
+	CheckWAL    = "wal"    // entry checksums and LSN order
+	CheckMemory = "memory" // the hybrid memory tier against disk
+)
+
+// Inconsistency is one problem Verify found.
+type Inconsistency struct {
+	Check  string `json:"check"`
+	Key    string `json:"key,omitempty"`
+	Detail string `json:"detail"`
+	// Repairable is set for a problem in a derived structure, which
+	// VerifyOptions.Repair rebuilds; Repaired once it has.
+	Repairable bool `json:"repairable"`
+	Repaired   bool `json:"repaired,omitempty"`
+}
+
+// VerifyReport is what Verify found. Issues holds the first
+// MaxVerifyIssues problems; Found counts them all.
+type VerifyReport struct {
+	Checks     []string        `json:"checks"`
+	Records    int             `json:"records"`
+	WALEntries int             `json:"wal_entries,omitempty"`
+	Found      int             `json:"found"`
+	Repaired   int             `json:"repaired"`
+	Issues     []Inconsistency `json:"issues"`
+	DurationMs float64         `json:"duration_ms"`
+}
+
+// MaxVerifyIssues bounds VerifyReport.Issues.
+const MaxVerifyIssues = 1000
+
+// OK reports whether Verify left nothing wrong: it found nothing, or
+// repaired all it found.
+func (r *VerifyReport) OK() bool { return r.Found == r.Repaired }
+
+// Engine errors. Engines wrap them with detail, so test with errors.Is.
+var (
+	ErrKeyNotFound   = errors.New("record not found") // missing or expired
+	ErrInvalidVector = errors.New("invalid vector")
+	ErrQueueFull     = errors.New("async write queue full")
+	ErrClosed        = errors.New("engine closed")
+	ErrReadOnly      = errors.New("read-only replica") // writes go to the primary
+	ErrNoTextIndex   = errors.New("no text index")
+	ErrWrongType     = errors.New("wrong kind of value")  // a list operation on a set, say
+	ErrRecovering    = errors.New("engine is recovering") // replaying its WAL after a restart
+	ErrMemoryLimit   = errors.New("memory limit reached") // an index is at its configured bound
+	ErrDiskFull      = errors.New("disk full")            // writes fenced while the data dir is low on space
+	ErrKeyExists     = errors.New("key already exists")   // a copy or rename onto a live key
+	ErrIndexLoading  = errors.New("vector index loading") // built from the records on disk after opening
+	ErrInvalidFilter = errors.New("invalid search filter")
+
+	ErrHistoryUnavailable = errors.New("change history no longer retained")
+)
+
+// ErrConnectionLimit is returned when a server turns away a connection,
+// call or subscription beyond its max_connections or max_streams limit.
+var ErrConnectionLimit = errors.New("connection limit reached")
+
+// ErrVersionMismatch is returned (wrapped in a *VersionMismatchError) when a
+// conditional write finds a different version than expected.
+var ErrVersionMismatch = errors.New("version mismatch")
+
+// VersionMismatchError reports the version actually stored; 0 means the key
+// does not exist.
+type VersionMismatchError struct {
+	Key      string
+	Expected uint64
+	Current  uint64
+}
+
+func (e *VersionMismatchError) Error() string {
+	return fmt.Sprintf("version mismatch for key %s: expected %d, current %d", e.Key, e.Expected, e.Current)
+}
+
+func (e *VersionMismatchError) Is(target error) bool { return target == ErrVersionMismatch }
+
 type Engine interface {
 	Put(ctx context.Context, key string, record *Record) error
 	Get(ctx context.Context, key string) (*Record, error)
 	Delete(ctx context.Context, key string) error
+	// CompareAndSwap writes record only if the key's current version equals
+	// version (0: the key must not exist). A nil record deletes the key.
+	// On mismatch it returns a *VersionMismatchError.
+	CompareAndSwap(ctx context.Context, key string, version uint64, record *Record) error
+	// Update atomically applies fn to a copy of the key's record and stores
+	// the result as the next version, all under the engine's write lock. An
+	// error from fn aborts the update and is returned unchanged.
+	Update(ctx context.Context, key string, fn func(*Record) error) (*Record, error)
+	// Scan calls fn for every record whose key starts with prefix, in key
+	// order, until fn returns false. Records are read in chunks so a scan
+	// never holds the whole keyspace in memory or blocks writers for long.
+	// It stops with ctx.Err() once ctx is cancelled. Scans are
+	// read-uncommitted: each chunk is read as the scan reaches it, so
+	// changes made while it runs show up in some chunks and not in others.
+	// ConsistentScanner scans one moment instead.
+	Scan(ctx context.Context, prefix string, fn func(*Record) bool) error
 	Close() error
 }
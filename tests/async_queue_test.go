@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// putConcurrently puts n records from 8 goroutines and returns the error
+// of each put by key.
+func putConcurrently(eng types.Engine, n int) map[string]error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	errs := make(map[string]error, n)
+	for w := range 8 {
+		wg.Go(func() {
+			for i := w; i < n; i += 8 {
+				key := fmt.Sprintf("k%05d", i)
+				err := eng.Put(context.Background(), key, &types.Record{ID: key, Data: map[string]interface{}{"i": i}})
+				mu.Lock()
+				errs[key] = err
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestHybridAcknowledgedWritesSurviveCrash(t *testing.T) {
+	for _, policy := range []string{config.QueueBlock, config.QueueFail, config.QueueSpill} {
+		t.Run(policy, func(t *testing.T) {
+			cfg := config.HybridConfig()
+			cfg.DataDir, cfg.AsyncQueueSize, cfg.AsyncQueueFull = t.TempDir(), 1, policy
+			eng, err := kvi.Open(cfg)
+			assert.NoError(t, err)
+			defer eng.Close()
+
+			errs := putConcurrently(eng, 2000)
+			assert.NoError(t, eng.Delete(context.Background(), "k00000"))
+
+			// The process "crashes" here: whatever is still queued never
+			// reaches the disk tier, but every acknowledged write is in the
+			// log, and no failed one is
+			logged := map[string]bool{}
+			var deleted bool
+			for _, frame := range scanWAL(t, filepath.Join(cfg.DataDir, wal.FileName)) {
+				assert.True(t, frame.Valid())
+				if frame.Entry.Op == types.OpDelete {
+					deleted = frame.Entry.Key == "k00000"
+					continue
+				}
+				logged[frame.Entry.Key] = true
+			}
+			assert.True(t, deleted, "the delete was logged last")
+			for key, err := range errs {
+				if err != nil {
+					assert.ErrorIs(t, err, types.ErrQueueFull)
+					assert.False(t, logged[key], key)
+					_, err := eng.Get(context.Background(), key)
+					assert.ErrorIs(t, err, types.ErrKeyNotFound, "a failed write changes nothing")
+					continue
+				}
+				assert.True(t, logged[key], key)
+			}
+			if policy != config.QueueFail {
+				assert.Len(t, logged, 2000)
+			}
+
+			// Restarting on the log as the crash left it brings back every
+			// acknowledged write
+			crashed := t.TempDir()
+			data, err := os.ReadFile(filepath.Join(cfg.DataDir, wal.FileName))
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(crashed, wal.FileName), data, 0o644))
+			restarted := *cfg
+			restarted.DataDir = crashed
+			reopened, err := kvi.Open(&restarted)
+			require.NoError(t, err)
+			defer reopened.Close()
+			for key, err := range errs {
+				_, getErr := reopened.Get(context.Background(), key)
+				if err != nil || key == "k00000" {
+					assert.ErrorIs(t, getErr, types.ErrKeyNotFound, key)
+				} else {
+					assert.NoError(t, getErr, key)
+				}
+			}
+		})
+	}
+}
+
+func TestHybridCloseDrainsQueue(t *testing.T) {
+	var logs bytes.Buffer
+	cfg := config.HybridConfig()
+	cfg.DataDir, cfg.AsyncQueueSize, cfg.AsyncQueueFull = t.TempDir(), 10, config.QueueSpill
+	cfg.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	eng, err := kvi.Open(cfg)
+	assert.NoError(t, err)
+
+	putConcurrently(eng, 500)
+	assert.NoError(t, eng.Close())
+	assert.Contains(t, logs.String(), `"msg":"async queue drained"`)
+	assert.Contains(t, logs.String(), `"left":0`)
+}
+
+func TestHybridQueueConfig(t *testing.T) {
+	cfg := config.HybridConfig()
+	cfg.AsyncQueueSize, cfg.AsyncQueueFull = 0, "drop"
+	err := cfg.Validate()
+	assert.ErrorContains(t, err, "async_queue_size")
+	assert.ErrorContains(t, err, `async_queue_full: unknown policy "drop"`)
+}
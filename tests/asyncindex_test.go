@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func asyncIndexConfig(t *testing.T, mode types.Mode) *config.Config {
+	cfg := config.VectorConfig(4)
+	cfg.Mode, cfg.DataDir = mode, t.TempDir()
+	cfg.EnableWAL = mode == types.ModeHybrid
+	cfg.AsyncIndexing, cfg.AsyncIndexWorkers, cfg.AsyncIndexQueue = true, 2, 64
+	return cfg
+}
+
+func TestAsyncIndexing(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []types.Mode{types.ModeMemory, types.ModeVector, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			eng, err := kvi.Open(asyncIndexConfig(t, mode))
+			require.NoError(t, err)
+			defer eng.Close()
+
+			// Writes beyond the queue's bound still go through, and every
+			// one is indexed in the end
+			const n = 300
+			for i := range n {
+				key := fmt.Sprintf("doc:%03d", i)
+				require.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, Tags: []string{"a"}, Vector: unitVector(4, i)}))
+			}
+			for i := range 10 {
+				require.NoError(t, eng.Delete(ctx, fmt.Sprintf("doc:%03d", i)))
+			}
+			require.Eventually(t, func() bool { return len(tagScan(t, eng, "a", "")) == n-10 }, 5*time.Second, 5*time.Millisecond)
+
+			// A barrier waits for what was queued before it
+			late := &types.Record{ID: "late", Tags: []string{"b"}, Vector: []float32{1, 1, 1, 1}}
+			require.NoError(t, eng.Put(ctx, "late", late))
+			queuer := eng.(types.IndexQueuer)
+			require.NoError(t, queuer.IndexBarrier(ctx))
+			assert.Equal(t, []string{"late"}, tagScan(t, eng, "b", ""))
+			if mode == types.ModeVector {
+				found, err := eng.(types.Searcher).Search(ctx, []float32{1, 1, 1, 1}, 1)
+				require.NoError(t, err)
+				require.Len(t, found, 1)
+				assert.Equal(t, "late", found[0].ID)
+			}
+
+			// A flush applies the backlog at once
+			require.NoError(t, eng.Delete(ctx, "late"))
+			queuer.FlushIndexes()
+			assert.Equal(t, []string{}, tagScan(t, eng, "b", ""))
+
+			st := eng.(types.StatsReporter).Stats().Indexing
+			require.NotNil(t, st)
+			assert.Zero(t, st.Queued)
+			assert.Zero(t, st.LagMs)
+			assert.Zero(t, st.Errors)
+			assert.Positive(t, st.Applied)
+			if mode == types.ModeVector {
+				assert.Equal(t, 4, st.Workers, "the tag and text indexes', and the graph's")
+			} else {
+				assert.Equal(t, 2, st.Workers)
+			}
+		})
+	}
+}
+
+func TestAsyncIndexingClose(t *testing.T) {
+	ctx := context.Background()
+	cfg := asyncIndexConfig(t, types.ModeVector)
+	cfg.AsyncIndexWorkers, cfg.AsyncIndexQueue = 1, 100000
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+
+	const n = 2000
+	for i := range n {
+		key := fmt.Sprintf("doc:%04d", i)
+		require.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, Tags: []string{"a"}, Vector: unitVector(4, i)}))
+	}
+	require.NoError(t, eng.Close())
+
+	// Close returned only once every queued update was applied
+	st := eng.(types.StatsReporter).Stats()
+	require.NotNil(t, st.Indexing)
+	assert.Zero(t, st.Indexing.Queued)
+	assert.EqualValues(t, 2*n, st.Indexing.Applied)
+	assert.Equal(t, n, st.Vector.Nodes)
+}
+
+func TestAsyncIndexingOff(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	require.NoError(t, eng.Put(context.Background(), "k", &types.Record{ID: "k", Tags: []string{"a"}}))
+
+	// Indexes are current as soon as a write returns
+	assert.Equal(t, []string{"k"}, tagScan(t, eng, "a", ""))
+	assert.NoError(t, eng.(types.IndexQueuer).IndexBarrier(context.Background()))
+	assert.Zero(t, eng.(types.IndexQueuer).FlushIndexes())
+	assert.Nil(t, eng.(types.StatsReporter).Stats().Indexing)
+}
+
+func TestAsyncIndexingAPI(t *testing.T) {
+	eng, err := kvi.Open(asyncIndexConfig(t, types.ModeMemory))
+	require.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	for i := range 50 {
+		code, _ := postBody(t, ts.URL+"/api/v1/put", jsonBody(map[string]interface{}{
+			"key": fmt.Sprintf("doc:%02d", i), "tags": []string{"a"},
+		}))
+		require.Equal(t, http.StatusCreated, code)
+	}
+	// Reading your writes waits for their index updates
+	var list struct{ Count int }
+	getJSON(t, ts.URL+"/api/v1/scan?tag=a&limit=100&consistency=read-your-writes", &list)
+	assert.Equal(t, 50, list.Count)
+
+	code, body := postBody(t, ts.URL+"/api/v1/admin/indexes/flush", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"flushed":0}`, body)
+
+	var report struct {
+		Engine struct{ Indexing *types.IndexingStats }
+	}
+	getJSON(t, ts.URL+"/api/v1/stats", &report)
+	require.NotNil(t, report.Engine.Indexing)
+	assert.EqualValues(t, 50, report.Engine.Indexing.Applied)
+	assert.Equal(t, 64, report.Engine.Indexing.Capacity)
+}
+
+func TestAsyncIndexingConfig(t *testing.T) {
+	cfg := config.MemoryConfig()
+	cfg.AsyncIndexing, cfg.AsyncIndexWorkers = true, 0
+	assert.ErrorContains(t, cfg.Validate(), "async_indexing")
+	cfg.AsyncIndexWorkers = 4
+	assert.NoError(t, cfg.Validate())
+}
@@ -0,0 +1,161 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/auth"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func newTestAuth(t *testing.T) *auth.Authenticator {
+	t.Helper()
+	a, err := auth.New(testSecret, time.Hour, auth.StaticKeys(map[string]auth.Role{
+		"reader-key": auth.RoleRead,
+		"writer-key": auth.RoleWrite,
+	}))
+	assert.NoError(t, err)
+	return a
+}
+
+func authedServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	t.Cleanup(func() { eng.Close() })
+
+	srv := api.NewServer(eng, api.WithAuth(newTestAuth(t)))
+	mux := http.NewServeMux()
+	srv.RegisterHandlers(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func login(t *testing.T, ts *httptest.Server, apiKey string) (string, int) {
+	t.Helper()
+	resp, err := http.Post(ts.URL+"/api/v1/auth", "application/json", jsonBody(map[string]string{"api_key": apiKey}))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	var body struct {
+		Token string `json:"token"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return body.Token, resp.StatusCode
+}
+
+func doAuthed(t *testing.T, method, url, token string, body interface{}) int {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, jsonBody(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestAuthRequiresCredentials(t *testing.T) {
+	ts := authedServer(t)
+
+	_, code := login(t, ts, "wrong-key")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	token, code := login(t, ts, "writer-key")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, http.StatusCreated, doAuthed(t, http.MethodPost, ts.URL+"/api/v1/put", token,
+		map[string]interface{}{"key": "k1", "data": map[string]interface{}{"v": 1}}))
+
+	resp, err := http.Get(ts.URL + "/api/v1/get?key=k1")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestAuthRejectsExpiredAndForeignTokens(t *testing.T) {
+	ts := authedServer(t)
+
+	expired, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+		Role: auth.RoleAdmin,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "old",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+	}).SignedString(testSecret)
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(t, http.MethodGet, ts.URL+"/api/v1/get?key=k1", expired, nil))
+
+	forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, auth.Claims{
+		Role: auth.RoleAdmin,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "mallory",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	}).SignedString([]byte("some-other-secret-of-32-bytes!!!"))
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(t, http.MethodGet, ts.URL+"/api/v1/get?key=k1", forged, nil))
+
+	// Refresh only works for tokens that are still valid
+	assert.Equal(t, http.StatusUnauthorized, doAuthed(t, http.MethodPost, ts.URL+"/api/v1/auth/refresh", expired, nil))
+	token, _ := login(t, ts, "reader-key")
+	assert.Equal(t, http.StatusOK, doAuthed(t, http.MethodPost, ts.URL+"/api/v1/auth/refresh", token, nil))
+}
+
+func TestAuthReadOnlyRole(t *testing.T) {
+	ts := authedServer(t)
+	token, _ := login(t, ts, "reader-key")
+
+	assert.Equal(t, http.StatusForbidden, doAuthed(t, http.MethodPost, ts.URL+"/api/v1/put", token,
+		map[string]interface{}{"key": "k1", "data": map[string]interface{}{}}))
+	assert.Equal(t, http.StatusForbidden, doAuthed(t, http.MethodDelete, ts.URL+"/api/v1/delete?key=k1", token, nil))
+	assert.Equal(t, http.StatusForbidden, doAuthed(t, http.MethodDelete, ts.URL+"/api/v1/channels/x", token, nil))
+	assert.Equal(t, http.StatusForbidden, doAuthed(t, http.MethodPost, ts.URL+"/api/v1/query", token,
+		map[string]string{"query": "DELETE FROM users WHERE id = 'u1'"}))
+
+	// A SELECT is allowed through; it fails only because the record is missing
+	assert.Equal(t, http.StatusBadRequest, doAuthed(t, http.MethodPost, ts.URL+"/api/v1/query", token,
+		map[string]string{"query": "SELECT * FROM users WHERE id = 'u1'"}))
+	assert.Equal(t, http.StatusNotFound, doAuthed(t, http.MethodGet, ts.URL+"/api/v1/get?key=k1", token, nil))
+}
+
+func TestGrpcAuthInterceptor(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	a := newTestAuth(t)
+	client := startGrpc(t, eng, pubsub.NewHub(),
+		grpc.UnaryInterceptor(kvi_grpc.UnaryAuthInterceptor(a)),
+		grpc.StreamInterceptor(kvi_grpc.StreamAuthInterceptor(a)))
+
+	withToken := func(apiKey string) context.Context {
+		token, _, err := a.Login(context.Background(), apiKey)
+		assert.NoError(t, err)
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+
+	_, err = client.Put(context.Background(), &kvi_grpc.PutRequest{Key: "k", DataJson: "{}"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.Put(withToken("reader-key"), &kvi_grpc.PutRequest{Key: "k", DataJson: "{}"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	_, err = client.Put(withToken("writer-key"), &kvi_grpc.PutRequest{Key: "k", DataJson: "{}"})
+	assert.NoError(t, err)
+
+	_, err = client.Get(withToken("reader-key"), &kvi_grpc.GetRequest{Key: "k"})
+	assert.NoError(t, err)
+}
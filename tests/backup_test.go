@@ -0,0 +1,220 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func memoryServer(t *testing.T) (types.Engine, *httptest.Server) {
+	t.Helper()
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	t.Cleanup(func() { eng.Close() })
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	t.Cleanup(ts.Close)
+	return eng, ts
+}
+
+func restore(t *testing.T, url string, body io.Reader, checksum string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url, body)
+	if checksum != "" {
+		req.Header.Set("X-Kvi-Checksum", checksum)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func TestBackupAndRestoreOverHTTP(t *testing.T) {
+	src, srcTS := memoryServer(t)
+	keys := fillEngine(t, src, "item:", 500)
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, src.Put(context.Background(), "session", &types.Record{ID: "session", TTL: &later}))
+
+	resp, err := http.Get(srcTS.URL + "/api/v1/backup")
+	assert.NoError(t, err)
+	snapshot, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NoError(t, err)
+	sum := sha256.Sum256(snapshot)
+	assert.Equal(t, hex.EncodeToString(sum[:]), resp.Trailer.Get("X-Kvi-Checksum"))
+	assert.Equal(t, "501", resp.Trailer.Get("X-Kvi-Records"))
+
+	dst, dstTS := memoryServer(t)
+	fillEngine(t, dst, "stale:", 3)
+
+	// A corrupted upload is rejected before anything is touched
+	assert.Equal(t, http.StatusBadRequest, restore(t, dstTS.URL+"/api/v1/restore", bytes.NewReader(snapshot), "deadbeef").StatusCode)
+	_, err = dst.Get(context.Background(), "stale:0000")
+	assert.NoError(t, err)
+
+	resp = restore(t, dstTS.URL+"/api/v1/restore", bytes.NewReader(snapshot), resp.Trailer.Get("X-Kvi-Checksum"))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var got []string
+	dst.Scan(context.Background(), "", func(rec *types.Record) bool {
+		got = append(got, rec.ID)
+		return true
+	})
+	assert.Equal(t, append(keys, "session"), got) // stale:* replaced
+	rec, err := dst.Get(context.Background(), "session")
+	assert.NoError(t, err)
+	assert.True(t, rec.TTL.Equal(later))
+
+	// Merge keeps keys missing from the backup
+	fillEngine(t, dst, "extra:", 1)
+	assert.Equal(t, http.StatusOK, restore(t, dstTS.URL+"/api/v1/restore?mode=merge", bytes.NewReader(snapshot), "").StatusCode)
+	_, err = dst.Get(context.Background(), "extra:0000")
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusBadRequest, restore(t, dstTS.URL+"/api/v1/restore", bytes.NewReader([]byte("junk")), "").StatusCode)
+}
+
+func TestConcurrentRestoreConflicts(t *testing.T) {
+	_, ts := memoryServer(t)
+
+	pr, pw := io.Pipe()
+	done := make(chan int)
+	go func() {
+		done <- restore(t, ts.URL+"/api/v1/restore", pr, "").StatusCode
+	}()
+	_, err := pw.Write([]byte("partial upload"))
+	assert.NoError(t, err)
+
+	// While the first upload is still open every other restore is turned away
+	assert.Eventually(t, func() bool {
+		return restore(t, ts.URL+"/api/v1/restore", bytes.NewReader(nil), "").StatusCode == http.StatusConflict
+	}, time.Second, 10*time.Millisecond)
+
+	pw.Close()
+	assert.Equal(t, http.StatusBadRequest, <-done)
+}
+
+// putVersions writes key n times, so it ends at version n.
+func putVersions(t *testing.T, eng types.Engine, key, value string, n int) {
+	t.Helper()
+	for range n {
+		assert.NoError(t, eng.Put(context.Background(), key, &types.Record{ID: key, Data: map[string]interface{}{"v": value}}))
+	}
+}
+
+func dumpEngine(t *testing.T, eng types.Engine) ([]byte, backup.Summary) {
+	t.Helper()
+	var buf bytes.Buffer
+	sum, err := backup.Dump(context.Background(), eng, &buf)
+	assert.NoError(t, err)
+	return buf.Bytes(), sum
+}
+
+func TestRestoreMerge(t *testing.T) {
+	ctx := context.Background()
+	src, _ := memoryServer(t)
+	putVersions(t, src, "a", "backup", 1)
+	putVersions(t, src, "b", "backup", 3)
+	putVersions(t, src, "c", "backup", 1)
+	snapshot, _ := dumpEngine(t, src)
+
+	dst, _ := memoryServer(t)
+	putVersions(t, dst, "a", "stored", 2) // newer than the backup's
+	putVersions(t, dst, "b", "stored", 1)
+	putVersions(t, dst, "d", "stored", 1)
+	values := func() map[string]interface{} {
+		got := map[string]interface{}{}
+		dst.Scan(ctx, "", func(rec *types.Record) bool {
+			got[rec.ID] = rec.Data["v"]
+			return true
+		})
+		return got
+	}
+	before := values()
+
+	plan, err := backup.DryRun(ctx, dst, bytes.NewReader(snapshot), backup.Merge)
+	assert.NoError(t, err)
+	assert.Equal(t, backup.Result{Restored: 2, Skipped: 1}, plan)
+	plan, err = backup.DryRun(ctx, dst, bytes.NewReader(snapshot), backup.Replace)
+	assert.NoError(t, err)
+	assert.Equal(t, backup.Result{Restored: 3, Removed: 3}, plan)
+	assert.Equal(t, before, values())
+
+	res, err := backup.Restore(ctx, dst, bytes.NewReader(snapshot), backup.Merge)
+	assert.NoError(t, err)
+	assert.Equal(t, backup.Result{Restored: 2, Skipped: 1}, res)
+	assert.Equal(t, map[string]interface{}{"a": "stored", "b": "backup", "c": "backup", "d": "stored"}, values())
+
+	_, err = backup.ParseStrategy("overwrite")
+	assert.Error(t, err)
+}
+
+func TestBackupVerify(t *testing.T) {
+	ctx := context.Background()
+	src, _ := memoryServer(t)
+	fillEngine(t, src, "item:", 3)
+	assert.NoError(t, src.Put(ctx, "emb", &types.Record{ID: "emb", Vector: []float32{1, 0}}))
+	snapshot, sum := dumpEngine(t, src)
+
+	report, err := backup.Verify(bytes.NewReader(snapshot), backup.Expect{Checksum: sum.Checksum, Records: sum.Records, VectorDim: 2})
+	assert.NoError(t, err)
+	assert.True(t, report.OK(), report.Problems)
+	assert.Equal(t, backup.Format, report.Format)
+	assert.Equal(t, 4, report.Records)
+	assert.Equal(t, 1, report.Vectors)
+	assert.Equal(t, sum.Checksum, report.Checksum)
+	assert.Equal(t, int64(len(snapshot)), report.Bytes)
+
+	report, err = backup.Verify(bytes.NewReader(snapshot), backup.Expect{Checksum: "00", Records: 5, VectorDim: 3})
+	assert.NoError(t, err)
+	assert.Len(t, report.Problems, 3)
+
+	_, err = backup.Verify(bytes.NewReader([]byte("junk")), backup.Expect{})
+	assert.ErrorIs(t, err, backup.ErrBadFormat)
+	_, err = backup.Verify(bytes.NewReader(snapshot[:len(snapshot)/2]), backup.Expect{})
+	assert.Error(t, err)
+}
+
+func TestRestoreDryRunOverHTTP(t *testing.T) {
+	src, _ := memoryServer(t)
+	fillEngine(t, src, "item:", 5)
+	snapshot, sum := dumpEngine(t, src)
+	dst, ts := memoryServer(t)
+	fillEngine(t, dst, "stale:", 2)
+
+	resp, err := http.Post(ts.URL+"/api/v1/restore?dry_run=true", "application/gzip", bytes.NewReader(snapshot))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		Status   string        `json:"status"`
+		DryRun   bool          `json:"dry_run"`
+		Restored int           `json:"restored"`
+		Removed  int           `json:"removed"`
+		Backup   backup.Report `json:"backup"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "ok", body.Status)
+	assert.True(t, body.DryRun)
+	assert.Equal(t, 5, body.Restored)
+	assert.Equal(t, 2, body.Removed)
+	assert.Equal(t, sum.Checksum, body.Backup.Checksum)
+	_, err = dst.Get(context.Background(), "stale:0000")
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusBadRequest, restore(t, ts.URL+"/api/v1/restore?dry_run=maybe", bytes.NewReader(snapshot), "").StatusCode)
+	assert.Equal(t, http.StatusBadRequest, restore(t, ts.URL+"/api/v1/restore?mode=overwrite", bytes.NewReader(snapshot), "").StatusCode)
+}
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/internal/bench"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func benchOptions(w bench.Workload) bench.Options {
+	o := bench.DefaultOptions()
+	o.Workload, o.Keys, o.Concurrency, o.Duration = w, 500, 4, 100*time.Millisecond
+	o.Dim, o.RecallQueries = 16, 20
+	return o
+}
+
+func TestBenchWorkloads(t *testing.T) {
+	for _, w := range []bench.Workload{bench.WriteOnly, bench.ReadOnly, bench.Mixed, bench.ScanHeavy} {
+		t.Run(string(w), func(t *testing.T) {
+			eng, err := kvi.Open(config.MemoryConfig())
+			assert.NoError(t, err)
+			defer eng.Close()
+			report, err := bench.Run(context.Background(), eng, benchOptions(w))
+			assert.NoError(t, err)
+			assert.Positive(t, report.Ops)
+			assert.Zero(t, report.Errors)
+			for name, s := range report.Operations {
+				assert.Positive(t, s.Count, name)
+				assert.LessOrEqual(t, s.P50Ms, s.P99Ms, name)
+				assert.LessOrEqual(t, s.P99Ms, s.MaxMs, name)
+			}
+			if w == bench.Mixed {
+				ops := report.Operations
+				assert.InDelta(t, 0.95, float64(ops["get"].Count)/float64(report.Ops), 0.02)
+			}
+			if w != bench.WriteOnly {
+				_, err := eng.Get(context.Background(), bench.Key(499))
+				assert.NoError(t, err) // the keyspace was loaded
+			}
+		})
+	}
+}
+
+func TestBenchVector(t *testing.T) {
+	o := benchOptions(bench.Vector)
+	eng, err := kvi.Open(config.VectorConfig(o.Dim))
+	assert.NoError(t, err)
+	defer eng.Close()
+	report, err := bench.Run(context.Background(), eng, o)
+	assert.NoError(t, err)
+	assert.Positive(t, report.Operations["search"].Count)
+	if assert.NotNil(t, report.Recall) {
+		assert.InDelta(t, 1.0, *report.Recall, 0.05)
+	}
+
+	// Searching needs types.Searcher
+	mem, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer mem.Close()
+	_, err = bench.Run(context.Background(), mem, o)
+	assert.ErrorContains(t, err, "search vectors")
+}
+
+func TestBenchZipfCache(t *testing.T) {
+	o := benchOptions(bench.ReadOnly)
+	o.Zipf, o.ValueSize = 1.2, 8<<10
+	cfg := config.HybridConfig()
+	cfg.DataDir, cfg.CacheSizeMB = t.TempDir(), 1
+	eng, err := kvi.Open(cfg)
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	report, err := bench.Run(context.Background(), eng, o)
+	assert.NoError(t, err)
+	assert.Zero(t, report.Errors)
+	assert.Positive(t, report.HeapEndMB)
+	if assert.NotNil(t, report.Cache) {
+		assert.Positive(t, report.Cache.Evictions)
+		assert.Greater(t, report.Cache.MemoryHitRatio, 0.5, "a skewed read mostly hits the cache")
+	}
+
+	o.Zipf = 1
+	assert.ErrorContains(t, o.Validate(), "zipf")
+}
+
+func TestBenchEmbeddings(t *testing.T) {
+	e := bench.NewEmbeddings(8, 4, 0.05, 7)
+	assert.Equal(t, e.Vector(3), bench.NewEmbeddings(8, 4, 0.05, 7).Vector(3))
+	assert.NotEqual(t, e.Vector(3), e.Vector(4))
+	assert.NotEqual(t, e.Vector(3), e.Query(3))
+
+	// Each vector is its own nearest neighbour
+	queries := [][]float32{e.Vector(10), e.Vector(20)}
+	nearest := e.Nearest(queries, 100, 5)
+	assert.Len(t, nearest[0], 5)
+	assert.Equal(t, 10, nearest[0][0])
+	assert.Equal(t, 20, nearest[1][0])
+}
+
+// flakyEngine fails every Get of an odd key.
+type flakyEngine struct{ types.Engine }
+
+func (e flakyEngine) Get(ctx context.Context, key string) (*types.Record, error) {
+	if key[len(key)-1]%2 == 1 {
+		return nil, errors.New("disk on fire")
+	}
+	return e.Engine.Get(ctx, key)
+}
+
+func TestBenchErrors(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+	report, err := bench.Run(context.Background(), flakyEngine{eng}, benchOptions(bench.ReadOnly))
+	assert.NoError(t, err)
+	get := report.Operations["get"]
+	assert.InDelta(t, 0.5, float64(get.Errors)/float64(get.Count), 0.1)
+	assert.Equal(t, "disk on fire", get.FirstError)
+	assert.Equal(t, get.Errors, report.Errors)
+
+	o := benchOptions("bogus")
+	_, err = bench.Run(context.Background(), eng, o)
+	assert.ErrorContains(t, err, "unknown workload")
+	o = benchOptions(bench.Mixed)
+	o.Concurrency = 0
+	assert.Error(t, o.Validate())
+}
@@ -0,0 +1,234 @@
+package tests
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/cdc"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// hook is a webhook endpoint that records the events it accepts and fails
+// while failing is set.
+type hook struct {
+	mu      sync.Mutex
+	events  []cdc.Event
+	failing bool
+	badSigs int
+}
+
+func (h *hook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	mac := hmac.New(sha256.New, []byte("hook-secret"))
+	mac.Write(body)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if r.Header.Get(cdc.SignatureHeader) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		h.badSigs++
+	}
+	if h.failing {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+		return
+	}
+	var batch []cdc.Event
+	if err := json.Unmarshal(body, &batch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h.events = append(h.events, batch...)
+}
+
+func (h *hook) setFailing(failing bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.failing = failing
+}
+
+// keys returns the keys of the distinct changes received, in LSN order,
+// failing the test if LSNs ever go backwards past a duplicate.
+func (h *hook) keys(t *testing.T) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var keys []string
+	var last uint64
+	for _, ev := range h.events {
+		if ev.LSN <= last {
+			continue // redelivered
+		}
+		last = ev.LSN
+		keys = append(keys, ev.Op+" "+ev.Key)
+	}
+	return keys
+}
+
+func openDisk(t *testing.T, dir string) types.Engine {
+	t.Helper()
+	cfg := config.DiskConfig()
+	cfg.DataDir = dir
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	return eng
+}
+
+func startCDC(t *testing.T, eng types.Engine, dir string, sinks []cdc.Sink, opts ...func(*cdc.CDC)) *cdc.CDC {
+	t.Helper()
+	opts = append([]func(*cdc.CDC){cdc.WithBatch(8, 10*time.Millisecond), cdc.WithRetry(5*time.Millisecond, 20*time.Millisecond)}, opts...)
+	c, err := cdc.New(eng, dir, sinks, opts...)
+	require.NoError(t, err)
+	require.NoError(t, c.Start())
+	return c
+}
+
+// Changes reach a webhook in order, signed, through its failures, and a
+// restart resumes with what it had not yet taken.
+func TestCDCWebhook(t *testing.T) {
+	ctx := context.Background()
+	dataDir, cdcDir := t.TempDir(), t.TempDir()
+	h := &hook{}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	sinks := []cdc.Sink{cdc.NewWebhook(ts.URL+"/kvi?token=secret", "hook-secret")}
+
+	eng := openDisk(t, dataDir)
+	require.NoError(t, eng.Put(ctx, "before", record("before", 0))) // not sent: CDC starts after it
+	c := startCDC(t, eng, cdcDir, sinks)
+
+	var want []string
+	h.setFailing(true)
+	for i := range 20 {
+		key := fmt.Sprintf("k%02d", i)
+		require.NoError(t, eng.Put(ctx, key, record(key, i)))
+		want = append(want, "put "+key)
+	}
+	require.Eventually(t, func() bool { return c.Stats().Sinks[0].State == cdc.StateRetrying }, 5*time.Second, 5*time.Millisecond)
+	h.setFailing(false)
+	require.NoError(t, eng.Delete(ctx, "k03"))
+	want = append(want, "delete k03")
+	require.Eventually(t, func() bool { return len(h.keys(t)) == len(want) }, 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, want, h.keys(t))
+
+	st := c.Stats()
+	require.Len(t, st.Sinks, 1)
+	assert.Equal(t, "webhook "+ts.Listener.Addr().String()+"/kvi", st.Sinks[0].Name)
+	assert.Equal(t, cdc.StateOK, st.Sinks[0].State)
+	assert.Positive(t, st.Sinks[0].Failures)
+	assert.EqualValues(t, len(want), st.Captured)
+	assert.Equal(t, st.CapturedLSN, st.Sinks[0].CursorLSN)
+	assert.Zero(t, st.Sinks[0].Lag)
+	assert.Zero(t, st.Gaps)
+
+	// Changes made while the sink is down, and up to the engine closing,
+	// survive the restart
+	h.setFailing(true)
+	for i := range 5 {
+		key := fmt.Sprintf("down%d", i)
+		require.NoError(t, eng.Put(ctx, key, record(key, i)))
+		want = append(want, "put "+key)
+	}
+	require.Eventually(t, func() bool { return c.Stats().Sinks[0].Lag > 0 }, 5*time.Second, 5*time.Millisecond)
+	require.NoError(t, eng.Close())
+	c.Stop()
+
+	h.setFailing(false)
+	eng = openDisk(t, dataDir)
+	defer eng.Close()
+	c = startCDC(t, eng, cdcDir, sinks)
+	defer c.Stop()
+	require.NoError(t, eng.Put(ctx, "after", record("after", 0)))
+	want = append(want, "put after")
+	require.Eventually(t, func() bool { return len(h.keys(t)) == len(want) }, 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, want, h.keys(t))
+	assert.Zero(t, c.Stats().Gaps)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	assert.Zero(t, h.badSigs)
+	assert.Equal(t, "after", h.events[len(h.events)-1].Record.ID)
+	assert.False(t, h.events[len(h.events)-1].Timestamp.IsZero())
+}
+
+// stuckSink never finishes a delivery.
+type stuckSink struct{}
+
+func (stuckSink) Name() string { return "stuck" }
+func (stuckSink) Deliver(ctx context.Context, _ []cdc.Event) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// countingSink counts the changes it takes.
+type countingSink struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (s *countingSink) Name() string { return "counting" }
+func (s *countingSink) Deliver(_ context.Context, events []cdc.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.n += len(events)
+	return nil
+}
+
+// A sink that never keeps up holds up neither writes nor the other sinks:
+// the outbox grows up to its limit, then drops changes.
+func TestCDCSlowSink(t *testing.T) {
+	ctx := context.Background()
+	eng := openDisk(t, t.TempDir())
+	defer eng.Close()
+	fast := &countingSink{}
+	dir := t.TempDir()
+	c := startCDC(t, eng, dir, []cdc.Sink{stuckSink{}, fast}, cdc.WithMaxOutbox(16<<10))
+	defer c.Stop()
+
+	start := time.Now()
+	for i := range 500 {
+		key := fmt.Sprintf("k%03d", i)
+		require.NoError(t, eng.Put(ctx, key, record(key, i)))
+	}
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	require.Eventually(t, func() bool {
+		st := c.Stats()
+		return st.Captured+st.Dropped == 500
+	}, 5*time.Second, 5*time.Millisecond)
+	st := c.Stats()
+	assert.Positive(t, st.Dropped)
+	assert.LessOrEqual(t, st.OutboxBytes, st.OutboxLimit)
+	assert.Positive(t, st.Sinks[0].Lag)
+	require.Eventually(t, func() bool {
+		fast.mu.Lock()
+		defer fast.mu.Unlock()
+		return fast.n == int(st.Captured)
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.FileExists(t, filepath.Join(dir, "counting.cursor"))
+}
+
+func TestCDCNeedsWAL(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	_, err = cdc.New(eng, t.TempDir(), []cdc.Sink{&countingSink{}})
+	assert.True(t, errors.Is(err, errors.ErrUnsupported))
+
+	cfg := config.MemoryConfig()
+	cfg.CDC.Webhooks = []string{"https://hooks.example.com/kvi"}
+	assert.ErrorContains(t, cfg.Validate(), "cdc.webhooks")
+}
@@ -0,0 +1,225 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// syncChanges pages through eng's changes after since, limit at a time,
+// returning them and the since to carry on from.
+func syncChanges(t *testing.T, eng types.Engine, since uint64, limit int) ([]types.Change, uint64) {
+	t.Helper()
+	var all []types.Change
+	for {
+		changes, next, err := eng.(types.ChangeScanner).ScanSince(context.Background(), since, limit)
+		require.NoError(t, err)
+		all = append(all, changes...)
+		if next == since {
+			return all, since
+		}
+		require.Greater(t, next, since)
+		since = next
+	}
+}
+
+// changeKeys lists the keys of changes, "-" before tombstones.
+func changeKeys(changes []types.Change) []string {
+	keys := []string{}
+	for _, change := range changes {
+		if change.Deleted {
+			keys = append(keys, "-"+change.Key)
+		} else {
+			keys = append(keys, change.Key)
+		}
+	}
+	return keys
+}
+
+func TestScanSince(t *testing.T) {
+	ctx := context.Background()
+	disk := config.DiskConfig()
+	disk.DataDir = t.TempDir()
+	hybrid := config.DefaultConfig()
+	hybrid.DataDir = t.TempDir()
+
+	for name, cfg := range map[string]*config.Config{"memory": config.MemoryConfig(), "columnar": config.ColumnarConfig(), "disk": disk, "hybrid": hybrid} {
+		t.Run(name, func(t *testing.T) {
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+			put := func(key string, ttl *time.Time) {
+				t.Helper()
+				require.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, Data: map[string]interface{}{"k": key}, TTL: ttl}))
+			}
+
+			// Keys come back in the order they were written, not by key
+			for _, key := range []string{"c", "a", "d", "b"} {
+				put(key, nil)
+			}
+			changes, since := syncChanges(t, eng, 0, 1)
+			assert.Equal(t, []string{"c", "a", "d", "b"}, changeKeys(changes))
+			for _, change := range changes {
+				assert.Equal(t, change.Key, change.Record.ID)
+				assert.EqualValues(t, change.Record.UpdatedAt.UnixNano(), change.At)
+			}
+			assert.Equal(t, changes[3].At, since)
+
+			// A rewrite moves a key to the end; a delete or expiry leaves a
+			// tombstone in its place
+			put("c", nil)
+			require.NoError(t, eng.Delete(ctx, "a"))
+			expired := time.Now().Add(50 * time.Millisecond)
+			put("d", &expired)
+			time.Sleep(100 * time.Millisecond)
+			changes, _ = syncChanges(t, eng, since, 2)
+			assert.Equal(t, []string{"c", "-a"}, changeKeys(changes)) // d is expired but not yet collected
+			assert.Nil(t, changes[1].Record)
+			require.NoError(t, eng.(types.Maintainer).Maintenance()[types.MaintenanceGC](ctx, func(int, int) {}))
+			changes, next := syncChanges(t, eng, since, 2)
+			assert.Equal(t, []string{"c", "-a", "-d"}, changeKeys(changes))
+			assert.Greater(t, changes[2].At, changes[1].At)
+
+			// A key written again loses its tombstone
+			put("a", nil)
+			changes, _ = syncChanges(t, eng, next, 0)
+			assert.Equal(t, []string{"a"}, changeKeys(changes))
+			changes, _ = syncChanges(t, eng, 0, 0)
+			assert.Equal(t, []string{"b", "c", "-d", "a"}, changeKeys(changes))
+		})
+	}
+}
+
+func TestScanSinceRecovered(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.DiskConfig()
+	cfg.DataDir = t.TempDir()
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	for _, key := range []string{"b", "a", "c"} {
+		require.NoError(t, eng.Put(ctx, key, &types.Record{ID: key}))
+	}
+	require.NoError(t, eng.Delete(ctx, "c"))
+	require.NoError(t, eng.Close())
+
+	// Records replayed from the WAL keep their order; the tombstones are
+	// not kept
+	eng, err = kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	changes, _ := syncChanges(t, eng, 0, 0)
+	assert.Equal(t, []string{"b", "a"}, changeKeys(changes))
+}
+
+func TestChangesTombstoneCap(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.MemoryConfig()
+	cfg.ChangesMaxTombstones = 2
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	for i := range 4 {
+		key := fmt.Sprintf("k%d", i)
+		require.NoError(t, eng.Put(ctx, key, &types.Record{ID: key}))
+	}
+	_, since := syncChanges(t, eng, 0, 0)
+	for i := range 3 {
+		require.NoError(t, eng.Delete(ctx, fmt.Sprintf("k%d", i)))
+	}
+
+	// The oldest tombstone is gone, so a client that has not seen it must
+	// start over
+	_, _, err = eng.(types.ChangeScanner).ScanSince(ctx, since, 0)
+	assert.ErrorIs(t, err, types.ErrHistoryUnavailable)
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+	resp, err := http.Get(fmt.Sprintf("%s/api/v1/changes?since=%d", ts.URL, since))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusGone, resp.StatusCode)
+	_, err = startGrpc(t, eng, nil).Changes(ctx, &kvi_grpc.ChangesRequest{Since: since})
+	assert.Equal(t, codes.OutOfRange, status.Code(err))
+
+	changes, since := syncChanges(t, eng, 0, 0)
+	assert.Equal(t, []string{"k3", "-k1", "-k2"}, changeKeys(changes))
+	require.NoError(t, eng.Delete(ctx, "k3"))
+	changes, _ = syncChanges(t, eng, since, 0)
+	assert.Equal(t, []string{"-k3"}, changeKeys(changes))
+}
+
+func TestChangesAPI(t *testing.T) {
+	ctx := context.Background()
+	eng, ts := memoryServer(t)
+	for _, key := range []string{"y", "x", "z"} {
+		require.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, Data: map[string]interface{}{"v": 1.0}}))
+	}
+	require.NoError(t, eng.Delete(ctx, "y"))
+
+	type page struct {
+		Items []struct {
+			Key     string `json:"key"`
+			At      uint64 `json:"at"`
+			Deleted bool   `json:"deleted"`
+			Record  *struct {
+				ID   string                 `json:"id"`
+				Data map[string]interface{} `json:"data"`
+			} `json:"record"`
+		} `json:"items"`
+		Count int    `json:"count"`
+		Next  uint64 `json:"next"`
+	}
+	var first, rest page
+	getJSON(t, ts.URL+"/api/v1/changes?limit=1", &first)
+	require.Equal(t, 1, first.Count)
+	assert.Equal(t, "x", first.Items[0].Key)
+	assert.Equal(t, 1.0, first.Items[0].Record.Data["v"])
+	assert.Equal(t, first.Items[0].At, first.Next)
+	getJSON(t, fmt.Sprintf("%s/api/v1/changes?since=%d", ts.URL, first.Next), &rest)
+	require.Equal(t, 2, rest.Count)
+	assert.Equal(t, "z", rest.Items[0].Key)
+	assert.Equal(t, "y", rest.Items[1].Key)
+	assert.True(t, rest.Items[1].Deleted)
+	assert.Nil(t, rest.Items[1].Record)
+
+	var none page
+	getJSON(t, fmt.Sprintf("%s/api/v1/changes?since=%d", ts.URL, rest.Next), &none)
+	assert.Equal(t, 0, none.Count)
+	assert.NotNil(t, none.Items)
+	assert.Equal(t, rest.Next, none.Next)
+
+	for _, query := range []string{"since=-1", "since=x", "limit=-1"} {
+		resp, err := http.Get(ts.URL + "/api/v1/changes?" + query)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+
+	// gRPC pages the same way
+	stub := startGrpc(t, eng, nil)
+	resp, err := stub.Changes(ctx, &kvi_grpc.ChangesRequest{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, resp.Changes, 2)
+	assert.Equal(t, "x", resp.Changes[0].Key)
+	assert.Equal(t, `{"v":1}`, resp.Changes[0].Record.DataJson)
+	assert.Equal(t, "z", resp.Changes[1].Key)
+	resp, err = stub.Changes(ctx, &kvi_grpc.ChangesRequest{Since: resp.Next})
+	require.NoError(t, err)
+	require.Len(t, resp.Changes, 1)
+	assert.Equal(t, "y", resp.Changes[0].Key)
+	assert.True(t, resp.Changes[0].Deleted)
+	assert.Nil(t, resp.Changes[0].Record)
+	assert.Equal(t, rest.Next, resp.Next)
+}
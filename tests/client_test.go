@@ -0,0 +1,362 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/auth"
+	"github.com/thirawat27/kvi/pkg/client"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// clientServer serves eng over REST and gRPC on loopback, returning the
+// REST URL and the gRPC address.
+func clientServer(t *testing.T, eng types.Engine, hub *pubsub.Hub, a *auth.Authenticator) (string, string) {
+	t.Helper()
+	opts := []func(*api.Server){api.WithHub(hub)}
+	if a != nil {
+		opts = append(opts, api.WithAuth(a))
+	}
+	ts := httptest.NewServer(api.NewServer(eng, opts...).Handler())
+	t.Cleanup(ts.Close)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	ctx, stop := context.WithCancel(context.Background())
+	go kvi_grpc.StartGRPCServer(ctx, lis, kvi_grpc.NewGrpcServer(eng, hub),
+		kvi_grpc.Interceptors(kvi_grpc.Middleware{Auth: a})...)
+	t.Cleanup(stop)
+	return ts.URL, lis.Addr().String()
+}
+
+func newClient(t *testing.T, opts ...func(*client.Client)) *client.Client {
+	t.Helper()
+	c, err := client.New(opts...)
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// Every method behaves the same with gRPC preferred and over REST alone.
+func TestClient(t *testing.T) {
+	for _, transport := range []string{"grpc", "http"} {
+		t.Run(transport, func(t *testing.T) {
+			eng, err := kvi.Open(config.MemoryConfig())
+			assert.NoError(t, err)
+			defer eng.Close()
+			hub := pubsub.NewHub()
+			httpURL, grpcAddr := clientServer(t, eng, hub, nil)
+
+			opts := []func(*client.Client){client.WithHTTP(httpURL), client.WithScanPageSize(2)}
+			if transport == "grpc" {
+				opts = append(opts, client.WithGRPC(grpcAddr), client.WithGRPCConns(2))
+			}
+			c := newClient(t, opts...)
+			ctx := context.Background()
+
+			// Put and Get
+			rec := &types.Record{Data: map[string]interface{}{"name": "Ann"}}
+			assert.NoError(t, c.Put(ctx, "user:1", rec))
+			assert.Equal(t, uint64(1), rec.Version)
+			expires := time.Now().Add(time.Hour)
+			rec = &types.Record{Data: map[string]interface{}{"name": "Ann", "age": float64(30)}, TTL: &expires}
+			assert.NoError(t, c.Put(ctx, "user:1", rec))
+			assert.Equal(t, uint64(2), rec.Version)
+
+			got, err := c.Get(ctx, "user:1")
+			assert.NoError(t, err)
+			stored, _ := eng.Get(ctx, "user:1")
+			assert.Equal(t, "user:1", got.ID)
+			assert.Equal(t, stored.Data, got.Data)
+			assert.Equal(t, stored.Version, got.Version)
+			assert.WithinDuration(t, *stored.TTL, *got.TTL, time.Millisecond)
+			assert.True(t, stored.CreatedAt.Equal(got.CreatedAt))
+			assert.True(t, stored.UpdatedAt.Equal(got.UpdatedAt))
+
+			_, err = c.Get(ctx, "missing")
+			assert.ErrorIs(t, err, types.ErrKeyNotFound)
+
+			// BatchPut, Scan across pages, Delete
+			var batch []*types.Record
+			for _, id := range []string{"item:1", "item:2", "item:3", "item:4", "item:5"} {
+				batch = append(batch, &types.Record{ID: id, Data: map[string]interface{}{"id": id}})
+			}
+			assert.NoError(t, c.BatchPut(ctx, batch))
+			assert.Equal(t, uint64(1), batch[4].Version)
+			assert.NoError(t, c.Delete(ctx, "item:3"))
+
+			var keys []string
+			assert.NoError(t, c.Scan(ctx, "item:", func(rec *types.Record) bool {
+				keys = append(keys, rec.ID)
+				return true
+			}))
+			assert.Equal(t, []string{"item:1", "item:2", "item:4", "item:5"}, keys)
+			keys = nil
+			assert.NoError(t, c.Scan(ctx, "item:", func(rec *types.Record) bool {
+				keys = append(keys, rec.ID)
+				return len(keys) < 3
+			}))
+			assert.Equal(t, []string{"item:1", "item:2", "item:4"}, keys)
+
+			// BatchGet and BatchDelete, streamed past 500 keys over gRPC
+			var many []*types.Record
+			var manyKeys []string
+			for i := range 600 {
+				key := fmt.Sprintf("many:%03d", i)
+				many = append(many, &types.Record{ID: key, Data: map[string]interface{}{"i": float64(i)}})
+				manyKeys = append(manyKeys, key)
+			}
+			assert.NoError(t, c.BatchPut(ctx, many))
+			found, err := c.BatchGet(ctx, slices.Concat(manyKeys, []string{"many:missing"}))
+			assert.NoError(t, err)
+			assert.Len(t, found, 600)
+			assert.Equal(t, float64(42), found["many:042"].Data["i"])
+			removed, err := c.BatchDelete(ctx, slices.Concat(manyKeys[:550], []string{"many:missing"}))
+			assert.NoError(t, err)
+			assert.Len(t, removed, 550)
+			assert.False(t, removed["many:missing"])
+			found, err = c.BatchGet(ctx, manyKeys)
+			assert.NoError(t, err)
+			assert.Len(t, found, 50)
+
+			// Query
+			result, err := c.Query(ctx, "SELECT * FROM users WHERE id = 'item:2'")
+			assert.NoError(t, err)
+			recs, err := result.Records()
+			assert.NoError(t, err)
+			if assert.Len(t, recs, 1) {
+				assert.Equal(t, "item:2", recs[0].ID)
+			}
+
+			// Subscribe and Ack
+			hub.ConfigureChannel("jobs", pubsub.ChannelOptions{Mode: pubsub.ModeAck, VisibilityTimeout: time.Minute})
+			sub, err := c.Subscribe(ctx, "jobs")
+			assert.NoError(t, err)
+			assert.Eventually(t, func() bool { return hub.Stats().Subscribers == 1 }, 2*time.Second, 5*time.Millisecond)
+			hub.Publish("jobs", "build #1")
+			select {
+			case msg := <-sub.C:
+				assert.Equal(t, "build #1", msg.Payload)
+				assert.Equal(t, "jobs", msg.Channel)
+				assert.NotEmpty(t, msg.AckToken)
+				assert.NoError(t, sub.Ack(ctx, msg))
+			case <-time.After(2 * time.Second):
+				t.Fatal("no message delivered")
+			}
+			assert.NoError(t, sub.Close())
+			_, open := <-sub.C
+			assert.False(t, open)
+			assert.NoError(t, sub.Err())
+		})
+	}
+}
+
+func TestClientVectorSearch(t *testing.T) {
+	eng, err := kvi.OpenVector(2)
+	assert.NoError(t, err)
+	defer eng.Close()
+	ctx := context.Background()
+	for id, vec := range map[string][]float32{"a": {1, 0}, "b": {0, 1}, "c": {0.9, 0.1}} {
+		assert.NoError(t, eng.Put(ctx, id, &types.Record{ID: id, Vector: vec}))
+	}
+	httpURL, grpcAddr := clientServer(t, eng, pubsub.NewHub(), nil)
+
+	c := newClient(t, client.WithGRPC(grpcAddr))
+	recs, err := c.VectorSearch(ctx, []float32{1, 0}, 2)
+	assert.NoError(t, err)
+	var ids []string
+	for _, rec := range recs {
+		ids = append(ids, rec.ID)
+	}
+	assert.ElementsMatch(t, []string{"a", "c"}, ids)
+
+	// REST has no vector search; a gRPC-only client has no Delete
+	_, err = newClient(t, client.WithHTTP(httpURL)).VectorSearch(ctx, []float32{1, 0}, 2)
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+	assert.ErrorIs(t, c.Delete(ctx, "a"), errors.ErrUnsupported)
+
+	mem, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer mem.Close()
+	_, grpcAddr = clientServer(t, mem, pubsub.NewHub(), nil)
+	_, err = newClient(t, client.WithGRPC(grpcAddr)).VectorSearch(ctx, []float32{1, 0}, 2)
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+// The client logs in with its API key and renews the token as it expires,
+// over both transports.
+func TestClientAuth(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+	a, err := auth.New(testSecret, 2*time.Second, auth.StaticKeys(map[string]auth.Role{
+		"reader-key": auth.RoleRead,
+		"writer-key": auth.RoleWrite,
+	}))
+	assert.NoError(t, err)
+	httpURL, grpcAddr := clientServer(t, eng, pubsub.NewHub(), a)
+	ctx := context.Background()
+
+	c := newClient(t, client.WithHTTP(httpURL), client.WithGRPC(grpcAddr), client.WithAPIKey("writer-key"))
+	assert.NoError(t, c.Put(ctx, "k", &types.Record{Data: map[string]interface{}{"n": float64(1)}}))
+	deadline := time.Now().Add(3 * time.Second) // outlives the first token
+	for time.Now().Before(deadline) {
+		_, err := c.Get(ctx, "k")
+		assert.NoError(t, err)
+		assert.NoError(t, c.Delete(ctx, "gone"))
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	reader := newClient(t, client.WithHTTP(httpURL), client.WithGRPC(grpcAddr), client.WithAPIKey("reader-key"))
+	_, err = reader.Get(ctx, "k")
+	assert.NoError(t, err)
+	assert.ErrorIs(t, reader.Put(ctx, "k", &types.Record{}), auth.ErrForbidden)
+	assert.ErrorIs(t, reader.Delete(ctx, "k"), auth.ErrForbidden)
+
+	_, err = newClient(t, client.WithHTTP(httpURL), client.WithAPIKey("wrong")).Get(ctx, "k")
+	assert.ErrorIs(t, err, client.ErrUnauthenticated)
+	_, err = newClient(t, client.WithGRPC(grpcAddr)).Get(ctx, "k")
+	assert.ErrorIs(t, err, client.ErrUnauthenticated)
+
+	_, err = client.New(client.WithGRPC(grpcAddr), client.WithAPIKey("writer-key"))
+	assert.Error(t, err)
+}
+
+// Reads are retried while the server is unavailable; writes are not.
+func TestClientRetry(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+	handler := api.NewServer(eng).Handler()
+	var calls, failures atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if failures.Add(-1) >= 0 {
+			http.Error(w, `{"error":"try again"}`, http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	ctx := context.Background()
+	c := newClient(t, client.WithHTTP(ts.URL), client.WithRetry(client.Retry{Attempts: 3, Backoff: time.Millisecond}))
+
+	failures.Store(1)
+	err = c.Put(ctx, "k", &types.Record{Data: map[string]interface{}{}})
+	assert.ErrorIs(t, err, client.ErrUnavailable)
+	assert.Equal(t, "try again", err.Error())
+	assert.Equal(t, int32(1), calls.Load())
+
+	assert.NoError(t, c.Put(ctx, "k", &types.Record{Data: map[string]interface{}{}}))
+	calls.Store(0)
+	failures.Store(2)
+	_, err = c.Get(ctx, "k")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), calls.Load())
+
+	calls.Store(0)
+	failures.Store(5)
+	_, err = c.Get(ctx, "k")
+	assert.ErrorIs(t, err, client.ErrUnavailable)
+	assert.Equal(t, int32(3), calls.Load())
+}
+
+// Backups taken through the client and locally restore interchangeably.
+func TestClientBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	src, srcTS := memoryServer(t)
+	keys := fillEngine(t, src, "item:", 50)
+	c := newClient(t, client.WithHTTP(srcTS.URL))
+
+	var remote bytes.Buffer
+	sum, err := c.Backup(ctx, &remote)
+	assert.NoError(t, err)
+	assert.Equal(t, 50, sum.Records)
+	assert.Equal(t, int64(remote.Len()), sum.Bytes)
+
+	// The remote backup restores locally...
+	local, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer local.Close()
+	restored, err := backup.Restore(ctx, local, bytes.NewReader(remote.Bytes()), backup.Replace)
+	assert.NoError(t, err)
+	assert.Equal(t, 50, restored.Restored)
+
+	// ...and a local one through the client
+	var dump bytes.Buffer
+	_, err = backup.Dump(ctx, local, &dump)
+	assert.NoError(t, err)
+	dst, dstTS := memoryServer(t)
+	fillEngine(t, dst, "stale:", 3)
+	res, err := newClient(t, client.WithHTTP(dstTS.URL)).Restore(ctx, bytes.NewReader(dump.Bytes()), client.RestoreOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 50, res.Restored)
+	assert.Equal(t, 3, res.Removed)
+	var got []string
+	dst.Scan(ctx, "", func(rec *types.Record) bool {
+		got = append(got, rec.ID)
+		return true
+	})
+	assert.Equal(t, keys, got)
+
+	_, err = c.Restore(ctx, bytes.NewReader([]byte("junk")), client.RestoreOptions{Merge: true})
+	assert.Error(t, err)
+
+	_, err = newClient(t, client.WithGRPC("localhost:1")).Backup(ctx, io.Discard)
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+}
+
+func TestClientAdmin(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	eng, err := kvi.Open(cfg)
+	assert.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+	c := newClient(t, client.WithHTTP(ts.URL))
+	fillEngine(t, eng, "k:", 10)
+
+	report, err := c.Stats(ctx)
+	assert.NoError(t, err)
+	var stats struct {
+		Engine struct {
+			Records int `json:"records"`
+		} `json:"engine"`
+	}
+	assert.NoError(t, json.Unmarshal(report, &stats))
+	assert.Equal(t, 10, stats.Engine.Records)
+
+	job, err := c.StartMaintenance(ctx, types.MaintenanceCompact)
+	assert.NoError(t, err)
+	assert.Equal(t, types.MaintenanceCompact, job.Op)
+	var seen int
+	job, err = c.WaitJob(ctx, job.ID, 10*time.Millisecond, func(*client.Job) { seen++ })
+	assert.NoError(t, err)
+	assert.Equal(t, "succeeded", job.Status)
+	assert.Positive(t, seen)
+
+	_, err = c.StartMaintenance(ctx, "snapshot") // no snapshot directory
+	assert.ErrorIs(t, err, errors.ErrUnsupported)
+	_, err = c.Job(ctx, "nope")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+}
@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/codec"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// exactData holds values JSON cannot carry exactly through a float64.
+func exactData() map[string]interface{} {
+	return map[string]interface{}{
+		"min":     int64(math.MinInt64),
+		"max":     int64(math.MaxInt64),
+		"past53":  int64(1<<53 + 1),
+		"umax":    uint64(math.MaxUint64),
+		"neg":     int64(-1),
+		"zero":    int64(0),
+		"half":    0.5,
+		"blob":    []byte{0, 1, 0xff},
+		"text":    "héllo",
+		"nothing": nil,
+		"nested": map[string]interface{}{
+			"list": []interface{}{int64(1), "two", []interface{}{true, false}},
+			"deep": map[string]interface{}{"id": int64(1<<62 + 7)},
+		},
+	}
+}
+
+func TestMsgPackRoundTrip(t *testing.T) {
+	ttl := time.Date(2030, 1, 2, 3, 4, 5, 6, time.UTC)
+	rec := &types.Record{
+		ID:        "k",
+		Data:      exactData(),
+		Vector:    []float32{0.25, -1},
+		Version:   math.MaxUint64,
+		TTL:       &ttl,
+		CreatedAt: ttl.Add(-time.Hour),
+	}
+	data, err := codec.MsgPack.Marshal(rec)
+	require.NoError(t, err)
+	c, ok := codec.Detect(data)
+	require.True(t, ok)
+	assert.Equal(t, codec.MsgPack, c)
+
+	var got types.Record
+	require.NoError(t, codec.MsgPack.Unmarshal(data, &got))
+	assert.Equal(t, rec.Data, got.Data)
+	assert.Equal(t, rec.Vector, got.Vector)
+	assert.Equal(t, rec.Version, got.Version)
+	assert.True(t, got.TTL.Equal(ttl))
+	assert.True(t, got.CreatedAt.Equal(rec.CreatedAt))
+
+	// The encoding is deterministic, which the WAL checksum relies on
+	again, err := codec.MsgPack.Marshal(&got)
+	require.NoError(t, err)
+	assert.Equal(t, data, again)
+
+	// JSON rounds the same integers
+	data, err = codec.JSON.Marshal(rec)
+	require.NoError(t, err)
+	var viaJSON types.Record
+	require.NoError(t, codec.JSON.Unmarshal(data, &viaJSON))
+	assert.Equal(t, float64(1<<53), viaJSON.Data["past53"])
+}
+
+func TestDiskCodec(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.DiskConfig()
+	cfg.DataDir, cfg.Codec, cfg.RecordCompressMinBytes = t.TempDir(), codec.NameMsgPack, 1024
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	require.NoError(t, eng.Put(ctx, "exact", &types.Record{ID: "exact", Data: exactData()}))
+
+	require.NoError(t, eng.Put(ctx, "big", &types.Record{ID: "big", Data: bigData(1)}))
+	require.NoError(t, eng.Delete(ctx, "gone"))
+	assert.Equal(t, codec.NameMsgPack, eng.(types.RecordCoder).RecordCodec())
+	require.NoError(t, eng.Close())
+
+	path := filepath.Join(cfg.DataDir, wal.FileName)
+	for _, frame := range scanWAL(t, path) {
+		assert.True(t, frame.Valid(), "%v", frame.Err)
+		assert.Equal(t, codec.NameMsgPack, frame.Codec)
+	}
+
+	// Recovery reads integers back exactly, and packed records too
+	eng, err = kvi.Open(cfg)
+	require.NoError(t, err)
+	rec, err := eng.Get(ctx, "exact")
+	require.NoError(t, err)
+	assert.Equal(t, exactData(), rec.Data)
+	rec, err = eng.Get(ctx, "big")
+	require.NoError(t, err)
+	assert.Equal(t, "the quick", rec.Data["body"].(string)[:9])
+	assert.Equal(t, 1.0, rec.Data["i"])
+
+	// A backup is written with the engine's codec and restores anywhere
+	snapshot, sum := dumpEngine(t, eng)
+	assert.EqualValues(t, 2, sum.Records)
+	require.NoError(t, eng.Close())
+	var keys []string
+	hdr, err := backup.Read(bytes.NewReader(snapshot), func(rec *types.Record) error {
+		keys = append(keys, rec.ID)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, codec.NameMsgPack, hdr.Codec)
+	assert.Equal(t, backup.Version, hdr.Version)
+	assert.Equal(t, []string{"big", "exact"}, keys)
+	report, err := backup.Verify(bytes.NewReader(snapshot), backup.Expect{})
+	require.NoError(t, err)
+	assert.Empty(t, report.Problems)
+
+	mem, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer mem.Close()
+	n, err := backup.Load(ctx, mem, bytes.NewReader(snapshot))
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	rec, err = mem.Get(ctx, "exact")
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MinInt64), rec.Data["min"])
+	assert.Equal(t, []byte{0, 1, 0xff}, rec.Data["blob"])
+
+	// ...and a JSON engine still writes version 1 backups
+	jsonSnapshot, _ := dumpEngine(t, mem)
+	hdr, err = backup.Read(bytes.NewReader(jsonSnapshot), func(*types.Record) error { return nil })
+	require.NoError(t, err)
+	assert.Equal(t, 1, hdr.Version)
+	assert.Empty(t, hdr.Codec)
+}
+
+func TestCodecMismatch(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.DiskConfig()
+	cfg.DataDir = t.TempDir()
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	require.NoError(t, eng.Put(ctx, "k", &types.Record{Data: map[string]interface{}{"n": 1.0}}))
+	require.NoError(t, eng.Close())
+
+	// A data directory written as JSON is not read as MessagePack
+	cfg.Codec = codec.NameMsgPack
+	_, err = kvi.Open(cfg)
+	assert.ErrorIs(t, err, wal.ErrCodecMismatch)
+	assert.ErrorContains(t, err, "entry at offset 32 is json, and the codec is msgpack")
+
+	cfg.Codec = codec.NameJSON
+	eng, err = kvi.Open(cfg)
+	require.NoError(t, err)
+	require.NoError(t, eng.Close())
+}
+
+func TestMsgPackWALChecksum(t *testing.T) {
+	dir := t.TempDir()
+	w, err := wal.NewWAL(dir)
+	require.NoError(t, err)
+	w.SetCodec(codec.MsgPack)
+	require.NoError(t, w.WriteEntry(types.OpPut, "k", &types.Record{Data: exactData()}))
+	require.NoError(t, w.Close())
+	path := filepath.Join(dir, wal.FileName)
+	require.True(t, scanWAL(t, path)[0].Valid())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	i := bytes.Index(data, []byte("héllo"))
+	require.Positive(t, i)
+	data[i] ^= 1
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	assert.ErrorIs(t, scanWAL(t, path)[0].Err, wal.ErrChecksum)
+}
+
+func BenchmarkCodec(b *testing.B) {
+	rec := &types.Record{
+		ID:        "user:1234",
+		Data:      map[string]interface{}{"id": int64(1234), "name": "Ada", "balance": 1234.5, "tags": []interface{}{"a", "b", "c"}, "active": true, "address": map[string]interface{}{"city": "Bangkok", "zip": "10110"}},
+		Version:   7,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	for _, c := range []codec.Codec{codec.JSON, codec.MsgPack} {
+		data, err := c.Marshal(rec)
+		require.NoError(b, err)
+		b.Run(c.Name()+"/encode", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if _, err := c.Marshal(rec); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(len(data)), "encoded-bytes")
+		})
+		b.Run(c.Name()+"/decode", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				var got types.Record
+				if err := c.Unmarshal(data, &got); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
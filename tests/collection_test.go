@@ -0,0 +1,198 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/collection"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func TestCollectionList(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	eng := openDisk(t, dir)
+
+	n, err := collection.RPush(ctx, eng, "queue", "b", "c")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = collection.LPush(ctx, eng, "queue", "a", "z")
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+
+	all, err := collection.LRange(ctx, eng, "queue", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"z", "a", "b", "c"}, all)
+	some, err := collection.LRange(ctx, eng, "queue", -3, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, some)
+	none, err := collection.LRange(ctx, eng, "missing", 0, -1)
+	require.NoError(t, err)
+	assert.Empty(t, none)
+
+	v, err := collection.LPop(ctx, eng, "queue")
+	require.NoError(t, err)
+	assert.Equal(t, "z", v)
+	v, err = collection.RPop(ctx, eng, "queue")
+	require.NoError(t, err)
+	assert.Equal(t, "c", v)
+
+	// The WAL logs each resulting record, so the list survives a restart
+	require.NoError(t, eng.Close())
+	eng = openDisk(t, dir)
+	defer eng.Close()
+	all, err = collection.LRange(ctx, eng, "queue", 0, -1)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, all)
+
+	for range 2 {
+		_, err = collection.LPop(ctx, eng, "queue")
+		require.NoError(t, err)
+	}
+	_, err = collection.LPop(ctx, eng, "queue")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+	_, err = eng.Get(ctx, "queue")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound, "popping the last item deletes the list")
+}
+
+func TestCollectionSet(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+
+	added, err := collection.SAdd(ctx, eng, "tags", "go", "db", "go")
+	require.NoError(t, err)
+	assert.Equal(t, 2, added)
+	added, err = collection.SAdd(ctx, eng, "tags", "kv", "db")
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+
+	members, err := collection.SMembers(ctx, eng, "tags")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"db", "go", "kv"}, members)
+	in, err := collection.SIsMember(ctx, eng, "tags", "kv")
+	require.NoError(t, err)
+	assert.True(t, in)
+	in, err = collection.SIsMember(ctx, eng, "nothing", "kv")
+	require.NoError(t, err)
+	assert.False(t, in)
+
+	removed, err := collection.SRem(ctx, eng, "tags", "kv", "rust")
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	removed, err = collection.SRem(ctx, eng, "tags", "db", "go")
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	_, err = eng.Get(ctx, "tags")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound, "removing the last member deletes the set")
+	removed, err = collection.SRem(ctx, eng, "tags", "db")
+	require.NoError(t, err)
+	assert.Zero(t, removed)
+}
+
+func TestCollectionWrongType(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+
+	_, err = collection.SAdd(ctx, eng, "tags", "a")
+	require.NoError(t, err)
+	require.NoError(t, eng.Put(ctx, "plain", &types.Record{ID: "plain", Data: map[string]interface{}{"name": "kvi"}}))
+
+	_, err = collection.LPush(ctx, eng, "tags", "x")
+	assert.ErrorIs(t, err, types.ErrWrongType)
+	_, err = collection.LRange(ctx, eng, "tags", 0, -1)
+	assert.ErrorIs(t, err, types.ErrWrongType)
+	_, err = collection.SAdd(ctx, eng, "plain", "x")
+	assert.ErrorIs(t, err, types.ErrWrongType)
+	_, err = collection.RPop(ctx, eng, "plain")
+	assert.ErrorIs(t, err, types.ErrWrongType)
+
+	members, err := collection.SMembers(ctx, eng, "tags")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, members, "a failed operation leaves the value alone")
+	rec, err := eng.Get(ctx, "plain")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "kvi"}, rec.Data)
+}
+
+func TestCollectionConcurrentPush(t *testing.T) {
+	ctx := context.Background()
+	for _, cfg := range []*config.Config{config.MemoryConfig(), config.ColumnarConfig()} {
+		t.Run(string(cfg.Mode), func(t *testing.T) {
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+
+			var wg sync.WaitGroup
+			for i := range 50 {
+				wg.Go(func() {
+					_, err := collection.RPush(ctx, eng, "jobs", fmt.Sprint(i))
+					assert.NoError(t, err)
+				})
+			}
+			wg.Wait()
+			all, err := collection.LRange(ctx, eng, "jobs", 0, -1)
+			require.NoError(t, err)
+			assert.Len(t, all, 50, "no push is lost, the first ones included")
+		})
+	}
+}
+
+func TestCollectionAPI(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	call := func(method, path string, body interface{}) (int, map[string]interface{}) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, jsonBody(body))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var out map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	status, out := call(http.MethodPost, "/api/v1/list/rpush", map[string]interface{}{"key": "q", "values": []string{"a", "b"}})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 2.0, out["length"])
+	_, out = call(http.MethodPost, "/api/v1/list/lpush", map[string]interface{}{"key": "q", "values": []string{"z"}})
+	assert.Equal(t, 3.0, out["length"])
+	_, out = call(http.MethodGet, "/api/v1/list/lrange?key=q&start=1", nil)
+	assert.Equal(t, []interface{}{"a", "b"}, out["values"])
+	_, out = call(http.MethodPost, "/api/v1/list/rpop", map[string]string{"key": "q"})
+	assert.Equal(t, "b", out["value"])
+	status, _ = call(http.MethodPost, "/api/v1/list/lpop", map[string]string{"key": "empty"})
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, out = call(http.MethodPost, "/api/v1/set/sadd", map[string]interface{}{"key": "s", "members": []string{"x", "y"}})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 2.0, out["added"])
+	_, out = call(http.MethodPost, "/api/v1/set/srem", map[string]interface{}{"key": "s", "members": []string{"y"}})
+	assert.Equal(t, 1.0, out["removed"])
+	_, out = call(http.MethodGet, "/api/v1/set/smembers?key=s", nil)
+	assert.Equal(t, []interface{}{"x"}, out["members"])
+	_, out = call(http.MethodGet, "/api/v1/set/sismember?key=s&member=x", nil)
+	assert.Equal(t, true, out["member"])
+
+	status, _ = call(http.MethodPost, "/api/v1/list/rpush", map[string]interface{}{"key": "s", "values": []string{"a"}})
+	assert.Equal(t, http.StatusConflict, status)
+	status, _ = call(http.MethodPost, "/api/v1/set/sadd", map[string]interface{}{"key": "s"})
+	assert.Equal(t, http.StatusBadRequest, status)
+}
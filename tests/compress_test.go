@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+// fetchEncoded sends a request with an explicit Accept-Encoding, which also
+// stops the client from decompressing transparently.
+func fetchEncoded(t *testing.T, url, encoding string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Accept-Encoding", encoding)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	return resp
+}
+
+func decodeBody(t *testing.T, resp *http.Response) io.Reader {
+	t.Helper()
+	switch resp.Header.Get("Content-Encoding") {
+	case "gzip":
+		zr, err := gzip.NewReader(resp.Body)
+		assert.NoError(t, err)
+		return zr
+	case "zstd":
+		zr, err := zstd.NewReader(resp.Body)
+		assert.NoError(t, err)
+		return zr
+	}
+	return resp.Body
+}
+
+func TestResponseCompression(t *testing.T) {
+	eng, ts := memoryServer(t)
+	fillEngine(t, eng, "user:", 200)
+
+	resp := fetchEncoded(t, ts.URL+"/api/v1/scan?prefix=user:", "identity")
+	plain, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+
+	for accept, want := range map[string]string{"gzip": "gzip", "gzip, zstd": "zstd", "zstd;q=0.5, gzip": "gzip", "br": ""} {
+		resp := fetchEncoded(t, ts.URL+"/api/v1/scan?prefix=user:", accept)
+		assert.Equal(t, want, resp.Header.Get("Content-Encoding"), accept)
+		if want != "" { // any length describes the encoded bytes
+			assert.Less(t, resp.ContentLength, int64(len(plain)), accept)
+		}
+		body, err := io.ReadAll(decodeBody(t, resp))
+		resp.Body.Close()
+		assert.NoError(t, err, accept)
+		assert.Equal(t, plain, body, accept)
+	}
+
+	// Small responses keep their Content-Length and are not encoded
+	resp = fetchEncoded(t, ts.URL+"/api/v1/get?key=user:0001", "gzip")
+	resp.Body.Close()
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	assert.NotEmpty(t, resp.Header.Get("Content-Length"))
+	assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
+
+	// Backups are already gzip and go out untouched
+	resp = fetchEncoded(t, ts.URL+"/api/v1/backup", "gzip, zstd")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+}
+
+func TestCompressedStreamsFlush(t *testing.T) {
+	eng, ts := memoryServer(t)
+	fillEngine(t, eng, "user:", 600)
+
+	// NDJSON scans stay streamable: each flush reaches the client decodable
+	resp := fetchEncoded(t, ts.URL+"/api/v1/scan?prefix=user:&stream=true", "gzip")
+	assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+	lines := 0
+	sc := bufio.NewScanner(decodeBody(t, resp))
+	for sc.Scan() {
+		lines++
+	}
+	resp.Body.Close()
+	assert.Equal(t, 600, lines)
+
+	// SSE is never compressed, so events arrive line by line
+	resp = fetchEncoded(t, ts.URL+"/api/v1/sub?channel=c&id=s1", "gzip")
+	defer resp.Body.Close()
+	assert.Empty(t, resp.Header.Get("Content-Encoding"))
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "retry:"))
+}
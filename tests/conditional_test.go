@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func TestEngineCompareAndSwap(t *testing.T) {
+	disk := config.DiskConfig()
+	disk.DataDir = t.TempDir()
+	hybrid := config.DefaultConfig()
+	hybrid.DataDir = t.TempDir()
+
+	for name, cfg := range map[string]*config.Config{"memory": config.MemoryConfig(), "disk": disk, "hybrid": hybrid} {
+		t.Run(name, func(t *testing.T) {
+			eng, err := kvi.Open(cfg)
+			assert.NoError(t, err)
+			defer eng.Close()
+			ctx := context.Background()
+
+			rec := &types.Record{ID: "k", Data: map[string]interface{}{"n": 1}}
+			assert.NoError(t, eng.CompareAndSwap(ctx, "k", 0, rec))
+			assert.Equal(t, uint64(1), rec.Version)
+
+			err = eng.CompareAndSwap(ctx, "k", 0, &types.Record{ID: "k"})
+			var mismatch *types.VersionMismatchError
+			assert.True(t, errors.As(err, &mismatch))
+			assert.ErrorIs(t, err, types.ErrVersionMismatch)
+			assert.Equal(t, uint64(1), mismatch.Current)
+
+			assert.NoError(t, eng.Put(ctx, "k", &types.Record{ID: "k", Data: map[string]interface{}{"n": 2}}))
+			got, err := eng.Get(ctx, "k")
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(2), got.Version)
+
+			assert.ErrorIs(t, eng.CompareAndSwap(ctx, "k", 1, nil), types.ErrVersionMismatch)
+			assert.NoError(t, eng.CompareAndSwap(ctx, "k", 2, nil))
+			_, err = eng.Get(ctx, "k")
+			assert.Error(t, err)
+		})
+	}
+}
+
+func conditionalRequest(t *testing.T, method, url, header, value string, body interface{}) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, jsonBody(body))
+	if header != "" {
+		req.Header.Set(header, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestConditionalWritesOverHTTP(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	put := func(header, value string, n int) *http.Response {
+		return conditionalRequest(t, http.MethodPost, ts.URL+"/api/v1/put", header, value,
+			map[string]interface{}{"key": "doc", "data": map[string]interface{}{"n": n}})
+	}
+
+	// Create-only
+	resp := put("If-None-Match", "*", 1)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
+	assert.Equal(t, http.StatusPreconditionFailed, put("If-None-Match", "*", 1).StatusCode)
+
+	resp, err = http.Get(ts.URL + "/api/v1/get?key=doc")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	assert.Equal(t, `"1"`, etag)
+
+	// Two editors holding the same ETag: the second loses
+	assert.Equal(t, http.StatusCreated, put("If-Match", etag, 2).StatusCode)
+	resp = put("If-Match", etag, 3)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	var body struct {
+		CurrentVersion uint64 `json:"current_version"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, uint64(2), body.CurrentVersion)
+
+	assert.Equal(t, http.StatusBadRequest, put("If-Match", "nonsense", 4).StatusCode)
+
+	del := func(value string) int {
+		return conditionalRequest(t, http.MethodDelete, ts.URL+"/api/v1/delete?key=doc", "If-Match", value, nil).StatusCode
+	}
+	assert.Equal(t, http.StatusPreconditionFailed, del(`"1"`))
+	assert.Equal(t, http.StatusOK, del(`"2"`))
+	assert.Equal(t, http.StatusPreconditionFailed, del("*"))
+}
@@ -0,0 +1,221 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func TestConfigLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	yamlPath := write("kvi.yaml", "mode: disk\ndata_dir: /var/lib/kvi\nport: 9090\ncors:\n  allowed_origins: [\"https://app.example.com\"]\napi_keys:\n  k1: admin\n")
+	jsonPath := write("kvi.json", `{"mode": "disk", "data_dir": "/var/lib/kvi", "port": 9090, "cors": {"allowed_origins": ["https://app.example.com"]}, "api_keys": {"k1": "admin"}}`)
+	for _, path := range []string{yamlPath, jsonPath} {
+		cfg, err := config.Load(path)
+		assert.NoError(t, err, path)
+		assert.Equal(t, types.ModeDisk, cfg.Mode)
+		assert.Equal(t, "/var/lib/kvi", cfg.DataDir)
+		assert.Equal(t, 9090, cfg.Port)
+		assert.Equal(t, []string{"https://app.example.com"}, cfg.CORS.AllowedOrigins)
+		assert.Equal(t, map[string]string{"k1": "admin"}, cfg.APIKeys)
+		// Keys the file leaves out keep their defaults
+		assert.Equal(t, 50051, cfg.GrpcPort)
+		assert.Equal(t, config.DefaultCORS().AllowedMethods, cfg.CORS.AllowedMethods)
+	}
+
+	_, err := config.Load(write("typo.yml", "mode: disk\nprot: 9090\n"))
+	assert.ErrorContains(t, err, `unknown field "prot"`)
+	_, err = config.Load(write("bad.json", `{"port": "high"}`))
+	assert.Error(t, err)
+	_, err = config.Load(filepath.Join(dir, "missing.yaml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("KVI_MODE", "disk")
+	t.Setenv("KVI_PORT", "9091")
+	t.Setenv("KVI_ENABLE_WAL", "false")
+	t.Setenv("KVI_VECTOR_DIMENSIONS", "128")
+	t.Setenv("KVI_READ_RATE_LIMIT", "2.5")
+	t.Setenv("KVI_MAX_IMPORT_BYTES", "1048576")
+	t.Setenv("KVI_CORS_ALLOWED_ORIGINS", "https://a.example.com, https://b.example.com")
+	t.Setenv("KVI_API_KEYS", "k1=admin, k2=read")
+
+	cfg := config.DefaultConfig()
+	cfg.Port = 9090 // as if from a config file, which the environment overrides
+	assert.NoError(t, config.FromEnv(cfg))
+	assert.Equal(t, types.ModeDisk, cfg.Mode)
+	assert.Equal(t, 9091, cfg.Port)
+	assert.False(t, cfg.EnableWAL)
+	assert.Equal(t, 128, cfg.VectorDim)
+	assert.Equal(t, 2.5, cfg.ReadRateLimit)
+	assert.Equal(t, int64(1<<20), cfg.MaxImportBytes)
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, cfg.CORS.AllowedOrigins)
+	assert.Equal(t, map[string]string{"k1": "admin", "k2": "read"}, cfg.APIKeys)
+	assert.Equal(t, "./data", cfg.DataDir) // unset variables change nothing
+
+	// The canonical name wins over its alias
+	t.Setenv("KVI_VECTOR_DIM", "64")
+	assert.NoError(t, config.FromEnv(cfg))
+	assert.Equal(t, 64, cfg.VectorDim)
+
+	// Every bad value is reported, and nothing is applied
+	t.Setenv("KVI_PORT", "high")
+	t.Setenv("KVI_ENABLE_PUBSUB", "maybe")
+	t.Setenv("KVI_API_KEYS", "k1")
+	cfg = config.DefaultConfig()
+	err := config.FromEnv(cfg)
+	assert.ErrorContains(t, err, `KVI_PORT: "high" is not an integer`)
+	assert.ErrorContains(t, err, "KVI_ENABLE_PUBSUB")
+	assert.ErrorContains(t, err, "KVI_API_KEYS")
+	assert.Equal(t, config.DefaultConfig(), cfg)
+
+	assert.Contains(t, config.EnvVars(), "KVI_CORS_MAX_AGE")
+}
+
+func TestConfigDump(t *testing.T) {
+	// Without secrets, a dump loads back as the same configuration
+	cfg := config.DefaultConfig()
+	cfg.Mode = types.ModeDisk
+	cfg.CORS.AllowedOrigins = []string{"https://app.example.com"}
+	var dump strings.Builder
+	assert.NoError(t, cfg.Dump(&dump))
+	assert.Contains(t, dump.String(), "cors:\n  disabled: false")
+	path := filepath.Join(t.TempDir(), "dump.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(dump.String()), 0o600))
+	loaded, err := config.Load(path)
+	assert.NoError(t, err)
+	assert.Equal(t, cfg, loaded)
+
+	cfg.JWTSecret = "hunter2"
+	cfg.APIKeys = map[string]string{"key-one": "admin", "key-two": "read", "key-three": "read"}
+	dump.Reset()
+	assert.NoError(t, cfg.Dump(&dump))
+	assert.NotContains(t, dump.String(), "hunter2")
+	assert.NotContains(t, dump.String(), "key-one")
+	assert.Contains(t, dump.String(), "1 admin, 2 read")
+}
+
+func TestConfigValidate(t *testing.T) {
+	presets := map[types.Mode]func() *config.Config{
+		types.ModeMemory:   config.MemoryConfig,
+		types.ModeDisk:     config.DiskConfig,
+		types.ModeColumnar: config.ColumnarConfig,
+		types.ModeVector:   func() *config.Config { return config.VectorConfig(3) },
+		types.ModeHybrid:   config.DefaultConfig,
+	}
+	for mode, preset := range presets {
+		assert.NoError(t, preset().Validate(), mode)
+		assert.Empty(t, preset().Warnings(), mode)
+	}
+
+	tests := []struct {
+		name  string
+		modes []types.Mode
+		edit  func(*config.Config)
+		err   string // "" = valid
+	}{
+		{"no data dir", []types.Mode{types.ModeDisk, types.ModeHybrid}, func(c *config.Config) { c.DataDir = "" }, "data_dir: required in"},
+		{"no data dir needed", []types.Mode{types.ModeMemory, types.ModeColumnar, types.ModeVector}, func(c *config.Config) { c.DataDir = "" }, ""},
+		{"no vector dim", []types.Mode{types.ModeVector, types.ModeHybrid}, func(c *config.Config) { c.VectorDim = 0 }, "vector_dim: must be positive"},
+		{"no vector dim needed", []types.Mode{types.ModeMemory, types.ModeDisk, types.ModeColumnar}, func(c *config.Config) { c.VectorDim = 0 }, ""},
+		{"unknown mode", nil, func(c *config.Config) { c.Mode = "tape" }, `mode: unknown mode "tape"`},
+		{"port", nil, func(c *config.Config) { c.Port = 70000 }, "port: 70000 is not a TCP port"},
+		{"grpc port", nil, func(c *config.Config) { c.GrpcPort = -1 }, "grpc_port: must not be negative"},
+		{"negative limit", nil, func(c *config.Config) { c.ReadRateLimit = -5 }, "read_rate_limit: must not be negative"},
+		{"nested negative", nil, func(c *config.Config) { c.CORS.MaxAge = -1 }, "cors.max_age: must not be negative"},
+		{"compression", nil, func(c *config.Config) { c.CompressionLevel = 11 }, "compression_level: must be 0 (off) to 9"},
+		{"short secret", nil, func(c *config.Config) { c.JWTSecret = "hunter2" }, "jwt_secret: must be at least 16 bytes, got 7"},
+		{"role", nil, func(c *config.Config) { c.APIKeys = map[string]string{"k1": "root", "k2": "read"} }, `api_keys: unknown role "root"`},
+		{"log level", nil, func(c *config.Config) { c.LogLevel = "loud" }, `log_level: unknown level "loud"`},
+		{"codec", nil, func(c *config.Config) { c.Codec = "cbor" }, `codec: unknown codec "cbor"`},
+	}
+	for _, tt := range tests {
+		modes := tt.modes
+		if modes == nil {
+			modes = []types.Mode{types.ModeMemory, types.ModeDisk, types.ModeColumnar, types.ModeVector, types.ModeHybrid}
+		}
+		for _, mode := range modes {
+			cfg := presets[mode]()
+			tt.edit(cfg)
+			err := cfg.Validate()
+			if tt.err == "" {
+				assert.NoError(t, err, "%s/%s", tt.name, mode)
+			} else {
+				assert.ErrorContains(t, err, tt.err, "%s/%s", tt.name, mode)
+			}
+		}
+	}
+
+	// Every problem is reported, and secrets are not
+	cfg := config.DiskConfig()
+	cfg.DataDir, cfg.Port = "", 99999
+	cfg.APIKeys = map[string]string{"sk-very-secret": "superuser"}
+	err := cfg.Validate()
+	assert.ErrorContains(t, err, "data_dir")
+	assert.ErrorContains(t, err, "port")
+	assert.ErrorContains(t, err, "api_keys")
+	assert.NotContains(t, err.Error(), "sk-very-secret")
+
+	// Opening an engine refuses an invalid config up front
+	_, err = kvi.Open(cfg)
+	assert.ErrorContains(t, err, "invalid config")
+
+	// A setting the mode ignores is a warning, not an error
+	cfg = config.VectorConfig(3)
+	cfg.EnableWAL = true
+	assert.NoError(t, cfg.Validate())
+	assert.Equal(t, []string{"enable_wal has no effect in vector mode, which keeps no data on disk"}, cfg.Warnings())
+}
+
+func TestConfigReload(t *testing.T) {
+	running := config.DefaultConfig()
+	running.JWTSecret = "the-first-secret-of-many"
+	next := config.DefaultConfig()
+	next.Mode = types.ModeDisk
+	next.VectorDim = 768
+	next.LogLevel = "debug"
+	next.CORS.AllowedOrigins = []string{"https://app.example.com"}
+	next.JWTSecret = "the-second-secret-of-many"
+
+	merged, report := config.Reload(running, next)
+	assert.Equal(t, []config.Change{
+		{Key: "jwt_secret", Old: "<redacted>", New: "<redacted>"},
+		{Key: "log_level", Old: "info", New: "debug"},
+		{Key: "cors.allowed_origins", Old: []string{"*"}, New: []string{"https://app.example.com"}},
+	}, report.Applied)
+	assert.Equal(t, []config.Change{
+		{Key: "mode", Old: types.ModeHybrid, New: types.ModeDisk},
+		{Key: "vector_dim", Old: 384, New: 768},
+	}, report.RestartRequired)
+
+	// Only the reloadable settings change, and only in the copy
+	assert.Equal(t, types.ModeHybrid, merged.Mode)
+	assert.Equal(t, 384, merged.VectorDim)
+	assert.Equal(t, "debug", merged.LogLevel)
+	assert.Equal(t, next.CORS.AllowedOrigins, merged.CORS.AllowedOrigins)
+	assert.Equal(t, "the-second-secret-of-many", merged.JWTSecret)
+	assert.Equal(t, "info", running.LogLevel)
+	assert.Equal(t, []string{"*"}, running.CORS.AllowedOrigins)
+
+	_, report = config.Reload(running, running)
+	assert.Empty(t, report.Applied)
+	assert.Empty(t, report.RestartRequired)
+
+	assert.True(t, config.Reloadable("cors.max_age"))
+	assert.True(t, config.Reloadable("api_keys"))
+	assert.False(t, config.Reloadable("data_dir"))
+	assert.False(t, config.Reloadable("log_format"))
+}
@@ -0,0 +1,475 @@
+// Package conformance is a behavioural test suite every storage engine must
+// pass. Run it from a test with a Factory for the engine under test:
+//
+//	conformance.Run(t, func(t *testing.T) (types.Engine, func()) { ... })
+//
+// Records written by the suite carry three-dimensional vectors, so the
+// factory must configure VectorDim 3 for engines that index them.
+// Engines must report their optional behaviour (batches, change feeds,
+// vector search, ...) as types.Capabilities, which the suite checks
+// against the interfaces they implement; the tests for behaviour an engine
+// lacks are skipped.
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// Factory opens a fresh, empty engine. cleanup releases whatever it holds
+// beyond the engine itself; the suite closes the engine.
+type Factory func(t *testing.T) (eng types.Engine, cleanup func())
+
+// Run runs every conformance test against engines from open.
+func Run(t *testing.T, open Factory) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, types.Engine, Factory)
+	}{
+		{"Capabilities", testCapabilities},
+		{"CRUD", testCRUD},
+		{"Overwrite", testOverwrite},
+		{"ScanOrder", testScanOrder},
+		{"ScanLimit", testScanLimit},
+		{"Batch", testBatch},
+		{"TTL", testTTL},
+		{"SnapshotRestore", testSnapshotRestore},
+		{"Concurrency", testConcurrency},
+		{"Errors", testErrors},
+		{"Search", testSearch},
+		{"TextSearch", testTextSearch},
+		{"Watch", testWatch},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			eng := openEngine(t, open)
+			tc.fn(t, eng, open)
+		})
+	}
+}
+
+func openEngine(t *testing.T, open Factory) types.Engine {
+	eng, cleanup := open(t)
+	t.Cleanup(func() {
+		eng.Close()
+		if cleanup != nil {
+			cleanup()
+		}
+	})
+	return eng
+}
+
+// record builds a record for key with a vector derived from n.
+func record(key string, n int) *types.Record {
+	return &types.Record{
+		ID:     key,
+		Data:   map[string]interface{}{"n": float64(n)},
+		Vector: []float32{float32(n), 1, 0},
+	}
+}
+
+func capabilities(t *testing.T, eng types.Engine) types.Capabilities {
+	t.Helper()
+	r, ok := eng.(types.CapabilityReporter)
+	require.True(t, ok, "engine is not a types.CapabilityReporter")
+	return r.Capabilities()
+}
+
+func testCapabilities(t *testing.T, eng types.Engine, _ Factory) {
+	caps := capabilities(t, eng)
+	_, batch := eng.(types.Batcher)
+	_, watch := eng.(types.Watcher)
+	_, search := eng.(types.Searcher)
+	_, filtered := eng.(types.FilteredSearcher)
+	_, pin := eng.(types.Pinner)
+	_, text := eng.(types.TextSearcher)
+	assert.Equal(t, batch, caps.Batch, "Batch")
+	assert.Equal(t, watch, caps.Watch, "Watch")
+	assert.Equal(t, search, caps.Search, "Search")
+	assert.Equal(t, filtered, caps.FilteredSearch, "FilteredSearch")
+	assert.Equal(t, pin, caps.Pin, "Pin")
+	assert.Equal(t, text, caps.TextSearch, "TextSearch")
+
+	ctx := context.Background()
+	err := eng.Put(ctx, "plain", &types.Record{ID: "plain", Data: map[string]interface{}{}})
+	if caps.VectorRequired {
+		assert.ErrorIs(t, err, types.ErrInvalidVector)
+	} else {
+		assert.NoError(t, err)
+	}
+}
+
+func testCRUD(t *testing.T, eng types.Engine, _ Factory) {
+	ctx := context.Background()
+	require.NoError(t, eng.Put(ctx, "a", record("a", 1)))
+
+	got, err := eng.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, "a", got.ID)
+	assert.Equal(t, 1.0, got.Data["n"])
+	assert.Equal(t, uint64(1), got.Version)
+	assert.False(t, got.CreatedAt.IsZero())
+
+	require.NoError(t, eng.Delete(ctx, "a"))
+	_, err = eng.Get(ctx, "a")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+
+	require.NoError(t, eng.Put(ctx, "a", record("a", 2)))
+	got, err = eng.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, got.Data["n"], "a deleted key can be written again")
+}
+
+func testOverwrite(t *testing.T, eng types.Engine, _ Factory) {
+	ctx := context.Background()
+	require.NoError(t, eng.Put(ctx, "k", &types.Record{ID: "k", Data: map[string]interface{}{"old": true}, Vector: []float32{1, 0, 0}}))
+	first, err := eng.Get(ctx, "k")
+	require.NoError(t, err)
+	created := first.CreatedAt
+
+	require.NoError(t, eng.Put(ctx, "k", record("k", 2)))
+	got, err := eng.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), got.Version)
+	assert.Equal(t, 2.0, got.Data["n"])
+	assert.NotContains(t, got.Data, "old", "a put replaces the whole record")
+	assert.Equal(t, created, got.CreatedAt)
+	assert.False(t, got.UpdatedAt.Before(created))
+
+	updated, err := eng.Update(ctx, "k", func(rec *types.Record) error {
+		rec.Data["n"] = 3.0
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), updated.Version)
+
+	require.NoError(t, eng.CompareAndSwap(ctx, "k", 3, record("k", 4)))
+	got, err = eng.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), got.Version)
+	assert.Equal(t, 4.0, got.Data["n"])
+}
+
+func scanKeys(t *testing.T, eng types.Engine, prefix string) []string {
+	t.Helper()
+	var keys []string
+	require.NoError(t, eng.Scan(context.Background(), prefix, func(rec *types.Record) bool {
+		keys = append(keys, rec.ID)
+		return true
+	}))
+	return keys
+}
+
+func testScanOrder(t *testing.T, eng types.Engine, _ Factory) {
+	ctx := context.Background()
+	var want []string
+	for i := range 600 { // more than one scan chunk
+		want = append(want, fmt.Sprintf("user:%04d", i))
+	}
+	for _, i := range rand.Perm(len(want)) {
+		require.NoError(t, eng.Put(ctx, want[i], record(want[i], i)))
+	}
+	require.NoError(t, eng.Put(ctx, "other", record("other", 0)))
+	require.NoError(t, eng.Put(ctx, "use", record("use", 0)))
+
+	assert.Equal(t, want, scanKeys(t, eng, "user:"))
+	all := scanKeys(t, eng, "")
+	assert.Len(t, all, len(want)+2)
+	assert.True(t, slices.IsSorted(all))
+	assert.Empty(t, scanKeys(t, eng, "nobody:"))
+}
+
+func testScanLimit(t *testing.T, eng types.Engine, _ Factory) {
+	ctx := context.Background()
+	for i := range 20 {
+		key := fmt.Sprintf("k%02d", i)
+		require.NoError(t, eng.Put(ctx, key, record(key, i)))
+	}
+
+	var keys []string
+	require.NoError(t, eng.Scan(ctx, "k", func(rec *types.Record) bool {
+		keys = append(keys, rec.ID)
+		return len(keys) < 5
+	}))
+	assert.Equal(t, []string{"k00", "k01", "k02", "k03", "k04"}, keys)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	err := eng.Scan(cancelled, "k", func(*types.Record) bool { return true })
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func testBatch(t *testing.T, eng types.Engine, _ Factory) {
+	if !capabilities(t, eng).Batch {
+		t.Skip("engine does not batch")
+	}
+	b := eng.(types.Batcher)
+	ctx := context.Background()
+	require.NoError(t, b.BatchPut(ctx, []*types.Record{record("b1", 1), record("b2", 2), record("b1", 3)}))
+
+	got, err := b.BatchGet(ctx, []string{"b1", "b2", "missing"})
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, 3.0, got["b1"].Data["n"], "later records in a batch win")
+	assert.Equal(t, uint64(2), got["b1"].Version)
+
+	deleted, err := b.BatchDelete(ctx, []string{"b1", "missing"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"b1": true}, deleted)
+	_, err = eng.Get(ctx, "b1")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+}
+
+func testTTL(t *testing.T, eng types.Engine, _ Factory) {
+	ctx := context.Background()
+	soon := time.Now().Add(50 * time.Millisecond)
+	later := time.Now().Add(time.Hour)
+	short, long := record("ttl:short", 1), record("ttl:long", 2)
+	short.TTL, long.TTL = &soon, &later
+	short.Data["body"], long.Data["body"] = "expiring soon", "expiring later"
+	if capabilities(t, eng).Search {
+		short.Vector, long.Vector = []float32{1, 0, 0}, []float32{0, 1, 0}
+	}
+	text, _ := eng.(types.TextSearcher)
+	if text != nil {
+		require.NoError(t, text.CreateTextIndex("body"))
+	}
+	require.NoError(t, eng.Put(ctx, "ttl:short", short))
+	require.NoError(t, eng.Put(ctx, "ttl:long", long))
+
+	_, err := eng.Get(ctx, "ttl:short")
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	// Every read leaves the expired record out
+	_, err = eng.Get(ctx, "ttl:short")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+	assert.Equal(t, []string{"ttl:long"}, scanKeys(t, eng, "ttl:"))
+	if b, ok := eng.(types.Batcher); ok {
+		found, err := b.BatchGet(ctx, []string{"ttl:short", "ttl:long"})
+		require.NoError(t, err)
+		assert.Len(t, found, 1)
+		assert.Contains(t, found, "ttl:long")
+	}
+	if capabilities(t, eng).Search {
+		found, err := eng.(types.Searcher).Search(ctx, []float32{1, 0, 0}, 1)
+		require.NoError(t, err)
+		require.Len(t, found, 1, "an expired record does not take a place in the top k")
+		assert.Equal(t, "ttl:long", found[0].ID)
+	}
+	if text != nil {
+		hits, err := text.TextSearch(ctx, "body", "expiring", 0)
+		require.NoError(t, err)
+		require.Len(t, hits, 1)
+		assert.Equal(t, "ttl:long", hits[0].Record.ID)
+	}
+	_, err = eng.Update(ctx, "ttl:short", func(*types.Record) error { return nil })
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+
+	require.NoError(t, eng.Put(ctx, "ttl:short", record("ttl:short", 3)))
+	got, err := eng.Get(ctx, "ttl:short")
+	require.NoError(t, err)
+	assert.Nil(t, got.TTL)
+}
+
+func testSnapshotRestore(t *testing.T, eng types.Engine, open Factory) {
+	ctx := context.Background()
+	for i := range 50 {
+		key := fmt.Sprintf("s%02d", i)
+		require.NoError(t, eng.Put(ctx, key, record(key, i)))
+	}
+	var buf bytes.Buffer
+	sum, err := backup.Dump(ctx, eng, &buf)
+	require.NoError(t, err)
+	assert.Equal(t, 50, sum.Records)
+
+	restored := openEngine(t, open)
+	require.NoError(t, restored.Put(ctx, "stale", record("stale", 0)))
+	res, err := backup.Restore(ctx, restored, bytes.NewReader(buf.Bytes()), backup.Replace)
+	require.NoError(t, err)
+	assert.Equal(t, 50, res.Restored)
+	assert.Equal(t, 1, res.Removed)
+
+	assert.Equal(t, scanKeys(t, eng, ""), scanKeys(t, restored, ""))
+	got, err := restored.Get(ctx, "s07")
+	require.NoError(t, err)
+	assert.Equal(t, 7.0, got.Data["n"])
+	assert.Equal(t, []float32{7, 1, 0}, got.Vector)
+}
+
+func testConcurrency(t *testing.T, eng types.Engine, _ Factory) {
+	ctx := context.Background()
+	require.NoError(t, eng.Put(ctx, "counter", record("counter", 0)))
+
+	const workers, rounds = 8, 50
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Go(func() {
+			for i := range rounds {
+				key := fmt.Sprintf("w%d:%d", w, i)
+				assert.NoError(t, eng.Put(ctx, key, record(key, i)))
+				_, err := eng.Get(ctx, key)
+				assert.NoError(t, err)
+				_, err = eng.Update(ctx, "counter", func(rec *types.Record) error {
+					rec.Data["n"] = rec.Data["n"].(float64) + 1
+					return nil
+				})
+				assert.NoError(t, err)
+				if i%10 == 0 {
+					assert.NoError(t, eng.Scan(ctx, fmt.Sprintf("w%d:", w), func(*types.Record) bool { return true }))
+					assert.NoError(t, eng.Delete(ctx, key))
+				}
+			}
+		})
+	}
+	wg.Wait()
+
+	got, err := eng.Get(ctx, "counter")
+	require.NoError(t, err)
+	assert.Equal(t, float64(workers*rounds), got.Data["n"], "no update is lost")
+	assert.Equal(t, uint64(workers*rounds+1), got.Version)
+	assert.Len(t, scanKeys(t, eng, "w"), workers*(rounds-rounds/10))
+}
+
+func testErrors(t *testing.T, eng types.Engine, _ Factory) {
+	ctx := context.Background()
+	_, err := eng.Get(ctx, "missing")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+	_, err = eng.Update(ctx, "missing", func(*types.Record) error { return nil })
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+	assert.NoError(t, eng.Delete(ctx, "missing"), "deleting a missing key succeeds")
+
+	err = eng.CompareAndSwap(ctx, "missing", 3, record("missing", 0))
+	assert.ErrorIs(t, err, types.ErrVersionMismatch)
+	require.NoError(t, eng.CompareAndSwap(ctx, "new", 0, record("new", 0)))
+	err = eng.CompareAndSwap(ctx, "new", 0, record("new", 1))
+	var mismatch *types.VersionMismatchError
+	if assert.ErrorAs(t, err, &mismatch) {
+		assert.Equal(t, uint64(1), mismatch.Current)
+	}
+
+	if capabilities(t, eng).Search {
+		bad := &types.Record{ID: "bad", Vector: []float32{1, 2}}
+		assert.ErrorIs(t, eng.Put(ctx, "bad", bad), types.ErrInvalidVector)
+		_, err = eng.Get(ctx, "bad")
+		assert.ErrorIs(t, err, types.ErrKeyNotFound, "a rejected write leaves nothing behind")
+	}
+
+	require.NoError(t, eng.Close())
+	assert.ErrorIs(t, eng.Close(), types.ErrClosed)
+	assert.ErrorIs(t, eng.Put(ctx, "k", record("k", 0)), types.ErrClosed)
+	_, err = eng.Get(ctx, "k")
+	assert.ErrorIs(t, err, types.ErrClosed)
+	assert.ErrorIs(t, eng.Delete(ctx, "k"), types.ErrClosed)
+	assert.ErrorIs(t, eng.Scan(ctx, "", func(*types.Record) bool { return true }), types.ErrClosed)
+}
+
+func testSearch(t *testing.T, eng types.Engine, _ Factory) {
+	if !capabilities(t, eng).Search {
+		t.Skip("engine does not search vectors")
+	}
+	s := eng.(types.Searcher)
+	ctx := context.Background()
+	require.NoError(t, eng.Put(ctx, "near", &types.Record{ID: "near", Vector: []float32{1, 0, 0}}))
+	require.NoError(t, eng.Put(ctx, "far", &types.Record{ID: "far", Vector: []float32{0, 0, 1}}))
+	require.NoError(t, eng.Put(ctx, "gone", &types.Record{ID: "gone", Vector: []float32{1, 0.01, 0}}))
+	require.NoError(t, eng.Delete(ctx, "gone"))
+	expired := time.Now().Add(-time.Second)
+	require.NoError(t, eng.Put(ctx, "expired", &types.Record{ID: "expired", Vector: []float32{1, 0.02, 0}, TTL: &expired}))
+
+	found, err := s.Search(ctx, []float32{1, 0, 0}, 2)
+	require.NoError(t, err)
+	require.Len(t, found, 2, "deleted and expired records leave room for others")
+	assert.Equal(t, "near", found[0].ID)
+	for _, rec := range found {
+		assert.NotEqual(t, "gone", rec.ID, "deleted records leave the index")
+		assert.NotEqual(t, "expired", rec.ID, "expired records are not found")
+	}
+
+	require.NoError(t, eng.Put(ctx, "far", &types.Record{ID: "far", Vector: []float32{1, 0, 0.01}}))
+	found, err = s.Search(ctx, []float32{1, 0, 0}, 2)
+	require.NoError(t, err)
+	var ids []string
+	for _, rec := range found {
+		ids = append(ids, rec.ID)
+	}
+	assert.ElementsMatch(t, []string{"near", "far"}, ids, "an overwrite moves the record in the index")
+}
+
+func testTextSearch(t *testing.T, eng types.Engine, _ Factory) {
+	if !capabilities(t, eng).TextSearch {
+		t.Skip("engine has no text index")
+	}
+	s := eng.(types.TextSearcher)
+	ctx := context.Background()
+	doc := func(key, text string) *types.Record {
+		rec := record(key, 0)
+		rec.Data["body"] = text
+		return rec
+	}
+	require.NoError(t, eng.Put(ctx, "before", doc("before", "Timeout talking to the disk")))
+	require.NoError(t, s.CreateTextIndex("body"))
+	require.NoError(t, eng.Put(ctx, "both", doc("both", "error: timeout, timeout again")))
+	require.NoError(t, eng.Put(ctx, "error", doc("error", "an error")))
+	require.NoError(t, eng.Put(ctx, "gone", doc("gone", "error timeout")))
+	require.NoError(t, eng.Delete(ctx, "gone"))
+
+	keys := func(query string, limit int) []string {
+		hits, err := s.TextSearch(ctx, "body", query, limit)
+		require.NoError(t, err)
+		ids := []string{}
+		for _, hit := range hits {
+			ids = append(ids, hit.Record.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{"both", "before"}, keys("timeout", 0), "records stored before the index are indexed")
+	assert.Equal(t, []string{"both"}, keys("ERROR timeout", 0), "every word must match")
+	assert.Equal(t, []string{"both", "before", "error"}, keys("error OR timeout", 0))
+	assert.Equal(t, []string{"both"}, keys("error OR timeout", 1))
+	assert.Empty(t, keys("the", 0), "stopwords are not indexed")
+
+	require.NoError(t, eng.Put(ctx, "both", doc("both", "all good")))
+	assert.Equal(t, []string{"before"}, keys("timeout", 0), "an overwrite replaces the record's words")
+
+	_, err := s.TextSearch(ctx, "title", "timeout", 0)
+	assert.ErrorIs(t, err, types.ErrNoTextIndex)
+}
+
+func testWatch(t *testing.T, eng types.Engine, _ Factory) {
+	if !capabilities(t, eng).Watch {
+		t.Skip("engine has no change feed")
+	}
+	w := eng.(types.Watcher)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events, err := w.Watch(ctx, "w:", 0)
+	require.NoError(t, err)
+
+	require.NoError(t, eng.Put(ctx, "w:1", record("w:1", 1)))
+	require.NoError(t, eng.Put(ctx, "other", record("other", 1)))
+	require.NoError(t, eng.Delete(ctx, "w:1"))
+
+	var ops []types.Operation
+	for len(ops) < 2 {
+		select {
+		case ev := <-events:
+			assert.Equal(t, "w:1", ev.Key)
+			ops = append(ops, ev.Op)
+		case <-ctx.Done():
+			t.Fatalf("got %v before timing out", ops)
+		}
+	}
+	assert.Equal(t, []types.Operation{types.OpPut, types.OpDelete}, ops)
+}
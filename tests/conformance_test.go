@@ -0,0 +1,26 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+	"github.com/thirawat27/kvi/tests/conformance"
+)
+
+func TestConformance(t *testing.T) {
+	for _, mode := range []types.Mode{types.ModeMemory, types.ModeDisk, types.ModeColumnar, types.ModeVector, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			conformance.Run(t, func(t *testing.T) (types.Engine, func()) {
+				cfg := config.DefaultConfig()
+				cfg.Mode, cfg.DataDir, cfg.VectorDim = mode, t.TempDir(), 3
+				eng, err := kvi.Open(cfg)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return eng, nil
+			})
+		})
+	}
+}
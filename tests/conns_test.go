@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConnectionLimits(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	conns, streams := stats.NewPool(1), stats.NewPool(1)
+	srv := api.NewServer(eng, api.WithConnLimits(conns, streams))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go srv.Serve(l)
+	defer srv.Shutdown(context.Background())
+	url := "http://" + l.Addr().String()
+
+	// Each client keeps its own connection open between requests
+	first, second := &http.Client{Transport: &http.Transport{}}, &http.Client{Transport: &http.Transport{}}
+	defer first.CloseIdleConnections()
+	resp, err := first.Get(url + "/health/live")
+	assert.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = second.Get(url + "/health/live")
+	assert.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("Retry-After"))
+	assert.True(t, resp.Close, "the rejected connection is closed")
+	assert.Contains(t, string(body), types.ErrConnectionLimit.Error())
+
+	// A subscription moves its connection to the streams pool, freeing a
+	// connection for the other client
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "GET", url+"/api/v1/sub?channel=c&id=s1", nil)
+	sub, err := first.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, sub.StatusCode)
+	nextLine(t, sub)
+	resp, err = second.Get(url + "/health/live")
+	assert.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// ...but a second subscription is over the streams limit
+	var report struct {
+		Connections stats.PoolStats `json:"connections"`
+		Streams     stats.PoolStats `json:"streams"`
+	}
+	resp, err = second.Get(url + "/api/v1/sub?channel=c&id=s2")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	resp, err = (&http.Client{Transport: &http.Transport{}}).Get(url + "/api/v1/stats")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "the second client's connection is still open")
+	second.CloseIdleConnections()
+	assert.Eventually(t, func() bool { return conns.Snapshot().Current == 0 }, 2*time.Second, 10*time.Millisecond)
+
+	resp, err = second.Get(url + "/api/v1/stats")
+	assert.NoError(t, err)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	resp.Body.Close()
+	assert.Equal(t, stats.PoolStats{Current: 1, Peak: 1, Limit: 1, Rejected: 2}, report.Connections)
+	assert.Equal(t, stats.PoolStats{Current: 1, Peak: 1, Limit: 1, Rejected: 1}, report.Streams)
+
+	// Ending the subscription gives its place back
+	cancel()
+	sub.Body.Close()
+	assert.Eventually(t, func() bool { return streams.Snapshot().Current == 0 }, 2*time.Second, 10*time.Millisecond)
+}
+
+// nextLine waits for the first line of an SSE response, showing the
+// subscription is up.
+func nextLine(t *testing.T, resp *http.Response) {
+	t.Helper()
+	buf := make([]byte, 1)
+	_, err := resp.Body.Read(buf)
+	assert.NoError(t, err)
+}
+
+func TestGrpcConnectionLimits(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	conns, streams := stats.NewPool(1), stats.NewPool(1)
+	client := serveGrpc(t, kvi_grpc.NewGrpcServer(eng, pubsub.NewHub(),
+		kvi_grpc.WithWatchHeartbeat(10*time.Millisecond), kvi_grpc.WithConnLimits(conns, streams)),
+		kvi_grpc.Interceptors(kvi_grpc.Middleware{Conns: conns, Streams: streams})...)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A Watch takes the only stream; a second is turned away
+	watch, err := client.Watch(ctx, &kvi_grpc.WatchRequest{Prefix: "user:"})
+	assert.NoError(t, err)
+	_, err = watch.Recv()
+	assert.NoError(t, err)
+	again, err := client.Watch(ctx, &kvi_grpc.WatchRequest{Prefix: "user:"})
+	assert.NoError(t, err)
+	_, err = again.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), types.ErrConnectionLimit.Error())
+
+	// Ordinary calls have their own pool
+	_, err = client.Put(ctx, &kvi_grpc.PutRequest{Key: "user:1", DataJson: "{}"})
+	assert.NoError(t, err)
+
+	// An open batch stream holds the only connection place
+	batch, err := client.BatchGetStream(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, batch.Send(&kvi_grpc.BatchGetRequest{Keys: []string{"user:1"}}))
+	_, err = batch.Recv()
+	assert.NoError(t, err)
+	_, err = client.Get(ctx, &kvi_grpc.GetRequest{Key: "user:1"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.NoError(t, batch.CloseSend())
+	_, err = batch.Recv()
+	assert.Equal(t, io.EOF, err)
+
+	assert.Eventually(t, func() bool { return conns.Snapshot().Current == 0 }, 2*time.Second, 10*time.Millisecond)
+	_, err = client.Get(ctx, &kvi_grpc.GetRequest{Key: "user:1"})
+	assert.NoError(t, err)
+
+	resp, err := client.Stats(ctx, &kvi_grpc.StatsRequest{})
+	assert.NoError(t, err)
+	var report stats.Report
+	assert.NoError(t, json.Unmarshal([]byte(resp.ReportJson), &report))
+	assert.Equal(t, &stats.PoolStats{Current: 1, Peak: 1, Limit: 1, Rejected: 1}, report.Connections) // the Stats call itself
+	assert.Equal(t, &stats.PoolStats{Current: 1, Peak: 1, Limit: 1, Rejected: 1}, report.Streams)
+}
@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func openHybrid(t *testing.T) types.Engine {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { eng.Close() })
+	return eng
+}
+
+func TestWatermark(t *testing.T) {
+	ctx := context.Background()
+	eng := openHybrid(t)
+	wm := eng.(types.Watermarker)
+
+	for i := range 200 {
+		require.NoError(t, eng.Put(ctx, "k"+strconv.Itoa(i), &types.Record{Data: map[string]interface{}{"n": 1}}))
+	}
+	assert.EqualValues(t, 200, wm.Watermark().Written)
+
+	// Reading your writes waits for the columnar tier to have them all
+	require.NoError(t, wm.Await(ctx, types.ConsistencyReadYourWrites, 0))
+	mark := wm.Watermark()
+	assert.EqualValues(t, 200, mark.Applied)
+	sum, err := eng.(interface{ Sum(string) (float64, error) }).Sum("n")
+	require.NoError(t, err)
+	assert.EqualValues(t, 200, sum)
+	stats := eng.(types.StatsReporter).Stats()
+	assert.Zero(t, *stats.AsyncQueue)
+	assert.Equal(t, mark, *stats.Watermark)
+
+	// Strong reads sync the WAL too; a watermark from before the engine
+	// opened counts as now
+	assert.NotZero(t, walStats(t, eng).Pending)
+	require.NoError(t, wm.Await(ctx, types.ConsistencyStrong, 1<<40))
+	assert.Zero(t, walStats(t, eng).Pending)
+	assert.Equal(t, walStats(t, eng).LastLSN, wm.Watermark().SyncedLSN)
+
+	// Eventual reads never wait
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.NoError(t, wm.Await(canceled, types.ConsistencyEventual, 0))
+
+	_, err = types.ParseConsistency("linearizable")
+	assert.Error(t, err)
+	level, err := types.ParseConsistency("")
+	require.NoError(t, err)
+	assert.Equal(t, types.ConsistencyEventual, level)
+}
+
+func TestConsistencyParam(t *testing.T) {
+	eng := openHybrid(t)
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/api/v1/put", "application/json", jsonBody(map[string]interface{}{"key": "a", "data": map[string]int{"n": 5}}))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusCreated, resp.StatusCode)
+	mark := resp.Header.Get("X-Kvi-Watermark")
+	assert.Equal(t, "1", mark)
+
+	var rec map[string]interface{}
+	getJSON(t, ts.URL+"/api/v1/get?key=a&consistency=read-your-writes&after="+mark, &rec)
+	assert.Equal(t, "a", rec["id"])
+	var list map[string]interface{}
+	getJSON(t, ts.URL+"/api/v1/scan?prefix=&consistency=strong", &list)
+	assert.EqualValues(t, 1, list["count"])
+	assert.Zero(t, walStats(t, eng).Pending)
+
+	for _, bad := range []string{"consistency=always", "consistency=strong&after=x"} {
+		resp, err := http.Get(ts.URL + "/api/v1/get?key=a&" + bad)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, bad)
+	}
+
+	// Other engines apply writes before acknowledging them, so only strong
+	// reads differ, and need a WAL
+	_, memTS := memoryServer(t)
+	resp, err = http.Post(memTS.URL+"/api/v1/put", "application/json", jsonBody(map[string]interface{}{"key": "a", "data": map[string]int{"n": 5}}))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, resp.Header.Get("X-Kvi-Watermark"))
+	for level, want := range map[string]int{"read-your-writes": http.StatusOK, "strong": http.StatusNotImplemented} {
+		resp, err := http.Get(memTS.URL + "/api/v1/get?key=a&consistency=" + level)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, want, resp.StatusCode, level)
+	}
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+)
+
+func corsServer(t *testing.T, opts ...func(*api.Server)) *httptest.Server {
+	t.Helper()
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	t.Cleanup(func() { eng.Close() })
+	ts := httptest.NewServer(api.NewServer(eng, opts...).Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func preflight(t *testing.T, url, origin, method string) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodOptions, url, nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	req.Header.Set("Access-Control-Request-Headers", "content-type")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func TestCORSDefaultPolicy(t *testing.T) {
+	ts := corsServer(t)
+
+	resp := preflight(t, ts.URL+"/api/v1/channels", "https://anywhere.test", "POST")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.Equal(t, "*", resp.Header.Get("Access-Control-Allow-Origin"))
+	// Only the methods this route serves are advertised
+	assert.Equal(t, "GET, POST", resp.Header.Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "600", resp.Header.Get("Access-Control-Max-Age"))
+
+	assert.Equal(t, http.StatusNotFound, preflight(t, ts.URL+"/nope", "https://anywhere.test", "GET").StatusCode)
+}
+
+func TestCORSRestrictedOrigins(t *testing.T) {
+	policy := config.DefaultCORS()
+	policy.AllowedOrigins = []string{"https://app.example.com", "https://*.corp.example"}
+	policy.AllowCredentials = true
+	ts := corsServer(t, api.WithCORS(policy))
+
+	for _, origin := range []string{"https://evil.test", "https://corp.example", "http://x.corp.example"} {
+		resp := preflight(t, ts.URL+"/api/v1/get", origin, "GET")
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode, origin)
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"), origin)
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Methods"), origin)
+		assert.Empty(t, resp.Header.Get("Access-Control-Allow-Credentials"), origin)
+	}
+
+	resp := preflight(t, ts.URL+"/api/v1/get", "https://team.corp.example", "GET")
+	assert.Equal(t, "https://team.corp.example", resp.Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/health", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	got, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	got.Body.Close()
+	assert.Equal(t, http.StatusOK, got.StatusCode)
+	assert.Equal(t, "https://app.example.com", got.Header.Get("Access-Control-Allow-Origin"))
+	assert.Contains(t, got.Header.Get("Access-Control-Expose-Headers"), "ETag")
+	assert.Contains(t, got.Header.Values("Vary"), "Origin")
+}
+
+func TestCORSDisabled(t *testing.T) {
+	ts := corsServer(t, api.WithCORS(config.CORSConfig{Disabled: true}))
+
+	resp := preflight(t, ts.URL+"/api/v1/channels", "https://app.example.com", "GET")
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Access-Control-Allow-Origin"))
+}
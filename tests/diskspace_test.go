@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/client"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// logged reports whether logs has a line with msg.
+func logged(t *testing.T, logs *syncBuffer, msg string) bool {
+	for _, line := range logs.lines(t) {
+		if line["msg"] == msg {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDiskFence(t *testing.T) {
+	ctx := context.Background()
+	var logs syncBuffer
+	cfg := config.DiskConfig()
+	cfg.DataDir, cfg.DiskCheckIntervalMs = t.TempDir(), 10
+	cfg.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	for i := range 100 {
+		require.NoError(t, eng.Put(ctx, "k", &types.Record{ID: "k", Data: map[string]interface{}{"n": i}}))
+	}
+	require.NoError(t, eng.(types.Syncer).Sync(ctx))
+	assert.Eventually(t, func() bool {
+		return !eng.(types.StatsReporter).Stats().Disk.CheckedAt.IsZero()
+	}, 5*time.Second, 5*time.Millisecond)
+	stats := eng.(types.StatsReporter).Stats()
+	assert.Equal(t, cfg.DataDir, stats.Disk.Dir)
+	assert.NotZero(t, stats.Disk.FreeBytes)
+	assert.EqualValues(t, 32<<20, stats.Disk.FenceBytes)
+	assert.False(t, stats.Disk.Fenced)
+	walBefore := stats.WAL.SizeBytes
+
+	// A mark above any disk's free space fences writes at the next check,
+	// and the WAL is compacted to give space back
+	eng.(types.DiskFencer).SetDiskFence(1 << 62)
+	check := eng.(types.HealthChecker).HealthChecks()["disk_space"]
+	assert.Eventually(t, func() bool { return check(ctx) != nil }, 5*time.Second, 5*time.Millisecond)
+	assert.ErrorContains(t, check(ctx), "disk full: ")
+	err = eng.Put(ctx, "k", &types.Record{ID: "k"})
+	assert.ErrorIs(t, err, types.ErrDiskFull)
+	assert.ErrorContains(t, err, cfg.DataDir)
+	assert.ErrorIs(t, eng.Delete(ctx, "k"), types.ErrDiskFull)
+	rec, err := eng.Get(ctx, "k") // reads carry on
+	require.NoError(t, err)
+	assert.EqualValues(t, 100, rec.Version)
+	assert.Eventually(t, func() bool { return logged(t, &logs, "emergency compaction done") }, 5*time.Second, 5*time.Millisecond)
+	assert.True(t, logged(t, &logs, "writes fenced: data dir is low on space"))
+	stats = eng.(types.StatsReporter).Stats()
+	assert.True(t, stats.Disk.Fenced)
+	assert.Less(t, stats.WAL.SizeBytes, walBefore)
+
+	// Both APIs refuse writes with 507 / RESOURCE_EXHAUSTED, which the
+	// client maps back
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+	code, body := postBody(t, ts.URL+"/api/v1/put", strings.NewReader(`{"key":"k","data":{}}`))
+	assert.Equal(t, http.StatusInsufficientStorage, code)
+	assert.Contains(t, body, "disk full")
+	_, err = startGrpc(t, eng, nil).Put(ctx, &kvi_grpc.PutRequest{Key: "k", DataJson: `{}`})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	c, err := client.New(client.WithHTTP(ts.URL))
+	require.NoError(t, err)
+	defer c.Close()
+	assert.ErrorIs(t, c.Put(ctx, "k", &types.Record{}), types.ErrDiskFull)
+	resp, err := http.Get(ts.URL + "/health/ready")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	// Once there is space again, writes go through
+	eng.(types.DiskFencer).SetDiskFence(0)
+	assert.Eventually(t, func() bool { return eng.Put(ctx, "k", &types.Record{ID: "k"}) == nil }, 5*time.Second, 5*time.Millisecond)
+	assert.NoError(t, check(ctx))
+	assert.True(t, logged(t, &logs, "writes unfenced: data dir has space again"))
+	assert.False(t, eng.(types.StatsReporter).Stats().Disk.Fenced)
+}
+
+func TestDiskFenceHybrid(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.HybridConfig()
+	cfg.DataDir, cfg.DiskCheckIntervalMs, cfg.DiskFenceMB = t.TempDir(), 10, 1<<40
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+
+	assert.Eventually(t, func() bool {
+		return eng.(types.StatsReporter).Stats().Disk.Fenced
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.ErrorIs(t, eng.Put(ctx, "k", &types.Record{ID: "k"}), types.ErrDiskFull)
+	assert.Error(t, eng.(types.HealthChecker).HealthChecks()["disk_space"](ctx))
+	assert.NotZero(t, workerStats(t, eng, "disk_monitor").Cycles)
+
+	eng.(types.DiskFencer).SetDiskFence(0)
+	assert.Eventually(t, func() bool { return eng.Put(ctx, "k", &types.Record{ID: "k"}) == nil }, 5*time.Second, 5*time.Millisecond)
+
+	// The monitor is off with a 0 interval
+	cfg = config.DiskConfig()
+	cfg.DataDir, cfg.DiskCheckIntervalMs, cfg.DiskFenceMB = t.TempDir(), 0, 1<<40
+	quiet, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer quiet.Close()
+	assert.NoError(t, quiet.Put(ctx, "k", &types.Record{ID: "k"}))
+	for _, w := range quiet.(types.WorkerReporter).Workers() {
+		assert.NotEqual(t, "disk_monitor", w.Name)
+	}
+	assert.True(t, config.Reloadable("disk_fence_mb"))
+}
@@ -25,3 +25,36 @@ func TestEnginePut(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "test", retrieved.Data["value"])
 }
+
+// TestEngineModes opens every mode from its exported preset, so a preset
+// and its engine cannot drift apart unnoticed.
+func TestEngineModes(t *testing.T) {
+	presets := map[types.Mode]func() *config.Config{
+		types.ModeMemory:   config.MemoryConfig,
+		types.ModeDisk:     config.DiskConfig,
+		types.ModeColumnar: config.ColumnarConfig,
+		types.ModeVector:   func() *config.Config { return config.VectorConfig(3) },
+		types.ModeHybrid:   config.HybridConfig,
+	}
+	ctx := context.Background()
+	for mode, preset := range presets {
+		cfg := preset()
+		assert.Equal(t, mode, cfg.Mode)
+		cfg.DataDir, cfg.VectorDim = t.TempDir(), 3
+		eng, err := kvi.Open(cfg)
+		if !assert.NoError(t, err, mode) {
+			continue
+		}
+		record := &types.Record{ID: "k", Data: map[string]interface{}{"v": 1.0}, Vector: []float32{1, 0, 0}}
+		assert.NoError(t, eng.Put(ctx, "k", record), mode)
+		got, err := eng.Get(ctx, "k")
+		assert.NoError(t, err, mode)
+		if assert.NotNil(t, got, mode) {
+			assert.Equal(t, 1.0, got.Data["v"], mode)
+		}
+		if r, ok := eng.(types.StatsReporter); ok {
+			assert.Equal(t, mode, r.Stats().Mode, mode)
+		}
+		assert.NoError(t, eng.Close(), mode)
+	}
+}
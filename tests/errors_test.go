@@ -0,0 +1,42 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+)
+
+// TestAPIErrorStatuses checks the REST API maps engine errors to statuses.
+// The engines' errors themselves are covered by the conformance suite.
+func TestAPIErrorStatuses(t *testing.T) {
+	cfg := config.VectorConfig(3)
+	cfg.DataDir = t.TempDir()
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	do := func(method, path, body string) int {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusNotFound, do(http.MethodGet, "/api/v1/get?key=missing", ""))
+	assert.Equal(t, http.StatusNotFound, do(http.MethodPatch, "/api/v1/patch", `{"key":"missing","set":{"a":1}}`))
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/v1/put", `{"key":"bad","data":{"a":1},"vector":[1,2]}`))
+
+	require.NoError(t, eng.Close())
+	assert.Equal(t, http.StatusServiceUnavailable, do(http.MethodGet, "/api/v1/get?key=missing", ""))
+	assert.Equal(t, http.StatusServiceUnavailable, do(http.MethodPost, "/api/v1/put", `{"key":"k","data":{"a":1},"vector":[1,0,0]}`))
+}
@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// expiries counts the calls of an OnExpire callback by key.
+type expiries struct {
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func (x *expiries) record(key string, rec *types.Record) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if rec.ID == key {
+		x.calls[key]++
+	}
+}
+
+func (x *expiries) count() (keys, calls int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for _, n := range x.calls {
+		keys, calls = keys+1, calls+n
+	}
+	return keys, calls
+}
+
+func runGC(t *testing.T, eng types.Engine) {
+	t.Helper()
+	require.NoError(t, eng.(types.Maintainer).Maintenance()[types.MaintenanceGC](context.Background(), func(int, int) {}))
+}
+
+func TestOnExpire(t *testing.T) {
+	ctx := context.Background()
+	modes := map[types.Mode]func() *config.Config{
+		types.ModeMemory:   config.MemoryConfig,
+		types.ModeDisk:     config.DiskConfig,
+		types.ModeColumnar: config.ColumnarConfig,
+		types.ModeHybrid:   config.DefaultConfig,
+	}
+	for mode, newConfig := range modes {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := newConfig()
+			cfg.DataDir, cfg.GCIntervalMs = t.TempDir(), 0
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+
+			seen := &expiries{calls: make(map[string]int)}
+			require.NoError(t, kvi.OnExpire(eng, seen.record))
+			putExpiring(t, eng, "gone", 20, 30*time.Millisecond)
+			putExpiring(t, eng, "kept", 2, time.Hour)
+			time.Sleep(40 * time.Millisecond)
+
+			// A read finding a record expired removes it and reports it
+			// without waiting for gc, however many reads find it
+			for range 5 {
+				_, err = eng.Get(ctx, "gone00003")
+				assert.ErrorIs(t, err, types.ErrKeyNotFound)
+			}
+			assert.Eventually(t, func() bool {
+				keys, _ := seen.count()
+				return keys == 1
+			}, 5*time.Second, 5*time.Millisecond)
+
+			runGC(t, eng)
+			assert.Eventually(t, func() bool {
+				keys, _ := seen.count()
+				return keys == 20
+			}, 5*time.Second, 5*time.Millisecond)
+			runGC(t, eng)
+			time.Sleep(20 * time.Millisecond)
+			keys, calls := seen.count()
+			assert.Equal(t, 20, keys)
+			assert.Equal(t, 20, calls, "each expiry reported once")
+		})
+	}
+}
+
+func TestOnExpireCopiesRecord(t *testing.T) {
+	cfg := config.MemoryConfig()
+	cfg.GCIntervalMs = 0
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+
+	got := make(chan *types.Record, 2)
+	kvi.OnExpire(eng, func(key string, rec *types.Record) {
+		rec.Data["i"] = -1
+		got <- rec
+	})
+	kvi.OnExpire(eng, func(key string, rec *types.Record) { got <- rec })
+	putExpiring(t, eng, "gone", 1, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	runGC(t, eng)
+
+	a, b := <-got, <-got
+	assert.NotSame(t, a, b)
+	assert.ElementsMatch(t, []interface{}{-1, 0}, []interface{}{a.Data["i"], b.Data["i"]})
+}
+
+// TestOnExpireSlowCallbacks checks that callbacks that do not return hold
+// up neither gc nor writes, and that Close drops what is queued once those
+// running return.
+func TestOnExpireSlowCallbacks(t *testing.T) {
+	cfg := config.MemoryConfig()
+	cfg.GCIntervalMs = 0
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	var mu sync.Mutex
+	calls := 0
+	kvi.OnExpire(eng, func(string, *types.Record) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		<-release
+	})
+	putExpiring(t, eng, "gone", 50, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+
+	start := time.Now()
+	runGC(t, eng)
+	putExpiring(t, eng, "kept", 1, time.Hour)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls > 0
+	}, 5*time.Second, 5*time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		assert.NoError(t, eng.Close())
+	}()
+	select {
+	case <-closed:
+		t.Fatal("Close returned while callbacks were running")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not return")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Less(t, calls, 50, "queued expiries dropped on Close")
+}
+
+func TestPublishExpired(t *testing.T) {
+	cfg := config.MemoryConfig()
+	cfg.GCIntervalMs = 0
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+
+	hub := pubsub.NewHub()
+	sub := hub.Subscribe(kvi.ExpiredChannel, "watcher")
+	defer sub.Unsubscribe()
+	require.NoError(t, kvi.PublishExpired(eng, hub))
+	putExpiring(t, eng, "gone", 1, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	runGC(t, eng)
+
+	select {
+	case msg := <-sub.C:
+		assert.Equal(t, pubsub.ContentTypeJSON, msg.ContentType)
+		var exp kvi.Expiration
+		require.NoError(t, json.Unmarshal([]byte(msg.Payload), &exp))
+		assert.Equal(t, "gone00000", exp.Key)
+		assert.Equal(t, "gone00000", exp.Record.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message on " + kvi.ExpiredChannel)
+	}
+}
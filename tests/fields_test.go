@@ -0,0 +1,169 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// typedUser is what every route below should decode to.
+type typedUser struct {
+	Name   string    `json:"name"`
+	Age    int       `json:"age"`
+	Score  float64   `json:"score"`
+	Admin  bool      `json:"admin"`
+	Joined time.Time `json:"joined"`
+	Roles  []string  `json:"roles"`
+}
+
+var joined = time.Date(2024, 6, 1, 15, 0, 0, 0, time.UTC)
+
+const typedJSON = `{"name":"Ann","age":30,"score":4.5,"admin":true,"joined":"2024-06-01T15:00:00Z","roles":["dev","ops"],"big":9007199254740993}`
+
+func assertTyped(t *testing.T, rec *types.Record) {
+	t.Helper()
+	name, ok := rec.GetString("name")
+	assert.True(t, ok)
+	assert.Equal(t, "Ann", name)
+	age, ok := rec.GetInt64("age")
+	assert.True(t, ok)
+	assert.EqualValues(t, 30, age)
+	score, ok := rec.GetFloat64("score")
+	assert.True(t, ok)
+	assert.Equal(t, 4.5, score)
+	asFloat, ok := rec.GetFloat64("age")
+	assert.True(t, ok)
+	assert.Equal(t, 30.0, asFloat)
+	admin, ok := rec.GetBool("admin")
+	assert.True(t, ok)
+	assert.True(t, admin)
+	at, ok := rec.GetTime("joined")
+	assert.True(t, ok)
+	assert.True(t, joined.Equal(at))
+	roles, ok := rec.GetStringSlice("roles")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"dev", "ops"}, roles)
+
+	// Wrong types and missing fields are not ok
+	_, ok = rec.GetInt64("score")
+	assert.False(t, ok, "4.5 is no integer")
+	_, ok = rec.GetString("age")
+	assert.False(t, ok)
+	_, ok = rec.GetBool("missing")
+	assert.False(t, ok)
+	_, ok = rec.GetTime("name")
+	assert.False(t, ok)
+	_, ok = rec.GetStringSlice("name")
+	assert.False(t, ok)
+
+	var u typedUser
+	require.NoError(t, rec.Decode(&u))
+	assert.True(t, joined.Equal(u.Joined))
+	u.Joined = joined
+	assert.Equal(t, typedUser{Name: "Ann", Age: 30, Score: 4.5, Admin: true, Joined: joined, Roles: []string{"dev", "ops"}}, u)
+}
+
+func TestRecordFieldsOverHTTP(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	status, _ := postBody(t, ts.URL+"/api/v1/put", strings.NewReader(`{"key":"u","data":`+typedJSON+`}`))
+	require.Equal(t, http.StatusCreated, status)
+	rec, err := eng.Get(context.Background(), "u")
+	require.NoError(t, err)
+	assertTyped(t, rec)
+}
+
+func TestRecordFieldsOverGrpc(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	client := serveGrpc(t, kvi_grpc.NewGrpcServer(eng, nil))
+
+	_, err = client.Put(context.Background(), &kvi_grpc.PutRequest{Key: "u", DataJson: typedJSON})
+	require.NoError(t, err)
+	rec, err := eng.Get(context.Background(), "u")
+	require.NoError(t, err)
+	assertTyped(t, rec)
+}
+
+func TestRecordFieldsFromLibrary(t *testing.T) {
+	ctx := context.Background()
+	for _, codec := range []string{"json", "msgpack"} {
+		t.Run(codec, func(t *testing.T) {
+			cfg := config.DiskConfig()
+			cfg.DataDir, cfg.Codec = t.TempDir(), codec
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			rec := &types.Record{ID: "u"}
+			rec.SetField("name", "Ann")
+			rec.SetField("age", int32(30))
+			rec.SetField("score", float32(4.5))
+			rec.SetField("admin", true)
+			rec.SetField("joined", joined)
+			rec.SetField("roles", []string{"dev", "ops"})
+			rec.SetField("gone", 1)
+			rec.DeleteField("gone")
+			assertTyped(t, rec)
+			require.NoError(t, eng.Put(ctx, "u", rec))
+
+			// As put, and as the codec reads it back from the WAL
+			got, err := eng.Get(ctx, "u")
+			require.NoError(t, err)
+			assertTyped(t, got)
+			require.NoError(t, eng.Close())
+			eng, err = kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+			got, err = eng.Get(ctx, "u")
+			require.NoError(t, err)
+			assertTyped(t, got)
+		})
+	}
+}
+
+func TestRecordFieldNumbers(t *testing.T) {
+	rec := &types.Record{Data: map[string]interface{}{
+		"whole": 3.0, "uint": uint64(1 << 63), "neg": int8(-4), "huge": 1e19, "exact": "12",
+	}}
+	n, ok := rec.GetInt64("whole")
+	assert.True(t, ok)
+	assert.EqualValues(t, 3, n)
+	n, ok = rec.GetInt64("neg")
+	assert.True(t, ok)
+	assert.EqualValues(t, -4, n)
+	_, ok = rec.GetInt64("uint")
+	assert.False(t, ok, "past int64")
+	_, ok = rec.GetInt64("huge")
+	assert.False(t, ok, "past int64")
+	_, ok = rec.GetInt64("exact")
+	assert.False(t, ok, "strings are not numbers")
+	f, ok := rec.GetFloat64("uint")
+	assert.True(t, ok)
+	assert.Equal(t, float64(1<<63), f)
+
+	// Data is made as needed, and kept once emptied
+	empty := &types.Record{}
+	empty.DeleteField("x")
+	assert.NotNil(t, empty.Data)
+	empty.SetField("x", 1)
+	empty.DeleteField("x")
+	assert.Equal(t, map[string]interface{}{}, empty.Data)
+	var u typedUser
+	assert.NoError(t, (&types.Record{}).Decode(&u))
+	assert.Zero(t, u)
+}
@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/internal/format"
+	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func walHeader(t *testing.T, path string) (format.Header, int64) {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	hdr, size, err := wal.ReadHeader(f)
+	require.NoError(t, err)
+	return hdr, size
+}
+
+func TestWALFormatVersion(t *testing.T) {
+	path := writeWAL(t, 3)
+	dir := filepath.Dir(path)
+	hdr, size := walHeader(t, path)
+	assert.EqualValues(t, 32, size)
+	assert.Equal(t, format.Header{Format: "kvi-wal", Version: 2, MinReader: 2, MinRelease: "1.1.0", CreatedAt: hdr.CreatedAt}, hdr)
+	assert.False(t, hdr.CreatedAt.IsZero())
+	original, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	// A log from before headers is read, and appended to, as it is
+	legacy := original[size:]
+	require.NoError(t, os.WriteFile(path, legacy, 0o644))
+	eng, err := kvi.OpenDisk(dir)
+	require.NoError(t, err)
+	require.NoError(t, eng.Put(context.Background(), "k9", &types.Record{ID: "k9"}))
+	require.NoError(t, eng.Close())
+	hdr, size = walHeader(t, path)
+	assert.Equal(t, 1, hdr.Version)
+	assert.Zero(t, size)
+	assert.Len(t, scanWAL(t, path), 5)
+
+	// Upgrading puts a header before the same entries, once
+	upgraded, err := wal.Upgrade(path)
+	require.NoError(t, err)
+	assert.True(t, upgraded)
+	hdr, size = walHeader(t, path)
+	assert.Equal(t, 2, hdr.Version)
+	frames := scanWAL(t, path)
+	require.Len(t, frames, 5)
+	assert.Equal(t, size, frames[0].Offset)
+	assert.Equal(t, "k9", frames[4].Entry.Key)
+	upgraded, err = wal.Upgrade(path)
+	require.NoError(t, err)
+	assert.False(t, upgraded)
+
+	// So does rewriting a log from before headers
+	require.NoError(t, os.WriteFile(path, legacy, 0o644))
+	w, err := wal.NewWAL(dir)
+	require.NoError(t, err)
+	require.NoError(t, w.Rewrite(func(put func(string, *types.Record) error) error {
+		return put("a", &types.Record{ID: "a"})
+	}))
+	require.NoError(t, w.Close())
+	hdr, _ = walHeader(t, path)
+	assert.Equal(t, 2, hdr.Version)
+	assert.Len(t, scanWAL(t, path), 1)
+
+	// A newer version is refused, naming the release that reads it,
+	// unless it says this binary's version can read it
+	newer := bytes.Clone(original)
+	binary.LittleEndian.PutUint16(newer[6:], 3)
+	binary.LittleEndian.PutUint16(newer[8:], 3)
+	copy(newer[10:24], "1.4.0\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	require.NoError(t, os.WriteFile(path, newer, 0o644))
+	_, err = kvi.OpenDisk(dir)
+	assert.ErrorIs(t, err, format.ErrTooNew)
+	assert.ErrorContains(t, err, "kvi-wal format version 3 needs kvi 1.4.0 or later to read; this binary reads up to version 2")
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	assert.ErrorIs(t, wal.Scan(f, func(wal.Frame) bool { return true }), format.ErrTooNew)
+	f.Close()
+	_, err = wal.Upgrade(path)
+	assert.ErrorIs(t, err, format.ErrTooNew)
+
+	binary.LittleEndian.PutUint16(newer[8:], 2)
+	require.NoError(t, os.WriteFile(path, newer, 0o644))
+	eng, err = kvi.OpenDisk(dir)
+	require.NoError(t, err)
+	_, err = eng.Get(context.Background(), "k1")
+	assert.NoError(t, err)
+	require.NoError(t, eng.Close())
+}
+
+// gzipLines writes a backup stream of the given lines.
+func gzipLines(t *testing.T, lines ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	for _, line := range lines {
+		_, err := zw.Write([]byte(line + "\n"))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestBackupFormatVersion(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	require.NoError(t, eng.Put(ctx, "a", &types.Record{ID: "a", Data: map[string]interface{}{"n": 1.0}}))
+
+	// Writers record the minimum reader version
+	var buf bytes.Buffer
+	_, err = backup.Dump(ctx, eng, &buf)
+	require.NoError(t, err)
+	hdr, err := backup.ReadHeader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, 1, hdr.MinReader)
+	assert.Equal(t, "1.0.0", hdr.MinRelease)
+
+	record := `{"id":"a","data":{"n":1}}`
+	count := func(data []byte) (int, error) {
+		n := 0
+		_, err := backup.Read(bytes.NewReader(data), func(*types.Record) error { n++; return nil })
+		return n, err
+	}
+	tooNew := gzipLines(t, `{"format":"kvi-backup","version":3,"min_reader":3,"min_release":"1.4.0","created_at":"2026-01-01T00:00:00Z"}`, record)
+	_, err = count(tooNew)
+	assert.ErrorIs(t, err, format.ErrTooNew)
+	assert.EqualError(t, err, "kvi-backup format version 3 needs kvi 1.4.0 or later to read; this binary reads up to version 2")
+	_, err = backup.Verify(bytes.NewReader(tooNew), backup.Expect{})
+	assert.ErrorIs(t, err, format.ErrTooNew)
+	readable := gzipLines(t, `{"format":"kvi-backup","version":3,"min_reader":1,"created_at":"2026-01-01T00:00:00Z"}`, record)
+	n, err := count(readable)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// A backup from before min_reader is upgraded in place, with its
+	// checksum file
+	path := filepath.Join(t.TempDir(), "old.kvibak")
+	old := gzipLines(t, `{"format":"kvi-backup","version":1,"created_at":"2026-01-01T00:00:00Z"}`, record)
+	require.NoError(t, os.WriteFile(path, old, 0o644))
+	require.NoError(t, os.WriteFile(path+".sha256", []byte("stale  old.kvibak\n"), 0o644))
+	upgraded, err := backup.UpgradeFile(path)
+	require.NoError(t, err)
+	assert.True(t, upgraded)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	hdr, err = backup.ReadHeader(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 1, hdr.MinReader)
+	assert.Equal(t, "2026-01-01T00:00:00Z", hdr.CreatedAt.Format("2006-01-02T15:04:05Z07:00"))
+	n, err = count(data)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	sum, _, err := backup.Checksum(bytes.NewReader(data))
+	require.NoError(t, err)
+	sidecar, err := os.ReadFile(path + ".sha256")
+	require.NoError(t, err)
+	assert.Equal(t, sum+"  old.kvibak\n", string(sidecar))
+	upgraded, err = backup.UpgradeFile(path)
+	require.NoError(t, err)
+	assert.False(t, upgraded)
+}
@@ -0,0 +1,132 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func gcStats(t *testing.T, eng types.Engine) types.GCStats {
+	t.Helper()
+	stats := eng.(types.StatsReporter).Stats()
+	require.NotNil(t, stats.GC)
+	return *stats.GC
+}
+
+// putExpiring writes n records under prefix that expire after ttl.
+func putExpiring(t testing.TB, eng types.Engine, prefix string, n int, ttl time.Duration) {
+	t.Helper()
+	ctx := context.Background()
+	for i := range n {
+		at := time.Now().Add(ttl)
+		key := fmt.Sprintf("%s%05d", prefix, i)
+		require.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, Data: map[string]interface{}{"i": i}, TTL: &at}))
+	}
+}
+
+func TestGCAfterRecovery(t *testing.T) {
+	for _, mode := range []types.Mode{types.ModeDisk, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Mode, cfg.DataDir, cfg.GCIntervalMs = mode, t.TempDir(), 0
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			putExpiring(t, eng, "gone", 20, 50*time.Millisecond)
+			putExpiring(t, eng, "kept", 2, time.Hour)
+			require.NoError(t, eng.Close())
+			time.Sleep(60 * time.Millisecond)
+
+			eng, err = kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+			assert.Equal(t, types.GCStats{Runs: 1, Reclaimed: 20}, gcStats(t, eng))
+			assert.Equal(t, 2, eng.(types.StatsReporter).Stats().Records)
+		})
+	}
+}
+
+func TestGCPeriodic(t *testing.T) {
+	for _, cfg := range []*config.Config{config.MemoryConfig(), config.ColumnarConfig(), config.DefaultConfig()} {
+		t.Run(string(cfg.Mode), func(t *testing.T) {
+			cfg.DataDir, cfg.GCIntervalMs = t.TempDir(), 10
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+
+			putExpiring(t, eng, "gone", 30, 20*time.Millisecond)
+			putExpiring(t, eng, "kept", 3, time.Hour)
+			assert.Eventually(t, func() bool {
+				return gcStats(t, eng).Reclaimed == 30
+			}, 5*time.Second, 10*time.Millisecond)
+			assert.Equal(t, 3, eng.(types.StatsReporter).Stats().Records)
+		})
+	}
+}
+
+func TestGCAfterRestore(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.MemoryConfig()
+	cfg.GCIntervalMs = 0
+	src, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer src.Close()
+	putExpiring(t, src, "key", 5, time.Hour)
+	var buf bytes.Buffer
+	_, err = backup.Dump(ctx, src, &buf)
+	require.NoError(t, err)
+
+	dst, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer dst.Close()
+	putExpiring(t, dst, "stale", 4, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	res, err := backup.Restore(ctx, dst, bytes.NewReader(buf.Bytes()), backup.Merge)
+	require.NoError(t, err)
+	assert.Equal(t, 5, res.Restored)
+	assert.Equal(t, types.GCStats{Runs: 1, Reclaimed: 4}, gcStats(t, dst), "expired records go once the restore is done")
+}
+
+// BenchmarkScanExpired scans a disk keyspace where 90% of the keys have
+// expired, before and after gc removes them from the tree. After gc a scan
+// costs about what one of the live keys alone would.
+func BenchmarkScanExpired(b *testing.B) {
+	ctx := context.Background()
+	for _, collect := range []bool{false, true} {
+		name := "expired"
+		if collect {
+			name = "collected"
+		}
+		b.Run(name, func(b *testing.B) {
+			cfg := config.DiskConfig()
+			cfg.DataDir, cfg.EnableWAL, cfg.GCIntervalMs = b.TempDir(), false, 0
+			eng, err := kvi.Open(cfg)
+			require.NoError(b, err)
+			defer eng.Close()
+			putExpiring(b, eng, "gone", 90000, 10*time.Millisecond)
+			putExpiring(b, eng, "kept", 10000, time.Hour)
+			time.Sleep(20 * time.Millisecond)
+			if collect {
+				gc := eng.(types.Maintainer).Maintenance()[types.MaintenanceGC]
+				require.NoError(b, gc(ctx, func(done, total int) {}))
+			}
+
+			for b.Loop() {
+				n := 0
+				require.NoError(b, eng.Scan(ctx, "", func(*types.Record) bool {
+					n++
+					return true
+				}))
+				require.Equal(b, 10000, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcSnapshot downloads a snapshot, checking the chunk sequence.
+func grpcSnapshot(t *testing.T, client kvi_grpc.KviServiceClient) ([]*kvi_grpc.SnapshotChunk, *kvi_grpc.SnapshotChunk) {
+	t.Helper()
+	stream, err := client.Snapshot(context.Background(), &kvi_grpc.SnapshotRequest{})
+	assert.NoError(t, err)
+	var chunks []*kvi_grpc.SnapshotChunk
+	for {
+		chunk, err := stream.Recv()
+		assert.NoError(t, err)
+		if chunk.Last {
+			_, err = stream.Recv()
+			assert.Equal(t, io.EOF, err)
+			return chunks, chunk
+		}
+		assert.Equal(t, uint64(len(chunks)), chunk.Index)
+		assert.LessOrEqual(t, len(chunk.Data), 1<<20)
+		chunks = append(chunks, chunk)
+	}
+}
+
+func grpcRestore(t *testing.T, client kvi_grpc.KviServiceClient, chunks []*kvi_grpc.RestoreChunk) (*kvi_grpc.RestoreResponse, error) {
+	t.Helper()
+	stream, err := client.Restore(context.Background())
+	assert.NoError(t, err)
+	for _, c := range chunks {
+		assert.NoError(t, stream.Send(c))
+	}
+	return stream.CloseAndRecv()
+}
+
+func TestGrpcSnapshotRestore(t *testing.T) {
+	src, _ := memoryServer(t)
+	ctx := context.Background()
+	for i := 0; i < 2000; i++ { // incompressible, so the backup spans several chunks
+		blob := make([]byte, 512)
+		rand.Read(blob)
+		key := fmt.Sprintf("k%04d", i)
+		assert.NoError(t, src.Put(ctx, key, &types.Record{ID: key, Data: map[string]interface{}{"blob": hex.EncodeToString(blob)}}))
+	}
+	chunks, last := grpcSnapshot(t, startGrpc(t, src, pubsub.NewHub()))
+	assert.Greater(t, len(chunks), 1)
+	assert.Equal(t, uint64(len(chunks)), last.TotalChunks)
+	assert.Equal(t, int64(2000), last.Records)
+
+	dst, _ := memoryServer(t)
+	assert.NoError(t, dst.Put(ctx, "stale", &types.Record{ID: "stale", Data: map[string]interface{}{}}))
+	client := startGrpc(t, dst, pubsub.NewHub())
+	upload := func() []*kvi_grpc.RestoreChunk {
+		var out []*kvi_grpc.RestoreChunk
+		for _, c := range chunks {
+			out = append(out, &kvi_grpc.RestoreChunk{Index: c.Index, Data: c.Data})
+		}
+		out[0].Checksum = last.Checksum
+		return out
+	}
+
+	// Out-of-order and corrupted uploads change nothing
+	bad := upload()
+	bad[0], bad[1] = bad[1], bad[0]
+	_, err := grpcRestore(t, client, bad)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	bad = upload()
+	bad[1] = &kvi_grpc.RestoreChunk{Index: 1, Data: append([]byte{}, chunks[0].Data...)}
+	_, err = grpcRestore(t, client, bad)
+	assert.Equal(t, codes.DataLoss, status.Code(err))
+	_, err = dst.Get(ctx, "stale")
+	assert.NoError(t, err)
+
+	// A dry run reports the counts and changes nothing
+	dry := upload()
+	dry[0].DryRun = true
+	resp, err := grpcRestore(t, client, dry)
+	assert.NoError(t, err)
+	assert.True(t, resp.DryRun)
+	assert.Equal(t, int64(2000), resp.Restored)
+	assert.Equal(t, int64(1), resp.Removed)
+	_, err = dst.Get(ctx, "stale")
+	assert.NoError(t, err)
+
+	resp, err = grpcRestore(t, client, upload())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2000), resp.Restored)
+	assert.Equal(t, int64(1), resp.Removed)
+	assert.Equal(t, last.Checksum, resp.Checksum)
+	want, _ := src.Get(ctx, "k1234")
+	got, err := dst.Get(ctx, "k1234")
+	assert.NoError(t, err)
+	assert.Equal(t, want.Data, got.Data)
+}
@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// plainEngine hides an engine's optional interfaces, so the server falls
+// back to one call per key.
+type plainEngine struct {
+	types.Engine
+}
+
+func TestGrpcBatch(t *testing.T) {
+	disk, err := kvi.OpenDisk(t.TempDir())
+	assert.NoError(t, err)
+	defer disk.Close()
+	hybridCfg := config.DefaultConfig()
+	hybridCfg.DataDir = t.TempDir()
+	hybrid, err := kvi.Open(hybridCfg)
+	assert.NoError(t, err)
+	defer hybrid.Close()
+	mem, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer mem.Close()
+
+	for name, eng := range map[string]types.Engine{"disk": disk, "hybrid": hybrid, "per-key": &plainEngine{mem}} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			past := time.Now().Add(-time.Second)
+			assert.NoError(t, eng.Put(ctx, "a", &types.Record{ID: "a", Data: map[string]interface{}{"n": 1}}))
+			assert.NoError(t, eng.Put(ctx, "b", &types.Record{ID: "b", Data: map[string]interface{}{"n": 2}}))
+			assert.NoError(t, eng.Put(ctx, "expired", &types.Record{ID: "expired", Data: map[string]interface{}{}, TTL: &past}))
+			client := serveGrpc(t, kvi_grpc.NewGrpcServer(eng, pubsub.NewHub(), kvi_grpc.WithMaxBatch(4)))
+
+			got, err := client.BatchGet(ctx, &kvi_grpc.BatchGetRequest{Keys: []string{"a", "missing", "expired", "b"}})
+			assert.NoError(t, err)
+			assert.Len(t, got.Records, 2)
+			assert.JSONEq(t, `{"n":1}`, got.Records["a"].DataJson)
+			assert.Equal(t, uint64(1), got.Records["b"].Version)
+			assert.Equal(t, []string{"missing", "expired"}, got.Missing)
+
+			deleted, err := client.BatchDelete(ctx, &kvi_grpc.BatchDeleteRequest{Keys: []string{"a", "missing", "a"}})
+			assert.NoError(t, err)
+			var results []bool
+			for _, r := range deleted.Results {
+				results = append(results, r.Deleted)
+			}
+			assert.Equal(t, []bool{true, false, false}, results)
+			_, err = eng.Get(ctx, "a")
+			assert.ErrorIs(t, err, types.ErrKeyNotFound)
+
+			_, err = client.BatchGet(ctx, &kvi_grpc.BatchGetRequest{Keys: []string{"1", "2", "3", "4", "5"}})
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			_, err = client.BatchDelete(ctx, &kvi_grpc.BatchDeleteRequest{Keys: []string{"1", "2", "3", "4", "5"}})
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+			// Larger batches stream in messages of up to the limit
+			stream, err := client.BatchGetStream(ctx)
+			assert.NoError(t, err)
+			for _, keys := range [][]string{{"a", "b", "c", "d"}, {"b"}} {
+				assert.NoError(t, stream.Send(&kvi_grpc.BatchGetRequest{Keys: keys}))
+				resp, err := stream.Recv()
+				assert.NoError(t, err)
+				assert.Contains(t, resp.Records, "b")
+				assert.Len(t, resp.Records, 1)
+			}
+			assert.NoError(t, stream.Send(&kvi_grpc.BatchGetRequest{Keys: []string{"1", "2", "3", "4", "5"}}))
+			_, err = stream.Recv()
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+			dstream, err := client.BatchDeleteStream(ctx)
+			assert.NoError(t, err)
+			assert.NoError(t, dstream.Send(&kvi_grpc.BatchDeleteRequest{Keys: []string{"b", "c"}}))
+			resp, err := dstream.Recv()
+			assert.NoError(t, err)
+			assert.True(t, resp.Results[0].Deleted)
+			assert.False(t, resp.Results[1].Deleted)
+			assert.NoError(t, dstream.CloseSend())
+			_, err = dstream.Recv()
+			assert.Error(t, err) // io.EOF: the server ended the stream
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// sickEngine fails its health checks while sick is set.
+type sickEngine struct {
+	types.Engine
+	sick atomic.Bool
+}
+
+func (e *sickEngine) HealthChecks() map[string]func(context.Context) error {
+	return map[string]func(context.Context) error{"wal": func(context.Context) error {
+		if e.sick.Load() {
+			return errors.New("disk full")
+		}
+		return nil
+	}}
+}
+
+func TestGrpcHealth(t *testing.T) {
+	mem, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer mem.Close()
+	eng := &sickEngine{Engine: mem}
+
+	lis := bufconn.Listen(1 << 20)
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	srv := kvi_grpc.NewGrpcServer(eng, pubsub.NewHub(), kvi_grpc.WithDrain(300*time.Millisecond, time.Second))
+	// Health answers without a token even with auth on
+	served := make(chan error, 1)
+	go func() {
+		served <- kvi_grpc.StartGRPCServer(ctx, lis, srv, kvi_grpc.Interceptors(kvi_grpc.Middleware{Auth: newTestAuth(t)})...)
+	}()
+	health := healthpb.NewHealthClient(dialBufconn(t, lis))
+
+	check := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		assert.NoError(t, err)
+		return resp.GetStatus()
+	}
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, check("kvi.KviService"))
+	_, err = health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "other"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	eng.sick.Store(true)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+	eng.sick.Store(false)
+
+	watch, err := health.Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	resp, err := watch.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+
+	// Shutdown flips health first and keeps answering for the drain delay
+	stop()
+	resp, err = watch.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.Status)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, check(""))
+
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	_, err = watch.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
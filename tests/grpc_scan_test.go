@@ -0,0 +1,167 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcScan runs one Scan call and returns the keys it streamed, the
+// number of messages, and the final message's resume token.
+func grpcScan(t *testing.T, client kvi_grpc.KviServiceClient, req *kvi_grpc.ScanRequest) ([]string, int, string) {
+	t.Helper()
+	stream, err := client.Scan(context.Background(), req)
+	assert.NoError(t, err)
+	var keys []string
+	for messages := 1; ; messages++ {
+		resp, err := stream.Recv()
+		if !assert.NoError(t, err) {
+			return keys, messages, ""
+		}
+		assert.LessOrEqual(t, len(resp.Records), 256)
+		for _, rec := range resp.Records {
+			keys = append(keys, rec.Id)
+		}
+		if resp.Last {
+			_, err := stream.Recv()
+			assert.Equal(t, io.EOF, err)
+			return keys, messages, resp.ResumeToken
+		}
+	}
+}
+
+func TestGrpcScan(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+	ctx := context.Background()
+	var want []string
+	for i := range 1000 {
+		key := fmt.Sprintf("row:%04d", i)
+		want = append(want, key)
+		assert.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, Data: map[string]interface{}{"i": i}}))
+	}
+	assert.NoError(t, eng.Put(ctx, "other", &types.Record{ID: "other", Data: map[string]interface{}{}}))
+	client := serveGrpc(t, kvi_grpc.NewGrpcServer(eng, pubsub.NewHub(), kvi_grpc.WithMaxScanRows(300)))
+
+	// The row cap splits the scan into calls chained by resume tokens
+	var got []string
+	req := &kvi_grpc.ScanRequest{Prefix: "row:"}
+	for calls := 1; ; calls++ {
+		keys, messages, token := grpcScan(t, client, req)
+		got = append(got, keys...)
+		if token == "" {
+			assert.Equal(t, 4, calls)
+			assert.Len(t, keys, 100)
+			break
+		}
+		assert.Len(t, keys, 300)
+		assert.Equal(t, 2, messages)
+		req.ResumeToken = token
+	}
+	assert.Equal(t, want, got)
+
+	// A smaller limit, and a key range
+	keys, _, token := grpcScan(t, client, &kvi_grpc.ScanRequest{Prefix: "row:", Limit: 5})
+	assert.Equal(t, want[:5], keys)
+	assert.NotEmpty(t, token)
+	keys, _, token = grpcScan(t, client, &kvi_grpc.ScanRequest{Prefix: "row:", Start: "row:0500", End: "row:0510"})
+	assert.Equal(t, want[500:510], keys)
+	assert.Empty(t, token)
+	keys, _, token = grpcScan(t, client, &kvi_grpc.ScanRequest{Prefix: "row:", Start: "row:0998", Limit: 2})
+	assert.Equal(t, want[998:], keys)
+	assert.Empty(t, token) // nothing left
+	keys, _, _ = grpcScan(t, client, &kvi_grpc.ScanRequest{Prefix: "nothing:"})
+	assert.Empty(t, keys)
+
+	stream, err := client.Scan(ctx, &kvi_grpc.ScanRequest{ResumeToken: "not base64!"})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// syntheticEngine yields rows generated on the fly, so a scan over it
+// measures only what the server holds on to.
+type syntheticEngine struct {
+	types.Engine
+	rows    int
+	yielded atomic.Int64
+}
+
+func (e *syntheticEngine) Scan(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+	for i := range e.rows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		key := fmt.Sprintf("row:%07d", i)
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		e.yielded.Add(1)
+		if !fn(&types.Record{ID: key, Data: map[string]interface{}{"i": i, "pad": "0123456789abcdef"}, Version: 1}) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// A million-row scan streams with flat memory, and cancelling the call
+// stops the engine's iteration.
+func TestGrpcScanMemory(t *testing.T) {
+	const rows = 1_000_000
+	eng := &syntheticEngine{rows: rows}
+	client := serveGrpc(t, kvi_grpc.NewGrpcServer(eng, pubsub.NewHub(), kvi_grpc.WithMaxScanRows(0)))
+
+	runtime.GC()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	baseline, peak := mem.HeapAlloc, mem.HeapAlloc
+
+	stream, err := client.Scan(context.Background(), &kvi_grpc.ScanRequest{Prefix: "row:"})
+	assert.NoError(t, err)
+	received := 0
+	for messages := 0; ; messages++ {
+		resp, err := stream.Recv()
+		if !assert.NoError(t, err) {
+			break
+		}
+		received += len(resp.Records)
+		if messages%200 == 0 {
+			runtime.ReadMemStats(&mem)
+			peak = max(peak, mem.HeapAlloc)
+		}
+		if resp.Last {
+			break
+		}
+	}
+	assert.Equal(t, rows, received)
+	// Materializing a million records would take hundreds of MiB
+	assert.Less(t, peak-min(peak, baseline), uint64(64<<20), "heap grew by %d MiB", (peak-min(peak, baseline))>>20)
+
+	eng.yielded.Store(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err = client.Scan(ctx, &kvi_grpc.ScanRequest{Prefix: "row:"})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.NoError(t, err)
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	stopped := eng.yielded.Load()
+	assert.Less(t, stopped, int64(rows))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, stopped, eng.yielded.Load()) // no longer iterating
+}
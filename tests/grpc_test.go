@@ -0,0 +1,216 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startGrpc serves the gRPC API over an in-memory listener and returns a
+// connected client.
+func startGrpc(t *testing.T, eng types.Engine, hub *pubsub.Hub, opts ...grpc.ServerOption) kvi_grpc.KviServiceClient {
+	t.Helper()
+	return serveGrpc(t, kvi_grpc.NewGrpcServer(eng, hub), opts...)
+}
+
+// serveGrpc is startGrpc for a configured server.
+func serveGrpc(t *testing.T, srv *kvi_grpc.GrpcServer, opts ...grpc.ServerOption) kvi_grpc.KviServiceClient {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	ctx, stop := context.WithCancel(context.Background())
+	go kvi_grpc.StartGRPCServer(ctx, lis, srv, opts...)
+	t.Cleanup(stop)
+	return kvi_grpc.NewKviServiceClient(dialBufconn(t, lis))
+}
+
+// dialBufconn connects to a server listening on lis.
+func dialBufconn(t *testing.T, lis *bufconn.Listener) *grpc.ClientConn {
+	t.Helper()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestPublishHTTPSubscribeGrpc(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	hub := pubsub.NewHub()
+	client := startGrpc(t, eng, hub)
+
+	srv := api.NewServer(eng, api.WithHub(hub))
+	mux := http.NewServeMux()
+	srv.RegisterHandlers(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := client.Stream(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Send(&kvi_grpc.StreamRequest{Id: "g1", Channel: "events"}))
+
+	assert.Eventually(t, func() bool { return hub.Stats().Subscribers == 1 }, time.Second, 5*time.Millisecond)
+
+	for _, msg := range []interface{}{"plain text", map[string]interface{}{"event": "signup", "user": 7}} {
+		resp, err := http.Post(ts.URL+"/api/v1/pub", "application/json",
+			jsonBody(map[string]interface{}{"channel": "events", "message": msg}))
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	text, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, "plain text", text.Payload)
+	assert.Equal(t, pubsub.ContentTypeText, text.ContentType)
+
+	obj, err := stream.Recv()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"event":"signup","user":7}`, obj.Payload)
+	assert.Equal(t, pubsub.ContentTypeJSON, obj.ContentType)
+}
+
+// A record written over gRPC reads back the same over HTTP, with sub-second
+// TTLs and the timestamps intact.
+func TestGrpcPutConformance(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	client := startGrpc(t, eng, pubsub.NewHub())
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	ctx := context.Background()
+	put, err := client.Put(ctx, &kvi_grpc.PutRequest{Key: "user:1", DataJson: `{"name":"Ann","tags":["a","b"]}`})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), put.Version)
+	put, err = client.Put(ctx, &kvi_grpc.PutRequest{Key: "user:1", DataJson: `{"name":"Ann","age":30}`, TtlMs: 1500, TtlSeconds: 60})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), put.Version)
+
+	got, err := client.Get(ctx, &kvi_grpc.GetRequest{Key: "user:1"})
+	assert.NoError(t, err)
+	var viaHTTP types.Record
+	getJSON(t, ts.URL+"/api/v1/get?key=user:1", &viaHTTP)
+
+	assert.Equal(t, got.Id, viaHTTP.ID)
+	assert.JSONEq(t, `{"name":"Ann","age":30}`, got.DataJson)
+	assert.Equal(t, map[string]interface{}{"name": "Ann", "age": float64(30)}, viaHTTP.Data)
+	assert.Equal(t, got.Version, viaHTTP.Version)
+	assert.True(t, got.ExpiresAt.AsTime().Equal(*viaHTTP.TTL))
+	assert.WithinDuration(t, time.Now().Add(1500*time.Millisecond), *viaHTTP.TTL, time.Second) // ttl_ms won
+	assert.True(t, got.CreatedAt.AsTime().Equal(viaHTTP.CreatedAt))
+	assert.True(t, got.UpdatedAt.AsTime().Equal(viaHTTP.UpdatedAt))
+	assert.True(t, viaHTTP.UpdatedAt.After(viaHTTP.CreatedAt)) // the first write's CreatedAt was kept
+
+	assert.Eventually(t, func() bool {
+		_, err := client.Get(ctx, &kvi_grpc.GetRequest{Key: "user:1"})
+		return err != nil
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+// failingEngine fails every read and write with err.
+type failingEngine struct {
+	types.Engine
+	err error
+}
+
+func (e *failingEngine) Get(ctx context.Context, key string) (*types.Record, error) {
+	return nil, e.err
+}
+func (e *failingEngine) Put(ctx context.Context, key string, rec *types.Record) error {
+	return e.err
+}
+
+func TestGrpcStatusCodes(t *testing.T) {
+	mem, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer mem.Close()
+
+	ctx := context.Background()
+	client := startGrpc(t, mem, pubsub.NewHub())
+	_, err = client.Get(ctx, &kvi_grpc.GetRequest{Key: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Put(ctx, &kvi_grpc.PutRequest{Key: "k", DataJson: "{"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	for _, tc := range []struct {
+		err  error
+		code codes.Code
+	}{
+		{fmt.Errorf("%w for key: k", types.ErrKeyNotFound), codes.NotFound},
+		{fmt.Errorf("%w: bad dim", types.ErrInvalidVector), codes.InvalidArgument},
+		{&types.VersionMismatchError{Key: "k", Expected: 1, Current: 2}, codes.FailedPrecondition},
+		{types.ErrQueueFull, codes.ResourceExhausted},
+		{context.DeadlineExceeded, codes.DeadlineExceeded},
+		{errors.New("disk on fire"), codes.Internal},
+	} {
+		client := startGrpc(t, &failingEngine{Engine: mem, err: tc.err}, pubsub.NewHub())
+		_, err := client.Get(ctx, &kvi_grpc.GetRequest{Key: "k"})
+		assert.Equal(t, tc.code, status.Code(err), tc.err.Error())
+		_, err = client.Put(ctx, &kvi_grpc.PutRequest{Key: "k", DataJson: "{}"})
+		assert.Equal(t, tc.code, status.Code(err), tc.err.Error())
+	}
+}
+
+// Cancelling StartGRPCServer's context ends open streams and returns once
+// the server has stopped.
+func TestGrpcGracefulStop(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	lis := bufconn.Listen(1 << 20)
+	ctx, stop := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		srv := kvi_grpc.NewGrpcServer(eng, pubsub.NewHub(), kvi_grpc.WithWatchHeartbeat(20*time.Millisecond))
+		served <- kvi_grpc.StartGRPCServer(ctx, lis, srv)
+	}()
+	client := kvi_grpc.NewKviServiceClient(dialBufconn(t, lis))
+
+	watch, err := client.Watch(context.Background(), &kvi_grpc.WatchRequest{})
+	assert.NoError(t, err)
+	sub, err := client.Stream(context.Background())
+	assert.NoError(t, err)
+	assert.NoError(t, sub.Send(&kvi_grpc.StreamRequest{Id: "c1", Channel: "events"}))
+	_, err = watch.Recv() // a heartbeat: the watch is registered
+	assert.NoError(t, err)
+
+	stop()
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	_, err = watch.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	_, err = sub.Recv()
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
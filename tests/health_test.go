@@ -0,0 +1,83 @@
+package tests
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+)
+
+type readiness struct {
+	Status string `json:"status"`
+	Checks map[string]struct {
+		Status    string  `json:"status"`
+		LatencyMs float64 `json:"latency_ms"`
+		Error     string  `json:"error"`
+	} `json:"checks"`
+	Failed []string `json:"failed"`
+}
+
+func ready(t *testing.T, url string) (readiness, int) {
+	t.Helper()
+	resp, err := http.Get(url + "/health/ready")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	var body readiness
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	return body, resp.StatusCode
+}
+
+func TestReadinessChecks(t *testing.T) {
+	cfg := config.DefaultConfig() // hybrid: WAL and async writer checks
+	cfg.DataDir = t.TempDir()
+	eng, err := kvi.Open(cfg)
+	assert.NoError(t, err)
+
+	ts := httptest.NewServer(api.NewServer(eng, api.WithDiskCheck(cfg.DataDir, 1)).Handler())
+	defer ts.Close()
+
+	body, code := ready(t, ts.URL)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", body.Status)
+	for _, name := range []string{"engine", "wal", "async_writer", "disk"} {
+		assert.Equal(t, "ok", body.Checks[name].Status, name)
+	}
+	assert.Empty(t, body.Failed)
+
+	// The probe key does not linger
+	var page scanPage
+	getJSON(t, ts.URL+"/api/v1/scan?prefix=__kvi_health__", &page)
+	assert.Empty(t, page.Items)
+
+	// A stopped engine takes the instance out of rotation, liveness stays up
+	eng.Close()
+	body, code = ready(t, ts.URL)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", body.Checks["async_writer"].Status)
+	assert.Contains(t, body.Failed, "async_writer")
+
+	resp, err := http.Get(ts.URL + "/health/live")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestReadinessFailsOnLowDisk(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	ts := httptest.NewServer(api.NewServer(eng, api.WithDiskCheck(t.TempDir(), math.MaxUint64)).Handler())
+	defer ts.Close()
+
+	body, code := ready(t, ts.URL)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", body.Checks["disk"].Status)
+	assert.Equal(t, "ok", body.Checks["engine"].Status)
+}
@@ -2,11 +2,17 @@ package tests
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/pkg/api"
 	"github.com/thirawat27/kvi/pkg/config"
 	"github.com/thirawat27/kvi/pkg/kvi"
 	"github.com/thirawat27/kvi/pkg/types"
@@ -51,3 +57,186 @@ func TestHybridEnginePutGetDelete(t *testing.T) {
 	err = eng.Close()
 	assert.NoError(t, err)
 }
+
+func TestHybridEngineScanAndSearch(t *testing.T) {
+	cfg := config.HybridConfig()
+	cfg.DataDir, cfg.VectorDim = t.TempDir(), 3
+
+	eng, err := kvi.Open(cfg)
+	assert.NoError(t, err)
+	defer eng.Close()
+	ctx := context.Background()
+
+	assert.NoError(t, eng.Put(ctx, "doc:b", &types.Record{ID: "doc:b", Data: map[string]interface{}{"n": 2}, Vector: []float32{0, 1, 0}}))
+	assert.NoError(t, eng.(types.Batcher).BatchPut(ctx, []*types.Record{
+		{ID: "doc:a", Data: map[string]interface{}{"n": 1}, Vector: []float32{1, 0, 0}},
+		{ID: "doc:c", Data: map[string]interface{}{"n": 3}, Vector: []float32{0, 0, 1}},
+		{ID: "other", Data: map[string]interface{}{"n": 4}},
+	}))
+	assert.ErrorIs(t, eng.Put(ctx, "doc:d", &types.Record{ID: "doc:d", Vector: []float32{1, 0}}), types.ErrInvalidVector)
+
+	// Scan sees each key once, in order, whether or not its disk copy has
+	// been written yet
+	scan := func() []string {
+		var keys []string
+		assert.NoError(t, eng.Scan(ctx, "doc:", func(rec *types.Record) bool {
+			keys = append(keys, rec.ID)
+			return true
+		}))
+		return keys
+	}
+	assert.Equal(t, []string{"doc:a", "doc:b", "doc:c"}, scan())
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, []string{"doc:a", "doc:b", "doc:c"}, scan())
+
+	found, err := eng.(types.Searcher).Search(ctx, []float32{0.1, 0.9, 0}, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, "doc:b", found[0].ID)
+	}
+
+	// A put without a vector drops the old one from the index
+	assert.NoError(t, eng.Put(ctx, "doc:b", &types.Record{ID: "doc:b", Data: map[string]interface{}{"n": 2}}))
+	found, err = eng.(types.Searcher).Search(ctx, []float32{0.1, 0.9, 0}, 3)
+	assert.NoError(t, err)
+	for _, rec := range found {
+		assert.NotEqual(t, "doc:b", rec.ID)
+	}
+
+	assert.NoError(t, eng.Delete(ctx, "doc:a"))
+	assert.Equal(t, []string{"doc:b", "doc:c"}, scan())
+}
+
+// After a restart every tier serves what the WAL recovered: reads come from
+// disk, and vectors and columns are indexed again.
+func TestHybridEngineReopen(t *testing.T) {
+	cfg := config.HybridConfig()
+	cfg.DataDir, cfg.VectorDim = t.TempDir(), 3
+	ctx := context.Background()
+
+	eng, err := kvi.Open(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, eng.Put(ctx, "doc:a", &types.Record{ID: "doc:a", Data: map[string]interface{}{"n": 1}, Vector: []float32{1, 0, 0}}))
+	assert.NoError(t, eng.Put(ctx, "doc:b", &types.Record{ID: "doc:b", Data: map[string]interface{}{"n": 2}, Vector: []float32{0, 1, 0}}))
+	assert.NoError(t, eng.Put(ctx, "doc:b", &types.Record{ID: "doc:b", Data: map[string]interface{}{"n": 5}, Vector: []float32{0, 1, 0}}))
+	assert.NoError(t, eng.Delete(ctx, "doc:a"))
+	assert.NoError(t, eng.Close())
+
+	eng, err = kvi.Open(cfg)
+	assert.NoError(t, err)
+	defer eng.Close()
+	_, err = eng.Get(ctx, "doc:a")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+	got, err := eng.Get(ctx, "doc:b")
+	if assert.NoError(t, err) {
+		assert.Equal(t, 5.0, got.Data["n"])
+		assert.Equal(t, uint64(2), got.Version)
+	}
+
+	found, err := eng.(types.Searcher).Search(ctx, []float32{0, 1, 0}, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, "doc:b", found[0].ID)
+	}
+	assert.NoError(t, eng.Put(ctx, "doc:b", &types.Record{ID: "doc:b", Data: map[string]interface{}{"n": 6}, Vector: []float32{0, 1, 0}}))
+	got, err = eng.Get(ctx, "doc:b")
+	if assert.NoError(t, err) {
+		assert.Equal(t, uint64(3), got.Version, "versions carry on")
+	}
+}
+
+func TestHybridCacheEviction(t *testing.T) {
+	cfg := config.HybridConfig()
+	cfg.DataDir, cfg.CacheSizeMB = t.TempDir(), 1
+
+	eng, err := kvi.Open(cfg)
+	assert.NoError(t, err)
+	defer eng.Close()
+	ctx := context.Background()
+	stats := func() types.EngineStats { return eng.(types.StatsReporter).Stats() }
+
+	// 300 records of 10 KB are three times the cache
+	payload := strings.Repeat("x", 10<<10)
+	assert.NoError(t, eng.(types.Pinner).Pin(ctx, "key:000"))
+	for i := range 300 {
+		key := fmt.Sprintf("key:%03d", i)
+		assert.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, Data: map[string]interface{}{"v": payload}}))
+	}
+	assert.Eventually(t, func() bool { return *stats().AsyncQueue == 0 }, 2*time.Second, 5*time.Millisecond)
+
+	// Reading everything back goes to disk for what was evicted, and
+	// leaves the cache within its size
+	for i := range 300 {
+		rec, err := eng.Get(ctx, fmt.Sprintf("key:%03d", i))
+		assert.NoError(t, err)
+		assert.Equal(t, payload, rec.Data["v"])
+	}
+	s := stats()
+	assert.Equal(t, 300, s.Records)
+	assert.LessOrEqual(t, s.Cache.SizeBytes, int64(1<<20))
+	assert.Less(t, s.Cache.Entries, 300)
+	assert.Positive(t, s.Cache.Evictions)
+	assert.Positive(t, s.Cache.DiskHits)
+	assert.Equal(t, 1, s.Cache.Pinned)
+	assert.Equal(t, []string{"key:000"}, eng.(types.Pinner).Pinned())
+
+	// The pinned key is still in memory after all that
+	hits := s.Cache.MemoryHits
+	_, err = eng.Get(ctx, "key:000")
+	assert.NoError(t, err)
+	assert.Equal(t, hits+1, stats().Cache.MemoryHits)
+
+	// Writing an evicted key continues its versions, and a miss is counted
+	_, err = eng.Get(ctx, "key:999")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+	assert.Equal(t, uint64(1), stats().Cache.Misses)
+	assert.NoError(t, eng.Put(ctx, "key:001", &types.Record{ID: "key:001", Data: map[string]interface{}{"v": "new"}}))
+	rec, err := eng.Get(ctx, "key:001")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), rec.Version)
+
+	// Scan sees every record, cached or not
+	n := 0
+	assert.NoError(t, eng.Scan(ctx, "key:", func(*types.Record) bool { n++; return true }))
+	assert.Equal(t, 300, n)
+}
+
+func TestPinsAPI(t *testing.T) {
+	cfg := config.HybridConfig()
+	cfg.DataDir = t.TempDir()
+	eng, err := kvi.Open(cfg)
+	assert.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	pins := func(method, query string) (int, []string) {
+		req, _ := http.NewRequest(method, ts.URL+"/api/v1/admin/pins"+query, nil)
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		var body struct{ Pinned []string }
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body.Pinned
+	}
+	code, pinned := pins(http.MethodPut, "?key=b")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"b"}, pinned)
+	pins(http.MethodPut, "?key=a")
+	_, pinned = pins(http.MethodGet, "")
+	assert.Equal(t, []string{"a", "b"}, pinned)
+	_, pinned = pins(http.MethodDelete, "?key=b")
+	assert.Equal(t, []string{"a"}, pinned)
+	code, _ = pins(http.MethodPut, "")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	mem, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer mem.Close()
+	memServer := httptest.NewServer(api.NewServer(mem).Handler())
+	defer memServer.Close()
+	resp, err := http.Get(memServer.URL + "/api/v1/admin/pins")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
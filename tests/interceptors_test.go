@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/stats"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// syncBuffer is a bytes.Buffer safe for the server's goroutines to log into.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) lines(t *testing.T) []map[string]interface{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []map[string]interface{}
+	sc := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for sc.Scan() {
+		var line map[string]interface{}
+		assert.NoError(t, json.Unmarshal(sc.Bytes(), &line))
+		out = append(out, line)
+	}
+	return out
+}
+
+func TestGrpcInterceptors(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	a := newTestAuth(t)
+	var logs syncBuffer
+	calls := stats.NewCalls()
+	client := serveGrpc(t, kvi_grpc.NewGrpcServer(eng, pubsub.NewHub(), kvi_grpc.WithCalls(calls)),
+		kvi_grpc.Interceptors(kvi_grpc.Middleware{
+			Auth:   a,
+			Logger: slog.New(slog.NewJSONHandler(&logs, nil)),
+			Calls:  calls,
+		})...)
+
+	token, _, err := a.Login(context.Background(), "writer-key")
+	assert.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+
+	_, err = client.Put(context.Background(), &kvi_grpc.PutRequest{Key: "k", DataJson: "{}"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.Put(ctx, &kvi_grpc.PutRequest{Key: "k", DataJson: "{}"})
+	assert.NoError(t, err)
+	_, err = client.Get(ctx, &kvi_grpc.GetRequest{Key: "missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// One line per call, rejected ones included
+	lines := logs.lines(t)
+	assert.Len(t, lines, 3)
+	assert.Equal(t, "grpc call", lines[0]["msg"])
+	assert.Equal(t, kvi_grpc.KviService_Put_FullMethodName, lines[0]["method"])
+	assert.Equal(t, "Unauthenticated", lines[0]["code"])
+	assert.Nil(t, lines[0]["subject"])
+	assert.Equal(t, "OK", lines[1]["code"])
+	assert.NotEmpty(t, lines[1]["subject"])
+	assert.Contains(t, lines[1], "duration_ms")
+	assert.Equal(t, "NotFound", lines[2]["code"])
+	assert.Equal(t, "INFO", lines[2]["level"]) // a miss is not a server error
+
+	// The counters show up in the Stats report
+	resp, err := client.Stats(ctx, &kvi_grpc.StatsRequest{})
+	assert.NoError(t, err)
+	var report stats.Report
+	assert.NoError(t, json.Unmarshal([]byte(resp.ReportJson), &report))
+	put := report.GRPC[kvi_grpc.KviService_Put_FullMethodName]
+	assert.Equal(t, uint64(2), put.Count)
+	assert.Equal(t, map[string]uint64{"OK": 1, "Unauthenticated": 1}, put.Codes)
+	assert.Len(t, put.LatencyMs.Buckets, len(stats.LatencyBoundsMs)+1)
+	assert.Equal(t, uint64(1), report.GRPC[kvi_grpc.KviService_Get_FullMethodName].Codes["NotFound"])
+}
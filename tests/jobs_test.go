@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func startJob(t *testing.T, url string) (api.Job, int) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", nil)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	var job api.Job
+	if resp.StatusCode == http.StatusAccepted {
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+		assert.Equal(t, "/api/v1/admin/jobs/"+job.ID, resp.Header.Get("Location"))
+	}
+	return job, resp.StatusCode
+}
+
+func waitJob(t *testing.T, base, id string) api.Job {
+	t.Helper()
+	var job api.Job
+	assert.Eventually(t, func() bool {
+		getJSON(t, base+"/api/v1/admin/jobs/"+id, &job)
+		return job.Status != "running"
+	}, 5*time.Second, 10*time.Millisecond)
+	return job
+}
+
+func TestMaintenanceJobs(t *testing.T) {
+	cfg := config.DefaultConfig() // hybrid: every engine operation
+	cfg.DataDir = t.TempDir()
+	eng, err := kvi.Open(cfg)
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	snapshots := filepath.Join(cfg.DataDir, "snapshots")
+	ts := httptest.NewServer(api.NewServer(eng, api.WithSnapshotDir(snapshots)).Handler())
+	defer ts.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 300; i++ { // a long history for one key
+		assert.NoError(t, eng.Put(ctx, "counter", &types.Record{ID: "counter", Data: map[string]interface{}{"n": i}}))
+	}
+	past := time.Now().Add(-time.Minute)
+	assert.NoError(t, eng.Put(ctx, "stale", &types.Record{ID: "stale", Data: map[string]interface{}{}, TTL: &past}))
+	walPath := filepath.Join(cfg.DataDir, "kvi.wal")
+
+	for _, op := range []string{"flush", "checkpoint", "gc", "reindex-vectors"} {
+		job, code := startJob(t, ts.URL+"/api/v1/admin/"+op)
+		assert.Equal(t, http.StatusAccepted, code, op)
+		assert.Equal(t, op, job.Op)
+		job = waitJob(t, ts.URL, job.ID)
+		assert.Equal(t, "succeeded", job.Status, op)
+		assert.Empty(t, job.Error, op)
+		assert.NotNil(t, job.FinishedAt, op)
+	}
+
+	// gc removed the expired key, so its versions start over
+	assert.NoError(t, eng.Put(ctx, "stale", &types.Record{ID: "stale", Data: map[string]interface{}{}}))
+	rec, _ := eng.Get(ctx, "stale")
+	assert.Equal(t, uint64(1), rec.Version)
+
+	before, err := os.Stat(walPath)
+	assert.NoError(t, err)
+	job, _ := startJob(t, ts.URL+"/api/v1/admin/compact")
+	assert.Equal(t, "succeeded", waitJob(t, ts.URL, job.ID).Status)
+	after, err := os.Stat(walPath)
+	assert.NoError(t, err)
+	assert.Less(t, after.Size(), before.Size()/10)
+
+	job, _ = startJob(t, ts.URL+"/api/v1/admin/snapshot")
+	job = waitJob(t, ts.URL, job.ID)
+	assert.Equal(t, "succeeded", job.Status)
+	result := job.Result.(map[string]interface{})
+	assert.EqualValues(t, 2, result["records"])
+	f, err := os.Open(result["path"].(string))
+	assert.NoError(t, err)
+	defer f.Close()
+	sum, _, err := backup.Checksum(f)
+	assert.NoError(t, err)
+	assert.Equal(t, result["checksum"], sum)
+	assert.Equal(t, 2, job.Done)
+
+	resp, err := http.Get(ts.URL + "/api/v1/admin/jobs/nope")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestMaintenanceJobConflicts(t *testing.T) {
+	_, ts := memoryServer(t)
+
+	// The memory engine has no WAL, and no snapshot directory was configured
+	_, code := startJob(t, ts.URL+"/api/v1/admin/compact")
+	assert.Equal(t, http.StatusNotImplemented, code)
+	_, code = startJob(t, ts.URL+"/api/v1/admin/snapshot")
+	assert.Equal(t, http.StatusNotImplemented, code)
+
+	// A restore in progress holds off maintenance. It holds the lock once
+	// its spool file exists.
+	spoolDir := t.TempDir()
+	t.Setenv("TMPDIR", spoolDir)
+	pr, pw := io.Pipe()
+	done := make(chan int)
+	go func() {
+		done <- restore(t, ts.URL+"/api/v1/restore", pr, "").StatusCode
+	}()
+	_, err := pw.Write([]byte("partial upload"))
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		spools, _ := filepath.Glob(filepath.Join(spoolDir, "kvi-restore-*"))
+		return len(spools) == 1
+	}, 2*time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		_, code := startJob(t, ts.URL+"/api/v1/admin/gc")
+		return code == http.StatusConflict
+	}, 2*time.Second, 10*time.Millisecond)
+
+	pw.Close()
+	assert.Equal(t, http.StatusBadRequest, <-done)
+	job, code := startJob(t, ts.URL+"/api/v1/admin/gc")
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, "succeeded", waitJob(t, ts.URL, job.ID).Status)
+}
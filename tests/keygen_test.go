@@ -0,0 +1,211 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/sql"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/client"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/keygen"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// scanKeys lists the keys under prefix in scan order.
+func scanKeys(t *testing.T, eng types.Engine, prefix string) []string {
+	t.Helper()
+	keys := []string{}
+	require.NoError(t, eng.Scan(context.Background(), prefix, func(rec *types.Record) bool {
+		keys = append(keys, rec.ID)
+		return true
+	}))
+	return keys
+}
+
+func TestKeyGenerators(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.OpenMemory()
+	require.NoError(t, err)
+	defer eng.Close()
+
+	shapes := map[string]*regexp.Regexp{
+		keygen.NameULID:     regexp.MustCompile(`^ev:[0-9A-HJKMNP-TV-Z]{26}$`),
+		keygen.NameUUIDv7:   regexp.MustCompile(`^ev:[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`),
+		keygen.NameSequence: regexp.MustCompile(`^ev:\d{20}$`),
+	}
+	for name, shape := range shapes {
+		t.Run(name, func(t *testing.T) {
+			g, err := keygen.New(name, eng)
+			require.NoError(t, err)
+
+			// Many in the same millisecond still sort in the order made
+			keys := make([]string, 1000)
+			for i := range keys {
+				keys[i], err = g.Key(ctx, "ev:")
+				require.NoError(t, err)
+				assert.Regexp(t, shape, keys[i])
+			}
+			assert.True(t, slices.IsSorted(keys))
+			assert.Len(t, slices.Compact(slices.Clone(keys)), len(keys))
+
+			// And no two goroutines get the same one
+			var mu sync.Mutex
+			seen := map[string]bool{}
+			var wg sync.WaitGroup
+			for range 8 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range 100 {
+						key, err := g.Key(ctx, "ev:")
+						assert.NoError(t, err)
+						mu.Lock()
+						assert.False(t, seen[key], key)
+						seen[key] = true
+						mu.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+
+	_, err = keygen.New("snowflake", eng)
+	assert.ErrorContains(t, err, `unknown key generator "snowflake"`)
+	cfg := config.MemoryConfig()
+	cfg.KeyGenerator = "snowflake"
+	assert.ErrorContains(t, cfg.Validate(), `key_generator: unknown key generator "snowflake"`)
+}
+
+func TestSequenceKeys(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.DiskConfig()
+	cfg.DataDir = t.TempDir()
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+
+	g := keygen.Sequence{Engine: eng}
+	key := func(prefix string) string {
+		t.Helper()
+		key, err := g.Key(ctx, prefix)
+		require.NoError(t, err)
+		return key
+	}
+	assert.Equal(t, "a:00000000000000000001", key("a:"))
+	assert.Equal(t, "a:00000000000000000002", key("a:"))
+	assert.Equal(t, "b:00000000000000000001", key("b:")) // each prefix counts alone
+
+	// The counters outlive a restart
+	require.NoError(t, eng.Close())
+	eng, err = kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	g.Engine = eng
+	assert.Equal(t, "a:00000000000000000003", key("a:"))
+}
+
+func TestAutoKeys(t *testing.T) {
+	ctx := context.Background()
+	eng, ts := memoryServer(t)
+	put := func(body string) (int, map[string]interface{}) {
+		t.Helper()
+		code, resp := postBody(t, ts.URL+"/api/v1/put", strings.NewReader(body))
+		var out map[string]interface{}
+		json.Unmarshal([]byte(resp), &out)
+		return code, out
+	}
+
+	// An empty or auto key is made up, and returned; those under a prefix
+	// scan in the order they were put
+	var made []string
+	for i := range 20 {
+		body := fmt.Sprintf(`{"key_prefix":"ev:","data":{"n":%d}}`, i)
+		if i%2 == 1 {
+			body = fmt.Sprintf(`{"key":"auto","key_prefix":"ev:","data":{"n":%d}}`, i)
+		}
+		code, out := put(body)
+		require.Equal(t, http.StatusCreated, code)
+		made = append(made, out["key"].(string))
+		assert.EqualValues(t, 1, out["version"])
+	}
+	assert.Equal(t, made, scanKeys(t, eng, "ev:"))
+	rec, err := eng.Get(ctx, made[3])
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, rec.Data["n"])
+
+	code, out := put(`{"data":{}}`)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Len(t, out["key"], 26)
+	code, out = put(`{"key":"fixed","key_prefix":"ev:","data":{}}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "key_prefix needs an empty or auto key", out["error"])
+
+	// Batches make up keys too
+	code, body := postBody(t, ts.URL+"/api/v1/batch", strings.NewReader(`{"records":[{"key_prefix":"b:"},{"key":"b:x"}]}`))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Regexp(t, `"key":"b:[0-9A-Z]{26}".*"key":"b:x"`, body)
+
+	// So does INSERT for rows without an id
+	xe := sql.NewExecutor(eng, sql.WithKeyGenerator(keygen.Sequence{Engine: eng}))
+	res, err := xe.ExecuteQuery(ctx, "INSERT INTO logs (msg) VALUES ('one'), ('two')")
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]string{
+		{"status": "ok", "inserted_id": "00000000000000000001"},
+		{"status": "ok", "inserted_id": "00000000000000000002"},
+	}, res)
+	res, err = xe.ExecuteQuery(ctx, "INSERT INTO logs (id, msg) VALUES ('auto', 'three')")
+	require.NoError(t, err)
+	assert.Equal(t, "00000000000000000003", res.(map[string]string)["inserted_id"])
+	rec, err = eng.Get(ctx, "00000000000000000002")
+	require.NoError(t, err)
+	assert.Equal(t, "two", rec.Data["msg"])
+
+	// The client learns the key over REST and gRPC alike
+	c, err := client.New(client.WithHTTP(ts.URL))
+	require.NoError(t, err)
+	defer c.Close()
+	rec = &types.Record{Data: map[string]interface{}{"via": "rest"}}
+	require.NoError(t, c.Put(ctx, "", rec))
+	assert.Len(t, rec.ID, 26)
+	_, err = eng.Get(ctx, rec.ID)
+	assert.NoError(t, err)
+
+	stub := startGrpc(t, eng, nil)
+	resp, err := stub.Put(ctx, &kvi_grpc.PutRequest{KeyPrefix: "g:", DataJson: `{}`})
+	require.NoError(t, err)
+	assert.Regexp(t, `^g:[0-9A-Z]{26}$`, resp.Key)
+	assert.EqualValues(t, 1, resp.Version)
+	resp, err = stub.Put(ctx, &kvi_grpc.PutRequest{Key: "g:fixed", DataJson: `{}`})
+	require.NoError(t, err)
+	assert.Equal(t, "g:fixed", resp.Key)
+	_, err = stub.Put(ctx, &kvi_grpc.PutRequest{Key: "g:fixed", KeyPrefix: "g:", DataJson: `{}`})
+	assert.ErrorContains(t, err, "key_prefix needs an empty or auto key")
+}
+
+func TestKeyGeneratorOption(t *testing.T) {
+	eng, err := kvi.OpenMemory()
+	require.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng, api.WithKeyGenerator(keygen.UUIDv7{})).Handler())
+	defer ts.Close()
+
+	code, body := postBody(t, ts.URL+"/api/v1/put", strings.NewReader(`{"key":"auto","data":{}}`))
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Regexp(t, `"key":"[0-9a-f]{8}-[0-9a-f]{4}-7`, body)
+	code, body = postBody(t, ts.URL+"/api/v1/query", strings.NewReader(`{"query":"INSERT INTO t (name) VALUES ('x')"}`))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Regexp(t, `"inserted_id":"[0-9a-f]{8}-[0-9a-f]{4}-7`, body)
+}
@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/keys"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// randomElem returns a random tuple element, favouring the values at the
+// edges of each type's order.
+func randomElem(r *rand.Rand) interface{} {
+	switch r.IntN(4) {
+	case 0:
+		return []int64{math.MinInt64, -1, 0, 1, math.MaxInt64, r.Int64() - math.MaxInt64/2, int64(r.IntN(20) - 10)}[r.IntN(7)]
+	case 1:
+		alphabet := []string{"", "\x00", "\x01", "\x02", "a", "b", "z", "é", "世", "\U0001F600", "\x7f"}
+		var b strings.Builder
+		for range r.IntN(4) {
+			b.WriteString(alphabet[r.IntN(len(alphabet))])
+		}
+		return b.String()
+	case 2:
+		return time.Unix(r.Int64N(1<<40)-1<<39, r.Int64N(int64(time.Second))).UTC()
+	default:
+		var id uuid.UUID
+		for i := range id {
+			id[i] = []byte{0, 0xff, byte(r.IntN(256))}[r.IntN(3)]
+		}
+		return id
+	}
+}
+
+// compareElems orders elements as the encoding promises: by type, then
+// by value.
+func compareElems(a, b interface{}) int {
+	rank := func(v interface{}) int {
+		switch v.(type) {
+		case int64:
+			return 0
+		case string:
+			return 1
+		case time.Time:
+			return 2
+		default:
+			return 3
+		}
+	}
+	if c := cmp.Compare(rank(a), rank(b)); c != 0 {
+		return c
+	}
+	switch a := a.(type) {
+	case int64:
+		return cmp.Compare(a, b.(int64))
+	case string:
+		return strings.Compare(a, b.(string))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	default:
+		ua, ub := a.(uuid.UUID), b.(uuid.UUID)
+		return slices.Compare(ua[:], ub[:])
+	}
+}
+
+func TestTupleKeyOrder(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	tuples := make([][]interface{}, 2000)
+	encoded := make([]string, len(tuples))
+	for i := range tuples {
+		for range r.IntN(4) {
+			tuples[i] = append(tuples[i], randomElem(r))
+		}
+		if i > 0 && r.IntN(4) == 0 { // share a prefix with another tuple
+			prev := tuples[r.IntN(i)]
+			tuples[i] = append(slices.Clone(prev[:r.IntN(len(prev)+1)]), tuples[i]...)
+		}
+		key, err := keys.Encode(tuples[i])
+		require.NoError(t, err)
+		encoded[i] = key
+	}
+
+	for i := range tuples {
+		got, err := keys.Decode(encoded[i])
+		require.NoError(t, err)
+		if tuples[i] == nil {
+			assert.Empty(t, got)
+		} else {
+			assert.Equal(t, tuples[i], got, "round trip of %q", encoded[i])
+		}
+		j := r.IntN(len(tuples))
+		want := slices.CompareFunc(tuples[i], tuples[j], compareElems)
+		assert.Equal(t, want, strings.Compare(encoded[i], encoded[j]), "%v vs %v", tuples[i], tuples[j])
+	}
+
+	// Sorting the keys sorts the tuples
+	order := make([]int, len(tuples))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return encoded[order[a]] < encoded[order[b]] })
+	assert.True(t, slices.IsSortedFunc(order, func(a, b int) int {
+		return slices.CompareFunc(tuples[a], tuples[b], compareElems)
+	}))
+}
+
+// TestTupleKeyFormat pins the byte format, which must not change.
+func TestTupleKeyFormat(t *testing.T) {
+	id := uuid.MustParse("123e4567-e89b-12d3-a456-426614174000")
+	at := time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC)
+	assert.Equal(t, "sacme\x00i7fffffffffffffffi8000000000000002", keys.MustEncode("acme", -1, 2))
+	assert.Equal(t, "sa\x01\x01b\x01\x02\x00", keys.MustEncode("a\x00b\x01"))
+	assert.Equal(t, "t80000000663881d90000000a", keys.MustEncode(at))
+	assert.Equal(t, "u123e4567e89b12d3a456426614174000", keys.MustEncode(id))
+
+	_, err := keys.Encode([]interface{}{3.5})
+	assert.Error(t, err)
+	_, err = keys.Encode([]interface{}{"\xff"})
+	assert.Error(t, err)
+	_, err = keys.Encode([]interface{}{uint64(math.MaxUint64)})
+	assert.Error(t, err)
+	for _, bad := range []string{"x", "sabc", "s\x01\x03\x00", "i123", "iFFFFFFFFFFFFFFFF", "t800000006638", "u12"} {
+		_, err := keys.Decode(bad)
+		assert.ErrorIs(t, err, keys.ErrMalformed, "%q", bad)
+	}
+}
+
+func TestTupleRecords(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.OpenDisk(t.TempDir())
+	require.NoError(t, err)
+	defer eng.Close()
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tenant := range []string{"acme", "acme2", "beta"} {
+		for _, minutes := range []int{30, -5, 0, 1000, 7} {
+			at := base.Add(time.Duration(minutes) * time.Minute)
+			rec := &types.Record{Data: map[string]interface{}{"tenant": tenant, "minutes": minutes}}
+			require.NoError(t, kvi.PutTuple(ctx, eng, []interface{}{tenant, at, "evt"}, rec))
+		}
+	}
+
+	rec, err := kvi.GetTuple(ctx, eng, []interface{}{"acme", base.Add(-5 * time.Minute), "evt"})
+	require.NoError(t, err)
+	assert.EqualValues(t, -5, toFloat(rec.Data["minutes"]))
+
+	// The acme prefix leaves out acme2, and the events come in time order
+	var minutes []int
+	require.NoError(t, kvi.ScanTuplePrefix(ctx, eng, []interface{}{"acme"}, func(rec *types.Record) bool {
+		tuple, err := keys.Decode(rec.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "acme", tuple[0])
+		minutes = append(minutes, int(tuple[1].(time.Time).Sub(base)/time.Minute))
+		return true
+	}))
+	assert.Equal(t, []int{-5, 0, 7, 30, 1000}, minutes)
+}
@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+)
+
+func TestServerShutdownEndsStreams(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	srv := api.NewServer(eng)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	base := "http://" + l.Addr().String()
+	resp, err := http.Get(base + "/api/v1/sub?channel=c&id=s1")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "retry:"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	assert.NoError(t, srv.Shutdown(ctx))
+	assert.Less(t, time.Since(start), time.Second) // the SSE stream did not hold shutdown up
+	assert.ErrorIs(t, <-served, http.ErrServerClosed)
+
+	_, err = http.Get(base + "/health")
+	assert.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+)
+
+func postBody(t *testing.T, url string, body io.Reader) (int, string) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", body)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(msg)
+}
+
+// chunked hides the length so the limit is hit while decoding.
+type chunked struct{ io.Reader }
+
+func TestRequestBodyLimits(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng, api.WithBodyLimits(256, 512)).Handler())
+	defer ts.Close()
+
+	big := `{"key":"k","data":{"blob":"` + strings.Repeat("x", 300) + `"}}`
+	code, msg := postBody(t, ts.URL+"/api/v1/put", strings.NewReader(big))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Contains(t, msg, "256 byte limit")
+
+	code, _ = postBody(t, ts.URL+"/api/v1/put", chunked{strings.NewReader(big)})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+
+	code, _ = postBody(t, ts.URL+"/api/v1/put", strings.NewReader(`{"key":"k","data":{}}`))
+	assert.Equal(t, http.StatusCreated, code)
+
+	// Restores get the separate, larger limit
+	code, _ = postBody(t, ts.URL+"/api/v1/restore", chunked{bytes.NewReader(make([]byte, 400))})
+	assert.Equal(t, http.StatusBadRequest, code) // within the limit, just not a backup
+	code, msg = postBody(t, ts.URL+"/api/v1/restore", chunked{bytes.NewReader(make([]byte, 600))})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Contains(t, msg, "512 byte limit")
+}
+
+func TestStrictJSONDecoding(t *testing.T) {
+	_, ts := memoryServer(t)
+
+	code, msg := postBody(t, ts.URL+"/api/v1/put", strings.NewReader(`{"key":"k","dat":{"v":1}}`))
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, msg, `unknown field "dat"`)
+
+	code, _ = postBody(t, ts.URL+"/api/v1/pub", strings.NewReader(`{"channel":"c","message":"m"}{"channel":"c"}`))
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = postBody(t, ts.URL+"/api/v1/pub", strings.NewReader(`{"channel":"c","message":"m"}`+"\n"))
+	assert.Equal(t, http.StatusOK, code)
+}
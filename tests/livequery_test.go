@@ -0,0 +1,220 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/sql"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func nextLive(t *testing.T, results <-chan sql.LiveResult) sql.LiveResult {
+	t.Helper()
+	select {
+	case res, ok := <-results:
+		require.True(t, ok, "live query ended")
+		require.NoError(t, res.Err)
+		return res
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a live result")
+	}
+	return sql.LiveResult{}
+}
+
+func noLive(t *testing.T, results <-chan sql.LiveResult) {
+	t.Helper()
+	select {
+	case res := <-results:
+		t.Fatalf("unexpected live result %d", res.Seq)
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+func liveRecords(t *testing.T, res sql.LiveResult) []*types.Record {
+	t.Helper()
+	switch v := res.Result.Value.(type) {
+	case *types.Record:
+		return []*types.Record{v}
+	case []*types.Record:
+		return v
+	}
+	t.Fatalf("unexpected result %T", res.Result.Value)
+	return nil
+}
+
+func TestLiveQuery(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.MemoryConfig()
+	cfg.TextIndex.Fields = []string{"status"}
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	live, err := sql.NewLiveQueries(eng, 20*time.Millisecond, 2)
+	require.NoError(t, err)
+	order := func(id, status string) {
+		require.NoError(t, eng.Put(ctx, id, &types.Record{ID: id, Data: map[string]interface{}{"status": status}}))
+	}
+
+	// The first result is there at once; a missing key reads as no rows
+	qctx, cancel := context.WithCancel(ctx)
+	byID, err := live.Subscribe(qctx, "a", "SELECT id, status FROM orders WHERE id = 'o1'")
+	require.NoError(t, err)
+	res := nextLive(t, byID)
+	assert.EqualValues(t, 1, res.Seq)
+	assert.Empty(t, liveRecords(t, res))
+	assert.Equal(t, []string{"id", "status"}, res.Result.Columns)
+
+	// Writes to its key are pushed, a burst as one result; others are not
+	order("o1", "pending")
+	res = nextLive(t, byID)
+	assert.EqualValues(t, 2, res.Seq)
+	assert.Equal(t, "pending", liveRecords(t, res)[0].Data["status"])
+	order("o2", "pending")
+	noLive(t, byID)
+	for _, status := range []string{"paid", "packed", "shipped"} {
+		order("o1", status)
+	}
+	res = nextLive(t, byID)
+	assert.EqualValues(t, 3, res.Seq)
+	assert.Equal(t, "shipped", liveRecords(t, res)[0].Data["status"])
+	noLive(t, byID)
+	require.NoError(t, eng.Delete(ctx, "o1"))
+	res = nextLive(t, byID)
+	assert.EqualValues(t, 4, res.Seq)
+	assert.Empty(t, liveRecords(t, res))
+
+	// A MATCH reads every key, and is pushed only when its answer changes
+	matches, err := live.Subscribe(ctx, "a", "SELECT * FROM orders WHERE status MATCH 'pending'")
+	require.NoError(t, err)
+	assert.Len(t, liveRecords(t, nextLive(t, matches)), 1)
+	order("o3", "pending")
+	assert.Len(t, liveRecords(t, nextLive(t, matches)), 2)
+
+	// A client holds as many as it may; others are not held back
+	_, err = live.Subscribe(ctx, "a", "SELECT * FROM orders WHERE id = 'o2'")
+	assert.ErrorIs(t, err, sql.ErrTooManyLiveQueries)
+	_, err = live.Subscribe(ctx, "b", "SELECT * FROM orders WHERE id = 'o2'")
+	assert.NoError(t, err)
+	for _, query := range []string{"DELETE FROM orders WHERE id = 'o2'", "SELECT * FROM orders", "SELEC"} {
+		_, err = live.Subscribe(ctx, "c", query)
+		assert.Error(t, err, query)
+	}
+
+	st := live.Stats()
+	assert.Equal(t, 3, st.Active)
+	assert.Equal(t, 2, st.Clients)
+	assert.EqualValues(t, 1, st.Rejected)
+	assert.EqualValues(t, 4+2+1, st.Pushes)
+
+	// Ending one frees its client's slot
+	cancel()
+	for range byID {
+	}
+	require.Eventually(t, func() bool { return live.Stats().Active == 2 }, time.Second, 5*time.Millisecond)
+	_, err = live.Subscribe(ctx, "a", "SELECT * FROM orders WHERE id = 'o2'")
+	assert.NoError(t, err)
+}
+
+func TestLiveQueryAPI(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	live, err := sql.NewLiveQueries(eng, 0, 1)
+	require.NoError(t, err)
+	ts := httptest.NewServer(api.NewServer(eng, api.WithLiveQueries(live)).Handler())
+	defer ts.Close()
+	url := ts.URL + "/api/v1/query/live?q=" + "SELECT+*+FROM+t+WHERE+id+%3D+%27k%27"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	events := sseStream(resp)
+	var got struct {
+		Seq   uint64
+		Count int
+		Items []struct{ Data map[string]interface{} }
+	}
+	ev := nextEvent(t, events)
+	assert.Equal(t, "1", ev.id)
+	require.NoError(t, json.Unmarshal([]byte(ev.data), &got))
+	assert.Zero(t, got.Count)
+
+	code, _ := postBody(t, ts.URL+"/api/v1/put", jsonBody(map[string]interface{}{"key": "k", "data": map[string]string{"n": "one"}}))
+	require.Equal(t, http.StatusCreated, code)
+	ev = nextEvent(t, events)
+	assert.Equal(t, "2", ev.id)
+	require.NoError(t, json.Unmarshal([]byte(ev.data), &got))
+	assert.EqualValues(t, 2, got.Seq)
+	require.Equal(t, 1, got.Count)
+	assert.Equal(t, "one", got.Items[0].Data["n"])
+
+	// The client's one live query is taken
+	resp2, err := http.Get(url)
+	require.NoError(t, err)
+	resp2.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp2.StatusCode)
+	var report struct {
+		LiveQueries *stats.LiveQueryStats `json:"live_queries"`
+	}
+	getJSON(t, ts.URL+"/api/v1/stats", &report)
+	require.NotNil(t, report.LiveQueries)
+	assert.Equal(t, 1, report.LiveQueries.Active)
+	assert.EqualValues(t, 1, report.LiveQueries.Rejected)
+
+	cancel()
+	require.Eventually(t, func() bool { return live.Stats().Active == 0 }, time.Second, 5*time.Millisecond)
+	resp2, err = http.Get(ts.URL + "/api/v1/query/live?q=DELETE+FROM+t+WHERE+id+%3D+%27k%27")
+	require.NoError(t, err)
+	resp2.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp2.StatusCode)
+}
+
+func TestLiveQueryGrpc(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	live, err := sql.NewLiveQueries(eng, 0, 1)
+	require.NoError(t, err)
+	client := serveGrpc(t, kvi_grpc.NewGrpcServer(eng, nil, kvi_grpc.WithLiveQueries(live)))
+
+	stream, err := client.LiveQuery(ctx, &kvi_grpc.LiveQueryRequest{Query: "SELECT * FROM t WHERE id = 'k'"})
+	require.NoError(t, err)
+	msg, err := stream.Recv()
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, msg.Seq)
+	assert.Empty(t, msg.Records)
+
+	require.NoError(t, eng.Put(ctx, "k", &types.Record{ID: "k", Data: map[string]interface{}{"n": "one"}}))
+	msg, err = stream.Recv()
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, msg.Seq)
+	require.Len(t, msg.Records, 1)
+	assert.Equal(t, "k", msg.Records[0].Id)
+
+	over, err := client.LiveQuery(ctx, &kvi_grpc.LiveQueryRequest{Query: "SELECT * FROM t WHERE id = 'j'"})
+	require.NoError(t, err)
+	_, err = over.Recv()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	bad, err := client.LiveQuery(ctx, &kvi_grpc.LiveQueryRequest{Query: "SELECT * FROM t"})
+	require.NoError(t, err)
+	_, err = bad.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
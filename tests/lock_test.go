@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/lock"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLock(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []types.Mode{types.ModeMemory, types.ModeDisk, types.ModeColumnar, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Mode, cfg.DataDir = mode, t.TempDir()
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+
+			// Of many concurrent Locks exactly one succeeds
+			const workers = 16
+			tokens := make(chan uint64, workers)
+			var wg sync.WaitGroup
+			for i := range workers {
+				wg.Go(func() {
+					token, err := lock.Lock(ctx, eng, "job:1", fmt.Sprintf("worker-%d", i), time.Minute)
+					if err == nil {
+						tokens <- token
+					} else {
+						assert.ErrorIs(t, err, lock.ErrHeld)
+					}
+				})
+			}
+			wg.Wait()
+			close(tokens)
+			require.Len(t, tokens, 1)
+			first := <-tokens
+
+			_, err = lock.Lock(ctx, eng, "job:1", "late", time.Minute)
+			assert.ErrorIs(t, err, lock.ErrHeld)
+			assert.ErrorContains(t, err, `job:1 is locked by "worker-`)
+			assert.ErrorIs(t, lock.Unlock(ctx, eng, "job:1", first+100), lock.ErrNotHeld)
+
+			// Released, it is taken again with a higher token
+			require.NoError(t, lock.Unlock(ctx, eng, "job:1", first))
+			assert.ErrorIs(t, lock.Unlock(ctx, eng, "job:1", first), lock.ErrNotHeld)
+			second, err := lock.Lock(ctx, eng, "job:1", "again", 50*time.Millisecond)
+			require.NoError(t, err)
+			assert.Greater(t, second, first)
+
+			// Expired, it is free for the next owner, and the old one can no
+			// longer release it
+			time.Sleep(60 * time.Millisecond)
+			third, err := lock.Lock(ctx, eng, "job:1", "next", 50*time.Millisecond)
+			require.NoError(t, err)
+			assert.Greater(t, third, second)
+			assert.ErrorIs(t, lock.Unlock(ctx, eng, "job:1", second), lock.ErrNotHeld)
+
+			// Tokens keep growing after the expired lock is collected
+			time.Sleep(60 * time.Millisecond)
+			if m, ok := eng.(types.Maintainer); ok {
+				require.NoError(t, m.Maintenance()[types.MaintenanceGC](ctx, func(int, int) {}))
+			}
+			fourth, err := lock.Lock(ctx, eng, "job:1", "last", time.Minute)
+			require.NoError(t, err)
+			assert.Greater(t, fourth, third)
+
+			// Locks are per key
+			other, err := lock.Lock(ctx, eng, "job:2", "last", time.Minute)
+			require.NoError(t, err)
+			assert.EqualValues(t, 1, other)
+		})
+	}
+}
+
+func TestLockAPI(t *testing.T) {
+	eng, ts := memoryServer(t)
+	post := func(path, payload string) (int, string) {
+		resp, err := http.Post(ts.URL+path, "application/json", strings.NewReader(payload))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := post("/api/v1/lock", `{"key":"job","owner":"a","ttl_ms":60000}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"token":1}`, body)
+	code, body = post("/api/v1/lock", `{"key":"job","owner":"b","ttl_ms":60000}`)
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Contains(t, body, `lock held: job is locked by \"a\"`)
+	code, _ = post("/api/v1/lock", `{"key":"job","owner":"b"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = post("/api/v1/unlock", `{"key":"job","token":2}`)
+	assert.Equal(t, http.StatusConflict, code)
+	code, _ = post("/api/v1/unlock", `{"key":"job","token":1}`)
+	assert.Equal(t, http.StatusOK, code)
+
+	// gRPC shares the locks
+	ctx := context.Background()
+	client := startGrpc(t, eng, nil)
+	got, err := client.Lock(ctx, &kvi_grpc.LockRequest{Key: "job", Owner: "c", TtlMs: 60000})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, got.Token)
+	_, err = client.Lock(ctx, &kvi_grpc.LockRequest{Key: "job", Owner: "d", TtlMs: 60000})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	code, _ = post("/api/v1/lock", `{"key":"job","owner":"b","ttl_ms":60000}`)
+	assert.Equal(t, http.StatusConflict, code)
+	_, err = client.Unlock(ctx, &kvi_grpc.UnlockRequest{Key: "job", Token: 2})
+	require.NoError(t, err)
+	_, err = client.Unlock(ctx, &kvi_grpc.UnlockRequest{Key: "job", Token: 2})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	_, err = client.Lock(ctx, &kvi_grpc.LockRequest{Key: "job", Owner: "d"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+)
+
+// logBuffer collects JSON log lines written by concurrent handlers.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) entries(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []map[string]interface{}
+	sc := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for sc.Scan() {
+		var e map[string]interface{}
+		assert.NoError(t, json.Unmarshal(sc.Bytes(), &e))
+		out = append(out, e)
+	}
+	return out
+}
+
+func loggedServer(t *testing.T, cfg api.AccessLog) (*httptest.Server, *logBuffer) {
+	t.Helper()
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	t.Cleanup(func() { eng.Close() })
+
+	logs := &logBuffer{}
+	cfg.Logger = slog.New(slog.NewJSONHandler(logs, nil))
+	ts := httptest.NewServer(api.NewServer(eng, api.WithAccessLog(cfg)).Handler())
+	t.Cleanup(ts.Close)
+	return ts, logs
+}
+
+func TestAccessLogRecordsRequests(t *testing.T) {
+	ts, logs := loggedServer(t, api.AccessLog{SampleFailedBodies: true})
+
+	resp := putKey(t, ts, "k1")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	generated := resp.Header.Get("X-Request-ID")
+	assert.NotEmpty(t, generated)
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/query", jsonBody(map[string]string{"query": "SELEC nope"}))
+	req.Header.Set("X-Request-ID", "client-trace-42")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, "client-trace-42", resp.Header.Get("X-Request-ID"))
+
+	entries := logs.entries(t)
+	assert.Len(t, entries, 2)
+
+	put := entries[0]
+	assert.Equal(t, "INFO", put["level"])
+	assert.Equal(t, generated, put["request_id"])
+	assert.Equal(t, "POST", put["method"])
+	assert.Equal(t, "/api/v1/put", put["path"])
+	assert.Equal(t, float64(http.StatusCreated), put["status"])
+	assert.Greater(t, put["bytes_in"], float64(0))
+	assert.Contains(t, put, "duration_ms")
+	assert.NotContains(t, put, "body")
+
+	failed := entries[1]
+	assert.Equal(t, "client-trace-42", failed["request_id"])
+	assert.Equal(t, float64(http.StatusBadRequest), failed["status"])
+	assert.Contains(t, failed["body"], "SELEC nope")
+}
+
+func TestAccessLogWarnsOnSlowQueries(t *testing.T) {
+	ts, logs := loggedServer(t, api.AccessLog{SlowThreshold: 1}) // every request is "slow"
+
+	resp, err := http.Post(ts.URL+"/api/v1/query", "application/json",
+		jsonBody(map[string]string{"query": "SELECT * FROM kv WHERE id = 'secret-key'"}))
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	entries := logs.entries(t)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "WARN", entries[0]["level"])
+	assert.Equal(t, true, entries[0]["slow"])
+	assert.Equal(t, "select * from kv where id = :v1", entries[0]["query"])
+}
+
+func TestConfigLogger(t *testing.T) {
+	var buf bytes.Buffer
+	cfg := config.DefaultConfig()
+	cfg.LogFormat = "text"
+	logger, err := cfg.NewLogger(&buf, nil)
+	assert.NoError(t, err)
+	logger.Debug("hidden")
+	logger.Info("shown", "n", 1)
+	assert.NotContains(t, buf.String(), "hidden")
+	assert.Contains(t, buf.String(), "level=INFO msg=shown n=1")
+
+	cfg.LogFormat = "yaml"
+	_, err = cfg.NewLogger(&buf, nil)
+	assert.ErrorContains(t, err, `unknown log format "yaml"`)
+	assert.ErrorContains(t, cfg.Validate(), "log_format")
+
+	// A logged config is grouped like the file and keeps its secrets
+	logs := &logBuffer{}
+	cfg = config.DefaultConfig()
+	cfg.JWTSecret = "a-long-secret-for-signing-tokens"
+	cfg.APIKeys = map[string]string{"sk-admin": "admin"}
+	slog.New(slog.NewJSONHandler(logs, nil)).Info("configuration loaded", "config", cfg)
+	entry := logs.entries(t)[0]["config"].(map[string]interface{})
+	assert.Equal(t, "hybrid", entry["mode"])
+	assert.Equal(t, "<redacted>", entry["jwt_secret"])
+	assert.Equal(t, "<redacted: 1 admin>", entry["api_keys"])
+	assert.Equal(t, []interface{}{"*"}, entry["cors"].(map[string]interface{})["allowed_origins"])
+	assert.NotContains(t, logs.buf.String(), "sk-admin")
+	assert.NotContains(t, logs.buf.String(), cfg.JWTSecret)
+}
+
+func TestServerLogsMaintenanceJobs(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	logs := &logBuffer{}
+	cfg.Logger = slog.New(slog.NewJSONHandler(logs, nil))
+	eng, err := kvi.Open(cfg)
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	ts := httptest.NewServer(api.NewServer(eng, api.WithLogger(cfg.Logger)).Handler())
+	defer ts.Close()
+	job, code := startJob(t, ts.URL+"/api/v1/admin/flush")
+	assert.Equal(t, http.StatusAccepted, code)
+	waitJob(t, ts.URL, job.ID)
+
+	var entry map[string]interface{}
+	assert.Eventually(t, func() bool {
+		for _, e := range logs.entries(t) {
+			if e["msg"] == "maintenance job succeeded" {
+				entry = e
+				return true
+			}
+		}
+		return false
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, job.ID, entry["job_id"])
+	assert.Equal(t, "flush", entry["op"])
+	assert.Equal(t, "INFO", entry["level"])
+}
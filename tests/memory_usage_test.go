@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// userRecord is a record of the shape the calibration loads: a few
+// fields, one of them nested, two tags and a vector.
+func userRecord(i int) *types.Record {
+	key := fmt.Sprintf("user:%06d", i)
+	return &types.Record{ID: key, Tags: []string{"a", "b"}, Vector: unitVector(16, i), Data: map[string]interface{}{
+		"name":    fmt.Sprintf("someone number %d", i),
+		"age":     float64(i % 90),
+		"address": map[string]interface{}{"city": "Bangkok", "zip": "10110"},
+	}}
+}
+
+// TestMemoryUsageCalibration checks the engines' estimate of their memory
+// against the heap they give back when closed after loading a known
+// dataset; measuring the load instead would count what earlier tests free
+// meanwhile. The columnar engine is left out: its column store keeps
+// copies of the values the estimate does not count.
+func TestMemoryUsageCalibration(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []types.Mode{types.ModeMemory, types.ModeDisk, types.ModeVector, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.VectorConfig(16)
+			cfg.Mode, cfg.DataDir, cfg.EnableWAL, cfg.GCIntervalMs = mode, t.TempDir(), false, 0
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+
+			const n = 20000
+			for i := range n {
+				rec := userRecord(i)
+				require.NoError(t, eng.Put(ctx, rec.ID, rec))
+			}
+			if wm, ok := eng.(types.Watermarker); ok {
+				require.NoError(t, wm.Await(ctx, types.ConsistencyReadYourWrites, 0))
+			}
+			stats := eng.(types.StatsReporter).Stats()
+			loaded := heapAlloc()
+			require.NoError(t, eng.Close())
+			eng = nil
+			heap := loaded - heapAlloc()
+			ratio := float64(stats.MemoryUsed) / float64(heap)
+			t.Logf("heap %d, memory_used %d, record_bytes %d: %.2f", heap, stats.MemoryUsed, stats.RecordBytes, ratio)
+			assert.InDelta(t, 1, ratio, 0.2)
+		})
+	}
+}
+
+func TestMemoryUsageAccounting(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	used := func() int64 { return eng.(types.StatsReporter).Stats().RecordBytes }
+	assert.Zero(t, used())
+
+	// Every write path moves the total: puts, overwrites, batches and deletes
+	require.NoError(t, eng.Put(ctx, "a", &types.Record{ID: "a", Data: map[string]interface{}{"v": "x"}}))
+	one := used()
+	assert.Positive(t, one)
+	require.NoError(t, eng.Put(ctx, "a", &types.Record{ID: "a", Data: map[string]interface{}{"v": string(make([]byte, 1000))}}))
+	assert.Greater(t, used(), one+900)
+	require.NoError(t, eng.(types.Batcher).BatchPut(ctx, []*types.Record{userRecord(1), userRecord(2)}))
+	three := used()
+	require.NoError(t, eng.Delete(ctx, "a"))
+	require.NoError(t, eng.Delete(ctx, "missing"))
+	assert.Less(t, used(), three-1000)
+
+	// A restore replacing everything leaves what it loaded
+	var buf bytes.Buffer
+	_, err = backup.Dump(ctx, eng, &buf)
+	require.NoError(t, err)
+	two := used()
+	extra := userRecord(3)
+	require.NoError(t, eng.Put(ctx, extra.ID, extra))
+	_, err = backup.Restore(ctx, eng, bytes.NewReader(buf.Bytes()), backup.Replace)
+	require.NoError(t, err)
+	assert.Equal(t, two, used())
+	_, err = eng.(types.Batcher).BatchDelete(ctx, []string{"user:000001", "user:000002"})
+	require.NoError(t, err)
+	assert.Zero(t, used())
+}
+
+func TestMaxMemoryEviction(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.DefaultConfig()
+	cfg.DataDir, cfg.VectorDim = t.TempDir(), 16
+	cfg.MaxMemoryMB, cfg.CacheSizeMB = 8, 0
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+
+	// Once the records alone take more than max_memory_mb, the memory
+	// tier gives its copies back
+	for i := range 8000 {
+		rec := userRecord(i)
+		require.NoError(t, eng.Put(ctx, rec.ID, rec))
+	}
+	require.NoError(t, eng.(types.Watermarker).Await(ctx, types.ConsistencyReadYourWrites, 0))
+	rec, err := eng.Get(ctx, "user:000042")
+	require.NoError(t, err)
+	assert.Equal(t, "someone number 42", rec.Data["name"])
+	stats := eng.(types.StatsReporter).Stats()
+	assert.Greater(t, stats.RecordBytes, int64(8<<20))
+	assert.Less(t, stats.Cache.Entries, 100)
+	assert.Positive(t, stats.Cache.Evictions)
+}
@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/client"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func TestBatchMerge(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []types.Mode{types.ModeMemory, types.ModeDisk, types.ModeColumnar, types.ModeVector, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.VectorConfig(4)
+			cfg.Mode, cfg.DataDir = mode, t.TempDir()
+			cfg.EnableWAL = mode == types.ModeDisk || mode == types.ModeHybrid
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+
+			expires := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+			require.NoError(t, eng.Put(ctx, "user:1", &types.Record{ID: "user:1", Tags: []string{"vip"}, TTL: &expires, Vector: unitVector(4, 0),
+				Data: map[string]interface{}{
+					"name":    "ada",
+					"address": map[string]interface{}{"city": "London", "zip": "N1"},
+					"prefs":   map[string]interface{}{"theme": "dark"},
+					"score":   1.0,
+					"langs":   []interface{}{"en", "fr"},
+				}}))
+
+			merged := &types.Record{ID: "user:1", Data: map[string]interface{}{
+				"address": map[string]interface{}{"zip": "N2", "street": "Fleet"}, // objects merge
+				"prefs":   "default",                                              // a scalar replaces an object
+				"score":   map[string]interface{}{"value": 2.0},                   // an object replaces a scalar
+				"langs":   []interface{}{"de"},                                    // arrays are replaced
+				"email":   nil,                                                    // null is a value
+			}}
+			fresh := &types.Record{ID: "user:2", Data: map[string]interface{}{"name": "bob"}, Vector: unitVector(4, 1)}
+			require.NoError(t, eng.(types.BatchMerger).BatchMerge(ctx, []*types.Record{merged, fresh}))
+
+			want := map[string]interface{}{
+				"name":    "ada",
+				"address": map[string]interface{}{"city": "London", "zip": "N2", "street": "Fleet"},
+				"prefs":   "default",
+				"score":   map[string]interface{}{"value": 2.0},
+				"langs":   []interface{}{"de"},
+				"email":   nil,
+			}
+			check := func(eng types.Engine) {
+				t.Helper()
+				rec, err := eng.Get(ctx, "user:1")
+				require.NoError(t, err)
+				assert.Equal(t, want, rec.Data)
+				assert.EqualValues(t, 2, rec.Version) // bumped once
+				assert.Equal(t, []string{"vip"}, rec.Tags)
+				assert.Equal(t, unitVector(4, 0), rec.Vector)
+				require.NotNil(t, rec.TTL)
+				assert.True(t, expires.Equal(*rec.TTL))
+			}
+			check(eng)
+			rec, err := eng.Get(ctx, "user:2")
+			require.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"name": "bob"}, rec.Data)
+			assert.EqualValues(t, 1, rec.Version)
+			assert.Equal(t, want, merged.Data) // the caller's record holds what was stored
+			assert.EqualValues(t, 2, merged.Version)
+
+			// A new vector or tags replace the old
+			require.NoError(t, eng.(types.BatchMerger).BatchMerge(ctx, []*types.Record{{ID: "user:2", Tags: []string{"new"}, Vector: unitVector(4, 2)}}))
+			rec, err = eng.Get(ctx, "user:2")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"new"}, rec.Tags)
+			assert.Equal(t, unitVector(4, 2), rec.Vector)
+			assert.Equal(t, map[string]interface{}{"name": "bob"}, rec.Data)
+			assert.Equal(t, []string{"user:2"}, tagScan(t, eng, "new", ""))
+
+			// The WAL holds the merged records
+			require.NoError(t, eng.Close())
+			if cfg.EnableWAL {
+				eng, err = kvi.Open(cfg)
+				require.NoError(t, err)
+				defer eng.Close()
+				check(eng)
+			}
+		})
+	}
+}
+
+func TestBatchAPI(t *testing.T) {
+	ctx := context.Background()
+	eng, ts := memoryServer(t)
+	post := func(body string) (int, string) {
+		return postBody(t, ts.URL+"/api/v1/batch", strings.NewReader(body))
+	}
+
+	code, body := post(`{"records":[{"key":"a","data":{"x":{"y":1}}},{"key":"b","data":{"n":1},"tags":["t"]}]}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"status":"ok","records":[{"key":"a","version":1},{"key":"b","version":1}]}`, body)
+
+	code, body = post(`{"mode":"merge","records":[{"key":"a","data":{"x":{"z":2}}},{"key":"b","data":{"m":2}}]}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"status":"ok","records":[{"key":"a","version":2},{"key":"b","version":2}]}`, body)
+	rec, err := eng.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"x": map[string]interface{}{"y": 1.0, "z": 2.0}}, rec.Data)
+	rec, err = eng.Get(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"n": 1.0, "m": 2.0}, rec.Data)
+	assert.Equal(t, []string{"t"}, rec.Tags)
+
+	// A put replaces
+	code, _ = post(`{"mode":"put","records":[{"key":"b","data":{"m":3}}]}`)
+	assert.Equal(t, http.StatusOK, code)
+	rec, err = eng.Get(ctx, "b")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"m": 3.0}, rec.Data)
+	assert.Empty(t, rec.Tags)
+
+	code, body = post(`{"mode":"upsert","records":[]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, "mode must be put or merge")
+	code, body = post(`{"records":[{"key":"c"},{"key":"d","tags":[""]}]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Contains(t, body, "record 1: tags must not be empty")
+	_, err = eng.Get(ctx, "c")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+
+	// The client merges in one call
+	c, err := client.New(client.WithHTTP(ts.URL))
+	require.NoError(t, err)
+	defer c.Close()
+	recs := []*types.Record{{ID: "a", Data: map[string]interface{}{"x": map[string]interface{}{"y": "one"}}}}
+	require.NoError(t, c.BatchMerge(ctx, recs))
+	assert.EqualValues(t, 3, recs[0].Version)
+	rec, err = eng.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"x": map[string]interface{}{"y": "one", "z": 2.0}}, rec.Data)
+}
@@ -0,0 +1,195 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+var png = []byte{0x89, 'P', 'N', 'G', 0, 0xff, 0x10}
+
+func tagScan(t *testing.T, eng types.Engine, tag, prefix string) []string {
+	t.Helper()
+	keys := []string{}
+	require.NoError(t, eng.(types.TagScanner).ScanTag(context.Background(), tag, prefix, func(rec *types.Record) bool {
+		keys = append(keys, rec.ID)
+		return true
+	}))
+	return keys
+}
+
+func TestRecordMetadata(t *testing.T) {
+	ctx := context.Background()
+	disk := config.DiskConfig()
+	disk.DataDir = t.TempDir()
+	hybrid := config.DefaultConfig()
+	hybrid.DataDir = t.TempDir()
+
+	for name, cfg := range map[string]*config.Config{"memory": config.MemoryConfig(), "columnar": config.ColumnarConfig(), "disk": disk, "hybrid": hybrid} {
+		t.Run(name, func(t *testing.T) {
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			put := func(key string, tags ...string) {
+				require.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, Blob: png, ContentType: "image/png", Tags: tags}))
+			}
+			put("img:1", "cat", "red")
+			put("img:2", "cat")
+			put("img:3", "dog")
+			put("doc:1", "cat")
+			require.NoError(t, eng.Put(ctx, "plain", &types.Record{ID: "plain", Data: map[string]interface{}{"tags": []interface{}{"cat"}}}))
+
+			rec, err := eng.Get(ctx, "img:1")
+			require.NoError(t, err)
+			assert.Equal(t, png, rec.Blob)
+			assert.Equal(t, "image/png", rec.ContentType)
+			assert.Equal(t, []string{"cat", "red"}, rec.Tags)
+			assert.Equal(t, []string{"doc:1", "img:1", "img:2"}, tagScan(t, eng, "cat", ""))
+			assert.Equal(t, []string{"img:1", "img:2"}, tagScan(t, eng, "cat", "img:"))
+
+			put("img:2", "dog")
+			require.NoError(t, eng.Delete(ctx, "doc:1"))
+			assert.Equal(t, []string{"img:1"}, tagScan(t, eng, "cat", ""), "retagged and deleted records leave the index")
+			assert.Equal(t, []string{"img:2", "img:3"}, tagScan(t, eng, "dog", ""))
+			assert.Empty(t, tagScan(t, eng, "bird", ""))
+			require.NoError(t, eng.Close())
+
+			if cfg.Mode != types.ModeDisk && cfg.Mode != types.ModeHybrid {
+				return
+			}
+			// The WAL keeps blobs and tags, and the index is rebuilt from it
+			eng, err = kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+			rec, err = eng.Get(ctx, "img:3")
+			require.NoError(t, err)
+			assert.Equal(t, png, rec.Blob)
+			assert.Equal(t, []string{"img:2", "img:3"}, tagScan(t, eng, "dog", ""))
+		})
+	}
+}
+
+func TestRecordMetadataChecksum(t *testing.T) {
+	dir := t.TempDir()
+	eng := openDisk(t, dir)
+	blob := bytes.Repeat([]byte("blob"), 30)
+	require.NoError(t, eng.Put(context.Background(), "k", &types.Record{ID: "k", Blob: blob, Tags: []string{"checked"}}))
+	require.NoError(t, eng.Close())
+
+	for _, field := range []string{base64.StdEncoding.EncodeToString(blob), `"checked"`} {
+		path := filepath.Join(dir, wal.FileName)
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		at := bytes.Index(data, []byte(field))
+		require.GreaterOrEqual(t, at, 0)
+		damaged := bytes.Clone(data)
+		damaged[at+1] ^= 0x01 // still valid base64 and JSON
+		require.NoError(t, os.WriteFile(path, damaged, 0o644))
+
+		cfg := config.DiskConfig()
+		cfg.DataDir = dir
+		_, err = kvi.Open(cfg)
+		assert.ErrorContains(t, err, "checksum", "the checksum covers %s", field)
+		require.NoError(t, os.WriteFile(path, data, 0o644))
+	}
+}
+
+func TestRecordMetadataBackup(t *testing.T) {
+	ctx := context.Background()
+	src, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer src.Close()
+	require.NoError(t, src.Put(ctx, "k", &types.Record{ID: "k", Blob: png, ContentType: "image/png", Tags: []string{"a"}}))
+	var buf bytes.Buffer
+	_, err = backup.Dump(ctx, src, &buf)
+	require.NoError(t, err)
+
+	dst, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer dst.Close()
+	_, err = backup.Restore(ctx, dst, bytes.NewReader(buf.Bytes()), backup.Replace)
+	require.NoError(t, err)
+	rec, err := dst.Get(ctx, "k")
+	require.NoError(t, err)
+	assert.Equal(t, png, rec.Blob)
+	assert.Equal(t, "image/png", rec.ContentType)
+	assert.Equal(t, []string{"k"}, tagScan(t, dst, "a", ""))
+}
+
+func TestRecordMetadataAPI(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	post := func(body interface{}) int {
+		resp, err := http.Post(ts.URL+"/api/v1/put", "application/json", jsonBody(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusCreated, post(map[string]interface{}{
+		"key": "img:1", "blob": base64.StdEncoding.EncodeToString(png), "content_type": "image/png", "tags": []string{"cat"},
+	}))
+	assert.Equal(t, http.StatusCreated, post(map[string]interface{}{"key": "img:2", "data": map[string]interface{}{"x": 1}}))
+	assert.Equal(t, http.StatusBadRequest, post(map[string]interface{}{"key": "bad", "blob": "not base64!"}))
+	assert.Equal(t, http.StatusBadRequest, post(map[string]interface{}{"key": "bad", "tags": []string{""}}))
+
+	var rec struct {
+		Blob        []byte   `json:"blob"`
+		ContentType string   `json:"content_type"`
+		Tags        []string `json:"tags"`
+	}
+	getJSON(t, ts.URL+"/api/v1/get?key=img:1", &rec)
+	assert.Equal(t, png, rec.Blob)
+	assert.Equal(t, "image/png", rec.ContentType)
+	assert.Equal(t, []string{"cat"}, rec.Tags)
+
+	var page scanPage
+	getJSON(t, ts.URL+"/api/v1/scan?prefix=img:&tag=cat", &page)
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, "img:1", page.Items[0].ID)
+	resp, err := http.Get(ts.URL + "/api/v1/scan?tag=cat&consistent=true")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestGrpcRecordMetadata(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	client := serveGrpc(t, kvi_grpc.NewGrpcServer(eng, pubsub.NewHub()))
+
+	_, err = client.Put(ctx, &kvi_grpc.PutRequest{Key: "img:1", Blob: png, ContentType: "image/png", Tags: []string{"cat"}})
+	require.NoError(t, err, "a blob needs no data_json")
+	_, err = client.Put(ctx, &kvi_grpc.PutRequest{Key: "img:2", DataJson: "{}", Tags: []string{"dog"}})
+	require.NoError(t, err)
+	_, err = client.Put(ctx, &kvi_grpc.PutRequest{Key: "img:3", DataJson: "{}", Tags: []string{""}})
+	assert.Error(t, err)
+
+	got, err := client.Get(ctx, &kvi_grpc.GetRequest{Key: "img:1"})
+	require.NoError(t, err)
+	assert.Equal(t, png, got.Blob)
+	assert.Equal(t, "image/png", got.ContentType)
+	assert.Equal(t, []string{"cat"}, got.Tags)
+	keys, _, _ := grpcScan(t, client, &kvi_grpc.ScanRequest{Prefix: "img:", Tag: "dog"})
+	assert.Equal(t, []string{"img:2"}, keys)
+}
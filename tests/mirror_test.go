@@ -0,0 +1,246 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/stats"
+)
+
+// mirroredRequest is what a canary received.
+type mirroredRequest struct {
+	method, uri, body, mirrored string
+}
+
+// canary serves requests with handle, after passing them on to got.
+func canary(t *testing.T, handle http.HandlerFunc) (*httptest.Server, chan mirroredRequest) {
+	t.Helper()
+	got := make(chan mirroredRequest, 100)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- mirroredRequest{r.Method, r.URL.RequestURI(), string(body), r.Header.Get(api.MirroredHeader)}
+		handle(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	return ts, got
+}
+
+func mirroringServer(t *testing.T, cfg config.MirrorConfig) *httptest.Server {
+	t.Helper()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	t.Cleanup(func() { eng.Close() })
+	ts := httptest.NewServer(api.NewServer(eng, api.WithMirror(cfg)).Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func mirrorStats(t *testing.T, ts *httptest.Server) stats.MirrorStats {
+	t.Helper()
+	var st stats.MirrorStats
+	getJSON(t, ts.URL+"/api/v1/admin/mirror", &st)
+	return st
+}
+
+func putMirror(t *testing.T, ts *httptest.Server, body string) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/mirror", strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func nextMirrored(t *testing.T, got chan mirroredRequest) mirroredRequest {
+	t.Helper()
+	select {
+	case r := <-got:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing mirrored")
+		return mirroredRequest{}
+	}
+}
+
+func TestMirrorRequests(t *testing.T) {
+	target, got := canary(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.RawQuery, "boom") {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		}
+	})
+	cfg := config.DefaultMirror()
+	cfg.Target, cfg.SamplePercent, cfg.Methods = target.URL, 100, []string{"GET", "POST"}
+	ts := mirroringServer(t, cfg)
+
+	// Writes go with their body, reads with their query
+	body := `{"key":"a","data":{"n":1}}`
+	status, _ := postBody(t, ts.URL+"/api/v1/put", strings.NewReader(body))
+	assert.Equal(t, http.StatusCreated, status)
+	assert.Equal(t, mirroredRequest{"POST", "/api/v1/put", body, "1"}, nextMirrored(t, got))
+	resp, err := http.Get(ts.URL + "/api/v1/get?key=a")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, mirroredRequest{"GET", "/api/v1/get?key=a", "", "1"}, nextMirrored(t, got))
+
+	// Administration, health and copies a mirror sent are not mirrored
+	mirrorStats(t, ts)
+	resp, err = http.Get(ts.URL + "/health/live")
+	require.NoError(t, err)
+	resp.Body.Close()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/get?key=a", nil)
+	req.Header.Set(api.MirroredHeader, "1")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// Nor is a body the server did not read
+	status, _ = postBody(t, ts.URL+"/api/v1/get?key=a", strings.NewReader(`{"key":"b"}`))
+	assert.Equal(t, http.StatusOK, status)
+
+	// The target failing fails nothing here
+	resp, err = http.Get(ts.URL + "/api/v1/get?key=boom")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, "/api/v1/get?key=boom", nextMirrored(t, got).uri)
+
+	require.Eventually(t, func() bool { return mirrorStats(t, ts).Failed == 1 }, 5*time.Second, 10*time.Millisecond)
+	st := mirrorStats(t, ts)
+	assert.EqualValues(t, 2, st.Mirrored)
+	assert.Equal(t, "GET /api/v1/get: 503 Service Unavailable", st.LastError)
+	assert.Equal(t, 2, st.LatencyDeltaMs.Samples)
+	assert.Empty(t, got, "nothing else was mirrored")
+
+	var report struct {
+		Mirror *stats.MirrorStats `json:"mirror"`
+	}
+	getJSON(t, ts.URL+"/api/v1/stats", &report)
+	require.NotNil(t, report.Mirror)
+	assert.Equal(t, target.URL, report.Mirror.Target)
+}
+
+func TestMirrorCredentials(t *testing.T) {
+	headers := make(chan http.Header, 10)
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { headers <- r.Header }))
+	t.Cleanup(target.Close)
+	cfg := config.DefaultMirror()
+	cfg.Target, cfg.SamplePercent = target.URL, 100
+	ts := mirroringServer(t, cfg)
+
+	get := func() http.Header {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/get?key=a", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Cookie", "session=secret")
+		req.Header.Set("X-Api-Key", "secret")
+		req.Header.Set("X-Request-ID", "r1")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		select {
+		case h := <-headers:
+			return h
+		case <-time.After(5 * time.Second):
+			t.Fatal("nothing mirrored")
+			return nil
+		}
+	}
+
+	// Credentials stay here unless forward_auth says otherwise
+	h := get()
+	assert.Empty(t, h.Get("Authorization"))
+	assert.Empty(t, h.Get("Cookie"))
+	assert.Empty(t, h.Get("X-Api-Key"))
+	assert.Equal(t, "r1", h.Get("X-Request-ID"), "other headers go along")
+
+	assert.Equal(t, http.StatusOK, putMirror(t, ts, `{"forward_auth":true}`))
+	assert.True(t, mirrorStats(t, ts).ForwardAuth)
+	h = get()
+	assert.Equal(t, "Bearer secret", h.Get("Authorization"))
+	assert.Equal(t, "session=secret", h.Get("Cookie"))
+}
+
+func TestMirrorConcurrencyCap(t *testing.T) {
+	release := make(chan struct{})
+	target, got := canary(t, func(w http.ResponseWriter, r *http.Request) { <-release })
+	t.Cleanup(func() { close(release) }) // before the canary closes
+	cfg := config.DefaultMirror()
+	cfg.Target, cfg.SamplePercent, cfg.MaxInFlight = target.URL, 100, 2
+	ts := mirroringServer(t, cfg)
+
+	// A stuck target holds up no response, and no more than two goroutines
+	start := time.Now()
+	for range 10 {
+		resp, err := http.Get(ts.URL + "/api/v1/get?key=a")
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.Less(t, time.Since(start), time.Second)
+	nextMirrored(t, got)
+	nextMirrored(t, got)
+	st := mirrorStats(t, ts)
+	assert.Equal(t, 2, st.InFlight)
+	assert.EqualValues(t, 8, st.Dropped)
+	assert.Zero(t, st.Mirrored)
+}
+
+func TestMirrorSettings(t *testing.T) {
+	target, got := canary(t, func(http.ResponseWriter, *http.Request) {})
+	ts := mirroringServer(t, config.DefaultMirror())
+
+	// Off until a target is set, and then not in the stats
+	var report struct {
+		Mirror *stats.MirrorStats `json:"mirror"`
+	}
+	getJSON(t, ts.URL+"/api/v1/stats", &report)
+	assert.Nil(t, report.Mirror)
+
+	assert.Equal(t, http.StatusBadRequest, putMirror(t, ts, `{"target":"canary:8080"}`))
+	assert.Equal(t, http.StatusBadRequest, putMirror(t, ts, `{"sample_percent":101}`))
+	assert.Equal(t, http.StatusBadRequest, putMirror(t, ts, `{"target":"`+target.URL+`","methods":["TRACE"]}`))
+	assert.Equal(t, http.StatusOK, putMirror(t, ts, `{"target":"`+target.URL+`","sample_percent":100}`))
+	st := mirrorStats(t, ts)
+	assert.Equal(t, target.URL, st.Target)
+	assert.Equal(t, []string{"GET"}, st.Methods, "fields left out are kept")
+	assert.Equal(t, 2000, st.TimeoutMs)
+
+	// Writes are only mirrored when asked for
+	status, _ := postBody(t, ts.URL+"/api/v1/put", strings.NewReader(`{"key":"a"}`))
+	assert.Equal(t, http.StatusCreated, status)
+	resp, err := http.Get(ts.URL + "/api/v1/get?key=a")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "GET", nextMirrored(t, got).method)
+
+	assert.Equal(t, http.StatusOK, putMirror(t, ts, `{"target":""}`))
+	resp, err = http.Get(ts.URL + "/api/v1/get?key=a")
+	require.NoError(t, err)
+	resp.Body.Close()
+	getJSON(t, ts.URL+"/api/v1/stats", &report)
+	assert.Nil(t, report.Mirror)
+	select {
+	case r := <-got:
+		t.Fatalf("mirrored %s with no target", r.uri)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMirrorConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Mirror.Target = "ftp://canary"
+	cfg.Mirror.MaxInFlight = 0
+	err := cfg.Validate()
+	assert.ErrorContains(t, err, "mirror.target")
+	assert.ErrorContains(t, err, "mirror.max_in_flight")
+	assert.True(t, config.Reloadable("mirror.sample_percent"))
+}
@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/client"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []types.Mode{types.ModeMemory, types.ModeDisk, types.ModeColumnar, types.ModeVector, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.VectorConfig(4)
+			cfg.Mode, cfg.DataDir = mode, t.TempDir()
+			cfg.EnableWAL = mode == types.ModeDisk || mode == types.ModeHybrid
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+			mover := eng.(types.Mover)
+			tagged := func(tag string) []string {
+				t.Helper()
+				var keys []string
+				require.NoError(t, eng.(types.TagScanner).ScanTag(ctx, tag, "", func(rec *types.Record) bool {
+					keys = append(keys, rec.ID)
+					return true
+				}))
+				return keys
+			}
+
+			expires := time.Now().Add(time.Hour).UTC().Truncate(time.Millisecond)
+			src := &types.Record{ID: "old:1", Data: map[string]interface{}{"n": 1.0}, Tags: []string{"vip"}, Vector: unitVector(4, 0), TTL: &expires}
+			require.NoError(t, eng.Put(ctx, "old:1", src))
+			time.Sleep(2 * time.Millisecond)
+
+			// A copy is a new record under dst with everything src had, and a
+			// CreatedAt of its own unless asked to keep src's
+			cp, err := mover.Copy(ctx, "old:1", "new:1", types.MoveOptions{})
+			require.NoError(t, err)
+			assert.Equal(t, "new:1", cp.ID)
+			assert.EqualValues(t, 1, cp.Version)
+			assert.True(t, cp.CreatedAt.After(src.CreatedAt))
+			rec, err := eng.Get(ctx, "new:1")
+			require.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"n": 1.0}, rec.Data)
+			assert.Equal(t, []string{"vip"}, rec.Tags)
+			assert.Equal(t, unitVector(4, 0), rec.Vector)
+			require.NotNil(t, rec.TTL)
+			assert.True(t, expires.Equal(*rec.TTL))
+			rec, err = eng.Get(ctx, "old:1")
+			require.NoError(t, err)
+			assert.EqualValues(t, 1, rec.Version)
+			assert.Equal(t, []string{"new:1", "old:1"}, tagged("vip"))
+
+			// dst must not exist unless overwriting, which bumps its version
+			_, err = mover.Copy(ctx, "old:1", "new:1", types.MoveOptions{})
+			assert.ErrorIs(t, err, types.ErrKeyExists)
+			cp, err = mover.Copy(ctx, "old:1", "new:1", types.MoveOptions{Overwrite: true, KeepCreatedAt: true})
+			require.NoError(t, err)
+			assert.EqualValues(t, 2, cp.Version)
+			assert.True(t, cp.CreatedAt.Equal(src.CreatedAt))
+			_, err = mover.Copy(ctx, "none", "new:2", types.MoveOptions{})
+			assert.ErrorIs(t, err, types.ErrKeyNotFound)
+
+			// A rename leaves nothing under src, in the indexes either
+			require.NoError(t, eng.Delete(ctx, "new:1"))
+			mv, err := mover.Rename(ctx, "old:1", "new:1", types.MoveOptions{KeepCreatedAt: true})
+			require.NoError(t, err)
+			assert.EqualValues(t, 1, mv.Version)
+			assert.True(t, mv.CreatedAt.Equal(src.CreatedAt))
+			_, err = eng.Get(ctx, "old:1")
+			assert.ErrorIs(t, err, types.ErrKeyNotFound)
+			rec, err = eng.Get(ctx, "new:1")
+			require.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"n": 1.0}, rec.Data)
+			assert.Equal(t, []string{"new:1"}, tagged("vip"))
+			if s, ok := eng.(types.Searcher); ok {
+				found, err := s.Search(ctx, unitVector(4, 0), 5)
+				require.NoError(t, err)
+				require.Len(t, found, 1)
+				assert.Equal(t, "new:1", found[0].ID)
+			}
+			_, err = mover.Rename(ctx, "old:1", "new:2", types.MoveOptions{})
+			assert.ErrorIs(t, err, types.ErrKeyNotFound)
+		})
+	}
+}
+
+func TestRenameLogged(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []types.Mode{types.ModeDisk, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Mode, cfg.DataDir = mode, t.TempDir()
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			require.NoError(t, eng.Put(ctx, "a", &types.Record{ID: "a", Data: map[string]interface{}{"v": 1.0}}))
+			require.NoError(t, eng.Put(ctx, "b", &types.Record{ID: "b"}))
+			after := eng.(types.LogShipper).LSN()
+			events, err := eng.(types.LogShipper).Ship(ctx, after)
+			require.NoError(t, err)
+			_, err = eng.(types.Mover).Rename(ctx, "a", "b", types.MoveOptions{Overwrite: true})
+			require.NoError(t, err)
+
+			// Replicas get the put of dst and the delete of src, in turn
+			put, del := <-events, <-events
+			assert.Equal(t, types.OpPut, put.Op)
+			assert.Equal(t, "b", put.Key)
+			assert.Equal(t, types.OpDelete, del.Op)
+			assert.Equal(t, "a", del.Key)
+			assert.Equal(t, after+1, put.Seq)
+			assert.Equal(t, after+2, del.Seq)
+			assert.Equal(t, del.Seq, eng.(types.LogShipper).LSN())
+			require.NoError(t, eng.Close())
+
+			// The WAL has a single entry for both, which recovery replays
+			var renames []*wal.LogEntry
+			for _, frame := range scanWAL(t, cfg.DataDir+"/"+wal.FileName) {
+				require.True(t, frame.Valid())
+				if frame.Entry.Op == types.OpRename {
+					renames = append(renames, frame.Entry)
+				}
+			}
+			require.Len(t, renames, 1)
+			assert.Equal(t, "a", renames[0].From)
+			assert.Equal(t, "b", renames[0].Key)
+
+			eng, err = kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+			_, err = eng.Get(ctx, "a")
+			assert.ErrorIs(t, err, types.ErrKeyNotFound)
+			rec, err := eng.Get(ctx, "b")
+			require.NoError(t, err)
+			assert.Equal(t, map[string]interface{}{"v": 1.0}, rec.Data)
+			assert.EqualValues(t, 2, rec.Version)
+		})
+	}
+}
+
+func TestMoveAPI(t *testing.T) {
+	ctx := context.Background()
+	eng, ts := memoryServer(t)
+	require.NoError(t, eng.Put(ctx, "a", &types.Record{ID: "a", Data: map[string]interface{}{"v": 1.0}}))
+	require.NoError(t, eng.Put(ctx, "c", &types.Record{ID: "c"}))
+
+	for _, tc := range []struct {
+		path, body string
+		status     int
+	}{
+		{"/api/v1/copy", `{"src":"a","dst":"b"}`, http.StatusCreated},
+		{"/api/v1/copy", `{"src":"a","dst":"c"}`, http.StatusConflict},
+		{"/api/v1/copy", `{"src":"x","dst":"y"}`, http.StatusNotFound},
+		{"/api/v1/copy", `{"src":"a","dst":"a"}`, http.StatusBadRequest},
+		{"/api/v1/rename", `{"src":"a"}`, http.StatusBadRequest},
+		{"/api/v1/rename", `{"src":"b","dst":"c"}`, http.StatusConflict},
+		{"/api/v1/rename", `{"src":"b","dst":"c","overwrite":true}`, http.StatusCreated},
+		{"/api/v1/rename", `{"src":"b","dst":"d"}`, http.StatusNotFound},
+	} {
+		code, body := postBody(t, ts.URL+tc.path, strings.NewReader(tc.body))
+		assert.Equal(t, tc.status, code, "%s %s: %s", tc.path, tc.body, body)
+	}
+	rec, err := eng.Get(ctx, "c")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"v": 1.0}, rec.Data)
+	assert.EqualValues(t, 2, rec.Version)
+	_, err = eng.Get(ctx, "b")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+
+	// The client maps the failures back
+	c, err := client.New(client.WithHTTP(ts.URL))
+	require.NoError(t, err)
+	defer c.Close()
+	rec, err = c.Rename(ctx, "c", "e", types.MoveOptions{KeepCreatedAt: true})
+	require.NoError(t, err)
+	assert.Equal(t, "e", rec.ID)
+	assert.EqualValues(t, 1, rec.Version)
+	assert.Equal(t, map[string]interface{}{"v": 1.0}, rec.Data)
+	_, err = c.Copy(ctx, "e", "a", types.MoveOptions{})
+	assert.ErrorIs(t, err, types.ErrKeyExists)
+	_, err = c.Copy(ctx, "c", "f", types.MoveOptions{})
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+
+	resp, err := http.Get(ts.URL + "/api/v1/copy")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func patchRecord(t *testing.T, url, ifMatch string, body interface{}) (*types.Record, int) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPatch, url+"/api/v1/patch", jsonBody(body))
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode
+	}
+	var rec types.Record
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&rec))
+	return &rec, resp.StatusCode
+}
+
+func TestPatchMergesFields(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/api/v1/put", "application/json", jsonBody(map[string]interface{}{
+		"key": "u1",
+		"data": map[string]interface{}{
+			"name":    "Ann",
+			"age":     30,
+			"address": map[string]interface{}{"city": "Bangkok", "zip": "10110"},
+			"vector":  []float64{0.1, 0.2},
+		},
+	}))
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	rec, code := patchRecord(t, ts.URL, `"1"`, map[string]interface{}{
+		"key":   "u1",
+		"set":   map[string]interface{}{"age": 31, "address": map[string]interface{}{"city": "Chiang Mai", "zip": nil}},
+		"unset": []string{"name"},
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, uint64(2), rec.Version)
+	assert.Equal(t, map[string]interface{}{
+		"age":     float64(31),
+		"address": map[string]interface{}{"city": "Chiang Mai"},
+		"vector":  []interface{}{0.1, 0.2},
+	}, rec.Data)
+
+	_, code = patchRecord(t, ts.URL, `"1"`, map[string]interface{}{"key": "u1", "set": map[string]interface{}{"age": 32}})
+	assert.Equal(t, http.StatusPreconditionFailed, code)
+	_, code = patchRecord(t, ts.URL, "", map[string]interface{}{"key": "missing", "set": map[string]interface{}{"a": 1}})
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestPatchIsAtomic(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	putKey(t, ts, "counter")
+
+	const writers = 20
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			patchRecord(t, ts.URL, "", map[string]interface{}{"key": "counter", "set": map[string]interface{}{fmt.Sprintf("f%d", i): i}})
+		}(i)
+	}
+	wg.Wait()
+
+	var rec types.Record
+	getJSON(t, ts.URL+"/api/v1/get?key=counter", &rec)
+	assert.Len(t, rec.Data, writers+1) // every field survived, plus the original "v"
+	assert.Equal(t, uint64(writers+1), rec.Version)
+}
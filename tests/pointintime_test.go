@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/internal/engine"
+	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// pitrHistory writes k0…k4, takes a backup, writes on, then deletes every
+// key in bulk (the poisoned entry starts it) and writes after that too. It
+// returns the backup, the closed log's path and the poisoned entry.
+func pitrHistory(t *testing.T) ([]byte, string, *wal.LogEntry) {
+	t.Helper()
+	ctx := context.Background()
+	dir := t.TempDir()
+	eng, err := kvi.OpenDisk(dir)
+	require.NoError(t, err)
+	for i := range 5 {
+		putVersions(t, eng, fmt.Sprintf("k%d", i), "base", 1)
+	}
+	base, _ := dumpEngine(t, eng)
+
+	putVersions(t, eng, "k5", "after", 1)
+	putVersions(t, eng, "k0", "after", 1)
+	require.NoError(t, eng.Delete(ctx, "k1"))
+	_, err = eng.(types.Mover).Rename(ctx, "k2", "moved", types.MoveOptions{})
+	require.NoError(t, err)
+	poisoned := eng.(types.StatsReporter).Stats().WAL.LastLSN + 1
+	for _, k := range []string{"k0", "k3", "k4", "k5", "moved"} {
+		require.NoError(t, eng.Delete(ctx, k))
+	}
+	putVersions(t, eng, "k6", "later", 1)
+	require.NoError(t, eng.Close())
+
+	path := filepath.Join(dir, wal.FileName)
+	for _, frame := range scanWAL(t, path) {
+		if frame.Entry.LSN == poisoned {
+			return base, path, frame.Entry
+		}
+	}
+	t.Fatalf("no entry at LSN %d", poisoned)
+	return nil, "", nil
+}
+
+func recoverUntil(t *testing.T, eng types.Engine, base []byte, path string, cut wal.Cut) (engine.PointInTime, error) {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	return engine.RecoverUntil(context.Background(), eng, bytes.NewReader(base), f, cut)
+}
+
+func TestPointInTimeRecovery(t *testing.T) {
+	ctx := context.Background()
+	base, path, poisoned := pitrHistory(t)
+	hdr, err := backup.ReadHeader(bytes.NewReader(base))
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), hdr.LSN, "the backup records the log's position")
+
+	for name, cut := range map[string]wal.Cut{
+		"lsn":  {LSN: poisoned.LSN - 1},
+		"time": {Time: time.Unix(0, poisoned.Timestamp)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			eng, err := kvi.OpenDisk(dir)
+			require.NoError(t, err)
+			putVersions(t, eng, "stray", "x", 1)
+
+			res, err := recoverUntil(t, eng, base, path, cut)
+			require.NoError(t, err)
+			assert.Equal(t, 5, res.Backup.Restored)
+			assert.Equal(t, 1, res.Backup.Removed)
+			assert.Equal(t, uint64(5), res.BaseLSN)
+			assert.Equal(t, 5, res.Skipped)
+			assert.Equal(t, 4, res.Replayed)
+			assert.Equal(t, poisoned.LSN-1, res.LastLSN, "replay stops right before the poisoned entry")
+			assert.Equal(t, poisoned.LSN, res.NextLSN)
+			assert.Equal(t, time.Unix(0, poisoned.Timestamp), res.NextTime)
+			assert.Equal(t, 6, res.Discarded)
+
+			want := map[string]string{"k0": "after", "k3": "base", "k4": "base", "k5": "after", "moved": "base"}
+			var got []string
+			require.NoError(t, eng.Scan(ctx, "", func(rec *types.Record) bool {
+				got = append(got, rec.ID)
+				return true
+			}))
+			assert.ElementsMatch(t, []string{"k0", "k3", "k4", "k5", "moved"}, got)
+			for k, v := range want {
+				rec, err := eng.Get(ctx, k)
+				require.NoError(t, err, k)
+				assert.Equal(t, v, rec.Data["v"], k)
+			}
+			k0, err := eng.Get(ctx, "k0")
+			require.NoError(t, err)
+			assert.Equal(t, uint64(2), k0.Version, "changes are stored as they were logged")
+			require.NoError(t, eng.Close())
+
+			// The restored records are the engine's own from then on
+			eng, err = kvi.OpenDisk(dir)
+			require.NoError(t, err)
+			defer eng.Close()
+			_, err = eng.Get(ctx, "moved")
+			assert.NoError(t, err)
+			_, err = eng.Get(ctx, "stray")
+			assert.ErrorIs(t, err, types.ErrKeyNotFound)
+		})
+	}
+
+	// Without a cut the whole log is replayed
+	eng, err := kvi.OpenMemory()
+	require.NoError(t, err)
+	defer eng.Close()
+	res, err := recoverUntil(t, eng, base, path, wal.Cut{})
+	require.NoError(t, err)
+	assert.Equal(t, 10, res.Replayed)
+	assert.Zero(t, res.Discarded)
+	rec, err := eng.Get(ctx, "k6")
+	require.NoError(t, err)
+	assert.Equal(t, "later", rec.Data["v"])
+	_, err = eng.Get(ctx, "k0")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+}
+
+func TestPointInTimeRecoveryRefuses(t *testing.T) {
+	ctx := context.Background()
+	base, path, poisoned := pitrHistory(t)
+	eng, err := kvi.OpenMemory()
+	require.NoError(t, err)
+	defer eng.Close()
+	putVersions(t, eng, "kept", "x", 1)
+	untouched := func() {
+		t.Helper()
+		_, err := eng.Get(ctx, "kept")
+		assert.NoError(t, err, "nothing is changed")
+	}
+
+	_, err = recoverUntil(t, eng, base, path, wal.Cut{LSN: 4})
+	assert.ErrorIs(t, err, engine.ErrBeforeBase)
+	_, err = recoverUntil(t, eng, base, path, wal.Cut{Time: time.Now().Add(-time.Hour)})
+	assert.ErrorIs(t, err, engine.ErrBeforeBase)
+	untouched()
+
+	_, err = recoverUntil(t, eng, []byte("not a backup"), path, wal.Cut{})
+	assert.ErrorIs(t, err, backup.ErrInvalid)
+	untouched()
+
+	// Damage before the cut stops it; damage past the cut is discarded
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	frames := scanWAL(t, path)
+	damaged := filepath.Join(t.TempDir(), wal.FileName)
+	last := frames[len(frames)-1]
+	at := last.Offset + 4 + int64(bytes.Index(data[last.Offset+4:], []byte(`"k6"`))) + 2
+	data[at] = '9'
+	require.NoError(t, os.WriteFile(damaged, data, 0o644))
+	_, err = recoverUntil(t, eng, base, damaged, wal.Cut{})
+	assert.ErrorIs(t, err, wal.ErrChecksum)
+	untouched()
+	res, err := recoverUntil(t, eng, base, damaged, wal.Cut{LSN: poisoned.LSN - 1})
+	require.NoError(t, err)
+	assert.Equal(t, 6, res.Discarded)
+
+	// A log compacted since the backup no longer holds what came after it
+	putVersions(t, eng, "kept", "x", 1)
+	w, err := wal.NewWAL(filepath.Dir(path))
+	require.NoError(t, err)
+	_, err = w.Replay(wal.ReplayOptions{}, func(*wal.LogEntry) error { return nil })
+	require.NoError(t, err)
+	require.NoError(t, w.Rewrite(func(put func(string, *types.Record) error) error {
+		return put("k6", &types.Record{ID: "k6"})
+	}))
+	require.NoError(t, w.Close())
+	_, err = recoverUntil(t, eng, base, path, wal.Cut{})
+	assert.ErrorIs(t, err, wal.ErrHistoryGap)
+	untouched()
+}
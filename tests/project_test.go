@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func putProjected(t *testing.T, eng types.Engine, n int) {
+	t.Helper()
+	for i := range n {
+		key := fmt.Sprintf("user:%d", i)
+		require.NoError(t, eng.Put(context.Background(), key, &types.Record{ID: key, Tags: []string{"vip"}, Vector: unitVector(4, i%4),
+			Data: map[string]interface{}{"name": key, "status": "active", "bio": "long text"}}))
+	}
+}
+
+func TestScanProjected(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []types.Mode{types.ModeMemory, types.ModeDisk, types.ModeColumnar, types.ModeVector, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.VectorConfig(4)
+			cfg.Mode, cfg.DataDir = mode, t.TempDir()
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+			putProjected(t, eng, 3)
+
+			var got []*types.Record
+			p := &types.Projection{Fields: []string{"name", "missing"}}
+			require.NoError(t, eng.(types.ProjectingScanner).ScanProjected(ctx, "user:", p, func(rec *types.Record) bool {
+				got = append(got, rec)
+				return true
+			}))
+			require.Len(t, got, 3)
+			for _, rec := range got {
+				assert.Equal(t, map[string]interface{}{"name": rec.ID}, rec.Data)
+				assert.Nil(t, rec.Vector)
+				assert.Equal(t, []string{"vip"}, rec.Tags)
+				assert.EqualValues(t, 1, rec.Version)
+			}
+
+			// The stored records keep everything
+			rec, err := eng.Get(ctx, "user:0")
+			require.NoError(t, err)
+			assert.Len(t, rec.Data, 3)
+			assert.Equal(t, unitVector(4, 0), rec.Vector)
+
+			// A nil projection is a plain scan
+			got = got[:0]
+			require.NoError(t, eng.(types.ProjectingScanner).ScanProjected(ctx, "user:", nil, func(rec *types.Record) bool {
+				got = append(got, rec)
+				return true
+			}))
+			require.Len(t, got, 3)
+			assert.Len(t, got[0].Data, 3)
+			assert.NotNil(t, got[0].Vector)
+		})
+	}
+}
+
+func TestScanProjectedPacked(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []types.Mode{types.ModeMemory, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.VectorConfig(4)
+			cfg.Mode, cfg.DataDir, cfg.RecordCompressMinBytes = mode, t.TempDir(), 1024
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+			for _, k := range []string{"a", "b"} {
+				require.NoError(t, eng.Put(ctx, k, &types.Record{Data: bigData(1), Vector: unitVector(4, 0)}))
+			}
+
+			scan := func(p *types.Projection) []*types.Record {
+				var got []*types.Record
+				require.NoError(t, eng.(types.ProjectingScanner).ScanProjected(ctx, "", p, func(rec *types.Record) bool {
+					got = append(got, rec)
+					return true
+				}))
+				require.Len(t, got, 2)
+				return got
+			}
+			for _, rec := range scan(&types.Projection{Fields: []string{"i", "tags"}}) {
+				assert.Equal(t, map[string]interface{}{"i": 1.0, "tags": []interface{}{"a", "b"}}, rec.Data)
+				assert.Nil(t, rec.Vector)
+				assert.Nil(t, rec.Packed)
+			}
+			for _, rec := range scan(&types.Projection{Fields: []string{}, Vector: true}) {
+				assert.Empty(t, rec.Data)
+				assert.Equal(t, unitVector(4, 0), rec.Vector)
+				assert.Nil(t, rec.Packed, "left packed, not handed out")
+				assert.EqualValues(t, 1, rec.Version)
+			}
+
+			// Trimming the decoded copy leaves what memory keeps alone
+			rec, err := eng.Get(ctx, "a")
+			require.NoError(t, err)
+			assert.Equal(t, bigData(1), rec.Data)
+		})
+	}
+}
+
+func TestScanProjectionAPI(t *testing.T) {
+	eng, ts := memoryServer(t)
+	putProjected(t, eng, 3)
+
+	var page struct {
+		Items []*types.Record `json:"items"`
+	}
+	getJSON(t, ts.URL+"/api/v1/scan?prefix=user:&fields=name,status&include_vector=false", &page)
+	require.Len(t, page.Items, 3)
+	for _, rec := range page.Items {
+		assert.Equal(t, map[string]interface{}{"name": rec.ID, "status": "active"}, rec.Data)
+		assert.Nil(t, rec.Vector)
+	}
+
+	// Fields alone keep the vector; so do tag and consistent scans
+	for _, query := range []string{"", "&tag=vip", "&consistent=true"} {
+		page.Items = nil
+		getJSON(t, ts.URL+"/api/v1/scan?prefix=user:&fields=status"+query, &page)
+		require.Len(t, page.Items, 3, query)
+		assert.Equal(t, map[string]interface{}{"status": "active"}, page.Items[0].Data, query)
+		assert.Equal(t, unitVector(4, 0), page.Items[0].Vector, query)
+	}
+
+	// The NDJSON stream is projected the same way
+	resp, err := http.Get(ts.URL + "/api/v1/scan?prefix=user:&stream=true&fields=status&include_vector=false")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	lines := 0
+	for sc := bufio.NewScanner(resp.Body); sc.Scan(); lines++ {
+		var rec types.Record
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &rec))
+		assert.Equal(t, map[string]interface{}{"status": "active"}, rec.Data)
+		assert.Nil(t, rec.Vector)
+	}
+	assert.Equal(t, 3, lines)
+
+	resp, err = http.Get(ts.URL + "/api/v1/scan?include_vector=maybe")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestGrpcScanProjection(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	putProjected(t, eng, 3)
+	client := serveGrpc(t, kvi_grpc.NewGrpcServer(eng, pubsub.NewHub()))
+
+	keep := false
+	for _, req := range []*kvi_grpc.ScanRequest{
+		{Prefix: "user:", Fields: []string{"name"}, IncludeVector: &keep},
+		{Prefix: "user:", Tag: "vip", Fields: []string{"name"}, IncludeVector: &keep},
+	} {
+		stream, err := client.Scan(context.Background(), req)
+		require.NoError(t, err)
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		require.Len(t, resp.Records, 3)
+		for _, rec := range resp.Records {
+			assert.JSONEq(t, fmt.Sprintf(`{"name":%q}`, rec.Id), rec.DataJson)
+			assert.Empty(t, rec.Vector)
+		}
+	}
+
+	// Unset, the record comes whole
+	stream, err := client.Scan(context.Background(), &kvi_grpc.ScanRequest{Prefix: "user:0"})
+	require.NoError(t, err)
+	resp, err := stream.Recv()
+	require.NoError(t, err)
+	require.Len(t, resp.Records, 1)
+	assert.Len(t, resp.Records[0].Vector, 4)
+}
@@ -0,0 +1,343 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+)
+
+func TestHubListAndHistory(t *testing.T) {
+	hub := pubsub.NewHub()
+	hub.Subscribe("news", "s1")
+	hub.Subscribe("news", "s2")
+
+	for _, p := range []string{"a", "b", "c"} {
+		hub.Publish("news", p)
+	}
+
+	infos := hub.ListChannels()
+	assert.Len(t, infos, 1)
+	assert.Equal(t, "news", infos[0].Name)
+	assert.Equal(t, 2, infos[0].Subscribers)
+	assert.Equal(t, 3, infos[0].HistoryLen)
+	assert.Equal(t, uint64(3), infos[0].Published)
+
+	history, ok := hub.History("news", 2)
+	assert.True(t, ok)
+	assert.Len(t, history, 2)
+	assert.Equal(t, "b", history[0].Payload)
+	assert.Equal(t, "c", history[1].Payload)
+
+	_, ok = hub.History("missing", 0)
+	assert.False(t, ok)
+}
+
+func TestHubDeleteChannelRacesUnsubscribe(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		hub := pubsub.NewHub()
+		sub := hub.Subscribe("jobs", "worker")
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() { defer wg.Done(); hub.Unsubscribe("jobs", "worker") }()
+		go func() { defer wg.Done(); hub.DeleteChannel("jobs") }()
+		wg.Wait()
+
+		_, open := <-sub.C
+		assert.False(t, open)
+	}
+}
+
+func TestHubAckModeRedelivery(t *testing.T) {
+	hub := pubsub.NewHub()
+	hub.ConfigureChannel("jobs", pubsub.ChannelOptions{
+		Mode:              pubsub.ModeAck,
+		VisibilityTimeout: 50 * time.Millisecond,
+	})
+
+	crashed := hub.Subscribe("jobs", "worker-a")
+	assert.Equal(t, 1, hub.Publish("jobs", "job-1"))
+
+	first := <-crashed.C
+	assert.Equal(t, "job-1", first.Payload)
+	assert.NotEmpty(t, first.AckToken)
+
+	// worker-a dies without acking; worker-b must get the job after the timeout
+	hub.Unsubscribe("jobs", "worker-a")
+	healthy := hub.Subscribe("jobs", "worker-b")
+
+	select {
+	case again := <-healthy.C:
+		assert.Equal(t, first.ID, again.ID)
+		assert.NotEqual(t, first.AckToken, again.AckToken)
+		assert.False(t, hub.Ack("jobs", first.AckToken))
+		assert.True(t, healthy.Ack(again.AckToken))
+	case <-time.After(time.Second):
+		t.Fatal("message was not redelivered")
+	}
+
+	stats := hub.Stats()
+	assert.Equal(t, 0, stats.InFlight)
+	assert.Equal(t, uint64(1), stats.Redelivered)
+	assert.Equal(t, uint64(1), stats.AckChannels["jobs"].Redelivered)
+
+	// Fire-and-forget channels are untouched
+	plain := hub.Subscribe("news", "reader")
+	hub.Publish("news", "hello")
+	msg := <-plain.C
+	assert.Empty(t, msg.AckToken)
+}
+
+func TestHubConsumerGroups(t *testing.T) {
+	hub := pubsub.NewHub()
+	w1 := hub.SubscribeGroup("orders", "billing", "w1")
+	w2 := hub.SubscribeGroup("orders", "billing", "w2")
+	audit := hub.Subscribe("orders", "audit")
+
+	for i := 0; i < 4; i++ {
+		hub.Publish("orders", fmt.Sprintf("order-%d", i))
+	}
+
+	// Each group member gets half; the plain subscriber gets everything
+	assert.Len(t, w1.C, 2)
+	assert.Len(t, w2.C, 2)
+	assert.Len(t, audit.C, 4)
+
+	// The whole group goes offline and misses two messages
+	hub.Unsubscribe("orders", "w1")
+	hub.Unsubscribe("orders", "w2")
+	hub.Publish("orders", "order-4")
+	hub.Publish("orders", "order-5")
+
+	infos := hub.ListChannels()
+	assert.Equal(t, uint64(2), infos[0].Groups[0].Lag)
+
+	back := hub.SubscribeGroup("orders", "billing", "w3")
+	assert.Equal(t, "order-4", (<-back.C).Payload)
+	assert.Equal(t, "order-5", (<-back.C).Payload)
+	assert.Equal(t, uint64(0), hub.ListChannels()[0].Groups[0].Lag)
+}
+
+func TestHubMessageTTL(t *testing.T) {
+	clock := newFakeClock()
+	hub := pubsub.NewHub(pubsub.WithClock(clock), pubsub.WithSweepInterval(10*time.Second))
+
+	hub.Publish("notify", "ephemeral", pubsub.WithTTL(30*time.Second))
+	hub.Publish("notify", "durable")
+
+	history, _ := hub.History("notify", 0)
+	assert.Len(t, history, 2)
+
+	// Expired messages disappear from replay before the sweep runs...
+	clock.Advance(30 * time.Second)
+	history, _ = hub.History("notify", 0)
+	assert.Len(t, history, 1)
+	assert.Equal(t, "durable", history[0].Payload)
+	assert.Equal(t, 1, hub.ListChannels()[0].HistoryLen)
+
+	// ...and a reconnecting consumer group skips them
+	hub.SubscribeGroup("other", "g", "w1")
+	hub.Unsubscribe("other", "w1")
+	hub.Publish("other", "gone", pubsub.WithTTL(time.Second))
+	hub.Publish("other", "kept")
+	clock.Advance(time.Second)
+
+	back := hub.SubscribeGroup("other", "g", "w2")
+	assert.Len(t, back.C, 1)
+	assert.Equal(t, "kept", (<-back.C).Payload)
+}
+
+func TestHubMessageTTLSweep(t *testing.T) {
+	clock := newFakeClock()
+	hub := pubsub.NewHub(pubsub.WithClock(clock), pubsub.WithSweepInterval(10*time.Second))
+
+	hub.Publish("notify", "a", pubsub.WithTTL(5*time.Second))
+	hub.Publish("notify", "b", pubsub.WithTTL(15*time.Second))
+	assert.Equal(t, 2, hub.ListChannels()[0].HistoryLen)
+
+	clock.Advance(10 * time.Second) // first sweep prunes "a" and re-arms for "b"
+	assert.Equal(t, 1, hub.ListChannels()[0].HistoryLen)
+
+	clock.Advance(10 * time.Second)
+	assert.Equal(t, 0, hub.ListChannels()[0].HistoryLen)
+}
+
+func TestHubScheduledDelivery(t *testing.T) {
+	clock := newFakeClock()
+	hub := pubsub.NewHub(pubsub.WithClock(clock))
+	sub := hub.Subscribe("reminders", "r")
+
+	assert.Equal(t, 0, hub.Publish("reminders", "later", pubsub.WithDeliverAt(clock.Now().Add(time.Minute))))
+	assert.Equal(t, 1, hub.Publish("reminders", "now"))
+	assert.Equal(t, 1, hub.ListChannels()[0].Scheduled)
+
+	assert.Equal(t, "now", (<-sub.C).Payload)
+	assert.Len(t, sub.C, 0)
+
+	clock.Advance(59 * time.Second)
+	assert.Len(t, sub.C, 0)
+
+	clock.Advance(time.Second)
+	msg := <-sub.C
+	assert.Equal(t, "later", msg.Payload)
+	assert.Equal(t, uint64(2), msg.ID)
+	assert.Equal(t, 0, hub.ListChannels()[0].Scheduled)
+
+	// Deleting a channel cancels what is still scheduled
+	hub.Publish("reminders", "never", pubsub.WithDeliverAt(clock.Now().Add(time.Minute)))
+	hub.DeleteChannel("reminders")
+	clock.Advance(time.Minute)
+	assert.Empty(t, hub.ListChannels())
+}
+
+func TestChannelEndpoints(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	srv := api.NewServer(eng)
+	mux := http.NewServeMux()
+	srv.RegisterHandlers(mux)
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/api/v1/pub", "application/json",
+		jsonBody(map[string]string{"channel": "alerts", "message": "disk full"}))
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	var channels []pubsub.ChannelInfo
+	getJSON(t, ts.URL+"/api/v1/channels", &channels)
+	assert.Len(t, channels, 1)
+	assert.Equal(t, uint64(1), channels[0].Published)
+
+	var history []pubsub.Message
+	getJSON(t, ts.URL+"/api/v1/channels/alerts/history?limit=10", &history)
+	assert.Len(t, history, 1)
+	assert.Equal(t, "disk full", history[0].Payload)
+
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+"/api/v1/channels/alerts", nil)
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+
+	var stats struct {
+		PubSub pubsub.HubStats `json:"pubsub"`
+	}
+	getJSON(t, ts.URL+"/api/v1/stats", &stats)
+	assert.Equal(t, 0, stats.PubSub.Channels)
+
+	resp, err = http.Get(ts.URL + "/api/v1/channels/alerts/history")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestPubSubDisabled(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	url := serveURL(t, api.NewServer(eng, api.WithHub(nil)))
+	resp, err := http.Post(url+"/api/v1/pub", "application/json",
+		jsonBody(map[string]string{"channel": "alerts", "message": "disk full"}))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// The rest of the API, stats included, works without a hub
+	var report map[string]interface{}
+	getJSON(t, url+"/api/v1/stats", &report)
+	assert.Contains(t, report, "pubsub")
+}
+
+func getJSON(t *testing.T, url string, v interface{}) {
+	t.Helper()
+	resp, err := http.Get(url)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+}
+
+func jsonBody(v interface{}) io.Reader {
+	b, _ := json.Marshal(v)
+	return bytes.NewReader(b)
+}
+
+// fakeClock fires AfterFunc callbacks synchronously from Advance.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) pubsub.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		var due *fakeTimer
+		for i, t := range c.timers {
+			if !t.stopped && !t.at.After(c.now) {
+				due = t
+				c.timers = append(c.timers[:i], c.timers[i+1:]...)
+				break
+			}
+		}
+		c.mu.Unlock()
+		if due == nil {
+			return
+		}
+		due.f()
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	stopped := t.stopped
+	t.stopped = true
+	return !stopped
+}
@@ -0,0 +1,175 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/sql"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func TestQueryCache(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.MemoryConfig()
+	cfg.TextIndex.Fields = []string{"name"}
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	cache, err := sql.NewCache(eng, time.Minute, 3)
+	require.NoError(t, err)
+	defer cache.Close()
+	executor := sql.NewExecutor(eng, sql.WithCache(cache))
+	_, err = executor.Execute(ctx, "INSERT INTO users (id, name) VALUES ('u1', 'Ann'), ('u2', 'Bo')")
+	require.NoError(t, err)
+	name := func(query string) (string, bool) {
+		t.Helper()
+		res, err := executor.Execute(ctx, query)
+		require.NoError(t, err)
+		if res.Cached {
+			assert.Zero(t, res.RowsScanned)
+		}
+		return res.Value.(*types.Record).Data["name"].(string), res.Cached
+	}
+
+	// The same statement, however it is spelled, is answered from the cache
+	got, cached := name("SELECT * FROM users WHERE id = 'u1'")
+	assert.Equal(t, "Ann", got)
+	assert.False(t, cached)
+	got, cached = name("select *  from users where id='u1'")
+	assert.Equal(t, "Ann", got)
+	assert.True(t, cached)
+
+	// A write to another key leaves it; one to its key, through any path,
+	// is seen by the next read
+	require.NoError(t, eng.Put(ctx, "u2", &types.Record{ID: "u2", Data: map[string]interface{}{"name": "Cy"}}))
+	_, cached = name("SELECT * FROM users WHERE id = 'u1'")
+	assert.True(t, cached)
+	require.NoError(t, eng.Put(ctx, "u1", &types.Record{ID: "u1", Data: map[string]interface{}{"name": "Di"}}))
+	got, cached = name("SELECT * FROM users WHERE id = 'u1'")
+	assert.Equal(t, "Di", got)
+	assert.False(t, cached)
+
+	// Any write drops a MATCH, which reads every key
+	match := func() bool {
+		res, err := executor.Execute(ctx, "SELECT * FROM users WHERE name MATCH 'cy'")
+		require.NoError(t, err)
+		assert.Len(t, res.Value, 1)
+		return res.Cached
+	}
+	assert.False(t, match())
+	assert.True(t, match())
+	_, err = executor.Execute(ctx, "DELETE FROM users WHERE id = 'u1'")
+	require.NoError(t, err)
+	assert.False(t, match())
+
+	// Writes, failures and other statements are never cached
+	_, err = executor.Execute(ctx, "SELECT * FROM users WHERE id = 'u1'")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+	st := cache.Stats()
+	assert.EqualValues(t, 3, st.Hits)
+	assert.EqualValues(t, 5, st.Misses)
+	assert.EqualValues(t, 1+2, st.Invalidations)
+	assert.Equal(t, 1, st.Entries)
+
+	// Beyond its entries the least recently used go
+	for _, id := range []string{"u3", "u4", "u5"} {
+		require.NoError(t, eng.Put(ctx, id, &types.Record{ID: id, Data: map[string]interface{}{"name": id}}))
+	}
+	for _, id := range []string{"u2", "u3", "u4", "u5"} {
+		name("SELECT * FROM users WHERE id = '" + id + "'")
+	}
+	_, cached = name("SELECT * FROM users WHERE id = 'u5'")
+	assert.True(t, cached)
+	st = cache.Stats()
+	assert.Equal(t, 3, st.Entries)
+	assert.EqualValues(t, 1, st.Evictions)
+	assert.Equal(t, 3, cache.Flush())
+	_, cached = name("SELECT * FROM users WHERE id = 'u4'")
+	assert.False(t, cached)
+}
+
+func TestQueryCacheExpiry(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	cache, err := sql.NewCache(eng, 500*time.Millisecond, 0)
+	require.NoError(t, err)
+	defer cache.Close()
+	executor := sql.NewExecutor(eng, sql.WithCache(cache))
+	cached := func(id string) bool {
+		t.Helper()
+		res, err := executor.Execute(ctx, "SELECT * FROM t WHERE id = '"+id+"'")
+		require.NoError(t, err)
+		return res.Cached
+	}
+
+	// A result lives for the TTL, or as long as its record if less
+	ttl := time.Now().Add(100 * time.Millisecond)
+	require.NoError(t, eng.Put(ctx, "short", &types.Record{ID: "short", TTL: &ttl}))
+	require.NoError(t, eng.Put(ctx, "long", &types.Record{ID: "long"}))
+	assert.False(t, cached("short"))
+	assert.False(t, cached("long"))
+	assert.True(t, cached("long"))
+	time.Sleep(150 * time.Millisecond)
+	_, err = executor.Execute(ctx, "SELECT * FROM t WHERE id = 'short'")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+	assert.True(t, cached("long"))
+	time.Sleep(400 * time.Millisecond)
+	assert.False(t, cached("long"))
+}
+
+func TestQueryCacheAPI(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	cache, err := sql.NewCache(eng, time.Minute, 0)
+	require.NoError(t, err)
+	defer cache.Close()
+	ts := httptest.NewServer(api.NewServer(eng, api.WithQueryCache(cache)).Handler())
+	defer ts.Close()
+
+	query := func(body interface{}, params string) bool {
+		t.Helper()
+		resp, err := http.Post(ts.URL+"/api/v1/query"+params, "application/json", jsonBody(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var got struct{ Cached bool }
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+		return got.Cached
+	}
+	sel := "SELECT * FROM users WHERE id = 'u1'"
+	query(map[string]string{"query": "INSERT INTO users (id, name) VALUES ('u1', 'Ann')"}, "")
+	assert.False(t, query(map[string]string{"query": sel}, ""))
+	assert.True(t, query(map[string]string{"query": sel}, ""))
+	assert.False(t, query(map[string]interface{}{"query": sel, "cache": false}, ""))
+	assert.False(t, query(map[string]string{"query": sel}, "?cache=false"))
+
+	var report struct {
+		QueryCache *stats.QueryCacheStats `json:"query_cache"`
+	}
+	getJSON(t, ts.URL+"/api/v1/stats", &report)
+	require.NotNil(t, report.QueryCache)
+	assert.EqualValues(t, 1, report.QueryCache.Hits)
+	assert.Equal(t, 1, report.QueryCache.Entries)
+
+	code, body := postBody(t, ts.URL+"/api/v1/admin/query-cache/flush", nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"flushed":1}`, body)
+	assert.False(t, query(map[string]string{"query": sel}, ""))
+
+	_, plain := memoryServer(t)
+	code, _ = postBody(t, plain.URL+"/api/v1/admin/query-cache/flush", nil)
+	assert.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+)
+
+func limitedServer(t *testing.T, read, write api.RateLimit) *httptest.Server {
+	t.Helper()
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	t.Cleanup(func() { eng.Close() })
+
+	srv := api.NewServer(eng, api.WithRateLimits(read, write))
+	mux := http.NewServeMux()
+	srv.RegisterHandlers(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func putKey(t *testing.T, ts *httptest.Server, key string) *http.Response {
+	t.Helper()
+	resp, err := http.Post(ts.URL+"/api/v1/put", "application/json",
+		jsonBody(map[string]interface{}{"key": key, "data": map[string]interface{}{"v": 1}}))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	return resp
+}
+
+func TestRateLimitRejectsWithRetryAfter(t *testing.T) {
+	ts := limitedServer(t, api.RateLimit{}, api.RateLimit{RPS: 0.5, Burst: 2})
+
+	assert.Equal(t, http.StatusCreated, putKey(t, ts, "a").StatusCode)
+	assert.Equal(t, http.StatusCreated, putKey(t, ts, "b").StatusCode)
+
+	resp := putKey(t, ts, "c")
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("Retry-After"))
+
+	// Reads have their own (unlimited) bucket.
+	for i := 0; i < 5; i++ {
+		resp, err := http.Get(ts.URL + "/api/v1/get?key=a")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	var stats struct {
+		RateLimits map[string]api.RateLimiterStats `json:"rate_limits"`
+	}
+	getJSON(t, ts.URL+"/api/v1/stats", &stats)
+	assert.Equal(t, uint64(1), stats.RateLimits["write"].Rejected)
+	assert.Equal(t, 1, stats.RateLimits["write"].Clients)
+	assert.Equal(t, uint64(0), stats.RateLimits["read"].Rejected)
+}
+
+func TestRateLimitAdjustableAtRuntime(t *testing.T) {
+	ts := limitedServer(t, api.RateLimit{}, api.RateLimit{RPS: 0.1, Burst: 1})
+
+	assert.Equal(t, http.StatusCreated, putKey(t, ts, "a").StatusCode)
+	assert.Equal(t, http.StatusTooManyRequests, putKey(t, ts, "b").StatusCode)
+
+	// Writes through SQL draw from the write bucket too.
+	resp, err := http.Post(ts.URL+"/api/v1/query", "application/json",
+		jsonBody(map[string]string{"query": "DELETE FROM kv WHERE key = 'a'"}))
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/api/v1/admin/rate-limits",
+		jsonBody(map[string]interface{}{"write": map[string]interface{}{"rps": 0}}))
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var limits map[string]api.RateLimiterStats
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&limits))
+	assert.Equal(t, float64(0), limits["write"].RPS)
+
+	assert.Equal(t, http.StatusCreated, putKey(t, ts, "b").StatusCode)
+}
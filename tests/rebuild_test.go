@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func TestRebuildIndexes(t *testing.T) {
+	ctx := context.Background()
+	const n = 1000 // four chunks
+	for _, mode := range []types.Mode{types.ModeMemory, types.ModeDisk, types.ModeColumnar, types.ModeVector, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.VectorConfig(4)
+			cfg.Mode, cfg.DataDir = mode, t.TempDir()
+			cfg.TextIndex.Fields = []string{"description"}
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+
+			put := func(key, tag, text string, i int) {
+				require.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, Tags: []string{tag},
+					Data: map[string]interface{}{"description": text}, Vector: unitVector(4, i)}))
+			}
+			for i := range n {
+				put(fmt.Sprintf("doc:%04d", i), "old", "alpha", i)
+			}
+
+			// Writes made while the rebuild is under way reach the new
+			// indexes, whether or not it has read their keys yet; reads
+			// meanwhile use the old ones
+			rebuilder := eng.(types.IndexRebuilder)
+			var calls, last, total int
+			require.NoError(t, rebuilder.RebuildIndexes(ctx, func(done, all int) {
+				if calls++; calls == 2 {
+					put("doc:0000", "new", "beta", 1)
+					put("doc:0999", "new", "beta", 1)
+					put("late", "new", "beta", 1)
+					require.NoError(t, eng.Delete(ctx, "doc:0500"))
+					assert.Equal(t, []string{"doc:0000", "doc:0999", "late"}, tagScan(t, eng, "new", ""))
+				}
+				last, total = done, all
+			}))
+			assert.Equal(t, total, last)
+			assert.GreaterOrEqual(t, total, n)
+
+			assert.Equal(t, []string{"doc:0000", "doc:0999", "late"}, tagScan(t, eng, "new", ""))
+			assert.Len(t, tagScan(t, eng, "old", ""), n-3)
+			assert.Equal(t, []string{"doc:0000", "doc:0999", "late"}, searchKeys(t, eng, "beta"))
+			assert.Len(t, searchKeys(t, eng, "alpha"), n-3)
+			if mode == types.ModeVector || mode == types.ModeHybrid {
+				s := eng.(types.Searcher)
+				found, err := s.Search(ctx, unitVector(4, 1), n)
+				require.NoError(t, err)
+				assert.Len(t, found, n)
+				stats := eng.(types.StatsReporter).Stats()
+				assert.Equal(t, n, stats.Vector.Nodes)
+			}
+
+			// Writes after the swap go to the new indexes alone
+			put("after", "new", "beta", 2)
+			assert.Contains(t, tagScan(t, eng, "new", ""), "after")
+
+			// Cancelled, the old indexes stay, and can be rebuilt again
+			cancelled, cancel := context.WithCancel(ctx)
+			err = rebuilder.RebuildIndexes(cancelled, func(done, all int) {
+				if done > 0 {
+					cancel()
+				}
+			}, types.IndexTags)
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.Len(t, tagScan(t, eng, "new", ""), 4)
+			require.NoError(t, rebuilder.RebuildIndexes(ctx, func(int, int) {}, types.IndexTags))
+			assert.Len(t, tagScan(t, eng, "new", ""), 4)
+
+			assert.ErrorContains(t, rebuilder.RebuildIndexes(ctx, func(int, int) {}, "btree"), `unknown index kind "btree"`)
+			if mode != types.ModeVector && mode != types.ModeHybrid {
+				assert.ErrorContains(t, rebuilder.RebuildIndexes(ctx, func(int, int) {}, types.IndexVector), "no vector index")
+			}
+		})
+	}
+}
+
+func TestRebuildIndexesJob(t *testing.T) {
+	eng, err := kvi.OpenMemory()
+	require.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	ctx := context.Background()
+	for i := range 300 {
+		key := fmt.Sprintf("k%03d", i)
+		require.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, Tags: []string{"t"}}))
+	}
+
+	job, code := startJob(t, ts.URL+"/api/v1/admin/rebuild-indexes?kinds=tags,text")
+	assert.Equal(t, http.StatusAccepted, code)
+	job = waitJob(t, ts.URL, job.ID)
+	assert.Equal(t, "succeeded", job.Status)
+	assert.Equal(t, 300, job.Done)
+	assert.Equal(t, 300, job.Total)
+	assert.Len(t, tagScan(t, eng, "t", ""), 300)
+
+	job, _ = startJob(t, ts.URL+"/api/v1/admin/rebuild-indexes")
+	assert.Equal(t, "succeeded", waitJob(t, ts.URL, job.ID).Status)
+
+	// The memory engine has no vector index; unknown kinds are refused
+	// before a job starts
+	job, _ = startJob(t, ts.URL+"/api/v1/admin/rebuild-indexes?kinds=vector")
+	job = waitJob(t, ts.URL, job.ID)
+	assert.Equal(t, "failed", job.Status)
+	assert.Contains(t, job.Error, "no vector index")
+	_, code = startJob(t, ts.URL+"/api/v1/admin/rebuild-indexes?kinds=btree")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// bigData is about 8 KB of JSON that compresses well, as documents do.
+func bigData(i float64) map[string]interface{} {
+	return map[string]interface{}{
+		"i":    i,
+		"body": strings.Repeat("the quick brown fox jumps over the lazy dog ", 180),
+		"tags": []interface{}{"a", "b"},
+	}
+}
+
+func TestRecordCompression(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []types.Mode{types.ModeMemory, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Mode, cfg.DataDir, cfg.RecordCompressMinBytes = mode, t.TempDir(), 1024
+			cfg.EnableWAL = mode == types.ModeHybrid
+			assert.Empty(t, cfg.Warnings())
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+
+			require.NoError(t, eng.Put(ctx, "big", &types.Record{Data: bigData(1)}))
+			require.NoError(t, eng.Put(ctx, "small", &types.Record{Data: map[string]interface{}{"i": 2.0}}))
+
+			rec, err := eng.Get(ctx, "big")
+			require.NoError(t, err)
+			assert.Equal(t, bigData(1), rec.Data)
+			assert.Nil(t, rec.Packed)
+			assert.EqualValues(t, 1, rec.Version)
+
+			// Every read and write path sees the data
+			rec, err = eng.Update(ctx, "big", func(r *types.Record) error {
+				r.Data["i"] = 3.0
+				return nil
+			})
+			require.NoError(t, err)
+			assert.EqualValues(t, 2, rec.Version)
+			var scanned []map[string]interface{}
+			require.NoError(t, eng.Scan(ctx, "", func(rec *types.Record) bool {
+				scanned = append(scanned, rec.Data)
+				return true
+			}))
+			assert.Equal(t, []map[string]interface{}{bigData(3), {"i": 2.0}}, scanned)
+			found, err := eng.(types.Batcher).BatchGet(ctx, []string{"big"})
+			require.NoError(t, err)
+			assert.Equal(t, bigData(3), found["big"].Data)
+
+			stats := eng.(types.StatsReporter).Stats()
+			require.NotNil(t, stats.Compression)
+			assert.EqualValues(t, 2, stats.Compression.Records, "the put and the update; small is left alone")
+			assert.Greater(t, stats.Compression.SavedBytes, stats.Compression.PackedBytes*10)
+			assert.Equal(t, stats.Compression.RawBytes-stats.Compression.PackedBytes, stats.Compression.SavedBytes)
+		})
+	}
+
+	cfg := config.MemoryConfig()
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	assert.Nil(t, eng.(types.StatsReporter).Stats().Compression, "off by default")
+
+	cfg.Mode, cfg.RecordCompressMinBytes = types.ModeColumnar, 1024
+	assert.Equal(t, []string{"record_compress_min_bytes has no effect in columnar mode"}, cfg.Warnings())
+}
+
+func TestWALRecordCompression(t *testing.T) {
+	ctx := context.Background()
+	sizes := map[int]int64{}
+	for _, minBytes := range []int{0, 1024} {
+		cfg := config.DiskConfig()
+		cfg.DataDir, cfg.RecordCompressMinBytes = t.TempDir(), minBytes
+		eng, err := kvi.Open(cfg)
+		require.NoError(t, err)
+		for _, key := range []string{"a", "b", "c"} {
+			require.NoError(t, eng.Put(ctx, key, &types.Record{Data: bigData(1)}))
+		}
+		require.NoError(t, eng.Put(ctx, "small", &types.Record{Data: map[string]interface{}{"i": 2.0}}))
+		walStats := eng.(types.StatsReporter).Stats().WAL
+		if minBytes > 0 {
+			require.NotNil(t, walStats.Compression)
+			assert.EqualValues(t, 3, walStats.Compression.Records)
+		} else {
+			assert.Nil(t, walStats.Compression)
+		}
+		require.NoError(t, eng.Close())
+		info, err := os.Stat(filepath.Join(cfg.DataDir, wal.FileName))
+		require.NoError(t, err)
+		sizes[minBytes] = info.Size()
+
+		// Recovery reads the packed entries back
+		eng, err = kvi.Open(cfg)
+		require.NoError(t, err)
+		rec, err := eng.Get(ctx, "b")
+		require.NoError(t, err)
+		assert.Equal(t, bigData(1), rec.Data)
+		require.NoError(t, eng.Close())
+
+		// The checksum covers each entry with its record uncompressed, so
+		// it is the same whether the record was packed or not
+		for _, frame := range scanWAL(t, filepath.Join(cfg.DataDir, wal.FileName)) {
+			require.True(t, frame.Valid())
+			e := frame.Entry
+			assert.Nil(t, e.Packed)
+			canonical, err := json.Marshal(&wal.LogEntry{LSN: e.LSN, Timestamp: e.Timestamp, Op: e.Op, Key: e.Key, Record: e.Record})
+			require.NoError(t, err)
+			assert.Equal(t, crc32.ChecksumIEEE(canonical), e.Checksum)
+		}
+	}
+	assert.Less(t, sizes[1024]*5, sizes[0])
+
+	// A damaged packed record fails its checksum
+	dir := t.TempDir()
+	w, err := wal.NewWAL(dir)
+	require.NoError(t, err)
+	w.Compress(1024)
+	require.NoError(t, w.WriteEntry(types.OpPut, "k", &types.Record{Data: bigData(1)}))
+	require.NoError(t, w.Close())
+	path := filepath.Join(dir, wal.FileName)
+	frames := scanWAL(t, path)
+	require.True(t, frames[0].Valid())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)-2] ^= 1 // the checksum's last digit
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	assert.ErrorIs(t, scanWAL(t, path)[0].Err, wal.ErrChecksum)
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// writeLog leaves a disk-mode WAL in dir with n keys, each written three
+// times and every tenth deleted.
+func writeLog(t *testing.T, dir string, n int) {
+	t.Helper()
+	ctx := context.Background()
+	eng := openDisk(t, dir)
+	for round := range 3 {
+		for i := range n {
+			key := fmt.Sprintf("key%05d", i)
+			require.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, Data: map[string]interface{}{"round": round}}))
+		}
+	}
+	for i := 0; i < n; i += 10 {
+		require.NoError(t, eng.Delete(ctx, fmt.Sprintf("key%05d", i)))
+	}
+	require.NoError(t, eng.Close())
+}
+
+func openRecovering(t *testing.T, dir string, startup string, workers int) types.Engine {
+	t.Helper()
+	cfg := config.DiskConfig()
+	cfg.DataDir, cfg.RecoveryStartup, cfg.RecoveryParallelism = dir, startup, workers
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	return eng
+}
+
+func snapshot(t *testing.T, eng types.Engine) map[string]types.Record {
+	t.Helper()
+	out := map[string]types.Record{}
+	require.NoError(t, eng.Scan(context.Background(), "", func(rec *types.Record) bool {
+		out[rec.ID] = *rec
+		return true
+	}))
+	return out
+}
+
+func TestRecoveryParallel(t *testing.T) {
+	dir := t.TempDir()
+	writeLog(t, dir, 500)
+
+	serial := openRecovering(t, dir, config.RecoveryBlock, 1)
+	want := snapshot(t, serial)
+	require.NoError(t, serial.Close())
+	assert.Len(t, want, 450)
+	assert.Equal(t, 2.0, toFloat(want["key00001"].Data["round"]), "the last write wins")
+
+	for _, workers := range []int{2, 8} {
+		eng := openRecovering(t, dir, config.RecoveryBlock, workers)
+		assert.Equal(t, want, snapshot(t, eng), "workers=%d", workers)
+		s := eng.(types.Recoverer).RecoveryStatus()
+		assert.False(t, s.Recovering)
+		assert.Equal(t, 100.0, s.Percent)
+		assert.Equal(t, int64(1550), s.Entries)
+		assert.Equal(t, s.BytesTotal, s.BytesDone)
+		require.NoError(t, eng.Close())
+	}
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int:
+		return float64(n)
+	}
+	return -1
+}
+
+func TestRecoveryServeReads(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeLog(t, dir, 5000)
+
+	eng := openRecovering(t, dir, config.RecoveryServeReads, 1)
+	defer eng.Close()
+	rec := eng.(types.Recoverer)
+	if rec.RecoveryStatus().Recovering {
+		err := eng.Put(ctx, "new", &types.Record{ID: "new"})
+		assert.ErrorIs(t, err, types.ErrRecovering)
+		_, err = eng.Get(ctx, "key00001")
+		if err != nil {
+			assert.ErrorIs(t, err, types.ErrKeyNotFound, "reads see what has been replayed so far")
+		}
+	}
+	require.Eventually(t, func() bool { return !rec.RecoveryStatus().Recovering }, 10*time.Second, 5*time.Millisecond)
+	assert.Equal(t, 100.0, rec.RecoveryStatus().Percent)
+	assert.Len(t, snapshot(t, eng), 4500)
+	require.NoError(t, eng.Put(ctx, "new", &types.Record{ID: "new"}))
+}
+
+func TestRecoveryBackground(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeLog(t, dir, 5000)
+
+	eng := openRecovering(t, dir, config.RecoveryBackground, 1)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+	if eng.(types.Recoverer).RecoveryStatus().Recovering {
+		_, err := eng.Get(ctx, "key00001")
+		assert.ErrorIs(t, err, types.ErrRecovering)
+		body, code := ready(t, ts.URL)
+		if body.Checks["recovery"].Status != "ok" {
+			assert.Equal(t, 503, code)
+			assert.Contains(t, body.Checks["recovery"].Error, "recovering")
+		}
+	}
+	assert.Eventually(t, func() bool {
+		_, code := ready(t, ts.URL)
+		return code == 200
+	}, 10*time.Second, 10*time.Millisecond)
+	_, err := eng.Get(ctx, "key00001")
+	assert.NoError(t, err)
+}
+
+func TestRecoveryCloseWhileRecovering(t *testing.T) {
+	dir := t.TempDir()
+	writeLog(t, dir, 5000)
+	eng := openRecovering(t, dir, config.RecoveryBackground, 4)
+	require.NoError(t, eng.Close(), "closing stops the replay")
+
+	eng = openRecovering(t, dir, config.RecoveryBlock, 1)
+	defer eng.Close()
+	assert.Len(t, snapshot(t, eng), 4500, "an interrupted replay leaves the WAL whole")
+}
+
+func TestRecoveryFailedInBackground(t *testing.T) {
+	dir := t.TempDir()
+	writeLog(t, dir, 10)
+	path := filepath.Join(dir, wal.FileName)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)/2] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	eng := openRecovering(t, dir, config.RecoveryServeReads, 1)
+	defer eng.Close()
+	rec := eng.(types.Recoverer)
+	require.Eventually(t, func() bool { return rec.RecoveryStatus().Error != "" }, 5*time.Second, 5*time.Millisecond)
+	assert.False(t, rec.RecoveryStatus().Recovering)
+	_, err = eng.Get(context.Background(), "key00001")
+	assert.ErrorIs(t, err, types.ErrRecovering, "reads stop once the replay has failed")
+}
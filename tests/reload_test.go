@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/auth"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+)
+
+func TestServerReconfigure(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+	srv := api.NewServer(eng, api.WithBodyLimits(64, 64))
+	ts := serveURL(t, srv)
+
+	big := `{"key":"k","data":{"text":"` + strings.Repeat("x", 100) + `"}}`
+	code, _ := postBody(t, ts+"/api/v1/put", strings.NewReader(big))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, "*", preflight(t, ts+"/api/v1/get", "https://other.test", "GET").Header.Get("Access-Control-Allow-Origin"))
+
+	policy := config.DefaultCORS()
+	policy.AllowedOrigins = []string{"https://app.example.com"}
+	srv.Reconfigure(api.WithBodyLimits(1024, 1024), api.WithCORS(policy))
+
+	code, _ = postBody(t, ts+"/api/v1/put", strings.NewReader(big))
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Empty(t, preflight(t, ts+"/api/v1/get", "https://other.test", "GET").Header.Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "https://app.example.com",
+		preflight(t, ts+"/api/v1/get", "https://app.example.com", "GET").Header.Get("Access-Control-Allow-Origin"))
+}
+
+func TestConfigEndpoints(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	// Without a source both endpoints say so
+	ts := serveURL(t, api.NewServer(eng))
+	resp, err := http.Get(ts + "/api/v1/admin/config")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+
+	current := config.MemoryConfig()
+	current.JWTSecret = "a-secret-nobody-should-see"
+	var reloadErr error
+	src := api.ConfigSource{
+		Current: func() *config.Config { return current },
+		Reload: func() (*config.ReloadReport, error) {
+			if reloadErr != nil {
+				return nil, reloadErr
+			}
+			next := *current
+			next.LogLevel, next.Port = "warn", 9999
+			var report *config.ReloadReport
+			current, report = config.Reload(current, &next)
+			return report, nil
+		},
+	}
+	ts = serveURL(t, api.NewServer(eng, api.WithConfig(src)))
+
+	var shown map[string]interface{}
+	getJSON(t, ts+"/api/v1/admin/config", &shown)
+	assert.Equal(t, "memory", shown["mode"])
+	assert.Equal(t, "<redacted>", shown["jwt_secret"])
+
+	resp, err = http.Post(ts+"/api/v1/admin/reload", "application/json", nil)
+	assert.NoError(t, err)
+	var report config.ReloadReport
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "log_level", report.Applied[0].Key)
+	assert.Equal(t, "port", report.RestartRequired[0].Key)
+
+	// The new values show at once; the restart-only ones do not
+	getJSON(t, ts+"/api/v1/admin/config", &shown)
+	assert.Equal(t, "warn", shown["log_level"])
+	assert.EqualValues(t, 8080, shown["port"])
+
+	reloadErr = errors.New("kvi.yaml: unknown field \"prot\"")
+	code, msg := postBody(t, ts+"/api/v1/admin/reload", nil)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Contains(t, msg, `reload failed: kvi.yaml: unknown field \"prot\"`)
+}
+
+func TestAuthenticatorUpdate(t *testing.T) {
+	a := newTestAuth(t)
+	old, _, err := a.Issue("someone", auth.RoleRead)
+	assert.NoError(t, err)
+
+	assert.Error(t, a.Update([]byte("short"), time.Hour, nil))
+	_, err = a.Verify(old) // a rejected update changes nothing
+	assert.NoError(t, err)
+
+	assert.NoError(t, a.Update([]byte("a-brand-new-secret-0123456789"), time.Minute,
+		auth.StaticKeys(map[string]auth.Role{"new-key": auth.RoleAdmin})))
+	_, err = a.Verify(old)
+	assert.ErrorIs(t, err, auth.ErrInvalidToken)
+	_, _, err = a.Login(t.Context(), "reader-key")
+	assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	token, claims, err := a.Login(t.Context(), "new-key")
+	assert.NoError(t, err)
+	assert.Equal(t, auth.RoleAdmin, claims.Role)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), claims.ExpiresAt.Time, 5*time.Second)
+	_, err = a.Verify(token)
+	assert.NoError(t, err)
+}
+
+// serveURL serves srv's full handler for the test and returns its URL.
+func serveURL(t *testing.T, srv *api.Server) string {
+	t.Helper()
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
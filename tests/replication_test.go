@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/internal/replication"
+	"github.com/thirawat27/kvi/pkg/client"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func scanAll(t *testing.T, eng types.Engine) map[string]*types.Record {
+	t.Helper()
+	recs := map[string]*types.Record{}
+	require.NoError(t, eng.Scan(context.Background(), "", func(rec *types.Record) bool {
+		recs[rec.ID] = rec
+		return true
+	}))
+	return recs
+}
+
+func record(key string, n int) *types.Record {
+	return &types.Record{ID: key, Data: map[string]any{"n": float64(n)}} // as JSON decodes it
+}
+
+// A follower syncs from a snapshot, streams the primary's WAL until it
+// matches it record for record, refuses writes, and takes them once
+// promoted.
+func TestReplication(t *testing.T) {
+	ctx := context.Background()
+	primaryCfg := config.DiskConfig()
+	primaryCfg.DataDir = t.TempDir()
+	primary, err := kvi.Open(primaryCfg)
+	require.NoError(t, err)
+	defer primary.Close()
+	for i := range 20 {
+		require.NoError(t, primary.Put(ctx, fmt.Sprintf("before:%02d", i), record(fmt.Sprintf("before:%02d", i), i)))
+	}
+
+	replicaCfg := config.DiskConfig()
+	replicaCfg.DataDir = t.TempDir()
+	replica, err := kvi.Open(replicaCfg)
+	require.NoError(t, err)
+	defer replica.Close()
+	require.NoError(t, replica.Put(ctx, "stale", record("stale", -1)))
+
+	_, grpcAddr := clientServer(t, primary, pubsub.NewHub(), nil)
+	f, err := replication.New(replica, newClient(t, client.WithGRPC(grpcAddr)), grpcAddr,
+		replication.WithRetryInterval(10*time.Millisecond))
+	require.NoError(t, err)
+	f.Start()
+	defer f.Stop()
+
+	for i := range 50 {
+		require.NoError(t, primary.Put(ctx, fmt.Sprintf("after:%02d", i), record(fmt.Sprintf("after:%02d", i), i)))
+		if i%5 == 0 {
+			require.NoError(t, primary.Delete(ctx, fmt.Sprintf("before:%02d", i/5)))
+		}
+	}
+	_, err = primary.Update(ctx, "after:00", func(rec *types.Record) error {
+		rec.Data["n"] = "updated"
+		return nil
+	})
+	require.NoError(t, err)
+
+	lsn := primary.(types.LogShipper).LSN()
+	require.Eventually(t, func() bool {
+		st := f.Stats()
+		return st.State == replication.StateStreaming && st.AppliedLSN == lsn
+	}, 5*time.Second, 10*time.Millisecond)
+
+	want, got := scanAll(t, primary), scanAll(t, replica)
+	assert.Len(t, got, len(want))
+	assert.NotContains(t, got, "stale")
+	for key, rec := range want {
+		if assert.Contains(t, got, key) {
+			assert.Equal(t, rec.Version, got[key].Version, key)
+			assert.Equal(t, rec.Data, got[key].Data, key)
+			assert.True(t, rec.UpdatedAt.Equal(got[key].UpdatedAt), key)
+		}
+	}
+
+	st := f.Stats()
+	assert.Equal(t, 1, st.Syncs)
+	assert.Zero(t, st.Lag)
+	assert.False(t, st.LastContact.IsZero())
+
+	assert.ErrorIs(t, replica.Put(ctx, "local", record("local", 0)), types.ErrReadOnly)
+	assert.ErrorIs(t, replica.Delete(ctx, "after:01"), types.ErrReadOnly)
+
+	require.NoError(t, f.Promote())
+	assert.ErrorIs(t, f.Promote(), replication.ErrPromoted)
+	assert.Equal(t, replication.StatePromoted, f.Stats().State)
+	assert.NoError(t, replica.Put(ctx, "local", record("local", 0)))
+}
+
+// A follower too far behind for the primary's retained history, here one
+// asking for changes before a restart, is told so.
+func TestReplicateHistoryUnavailable(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.DiskConfig()
+	cfg.DataDir = t.TempDir()
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	require.NoError(t, eng.Put(ctx, "a", record("a", 0)))
+	require.NoError(t, eng.Close())
+
+	eng, err = kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	_, grpcAddr := clientServer(t, eng, pubsub.NewHub(), nil)
+	c := newClient(t, client.WithGRPC(grpcAddr))
+
+	err = c.Replicate(ctx, 0, func(types.ChangeEvent, bool) error { return nil })
+	assert.ErrorIs(t, err, types.ErrHistoryUnavailable)
+
+	mem, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer mem.Close()
+	_, memAddr := clientServer(t, mem, pubsub.NewHub(), nil)
+	err = newClient(t, client.WithGRPC(memAddr)).Replicate(ctx, 0, func(types.ChangeEvent, bool) error { return nil })
+	assert.Error(t, err)
+}
@@ -0,0 +1,208 @@
+package tests
+
+import (
+	"context"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/auth"
+	"github.com/thirawat27/kvi/pkg/collection"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/resp"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// respServer serves eng on a local port until the test ends and returns
+// its address.
+func respServer(t *testing.T, eng types.Engine, hub *pubsub.Hub, opts ...func(*resp.Server)) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- resp.NewServer(eng, hub, opts...).Serve(ctx, lis) }()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	return lis.Addr().String()
+}
+
+func respClient(t *testing.T, addr, password string) *redis.Client {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: addr, Password: password})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+func TestRESPCommands(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	rdb := respClient(t, respServer(t, eng, nil), "")
+
+	assert.Equal(t, "PONG", rdb.Ping(ctx).Val())
+
+	// Strings, and their TTLs
+	require.NoError(t, rdb.Set(ctx, "greeting", "hello", 0).Err())
+	assert.Equal(t, "hello", rdb.Get(ctx, "greeting").Val())
+	assert.Equal(t, time.Duration(-1), rdb.TTL(ctx, "greeting").Val())
+	assert.ErrorIs(t, rdb.Get(ctx, "missing").Err(), redis.Nil)
+	assert.Equal(t, time.Duration(-2), rdb.TTL(ctx, "missing").Val())
+
+	require.NoError(t, rdb.Set(ctx, "session", "abc", time.Minute).Err())
+	ttl := rdb.TTL(ctx, "session").Val()
+	assert.True(t, ttl > 50*time.Second && ttl <= time.Minute, "ttl %v", ttl)
+	assert.True(t, rdb.Expire(ctx, "greeting", time.Hour).Val())
+	assert.False(t, rdb.Expire(ctx, "missing", time.Hour).Val())
+	assert.Greater(t, rdb.TTL(ctx, "greeting").Val(), 59*time.Minute)
+
+	require.NoError(t, rdb.Set(ctx, "flash", "gone soon", 50*time.Millisecond).Err())
+	assert.Eventually(t, func() bool { return rdb.Exists(ctx, "flash").Val() == 0 }, 2*time.Second, 10*time.Millisecond)
+
+	assert.True(t, rdb.SetNX(ctx, "once", "1", 0).Val())
+	assert.False(t, rdb.SetNX(ctx, "once", "2", 0).Val())
+	assert.False(t, rdb.SetXX(ctx, "never", "1", 0).Val())
+	assert.Equal(t, "1", rdb.Get(ctx, "once").Val())
+
+	// Counters
+	assert.Equal(t, int64(1), rdb.Incr(ctx, "hits").Val())
+	assert.Equal(t, int64(11), rdb.IncrBy(ctx, "hits", 10).Val())
+	assert.Equal(t, int64(10), rdb.Decr(ctx, "hits").Val())
+	assert.Equal(t, int64(5), rdb.DecrBy(ctx, "hits", 5).Val())
+	assert.Equal(t, "5", rdb.Get(ctx, "hits").Val())
+	assert.ErrorContains(t, rdb.Incr(ctx, "greeting").Err(), "not an integer")
+
+	// Several keys at once
+	require.NoError(t, rdb.MSet(ctx, "user:1", "ann", "user:2", "bob", "user:3", "cy").Err())
+	assert.Equal(t, []any{"ann", nil, "cy"}, rdb.MGet(ctx, "user:1", "user:9", "user:3").Val())
+	assert.Equal(t, int64(2), rdb.Exists(ctx, "user:1", "user:2", "user:9").Val())
+
+	var keys []string
+	iter := rdb.Scan(ctx, 0, "user:*", 2).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	require.NoError(t, iter.Err())
+	sort.Strings(keys)
+	assert.Equal(t, []string{"user:1", "user:2", "user:3"}, keys)
+
+	assert.Equal(t, int64(2), rdb.Del(ctx, "user:1", "user:2", "user:9").Val())
+	assert.Equal(t, int64(0), rdb.Exists(ctx, "user:1").Val())
+
+	// Records written through the other APIs read back as JSON
+	require.NoError(t, eng.Put(ctx, "doc", &types.Record{ID: "doc", Data: map[string]any{"name": "kvi"}}))
+	assert.JSONEq(t, `{"name":"kvi"}`, rdb.Get(ctx, "doc").Val())
+
+	info := rdb.Info(ctx).Val()
+	assert.Contains(t, info, "# Server")
+	assert.Contains(t, info, "kvi_mode:memory")
+	assert.Contains(t, info, "# Keyspace")
+
+	// Errors come back as Redis sends them
+	err = rdb.Do(ctx, "FLUSHALL").Err()
+	assert.EqualError(t, err, "ERR unknown command 'flushall', with args beginning with: ")
+	assert.ErrorContains(t, rdb.Do(ctx, "GET").Err(), "ERR wrong number of arguments for 'get' command")
+	assert.ErrorContains(t, rdb.Do(ctx, "SET", "k", "v", "EX", "soon").Err(), "ERR")
+	assert.Equal(t, "PONG", rdb.Ping(ctx).Val(), "an error leaves the connection usable")
+}
+
+func TestRESPPubSub(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	hub := pubsub.NewHub()
+	rdb := respClient(t, respServer(t, eng, hub), "")
+
+	sub := rdb.Subscribe(ctx, "news")
+	defer sub.Close()
+	_, err = sub.Receive(ctx) // the confirmation
+	require.NoError(t, err)
+
+	// Published over RESP and by the other APIs alike
+	assert.Equal(t, int64(1), rdb.Publish(ctx, "news", "from redis").Val())
+	hub.Publish("news", "from the hub")
+	for _, want := range []string{"from redis", "from the hub"} {
+		msg, err := sub.ReceiveMessage(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "news", msg.Channel)
+		assert.Equal(t, want, msg.Payload)
+	}
+
+	require.NoError(t, sub.Unsubscribe(ctx, "news"))
+	assert.Eventually(t, func() bool { return rdb.Publish(ctx, "news", "nobody").Val() == 0 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestRESPAuth(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	a, err := auth.New(testSecret, time.Hour, auth.StaticKeys(map[string]auth.Role{
+		"reader-key": auth.RoleRead,
+		"writer-key": auth.RoleWrite,
+	}))
+	require.NoError(t, err)
+	addr := respServer(t, eng, nil, resp.WithAuth(a))
+
+	anon := respClient(t, addr, "")
+	assert.ErrorContains(t, anon.Get(ctx, "k").Err(), "NOAUTH")
+
+	assert.ErrorContains(t, respClient(t, addr, "wrong-key").Ping(ctx).Err(), "WRONGPASS")
+
+	writer := respClient(t, addr, "writer-key")
+	require.NoError(t, writer.Set(ctx, "k", "v", 0).Err())
+
+	reader := respClient(t, addr, "reader-key")
+	assert.Equal(t, "v", reader.Get(ctx, "k").Val())
+	assert.ErrorContains(t, reader.Set(ctx, "k", "w", 0).Err(), "NOPERM")
+
+	// A token from the REST login works as the password too
+	token, _, err := a.Login(ctx, "writer-key")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), respClient(t, addr, token).Del(ctx, "k").Val())
+}
+
+func TestRESPPortValidation(t *testing.T) {
+	cfg := config.MemoryConfig()
+	cfg.RESPPort = 70000
+	assert.ErrorContains(t, cfg.Validate(), "resp_port")
+}
+
+func TestRESPCollections(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	rdb := respClient(t, respServer(t, eng, nil), "")
+
+	assert.Equal(t, int64(2), rdb.RPush(ctx, "queue", "b", "c").Val())
+	assert.Equal(t, int64(4), rdb.LPush(ctx, "queue", "a", "z").Val())
+	assert.Equal(t, []string{"z", "a", "b", "c"}, rdb.LRange(ctx, "queue", 0, -1).Val())
+	assert.Equal(t, "z", rdb.LPop(ctx, "queue").Val())
+	assert.Equal(t, "c", rdb.RPop(ctx, "queue").Val())
+	assert.ErrorIs(t, rdb.LPop(ctx, "missing").Err(), redis.Nil)
+
+	assert.Equal(t, int64(2), rdb.SAdd(ctx, "tags", "go", "db", "go").Val())
+	assert.Equal(t, []string{"db", "go"}, rdb.SMembers(ctx, "tags").Val())
+	assert.True(t, rdb.SIsMember(ctx, "tags", "go").Val())
+	assert.Equal(t, int64(1), rdb.SRem(ctx, "tags", "go", "kv").Val())
+
+	// Lists and sets are shared with the other APIs
+	members, err := collection.SMembers(ctx, eng, "tags")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"db"}, members)
+
+	assert.ErrorContains(t, rdb.LPush(ctx, "tags", "x").Err(), "WRONGTYPE")
+	require.NoError(t, rdb.Set(ctx, "greeting", "hello", 0).Err())
+	assert.ErrorContains(t, rdb.SAdd(ctx, "greeting", "x").Err(), "WRONGTYPE")
+}
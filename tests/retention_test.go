@@ -0,0 +1,298 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/retention"
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func putKeys(t *testing.T, eng types.Engine, keys ...string) {
+	t.Helper()
+	for _, k := range keys {
+		require.NoError(t, eng.Put(context.Background(), k, &types.Record{ID: k, Data: map[string]interface{}{"k": k}}))
+	}
+}
+
+func engineKeys(t *testing.T, eng types.Engine, prefix string) []string {
+	t.Helper()
+	var keys []string
+	require.NoError(t, eng.Scan(context.Background(), prefix, func(rec *types.Record) bool {
+		keys = append(keys, rec.ID)
+		return true
+	}))
+	return keys
+}
+
+func TestRetentionMaxCount(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	for i := range 10 {
+		putKeys(t, eng, fmt.Sprintf("a:%d", i))
+	}
+	putKeys(t, eng, "b:1")
+	ret := retention.New(eng, retention.Options{Samples: 3})
+	defer ret.Close()
+	require.NoError(t, ret.Set(ctx, retention.Rule{Prefix: "a:", MaxCount: 3}))
+
+	// A dry run lists the oldest and deletes nothing
+	results, err := ret.Run(ctx, "a:", true)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].DryRun)
+	assert.EqualValues(t, 10, results[0].Matched)
+	assert.EqualValues(t, 7, results[0].Removed)
+	assert.Equal(t, []string{"a:0", "a:1", "a:2"}, results[0].Keys)
+	assert.Len(t, engineKeys(t, eng, "a:"), 10)
+
+	results, err = ret.Run(ctx, "", false)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.EqualValues(t, 7, results[0].Removed)
+	assert.Empty(t, results[0].Keys)
+	assert.Equal(t, []string{"a:7", "a:8", "a:9"}, engineKeys(t, eng, "a:"))
+	assert.Equal(t, []string{"b:1"}, engineKeys(t, eng, "b:"))
+
+	st, err := ret.Stats(ctx)
+	require.NoError(t, err)
+	require.Len(t, st.Rules, 1)
+	rule := st.Rules[0]
+	assert.Equal(t, "a:", rule.Prefix)
+	assert.EqualValues(t, 3, rule.MaxCount)
+	assert.EqualValues(t, 1, rule.Runs, "dry runs asked for are not counted")
+	assert.EqualValues(t, 7, rule.Removed)
+	assert.EqualValues(t, 7, rule.LastRemoved)
+	assert.EqualValues(t, 10, rule.LastMatched)
+	assert.True(t, rule.NextRun.IsZero(), "nothing is scheduled")
+
+	_, err = ret.Run(ctx, "c:", false)
+	assert.ErrorIs(t, err, retention.ErrNoRule)
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	putKeys(t, eng, "ev:1", "ev:2")
+	time.Sleep(1100 * time.Millisecond)
+	putKeys(t, eng, "ev:3")
+	putKeys(t, eng, "ev:1") // written again, so new
+
+	ret := retention.New(eng, retention.Options{})
+	defer ret.Close()
+	require.NoError(t, ret.Set(ctx, retention.Rule{Prefix: "ev:", MaxAgeSeconds: 1}))
+	results, err := ret.Run(ctx, "ev:", false)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, results[0].Removed)
+	assert.Equal(t, []string{"ev:1", "ev:3"}, engineKeys(t, eng, "ev:"))
+}
+
+// rewritingEngine writes key again once a scan has read it, as a client
+// might while a run is on. Scans of the rules leave it be.
+type rewritingEngine struct {
+	types.Engine
+	key string
+}
+
+func (e *rewritingEngine) Scan(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+	if err := e.Engine.Scan(ctx, prefix, fn); err != nil || !strings.HasPrefix(e.key, prefix) {
+		return err
+	}
+	return e.Engine.Put(ctx, e.key, &types.Record{ID: e.key, Data: map[string]interface{}{"k": "again"}})
+}
+
+func TestRetentionKeepsRewrites(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	putKeys(t, eng, "a:1", "a:2", "a:3")
+
+	ret := retention.New(&rewritingEngine{Engine: eng, key: "a:1"}, retention.Options{})
+	defer ret.Close()
+	require.NoError(t, ret.Set(ctx, retention.Rule{Prefix: "a:", MaxCount: 1}))
+	results, err := ret.Run(ctx, "a:", false)
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, results[0].Removed)
+	assert.EqualValues(t, 1, results[0].Changed)
+	assert.Equal(t, []string{"a:1", "a:3"}, engineKeys(t, eng, "a:"))
+}
+
+func TestRetentionReservedKeys(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	putKeys(t, eng, "kvi:seq:orders", "kvi:seq:users", "x:1", "x:2")
+
+	// A rule over every key leaves kvi's own records
+	ret := retention.New(eng, retention.Options{})
+	defer ret.Close()
+	require.NoError(t, ret.Set(ctx, retention.Rule{Prefix: "", MaxCount: 1}))
+	_, err = ret.Run(ctx, "", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x:2"}, engineKeys(t, eng, "x:"))
+	assert.Len(t, engineKeys(t, eng, "kvi:seq:"), 2)
+	assert.Len(t, engineKeys(t, eng, retention.Prefix), 1)
+
+	// Unless it names them; its own rules are kept either way
+	require.NoError(t, ret.Set(ctx, retention.Rule{Prefix: "kvi:", MaxCount: 1}))
+	_, err = ret.Run(ctx, "kvi:", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kvi:seq:users"}, engineKeys(t, eng, "kvi:seq:"))
+	rules, err := ret.Rules(ctx)
+	require.NoError(t, err)
+	assert.Len(t, rules, 2)
+
+	assert.ErrorIs(t, ret.Set(ctx, retention.Rule{Prefix: retention.Prefix + "x", MaxCount: 1}), retention.ErrBadRule)
+	assert.ErrorIs(t, ret.Set(ctx, retention.Rule{Prefix: "x:"}), retention.ErrBadRule)
+	assert.ErrorIs(t, ret.Set(ctx, retention.Rule{Prefix: "x:", MaxCount: -1}), retention.ErrBadRule)
+	assert.ErrorIs(t, ret.Remove(ctx, "y:"), retention.ErrNoRule)
+}
+
+func TestRetentionRulesPersist(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.DiskConfig()
+	cfg.DataDir = t.TempDir()
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	ret := retention.New(eng, retention.Options{})
+	require.NoError(t, ret.Set(ctx, retention.Rule{Prefix: "a:", MaxAgeSeconds: 60, DryRun: true}))
+	ret.Close()
+	require.NoError(t, eng.Close())
+
+	eng, err = kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	ret = retention.New(eng, retention.Options{})
+	defer ret.Close()
+	rules, err := ret.Rules(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []retention.Rule{{Prefix: "a:", MaxAgeSeconds: 60, DryRun: true}}, rules)
+}
+
+func TestRetentionSchedule(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	putKeys(t, eng, "a:1", "a:2", "a:3", "d:1", "d:2")
+
+	ret := retention.New(eng, retention.Options{Interval: 20 * time.Millisecond})
+	defer ret.Close()
+	require.NoError(t, ret.Set(ctx, retention.Rule{Prefix: "a:", MaxCount: 1}))
+	require.NoError(t, ret.Set(ctx, retention.Rule{Prefix: "d:", MaxCount: 1, DryRun: true}))
+	require.Eventually(t, func() bool { return len(engineKeys(t, eng, "a:")) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// A dry-run rule only counts
+	require.Eventually(t, func() bool {
+		st, err := ret.Stats(ctx)
+		return err == nil && len(st.Rules) == 2 && st.Rules[1].Runs > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	st, err := ret.Stats(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, 20, st.IntervalMs)
+	assert.EqualValues(t, 2, st.Rules[0].Removed)
+	assert.EqualValues(t, 1, st.Rules[1].LastWouldRemove)
+	assert.Zero(t, st.Rules[1].Removed)
+	assert.False(t, st.Rules[0].NextRun.IsZero())
+	assert.Len(t, engineKeys(t, eng, "d:"), 2)
+}
+
+func retentionRequest(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(msg)
+}
+
+func TestRetentionAdmin(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	putKeys(t, eng, "a:1", "a:2", "a:3")
+	ret := retention.New(eng, retention.Options{})
+	defer ret.Close()
+	ts := httptest.NewServer(api.NewServer(eng, api.WithRetention(ret)).Handler())
+	defer ts.Close()
+	url := ts.URL + "/api/v1/admin/retention"
+
+	// No section in the stats until there is a rule
+	var report struct {
+		Retention *stats.RetentionStats `json:"retention"`
+	}
+	getJSON(t, ts.URL+"/api/v1/stats", &report)
+	assert.Nil(t, report.Retention)
+
+	status, _ := retentionRequest(t, http.MethodPut, url, `{"prefix":"a:"}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = retentionRequest(t, http.MethodPut, url, `{"prefix":"a:","max_count":1,"max_size":3}`)
+	assert.Equal(t, http.StatusBadRequest, status, "unknown fields are refused")
+	status, _ = retentionRequest(t, http.MethodPut, url, `{"prefix":"a:","max_count":1}`)
+	assert.Equal(t, http.StatusOK, status)
+	var st stats.RetentionStats
+	getJSON(t, url, &st)
+	require.Len(t, st.Rules, 1)
+	assert.EqualValues(t, 1, st.Rules[0].MaxCount)
+
+	status, body := retentionRequest(t, http.MethodPost, url+"/run?prefix=a:&dry_run=true", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"keys":["a:1","a:2"]`)
+	assert.Len(t, engineKeys(t, eng, "a:"), 3)
+	status, _ = retentionRequest(t, http.MethodPost, url+"/run?dry_run=maybe", "")
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = retentionRequest(t, http.MethodPost, url+"/run?prefix=b:", "")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = retentionRequest(t, http.MethodPost, url+"/run", "")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []string{"a:3"}, engineKeys(t, eng, "a:"))
+
+	getJSON(t, ts.URL+"/api/v1/stats", &report)
+	require.NotNil(t, report.Retention)
+	assert.EqualValues(t, 2, report.Retention.Rules[0].Removed)
+
+	status, _ = retentionRequest(t, http.MethodDelete, url, "")
+	assert.Equal(t, http.StatusBadRequest, status, "prefix is required")
+	status, _ = retentionRequest(t, http.MethodDelete, url+"?prefix=b:", "")
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = retentionRequest(t, http.MethodDelete, url+"?prefix=a:", "")
+	assert.Equal(t, http.StatusOK, status)
+	report.Retention = nil
+	getJSON(t, ts.URL+"/api/v1/stats", &report)
+	assert.Nil(t, report.Retention)
+
+	// A server without rules has no such endpoint
+	bare := httptest.NewServer(api.NewServer(eng).Handler())
+	defer bare.Close()
+	status, _ = retentionRequest(t, http.MethodGet, bare.URL+"/api/v1/admin/retention", "")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestRetentionConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	assert.Equal(t, 60000, cfg.RetentionIntervalMs)
+	cfg.RetentionBatchSize = 0
+	cfg.RetentionIntervalMs = -1
+	err := cfg.Validate()
+	assert.ErrorContains(t, err, "retention_batch_size")
+	assert.ErrorContains(t, err, "retention_interval_ms")
+}
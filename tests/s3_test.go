@@ -0,0 +1,316 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// fakeS3 is the part of the S3 API backups use, with path-style URLs:
+// objects with metadata, multipart uploads and copies.
+type fakeS3 struct {
+	mu        sync.Mutex
+	objects   map[string]fakeObject
+	uploads   map[string]map[int][]byte
+	failParts int // part uploads still to fail with a 500
+	parts     int // part uploads accepted
+	unsigned  int
+}
+
+type fakeObject struct {
+	data []byte
+	meta http.Header
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, config.S3Config) {
+	f := &fakeS3{objects: map[string]fakeObject{}, uploads: map[string]map[int][]byte{}}
+	ts := httptest.NewServer(f)
+	t.Cleanup(ts.Close)
+	cfg := config.DefaultBackup().S3
+	cfg.Endpoint, cfg.PathStyle = ts.URL, true
+	cfg.AccessKeyID, cfg.SecretAccessKey = "test-key", "test-secret"
+	cfg.PartSizeMB = 5
+	return f, cfg
+}
+
+func s3Error(w http.ResponseWriter, code int, s3code string) {
+	w.WriteHeader(code)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", s3code, s3code)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test-key/") {
+		f.unsigned++
+		s3Error(w, http.StatusForbidden, "AccessDenied")
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	q := r.URL.Query()
+	body, _ := io.ReadAll(r.Body)
+	meta := http.Header{}
+	for k, v := range r.Header {
+		if strings.HasPrefix(k, "X-Amz-Meta-") {
+			meta[k] = v
+		}
+	}
+
+	switch {
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		id := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && q.Has("partNumber"):
+		if f.failParts > 0 {
+			f.failParts--
+			s3Error(w, http.StatusInternalServerError, "InternalError")
+			return
+		}
+		n, _ := strconv.Atoi(q.Get("partNumber"))
+		f.uploads[q.Get("uploadId")][n] = body
+		f.parts++
+		w.Header().Set("ETag", fmt.Sprintf(`"%d"`, n))
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		var done struct {
+			Parts []struct{ PartNumber int } `xml:"Part"`
+		}
+		xml.Unmarshal(body, &done)
+		var data []byte
+		for _, p := range done.Parts {
+			data = append(data, f.uploads[q.Get("uploadId")][p.PartNumber]...)
+		}
+		delete(f.uploads, q.Get("uploadId"))
+		f.objects[key] = fakeObject{data: data, meta: meta}
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		delete(f.uploads, q.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source, _ := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+		obj, ok := f.objects[source]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		f.objects[key] = fakeObject{data: obj.data, meta: meta}
+		fmt.Fprint(w, "<CopyObjectResult></CopyObjectResult>")
+	case r.Method == http.MethodPut:
+		f.objects[key] = fakeObject{data: body, meta: meta}
+	case r.Method == http.MethodGet:
+		obj, ok := f.objects[key]
+		if !ok {
+			s3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		for k, v := range obj.meta {
+			w.Header()[k] = v
+		}
+		w.Write(obj.data)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		s3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// putRandom stores n records of incompressible data, size bytes each.
+func putRandom(t *testing.T, eng types.Engine, n, size int) {
+	t.Helper()
+	buf := make([]byte, size/2)
+	for i := range n {
+		rand.Read(buf)
+		key := fmt.Sprintf("k%05d", i)
+		require.NoError(t, eng.Put(context.Background(), key, &types.Record{ID: key, Data: map[string]any{"blob": hex.EncodeToString(buf)}}))
+	}
+}
+
+// A backup larger than a part goes up in parts, through failed ones, and
+// comes back checked against the checksum stored with it.
+func TestS3Backup(t *testing.T) {
+	ctx := context.Background()
+	fake, cfg := newFakeS3(t)
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	putRandom(t, eng, 1200, 10<<10) // about 6 MiB compressed: two parts
+
+	store, err := backup.NewStore("s3://backups/nightly", cfg)
+	require.NoError(t, err)
+	fake.failParts = 2
+	sum, err := backup.Save(ctx, store, "full.kvibak", func(w io.Writer) (backup.Summary, error) {
+		return backup.Dump(ctx, eng, w)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1200, sum.Records)
+	assert.Greater(t, sum.Bytes, int64(5<<20))
+	assert.Equal(t, 2, fake.parts)
+	assert.Equal(t, []string{"backups/nightly/full.kvibak"}, fake.keys(), "the partial object is gone")
+	assert.Zero(t, fake.unsigned)
+	assert.Equal(t, "s3://backups/nightly/full.kvibak", store.Location("full.kvibak"))
+
+	var buf bytes.Buffer
+	meta, err := backup.Fetch(ctx, store, "full.kvibak", &buf)
+	require.NoError(t, err)
+	assert.Equal(t, sum.Checksum, meta.Checksum)
+	assert.Equal(t, 1200, meta.Records)
+	restored, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer restored.Close()
+	res, err := backup.Restore(ctx, restored, bytes.NewReader(buf.Bytes()), backup.Replace)
+	require.NoError(t, err)
+	assert.Equal(t, 1200, res.Restored)
+
+	// A changed object no longer matches its metadata
+	fake.mu.Lock()
+	obj := fake.objects["backups/nightly/full.kvibak"]
+	obj.data = append([]byte(nil), obj.data...)
+	obj.data[len(obj.data)/2] ^= 0xff
+	fake.objects["backups/nightly/full.kvibak"] = obj
+	fake.mu.Unlock()
+	_, err = backup.Fetch(ctx, store, "full.kvibak", io.Discard)
+	assert.ErrorIs(t, err, backup.ErrChecksumMismatch)
+
+	_, err = backup.Fetch(ctx, store, "missing.kvibak", io.Discard)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// Retries run out
+	fake.failParts = 100
+	cfg.Retries = 1
+	store, err = backup.NewStore("s3://backups", cfg)
+	require.NoError(t, err)
+	_, err = backup.Save(ctx, store, "doomed.kvibak", func(w io.Writer) (backup.Summary, error) {
+		return backup.Dump(ctx, eng, w)
+	})
+	assert.ErrorContains(t, err, "InternalError")
+	fake.mu.Lock()
+	assert.Empty(t, fake.uploads, "the failed upload was aborted")
+	fake.mu.Unlock()
+	assert.NotContains(t, fake.keys(), "backups/doomed.kvibak")
+}
+
+// The snapshot job and GET /api/v1/backup?dest= write to object storage.
+func TestS3Snapshots(t *testing.T) {
+	fake, cfg := newFakeS3(t)
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	putRandom(t, eng, 10, 100)
+
+	snapshots, err := backup.NewStore("s3://kvi/snapshots/", cfg)
+	require.NoError(t, err)
+	ts := httptest.NewServer(api.NewServer(eng, api.WithSnapshotStore(snapshots), api.WithBackupS3(cfg)).Handler())
+	defer ts.Close()
+
+	job, code := startJob(t, ts.URL+"/api/v1/admin/snapshot")
+	require.Equal(t, http.StatusAccepted, code)
+	job = waitJob(t, ts.URL, job.ID)
+	require.Equal(t, "succeeded", job.Status, job.Error)
+	result := job.Result.(map[string]interface{})
+	assert.EqualValues(t, 10, result["records"])
+	assert.Regexp(t, `^s3://kvi/snapshots/kvi-.*\.kvibak$`, result["path"])
+
+	resp, err := http.Get(ts.URL + "/api/v1/backup?dest=" + url.QueryEscape("s3://kvi/adhoc"))
+	require.NoError(t, err)
+	var out struct {
+		Location string `json:"location"`
+		Records  int    `json:"records"`
+		Checksum string `json:"checksum"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 10, out.Records)
+	assert.Regexp(t, `^s3://kvi/adhoc/kvi-.*\.kvibak$`, out.Location)
+	assert.Len(t, fake.keys(), 2)
+
+	dest, name := backup.SplitLocation(out.Location)
+	store, err := backup.NewStore(dest, cfg)
+	require.NoError(t, err)
+	meta, err := backup.Fetch(context.Background(), store, name, io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, out.Checksum, meta.Checksum)
+
+	resp, err = http.Get(ts.URL + "/api/v1/backup?dest=" + url.QueryEscape("/tmp/elsewhere"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	putRandom(t, eng, 5, 100)
+
+	store, err := backup.NewStore(dir, config.S3Config{})
+	require.NoError(t, err)
+	sum, err := backup.Save(ctx, store, "a.kvibak", func(w io.Writer) (backup.Summary, error) {
+		return backup.Dump(ctx, eng, w)
+	})
+	require.NoError(t, err)
+	line, err := os.ReadFile(filepath.Join(dir, "a.kvibak.sha256"))
+	require.NoError(t, err)
+	assert.Equal(t, sum.Checksum+"  a.kvibak\n", string(line))
+	assert.NoFileExists(t, filepath.Join(dir, "a.kvibak.partial"))
+
+	meta, err := backup.Fetch(ctx, store, "a.kvibak", io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, sum.Checksum, meta.Checksum)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.kvibak.sha256"), []byte("0000  a.kvibak\n"), 0644))
+	_, err = backup.Fetch(ctx, store, "a.kvibak", io.Discard)
+	assert.ErrorIs(t, err, backup.ErrChecksumMismatch)
+
+	// A failed dump leaves nothing behind
+	_, err = backup.Save(ctx, store, "b.kvibak", func(w io.Writer) (backup.Summary, error) {
+		w.Write([]byte("half a backup"))
+		return backup.Summary{}, io.ErrUnexpectedEOF
+	})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 2)
+
+	cfg := config.DefaultConfig()
+	cfg.Backup.SnapshotDest = "s3://"
+	cfg.Backup.S3.PartSizeMB = 1
+	err = cfg.Validate()
+	assert.ErrorContains(t, err, "backup.snapshot_dest")
+	assert.ErrorContains(t, err, "backup.s3.part_size_mb")
+}
@@ -0,0 +1,276 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func fillEngine(t *testing.T, eng types.Engine, prefix string, n int) []string {
+	t.Helper()
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("%s%04d", prefix, i)
+		assert.NoError(t, eng.Put(context.Background(), keys[i], &types.Record{ID: keys[i], Data: map[string]interface{}{"i": i}}))
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestEngineScan(t *testing.T) {
+	disk := config.DiskConfig()
+	disk.DataDir = t.TempDir()
+
+	for name, cfg := range map[string]*config.Config{"memory": config.MemoryConfig(), "disk": disk} {
+		t.Run(name, func(t *testing.T) {
+			eng, err := kvi.Open(cfg)
+			assert.NoError(t, err)
+			defer eng.Close()
+
+			users := fillEngine(t, eng, "user:", 700) // spans several scan chunks
+			fillEngine(t, eng, "order:", 10)
+
+			var got []string
+			err = eng.Scan(context.Background(), "user:", func(rec *types.Record) bool {
+				got = append(got, rec.ID)
+				return true
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, users, got)
+
+			got = got[:0]
+			eng.Scan(context.Background(), "user:", func(rec *types.Record) bool {
+				got = append(got, rec.ID)
+				return len(got) < 3
+			})
+			assert.Equal(t, users[:3], got)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			seen := 0
+			err = eng.Scan(ctx, "", func(*types.Record) bool {
+				seen++
+				cancel()
+				return true
+			})
+			assert.ErrorIs(t, err, context.Canceled)
+			assert.Equal(t, 1, seen)
+		})
+	}
+}
+
+// scanPage is the envelope of GET /api/v1/scan.
+type scanPage struct {
+	Items      []types.Record `json:"items"`
+	Count      int            `json:"count"`
+	Truncated  bool           `json:"truncated"`
+	NextCursor string         `json:"next_cursor"`
+}
+
+func TestScanEndpoint(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+	keys := fillEngine(t, eng, "k", 600)
+
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	var page scanPage
+	getJSON(t, ts.URL+"/api/v1/scan?prefix=k00&limit=5", &page)
+	assert.Len(t, page.Items, 5)
+	assert.Equal(t, 5, page.Count)
+	assert.Equal(t, "k0000", page.Items[0].ID)
+	assert.True(t, page.Truncated)
+
+	// Following the cursor walks the rest without gaps or repeats
+	seen := len(page.Items)
+	for page.Truncated {
+		cursor := page.NextCursor
+		page = scanPage{}
+		getJSON(t, ts.URL+"/api/v1/scan?prefix=k00&limit=40&cursor="+cursor, &page)
+		assert.Equal(t, keys[seen], page.Items[0].ID)
+		seen += page.Count
+	}
+	assert.Equal(t, 100, seen)
+	assert.Empty(t, page.NextCursor)
+
+	// An exact fit is not truncated
+	page = scanPage{}
+	getJSON(t, ts.URL+"/api/v1/scan?prefix=k00&limit=100", &page)
+	assert.Equal(t, 100, page.Count)
+	assert.False(t, page.Truncated)
+
+	var legacy []types.Record
+	resp, err := http.Get(ts.URL + "/api/v1/scan?prefix=k00&limit=5&envelope=legacy")
+	assert.NoError(t, err)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&legacy))
+	resp.Body.Close()
+	assert.Len(t, legacy, 5)
+	assert.Equal(t, "true", resp.Header.Get("Deprecation"))
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/v1/scan?prefix=k", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+
+	var got []string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		var rec types.Record
+		assert.NoError(t, json.Unmarshal(sc.Bytes(), &rec))
+		got = append(got, rec.ID)
+	}
+	assert.Equal(t, keys, got)
+
+	page = scanPage{}
+	getJSON(t, ts.URL+"/api/v1/scan?prefix=k01&limit=100&consistent=true", &page)
+	assert.Equal(t, 100, page.Count)
+	assert.Equal(t, "k0100", page.Items[0].ID)
+	resp, err = http.Get(ts.URL + "/api/v1/scan?prefix=k05&stream=true&consistent=true")
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	lines := 0
+	for sc = bufio.NewScanner(resp.Body); sc.Scan(); {
+		lines++
+	}
+	assert.Equal(t, 100, lines)
+}
+
+func TestQueryEnvelope(t *testing.T) {
+	_, ts := memoryServer(t)
+
+	query := func(sql, params string) *http.Response {
+		resp, err := http.Post(ts.URL+"/api/v1/query"+params, "application/json", jsonBody(map[string]string{"query": sql}))
+		assert.NoError(t, err)
+		return resp
+	}
+	query("INSERT INTO users (id, name) VALUES ('u1', 'Ann'), ('u2', 'Bo')", "").Body.Close()
+
+	var got struct {
+		Items       []types.Record `json:"items"`
+		Count       int            `json:"count"`
+		Truncated   bool           `json:"truncated"`
+		Columns     []string       `json:"columns"`
+		RowsScanned int            `json:"rows_scanned"`
+		DurationMs  *float64       `json:"duration_ms"`
+	}
+	resp := query("SELECT * FROM users WHERE id = 'u2'", "")
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	resp.Body.Close()
+	assert.Equal(t, 1, got.Count)
+	assert.Equal(t, "Bo", got.Items[0].Data["name"])
+	assert.Equal(t, 1, got.RowsScanned)
+	assert.False(t, got.Truncated)
+	assert.NotNil(t, got.DurationMs)
+	assert.Nil(t, got.Columns)
+
+	got.Columns = nil
+	resp = query("SELECT name, id FROM users WHERE id = 'u2'", "")
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	resp.Body.Close()
+	assert.Equal(t, []string{"name", "id"}, got.Columns)
+
+	var legacy types.Record
+	resp = query("SELECT * FROM users WHERE id = 'u2'", "?envelope=legacy")
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&legacy))
+	resp.Body.Close()
+	assert.Equal(t, "u2", legacy.ID)
+	assert.Equal(t, "true", resp.Header.Get("Deprecation"))
+}
+
+// TestConsistentScan scans while a writer sweeps generation g over every
+// key in order, then puts tmp<g> and deletes tmp<g-1>. A snapshot sees the
+// keys up to some point at g and the rest at g-1, with only tmp<g-1>, or
+// every key at g with tmp<g-1>, tmp<g> or both.
+func TestConsistentScan(t *testing.T) {
+	ctx := context.Background()
+	disk := config.DiskConfig()
+	disk.DataDir, disk.EnableWAL = t.TempDir(), false
+	hybrid := config.DefaultConfig()
+	hybrid.DataDir, hybrid.CacheSizeMB = t.TempDir(), 0
+	hybrid.EnableWAL = false
+
+	for name, cfg := range map[string]*config.Config{"memory": config.MemoryConfig(), "columnar": config.ColumnarConfig(), "disk": disk, "hybrid": hybrid} {
+		t.Run(name, func(t *testing.T) {
+			eng, err := kvi.Open(cfg)
+			assert.NoError(t, err)
+			defer eng.Close()
+			put := func(key string, gen int) {
+				assert.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, Data: map[string]interface{}{"gen": gen}}))
+			}
+			const n = 1000
+			for i := range n {
+				put(fmt.Sprintf("k%04d", i), 0)
+			}
+			put("tmp0000", 0)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for g := 1; g <= 20; g++ {
+					for i := range n {
+						put(fmt.Sprintf("k%04d", i), g)
+					}
+					put(fmt.Sprintf("tmp%04d", g), g)
+					assert.NoError(t, eng.Delete(ctx, fmt.Sprintf("tmp%04d", g-1)))
+				}
+			}()
+
+			defer func() { <-done }()
+			scanner := eng.(types.ConsistentScanner)
+			for scans := 0; scans < 200; scans++ {
+				var keys []string
+				var gens, tmps []int
+				assert.NoError(t, scanner.ScanConsistent(ctx, "", func(rec *types.Record) bool {
+					keys = append(keys, rec.ID)
+					if rec.ID[0] == 'k' {
+						gens = append(gens, int(toFloat(rec.Data["gen"])))
+					} else {
+						tmps = append(tmps, int(toFloat(rec.Data["gen"])))
+					}
+					return true
+				}))
+				assert.True(t, sort.StringsAreSorted(keys) && !hasDuplicate(keys), "scan %d: keys out of order or repeated", scans)
+				if !assert.Len(t, gens, n, "scan %d", scans) {
+					return
+				}
+				hi, lo := gens[0], gens[n-1]
+				assert.True(t, sort.SliceIsSorted(gens, func(i, j int) bool { return gens[i] > gens[j] }), "scan %d: generations rise along the keys", scans)
+				if hi == lo {
+					assert.Contains(t, [][]int{{hi - 1}, {hi - 1, hi}, {hi}}, tmps, "scan %d: all at %d", scans, hi)
+				} else {
+					assert.Equal(t, hi-1, lo, "scan %d", scans)
+					assert.Equal(t, []int{lo}, tmps, "scan %d: sweeping %d", scans, hi)
+				}
+
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+		})
+	}
+}
+
+func hasDuplicate(keys []string) bool {
+	for i := 1; i < len(keys); i++ {
+		if keys[i] == keys[i-1] {
+			return true
+		}
+	}
+	return false
+}
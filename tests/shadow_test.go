@@ -0,0 +1,287 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/client"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/shadow"
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// shadowPair opens a primary and a shadow holding the same records but
+// for "b", whose data differs, "c", only in the primary, and "d", only in
+// the shadow.
+func shadowPair(t *testing.T) (types.Engine, types.Engine) {
+	t.Helper()
+	ctx := context.Background()
+	var engs [2]types.Engine
+	for i := range engs {
+		eng, err := kvi.Open(config.MemoryConfig())
+		require.NoError(t, err)
+		t.Cleanup(func() { eng.Close() })
+		require.NoError(t, eng.Put(ctx, "a", &types.Record{ID: "a", Data: map[string]interface{}{"n": 1}, Tags: []string{"x"}}))
+		require.NoError(t, eng.Put(ctx, "b", &types.Record{ID: "b", Data: map[string]interface{}{"n": i}}))
+		engs[i] = eng
+	}
+	require.NoError(t, engs[0].Put(ctx, "c", &types.Record{ID: "c"}))
+	require.NoError(t, engs[1].Put(ctx, "d", &types.Record{ID: "d"}))
+	// Versions differ between the two, which is no mismatch
+	require.NoError(t, engs[1].Put(ctx, "a", &types.Record{ID: "a", Data: map[string]interface{}{"n": 1.0}, Tags: []string{"x"}}))
+	return engs[0], engs[1]
+}
+
+// awaitReads waits for sh to have compared n reads.
+func awaitReads(t *testing.T, sh *shadow.Shadow, n int64) *stats.ShadowStats {
+	t.Helper()
+	require.Eventually(t, func() bool { return sh.Stats().Reads >= n }, 5*time.Second, 5*time.Millisecond)
+	return sh.Stats()
+}
+
+func TestShadowCompare(t *testing.T) {
+	ctx := context.Background()
+	primary, src := shadowPair(t)
+	sh := shadow.New(src, "test", shadow.Options{RatePerSec: 1000})
+	defer sh.Close()
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		rec, err := primary.Get(ctx, key)
+		if err != nil {
+			require.ErrorIs(t, err, types.ErrKeyNotFound)
+		}
+		sh.Get(key, rec)
+	}
+	st := awaitReads(t, sh, 4)
+	assert.Equal(t, "test", st.Source)
+	assert.EqualValues(t, 4, st.Keys)
+	assert.EqualValues(t, 3, st.Mismatches)
+	assert.Zero(t, st.Errors)
+	reasons := map[string]string{}
+	for _, m := range st.Samples {
+		assert.Equal(t, "get", m.Op)
+		reasons[m.Key] = m.Reason
+	}
+	assert.Equal(t, map[string]string{
+		"b": shadow.ReasonData,
+		"c": shadow.ReasonMissingShadow,
+		"d": shadow.ReasonMissingPrimary,
+	}, reasons)
+	assert.Equal(t, "d", st.Samples[0].Key, "latest first")
+
+	// A whole scan finds every difference; a truncated page only those up
+	// to its last key
+	var recs []*types.Record
+	require.NoError(t, primary.Scan(ctx, "", func(rec *types.Record) bool {
+		recs = append(recs, rec)
+		return true
+	}))
+	sh.Scan(shadow.ScanPage{Records: recs})
+	sh.Scan(shadow.ScanPage{Records: recs[:2], Truncated: true})
+	st = awaitReads(t, sh, 6)
+	assert.EqualValues(t, 4+4+2, st.Keys)
+	assert.EqualValues(t, 3+3+1, st.Mismatches)
+	assert.Equal(t, "scan", st.Samples[0].Op)
+	assert.Equal(t, "b", st.Samples[0].Key)
+
+	// A projected page is compared with the shadow's records projected alike
+	proj := &types.Projection{Fields: []string{"none"}}
+	sh.Scan(shadow.ScanPage{Prefix: "a", Projection: proj, Records: []*types.Record{proj.Apply(recs[0])}})
+	st = awaitReads(t, sh, 7)
+	assert.EqualValues(t, 7, st.Mismatches)
+
+	// Pages after a cursor are not compared
+	sh.Scan(shadow.ScanPage{After: "a", Records: recs[1:]})
+	assert.EqualValues(t, 1, sh.Stats().Skipped)
+}
+
+func TestShadowRateLimit(t *testing.T) {
+	primary, src := shadowPair(t)
+	rec, err := primary.Get(context.Background(), "a")
+	require.NoError(t, err)
+	sh := shadow.New(src, "test", shadow.Options{RatePerSec: 3})
+	defer sh.Close()
+
+	// Beyond a second's worth at once, reads are skipped, not queued
+	for range 10 {
+		sh.Get("a", rec)
+	}
+	st := awaitReads(t, sh, 3)
+	assert.EqualValues(t, 3, st.Reads)
+	assert.EqualValues(t, 7, st.Skipped)
+	assert.Zero(t, st.Mismatches)
+
+	// The bucket refills with time
+	time.Sleep(400 * time.Millisecond)
+	sh.Get("c", nil)
+	st = awaitReads(t, sh, 4)
+	assert.EqualValues(t, 0, st.Mismatches)
+}
+
+func TestShadowRemote(t *testing.T) {
+	ctx := context.Background()
+	primary, src := shadowPair(t)
+	ts := httptest.NewServer(api.NewServer(src).Handler())
+	defer ts.Close()
+	c, err := client.New(client.WithHTTP(ts.URL))
+	require.NoError(t, err)
+	defer c.Close()
+	sh := shadow.New(c, ts.URL, shadow.Options{})
+	defer sh.Close()
+
+	// Numbers decoded from JSON match the ints the primary holds
+	for _, key := range []string{"a", "b"} {
+		rec, err := primary.Get(ctx, key)
+		require.NoError(t, err)
+		sh.Get(key, rec)
+	}
+	sh.Get("d", nil)
+	st := awaitReads(t, sh, 3)
+	assert.EqualValues(t, 2, st.Mismatches)
+	assert.Zero(t, st.Errors)
+}
+
+func TestShadowAPI(t *testing.T) {
+	ctx := context.Background()
+	primary, src := shadowPair(t)
+	sh := shadow.New(src, "old", shadow.Options{RatePerSec: 1000})
+	defer sh.Close()
+	ts := httptest.NewServer(api.NewServer(primary, api.WithShadow(sh)).Handler())
+	defer ts.Close()
+
+	var rec types.Record
+	getJSON(t, ts.URL+"/api/v1/get?key=b", &rec)
+	var list struct{ Count int }
+	getJSON(t, ts.URL+"/api/v1/scan?limit=10", &list)
+	assert.Equal(t, 3, list.Count)
+	awaitReads(t, sh, 2)
+
+	var report stats.ShadowStats
+	getJSON(t, ts.URL+"/api/v1/admin/shadow", &report)
+	assert.Equal(t, "old", report.Source)
+	assert.EqualValues(t, 1+4, report.Keys)
+	assert.EqualValues(t, 1+3, report.Mismatches)
+	require.Len(t, report.Samples, 4)
+	assert.Equal(t, "scan", report.Samples[0].Op)
+
+	var all struct{ Shadow *stats.ShadowStats }
+	getJSON(t, ts.URL+"/api/v1/stats", &all)
+	require.NotNil(t, all.Shadow)
+	assert.EqualValues(t, 4, all.Shadow.Mismatches)
+	assert.Empty(t, all.Shadow.Samples)
+
+	// The gRPC server compares its reads too
+	grpcClient := serveGrpc(t, kvi_grpc.NewGrpcServer(primary, pubsub.NewHub(), kvi_grpc.WithShadow(sh)))
+	_, err := grpcClient.Get(ctx, &kvi_grpc.GetRequest{Key: "c"})
+	require.NoError(t, err)
+	stream, err := grpcClient.Scan(ctx, &kvi_grpc.ScanRequest{Prefix: "a"})
+	require.NoError(t, err)
+	for {
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		if resp.Last {
+			break
+		}
+	}
+	st := awaitReads(t, sh, 4)
+	assert.EqualValues(t, 1+3+1, st.Mismatches)
+	assert.Equal(t, "c", st.Samples[0].Key)
+
+	// Without a shadow there is nothing to report
+	_, plain := memoryServer(t)
+	resp, err := http.Get(plain.URL + "/api/v1/admin/shadow")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestShadowOpenReadOnly(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	eng, err := kvi.OpenDisk(dir)
+	require.NoError(t, err)
+	fillEngine(t, eng, "k", 10)
+	require.NoError(t, eng.Close())
+	// A crash left half a length prefix behind
+	path := filepath.Join(dir, wal.FileName)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0x20, 0x00})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	logged, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	for _, mode := range []types.Mode{types.ModeDisk, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Mode, cfg.DataDir = mode, dir
+			src, err := kvi.OpenReadOnly(cfg)
+			require.NoError(t, err)
+			assert.ErrorIs(t, src.Put(ctx, "new", &types.Record{ID: "new"}), types.ErrReadOnly)
+			assert.ErrorIs(t, src.Delete(ctx, "k0000"), types.ErrReadOnly)
+
+			sh := shadow.New(src, dir, shadow.Options{RatePerSec: 1000})
+			rec, err := src.Get(ctx, "k0000")
+			require.NoError(t, err)
+			sh.Get("k0000", rec)
+			sh.Get("gone", nil)
+			st := awaitReads(t, sh, 2)
+			assert.Zero(t, st.Mismatches)
+			assert.Zero(t, st.Errors)
+			sh.Close()
+			require.NoError(t, src.Close())
+
+			now, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, logged, now, "the log is left byte for byte, torn tail and all")
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			assert.Equal(t, wal.FileName, entries[0].Name())
+		})
+	}
+
+	// A directory that is missing or holds no log, or a mode keeping
+	// nothing in one, is refused, and the directory left as it was
+	missing := filepath.Join(t.TempDir(), "typo")
+	empty := t.TempDir()
+	for _, cfg := range []*config.Config{
+		{Mode: types.ModeDisk, DataDir: missing},
+		{Mode: types.ModeHybrid, DataDir: empty},
+		{Mode: types.ModeMemory, DataDir: dir},
+	} {
+		_, err := kvi.OpenReadOnly(cfg)
+		assert.Error(t, err, cfg.DataDir)
+	}
+	assert.NoDirExists(t, missing)
+	entries, err := os.ReadDir(empty)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestShadowConfig(t *testing.T) {
+	cfg := config.DiskConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.Shadow.URL = "ftp://old"
+	assert.ErrorContains(t, cfg.Validate(), "shadow.url")
+	cfg.Shadow.URL, cfg.Shadow.DataDir = "", cfg.DataDir
+	assert.ErrorContains(t, cfg.Validate(), "shadow.data_dir")
+	cfg.Shadow.DataDir, cfg.Shadow.Mode = t.TempDir(), "tape"
+	assert.ErrorContains(t, cfg.Validate(), "shadow.mode")
+	cfg.Shadow.Mode = types.ModeHybrid
+	assert.NoError(t, cfg.Validate())
+}
@@ -36,6 +36,15 @@ func TestSQLExecutor(t *testing.T) {
 	assert.Equal(t, "user1", recRes.ID)
 	assert.Equal(t, "John", recRes.Data["name"])
 
+	// A projection is reported, in order, with aliases
+	res, err := executor.Execute(ctx, "SELECT Age, name AS who FROM users WHERE id = 'user1'")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"age", "who"}, res.Columns)
+	assert.Equal(t, "John", res.Value.(*types.Record).Data["name"]) // still the whole record
+	res, err = executor.Execute(ctx, "SELECT * FROM users WHERE id = 'user1'")
+	assert.NoError(t, err)
+	assert.Nil(t, res.Columns)
+
 	// Test Standard SQL UPDATE
 	_, err = executor.ExecuteQuery(ctx, "UPDATE users SET name = 'Jane', age = 31 WHERE id = 'user1'")
 	assert.NoError(t, err)
@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+)
+
+type sseEvent struct {
+	id   string
+	data string
+}
+
+// sseStream reads events from an SSE response until the body closes. The
+// retry hint and ": ping" comments are reported as events with data "retry"
+// and "ping".
+func sseStream(resp *http.Response) <-chan sseEvent {
+	out := make(chan sseEvent, 1000)
+	go func() {
+		defer close(out)
+		scanner := bufio.NewScanner(resp.Body)
+		var ev sseEvent
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case line == ": ping":
+				out <- sseEvent{data: "ping"}
+			case strings.HasPrefix(line, "retry: "):
+				out <- sseEvent{data: "retry"}
+			case strings.HasPrefix(line, "id: "):
+				ev.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "data: "):
+				ev.data = strings.TrimPrefix(line, "data: ")
+			case line == "" && ev.id != "":
+				out <- ev
+				ev = sseEvent{}
+			}
+		}
+	}()
+	return out
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent) sseEvent {
+	t.Helper()
+	for {
+		select {
+		case ev := <-events:
+			if ev.data != "ping" && ev.data != "retry" {
+				return ev
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for SSE event")
+		}
+	}
+}
+
+func TestSSEReconnectResumesFromHistory(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	srv := api.NewServer(eng, api.WithHeartbeat(20*time.Millisecond))
+	mux := http.NewServeMux()
+	srv.RegisterHandlers(mux)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	publish := func(msg string) {
+		resp, err := http.Post(ts.URL+"/api/v1/pub", "application/json",
+			jsonBody(map[string]string{"channel": "feed", "message": msg}))
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	connect := func(ctx context.Context, lastEventID string) <-chan sseEvent {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/v1/sub?channel=feed&id=c1", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		events := sseStream(resp)
+		// The retry hint is written once the subscription is registered
+		assert.Equal(t, "retry", (<-events).data)
+		return events
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := connect(ctx, "")
+	publish("one")
+	publish("two")
+	assert.Equal(t, sseEvent{id: "1", data: "one"}, nextEvent(t, events))
+	last := nextEvent(t, events)
+	assert.Equal(t, sseEvent{id: "2", data: "two"}, last)
+	cancel()
+
+	// Messages published while the client is away must not be lost
+	publish("three")
+	publish("four")
+
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	events = connect(ctx, last.id)
+	publish("five")
+
+	assert.Equal(t, sseEvent{id: "3", data: "three"}, nextEvent(t, events))
+	assert.Equal(t, sseEvent{id: "4", data: "four"}, nextEvent(t, events))
+	assert.Equal(t, sseEvent{id: "5", data: "five"}, nextEvent(t, events))
+}
@@ -0,0 +1,200 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/stats"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func TestStatsReport(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.VectorDim = 3
+	eng, err := kvi.Open(cfg)
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	ctx := context.Background()
+	fillEngine(t, eng, "k", 10)
+	assert.NoError(t, eng.Put(ctx, "v", &types.Record{ID: "v", Vector: []float32{1, 0, 0}}))
+
+	hub := pubsub.NewHub()
+	hub.Publish("news", "hello")
+	ts := httptest.NewServer(api.NewServer(eng, api.WithHub(hub)).Handler())
+	defer ts.Close()
+
+	var report stats.Report
+	assert.Eventually(t, func() bool { // the disk tier fills asynchronously
+		getJSON(t, ts.URL+"/api/v1/stats", &report)
+		return report.Engine.WAL.Writes == 11
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, stats.SchemaVersion, report.SchemaVersion)
+	assert.Equal(t, types.ModeHybrid, report.Engine.Mode)
+	assert.Equal(t, 11, report.Engine.Records)
+	assert.Equal(t, 0, *report.Engine.AsyncQueue)
+	assert.Contains(t, report.Engine.WAL.Path, cfg.DataDir)
+	assert.Equal(t, 11, report.Engine.Columnar.Rows)
+	assert.Equal(t, 1, report.Engine.Vector.Nodes)
+	assert.Equal(t, 3, report.Engine.Vector.Dim)
+	assert.Positive(t, report.Engine.Vector.MemoryBytes)
+	assert.Positive(t, report.Runtime.Goroutines)
+	assert.Equal(t, 1, report.PubSub.Channels)
+
+	// gRPC serves the same report
+	resp, err := startGrpc(t, eng, hub).Stats(ctx, &kvi_grpc.StatsRequest{})
+	assert.NoError(t, err)
+	assert.EqualValues(t, stats.SchemaVersion, resp.SchemaVersion)
+	var viaGrpc stats.Report
+	assert.NoError(t, json.Unmarshal([]byte(resp.ReportJson), &viaGrpc))
+	report.Engine.WAL.SinceSync, viaGrpc.Engine.WAL.SinceSync = 0, 0 // a clock, not a count
+	assert.Equal(t, report.Engine, viaGrpc.Engine)
+}
+
+func TestCollectionStats(t *testing.T) {
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+	for _, mode := range []types.Mode{types.ModeMemory, types.ModeDisk, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Mode, cfg.DataDir, cfg.VectorDim = mode, t.TempDir(), 3
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+
+			for _, key := range []string{"user:2", "user:1", "user:3"} {
+				require.NoError(t, eng.Put(ctx, key, &types.Record{Data: map[string]interface{}{"name": key}}))
+			}
+			require.NoError(t, eng.Put(ctx, "user:4", &types.Record{Data: map[string]interface{}{"n": 1}, TTL: &expires}))
+			require.NoError(t, eng.Put(ctx, "order:1", &types.Record{Blob: make([]byte, 4096)}))
+			require.NoError(t, eng.Put(ctx, "order:2", &types.Record{Vector: []float32{1, 0, 0}}))
+			require.NoError(t, eng.Put(ctx, "plain", &types.Record{}))
+
+			cs := eng.(types.CollectionStatser)
+			users, err := cs.CollectionStats(ctx, "user:")
+			require.NoError(t, err)
+			assert.Equal(t, "user:", users.Prefix)
+			assert.Equal(t, 4, users.Keys)
+			assert.Equal(t, 1, users.WithTTL)
+			assert.Zero(t, users.WithVector)
+			assert.Equal(t, "user:1", users.MinKey)
+			assert.Equal(t, "user:4", users.MaxKey)
+			assert.Positive(t, users.ApproxBytes)
+
+			all, err := cs.CollectionStats(ctx, "")
+			require.NoError(t, err)
+			assert.Equal(t, 7, all.Keys)
+			assert.Equal(t, 1, all.WithVector)
+
+			top, err := cs.TopCollections(ctx, 10)
+			require.NoError(t, err)
+			require.Len(t, top, 2, "plain has no collection")
+			assert.Equal(t, "order:", top[0].Prefix, "the blob makes orders the largest")
+			assert.Greater(t, top[0].ApproxBytes, int64(4096))
+			assert.Equal(t, users.ApproxBytes, top[1].ApproxBytes)
+			top, err = cs.TopCollections(ctx, 1)
+			require.NoError(t, err)
+			assert.Len(t, top, 1)
+
+			// Cached until collection_stats_ttl_ms runs out
+			require.NoError(t, eng.Put(ctx, "user:5", &types.Record{}))
+			cached, err := cs.CollectionStats(ctx, "user:")
+			require.NoError(t, err)
+			assert.Equal(t, users, cached)
+		})
+	}
+
+	cfg := config.MemoryConfig()
+	cfg.CollectionStatsTTLMs = 0
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	cs := eng.(types.CollectionStatser)
+	for i := 1; i <= 2; i++ {
+		fillEngine(t, eng, "user:", i)
+		users, err := cs.CollectionStats(ctx, "user:")
+		require.NoError(t, err)
+		assert.Equal(t, i, users.Keys)
+	}
+}
+
+func TestCollectionStatsShared(t *testing.T) {
+	eng, err := kvi.OpenMemory()
+	require.NoError(t, err)
+	defer eng.Close()
+	fillEngine(t, eng, "user:", 5000)
+	cs := eng.(types.CollectionStatser)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Callers asking at once share a walk, and one whose context ends
+	// does not end it for the others
+	var wg sync.WaitGroup
+	at := make([]time.Time, 16)
+	for i := range at {
+		wg.Go(func() {
+			if i%4 == 0 {
+				// Cancelled, unless the cache already has the answer
+				if _, err := cs.TopCollections(cancelled, 1); err != nil {
+					assert.ErrorIs(t, err, context.Canceled)
+				}
+				return
+			}
+			top, err := cs.TopCollections(context.Background(), 1)
+			if assert.NoError(t, err) && assert.Len(t, top, 1) {
+				assert.Equal(t, 5000, top[0].Keys)
+				at[i] = top[0].ComputedAt
+			}
+		})
+	}
+	wg.Wait()
+	for i := range at {
+		if i%4 != 0 {
+			assert.Equal(t, at[1], at[i], "one walk served every caller")
+		}
+	}
+}
+
+func TestCollectionStatsEndpoint(t *testing.T) {
+	eng, ts := memoryServer(t)
+	fillEngine(t, eng, "user:", 3)
+	fillEngine(t, eng, "order:", 1)
+
+	var users types.CollectionStats
+	getJSON(t, ts.URL+"/api/v1/stats/collections?prefix=user:", &users)
+	assert.Equal(t, 3, users.Keys)
+
+	var list struct {
+		Items []types.CollectionStats `json:"items"`
+		Count int                     `json:"count"`
+	}
+	getJSON(t, ts.URL+"/api/v1/stats/collections", &list)
+	require.Equal(t, 2, list.Count)
+	assert.Equal(t, "user:", list.Items[0].Prefix)
+	getJSON(t, ts.URL+"/api/v1/stats/collections?limit=1", &list)
+	assert.Equal(t, 1, list.Count)
+
+	var report stats.Report
+	getJSON(t, ts.URL+"/api/v1/stats", &report)
+	assert.Equal(t, list.Items[0].Prefix, report.Engine.Collections[0].Prefix)
+	assert.Len(t, report.Engine.Collections, 2)
+
+	resp, err := http.Get(ts.URL + "/api/v1/stats/collections?limit=0")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func walStats(t *testing.T, eng types.Engine) types.WALStats {
+	t.Helper()
+	stats := eng.(types.StatsReporter).Stats()
+	require.NotNil(t, stats.WAL)
+	return *stats.WAL
+}
+
+func TestSync(t *testing.T) {
+	ctx := context.Background()
+	eng := openDisk(t, t.TempDir())
+	defer eng.Close()
+	assert.True(t, eng.(types.CapabilityReporter).Capabilities().Sync)
+
+	fillEngine(t, eng, "k", 5) // buffered, not yet written
+	time.Sleep(20 * time.Millisecond)
+	stats := walStats(t, eng)
+	assert.Equal(t, 5, stats.Buffered)
+	assert.Equal(t, 5, stats.Pending)
+	assert.GreaterOrEqual(t, stats.SinceSync, int64(20))
+
+	require.NoError(t, eng.(types.Syncer).Sync(ctx))
+	stats = walStats(t, eng)
+	assert.Zero(t, stats.Pending)
+	assert.Less(t, stats.SinceSync, int64(20))
+	info, err := os.Stat(stats.Path)
+	require.NoError(t, err)
+	assert.Equal(t, stats.SizeBytes, info.Size())
+	require.NoError(t, eng.(types.Syncer).Sync(ctx)) // nothing to do
+
+	// The hybrid engine writes through to the file, unsynced
+	cfg := config.DefaultConfig()
+	cfg.DataDir = t.TempDir()
+	hybrid, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer hybrid.Close()
+	fillEngine(t, hybrid, "k", 3)
+	stats = walStats(t, hybrid)
+	assert.Zero(t, stats.Buffered)
+	assert.Equal(t, 3, stats.Pending)
+	require.NoError(t, hybrid.(types.Syncer).Sync(ctx))
+	assert.Zero(t, walStats(t, hybrid).Pending)
+
+	mem, err := kvi.OpenMemory()
+	require.NoError(t, err)
+	defer mem.Close()
+	_, ok := mem.(types.Syncer)
+	assert.False(t, ok)
+	assert.False(t, mem.(types.CapabilityReporter).Capabilities().Sync)
+}
+
+func TestSyncEndpoint(t *testing.T) {
+	eng := openDisk(t, t.TempDir())
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	put := func(url, key string) int {
+		resp, err := http.Post(url+"/api/v1/put?durable=true", "application/json", jsonBody(map[string]interface{}{"key": key, "data": map[string]int{"n": 1}}))
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusCreated, put(ts.URL, "a"))
+	assert.Zero(t, walStats(t, eng).Pending)
+
+	fillEngine(t, eng, "k", 3)
+	assert.Equal(t, 3, walStats(t, eng).Pending)
+	resp, err := http.Post(ts.URL+"/api/v1/sync", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Zero(t, walStats(t, eng).Pending)
+
+	mem, memTS := memoryServer(t)
+	assert.Equal(t, http.StatusNotImplemented, put(memTS.URL, "a"))
+	_, err = mem.Get(context.Background(), "a")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound, "refused before writing")
+	resp, err = http.Post(memTS.URL+"/api/v1/sync", "", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
+
+func TestGrpcDurablePut(t *testing.T) {
+	ctx := context.Background()
+	eng := openDisk(t, t.TempDir())
+	defer eng.Close()
+	client := startGrpc(t, eng, nil)
+
+	_, err := client.Put(ctx, &kvi_grpc.PutRequest{Key: "a", DataJson: `{"n":1}`, Durable: true})
+	require.NoError(t, err)
+	assert.Zero(t, walStats(t, eng).Pending)
+	_, err = client.Put(ctx, &kvi_grpc.PutRequest{Key: "b", DataJson: `{"n":1}`})
+	require.NoError(t, err)
+	assert.Equal(t, 1, walStats(t, eng).Pending)
+
+	mem, err := kvi.OpenMemory()
+	require.NoError(t, err)
+	defer mem.Close()
+	_, err = startGrpc(t, mem, nil).Put(ctx, &kvi_grpc.PutRequest{Key: "a", DataJson: `{}`, Durable: true})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestGroupCommit(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.DiskConfig()
+	cfg.DataDir, cfg.GroupCommit, cfg.SyncIntervalMs = t.TempDir(), true, 5
+	assert.Empty(t, cfg.Warnings())
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+
+	// Each write returns once it is synced
+	require.NoError(t, eng.Put(ctx, "a", &types.Record{Data: map[string]interface{}{"n": 1}}))
+	assert.Zero(t, walStats(t, eng).Pending)
+
+	// Writers waiting at once share a sync
+	var wg sync.WaitGroup
+	for w := range 64 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 10 {
+				key := fmt.Sprintf("w%02d-%d", w, i)
+				assert.NoError(t, eng.Put(ctx, key, &types.Record{Data: map[string]interface{}{"n": i}}))
+			}
+		}()
+	}
+	wg.Wait()
+	stats := walStats(t, eng)
+	assert.Zero(t, stats.Pending)
+	assert.EqualValues(t, 641, stats.Writes)
+	assert.Less(t, stats.Flushes, stats.Writes/4)
+	require.NoError(t, eng.Close())
+	assert.ErrorIs(t, eng.Put(ctx, "late", &types.Record{}), types.ErrClosed)
+
+	eng, err = kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	assert.Equal(t, 641, eng.(types.StatsReporter).Stats().Records)
+
+	cfg.Mode = types.ModeHybrid
+	assert.Equal(t, []string{"group_commit has no effect outside disk mode with enable_wal"}, cfg.Warnings())
+}
+
+// BenchmarkConcurrentPut measures Put latency with 64 writers, with the WAL
+// syncing every 1000 entries inline and committing in groups. Group commit
+// waits for every sync, so compare p99-µs rather than ns/op.
+func BenchmarkConcurrentPut(b *testing.B) {
+	for _, group := range []bool{false, true} {
+		b.Run(map[bool]string{false: "batched", true: "group"}[group], func(b *testing.B) {
+			cfg := config.DiskConfig()
+			cfg.DataDir, cfg.GroupCommit = b.TempDir(), group
+			eng, err := kvi.Open(cfg)
+			require.NoError(b, err)
+			defer eng.Close()
+
+			var mu sync.Mutex
+			var latencies []time.Duration
+			var n atomic.Int64
+			b.SetParallelism(max(1, 64/runtime.GOMAXPROCS(0)))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				var local []time.Duration
+				for pb.Next() {
+					key := fmt.Sprintf("k%d", n.Add(1))
+					start := time.Now()
+					if err := eng.Put(context.Background(), key, &types.Record{Data: map[string]interface{}{"n": 1}}); err != nil {
+						b.Error(err)
+						return
+					}
+					local = append(local, time.Since(start))
+				}
+				mu.Lock()
+				latencies = append(latencies, local...)
+				mu.Unlock()
+			})
+			b.StopTimer()
+			slices.Sort(latencies)
+			if len(latencies) > 0 {
+				b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-µs")
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/backup"
+	"github.com/thirawat27/kvi/internal/sql"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func putDescriptions(t *testing.T, eng types.Engine, docs map[string]string) {
+	t.Helper()
+	for key, text := range docs {
+		require.NoError(t, eng.Put(context.Background(), key, &types.Record{ID: key, Data: map[string]interface{}{"description": text}}))
+	}
+}
+
+func searchKeys(t *testing.T, eng types.Engine, query string) []string {
+	t.Helper()
+	hits, err := eng.(types.TextSearcher).TextSearch(context.Background(), "description", query, 0)
+	require.NoError(t, err)
+	keys := []string{}
+	for _, hit := range hits {
+		keys = append(keys, hit.Record.ID)
+	}
+	return keys
+}
+
+func TestTextIndexRecovery(t *testing.T) {
+	cfg := config.DiskConfig()
+	cfg.DataDir = t.TempDir()
+	cfg.TextIndex.Fields = []string{"description"}
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	putDescriptions(t, eng, map[string]string{
+		"log:1": "connection timeout",
+		"log:2": "disk error",
+		"log:3": "timeout on timeout",
+	})
+	require.NoError(t, eng.Delete(context.Background(), "log:3"))
+	require.NoError(t, eng.Close())
+
+	// The index is rebuilt from the records the WAL brings back
+	eng, err = kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	assert.Equal(t, []string{"log:1"}, searchKeys(t, eng, "timeout"))
+	assert.Equal(t, []string{"log:1", "log:2"}, searchKeys(t, eng, "timeout OR error"))
+}
+
+func TestTextIndexRestore(t *testing.T) {
+	ctx := context.Background()
+	src, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer src.Close()
+	putDescriptions(t, src, map[string]string{"a": "Café au lait", "b": "café crème"})
+	var snapshot bytes.Buffer
+	_, err = backup.Dump(ctx, src, &snapshot)
+	require.NoError(t, err)
+
+	cfg := config.MemoryConfig()
+	cfg.TextIndex = config.TextIndexConfig{Fields: []string{"description"}, Stopwords: []string{"au"}}
+	dst, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer dst.Close()
+	_, err = backup.Restore(ctx, dst, bytes.NewReader(snapshot.Bytes()), backup.Replace)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"a", "b"}, searchKeys(t, dst, "CAFÉ"), "restored records are indexed")
+	assert.Empty(t, searchKeys(t, dst, "au"), "configured stopwords are left out")
+}
+
+func TestTextSearchSQL(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.MemoryConfig()
+	cfg.TextIndex.Fields = []string{"description"}
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	executor := sql.NewExecutor(eng)
+
+	_, err = executor.ExecuteQuery(ctx, "INSERT INTO logs (id, description) VALUES ('1', 'error: timeout'), ('2', 'timeout'), ('3', 'error')")
+	require.NoError(t, err)
+
+	res, err := executor.Execute(ctx, "SELECT * FROM logs WHERE description MATCH 'error timeout'")
+	require.NoError(t, err)
+	records := res.Value.([]*types.Record)
+	require.Len(t, records, 1)
+	assert.Equal(t, "1", records[0].ID)
+
+	res, err = executor.Execute(ctx, "SELECT * FROM logs WHERE MATCH (description) AGAINST ('error OR timeout') LIMIT 2")
+	require.NoError(t, err)
+	assert.Len(t, res.Value, 2)
+	assert.Equal(t, 2, res.RowsScanned)
+	assert.True(t, sql.IsReadOnly("SELECT * FROM logs WHERE description MATCH 'error'"))
+
+	_, err = executor.Execute(ctx, "SELECT * FROM logs WHERE title MATCH 'error'")
+	assert.ErrorIs(t, err, types.ErrNoTextIndex)
+}
+
+func TestTextSearchAPI(t *testing.T) {
+	cfg := config.MemoryConfig()
+	cfg.TextIndex.Fields = []string{"description"}
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+	putDescriptions(t, eng, map[string]string{"x": "slow disk, slow network", "y": "slow start"})
+
+	resp, err := http.Get(ts.URL + "/api/v1/search/text?field=description&q=slow&limit=1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var body struct {
+		Items []struct {
+			Record types.Record `json:"record"`
+			Score  float64      `json:"score"`
+		} `json:"items"`
+		Count int `json:"count"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, 1, body.Count)
+	assert.Equal(t, "x", body.Items[0].Record.ID)
+	assert.Equal(t, 2.0, body.Items[0].Score)
+
+	for url, status := range map[string]int{
+		"/api/v1/search/text?field=description":              http.StatusBadRequest,
+		"/api/v1/search/text?field=title&q=slow":             http.StatusBadRequest,
+		"/api/v1/search/text?field=description&q=x&limit=-1": http.StatusBadRequest,
+	} {
+		resp, err := http.Get(ts.URL + url)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, status, resp.StatusCode, url)
+	}
+}
@@ -0,0 +1,99 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// slowEngine delays reads, giving up as soon as ctx is done like the real
+// engines do between scanned records. Each finished Scan reports its error.
+type slowEngine struct {
+	types.Engine
+	delay   time.Duration
+	scanned chan error
+}
+
+func (e *slowEngine) wait(ctx context.Context) error {
+	select {
+	case <-time.After(e.delay):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *slowEngine) Get(ctx context.Context, key string) (*types.Record, error) {
+	if err := e.wait(ctx); err != nil {
+		return nil, err
+	}
+	return e.Engine.Get(ctx, key)
+}
+
+func (e *slowEngine) Scan(ctx context.Context, prefix string, fn func(*types.Record) bool) error {
+	err := e.Engine.Scan(ctx, prefix, func(rec *types.Record) bool {
+		return e.wait(ctx) == nil && fn(rec)
+	})
+	e.scanned <- err
+	return err
+}
+
+type timeoutError struct {
+	Stage     string `json:"stage"`
+	TimeoutMs int64  `json:"timeout_ms"`
+}
+
+func expectTimeout(t *testing.T, resp *http.Response, stage string) {
+	t.Helper()
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	var body timeoutError
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, stage, body.Stage)
+	assert.Equal(t, int64(100), body.TimeoutMs)
+}
+
+func TestRouteTimeouts(t *testing.T) {
+	mem, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer mem.Close()
+	fillEngine(t, mem, "k", 100)
+	eng := &slowEngine{Engine: mem, delay: 20 * time.Millisecond, scanned: make(chan error, 1)}
+
+	limit := 100 * time.Millisecond
+	ts := httptest.NewServer(api.NewServer(eng, api.WithTimeouts(api.Timeouts{Read: limit, Query: limit})).Handler())
+	defer ts.Close()
+
+	// The scan would take 2s; the client hears back at the deadline and the
+	// engine stops with it
+	start := time.Now()
+	resp, err := http.Get(ts.URL + "/api/v1/scan?prefix=k")
+	assert.NoError(t, err)
+	expectTimeout(t, resp, "engine scan")
+	assert.Less(t, time.Since(start), time.Second)
+	select {
+	case <-eng.scanned:
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("scan kept running after the 504")
+	}
+
+	eng.delay = time.Second
+	resp, err = http.Post(ts.URL+"/api/v1/query", "application/json", jsonBody(map[string]string{"query": "SELECT * FROM t WHERE id = 'k0001'"}))
+	assert.NoError(t, err)
+	expectTimeout(t, resp, "engine")
+
+	// Fast enough requests are unaffected
+	eng.delay = 0
+	var rec types.Record
+	getJSON(t, ts.URL+"/api/v1/get?key=k0001", &rec)
+	assert.Equal(t, "k0001", rec.ID)
+}
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/tracing"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingHTTPQuery(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	defer tp.Shutdown(context.Background())
+
+	cfg := config.MemoryConfig()
+	cfg.TracerProvider = tp
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	require.NoError(t, eng.Put(context.Background(), "u1", &types.Record{ID: "u1", Data: map[string]interface{}{"name": "Ann"}}))
+	ts := httptest.NewServer(api.NewServer(eng, api.WithTracerProvider(tp)).Handler())
+	defer ts.Close()
+	sr.Reset()
+
+	const traceID, callerID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/v1/query", jsonBody(map[string]string{"query": "SELECT * FROM users WHERE id = 'u1'"}))
+	require.NoError(t, err)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+callerID+"-01")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range sr.Ended() {
+		spans[s.Name()] = s
+	}
+	server := spans["POST /api/v1/query"]
+	require.NotNil(t, server, "server span named for the route")
+	assert.Equal(t, trace.SpanKindServer, server.SpanKind())
+	assert.Equal(t, traceID, server.SpanContext().TraceID().String(), "the caller's trace is continued")
+	assert.Equal(t, callerID, server.Parent().SpanID().String())
+
+	for child, parent := range map[string]string{
+		"sql.execute": "POST /api/v1/query",
+		"sql.parse":   "sql.execute",
+		"sql.engine":  "sql.execute",
+		"memory.Get":  "sql.engine",
+	} {
+		require.Contains(t, spans, child)
+		assert.Equal(t, spans[parent].SpanContext().SpanID(), spans[child].Parent().SpanID(), "%s under %s", child, parent)
+		assert.Equal(t, server.SpanContext().TraceID(), spans[child].SpanContext().TraceID())
+	}
+}
+
+func TestTracingDisabled(t *testing.T) {
+	tracer := tracing.New(nil)
+	require.Nil(t, tracer)
+	ctx := context.Background()
+	got, span := tracer.Start(ctx, "memory.Get")
+	assert.Equal(t, ctx, got)
+	assert.False(t, span.IsRecording())
+	allocs := testing.AllocsPerRun(100, func() {
+		_, span := tracer.Start(ctx, "memory.Get")
+		span.End()
+	})
+	assert.Zero(t, allocs, "a nil tracer costs a nil check")
+}
+
+// Example_stdoutTracing prints the spans of an engine, and of a server
+// in front of it, to stdout.
+func Example_stdoutTracing() {
+	exporter, err := stdouttrace.New(stdouttrace.WithPrettyPrint(), stdouttrace.WithWriter(os.Stdout))
+	if err != nil {
+		panic(err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer tp.Shutdown(context.Background())
+
+	cfg := config.MemoryConfig()
+	cfg.TracerProvider = tp // engine spans: memory.Get, memory.Put, ...
+	eng, err := kvi.Open(cfg)
+	if err != nil {
+		panic(err)
+	}
+	defer eng.Close()
+
+	srv := api.NewServer(eng, api.WithTracerProvider(tp)) // request and SQL spans
+	_ = srv.Start(":8080")
+}
@@ -0,0 +1,198 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/engine"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type recordWithTTL struct {
+	types.Record
+	ExpiresIn *int64 `json:"expires_in_seconds"`
+}
+
+func TestPutTTLSeconds(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	put := func(body map[string]interface{}) {
+		body["key"] = "session"
+		body["data"] = map[string]interface{}{"user": "ann"}
+		resp, err := http.Post(ts.URL+"/api/v1/put", "application/json", jsonBody(body))
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+
+	put(map[string]interface{}{"ttl_seconds": 90})
+	var rec recordWithTTL
+	getJSON(t, ts.URL+"/api/v1/get?key=session", &rec)
+	assert.Equal(t, int64(90), *rec.ExpiresIn)
+	assert.NotNil(t, rec.TTL)
+
+	var scanned struct{ Items []recordWithTTL }
+	getJSON(t, ts.URL+"/api/v1/scan?prefix=sess", &scanned)
+	assert.Len(t, scanned.Items, 1)
+	assert.Equal(t, int64(90), *scanned.Items[0].ExpiresIn)
+
+	// Zero clears the TTL and wins over an absolute one
+	put(map[string]interface{}{"ttl_seconds": 0, "ttl": time.Now().Add(time.Hour)})
+	rec = recordWithTTL{}
+	getJSON(t, ts.URL+"/api/v1/get?key=session", &rec)
+	assert.Nil(t, rec.ExpiresIn)
+	assert.Nil(t, rec.TTL)
+
+	// The absolute form still works; a past expiry hides the record at once
+	put(map[string]interface{}{"ttl": time.Now().Add(-time.Second)})
+	resp, err := http.Get(ts.URL + "/api/v1/get?key=session")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	scanned.Items = nil
+	getJSON(t, ts.URL+"/api/v1/scan?prefix=sess", &scanned)
+	assert.Empty(t, scanned.Items)
+}
+
+// An expired record counts as missing for preconditions, but its version is
+// not reused, so stale ETags never match a new incarnation of the key.
+func TestConditionalWritesOnExpiredRecord(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	past := time.Now().Add(-time.Second)
+	assert.NoError(t, eng.Put(context.Background(), "lock", &types.Record{ID: "lock", TTL: &past}))
+
+	body := map[string]interface{}{"key": "lock", "data": map[string]interface{}{"owner": "b"}}
+	resp := conditionalRequest(t, http.MethodPost, ts.URL+"/api/v1/put", "If-Match", `"1"`, body)
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+
+	resp = conditionalRequest(t, http.MethodPost, ts.URL+"/api/v1/put", "If-None-Match", "*", body)
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+}
+
+func TestGrpcPutTTLSeconds(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+	client := startGrpc(t, eng, nil)
+	ctx := context.Background()
+
+	_, err = client.Put(ctx, &kvi_grpc.PutRequest{Key: "g", DataJson: `{"a":1}`, TtlSeconds: 30})
+	assert.NoError(t, err)
+	got, err := client.Get(ctx, &kvi_grpc.GetRequest{Key: "g"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(30), got.ExpiresInSeconds)
+
+	_, err = client.Put(ctx, &kvi_grpc.PutRequest{Key: "g", DataJson: `{"a":2}`, TtlSeconds: -1})
+	assert.NoError(t, err)
+	got, err = client.Get(ctx, &kvi_grpc.GetRequest{Key: "g"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), got.ExpiresInSeconds)
+}
+
+func TestExpiredRecordsOnEverySurface(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.DefaultConfig()
+	cfg.Mode, cfg.DataDir, cfg.VectorDim = types.ModeHybrid, t.TempDir(), 2
+	cfg.TextIndex.Fields = []string{"note"}
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+	client := startGrpc(t, eng, nil)
+	rdb := respClient(t, respServer(t, eng, nil), "")
+
+	soon := time.Now().Add(50 * time.Millisecond)
+	for _, rec := range []*types.Record{
+		{ID: "t:gone", Data: map[string]interface{}{"note": "fleeting", "value": "x"}, Vector: []float32{1, 0}, TTL: &soon},
+		{ID: "t:kept", Data: map[string]interface{}{"note": "fleeting", "value": "y"}, Vector: []float32{0.9, 0.1}},
+	} {
+		require.NoError(t, eng.Put(ctx, rec.ID, rec))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// REST: get, scan, SQL and text search
+	resp, err := http.Get(ts.URL + "/api/v1/get?key=t:gone")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	var scanned struct{ Items []recordWithTTL }
+	getJSON(t, ts.URL+"/api/v1/scan?prefix=t:", &scanned)
+	require.Len(t, scanned.Items, 1)
+	assert.Equal(t, "t:kept", scanned.Items[0].ID)
+	var queried struct{ Items []types.Record }
+	resp, err = http.Post(ts.URL+"/api/v1/query", "application/json", jsonBody(map[string]string{"query": "SELECT * FROM t WHERE note MATCH 'fleeting'"}))
+	require.NoError(t, err)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&queried))
+	resp.Body.Close()
+	require.Len(t, queried.Items, 1)
+	assert.Equal(t, "t:kept", queried.Items[0].ID)
+	var hits struct{ Count int }
+	getJSON(t, ts.URL+"/api/v1/search/text?field=note&q=fleeting", &hits)
+	assert.Equal(t, 1, hits.Count)
+
+	// gRPC: get, batch get and vector search
+	_, err = client.Get(ctx, &kvi_grpc.GetRequest{Key: "t:gone"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	batch, err := client.BatchGet(ctx, &kvi_grpc.BatchGetRequest{Keys: []string{"t:gone", "t:kept"}})
+	require.NoError(t, err)
+	assert.Len(t, batch.Records, 1)
+	found, err := client.VectorSearch(ctx, &kvi_grpc.VectorSearchRequest{Vector: []float32{1, 0}, K: 1})
+	require.NoError(t, err)
+	require.Len(t, found.Results, 1)
+	assert.Equal(t, "t:kept", found.Results[0].Id)
+
+	// RESP
+	assert.ErrorIs(t, rdb.Get(ctx, "t:gone").Err(), redis.Nil)
+	assert.Equal(t, int64(0), rdb.Exists(ctx, "t:gone").Val())
+	assert.Equal(t, time.Duration(-2), rdb.TTL(ctx, "t:gone").Val())
+}
+
+func TestSecondsLeft(t *testing.T) {
+	now := time.Now()
+	rec := &types.Record{}
+	_, ok := rec.SecondsLeft(now)
+	assert.False(t, ok, "no TTL")
+	for ttl, want := range map[time.Duration]int64{90 * time.Second: 90, 1500 * time.Millisecond: 2, time.Millisecond: 1, 0: 0, -time.Minute: 0} {
+		at := now.Add(ttl)
+		rec.TTL = &at
+		secs, ok := rec.SecondsLeft(now)
+		assert.True(t, ok)
+		assert.Equal(t, want, secs, ttl)
+	}
+}
+
+func TestMVCCExpiryAsOf(t *testing.T) {
+	m := engine.NewMVCCManager()
+	soon := time.Now().Add(20 * time.Millisecond)
+	tx := m.Put("k", &types.Record{ID: "k", TTL: &soon})
+	time.Sleep(40 * time.Millisecond)
+
+	rec, _ := m.Get("k")
+	assert.Nil(t, rec, "current reads hide the expired version")
+	assert.Nil(t, m.GetAsOf("k", tx, time.Now()), "judged now, the version has expired")
+	assert.NotNil(t, m.GetAsOf("k", tx, time.Time{}), "judged at the version, it was live")
+}
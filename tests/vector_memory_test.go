@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/vector"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// heapAlloc is the live heap once it settles, so memory still being let
+// go by the goroutines of earlier tests is not counted.
+func heapAlloc() int64 {
+	var m runtime.MemStats
+	last := int64(-1)
+	for range 50 {
+		runtime.GC()
+		runtime.ReadMemStats(&m)
+		if heap := int64(m.HeapAlloc); last < 0 || max(heap-last, last-heap) > 64<<10 {
+			last = heap
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		break
+	}
+	return int64(m.HeapAlloc)
+}
+
+func unitVector(dim, i int) []float32 {
+	vec := make([]float32, dim)
+	vec[i%dim] = 1
+	return vec
+}
+
+// TestVectorIndexAccounting checks the index's memory estimate follows the
+// heap as vectors are added and deleted.
+func TestVectorIndexAccounting(t *testing.T) {
+	const dim, n = 512, 4000
+	index := vector.NewHNSWIndex(dim)
+	heap, estimate := heapAlloc(), index.Stats().MemoryBytes
+	for i := range n {
+		require.NoError(t, index.Add(fmt.Sprintf("doc:%06d", i), unitVector(dim, i)))
+	}
+	added := index.Stats()
+	assert.Equal(t, n, added.Nodes)
+	assert.InEpsilon(t, heapAlloc()-heap, added.MemoryBytes-estimate, 0.05)
+
+	heap = heapAlloc()
+	for i := range n / 2 {
+		index.Delete(fmt.Sprintf("doc:%06d", i))
+	}
+	deleted := index.Stats()
+	assert.Equal(t, n/2, deleted.Nodes)
+	assert.InEpsilon(t, heap-heapAlloc(), added.MemoryBytes-deleted.MemoryBytes, 0.05)
+
+	// Replacing a vector with one of the same size costs nothing
+	require.NoError(t, index.Add("doc:003999", unitVector(dim, 7)))
+	assert.Equal(t, deleted.MemoryBytes, index.Stats().MemoryBytes)
+	runtime.KeepAlive(index)
+}
+
+func TestVectorIndexMemoryLimit(t *testing.T) {
+	ctx := context.Background()
+	const dim = 256 // about 1 KB a vector, so about 950 fit in 1 MB
+	for _, mode := range []types.Mode{types.ModeVector, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.VectorConfig(dim)
+			cfg.Mode, cfg.DataDir, cfg.VectorIndexMaxMemoryMB = mode, t.TempDir(), 1
+			cfg.EnableWAL = mode == types.ModeHybrid
+			assert.Empty(t, cfg.Warnings())
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+
+			var stored int
+			for ; ; stored++ {
+				key := fmt.Sprintf("v%04d", stored)
+				err = eng.Put(ctx, key, &types.Record{ID: key, Vector: unitVector(dim, stored)})
+				if err != nil {
+					break
+				}
+			}
+			assert.ErrorIs(t, err, types.ErrMemoryLimit)
+			assert.Greater(t, stored, 900)
+			stats := eng.(types.StatsReporter).Stats()
+			assert.Equal(t, stored, stats.Vector.Nodes)
+			assert.LessOrEqual(t, stats.Vector.MemoryBytes, int64(1<<20))
+			assert.GreaterOrEqual(t, stats.MemoryUsed, stats.Vector.MemoryBytes)
+
+			// The refused write changed nothing
+			full := fmt.Sprintf("v%04d", stored)
+			_, err = eng.Get(ctx, full)
+			assert.ErrorIs(t, err, types.ErrKeyNotFound)
+
+			// Vectors replaced in place, and hybrid records without one, still go in
+			if mode == types.ModeHybrid {
+				require.NoError(t, eng.Put(ctx, "plain", &types.Record{ID: "plain", Data: map[string]interface{}{"a": 1.0}}))
+			}
+			require.NoError(t, eng.Put(ctx, "v0000", &types.Record{ID: "v0000", Vector: unitVector(dim, 1)}))
+
+			// The API refuses with 507 and ResourceExhausted
+			ts := httptest.NewServer(api.NewServer(eng).Handler())
+			defer ts.Close()
+			body := fmt.Sprintf(`{"key":%q,"vector":[1%s]}`, full, strings.Repeat(",0", dim-1))
+			resp, err := http.Post(ts.URL+"/api/v1/put", "application/json", strings.NewReader(body))
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusInsufficientStorage, resp.StatusCode)
+			_, err = startGrpc(t, eng, nil).Put(ctx, &kvi_grpc.PutRequest{Key: full, DataJson: "{}", Vector: unitVector(dim, 0)})
+			assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+			// A delete makes room again
+			require.NoError(t, eng.Delete(ctx, "v0001"))
+			require.NoError(t, eng.Put(ctx, full, &types.Record{ID: full, Vector: unitVector(dim, 0)}))
+		})
+	}
+}
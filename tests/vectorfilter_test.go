@@ -0,0 +1,327 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/bench"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/client"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func recordIDs(recs []*types.Record) []string {
+	ids := make([]string, len(recs))
+	for i, rec := range recs {
+		ids[i] = rec.ID
+	}
+	return ids
+}
+
+func vectorFilterStats(t *testing.T, eng types.Engine) map[string]types.FilterFieldStats {
+	t.Helper()
+	out := map[string]types.FilterFieldStats{}
+	for _, f := range eng.(types.StatsReporter).Stats().Vector.Filters {
+		out[f.Field] = f
+	}
+	return out
+}
+
+func TestVectorFilterSearch(t *testing.T) {
+	for _, mode := range []types.Mode{types.ModeVector, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			ctx := context.Background()
+			cfg := config.VectorConfig(2)
+			if mode == types.ModeHybrid {
+				cfg = config.HybridConfig()
+				cfg.DataDir, cfg.VectorDim = t.TempDir(), 2
+			}
+			cfg.VectorFilterFields = []string{"tenant"}
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+			fs := eng.(types.FilteredSearcher)
+			assert.Equal(t, []string{"tenant"}, fs.FilterFields())
+
+			for id, rec := range map[string]*types.Record{
+				"a1": {Vector: []float32{1, 0}, Data: map[string]interface{}{"tenant": "a", "tier": 1}},
+				"a2": {Vector: []float32{0.8, 0.2}, Data: map[string]interface{}{"tenant": "a", "tier": 2}},
+				"a3": {Vector: []float32{0, 1}, Data: map[string]interface{}{"tenant": "a", "tier": 1}},
+				"b1": {Vector: []float32{0.9, 0.1}, Data: map[string]interface{}{"tenant": "b", "tier": 1}},
+			} {
+				rec.ID = id
+				require.NoError(t, eng.Put(ctx, id, rec))
+			}
+
+			recs, err := fs.SearchFiltered(ctx, []float32{1, 0}, 2, map[string]interface{}{"tenant": "a"})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a1", "a2"}, recordIDs(recs))
+
+			// Fields without posting lists are checked on the results, and
+			// numbers match whatever their type
+			recs, err = fs.SearchFiltered(ctx, []float32{1, 0}, 5, map[string]interface{}{"tenant": "a", "tier": int64(1)})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a1", "a3"}, recordIDs(recs))
+			recs, err = fs.SearchFiltered(ctx, []float32{1, 0}, 5, map[string]interface{}{"tier": 2.0})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a2"}, recordIDs(recs))
+			recs, err = fs.SearchFiltered(ctx, []float32{1, 0}, 5, map[string]interface{}{"tenant": "c"})
+			require.NoError(t, err)
+			assert.Empty(t, recs)
+			_, err = fs.SearchFiltered(ctx, []float32{1, 0}, 5, map[string]interface{}{"tenant": []string{"a"}})
+			assert.ErrorIs(t, err, types.ErrInvalidFilter)
+
+			assert.Equal(t, types.FilterFieldStats{Field: "tenant", Values: 2, Records: 4}, vectorFilterStats(t, eng)["tenant"])
+
+			// Rewrites and deletes move records between the lists
+			require.NoError(t, eng.Put(ctx, "a1", &types.Record{ID: "a1", Vector: []float32{1, 0}, Data: map[string]interface{}{"tenant": "b"}}))
+			require.NoError(t, eng.Delete(ctx, "a2"))
+			recs, err = fs.SearchFiltered(ctx, []float32{1, 0}, 5, map[string]interface{}{"tenant": "a"})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a3"}, recordIDs(recs))
+			recs, err = fs.SearchFiltered(ctx, []float32{1, 0}, 5, map[string]interface{}{"tenant": "b"})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a1", "b1"}, recordIDs(recs))
+			assert.Equal(t, types.FilterFieldStats{Field: "tenant", Values: 2, Records: 3}, vectorFilterStats(t, eng)["tenant"])
+
+			// Fields declared later are built from the records stored
+			require.NoError(t, fs.SetFilterFields([]string{"tier", "tenant"}))
+			assert.Equal(t, []string{"tenant", "tier"}, fs.FilterFields())
+			assert.Equal(t, types.FilterFieldStats{Field: "tier", Values: 1, Records: 2}, vectorFilterStats(t, eng)["tier"])
+			recs, err = fs.SearchFiltered(ctx, []float32{1, 0}, 5, map[string]interface{}{"tier": 1})
+			require.NoError(t, err)
+			assert.Equal(t, []string{"b1", "a3"}, recordIDs(recs))
+			assert.ErrorIs(t, fs.SetFilterFields([]string{""}), types.ErrInvalidFilter)
+		})
+	}
+}
+
+// TestVectorFilterPushdownMatchesPostFilter checks searches narrowed by
+// posting lists find what checking every result finds, for allowed sets
+// small enough to score one by one and large enough to search through.
+func TestVectorFilterPushdownMatchesPostFilter(t *testing.T) {
+	ctx := context.Background()
+	const dim, n = 8, 2000
+	open := func(fields ...string) types.FilteredSearcher {
+		cfg := config.VectorConfig(dim)
+		cfg.VectorFilterFields = fields
+		eng, err := kvi.Open(cfg)
+		require.NoError(t, err)
+		t.Cleanup(func() { eng.Close() })
+		return eng.(types.FilteredSearcher)
+	}
+	pushed, post := open("tenant", "half"), open()
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range n {
+		vec := make([]float32, dim)
+		for d := range vec {
+			vec[d] = rng.Float32()
+		}
+		rec := &types.Record{ID: bench.Key(i), Vector: vec, Data: map[string]interface{}{"tenant": i % 100, "half": i%2 == 0}}
+		for _, eng := range []types.FilteredSearcher{pushed, post} {
+			copied := *rec
+			require.NoError(t, eng.(types.Engine).Put(ctx, rec.ID, &copied))
+		}
+	}
+	for q, filter := range []map[string]interface{}{
+		{"tenant": 7},                 // 1%
+		{"half": true},                // 50%
+		{"half": false, "tenant": 13}, // 1%, by intersection
+		{"half": true, "tenant": 13},  // none
+	} {
+		query := make([]float32, dim)
+		for d := range query {
+			query[d] = rng.Float32()
+		}
+		want, err := post.SearchFiltered(ctx, query, 10, filter)
+		require.NoError(t, err)
+		got, err := pushed.SearchFiltered(ctx, query, 10, filter)
+		require.NoError(t, err)
+		assert.Equal(t, recordIDs(want), recordIDs(got), "filter %d", q)
+		if q < 3 {
+			assert.Len(t, got, 10)
+		} else {
+			assert.Empty(t, got)
+		}
+	}
+}
+
+func TestVectorFilterGrpc(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.OpenVector(2)
+	require.NoError(t, err)
+	defer eng.Close()
+	for id, tenant := range map[string]string{"a": "x", "b": "y", "c": "x"} {
+		vec := []float32{1, 0}
+		if id == "c" {
+			vec = []float32{0, 1}
+		}
+		require.NoError(t, eng.Put(ctx, id, &types.Record{ID: id, Vector: vec, Data: map[string]interface{}{"tenant": tenant}}))
+	}
+	grpcClient := serveGrpc(t, kvi_grpc.NewGrpcServer(eng, nil))
+	resp, err := grpcClient.VectorSearch(ctx, &kvi_grpc.VectorSearchRequest{Vector: []float32{1, 0}, K: 2, FilterJson: `{"tenant":"x"}`})
+	require.NoError(t, err)
+	require.Len(t, resp.Results, 2)
+	assert.Equal(t, "a", resp.Results[0].Id)
+	assert.Equal(t, "c", resp.Results[1].Id)
+	_, err = grpcClient.VectorSearch(ctx, &kvi_grpc.VectorSearchRequest{Vector: []float32{1, 0}, K: 2, FilterJson: `{"tenant":`})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = grpcClient.VectorSearch(ctx, &kvi_grpc.VectorSearchRequest{Vector: []float32{1, 0}, K: 2, FilterJson: `{"tenant":{"in":["x"]}}`})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, grpcAddr := clientServer(t, eng, pubsub.NewHub(), nil)
+	recs, err := newClient(t, client.WithGRPC(grpcAddr)).VectorSearchFiltered(ctx, []float32{1, 0}, 5, map[string]interface{}{"tenant": "y"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, recordIDs(recs))
+}
+
+func TestVectorFilterAdmin(t *testing.T) {
+	eng, err := kvi.OpenVector(2)
+	require.NoError(t, err)
+	defer eng.Close()
+	require.NoError(t, eng.Put(context.Background(), "a", &types.Record{ID: "a", Vector: []float32{1, 0}, Data: map[string]interface{}{"tenant": "x"}}))
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+	url := ts.URL + "/api/v1/admin/vector-filters"
+
+	var got struct {
+		Fields  []string                 `json:"fields"`
+		Filters []types.FilterFieldStats `json:"filters"`
+	}
+	getJSON(t, url, &got)
+	assert.Empty(t, got.Fields)
+
+	req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(`{"fields":["tenant"]}`))
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	assert.Equal(t, []string{"tenant"}, got.Fields)
+	assert.Equal(t, []types.FilterFieldStats{{Field: "tenant", Values: 1, Records: 1}}, got.Filters)
+
+	req, _ = http.NewRequest(http.MethodPut, url, strings.NewReader(`{"fields":[""]}`))
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	mem, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer mem.Close()
+	bare := httptest.NewServer(api.NewServer(mem).Handler())
+	defer bare.Close()
+	resp, err = http.Get(bare.URL + "/api/v1/admin/vector-filters")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotImplemented, resp.StatusCode)
+}
+
+func TestVectorFilterConfig(t *testing.T) {
+	cfg := config.VectorConfig(4)
+	cfg.VectorFilterFields = []string{"tenant", ""}
+	assert.ErrorContains(t, cfg.Validate(), "vector_filter_fields")
+	cfg = config.MemoryConfig()
+	cfg.VectorFilterFields = []string{"tenant"}
+	assert.Contains(t, strings.Join(cfg.Warnings(), "\n"), "vector_filter_fields")
+}
+
+func TestBenchVectorFiltered(t *testing.T) {
+	for _, pushdown := range []bool{true, false} {
+		o := benchOptions(bench.Vector)
+		o.Selectivity, o.FilterPushdown = 10, pushdown
+		eng, err := kvi.Open(config.VectorConfig(o.Dim))
+		require.NoError(t, err)
+		report, err := bench.Run(context.Background(), eng, o)
+		require.NoError(t, err)
+		assert.Positive(t, report.Operations["search"].Count)
+		assert.Zero(t, report.Errors)
+		assert.Equal(t, 10.0, report.Selectivity)
+		assert.Equal(t, pushdown, report.FilterPushdown)
+		if assert.NotNil(t, report.Recall) {
+			assert.InDelta(t, 1.0, *report.Recall, 0.05)
+		}
+		if pushdown {
+			assert.Equal(t, []string{bench.TenantField}, eng.(types.FilteredSearcher).FilterFields())
+		}
+		eng.Close()
+	}
+
+	mem, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer mem.Close()
+	o := benchOptions(bench.Vector)
+	o.Selectivity = 1
+	_, err = bench.Run(context.Background(), mem, o)
+	assert.Error(t, err)
+	o.Selectivity = 101
+	assert.Error(t, o.Validate())
+}
+
+// BenchmarkVectorFilter compares searches narrowed by posting lists with
+// those checking the records they find, at 1% and 50% selectivity, and
+// reports the recall of each.
+func BenchmarkVectorFilter(b *testing.B) {
+	const n, dim, k = 20000, 64, 10
+	ctx := context.Background()
+	emb := bench.NewEmbeddings(dim, 32, 0.1, 1)
+	queries := make([][]float32, 20)
+	for q := range queries {
+		queries[q] = emb.Query(q)
+	}
+	for _, selectivity := range []int{1, 50} {
+		tenants := 100 / selectivity
+		exact := emb.NearestWhere(queries, n, k, func(q, i int) bool { return q%tenants == i%tenants })
+		for _, pushdown := range []bool{true, false} {
+			name := fmt.Sprintf("%dpct/postfilter", selectivity)
+			if pushdown {
+				name = fmt.Sprintf("%dpct/pushdown", selectivity)
+			}
+			b.Run(name, func(b *testing.B) {
+				eng, err := kvi.Open(config.VectorConfig(dim))
+				require.NoError(b, err)
+				defer eng.Close()
+				fs := eng.(types.FilteredSearcher)
+				if pushdown {
+					require.NoError(b, fs.SetFilterFields([]string{"tenant"}))
+				}
+				for i := range n {
+					key := bench.Key(i)
+					require.NoError(b, eng.Put(ctx, key, &types.Record{ID: key, Vector: emb.Vector(i), Data: map[string]interface{}{"tenant": i % tenants}}))
+				}
+
+				hits, searches := 0, 0
+				for b.Loop() {
+					q := searches % len(queries)
+					recs, err := fs.SearchFiltered(ctx, queries[q], k, map[string]interface{}{"tenant": q % tenants})
+					require.NoError(b, err)
+					want := map[string]bool{}
+					for _, i := range exact[q] {
+						want[bench.Key(i)] = true
+					}
+					for _, rec := range recs {
+						if want[rec.ID] {
+							hits++
+						}
+					}
+					searches++
+				}
+				b.ReportMetric(float64(hits)/float64(searches*k), "recall")
+			})
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// vectorLoadDir writes n records with vectors pointing every which way
+// but negative, and three with a negative one each, to a hybrid data
+// directory it returns the config of.
+func vectorLoadDir(t *testing.T, n int) *config.Config {
+	t.Helper()
+	ctx := context.Background()
+	cfg := config.HybridConfig()
+	cfg.DataDir, cfg.VectorDim, cfg.GCIntervalMs = t.TempDir(), 4, 0
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	for i := range n {
+		key := fmt.Sprintf("doc:%05d", i)
+		vec := []float32{float32(1 + i%7), float32(1 + i%11), float32(1 + i%13), 1}
+		require.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, Vector: vec}))
+	}
+	for key, vec := range map[string][]float32{"moved": {0, 0, 0, -1}, "gone": {0, -1, 0, 0}, "plain": {0, 0, -1, 0}} {
+		require.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, Vector: vec}))
+	}
+	require.NoError(t, eng.Close())
+	return cfg
+}
+
+func nearest(t *testing.T, eng types.Engine, query ...float32) string {
+	t.Helper()
+	found, err := eng.(types.Searcher).Search(context.Background(), query, 1)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	return found[0].ID
+}
+
+func TestVectorIndexLoad(t *testing.T) {
+	ctx := context.Background()
+	cfg := vectorLoadDir(t, 5000)
+	cfg.VectorIndexLoad = config.VectorLoadLazy
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	load := func() *types.IndexLoadStatus { return eng.(types.StatsReporter).Stats().Vector.Load }
+
+	// Nothing is indexed before the first search, and keys are served
+	assert.Equal(t, "pending", load().State)
+	assert.Zero(t, eng.(types.StatsReporter).Stats().Vector.Nodes)
+	_, err = eng.Get(ctx, "doc:00042")
+	require.NoError(t, err)
+	ready := eng.(types.HealthChecker).HealthChecks()["vector_index"]
+	require.NotNil(t, ready)
+	assert.NoError(t, ready(ctx), "a lazy load not started holds nothing back")
+
+	// Writes before and during the load win over what it finds on disk
+	require.NoError(t, eng.Put(ctx, "moved", &types.Record{ID: "moved", Vector: []float32{-1, 0, 0, 0}}))
+	require.NoError(t, eng.Delete(ctx, "gone"))
+	require.NoError(t, eng.Put(ctx, "plain", &types.Record{ID: "plain"}))
+
+	_, err = eng.(types.Searcher).Search(ctx, []float32{1, 1, 1, 1}, 1)
+	assert.ErrorIs(t, err, types.ErrIndexLoading)
+	assert.Error(t, ready(ctx))
+	require.NoError(t, eng.Put(ctx, "new", &types.Record{ID: "new", Vector: []float32{-1, -1, -1, -1}}))
+	require.Eventually(t, func() bool { return load().State == "loaded" }, 10*time.Second, 10*time.Millisecond)
+
+	st := load()
+	assert.GreaterOrEqual(t, st.Total, int64(5002), "what disk held as the load began")
+	assert.Equal(t, st.Total, st.Loaded)
+	assert.EqualValues(t, 100, st.Percent)
+	assert.Positive(t, st.DurationMs)
+	assert.NoError(t, ready(ctx))
+	assert.Equal(t, 5002, eng.(types.StatsReporter).Stats().Vector.Nodes)
+	assert.Equal(t, "moved", nearest(t, eng, -1, 0, 0, 0))
+	assert.Equal(t, "new", nearest(t, eng, -1, -1, -1, -1))
+	assert.NotEqual(t, "gone", nearest(t, eng, 0, -1, 0, 0))
+	assert.NotEqual(t, "plain", nearest(t, eng, 0, 0, -1, 0))
+	assert.NotEqual(t, "moved", nearest(t, eng, 0, 0, 0, -1))
+	report, err := eng.(types.Verifier).Verify(ctx, types.VerifyOptions{})
+	require.NoError(t, err)
+	assert.Contains(t, report.Checks, types.IndexVector)
+	assert.True(t, report.OK(), report.Issues)
+}
+
+func TestVectorIndexLoadBackground(t *testing.T) {
+	cfg := vectorLoadDir(t, 500)
+	cfg.VectorIndexLoad, cfg.VectorIndexReadiness = config.VectorLoadBackground, config.ReadyForKV
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+
+	// It starts by itself, and readiness does not wait for it
+	require.Eventually(t, func() bool {
+		return eng.(types.StatsReporter).Stats().Vector.Load.State == "loaded"
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "gone", nearest(t, eng, 0, -1, 0, 0))
+	assert.NotContains(t, eng.(types.HealthChecker).HealthChecks(), "vector_index")
+
+	// Eager loads need no status
+	cfg.VectorIndexLoad = config.VectorLoadEager
+	require.NoError(t, eng.Close())
+	eng, err = kvi.Open(cfg)
+	require.NoError(t, err)
+	assert.Nil(t, eng.(types.StatsReporter).Stats().Vector.Load)
+	assert.Equal(t, "gone", nearest(t, eng, 0, -1, 0, 0))
+}
+
+func TestVectorIndexLoadGrpc(t *testing.T) {
+	cfg := vectorLoadDir(t, 5000)
+	cfg.VectorIndexLoad = config.VectorLoadLazy
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	client := serveGrpc(t, kvi_grpc.NewGrpcServer(eng, nil))
+
+	_, err = client.VectorSearch(context.Background(), &kvi_grpc.VectorSearchRequest{Vector: []float32{1, 1, 1, 1}, K: 1})
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.ErrorContains(t, err, "vector index loading")
+}
+
+func TestVectorIndexLoadConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.VectorIndexLoad = "later"
+	assert.ErrorContains(t, cfg.Validate(), "vector_index_load")
+	cfg.VectorIndexLoad, cfg.VectorIndexReadiness = config.VectorLoadLazy, "never"
+	assert.ErrorContains(t, cfg.Validate(), "vector_index_readiness")
+	cfg = config.VectorConfig(4)
+	cfg.VectorIndexLoad = config.VectorLoadBackground
+	assert.NoError(t, cfg.Validate())
+	assert.Contains(t, cfg.Warnings()[0], "vector_index_load has no effect in vector mode")
+}
@@ -0,0 +1,193 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	for mode, checks := range map[types.Mode][]string{
+		types.ModeMemory:   {types.IndexTags},
+		types.ModeDisk:     {types.CheckWAL, types.IndexTags},
+		types.ModeColumnar: {types.IndexTags},
+		types.ModeVector:   {types.IndexTags, types.IndexVector},
+		types.ModeHybrid:   {types.CheckWAL, types.CheckMemory, types.IndexTags, types.IndexVector},
+	} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.VectorConfig(4)
+			cfg.Mode, cfg.DataDir = mode, t.TempDir()
+			cfg.EnableWAL = mode == types.ModeDisk || mode == types.ModeHybrid
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			for i := range 20 {
+				key := fmt.Sprintf("doc:%02d", i)
+				require.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, Tags: []string{"a"}, Vector: unitVector(4, i%4)}))
+			}
+			require.NoError(t, eng.Delete(ctx, "doc:00"))
+
+			report, err := eng.(types.Verifier).Verify(ctx, types.VerifyOptions{})
+			require.NoError(t, err)
+			assert.True(t, report.OK(), "%+v", report.Issues)
+			assert.Equal(t, checks, report.Checks)
+			assert.Equal(t, 19, report.Records)
+			assert.Empty(t, report.Issues)
+			if cfg.EnableWAL {
+				assert.Equal(t, 21, report.WALEntries)
+			}
+			require.NoError(t, eng.Close())
+
+			// Verifying on start opens a sound engine as usual
+			cfg.VerifyOnStart = true
+			eng, err = kvi.Open(cfg)
+			require.NoError(t, err)
+			require.NoError(t, eng.Close())
+		})
+	}
+}
+
+func TestVerifyRepair(t *testing.T) {
+	ctx := context.Background()
+	for _, mode := range []types.Mode{types.ModeMemory, types.ModeVector} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.VectorConfig(4)
+			cfg.Mode = mode
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+			rec := &types.Record{ID: "doc", Tags: []string{"a"}, Vector: unitVector(4, 0)}
+			require.NoError(t, eng.Put(ctx, "doc", rec))
+
+			// A caller changing a record it has put leaves the indexes behind
+			rec.Tags, rec.Vector = []string{"b"}, unitVector(4, 1)
+			verifier := eng.(types.Verifier)
+			report, err := verifier.Verify(ctx, types.VerifyOptions{})
+			require.NoError(t, err)
+			assert.False(t, report.OK())
+			require.NotEmpty(t, report.Issues)
+			for _, issue := range report.Issues {
+				assert.Equal(t, "doc", issue.Key)
+				assert.True(t, issue.Repairable)
+				assert.False(t, issue.Repaired)
+			}
+			assert.Equal(t, []string{}, tagScan(t, eng, "b", ""))
+
+			report, err = verifier.Verify(ctx, types.VerifyOptions{Repair: true})
+			require.NoError(t, err)
+			assert.True(t, report.OK())
+			assert.Equal(t, report.Found, report.Repaired)
+			assert.True(t, report.Issues[0].Repaired)
+			assert.Equal(t, []string{"doc"}, tagScan(t, eng, "b", ""))
+			if mode == types.ModeVector {
+				found, err := eng.(types.Searcher).Search(ctx, unitVector(4, 1), 1)
+				require.NoError(t, err)
+				require.Len(t, found, 1)
+				assert.Equal(t, "doc", found[0].ID)
+			}
+
+			report, err = verifier.Verify(ctx, types.VerifyOptions{})
+			require.NoError(t, err)
+			assert.Zero(t, report.Found)
+		})
+	}
+}
+
+func TestVerifyWAL(t *testing.T) {
+	path := writeWAL(t, 5)
+	original, err := os.ReadFile(path)
+	require.NoError(t, err)
+	frames := scanWAL(t, path)
+
+	res, err := wal.VerifyFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 6, res.Entries)
+	assert.EqualValues(t, 6, res.LastLSN)
+	assert.Empty(t, res.Problems)
+
+	// A damaged entry, entries replayed out of order and a torn tail
+	damaged := bytes.Clone(original)
+	damaged[frames[3].Offset+10] ^= 0x01
+	damaged = append(damaged, original[frames[4].Offset:]...)
+	damaged = append(damaged, original[frames[0].Offset:frames[1].Offset-2]...)
+	require.NoError(t, os.WriteFile(path, damaged, 0o644))
+	res, err = wal.VerifyFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 5, res.Entries)
+	require.Len(t, res.Problems, 4)
+	assert.Equal(t, frames[3].Offset, res.Problems[0].Offset)
+	assert.NotContains(t, res.Problems[0].Err, wal.ErrOutOfOrder.Error())
+	for _, p := range res.Problems[1:3] {
+		assert.Contains(t, p.Err, wal.ErrOutOfOrder.Error())
+	}
+	assert.EqualValues(t, 5, res.Problems[1].LSN)
+	assert.True(t, res.Problems[3].Tail)
+	assert.False(t, res.Problems[0].Tail)
+}
+
+// TestVerifyReadOnly opens a data directory as kvi verify does, read-only,
+// so a torn tail is reported and a mistyped directory is not created.
+func TestVerifyReadOnly(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	eng, err := kvi.OpenDisk(dir)
+	require.NoError(t, err)
+	fillEngine(t, eng, "k", 5)
+	require.NoError(t, eng.Close())
+	path := filepath.Join(dir, wal.FileName)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0x20, 0x00})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	logged, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	for _, mode := range []types.Mode{types.ModeDisk, types.ModeHybrid} {
+		t.Run(string(mode), func(t *testing.T) {
+			cfg := config.DefaultConfig()
+			cfg.Mode, cfg.DataDir = mode, dir
+			eng, err := kvi.OpenReadOnly(cfg)
+			require.NoError(t, err)
+			report, err := eng.(types.Verifier).Verify(ctx, types.VerifyOptions{Repair: true})
+			require.NoError(t, err)
+			require.NoError(t, eng.Close())
+
+			assert.Equal(t, 5, report.Records)
+			assert.Equal(t, 5, report.WALEntries)
+			require.Equal(t, 1, report.Found, "the torn tail")
+			assert.Equal(t, types.CheckWAL, report.Issues[0].Check)
+			assert.Contains(t, report.Issues[0].Detail, wal.ErrTruncated.Error())
+			assert.False(t, report.OK())
+
+			now, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, logged, now, "the log is left byte for byte")
+		})
+	}
+
+	cfg := config.DiskConfig()
+	cfg.DataDir = filepath.Join(t.TempDir(), "typo")
+	_, err = kvi.OpenReadOnly(cfg)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoDirExists(t, cfg.DataDir)
+}
+
+func TestVerifyOnStartConfig(t *testing.T) {
+	cfg := config.DiskConfig()
+	cfg.DataDir, cfg.VerifyOnStart = t.TempDir(), true
+	cfg.RecoveryStartup = config.RecoveryBackground
+	assert.ErrorContains(t, cfg.Validate(), "verify_on_start")
+	cfg.RecoveryStartup = config.RecoveryBlock
+	assert.NoError(t, cfg.Validate())
+}
@@ -0,0 +1,203 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/wal"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// writeWAL writes n puts of keys k0… and a delete of k0, and returns the
+// log's path.
+func writeWAL(t *testing.T, n int) string {
+	t.Helper()
+	dir := t.TempDir()
+	w, err := wal.NewWAL(dir)
+	assert.NoError(t, err)
+	for i := range n {
+		key := fmt.Sprintf("k%d", i)
+		assert.NoError(t, w.WriteEntry(types.OpPut, key, &types.Record{ID: key, Data: map[string]interface{}{"i": i, "big": uint64(1) << 60, "f": 0.1}}))
+	}
+	assert.NoError(t, w.WriteEntry(types.OpDelete, "k0", nil))
+	assert.NoError(t, w.Close())
+	return filepath.Join(dir, wal.FileName)
+}
+
+func scanWAL(t *testing.T, path string) []wal.Frame {
+	t.Helper()
+	f, err := os.Open(path)
+	assert.NoError(t, err)
+	defer f.Close()
+	var frames []wal.Frame
+	assert.NoError(t, wal.Scan(f, func(frame wal.Frame) bool {
+		frames = append(frames, frame)
+		return true
+	}))
+	return frames
+}
+
+func TestWALScan(t *testing.T) {
+	path := writeWAL(t, 5)
+	frames := scanWAL(t, path)
+	assert.Len(t, frames, 6)
+	offset := frames[0].Offset
+	assert.EqualValues(t, 32, offset, "the first entry follows the header")
+	for i, frame := range frames {
+		assert.NoError(t, frame.Err, i)
+		assert.Equal(t, offset, frame.Offset)
+		assert.Equal(t, uint64(i+1), frame.Entry.LSN)
+		offset += frame.Size
+	}
+	assert.Equal(t, types.OpDelete, frames[5].Entry.Op)
+
+	// Damage one payload and cut the last frame short
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	at := frames[2].Offset + 4 + int64(bytes.Index(data[frames[2].Offset+4:], []byte(`"k2"`))) + 2
+	data[at] = '7'
+	data = data[:len(data)-3]
+	assert.NoError(t, os.WriteFile(path, data, 0o644))
+
+	frames = scanWAL(t, path)
+	assert.Len(t, frames, 6)
+	assert.ErrorIs(t, frames[2].Err, wal.ErrChecksum)
+	assert.Equal(t, "k7", frames[2].Entry.Key) // still decoded, for inspection
+	assert.True(t, frames[3].Valid())
+	assert.ErrorIs(t, frames[5].Err, wal.ErrTruncated)
+
+	// A length prefix that cannot be real ends the scan
+	assert.NoError(t, os.WriteFile(path, append(data[:frames[1].Offset], 0xff, 0xff, 0xff, 0xff, '{'), 0o644))
+	frames = scanWAL(t, path)
+	assert.Len(t, frames, 2)
+	assert.ErrorIs(t, frames[1].Err, wal.ErrBadLength)
+
+	assert.NoError(t, os.WriteFile(path, append(data[:frames[1].Offset], 3, 0, 0, 0, 'n', 'o', '!'), 0o644))
+	frames = scanWAL(t, path)
+	assert.ErrorIs(t, frames[1].Err, wal.ErrMalformed)
+}
+
+func TestWALRepair(t *testing.T) {
+	path := writeWAL(t, 5)
+	original, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	frames := scanWAL(t, path)
+
+	// An intact log is left alone, without a backup
+	backup := path + ".bak"
+	res, err := wal.Repair(path, backup)
+	assert.NoError(t, err)
+	assert.Equal(t, wal.RepairResult{Kept: 6, KeptBytes: int64(len(original)) - frames[0].Offset}, res)
+	assert.NoFileExists(t, backup)
+
+	damaged := bytes.Clone(original)
+	damaged[frames[3].Offset+10] ^= 0x01
+	assert.NoError(t, os.WriteFile(path, damaged, 0o644))
+	res, err = wal.Repair(path, backup)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, res.Kept)
+	assert.Equal(t, 3, res.Dropped)
+	assert.Equal(t, 2, res.DroppedValid)
+	assert.Equal(t, int64(len(original))-frames[3].Offset, res.DroppedBytes)
+
+	saved, err := os.ReadFile(backup)
+	assert.NoError(t, err)
+	assert.Equal(t, damaged, saved)
+	repaired, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, original[:frames[3].Offset], repaired)
+
+	// An existing backup is never overwritten
+	assert.NoError(t, os.WriteFile(path, damaged, 0o644))
+	_, err = wal.Repair(path, backup)
+	assert.ErrorIs(t, err, os.ErrExist)
+}
+
+func TestDiskEngineRecovery(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	eng, err := kvi.OpenDisk(dir)
+	require.NoError(t, err)
+	expired := time.Now().Add(-time.Second)
+	assert.NoError(t, eng.Put(ctx, "a", &types.Record{ID: "a", Data: map[string]interface{}{"n": 1.0}}))
+	assert.NoError(t, eng.Put(ctx, "a", &types.Record{ID: "a", Data: map[string]interface{}{"n": 2.0}}))
+	assert.NoError(t, eng.Put(ctx, "b", &types.Record{ID: "b", Data: map[string]interface{}{"n": 3.0}}))
+	assert.NoError(t, eng.Put(ctx, "gone", &types.Record{ID: "gone"}))
+	assert.NoError(t, eng.Delete(ctx, "gone"))
+	assert.NoError(t, eng.Put(ctx, "old", &types.Record{ID: "old", TTL: &expired}))
+	before, err := eng.Get(ctx, "a")
+	require.NoError(t, err)
+	require.NoError(t, eng.Close())
+
+	eng, err = kvi.OpenDisk(dir)
+	require.NoError(t, err)
+	got, err := eng.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, got.Data["n"], "numbers come back as the API decodes them")
+	assert.Equal(t, uint64(2), got.Version)
+	assert.True(t, before.CreatedAt.Equal(got.CreatedAt))
+	_, err = eng.Get(ctx, "gone")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+	_, err = eng.Get(ctx, "old")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+
+	// Versions and LSNs carry on, and later writes survive the next reopen
+	assert.NoError(t, eng.Put(ctx, "a", &types.Record{ID: "a", Data: map[string]interface{}{"n": 4.0}}))
+	got, err = eng.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), got.Version)
+	assert.Equal(t, uint64(7), eng.(types.StatsReporter).Stats().WAL.LastLSN)
+	require.NoError(t, eng.Close())
+
+	eng, err = kvi.OpenDisk(dir)
+	require.NoError(t, err)
+	defer eng.Close()
+	got, err = eng.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.Equal(t, 4.0, got.Data["n"])
+	_, err = eng.Get(ctx, "b")
+	assert.NoError(t, err)
+}
+
+func TestWALRecoveryDamage(t *testing.T) {
+	path := writeWAL(t, 5)
+	dir := filepath.Dir(path)
+	original, err := os.ReadFile(path)
+	require.NoError(t, err)
+	frames := scanWAL(t, path)
+
+	// A write torn by a crash is cut off, and what follows is readable
+	assert.NoError(t, os.WriteFile(path, original[:len(original)-3], 0o644))
+	eng, err := kvi.OpenDisk(dir)
+	require.NoError(t, err)
+	_, err = eng.Get(context.Background(), "k0")
+	assert.NoError(t, err, "the torn delete of k0 is lost")
+	assert.NoError(t, eng.Put(context.Background(), "k9", &types.Record{ID: "k9"}))
+	require.NoError(t, eng.Close())
+	frames = scanWAL(t, path)
+	assert.Len(t, frames, 6)
+	for _, frame := range frames {
+		assert.True(t, frame.Valid())
+	}
+	assert.Equal(t, "k9", frames[5].Entry.Key)
+	assert.Equal(t, uint64(6), frames[5].Entry.LSN)
+
+	// Damage before the end is left for kvi wal repair
+	damaged := bytes.Clone(original)
+	damaged[frames[2].Offset+10] ^= 0x01
+	assert.NoError(t, os.WriteFile(path, damaged, 0o644))
+	_, err = kvi.OpenDisk(dir)
+	assert.ErrorIs(t, err, wal.ErrMalformed)
+	assert.ErrorContains(t, err, "kvi wal repair")
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, damaged, after, "a log that fails to replay is not touched")
+}
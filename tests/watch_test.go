@@ -0,0 +1,124 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func recvEvent(t *testing.T, stream kvi_grpc.KviService_WatchClient) *kvi_grpc.WatchEvent {
+	t.Helper()
+	ev, err := stream.Recv()
+	assert.NoError(t, err)
+	return ev
+}
+
+func TestWatch(t *testing.T) {
+	cfg := config.DefaultConfig() // hybrid
+	cfg.DataDir = t.TempDir()
+	eng, err := kvi.Open(cfg)
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	client := serveGrpc(t, kvi_grpc.NewGrpcServer(eng, pubsub.NewHub(), kvi_grpc.WithWatchHeartbeat(50*time.Millisecond)))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := client.Watch(ctx, &kvi_grpc.WatchRequest{Prefix: "user:"})
+	assert.NoError(t, err)
+	// The stream is live once the first heartbeat arrives
+	assert.Equal(t, "heartbeat", recvEvent(t, stream).Op)
+
+	past := time.Now().Add(-time.Second)
+	assert.NoError(t, eng.Put(ctx, "user:1", &types.Record{ID: "user:1", Data: map[string]interface{}{"n": 1}}))
+	assert.NoError(t, eng.Put(ctx, "order:1", &types.Record{ID: "order:1", Data: map[string]interface{}{}}))
+	_, err = eng.Update(ctx, "user:1", func(rec *types.Record) error { rec.Data["n"] = 2; return nil })
+	assert.NoError(t, err)
+	assert.NoError(t, eng.Delete(ctx, "user:1"))
+	assert.NoError(t, eng.Put(ctx, "user:2", &types.Record{ID: "user:2", Data: map[string]interface{}{}, TTL: &past}))
+	assert.NoError(t, eng.(types.Maintainer).Maintenance()[types.MaintenanceGC](ctx, func(int, int) {}))
+
+	var seqs []uint64
+	for _, want := range []struct {
+		op, key string
+		version uint64
+	}{
+		{"put", "user:1", 1},
+		{"put", "user:1", 2},
+		{"delete", "user:1", 2},
+		{"put", "user:2", 1},
+		{"expire", "user:2", 1},
+	} {
+		ev := recvEvent(t, stream)
+		for ev.Op == "heartbeat" {
+			ev = recvEvent(t, stream)
+		}
+		assert.Equal(t, want.op, ev.Op)
+		assert.Equal(t, want.key, ev.Key)
+		assert.Equal(t, want.version, ev.Record.Version)
+		seqs = append(seqs, ev.Seq)
+	}
+	assert.IsIncreasing(t, seqs)
+	assert.Equal(t, seqs[0]+2, seqs[1]) // order:1's change was filtered out
+
+	// Heartbeats carry the last seq, and a resumed watch replays what came after
+	ev := recvEvent(t, stream)
+	assert.Equal(t, "heartbeat", ev.Op)
+	assert.Equal(t, seqs[4], ev.Seq)
+
+	resumed, err := client.Watch(ctx, &kvi_grpc.WatchRequest{Prefix: "user:", FromSeq: seqs[1]})
+	assert.NoError(t, err)
+	assert.Equal(t, seqs[2], recvEvent(t, resumed).Seq)
+
+	// Cancelling a watch ends it cleanly
+	cancel()
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Canceled, status.Code(err))
+
+	// Changes from before a restart, or the future, cannot be replayed
+	stream, err = client.Watch(context.Background(), &kvi_grpc.WatchRequest{FromSeq: 1 << 40})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.OutOfRange, status.Code(err))
+}
+
+func TestWatchUnsupported(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	// Every built-in engine has a change feed; embedding hides it.
+	bare := struct{ types.Engine }{eng}
+	stream, err := startGrpc(t, bare, pubsub.NewHub()).Watch(context.Background(), &kvi_grpc.WatchRequest{})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+// A watcher that stops reading is dropped rather than holding up writers.
+func TestWatchLagging(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	assert.NoError(t, err)
+	defer eng.Close()
+
+	ctx := context.Background()
+	events, err := eng.(types.Watcher).Watch(ctx, "", 0)
+	assert.NoError(t, err)
+	fillEngine(t, eng, "k", 5000)
+
+	n := 0
+	for range events {
+		n++
+	}
+	assert.Less(t, n, 5000)
+	assert.Positive(t, n)
+}
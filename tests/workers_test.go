@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/config"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+)
+
+// workerStats returns the stats of eng's worker called name.
+func workerStats(t *testing.T, eng types.Engine, name string) types.WorkerStats {
+	t.Helper()
+	for _, w := range eng.(types.WorkerReporter).Workers() {
+		if w.Name == name {
+			return w
+		}
+	}
+	t.Fatalf("no worker %q", name)
+	return types.WorkerStats{}
+}
+
+// metrics fetches the Prometheus metrics of the server at url.
+func metrics(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain; version=0.0.4")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestWorkerStats(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.HybridConfig()
+	cfg.DataDir, cfg.GCIntervalMs = t.TempDir(), 20
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+
+	expired := time.Now().Add(-time.Second)
+	for _, key := range []string{"a", "b", "c"} {
+		require.NoError(t, eng.Put(ctx, key, &types.Record{ID: key, TTL: &expired}))
+	}
+	assert.Eventually(t, func() bool { return workerStats(t, eng, "gc").Processed == 3 }, 5*time.Second, 10*time.Millisecond)
+	gc := workerStats(t, eng, "gc")
+	assert.EqualValues(t, 20, gc.IntervalMs)
+	assert.NotZero(t, gc.Cycles)
+	assert.WithinDuration(t, time.Now(), gc.LastRun, time.Second)
+	assert.False(t, gc.Stalled)
+
+	writer := workerStats(t, eng, "async_writer")
+	assert.GreaterOrEqual(t, writer.Processed, uint64(3)) // the three puts reached disk
+	assert.EqualValues(t, 1000, writer.IntervalMs)
+	assert.Zero(t, writer.QueueDepth)
+	assert.Zero(t, writer.Errors)
+
+	// Both are in the stats payload and in /metrics
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+	var report struct {
+		Engine struct {
+			Workers []types.WorkerStats `json:"workers"`
+		} `json:"engine"`
+	}
+	getJSON(t, ts.URL+"/api/v1/stats", &report)
+	require.Len(t, report.Engine.Workers, 3) // and the disk monitor
+
+	body := metrics(t, ts.URL)
+	assert.Contains(t, body, "# TYPE kvi_worker_queue_depth gauge\n")
+	assert.Contains(t, body, `kvi_worker_queue_depth{worker="async_writer"} 0`+"\n")
+	assert.Contains(t, body, `kvi_worker_processed_total{worker="gc"} 3`+"\n")
+	assert.Contains(t, body, `kvi_worker_stalled{worker="gc"} 0`+"\n")
+	assert.Contains(t, body, `kvi_worker_interval_seconds{worker="gc"} 0.02`+"\n")
+}
+
+func TestWorkerWatchdog(t *testing.T) {
+	ctx := context.Background()
+	var logs syncBuffer
+	cfg := config.MemoryConfig()
+	cfg.GCIntervalMs, cfg.WorkerStallIntervals = 10, 3
+	cfg.Logger = slog.New(slog.NewJSONHandler(&logs, nil))
+	eng, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer eng.Close()
+	require.NoError(t, eng.Put(ctx, "k", &types.Record{ID: "k"}))
+	check := eng.(types.HealthChecker).HealthChecks()["workers"]
+	require.NoError(t, check(ctx))
+
+	// An update holding the engine's lock keeps the collector from its
+	// next pass
+	release, updated := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(updated)
+		eng.Update(ctx, "k", func(*types.Record) error {
+			<-release
+			return nil
+		})
+	}()
+	assert.Eventually(t, func() bool { return workerStats(t, eng, "gc").Stalled }, 5*time.Second, 5*time.Millisecond)
+	assert.ErrorContains(t, check(ctx), "stalled: gc")
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+	assert.Contains(t, metrics(t, ts.URL), `kvi_worker_stalled{worker="gc"} 1`+"\n")
+	assert.Eventually(t, func() bool {
+		for _, line := range logs.lines(t) {
+			if line["msg"] == "background worker stalled" && line["worker"] == "gc" {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 5*time.Millisecond)
+
+	close(release)
+	<-updated
+	assert.Eventually(t, func() bool { return check(ctx) == nil }, 5*time.Second, 5*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		for _, line := range logs.lines(t) {
+			if line["msg"] == "background worker recovered" {
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 5*time.Millisecond)
+
+	// 0 turns the watchdog off
+	cfg = config.MemoryConfig()
+	cfg.GCIntervalMs, cfg.WorkerStallIntervals = 10, 0
+	quiet, err := kvi.Open(cfg)
+	require.NoError(t, err)
+	defer quiet.Close()
+	require.NoError(t, quiet.Put(ctx, "k", &types.Record{ID: "k"}))
+	release = make(chan struct{})
+	go quiet.Update(ctx, "k", func(*types.Record) error {
+		<-release
+		return nil
+	})
+	time.Sleep(100 * time.Millisecond)
+	assert.False(t, workerStats(t, quiet, "gc").Stalled)
+	close(release)
+}
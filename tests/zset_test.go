@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/thirawat27/kvi/internal/pubsub"
+	"github.com/thirawat27/kvi/pkg/api"
+	"github.com/thirawat27/kvi/pkg/collection"
+	"github.com/thirawat27/kvi/pkg/config"
+	kvi_grpc "github.com/thirawat27/kvi/pkg/grpc"
+	"github.com/thirawat27/kvi/pkg/kvi"
+	"github.com/thirawat27/kvi/pkg/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestZSet(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	eng := openDisk(t, dir)
+
+	added, err := collection.ZAdd(ctx, eng, "board",
+		collection.ZMember{Member: "ann", Score: 30},
+		collection.ZMember{Member: "bob", Score: 10},
+		collection.ZMember{Member: "cat", Score: 20},
+		collection.ZMember{Member: "bob", Score: 15})
+	require.NoError(t, err)
+	assert.Equal(t, 3, added)
+	added, err = collection.ZAdd(ctx, eng, "board", collection.ZMember{Member: "dan", Score: 20})
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+
+	score, err := collection.ZIncrBy(ctx, eng, "board", "bob", 25)
+	require.NoError(t, err)
+	assert.Equal(t, 40.0, score)
+
+	all, err := collection.ZRangeByScore(ctx, eng, "board", math.Inf(-1), math.Inf(1), collection.RangeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []collection.ZMember{{Member: "cat", Score: 20}, {Member: "dan", Score: 20}, {Member: "ann", Score: 30}, {Member: "bob", Score: 40}}, all, "ties are ordered by member")
+	top, err := collection.ZRangeByScore(ctx, eng, "board", 20, 35, collection.RangeOptions{Offset: 1, Limit: 1, Reverse: true})
+	require.NoError(t, err)
+	assert.Equal(t, []collection.ZMember{{Member: "dan", Score: 20}}, top)
+	none, err := collection.ZRangeByScore(ctx, eng, "board", 50, 60, collection.RangeOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, none)
+
+	rank, err := collection.ZRank(ctx, eng, "board", "ann", false)
+	require.NoError(t, err)
+	assert.Equal(t, 2, rank)
+	rank, err = collection.ZRank(ctx, eng, "board", "bob", true)
+	require.NoError(t, err)
+	assert.Equal(t, 0, rank)
+	_, err = collection.ZRank(ctx, eng, "board", "eve", false)
+	assert.ErrorIs(t, err, types.ErrKeyNotFound)
+
+	_, err = collection.ZAdd(ctx, eng, "board", collection.ZMember{Member: "eve", Score: math.NaN()})
+	assert.ErrorIs(t, err, collection.ErrInvalidScore)
+	_, err = collection.ZIncrBy(ctx, eng, "board", "bob", math.Inf(1))
+	assert.ErrorIs(t, err, collection.ErrInvalidScore)
+	_, err = collection.ZIncrBy(ctx, eng, "board", "bob", math.MaxFloat64)
+	require.NoError(t, err)
+	_, err = collection.ZIncrBy(ctx, eng, "board", "bob", math.MaxFloat64)
+	assert.ErrorIs(t, err, collection.ErrInvalidScore, "an increment may not overflow")
+
+	// The WAL logs each resulting record, so scores survive a restart
+	require.NoError(t, eng.Close())
+	eng = openDisk(t, dir)
+	defer eng.Close()
+	all, err = collection.ZRangeByScore(ctx, eng, "board", 0, 100, collection.RangeOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []collection.ZMember{{Member: "cat", Score: 20}, {Member: "dan", Score: 20}, {Member: "ann", Score: 30}}, all)
+
+	removed, err := collection.ZRem(ctx, eng, "board", "cat", "eve")
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	removed, err = collection.ZRem(ctx, eng, "board", "ann", "bob", "dan")
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+	_, err = eng.Get(ctx, "board")
+	assert.ErrorIs(t, err, types.ErrKeyNotFound, "removing the last member deletes the sorted set")
+
+	_, err = collection.SAdd(ctx, eng, "tags", "a")
+	require.NoError(t, err)
+	_, err = collection.ZAdd(ctx, eng, "tags", collection.ZMember{Member: "a", Score: 1})
+	assert.ErrorIs(t, err, types.ErrWrongType)
+}
+
+func TestZSetConcurrentIncrBy(t *testing.T) {
+	ctx := context.Background()
+	for _, cfg := range []*config.Config{config.MemoryConfig(), config.ColumnarConfig()} {
+		t.Run(string(cfg.Mode), func(t *testing.T) {
+			eng, err := kvi.Open(cfg)
+			require.NoError(t, err)
+			defer eng.Close()
+
+			const players, rounds = 10, 20
+			var wg sync.WaitGroup
+			for p := range players {
+				for range rounds {
+					wg.Go(func() {
+						_, err := collection.ZIncrBy(ctx, eng, "board", fmt.Sprint("p", p), float64(p+1))
+						assert.NoError(t, err)
+					})
+				}
+			}
+			// Readers see a whole set, ranked as it is ordered, throughout
+			wg.Go(func() {
+				for range 50 {
+					all, err := collection.ZRangeByScore(ctx, eng, "board", math.Inf(-1), math.Inf(1), collection.RangeOptions{})
+					if !assert.NoError(t, err) {
+						return
+					}
+					for i := 1; i < len(all); i++ {
+						assert.LessOrEqual(t, all[i-1].Score, all[i].Score)
+					}
+				}
+			})
+			wg.Wait()
+
+			all, err := collection.ZRangeByScore(ctx, eng, "board", math.Inf(-1), math.Inf(1), collection.RangeOptions{})
+			require.NoError(t, err)
+			require.Len(t, all, players)
+			for i, m := range all {
+				assert.Equal(t, fmt.Sprint("p", i), m.Member)
+				assert.Equal(t, float64((i+1)*rounds), m.Score, "no increment is lost")
+				rank, err := collection.ZRank(ctx, eng, "board", m.Member, true)
+				require.NoError(t, err)
+				assert.Equal(t, players-1-i, rank)
+			}
+		})
+	}
+}
+
+func TestZSetAPI(t *testing.T) {
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	ts := httptest.NewServer(api.NewServer(eng).Handler())
+	defer ts.Close()
+
+	call := func(method, path string, body interface{}) (int, map[string]interface{}) {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, jsonBody(body))
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var out map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	status, out := call(http.MethodPost, "/api/v1/zset/zadd", map[string]interface{}{
+		"key": "b", "members": []map[string]interface{}{{"member": "x", "score": 1}, {"member": "y", "score": 2}},
+	})
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 2.0, out["added"])
+	_, out = call(http.MethodPost, "/api/v1/zset/zincrby", map[string]interface{}{"key": "b", "member": "x", "delta": 5})
+	assert.Equal(t, 6.0, out["score"])
+	_, out = call(http.MethodGet, "/api/v1/zset/zrangebyscore?key=b&min=-inf&rev=true&limit=1", nil)
+	assert.Equal(t, []interface{}{map[string]interface{}{"member": "x", "score": 6.0}}, out["members"])
+	_, out = call(http.MethodGet, "/api/v1/zset/zrank?key=b&member=y", nil)
+	assert.Equal(t, 0.0, out["rank"])
+	status, _ = call(http.MethodGet, "/api/v1/zset/zrank?key=b&member=z", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	_, out = call(http.MethodPost, "/api/v1/zset/zrem", map[string]interface{}{"key": "b", "members": []string{"y"}})
+	assert.Equal(t, 1.0, out["removed"])
+
+	status, _ = call(http.MethodGet, "/api/v1/zset/zrangebyscore?key=b&min=low", nil)
+	assert.Equal(t, http.StatusBadRequest, status)
+	status, _ = call(http.MethodPost, "/api/v1/set/sadd", map[string]interface{}{"key": "b", "members": []string{"a"}})
+	assert.Equal(t, http.StatusConflict, status)
+}
+
+func TestGrpcZSet(t *testing.T) {
+	ctx := context.Background()
+	eng, err := kvi.Open(config.MemoryConfig())
+	require.NoError(t, err)
+	defer eng.Close()
+	client := serveGrpc(t, kvi_grpc.NewGrpcServer(eng, pubsub.NewHub()))
+
+	added, err := client.ZAdd(ctx, &kvi_grpc.ZAddRequest{Key: "b", Members: []*kvi_grpc.ZMember{
+		{Member: "x", Score: 1}, {Member: "y", Score: 2}, {Member: "z", Score: 3},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), added.Added)
+	incr, err := client.ZIncrBy(ctx, &kvi_grpc.ZIncrByRequest{Key: "b", Member: "x", Delta: 2.5})
+	require.NoError(t, err)
+	assert.Equal(t, 3.5, incr.Score)
+
+	low := 2.0
+	got, err := client.ZRangeByScore(ctx, &kvi_grpc.ZRangeByScoreRequest{Key: "b", Min: &low, Reverse: true, Limit: 2})
+	require.NoError(t, err)
+	var members []string
+	for _, m := range got.Members {
+		members = append(members, m.Member)
+	}
+	assert.Equal(t, []string{"x", "z"}, members)
+
+	rank, err := client.ZRank(ctx, &kvi_grpc.ZRankRequest{Key: "b", Member: "y"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), rank.Rank)
+	removed, err := client.ZRem(ctx, &kvi_grpc.ZRemRequest{Key: "b", Members: []string{"y", "w"}})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), removed.Removed)
+
+	_, err = client.ZRank(ctx, &kvi_grpc.ZRankRequest{Key: "b", Member: "y"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.ZAdd(ctx, &kvi_grpc.ZAddRequest{Key: "b", Members: []*kvi_grpc.ZMember{{Member: "n", Score: math.NaN()}}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}